@@ -0,0 +1,174 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	myi18n "github.com/veops/oneterm/i18n"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	bufferSize = 1000
+)
+
+var (
+	events  chan *eventbus.Event
+	dropped atomic.Int64
+	// failures are times of failed connects of users within the window, alerted are times of the last alerts by keys,
+	// both are only touched by Run
+	failures    = map[string][]time.Time{}
+	alerted     = map[string]time.Time{}
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+func init() {
+	cfg := conf.Cfg.Alert
+	if cfg.Smtp.Host == "" || len(cfg.To) == 0 {
+		return
+	}
+	events = make(chan *eventbus.Event, bufferSize)
+	eventbus.Listen(func(e *eventbus.Event) {
+		if e.Type != eventbus.TYPE_COMMAND_BLOCKED && e.Type != eventbus.TYPE_CONNECT_FAILED && e.Type != eventbus.TYPE_CANARY_FAILED {
+			return
+		}
+		select {
+		case events <- e:
+		default:
+			dropped.Add(1)
+		}
+	})
+}
+
+// Run mails admins of blocked commands, of users failing to connect too many times and of canary targets failing until
+// it is stopped, it only waits if alerts are not configured
+func Run() (err error) {
+	if events == nil {
+		<-ctx.Done()
+		return
+	}
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			if n := dropped.Swap(0); n > 0 {
+				logger.L().Warn("alert buffer is full, events are dropped", zap.Int64("count", n))
+			}
+			expire(time.Now())
+		case e := <-events:
+			handle(e)
+		}
+	}
+}
+
+func Stop() {
+	defer cancel()
+}
+
+func handle(e *eventbus.Event) {
+	cfg := conf.Cfg.Alert
+	data := map[string]any{"time": e.Time}
+	switch d := e.Data.(type) {
+	case *eventbus.BlockedCommand:
+		if !due("blocked:"+d.SessionId, e.Time) {
+			return
+		}
+		// blocked commands only have session ids, others of them are of the session
+		sess := &model.Session{}
+		if err := mysql.AuditDB.Model(sess).Where("session_id = ?", d.SessionId).First(sess).Error; err != nil {
+			sess = &model.Session{SessionId: d.SessionId, UserName: d.UserName}
+		}
+		data["user"], data["asset"], data["account"], data["ip"], data["session"], data["rule"], data["cmd"] =
+			d.UserName, sess.AssetInfo, sess.AccountInfo, sess.ClientIp, d.SessionId, d.Rule, d.Cmd
+		send(myi18n.MsgAlertCommandBlockedSubject, myi18n.MsgAlertCommandBlocked, data)
+	case model.AccessLog:
+		if cfg.FailedConnects <= 0 {
+			return
+		}
+		since := e.Time.Add(-time.Minute * time.Duration(cfg.Window))
+		ts := append(lo.Filter(failures[d.UserName], func(t time.Time, _ int) bool { return t.After(since) }), e.Time)
+		failures[d.UserName] = ts
+		if len(ts) < cfg.FailedConnects || !due("connect:"+d.UserName, e.Time) {
+			return
+		}
+		asset := &model.Asset{}
+		if err := mysql.DB.Model(asset).Select("name", "ip").Where("id = ?", d.AssetId).First(asset).Error; err == nil {
+			data["asset"] = fmt.Sprintf("%s(%s)", asset.Name, asset.Ip)
+		} else {
+			data["asset"] = d.AssetId
+		}
+		data["user"], data["count"], data["minutes"], data["protocol"], data["ip"], data["reason"] =
+			d.UserName, len(ts), cfg.Window, d.Protocol, d.ClientIp, d.Reason
+		send(myi18n.MsgAlertConnectFailedSubject, myi18n.MsgAlertConnectFailed, data)
+	case *model.CanaryRun:
+		if !due(fmt.Sprintf("canary:%d:%d:%s", d.AssetId, d.AccountId, d.Protocol), e.Time) {
+			return
+		}
+		asset := &model.Asset{}
+		if err := mysql.DB.Model(asset).Select("name", "ip").Where("id = ?", d.AssetId).First(asset).Error; err == nil {
+			data["asset"] = fmt.Sprintf("%s(%s)", asset.Name, asset.Ip)
+		} else {
+			data["asset"] = d.AssetId
+		}
+		data["account"], data["protocol"], data["count"], data["stage"], data["reason"] =
+			d.AccountId, d.Protocol, d.Failures, d.Stage, d.Message
+		send(myi18n.MsgAlertCanaryFailedSubject, myi18n.MsgAlertCanaryFailed, data)
+	}
+}
+
+// due tells whether an alert of the key could be sent at t, alerts within the cooldown of the last one are dropped so
+// that admins are not flooded by a user trying again and again
+func due(key string, t time.Time) bool {
+	if last, ok := alerted[key]; ok && t.Sub(last) < time.Minute*time.Duration(conf.Cfg.Alert.Cooldown) {
+		return false
+	}
+	alerted[key] = t
+	return true
+}
+
+// expire forgets failures out of the window and alerts out of the cooldown
+func expire(now time.Time) {
+	cfg := conf.Cfg.Alert
+	since := now.Add(-time.Minute * time.Duration(cfg.Window))
+	for k, ts := range failures {
+		if ts = lo.Filter(ts, func(t time.Time, _ int) bool { return t.After(since) }); len(ts) == 0 {
+			delete(failures, k)
+		} else {
+			failures[k] = ts
+		}
+	}
+	for k, t := range alerted {
+		if now.Sub(t) >= time.Minute*time.Duration(cfg.Cooldown) {
+			delete(alerted, k)
+		}
+	}
+}
+
+func send(subject, body *i18n.Message, data map[string]any) {
+	cfg := conf.Cfg.Alert
+	if err := Mail(cfg.Smtp, cfg.To, localize(cfg.Lang, subject, data), localize(cfg.Lang, body, data)); err != nil {
+		logger.L().Warn("send alert failed", zap.String("subject", subject.ID), zap.Error(err))
+	}
+}
+
+func localize(lang string, msg *i18n.Message, data map[string]any) string {
+	lang = lo.Ternary(lang == "", "en", lang)
+	text, _ := i18n.NewLocalizer(myi18n.Bundle, lang).Localize(&i18n.LocalizeConfig{
+		DefaultMessage: msg,
+		TemplateData:   myi18n.TimeData(data, "", lang),
+	})
+	return text
+}
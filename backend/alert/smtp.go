@@ -0,0 +1,97 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/conf"
+)
+
+const (
+	TLS_STARTTLS = "starttls"
+	TLS_IMPLICIT = "tls"
+	TLS_NONE     = "none"
+)
+
+// Mail sends a plain text mail in utf-8 to the addresses, the subject is encoded by rfc 2047 so that it could be
+// of any language
+func Mail(cfg conf.SmtpConfig, to []string, subject, body string) (err error) {
+	timeout := time.Second * time.Duration(max(cfg.Timeout, 1))
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsCfg := &tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	var conn net.Conn
+	switch cfg.Tls {
+	case TLS_IMPLICIT:
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsCfg)
+	case TLS_STARTTLS, TLS_NONE, "":
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	default:
+		return fmt.Errorf("unsupported smtp tls %s", cfg.Tls)
+	}
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer c.Close()
+
+	if cfg.Tls == TLS_STARTTLS || cfg.Tls == "" {
+		if err = c.StartTLS(tlsCfg); err != nil {
+			return
+		}
+	}
+	if cfg.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return
+		}
+	}
+	from := lo.Ternary(cfg.From == "", cfg.Username, cfg.From)
+	if err = c.Mail(from); err != nil {
+		return
+	}
+	for _, t := range to {
+		if err = c.Rcpt(t); err != nil {
+			return
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(message(from, to, subject, body)); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return c.Quit()
+}
+
+func message(from string, to []string, subject, body string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(buf)
+	w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	w.Close()
+	return buf.Bytes()
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -11,26 +12,37 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/veops/oneterm/api/controller"
+	"github.com/veops/oneterm/cert"
 	"github.com/veops/oneterm/conf"
 	"github.com/veops/oneterm/docs"
 	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/proxyproto"
 )
 
 var (
 	ctx, cancel = context.WithCancel(context.Background())
 	srv         = &http.Server{}
+	// challengeSrv answers http challenges of acme and redirects others to https
+	challengeSrv = &http.Server{}
 )
 
 func RunApi() error {
 	c := controller.Controller{}
 	r := gin.New()
-	r.SetTrustedProxies([]string{"0.0.0.0/0", "::/0"})
+	if err := r.SetTrustedProxies(conf.Cfg.Proxy.TrustedProxies); err != nil {
+		logger.L().Fatal("invalid trusted proxies", zap.Error(err))
+	}
+	if len(conf.Cfg.Proxy.RemoteIPHeaders) > 0 {
+		r.RemoteIPHeaders = conf.Cfg.Proxy.RemoteIPHeaders
+	}
+	r.TrustedPlatform = conf.Cfg.Proxy.TrustedPlatform
 	r.MaxMultipartMemory = 128 << 20
 	r.Use(gin.Recovery(), ginLogger())
 
 	docs.SwaggerInfo.Title = "ONETERM API"
 	docs.SwaggerInfo.BasePath = "/api/oneterm/v1"
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/readyz", c.Readyz)
 
 	v1 := r.Group("/api/oneterm/v1", Error2Resp(), auth())
 	{
@@ -40,6 +52,8 @@ func RunApi() error {
 			account.DELETE("/:id", c.DeleteAccount)
 			account.PUT("/:id", c.UpdateAccount)
 			account.GET("", c.GetAccounts)
+			account.POST("/:id/rotate", c.RotateAccount)
+			account.GET("/compliance", c.GetAccountCompliance)
 		}
 
 		asset := v1.Group("asset")
@@ -48,6 +62,17 @@ func RunApi() error {
 			asset.DELETE("/:id", c.DeleteAsset)
 			asset.PUT("/:id", c.UpdateAsset)
 			asset.GET("", c.GetAssets)
+			asset.GET("/:id/health", c.GetAssetHealth)
+			asset.POST("/:id/health", c.CheckAssetHealth)
+			asset.GET("/:id/contacts", c.GetAssetContacts)
+		}
+
+		discovery := v1.Group("discovery")
+		{
+			discovery.GET("", c.GetDiscoveredAssets)
+			discovery.POST("/scan", c.ScanDiscovery)
+			discovery.POST("/ignore", c.IgnoreDiscoveredAssets)
+			discovery.POST("/import", c.ImportDiscoveredAssets)
 		}
 
 		node := v1.Group("node")
@@ -66,6 +91,14 @@ func RunApi() error {
 			publicKey.GET("", c.GetPublicKeys)
 		}
 
+		agentKey := v1.Group("agent_key")
+		{
+			agentKey.POST("", c.CreateAgentKey)
+			agentKey.DELETE("/:id", c.DeleteAgentKey)
+			agentKey.PUT("/:id", c.UpdateAgentKey)
+			agentKey.GET("", c.GetAgentKeys)
+		}
+
 		gateway := v1.Group("gateway")
 		{
 			gateway.POST("", c.CreateGateway)
@@ -74,6 +107,14 @@ func RunApi() error {
 			gateway.GET("", c.GetGateways)
 		}
 
+		gatewayGroup := v1.Group("gateway_group")
+		{
+			gatewayGroup.POST("", c.CreateGatewayGroup)
+			gatewayGroup.DELETE("/:id", c.DeleteGatewayGroup)
+			gatewayGroup.PUT("/:id", c.UpdateGatewayGroup)
+			gatewayGroup.GET("", c.GetGatewayGroups)
+		}
+
 		stat := v1.Group("stat")
 		{
 			stat.GET("assettype", c.StatAssetType)
@@ -82,6 +123,7 @@ func RunApi() error {
 			stat.GET("account", c.StatAccount)
 			stat.GET("asset", c.StatAsset)
 			stat.GET("rank/ofuser", c.StatRankOfUser)
+			stat.GET("heatmap", c.StatHeatmap)
 		}
 
 		command := v1.Group("command")
@@ -92,29 +134,215 @@ func RunApi() error {
 			command.GET("", c.GetCommands)
 		}
 
+		commandPolicy := v1.Group("command_policy")
+		{
+			commandPolicy.POST("", c.CreateCommandPolicy)
+			commandPolicy.DELETE("/:id", c.DeleteCommandPolicy)
+			commandPolicy.PUT("/:id", c.UpdateCommandPolicy)
+			commandPolicy.GET("", c.GetCommandPolicies)
+		}
+
+		workspace := v1.Group("workspace")
+		{
+			workspace.POST("", c.CreateWorkspace)
+			workspace.GET("", c.GetWorkspaces)
+			workspace.POST("/:id/close", c.CloseWorkspace)
+			workspace.GET("/:id/forward/:port", c.ConnectWorkspaceForward)
+		}
+
+		accessRequest := v1.Group("access_request")
+		{
+			accessRequest.POST("", c.CreateAccessRequest)
+			accessRequest.GET("", c.GetAccessRequests)
+			accessRequest.POST("/:id/approval", c.CreateAccessApproval)
+			accessRequest.POST("/:id/revoke", c.RevokeAccessRequest)
+			accessRequest.POST("/grant", c.CreateAccessGrant)
+		}
+
+		labTemplate := v1.Group("lab_template")
+		{
+			labTemplate.POST("", c.CreateLabTemplate)
+			labTemplate.DELETE("/:id", c.DeleteLabTemplate)
+			labTemplate.PUT("/:id", c.UpdateLabTemplate)
+			labTemplate.GET("", c.GetLabTemplates)
+		}
+		v1.GET("/lab", c.GetLabs)
+		r.POST("/api/oneterm/v1/lab/webhook", Error2Resp(), c.LabWebhook)
+
+		clipboardPolicy := v1.Group("clipboard_policy")
+		{
+			clipboardPolicy.POST("", c.CreateClipboardPolicy)
+			clipboardPolicy.DELETE("/:id", c.DeleteClipboardPolicy)
+			clipboardPolicy.PUT("/:id", c.UpdateClipboardPolicy)
+			clipboardPolicy.GET("", c.GetClipboardPolicies)
+		}
+		v1.GET("/clipboard_log", c.GetClipboardLogs)
+
+		watermarkPolicy := v1.Group("watermark_policy")
+		{
+			watermarkPolicy.POST("", c.CreateWatermarkPolicy)
+			watermarkPolicy.DELETE("/:id", c.DeleteWatermarkPolicy)
+			watermarkPolicy.PUT("/:id", c.UpdateWatermarkPolicy)
+			watermarkPolicy.GET("", c.GetWatermarkPolicies)
+		}
+
+		featureFlag := v1.Group("feature_flag")
+		{
+			featureFlag.POST("", c.CreateFeatureFlag)
+			featureFlag.DELETE("/:id", c.DeleteFeatureFlag)
+			featureFlag.PUT("/:id", c.UpdateFeatureFlag)
+			featureFlag.GET("", c.GetFeatureFlags)
+			featureFlag.GET("/mine", c.GetMyFeatures)
+		}
+
+		mfaPolicy := v1.Group("mfa_policy")
+		{
+			mfaPolicy.POST("", c.CreateMfaPolicy)
+			mfaPolicy.DELETE("/:id", c.DeleteMfaPolicy)
+			mfaPolicy.PUT("/:id", c.UpdateMfaPolicy)
+			mfaPolicy.GET("", c.GetMfaPolicies)
+		}
+
+		macro := v1.Group("macro")
+		{
+			macro.POST("", c.CreateMacro)
+			macro.DELETE("/:id", c.DeleteMacro)
+			macro.PUT("/:id", c.UpdateMacro)
+			macro.GET("", c.GetMacros)
+		}
+
+		preference := v1.Group("preference")
+		{
+			preference.GET("", c.GetPreference)
+			preference.PUT("", c.UpdatePreference)
+		}
+
+		maintenanceWindow := v1.Group("maintenance_window")
+		{
+			maintenanceWindow.POST("", c.CreateMaintenanceWindow)
+			maintenanceWindow.DELETE("/:id", c.DeleteMaintenanceWindow)
+			maintenanceWindow.PUT("/:id", c.UpdateMaintenanceWindow)
+			maintenanceWindow.GET("", c.GetMaintenanceWindows)
+		}
+
+		sshCa := v1.Group("ssh_ca")
+		{
+			sshCa.POST("", c.CreateSshCa)
+			sshCa.DELETE("/:id", c.DeleteSshCa)
+			sshCa.GET("", c.GetSshCas)
+			sshCa.POST("/rotate", c.RotateSshCa)
+			sshCa.GET("/public_key", c.GetSshCaPublicKeys)
+			sshCa.GET("/known_hosts", c.GetSshKnownHosts)
+			sshCa.GET("/principals/:asset_id", c.GetSshCaPrincipals)
+			sshCa.POST("/distribute/:asset_id", c.DistributeSshCa)
+		}
+
+		cloudAccount := v1.Group("cloud_account")
+		{
+			cloudAccount.POST("", c.CreateCloudAccount)
+			cloudAccount.DELETE("/:id", c.DeleteCloudAccount)
+			cloudAccount.PUT("/:id", c.UpdateCloudAccount)
+			cloudAccount.GET("", c.GetCloudAccounts)
+			cloudAccount.POST("/:id/sync", c.SyncCloudAccount)
+		}
+
+		cmdb := v1.Group("cmdb")
+		{
+			cmdb.POST("/sync", c.SyncCmdb)
+		}
+		r.POST("/api/oneterm/v1/cmdb/webhook", Error2Resp(), c.CmdbWebhook)
+
+		v1.POST("/import", c.Import)
+
+		assetWarning := v1.Group("asset_warning")
+		{
+			assetWarning.GET("", c.GetAssetWarnings)
+			assetWarning.POST("/pull", c.PullAssetWarnings)
+		}
+		r.POST("/api/oneterm/v1/asset_warning/webhook", Error2Resp(), c.AssetWarningWebhook)
+
+		stepUp := v1.Group("step_up")
+		{
+			stepUp.POST("", c.CreateStepUp)
+			stepUp.POST("/challenge", c.CreateStepUpChallenge)
+			stepUp.GET("/totp", c.NewStepUpTotp)
+			stepUp.GET("/credential", c.GetStepUpCredentials)
+			stepUp.POST("/credential", c.CreateStepUpCredential)
+			stepUp.DELETE("/credential/:id", c.DeleteStepUpCredential)
+		}
+
+		replayLog := v1.Group("replay_log")
+		{
+			replayLog.GET("", c.GetReplayLogs)
+		}
+
 		session := v1.Group("session")
 		{
 			session.GET("", c.GetSessions)
+			session.GET("/mine", c.GetMySessions)
+			session.GET("/mine/:session_id/cmd", c.GetMySessionCmds)
+			session.GET("/mine/:session_id/replay", c.GetMySessionReplay)
 			session.GET("/:session_id/cmd", c.GetSessionCmds)
 			session.GET("/option/asset", c.GetSessionOptionAsset)
 			session.GET("/option/clientip", c.GetSessionOptionClientIp)
+			session.GET("/chanstat", c.GetSessionChanStats)
+			session.GET("/inspect", c.GetOnlineSessionInspection)
 			session.GET("/replay/:session_id", c.GetSessionReplay)
+			session.GET("/:session_id/replay", c.ConnectSessionReplay)
+			session.GET("/multi/replay", c.ConnectMultiSessionReplay)
+			session.GET("/multi/events", c.GetMultiSessionReplayEvents)
+			session.POST("/:session_id/redact", c.CreateSessionRedaction)
+			session.GET("/screenshot/:session_id", c.GetSessionScreenshot)
+			session.GET("/:session_id/x11", c.GetSessionX11Captures)
+			session.GET("/x11/:id", c.GetSessionX11Capture)
+			session.GET("/:session_id/command-approval", c.GetCommandApprovals)
+			session.POST("/:session_id/command-approval", c.CreateCommandApproval)
+			session.GET("/:session_id/takeover", c.GetSessionTakeovers)
+			session.GET("/command-approval/notice", c.ConnectCommandApprovalNotice)
+			session.POST("/:session_id/review", c.CreateSessionReview)
+		}
+
+		sessionReview := v1.Group("session_review")
+		{
+			sessionReview.GET("", c.GetSessionReviews)
+			sessionReview.GET("/workload", c.GetSessionReviewWorkload)
+			sessionReview.POST("/:id/done", c.DoneSessionReview)
+			sessionReview.POST("/:id/assign", c.AssignSessionReview)
 		}
 
 		connect := v1.Group("connect")
 		{
 			connect.GET("/:asset_id/:account_id/:protocol", c.Connect)
+			connect.GET("/preflight/:asset_id/:account_id/:protocol", c.ConnectPreflight)
+			connect.GET("/options/:asset_id", c.GetConnectOptions)
 			connect.GET("/monitor/:session_id", c.ConnectMonitor)
+			connect.GET("/resume/:session_id", c.ConnectResume)
+			connect.GET("/thumbnail/:session_id", c.ConnectThumbnail)
 			connect.POST("/close/:session_id", c.ConnectClose)
+			connect.POST("/invite/:session_id", c.ConnectInvite)
+			connect.POST("/collab/:session_id", c.ConnectCollab)
+			connect.GET("/takeover/:session_id", c.ConnectTakeover)
+			connect.GET("/join/:session_id", c.ConnectJoin)
+			connect.GET("/mfa/:asset_id", c.GetConnectMfa)
+			connect.POST("/mfa/:asset_id", c.CreateConnectMfa)
+			connect.POST("/proxy/:asset_id/:account_id/:protocol", c.CreateProxyToken)
 		}
+		v1.POST("/exec/:asset_id/:account_id", c.Exec)
 
 		file := v1.Group("file")
 		{
 			file.GET("/history", c.GetFileHistory)
+			file.GET("/transfer", c.GetFileTransfers)
 			file.GET("/ls/:asset_id/:account_id", c.FileLS)
 			file.POST("/mkdir/:asset_id/:account_id", c.FileMkdir)
 			file.POST("/upload/:asset_id/:account_id", c.FileUpload)
 			file.GET("/download/:asset_id/:account_id", c.FileDownload)
+			file.DELETE("/rm/:asset_id/:account_id", c.FileRm)
+			file.GET("/session/:session_id/ls", c.SessionFileLS)
+			file.POST("/session/:session_id/mkdir", c.SessionFileMkdir)
+			file.POST("/session/:session_id/upload", c.SessionFileUpload)
+			file.GET("/session/:session_id/download", c.SessionFileDownload)
+			file.DELETE("/session/:session_id/rm", c.SessionFileRm)
 		}
 
 		config := v1.Group("config")
@@ -129,6 +357,21 @@ func RunApi() error {
 			history.GET("/type/mapping", c.GetHistoryTypeMapping)
 		}
 
+		accessLog := v1.Group("access_log")
+		{
+			accessLog.GET("", c.GetAccessLogs)
+		}
+
+		agentSignLog := v1.Group("agent_sign_log")
+		{
+			agentSignLog.GET("", c.GetAgentSignLogs)
+		}
+
+		rotationHistory := v1.Group("rotation_history")
+		{
+			rotationHistory.GET("", c.GetRotationHistories)
+		}
+
 		share := v1.Group("/share")
 		{
 			share.POST("", c.CreateShare)
@@ -137,17 +380,79 @@ func RunApi() error {
 		}
 		r.GET("/api/oneterm/v1/share/connect/:uuid", Error2Resp(), c.ConnectShare)
 
+		// status pages embed the status without login if it is public
+		if conf.Cfg.Status.Public {
+			r.GET("/api/oneterm/v1/status", Error2Resp(), c.GetStatus)
+		} else {
+			v1.GET("/status", c.GetStatus)
+		}
+
 		authorization := v1.Group("/authorization")
 		{
 			authorization.POST("", c.UpsertAuthorization)
 			authorization.DELETE("/:id", c.DeleteAccount)
 			authorization.GET("", c.GetAuthorizations)
 		}
+
+		webhook := v1.Group("/webhook")
+		{
+			webhook.POST("", c.CreateWebhook)
+			webhook.DELETE("/:id", c.DeleteWebhook)
+			webhook.PUT("/:id", c.UpdateWebhook)
+			webhook.GET("", c.GetWebhooks)
+			webhook.GET("/delivery", c.GetWebhookDeliveries)
+			webhook.POST("/delivery/:id/redeliver", c.RedeliverWebhook)
+		}
+
+		notification := v1.Group("/notification")
+		{
+			notification.POST("", c.CreateNotification)
+			notification.DELETE("/:id", c.DeleteNotification)
+			notification.PUT("/:id", c.UpdateNotification)
+			notification.GET("", c.GetNotifications)
+			notification.POST("/:id/test", c.TestNotification)
+		}
+
+		canary := v1.Group("/canary")
+		{
+			canary.GET("", c.GetCanaryRuns)
+			canary.POST("/run", c.RunCanaries)
+		}
+
+		usage := v1.Group("/usage")
+		{
+			usage.GET("", c.GetUsage)
+			usage.GET("/user", c.GetUserUsage)
+			usage.GET("/export", c.ExportUsage)
+		}
 	}
 
-	srv.Addr = fmt.Sprintf("%s:%d", conf.Cfg.Http.Host, conf.Cfg.Http.Port)
+	srv.Addr = net.JoinHostPort(conf.Cfg.Http.Host, fmt.Sprint(conf.Cfg.Http.Port))
 	srv.Handler = r
-	err := srv.ListenAndServe()
+	tlsCfg, err := cert.TLSConfig()
+	if err != nil {
+		logger.L().Fatal("init tls failed", zap.Error(err))
+	}
+	l, err := proxyproto.Listen(proxyproto.LISTENER_HTTP, srv.Addr)
+	if err != nil {
+		logger.L().Fatal("start http failed", zap.Error(err))
+	}
+	if tlsCfg == nil {
+		err = srv.Serve(l)
+	} else {
+		srv.TLSConfig = tlsCfg
+		go cert.Run()
+		if h := cert.HttpHandler(); h != nil && conf.Cfg.Http.Tls.Acme.HttpPort > 0 {
+			challengeSrv.Addr = net.JoinHostPort(conf.Cfg.Http.Host, fmt.Sprint(conf.Cfg.Http.Tls.Acme.HttpPort))
+			challengeSrv.Handler = h
+			go func() {
+				if err := challengeSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.L().Error("start http of acme challenges failed", zap.Error(err))
+				}
+			}()
+		}
+		err = srv.ServeTLS(l, "", "")
+	}
 	if err != nil {
 		logger.L().Fatal("start http failed", zap.Error(err))
 	}
@@ -156,5 +461,8 @@ func RunApi() error {
 
 func StopApi() {
 	defer cancel()
+	controller.CloseOnShutdown()
+	cert.Stop()
+	challengeSrv.Shutdown(ctx)
 	srv.Shutdown(ctx)
 }
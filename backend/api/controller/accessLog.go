@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/tracing"
+)
+
+var (
+	err2AccessCode = map[int]int{
+		ErrInvalidArgument: model.ACCESSCODE_INVALID,
+		ErrAccessTime:      model.ACCESSCODE_TIME,
+		ErrUnauthorized:    model.ACCESSCODE_UNAUTHORIZED,
+		ErrNoPerm:          model.ACCESSCODE_UNAUTHORIZED,
+		ErrNoAccess:        model.ACCESSCODE_UNAUTHORIZED,
+		ErrConnectServer:   model.ACCESSCODE_CONNECT,
+	}
+)
+
+// saveAccessLog records a denied or failed connect attempt
+func saveAccessLog(ctx *gin.Context, code int, err error) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if code == 0 {
+		code = model.ACCESSCODE_OTHER
+		ae := &ApiError{}
+		if errors.As(err, &ae) {
+			if c, ok := err2AccessCode[ae.Code]; ok {
+				code = c
+			}
+		}
+	}
+	sessionType := ctx.GetInt("sessionType")
+	l := &model.AccessLog{
+		Uid:         currentUser.GetUid(),
+		UserName:    currentUser.GetUserName(),
+		AssetId:     cast.ToInt(ctx.Param("asset_id")),
+		AccountId:   cast.ToInt(ctx.Param("account_id")),
+		Protocol:    ctx.Param("protocol"),
+		SessionType: sessionType,
+		ClientIp:    ctx.ClientIP(),
+		Code:        code,
+		Reason:      err.Error(),
+	}
+	if span := tracing.FromContext(ctx); span != nil {
+		l.TraceId = span.TraceId
+	}
+	if sessionType == model.SESSIONTYPE_CLIENT {
+		l.ClientIp = ctx.RemoteIP()
+	}
+	if err := mysql.AuditDB.Model(l).Create(l).Error; err != nil {
+		logger.L().Error("save access log failed", zap.Error(err))
+	}
+}
+
+// GetAccessLogs godoc
+//
+//	@Tags		access_log
+//	@Param		page_index	query		int		true	"page_index"
+//	@Param		page_size	query		int		true	"page_size"
+//	@Param		search		query		string	false	"search"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Param		uid			query		int		false	"uid"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		account_id	query		int		false	"account id"
+//	@Param		client_ip	query		string	false	"client_ip"
+//	@Param		code		query		int		false	"code"
+//	@Param		protocol	query		string	false	"protocol"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.AccessLog}}
+//	@Router		/access_log [get]
+func (c *Controller) GetAccessLogs(ctx *gin.Context) {
+	db := mysql.AuditDB.Model(model.DefaultAccessLog)
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !acl.IsAdmin(currentUser) {
+		db = db.Where("uid = ?", currentUser.Uid)
+	}
+	db = filterSearch(ctx, db, "user_name", "reason")
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = filterEqual(ctx, db, "uid", "asset_id", "account_id", "client_ip", "code", "protocol")
+
+	doGet[*model.AccessLog](ctx, false, db, "")
+}
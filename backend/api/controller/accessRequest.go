@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/approval"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/lab"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+type accessRequestReq struct {
+	AssetId   int `json:"asset_id" binding:"required_without=TemplateId"`
+	AccountId int `json:"account_id" binding:"required_without=TemplateId"`
+	// TemplateId requests a lab provisioned of the template instead of an existing asset
+	TemplateId int `json:"template_id"`
+	// Duration of the access after approval, unit is minute, at most a week
+	Duration int    `json:"duration" binding:"required,min=1,max=10080"`
+	Reason   string `json:"reason" binding:"required"`
+}
+
+type accessGrantReq struct {
+	Uid       int    `json:"uid" binding:"required"`
+	UserName  string `json:"user_name" binding:"required"`
+	AssetId   int    `json:"asset_id" binding:"required"`
+	AccountId int    `json:"account_id" binding:"required"`
+	// Hours of the access, at most 30 days
+	Hours  int    `json:"hours" binding:"required,min=1,max=720"`
+	Reason string `json:"reason"`
+}
+
+type accessApprovalReq struct {
+	Approve bool   `json:"approve"`
+	Comment string `json:"comment"`
+}
+
+// AccessRequestNotice is sent to approvers like CommandApprovalNotice
+type AccessRequestNotice struct {
+	AccessRequest *model.AccessRequest `json:"access_request"`
+	Context       *approval.Context    `json:"context"`
+}
+
+// CreateAccessRequest godoc
+//
+//	@Tags		access_request
+//	@Param		request	body		accessRequestReq	true	"asset and account or lab template, duration and reason"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/access_request [post]
+func (c *Controller) CreateAccessRequest(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	req := &accessRequestReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if req.TemplateId > 0 {
+		tpl := &model.LabTemplate{}
+		if err := mysql.DB.Model(tpl).Where("id = ? AND enable = ?", req.TemplateId, true).First(tpl).Error; err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+			return
+		}
+		req.AssetId, req.AccountId = 0, tpl.AccountId
+	} else if !checkAssetAccount(ctx, req.AssetId, req.AccountId) {
+		return
+	}
+
+	ar := &model.AccessRequest{
+		Uid:        currentUser.GetUid(),
+		UserName:   currentUser.GetUserName(),
+		AssetId:    req.AssetId,
+		AccountId:  req.AccountId,
+		TemplateId: req.TemplateId,
+		Duration:   req.Duration,
+		Reason:     req.Reason,
+		Status:     model.APPROVAL_STATUS_PENDING,
+	}
+	if err := mysql.DB.Create(ar).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	notice := &AccessRequestNotice{AccessRequest: &model.AccessRequest{}}
+	*notice.AccessRequest = *ar
+	approval.BuildContextAsync(ar.Uid, ar.AssetId, func(c *approval.Context) {
+		notice.Context = c
+		approval.Notify(notice)
+	})
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(map[string]any{"id": ar.Id}))
+}
+
+// CreateAccessGrant godoc
+//
+//	@Tags		access_request
+//	@Param		grant	body		accessGrantReq	true	"user, asset, account and hours"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/access_request/grant [post]
+func (c *Controller) CreateAccessGrant(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "grant access") {
+		return
+	}
+
+	req := &accessGrantReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if !checkAssetAccount(ctx, req.AssetId, req.AccountId) {
+		return
+	}
+
+	ar := &model.AccessRequest{
+		Uid:        req.Uid,
+		UserName:   req.UserName,
+		AssetId:    req.AssetId,
+		AccountId:  req.AccountId,
+		Duration:   req.Hours * 60,
+		Reason:     req.Reason,
+		Status:     model.APPROVAL_STATUS_APPROVED,
+		ApproverId: currentUser.GetUid(),
+		Approver:   currentUser.GetUserName(),
+		End:        lo.ToPtr(time.Now().Add(time.Hour * time.Duration(req.Hours))),
+	}
+	if err := mysql.DB.Create(ar).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	logger.L().Info("access granted", zap.Int("id", ar.Id), zap.String("admin", ar.Approver), zap.String("user", ar.UserName),
+		zap.Int("assetId", ar.AssetId), zap.Int("accountId", ar.AccountId), zap.Timep("end", ar.End))
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(map[string]any{"id": ar.Id}))
+}
+
+// RevokeAccessRequest godoc
+//
+//	@Tags		access_request
+//	@Param		id	path		int	true	"access request id"
+//	@Success	200	{object}	HttpResponse	"sessions relying on it are closed"
+//	@Router		/access_request/:id/revoke [post]
+func (c *Controller) RevokeAccessRequest(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "revoke access") {
+		return
+	}
+
+	id, now := cast.ToInt(ctx.Param("id")), time.Now()
+	res := mysql.DB.Model(model.DefaultAccessRequest).
+		Where("id = ? AND status = ? AND `end` > ?", id, model.APPROVAL_STATUS_APPROVED, now).
+		Updates(map[string]any{"end": now, "revoker": currentUser.GetUserName(), "revoked_at": now})
+	if res.Error != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": res.Error}})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("access request %d is not in effect", id)}})
+		return
+	}
+	n := gsession.CloseSessions(func(s *gsession.Session) bool { return s.AccessRequestId == id }, currentUser.GetUserName())
+	logger.L().Info("access revoked", zap.Int("id", id), zap.String("admin", currentUser.GetUserName()), zap.Int("closed", n))
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// CreateAccessApproval godoc
+//
+//	@Tags		access_request
+//	@Param		id			path		int					true	"access request id"
+//	@Param		approval	body		accessApprovalReq	true	"approve or deny"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/access_request/:id/approval [post]
+func (c *Controller) CreateAccessApproval(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "approve access request") {
+		return
+	}
+
+	req := &accessApprovalReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	ar := &model.AccessRequest{}
+	if err := mysql.DB.Model(ar).Where("id = ?", cast.ToInt(ctx.Param("id"))).First(ar).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if ar.Uid == currentUser.GetUid() {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "approve own access request"}})
+		return
+	}
+
+	ar.Status = map[bool]int{true: model.APPROVAL_STATUS_APPROVED, false: model.APPROVAL_STATUS_REJECTED}[req.Approve]
+	ar.ApproverId, ar.Approver, ar.Comment = currentUser.GetUid(), currentUser.GetUserName(), req.Comment
+	if req.Approve {
+		ar.End = lo.ToPtr(time.Now().Add(time.Minute * time.Duration(ar.Duration)))
+	}
+	res := mysql.DB.Model(ar).
+		Where("id = ? AND status = ?", ar.Id, model.APPROVAL_STATUS_PENDING).
+		Select("status", "approver_id", "approver", "comment", "end").
+		Updates(ar)
+	if res.Error != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": res.Error}})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("access request %d is not pending", ar.Id)}})
+		return
+	}
+	if req.Approve && ar.TemplateId > 0 {
+		go func() {
+			if err := lab.Provision(ar); err != nil {
+				logger.L().Warn("provision lab failed", zap.Int("accessRequestId", ar.Id), zap.Error(err))
+			}
+		}()
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// GetAccessRequests godoc
+//
+//	@Tags		access_request
+//	@Param		page_index	query		int	true	"page index"
+//	@Param		page_size	query		int	true	"page size"
+//	@Param		id			query		int	false	"access request id"
+//	@Param		uid			query		int	false	"requester, admins only"
+//	@Param		asset_id	query		int	false	"asset id"
+//	@Param		status		query		int	false	"1 pending, 2 approved, 3 rejected"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.AccessRequest}}
+//	@Router		/access_request [get]
+func (c *Controller) GetAccessRequests(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.DB.Model(model.DefaultAccessRequest)
+	db = filterEqual(ctx, db, "id", "asset_id", "status")
+	if acl.IsAdmin(currentUser) {
+		db = filterEqual(ctx, db, "uid")
+	} else {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+	db = db.Order("id DESC")
+
+	doGet[*model.AccessRequest](ctx, false, db, "")
+}
+
+func checkAssetAccount(ctx *gin.Context, assetId, accountId int) bool {
+	asset := &model.Asset{}
+	if err := mysql.DB.Model(asset).Where("id = ?", assetId).First(asset).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return false
+	}
+	if _, ok := asset.Authorization[accountId]; !ok {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("account %d is not of asset %d", accountId, assetId)}})
+		return false
+	}
+	return true
+}
+
+// hasAccessGrant tells whether the user has an approved access request in effect on the account of the asset,
+// the session ends when the access does
+func hasAccessGrant(uid int, sess *gsession.Session) bool {
+	ar := &model.AccessRequest{}
+	if err := mysql.DB.Model(ar).
+		Where("uid = ? AND asset_id = ? AND account_id = ? AND status = ? AND `end` > ?", uid, sess.AssetId, sess.AccountId, model.APPROVAL_STATUS_APPROVED, time.Now()).
+		Order("`end` DESC").
+		First(ar).Error; err != nil {
+		return false
+	}
+	sess.AccessRequestId, sess.AccessEnd = ar.Id, *ar.End
+	return true
+}
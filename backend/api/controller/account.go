@@ -2,8 +2,10 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
@@ -13,14 +15,27 @@ import (
 
 	"github.com/veops/oneterm/acl"
 	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/credential"
 	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/docker"
+	"github.com/veops/oneterm/k8s"
 	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/rotation"
 	"github.com/veops/oneterm/util"
 )
 
 var (
 	accountPreHooks = []preHook[*model.Account]{
 		func(ctx *gin.Context, data *model.Account) {
+			// secrets of provider are checked when they are fetched
+			if data.Credential.Provider != "" {
+				if err := credential.Validate(&data.Credential); err != nil {
+					ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+					return
+				}
+				credential.Forget(&data.Credential)
+				return
+			}
 			if data.AccountType == model.AUTHMETHOD_PUBLICKEY {
 				if data.Phrase == "" {
 					_, err := ssh.ParsePrivateKey([]byte(data.Pk))
@@ -36,6 +51,56 @@ var (
 					}
 				}
 			}
+			if data.AccountType == model.AUTHMETHOD_SSHKEYCERT {
+				// the certificate is issued by the active ssh ca if it is not given
+				if data.Cert == "" {
+					if err := util.IssueSshKeyCert(data); err != nil {
+						ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+						return
+					}
+				} else if _, cert, err := util.ParseSshKeyCert(data); err != nil {
+					ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+					return
+				} else {
+					expiredAt := time.Unix(int64(cert.ValidBefore), 0)
+					data.CertExpiredAt = &expiredAt
+				}
+			}
+			if data.AccountType == model.AUTHMETHOD_KUBECONFIG {
+				if _, err := k8s.ParseKubeconfig(data.Pk); err != nil {
+					ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+					return
+				}
+			}
+			if data.AccountType == model.AUTHMETHOD_TLSCERT {
+				if _, err := docker.ParseTLS(data.Pk); err != nil {
+					ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+					return
+				}
+			}
+		},
+		func(ctx *gin.Context, data *model.Account) {
+			if data.Credential.Provider != "" || data.AccountType != model.AUTHMETHOD_PASSWORD {
+				return
+			}
+			// passwords kept by updates are left to the compliance report, so that new policies do not block editing
+			account := *data
+			account.Id = cast.ToInt(ctx.Param("id"))
+			if account.Id > 0 {
+				old := &model.Account{}
+				if err := mysql.DB.Model(old).Select("password").Where("id = ?", account.Id).First(old).Error; err == nil && util.DecryptAES(old.Password) == data.Password {
+					return
+				}
+			}
+			policies, _, err := rotation.Policies([]*model.Account{&account})
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+				return
+			}
+			if violations := policies[account.Id].Violations(data.Password); len(violations) > 0 {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Errorf("password violates %s of the policy", strings.Join(violations, ", "))}})
+				return
+			}
 		},
 		func(ctx *gin.Context, data *model.Account) {
 			data.Password = util.EncryptAES(data.Password)
@@ -178,3 +243,22 @@ func GetAccountIdsByAuthorization(ctx *gin.Context) (ids []int, err error) {
 
 	return
 }
+
+// GetAccountCompliance godoc
+//
+//	@Tags		account
+//	@Success	200	{object}	HttpResponse{data=[]model.PolicyCompliance}
+//	@Router		/account/compliance [get]
+func (c *Controller) GetAccountCompliance(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get account compliance") {
+		return
+	}
+
+	res, err := rotation.Compliance()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
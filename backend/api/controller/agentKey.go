@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/sshagent"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	agentKeyPreHooks = []preHook[*model.AgentKey]{
+		func(ctx *gin.Context, data *model.AgentKey) {
+			currentUser, _ := acl.GetSessionFromCtx(ctx)
+			data.Uid = currentUser.GetUid()
+			data.UserName = currentUser.GetUserName()
+
+			// keys are never returned, so the saved one is kept if it is not given on update
+			if id, ok := ctx.Params.Get("id"); ok {
+				old := &model.AgentKey{}
+				if err := mysql.DB.Model(old).Where("id = ?", cast.ToInt(id)).First(old).Error; err != nil || old.Uid != data.Uid {
+					ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": acl.WRITE}})
+					return
+				}
+				if data.Pk == "" {
+					data.Pk, data.Phrase, data.PublicKey, data.Fingerprint = old.Pk, old.Phrase, old.PublicKey, old.Fingerprint
+					return
+				}
+			}
+
+			signer, err := sshagent.ParseSigner(data.Pk, data.Phrase)
+			if err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrWrongPvk, Data: nil})
+				return
+			}
+			data.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+			data.Fingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+			data.Pk = util.EncryptAES(data.Pk)
+			data.Phrase = util.EncryptAES(data.Phrase)
+		},
+	}
+	agentKeyPostHooks = []postHook[*model.AgentKey]{
+		func(ctx *gin.Context, data []*model.AgentKey) {
+			for _, d := range data {
+				d.Pk, d.Phrase = "", ""
+			}
+		},
+	}
+	agentKeyDcs = []deleteCheck{
+		func(ctx *gin.Context, id int) {
+			currentUser, _ := acl.GetSessionFromCtx(ctx)
+			cnt := int64(0)
+			mysql.DB.Model(model.DefaultAgentKey).Where("id = ? AND uid = ?", id, currentUser.GetUid()).Count(&cnt)
+			if cnt == 0 {
+				ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": acl.DELETE}})
+			}
+		},
+	}
+)
+
+// CreateAgentKey godoc
+//
+//	@Tags		agent_key
+//	@Param		key	body		model.AgentKey	true	"agent key"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/agent_key [post]
+func (c *Controller) CreateAgentKey(ctx *gin.Context) {
+	doCreate(ctx, false, &model.AgentKey{}, "", agentKeyPreHooks...)
+}
+
+// DeleteAgentKey godoc
+//
+//	@Tags		agent_key
+//	@Param		id	path		int	true	"agent key id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/agent_key/:id [delete]
+func (c *Controller) DeleteAgentKey(ctx *gin.Context) {
+	doDelete(ctx, false, &model.AgentKey{}, "", agentKeyDcs...)
+}
+
+// UpdateAgentKey godoc
+//
+//	@Tags		agent_key
+//	@Param		id	path		int				true	"agent key id"
+//	@Param		key	body		model.AgentKey	true	"agent key, the saved key is kept if pk is empty"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/agent_key/:id [put]
+func (c *Controller) UpdateAgentKey(ctx *gin.Context) {
+	doUpdate(ctx, false, &model.AgentKey{}, "", agentKeyPreHooks...)
+}
+
+// GetAgentKeys godoc
+//
+//	@Tags		agent_key
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name, comment or fingerprint"
+//	@Param		id			query		int		false	"agent key id"
+//	@Param		enable		query		int		false	"agent key enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.AgentKey}}
+//	@Router		/agent_key [get]
+func (c *Controller) GetAgentKeys(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.DB.Model(&model.AgentKey{})
+	db = filterSearch(ctx, db, "name", "comment", "fingerprint")
+	db = filterEqual(ctx, db, "id", "enable")
+	db = db.Where("uid = ?", currentUser.GetUid())
+
+	doGet(ctx, false, db, "", agentKeyPostHooks...)
+}
+
+// GetAgentSignLogs godoc
+//
+//	@Tags		agent_sign_log
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Param		session_id	query		string	false	"session id"
+//	@Param		uid			query		int		false	"uid"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		key_id		query		int		false	"agent key id"
+//	@Param		allowed		query		int		false	"allowed or denied"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.AgentSignLog}}
+//	@Router		/agent_sign_log [get]
+func (c *Controller) GetAgentSignLogs(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.AuditDB.Model(model.DefaultAgentSignLog)
+	if !acl.IsAdmin(currentUser) {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = filterEqual(ctx, db, "session_id", "uid", "asset_id", "key_id", "allowed")
+
+	doGet[*model.AgentSignLog](ctx, false, db, "")
+}
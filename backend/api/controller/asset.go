@@ -17,6 +17,7 @@ import (
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
 	"github.com/veops/oneterm/schedule"
+	"github.com/veops/oneterm/usage"
 	"github.com/veops/oneterm/util"
 )
 
@@ -36,8 +37,21 @@ var (
 				data.Authorization = make(model.Map[int, model.Slice[int]])
 			}
 		},
+		func(ctx *gin.Context, data *model.Asset) {
+			for _, c := range append(append([]model.Contact{}, data.Owners...), data.Contacts...) {
+				if (c.Type != model.CONTACT_TYPE_USER && c.Type != model.CONTACT_TYPE_ROLE) || c.Id <= 0 {
+					ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": fmt.Sprintf("invalid contact %s %d", c.Type, c.Id)}})
+					return
+				}
+			}
+		},
+		func(ctx *gin.Context, data *model.Asset) {
+			if err := usage.CheckAsset(1); err != nil {
+				ctx.AbortWithError(http.StatusForbidden, licenseError(err))
+			}
+		},
 	}
-	assetPostHooks = []postHook[*model.Asset]{assetPostHookCount, assetPostHookAuth}
+	assetPostHooks = []postHook[*model.Asset]{assetPostHookCount, assetPostHookAuth, assetPostHookWarning}
 )
 
 // CreateAsset godoc
@@ -110,7 +124,7 @@ func (c *Controller) GetAssets(ctx *gin.Context) {
 	}
 
 	if info {
-		db = db.Select("id", "parent_id", "name", "ip", "protocols", "connectable", "authorization")
+		db = db.Select("id", "parent_id", "name", "ip", "protocols", "connectable", "authorization", "health_status", "health_latency", "health_checked_at")
 
 		if !acl.IsAdmin(currentUser) {
 			ids, err := GetAssetIdsByAuthorization(ctx)
@@ -127,6 +141,36 @@ func (c *Controller) GetAssets(ctx *gin.Context) {
 	doGet(ctx, !info, db, conf.RESOURCE_ASSET, assetPostHooks...)
 }
 
+// AssetContacts are whom users ask for access to the asset
+type AssetContacts struct {
+	AssetId  int             `json:"asset_id"`
+	Name     string          `json:"name"`
+	Owners   []model.Contact `json:"owners"`
+	Contacts []model.Contact `json:"contacts"`
+}
+
+// GetAssetContacts godoc
+//
+//	@Tags		asset
+//	@Param		id	path		int	true	"asset id"
+//	@Success	200	{object}	HttpResponse{data=AssetContacts}
+//	@Router		/asset/:id/contacts [get]
+func (c *Controller) GetAssetContacts(ctx *gin.Context) {
+	// users denied are the ones asking, so no permission of the asset is needed
+	asset := &model.Asset{}
+	if err := mysql.DB.Model(asset).Select("id", "name", "owners", "contacts").Where("id = ?", cast.ToInt(ctx.Param("id"))).First(asset).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(&AssetContacts{
+		AssetId:  asset.Id,
+		Name:     asset.Name,
+		Owners:   asset.Owners,
+		Contacts: asset.Contacts,
+	}))
+}
+
 func assetPostHookCount(ctx *gin.Context, data []*model.Asset) {
 	nodes, err := util.GetAllFromCacheDb(ctx, model.DefaultNode)
 	if err != nil {
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/warning"
+)
+
+// GetAssetWarnings godoc
+//
+//	@Tags		asset_warning
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"title or external id"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		source		query		string	false	"source"
+//	@Param		type		query		string	false	"incident or vulnerability"
+//	@Param		severity	query		string	false	"low, medium, high or critical"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.AssetWarning}}
+//	@Router		/asset_warning [get]
+func (c *Controller) GetAssetWarnings(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get asset warning") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultAssetWarning)
+	db = filterSearch(ctx, db, "title", "external_id")
+	db = filterEqual(ctx, db, "asset_id", "source", "type", "severity")
+
+	doGet[*model.AssetWarning](ctx, false, db, "")
+}
+
+// PullAssetWarnings godoc
+//
+//	@Tags		asset_warning
+//	@Success	200	{object}	HttpResponse{data=int}	"number of open warnings"
+//	@Router		/asset_warning/pull [post]
+func (c *Controller) PullAssetWarnings(ctx *gin.Context) {
+	if !checkAdmin(ctx, "pull asset warning") {
+		return
+	}
+
+	n, err := warning.Pull()
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(n))
+}
+
+// AssetWarningWebhook godoc
+//
+//	@Tags		asset_warning
+//	@Param		X-Oneterm-Token	header		string			true	"webhook token in config"
+//	@Param		report			body		warning.Report	true	"warnings of assets, resolved ones are removed"
+//	@Success	200				{object}	HttpResponse{data=int}	"number of open warnings"
+//	@Router		/asset_warning/webhook [post]
+func (c *Controller) AssetWarningWebhook(ctx *gin.Context) {
+	token := conf.Cfg.Warning.WebhookToken
+	if token == "" || subtle.ConstantTimeCompare([]byte(ctx.GetHeader("X-Oneterm-Token")), []byte(token)) != 1 {
+		ctx.AbortWithError(http.StatusUnauthorized, &ApiError{Code: ErrUnauthorized, Data: map[string]any{"err": fmt.Errorf("invalid webhook token")}})
+		return
+	}
+
+	r := &warning.Report{}
+	if err := ctx.ShouldBindBodyWithJSON(r); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	n, err := warning.Apply(ctx, r)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(n))
+}
+
+// assetPostHookWarning attaches open warnings so they are shown before connecting
+func assetPostHookWarning(ctx *gin.Context, data []*model.Asset) {
+	warnings, err := warning.Get(ctx, lo.Map(data, func(a *model.Asset, _ int) int { return a.Id })...)
+	if err != nil {
+		return
+	}
+	for _, a := range data {
+		a.Warnings = warnings[a.Id]
+	}
+}
+
+// needConfirm returns titles of warnings of the asset which connecting needs a confirmation for
+func needConfirm(ctx *gin.Context, assetId int) (titles []string, err error) {
+	severity := conf.Cfg.Warning.Confirm
+	if severity == "" {
+		return
+	}
+	warnings, err := warning.Get(ctx, assetId)
+	if err != nil {
+		return
+	}
+	titles = lo.FilterMap(warnings[assetId], func(w *model.AssetWarning, _ int) (string, bool) {
+		return fmt.Sprintf("[%s] %s", w.Severity, w.Title), w.AtLeast(severity)
+	})
+	return
+}
@@ -376,6 +376,12 @@ func getAuthorizationIds(ctx *gin.Context) (authIds []*model.AuthorizationIds, e
 
 func hasAuthorization(ctx *gin.Context, sess *gsession.Session) (ok bool) {
 	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	// approved access requests are temporary authorizations
+	defer func() {
+		if !ok {
+			ok = hasAccessGrant(currentUser.GetUid(), sess)
+		}
+	}()
 
 	if sess.ShareId != 0 {
 		return true
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/canary"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+// GetCanaryRuns godoc
+//
+//	@Tags		canary
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		protocol	query		string	false	"protocol"
+//	@Param		success		query		bool	false	"success"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.CanaryRun}}
+//	@Router		/canary [get]
+func (c *Controller) GetCanaryRuns(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get canary runs") {
+		return
+	}
+
+	db := mysql.AuditDB.Model(model.DefaultCanaryRun)
+	db = filterEqual(ctx, db, "asset_id", "protocol")
+	if q, ok := ctx.GetQuery("success"); ok {
+		db = db.Where("success = ?", cast.ToBool(q))
+	}
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = db.Order("id DESC")
+
+	doGet[*model.CanaryRun](ctx, false, db, "")
+}
+
+// RunCanaries godoc
+//
+//	@Tags		canary
+//	@Success	200	{object}	HttpResponse{data=[]model.CanaryRun}
+//	@Router		/canary/run [post]
+func (c *Controller) RunCanaries(ctx *gin.Context) {
+	if !checkAdmin(ctx, "run canaries") {
+		return
+	}
+
+	runs, err := canary.Run()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(runs))
+}
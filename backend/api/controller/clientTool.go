@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/docker"
+	"github.com/veops/oneterm/feature"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/util"
+)
+
+// hasClientTool tells whether sessions of protocol run its client tool in a container,
+// the client_tool flag rolls it out to users gradually
+func hasClientTool(protocol string, uid, rid int) bool {
+	cfg := conf.Cfg.ClientTool
+	return cfg.Enable && cfg.Images[protocol] != "" && feature.On("client_tool", uid, rid, true)
+}
+
+// clientToolSpec returns the container running the client of protocol, passwords are passed by the environment
+func clientToolSpec(protocol, ip string, port int, account *model.Account) (spec *docker.Spec, err error) {
+	cfg := conf.Cfg.ClientTool
+	spec = &docker.Spec{Image: cfg.Images[protocol], Network: cfg.Network}
+	host, p := ip, cast.ToString(port)
+	switch protocol {
+	case "mysql":
+		spec.Command = []string{"mysql", "-h", host, "-P", p, "-u", account.Account}
+		spec.Env = []string{"MYSQL_PWD=" + account.Password}
+	case "postgresql":
+		spec.Command = []string{"psql", "-h", host, "-p", p, "-U", account.Account}
+		spec.Env = []string{"PGPASSWORD=" + account.Password}
+	case "redis":
+		spec.Command = []string{"redis-cli", "-h", host, "-p", p}
+		if account.Account != "" && account.Account != "default" {
+			spec.Command = append(spec.Command, "--user", account.Account)
+		}
+		spec.Env = []string{"REDISCLI_AUTH=" + account.Password}
+	default:
+		return nil, fmt.Errorf("no client tool of %s", protocol)
+	}
+	return
+}
+
+// connectClientTool runs the client of the protocol in an ephemeral container, so that no client is installed on the bastion
+// and credentials stay in the container of the session
+func connectClientTool(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
+	w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
+	chs := sess.Chans
+	defer func() {
+		ggateway.GetGatewayManager().Close(sess.SessionId)
+		if err != nil {
+			chs.ErrChan <- err
+		}
+	}()
+
+	protocol := strings.Split(sess.Protocol, ":")[0]
+	ip, port, err := util.Proxy(false, sess.SessionId, protocol, asset, gateway)
+	if err != nil {
+		return
+	}
+	spec, err := clientToolSpec(protocol, ip, port, account)
+	if err != nil {
+		return
+	}
+	spec.Labels = map[string]string{"oneterm.session": sess.SessionId}
+	dial, host, err := docker.DialEngine(conf.Cfg.ClientTool.Docker)
+	if err != nil {
+		return
+	}
+
+	cli, err := docker.Run(dial, host, spec, w, h)
+	if err != nil {
+		logger.L().Error("run client tool failed", zap.String("sessionId", sess.SessionId), zap.String("image", spec.Image), zap.Error(err))
+		return
+	}
+	defer cli.Close()
+
+	chs.ErrChan <- err
+
+	pipeDocker(sess, cli)
+
+	return
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/api/guacd"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+const (
+	// clipboardRecordMax is the most bytes of content kept by a clipboard log
+	clipboardRecordMax = 65535
+)
+
+var (
+	clipboardPolicyPreHooks = []preHook[*model.ClipboardPolicy]{
+		func(ctx *gin.Context, data *model.ClipboardPolicy) {
+			if data.MaxSize < 0 {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "max size must not be negative"}})
+			}
+		},
+	}
+)
+
+// CreateClipboardPolicy godoc
+//
+//	@Tags		clipboard_policy
+//	@Param		policy	body		model.ClipboardPolicy	true	"clipboard policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/clipboard_policy [post]
+func (c *Controller) CreateClipboardPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create clipboard policy") {
+		return
+	}
+	doCreate(ctx, false, &model.ClipboardPolicy{}, "", clipboardPolicyPreHooks...)
+}
+
+// DeleteClipboardPolicy godoc
+//
+//	@Tags		clipboard_policy
+//	@Param		id	path		int	true	"clipboard policy id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/clipboard_policy/:id [delete]
+func (c *Controller) DeleteClipboardPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete clipboard policy") {
+		return
+	}
+	doDelete(ctx, false, &model.ClipboardPolicy{}, "")
+}
+
+// UpdateClipboardPolicy godoc
+//
+//	@Tags		clipboard_policy
+//	@Param		id		path		int						true	"clipboard policy id"
+//	@Param		policy	body		model.ClipboardPolicy	true	"clipboard policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/clipboard_policy/:id [put]
+func (c *Controller) UpdateClipboardPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update clipboard policy") {
+		return
+	}
+	doUpdate(ctx, false, &model.ClipboardPolicy{}, "", clipboardPolicyPreHooks...)
+}
+
+// GetClipboardPolicies godoc
+//
+//	@Tags		clipboard_policy
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"clipboard policy id"
+//	@Param		enable		query		int		false	"clipboard policy enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.ClipboardPolicy}}
+//	@Router		/clipboard_policy [get]
+func (c *Controller) GetClipboardPolicies(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get clipboard policy") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultClipboardPolicy)
+	db = filterEqual(ctx, db, "id", "enable")
+	db = filterSearch(ctx, db, "name", "comment")
+
+	doGet[*model.ClipboardPolicy](ctx, false, db, "")
+}
+
+// GetClipboardLogs godoc
+//
+//	@Tags		clipboard_policy
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		session_id	query		string	false	"session id"
+//	@Param		uid			query		int		false	"user id"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		direction	query		string	false	"copy or paste"
+//	@Param		blocked		query		int		false	"blocked by policies"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.ClipboardLog}}
+//	@Router		/clipboard_log [get]
+func (c *Controller) GetClipboardLogs(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get clipboard log") {
+		return
+	}
+
+	db := mysql.AuditDB.Model(model.DefaultClipboardLog)
+	db = filterEqual(ctx, db, "session_id", "uid", "asset_id", "direction", "blocked")
+	db = db.Order("id DESC")
+
+	doGet[*model.ClipboardLog](ctx, false, db, "")
+}
+
+// setClipboards filters clipboards of the rdp or vnc session by policies covering it, sessions no policy covers are left
+// to the global config only
+func setClipboards(sess *gsession.Session, rid int) (err error) {
+	policies := make([]*model.ClipboardPolicy, 0)
+	if err = mysql.DB.Model(model.DefaultClipboardPolicy).Where("enable = ?", true).Find(&policies).Error; err != nil {
+		return
+	}
+	policies = lo.Filter(policies, func(p *model.ClipboardPolicy, _ int) bool { return p.InScope(sess.Uid, rid, sess.AssetId) })
+	if len(policies) == 0 {
+		return
+	}
+
+	copyRule, pasteRule := guacd.ClipboardRule{Allow: true}, guacd.ClipboardRule{Allow: true}
+	record := false
+	for _, p := range policies {
+		copyRule.Allow, pasteRule.Allow = copyRule.Allow && p.Copy, pasteRule.Allow && p.Paste
+		if p.MaxSize > 0 && (copyRule.MaxSize == 0 || p.MaxSize < copyRule.MaxSize) {
+			copyRule.MaxSize, pasteRule.MaxSize = p.MaxSize, p.MaxSize
+		}
+		record = record || p.Record
+	}
+	sess.ClipCopy = guacd.NewClipboard(copyRule, recordClipboard(sess, model.CLIPBOARD_COPY, record))
+	sess.ClipPaste = guacd.NewClipboard(pasteRule, recordClipboard(sess, model.CLIPBOARD_PASTE, record))
+
+	return
+}
+
+// recordClipboard keeps every clipboard transfer of the session in the audit trail, text content is kept only if it is allowed
+func recordClipboard(sess *gsession.Session, direction string, record bool) func(string, []byte, bool) {
+	return func(mimetype string, data []byte, blocked bool) {
+		l := &model.ClipboardLog{
+			SessionId: sess.SessionId,
+			Uid:       sess.Uid,
+			UserName:  sess.UserName,
+			AssetId:   sess.AssetId,
+			Direction: direction,
+			Mimetype:  mimetype,
+			Size:      len(data),
+			Blocked:   blocked,
+			CreatedAt: time.Now(),
+		}
+		if record && !blocked && strings.HasPrefix(mimetype, "text/") {
+			l.Content = strings.ToValidUTF8(string(data[:min(len(data), clipboardRecordMax)]), "")
+		}
+		go func() {
+			if err := mysql.AuditDB.Create(l).Error; err != nil {
+				logger.L().Error("record clipboard failed", zap.String("sessionId", l.SessionId), zap.Error(err))
+			}
+		}()
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	gsession "github.com/veops/oneterm/session"
+)
+
+// close codes of websockets, application ones are in 4000-4999 and standard ones are used where they fit
+const (
+	CLOSE_NORMAL   = websocket.CloseNormalClosure
+	CLOSE_SHUTDOWN = websocket.CloseGoingAway
+	CLOSE_POLICY   = websocket.ClosePolicyViolation
+	CLOSE_INTERNAL = websocket.CloseInternalServerErr
+	CLOSE_IDLE     = 4000
+	CLOSE_ADMIN    = 4001
+	CLOSE_LOGIN    = 4002
+)
+
+// actions clients take when websockets are closed
+const (
+	CLOSE_ACTION_NONE      = "none"
+	CLOSE_ACTION_MESSAGE   = "message"
+	CLOSE_ACTION_RECONNECT = "reconnect"
+	CLOSE_ACTION_LOGIN     = "login"
+)
+
+const (
+	// reconnectAfter is the hint of seconds before clients reconnect
+	reconnectAfter = 5
+)
+
+var (
+	shuttingDown atomic.Bool
+)
+
+// closeReason is the reason of close frames in json, it must be short since reasons are limited to 123 bytes.
+// Code is the code of the api error if there is one
+type closeReason struct {
+	Code   int    `json:"code,omitempty"`
+	Action string `json:"action"`
+	Retry  int    `json:"retry,omitempty"`
+}
+
+// closeCode tells clients whether to reconnect, show the message they have got or go to login by the error ending
+// the websocket, errors not of the api are taken as the end of the session if it has started
+func closeCode(err error, started bool) (code int, reason *closeReason) {
+	if shuttingDown.Load() {
+		return CLOSE_SHUTDOWN, &closeReason{Action: CLOSE_ACTION_RECONNECT, Retry: reconnectAfter}
+	}
+	ae := &ApiError{}
+	if !errors.As(err, &ae) {
+		if err == nil || started {
+			return CLOSE_NORMAL, &closeReason{Action: CLOSE_ACTION_NONE}
+		}
+		return CLOSE_INTERNAL, &closeReason{Action: CLOSE_ACTION_MESSAGE}
+	}
+
+	reason = &closeReason{Code: ae.Code, Action: CLOSE_ACTION_MESSAGE}
+	switch ae.Code {
+	case ErrIdleTimeout:
+		code = CLOSE_IDLE
+	case ErrAdminClose:
+		code = CLOSE_ADMIN
+	case ErrUnauthorized, ErrLogin:
+		code, reason.Action = CLOSE_LOGIN, CLOSE_ACTION_LOGIN
+	case ErrInternal, ErrRemoteServer, ErrConnectServer, ErrLoadSession:
+		code, reason.Action, reason.Retry = CLOSE_INTERNAL, CLOSE_ACTION_RECONNECT, reconnectAfter
+	default:
+		code = CLOSE_POLICY
+	}
+	return
+}
+
+// closeWs sends the close frame of the error, messages are written before it so clients show them
+func closeWs(ws *websocket.Conn, err error, started bool) {
+	if ws == nil {
+		return
+	}
+	// the peer has closed it already
+	if ce := (&websocket.CloseError{}); errors.As(err, &ce) {
+		return
+	}
+	code, reason := closeCode(err, started)
+	bs, _ := json.Marshal(reason)
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, string(bs)), time.Now().Add(time.Second))
+}
+
+// CloseOnShutdown closes websockets of online sessions and their monitors with the hint of reconnecting,
+// websockets are hijacked so that shutting down http servers does not close them
+func CloseOnShutdown() {
+	shuttingDown.Store(true)
+	gsession.GetOnlineSession().Range(func(key, value any) bool {
+		sess, ok := value.(*gsession.Session)
+		if !ok {
+			return true
+		}
+		closeWs(sess.Ws, nil, true)
+		if sess.Monitors == nil {
+			return true
+		}
+		sess.Monitors.Range(func(key, value any) bool {
+			if w, ok := value.(*gsession.WsOut); ok {
+				closeWs(w.Ws, nil, true)
+			}
+			return true
+		})
+		return true
+	})
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/cloud"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	cloudAccountPreHooks = []preHook[*model.CloudAccount]{
+		func(ctx *gin.Context, data *model.CloudAccount) {
+			if err := cloud.Validate(data); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+				return
+			}
+			// secrets are never returned, so the saved one is kept if it is not given on update
+			if id, ok := ctx.Params.Get("id"); ok && data.SecretKey == "" {
+				old := &model.CloudAccount{}
+				if err := mysql.DB.Model(old).Where("id = ?", cast.ToInt(id)).First(old).Error; err == nil {
+					data.SecretKey = old.SecretKey
+				}
+				return
+			}
+			data.SecretKey = util.EncryptAES(data.SecretKey)
+		},
+	}
+	cloudAccountPostHooks = []postHook[*model.CloudAccount]{
+		func(ctx *gin.Context, data []*model.CloudAccount) {
+			for _, d := range data {
+				d.SecretKey = ""
+			}
+		},
+	}
+)
+
+// CreateCloudAccount godoc
+//
+//	@Tags		cloud_account
+//	@Param		account	body		model.CloudAccount	true	"cloud account"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/cloud_account [post]
+func (c *Controller) CreateCloudAccount(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create cloud account") {
+		return
+	}
+	doCreate(ctx, false, &model.CloudAccount{}, "", cloudAccountPreHooks...)
+}
+
+// DeleteCloudAccount godoc
+//
+//	@Tags		cloud_account
+//	@Param		id	path		int	true	"cloud account id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/cloud_account/:id [delete]
+func (c *Controller) DeleteCloudAccount(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete cloud account") {
+		return
+	}
+	doDelete(ctx, false, &model.CloudAccount{}, "")
+}
+
+// UpdateCloudAccount godoc
+//
+//	@Tags		cloud_account
+//	@Param		id		path		int					true	"cloud account id"
+//	@Param		account	body		model.CloudAccount	true	"cloud account, the saved secret key is kept if it is empty"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/cloud_account/:id [put]
+func (c *Controller) UpdateCloudAccount(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update cloud account") {
+		return
+	}
+	doUpdate(ctx, false, &model.CloudAccount{}, "", cloudAccountPreHooks...)
+}
+
+// GetCloudAccounts godoc
+//
+//	@Tags		cloud_account
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"cloud account id"
+//	@Param		provider	query		string	false	"provider, aws aliyun or tencent"
+//	@Param		enable		query		int		false	"cloud account enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.CloudAccount}}
+//	@Router		/cloud_account [get]
+func (c *Controller) GetCloudAccounts(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get cloud account") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultCloudAccount)
+	db = filterSearch(ctx, db, "name", "comment")
+	db = filterEqual(ctx, db, "id", "provider", "enable")
+
+	doGet(ctx, false, db, "", cloudAccountPostHooks...)
+}
+
+// SyncCloudAccount godoc
+//
+//	@Tags		cloud_account
+//	@Param		id	path		int	true	"cloud account id"
+//	@Success	200	{object}	HttpResponse{data=int}	"number of instances synchronized"
+//	@Router		/cloud_account/:id/sync [post]
+func (c *Controller) SyncCloudAccount(ctx *gin.Context) {
+	if !checkAdmin(ctx, "sync cloud account") {
+		return
+	}
+
+	a := &model.CloudAccount{}
+	if err := mysql.DB.Model(a).Where("id = ?", cast.ToInt(ctx.Param("id"))).First(a).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	n, err := cloud.Sync(a)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(n))
+}
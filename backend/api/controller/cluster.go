@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"go.uber.org/zap"
+
+	myi18n "github.com/veops/oneterm/i18n"
+	"github.com/veops/oneterm/logger"
+	gsession "github.com/veops/oneterm/session"
+)
+
+func init() {
+	gsession.HandleControl(gsession.CONTROL_CLOSE, func(c *gsession.Control) {
+		if err := closeSession(i18n.NewLocalizer(myi18n.Bundle, c.Langs...), c.SessionId, c.Closer); err != nil {
+			logger.L().Error("close session of control failed", zap.String("sessionId", c.SessionId), zap.String("from", c.From), zap.Error(err))
+		}
+	})
+}
+
+// forward proxies the request of a session held by another instance to it, websockets included, so that monitors
+// work on any instance. It returns false if the session is not held by others or the request is
+// forwarded already, which is never forwarded again so that instances disagreeing never loop
+func forward(ctx *gin.Context, sessionId string) bool {
+	if gsession.GetOnlineSessionById(sessionId) != nil || ctx.GetHeader(gsession.HEADER_FORWARDED) != "" {
+		return false
+	}
+	e := gsession.GetOnlineSession().Remote(sessionId)
+	if e == nil {
+		return false
+	}
+	if e.Addr == "" {
+		ctx.AbortWithError(http.StatusBadGateway, &ApiError{Code: ErrInternal, Data: map[string]any{"err": fmt.Sprintf("session %s is held by instance %s without an advertised address", sessionId, e.Instance)}})
+		return true
+	}
+	u, err := url.Parse(e.Addr)
+	if err != nil {
+		logger.L().Warn("invalid address of instance", zap.String("instance", e.Instance), zap.String("addr", e.Addr), zap.Error(err))
+		return false
+	}
+	// bodies are read by auth already
+	if bs, ok := ctx.Get(gin.BodyBytesKey); ok {
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(bs.([]byte)))
+		ctx.Request.ContentLength = int64(len(bs.([]byte)))
+	}
+	ctx.Request.Header.Set(gsession.HEADER_FORWARDED, gsession.InstanceId)
+	p := httputil.NewSingleHostReverseProxy(u)
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.L().Warn("forward to instance failed", zap.String("instance", e.Instance), zap.String("sessionId", sessionId), zap.Error(err))
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	p.ServeHTTP(ctx.Writer, ctx.Request)
+	ctx.Abort()
+	return true
+}
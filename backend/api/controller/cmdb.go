@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/cmdb"
+	"github.com/veops/oneterm/conf"
+)
+
+const (
+	CMDB_EVENT_UPSERT = "upsert"
+	CMDB_EVENT_DELETE = "delete"
+)
+
+type cmdbWebhookReq struct {
+	Event string     `json:"event" binding:"required,oneof=upsert delete"`
+	Cis   []*cmdb.Ci `json:"cis" binding:"dive"`
+}
+
+// SyncCmdb godoc
+//
+//	@Tags		cmdb
+//	@Success	200	{object}	HttpResponse{data=int}	"number of assets created or updated"
+//	@Router		/cmdb/sync [post]
+func (c *Controller) SyncCmdb(ctx *gin.Context) {
+	if !checkAdmin(ctx, "sync cmdb") {
+		return
+	}
+
+	n, err := cmdb.Sync()
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(n))
+}
+
+// CmdbWebhook godoc
+//
+//	@Tags		cmdb
+//	@Param		X-Oneterm-Token	header		string			true	"webhook token in config"
+//	@Param		req				body		cmdbWebhookReq	true	"upsert or delete cis, assets of deleted cis are unlinked only"
+//	@Success	200				{object}	HttpResponse{data=int}
+//	@Router		/cmdb/webhook [post]
+func (c *Controller) CmdbWebhook(ctx *gin.Context) {
+	token := conf.Cfg.Cmdb.WebhookToken
+	if token == "" || subtle.ConstantTimeCompare([]byte(ctx.GetHeader("X-Oneterm-Token")), []byte(token)) != 1 {
+		ctx.AbortWithError(http.StatusUnauthorized, &ApiError{Code: ErrUnauthorized, Data: map[string]any{"err": fmt.Errorf("invalid webhook token")}})
+		return
+	}
+
+	req := &cmdbWebhookReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	n, err := 0, error(nil)
+	switch req.Event {
+	case CMDB_EVENT_UPSERT:
+		n, err = cmdb.Upsert(ctx, req.Cis)
+	case CMDB_EVENT_DELETE:
+		n, err = len(req.Cis), cmdb.Unlink(ctx, lo.Map(req.Cis, func(ci *cmdb.Ci, _ int) string { return ci.Id }))
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(n))
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/approval"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+const (
+	commandApprovalTimeout = time.Minute * 5
+)
+
+type CommandApprovalReq struct {
+	Id      int    `json:"id" binding:"required"`
+	Approve bool   `json:"approve"`
+	Reason  string `json:"reason"`
+}
+
+// CommandApprovalNotice is sent to approvers
+type CommandApprovalNotice struct {
+	*model.CommandApproval
+	Context *approval.Context `json:"context"`
+}
+
+// requestApproval saves a pending approval of current command and notifies approvers with its context,
+// it is approved at once if the maintenance window of session is still in effect
+func requestApproval(sess *gsession.Session, rule string) (ca *model.CommandApproval, err error) {
+	ca = &model.CommandApproval{
+		SessionId: sess.SessionId,
+		Uid:       sess.Uid,
+		UserName:  sess.UserName,
+		AssetId:   sess.AssetId,
+		AccountId: sess.AccountId,
+		Cmd:       sess.SshParser.Current(),
+		Rule:      rule,
+		Status:    model.APPROVAL_STATUS_PENDING,
+	}
+	if sess.MaintenanceWindowId != 0 {
+		window := &model.MaintenanceWindow{}
+		if mysql.DB.Model(window).Where("id = ?", sess.MaintenanceWindowId).First(window).Error == nil && window.InEffect(time.Now()) {
+			ca.Status = model.APPROVAL_STATUS_APPROVED
+			ca.Approver = fmt.Sprintf("maintenance window %s", window.Name)
+			ca.Reason = fmt.Sprintf("maintenance window #%d", window.Id)
+		}
+	}
+	if err = mysql.DB.Model(ca).Create(ca).Error; err != nil {
+		return
+	}
+	if ca.Status == model.APPROVAL_STATUS_APPROVED {
+		return
+	}
+	notice := &CommandApprovalNotice{CommandApproval: &model.CommandApproval{}}
+	*notice.CommandApproval = *ca
+	approval.BuildContextAsync(sess.Uid, sess.AssetId, func(c *approval.Context) {
+		notice.Context = c
+		approval.Notify(notice)
+	})
+	return
+}
+
+func expireApproval(ca *model.CommandApproval) {
+	if err := mysql.DB.Model(ca).
+		Where("id = ? AND status = ?", ca.Id, model.APPROVAL_STATUS_PENDING).
+		Update("status", model.APPROVAL_STATUS_TIMEOUT).
+		Error; err != nil {
+		logger.L().Error("expire approval failed", zap.Int("id", ca.Id), zap.Error(err))
+		return
+	}
+	flagSession(ca.SessionId, fmt.Sprintf("command approval timeout: %s", ca.Cmd), "system")
+}
+
+// CreateCommandApproval godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string				true	"session id"
+//	@Param		approval	body		CommandApprovalReq	true	"approve or reject"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/session/:session_id/command-approval [post]
+func (c *Controller) CreateCommandApproval(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "approve command") {
+		return
+	}
+
+	req := &CommandApprovalReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+
+	ca := &model.CommandApproval{}
+	if err := mysql.DB.Model(ca).Where("id = ? AND session_id = ?", req.Id, sessionId).First(ca).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if ca.Uid == currentUser.GetUid() {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "approve own command"}})
+		return
+	}
+
+	ca.Status = map[bool]int{true: model.APPROVAL_STATUS_APPROVED, false: model.APPROVAL_STATUS_REJECTED}[req.Approve]
+	ca.ApproverId, ca.Approver, ca.Reason = currentUser.GetUid(), currentUser.GetUserName(), req.Reason
+	res := mysql.DB.Model(ca).
+		Where("id = ? AND status = ?", ca.Id, model.APPROVAL_STATUS_PENDING).
+		Select("status", "approver_id", "approver", "reason").
+		Updates(ca)
+	if res.Error != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": res.Error}})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("approval %d is not pending", ca.Id)}})
+		return
+	}
+
+	select {
+	case sess.Chans.ApprovalChan <- ca:
+	default:
+	}
+	if !req.Approve {
+		flagSession(sessionId, fmt.Sprintf("command rejected by %s: %s", ca.Approver, ca.Cmd), ca.Approver)
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// GetCommandApprovals godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		status		query		int		false	"1 pending, 2 approved, 3 rejected, 4 timeout"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.CommandApproval}}
+//	@Router		/session/:session_id/command-approval [get]
+func (c *Controller) GetCommandApprovals(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get command approval") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultCommandApproval).Where("session_id = ?", ctx.Param("session_id"))
+	db = filterEqual(ctx, db, "status")
+
+	doGet[*model.CommandApproval](ctx, false, db, "")
+}
+
+// ConnectCommandApprovalNotice godoc
+//
+//	@Tags		session
+//	@Success	200	{object}	HttpResponse{data=CommandApprovalNotice}
+//	@Router		/session/command-approval/notice [get]
+func (c *Controller) ConnectCommandApprovalNotice(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "approve command") {
+		return
+	}
+
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+
+	key := fmt.Sprintf("%d-%d", currentUser.GetUid(), time.Now().UnixNano())
+	ch := approval.Subscribe(key)
+	defer approval.Unsubscribe(key)
+
+	errChan := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-errChan:
+			return
+		case v := <-ch:
+			if err = ws.WriteJSON(v); err != nil {
+				return
+			}
+		}
+	}
+}
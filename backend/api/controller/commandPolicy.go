@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+var (
+	commandPolicyPreHooks = []preHook[*model.CommandPolicy]{
+		func(ctx *gin.Context, data *model.CommandPolicy) {
+			if !lo.Contains([]int{model.POLICY_ACTION_BLOCK, model.POLICY_ACTION_WARN, model.POLICY_ACTION_CONFIRM, model.POLICY_ACTION_APPROVE}, data.Action) {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "invalid action"}})
+				return
+			}
+			if err := data.Compile(); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": err}})
+			}
+		},
+	}
+)
+
+func checkAdmin(ctx *gin.Context, perm string) bool {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": perm}})
+		return false
+	}
+	return true
+}
+
+// CreateCommandPolicy godoc
+//
+//	@Tags		command_policy
+//	@Param		policy	body		model.CommandPolicy	true	"command policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/command_policy [post]
+func (c *Controller) CreateCommandPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create command policy") {
+		return
+	}
+	doCreate(ctx, false, &model.CommandPolicy{}, "", commandPolicyPreHooks...)
+}
+
+// DeleteCommandPolicy godoc
+//
+//	@Tags		command_policy
+//	@Param		id	path		int	true	"command policy id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/command_policy/:id [delete]
+func (c *Controller) DeleteCommandPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete command policy") {
+		return
+	}
+	doDelete(ctx, false, &model.CommandPolicy{}, "")
+}
+
+// UpdateCommandPolicy godoc
+//
+//	@Tags		command_policy
+//	@Param		id		path		int					true	"command policy id"
+//	@Param		policy	body		model.CommandPolicy	true	"command policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/command_policy/:id [put]
+func (c *Controller) UpdateCommandPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update command policy") {
+		return
+	}
+	doUpdate(ctx, false, &model.CommandPolicy{}, "", commandPolicyPreHooks...)
+}
+
+// GetCommandPolicies godoc
+//
+//	@Tags		command_policy
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or patterns"
+//	@Param		id			query		int		false	"command policy id"
+//	@Param		action		query		int		false	"1 block, 2 warn, 3 confirm, 4 approve"
+//	@Param		enable		query		int		false	"command policy enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.CommandPolicy}}
+//	@Router		/command_policy [get]
+func (c *Controller) GetCommandPolicies(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get command policy") {
+		return
+	}
+
+	db := mysql.DB.Model(&model.CommandPolicy{})
+	db = filterEqual(ctx, db, "id", "action", "enable")
+	db = filterSearch(ctx, db, "name", "patterns")
+
+	doGet[*model.CommandPolicy](ctx, false, db, "")
+}
@@ -2,14 +2,18 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 	"github.com/spf13/cast"
 	"gorm.io/gorm"
 
 	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/api/guacd"
 	redis "github.com/veops/oneterm/cache"
 	mysql "github.com/veops/oneterm/db"
 	"github.com/veops/oneterm/model"
@@ -33,6 +37,22 @@ func (c *Controller) PostConfig(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
 		return
 	}
+	keyMapping := model.Map[string, string]{}
+	for k, v := range cfg.KeyMapping {
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.ToLower(strings.TrimSpace(v))
+		for _, combo := range []string{k, v} {
+			if _, err := guacd.ParseCombo(combo); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+				return
+			}
+		}
+		keyMapping[k] = v
+	}
+	cfg.KeyMapping = keyMapping
+	if s := cfg.Review.Strategy; s != "" && !lo.Contains([]string{model.REVIEW_STRATEGY_ROUND_ROBIN, model.REVIEW_STRATEGY_LEAST_LOADED, model.REVIEW_STRATEGY_OWNER}, s) {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("invalid review strategy %s", s)}})
+		return
+	}
 	cfg.Id = 0
 	cfg.CreatorId = currentUser.GetUid()
 	cfg.UpdaterId = currentUser.GetUid()
@@ -3,9 +3,15 @@ package controller
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
@@ -23,6 +29,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/samber/lo"
 	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
@@ -31,13 +38,27 @@ import (
 
 	"github.com/veops/oneterm/acl"
 	"github.com/veops/oneterm/api/guacd"
+	"github.com/veops/oneterm/conf"
 	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/docker"
+	"github.com/veops/oneterm/eventbus"
 	ggateway "github.com/veops/oneterm/gateway"
 	myi18n "github.com/veops/oneterm/i18n"
+	"github.com/veops/oneterm/k8s"
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/qos"
+	"github.com/veops/oneterm/rotation"
 	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/sshagent"
+	"github.com/veops/oneterm/storage"
+	"github.com/veops/oneterm/telnet"
+	"github.com/veops/oneterm/tracing"
+	"github.com/veops/oneterm/usage"
 	"github.com/veops/oneterm/util"
+	"github.com/veops/oneterm/warmup"
+	"github.com/veops/oneterm/x11"
+	"github.com/veops/oneterm/zmodem"
 )
 
 var (
@@ -71,6 +92,8 @@ func init() {
 
 func read(sess *gsession.Session) error {
 	chs := sess.Chans
+	// the websocket is replaced by the one of the owner resuming, sess.Ws is only touched by the main loop
+	ws := sess.Ws
 	for {
 		select {
 		case <-sess.Gctx.Done():
@@ -79,16 +102,27 @@ func read(sess *gsession.Session) error {
 			return nil
 		default:
 			if sess.SessionType == model.SESSIONTYPE_WEB {
-				t, msg, err := sess.Ws.ReadMessage()
+				t, msg, err := ws.ReadMessage()
 				if err != nil {
-					return err
+					if ws, err = waitResume(sess, ws, err); ws == nil {
+						return err
+					}
+					continue
 				}
 				if len(msg) <= 0 {
 					continue
 				}
 				switch t {
+				case websocket.BinaryMessage:
+					if sess.Framing != gsession.FRAMING_BINARY {
+						continue
+					}
+					if msg = gsession.DecodeFrame(msg); len(msg) == 0 {
+						continue
+					}
+					fallthrough
 				case websocket.TextMessage:
-					chs.InChan <- msg
+					chs.SendIn(msg)
 					if (sess.IsGuacd() && len(msg) > 0 && msg[0] != '9') || (!sess.IsGuacd() && guacd.IsActive(msg)) {
 						sess.SetIdle()
 					}
@@ -96,37 +130,104 @@ func read(sess *gsession.Session) error {
 			} else if sess.SessionType == model.SESSIONTYPE_CLIENT {
 				p, err := sess.CliRw.Read()
 				if err != nil {
-					return err
+					return fmt.Errorf("%w: %w", gsession.ErrClientLeave, err)
 				}
-				chs.InChan <- p
+				chs.SendIn(p)
 				sess.SetIdle()
 			}
 		}
 	}
 }
 
+// waitResume tells the main loop the websocket failed and waits for the one of the owner resuming, the session ends
+// with the error at once if it is not resumable. It returns nil once the session ends
+func waitResume(sess *gsession.Session, ws *websocket.Conn, err error) (*websocket.Conn, error) {
+	if sess.ResumeGrace() <= 0 {
+		return nil, fmt.Errorf("%w: %w", gsession.ErrClientLeave, err)
+	}
+	select {
+	case sess.Chans.LeaveChan <- ws:
+	case <-sess.Gctx.Done():
+		return nil, nil
+	}
+	select {
+	case ws = <-sess.Chans.ReadChan:
+		return ws, nil
+	case <-sess.Gctx.Done():
+		return nil, nil
+	}
+}
+
+// clientErr drops errors of writing to owners waited for by the session since readers find them gone, others end
+// the session as the owner left
+func clientErr(sess *gsession.Session, err error) error {
+	if err == nil || sess.ResumeGrace() > 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", gsession.ErrClientLeave, err)
+}
+
+// endReason tells why the session ended by the error ending it, errors not of clients or admins are of backends
+func endReason(err error) string {
+	ae := &ApiError{}
+	switch {
+	case errors.Is(err, gsession.ErrClientLeave):
+		return model.CLOSEREASON_CLIENT_LEAVE
+	case !errors.As(err, &ae):
+		return model.CLOSEREASON_BACKEND_END
+	case ae.Code == ErrAdminClose:
+		return model.CLOSEREASON_ADMIN_CLOSE
+	case ae.Code == ErrIdleTimeout || ae.Code == ErrAccessTime:
+		return model.CLOSEREASON_TIMEOUT
+	}
+	return model.CLOSEREASON_BACKEND_END
+}
+
 func write(sess *gsession.Session) (err error) {
 	chs := sess.Chans
 	out := chs.OutBuf.Bytes()
+	// the rest is flushed by later ticks if the output is shrunk under bandwidth pressure
+	if !sess.IsGuacd() {
+		out = out[:qos.Flush(sess.Session, len(out))]
+	}
 
+	// output is kept before it is written, so that the owner resuming gets output lost with a dropped websocket as well
+	if sess.Backlog != nil && len(out) > 0 {
+		sess.Backlog.Write(out)
+	}
 	if sess.SessionType == model.SESSIONTYPE_WEB && sess.Ws != nil {
-		if len(out) > 0 || sess.IsGuacd() {
+		if sess.IsGuacd() {
 			err = sess.Ws.WriteMessage(websocket.TextMessage, out)
+		} else if len(out) > 0 {
+			err = clientErr(sess, sess.WriteTerm(out))
 		}
 	} else if sess.SessionType == model.SESSIONTYPE_CLIENT && len(out) > 0 {
 		_, err = sess.CliRw.Write(out)
+		err = clientErr(sess, err)
 	}
 
 	if sess.SshRecoder != nil && len(out) > 0 && !sess.IsGuacd() {
 		sess.SshRecoder.Write(out)
 	}
-
-	writeToMonitors(sess.Monitors, out)
-	chs.OutBuf.Reset()
+	if sess.MonitorDelay != nil {
+		sess.MonitorDelay.Push(out)
+	} else {
+		writeToWatchers(sess, out)
+	}
+	chs.OutBuf.Next(len(out))
+	chs.OutPending.Store(int64(chs.OutBuf.Len()))
 
 	return
 }
 
+// writeToWatchers sends output to monitors and previews, it is delayed for sensitive assets
+func writeToWatchers(sess *gsession.Session, out []byte) {
+	if len(out) > 0 && !sess.IsGuacd() {
+		sess.Tail.Write(out)
+	}
+	writeToMonitors(sess.Monitors, out)
+}
+
 func writeErrMsg(sess *gsession.Session, msg string) {
 	chs := sess.Chans
 	out := []byte(fmt.Sprintf("\r\n \033[31m %s \x1b[0m", msg))
@@ -138,23 +239,89 @@ func HandleTerm(sess *gsession.Session) (err error) {
 	defer func() {
 		logger.L().Debug("defer HandleSsh", zap.String("sessionId", sess.SessionId))
 		sess.SshParser.Close(sess.Prompt)
+		sess.Slot.Release()
+		if sess.SshRecoder != nil {
+			sess.SshRecoder.Close()
+			go storage.Archive(sess.RecordingName())
+		}
 		sess.Status = model.SESSIONSTATUS_OFFLINE
 		sess.ClosedAt = lo.ToPtr(time.Now())
-		if err = gsession.UpsertSession(sess); err != nil {
-			logger.L().Error("offline ssh session failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		sess.CloseReason = endReason(err)
+		// err is kept for the close code of the websocket
+		if e := gsession.UpsertSession(sess); e != nil {
+			logger.L().Error("offline ssh session failed", zap.String("sessionId", sess.SessionId), zap.Error(e))
 			return
 		}
 	}()
+	// queued output goes before the websocket is closed, it is the one of the owner resuming last if there is one
+	defer func() {
+		sess.WsOut.Close()
+	}()
 	chs := sess.Chans
-	tk, tk1s, tk1m := time.NewTicker(time.Millisecond*100), time.NewTicker(time.Second), time.NewTicker(time.Minute)
+	tk1s, tk1m := time.NewTicker(time.Second), time.NewTicker(time.Minute)
+	defer tk1s.Stop()
+	defer tk1m.Stop()
 	sess.G.Go(func() error {
 		return read(sess)
 	})
 	sess.G.Go(func() (err error) {
+		// output is flushed once it arrives rather than by polling, bursts are coalesced into one message
+		flush := gsession.NewCoalescer(gsession.CoalesceWindow, gsession.CoalesceMaxLatency)
+		defer flush.Stop()
+		flushOut := func() error {
+			flush.Flushed()
+			err := write(sess)
+			// the rest shrunk under bandwidth pressure goes with later flushes
+			if chs.OutBuf.Len() > 0 {
+				flush.Add()
+			}
+			return err
+		}
+		// output of delayed sessions is polled for monitors as it becomes due by time rather than by arrival
+		var delayC <-chan time.Time
+		if sess.MonitorDelay != nil {
+			delayTk := time.NewTicker(time.Millisecond * 100)
+			defer delayTk.Stop()
+			delayC = delayTk.C
+		}
+		var macroTk *time.Ticker
+		var macroC <-chan time.Time
+		defer func() {
+			if macroTk != nil {
+				macroTk.Stop()
+			}
+		}()
 		asset := &model.Asset{}
+		confirming := false
+		var approving *model.CommandApproval
+		var approvalTimeout <-chan time.Time
+		takenBy := ""
+		// lines of the running macro wait until the former one is accepted
+		var macro [][]byte
+		var zmodemOut <-chan []byte
+		if sess.Zmodem != nil {
+			zmodemOut = sess.Zmodem.Out
+		}
+		// the session ends as the owner left once the grace passes without resuming
+		var graceTm *time.Timer
+		var graceC <-chan time.Time
+		defer func() {
+			if graceTm != nil {
+				graceTm.Stop()
+			}
+		}()
+		// reading is true while the reader reads on the websocket of the owner rather than waiting for one
+		reading := true
+		dropMacro := func() {
+			if len(macro) > 0 {
+				writeErrMsg(sess, "the rest of the macro is dropped\n")
+				macro = nil
+			}
+		}
 		defer sess.Chans.Rin.Close()
 		defer sess.Chans.Wout.Close()
 		for {
+			var in []byte
 			select {
 			case <-sess.Gctx.Done():
 				write(sess)
@@ -168,7 +335,7 @@ func HandleTerm(sess *gsession.Session) (err error) {
 				if mysql.DB.Model(asset).Where("id = ?", sess.AssetId).First(asset).Error != nil {
 					continue
 				}
-				if checkTime(asset.AccessAuth) && (sess.ShareId == 0 || time.Now().Before(sess.ShareEnd)) {
+				if checkTime(asset.AccessAuth) && sess.InGrant(time.Now()) {
 					continue
 				}
 				return &ApiError{Code: ErrAccessTime}
@@ -179,52 +346,250 @@ func HandleTerm(sess *gsession.Session) (err error) {
 			case err = <-chs.ErrChan:
 				writeErrMsg(sess, err.Error())
 				return
-			case in := <-chs.InChan:
-				if sess.SessionType == model.SESSIONTYPE_WEB {
-					rt := in[0]
-					msg := in[1:]
-					switch rt {
-					case '1':
-						in = msg
-					case '9':
-						continue
-					case 'w':
-						wh := strings.Split(string(msg), ",")
-						if len(wh) < 2 {
-							continue
-						}
-						chs.WindowChan <- ssh.Window{
-							Width:  cast.ToInt(wh[0]),
-							Height: cast.ToInt(wh[1]),
-						}
+			case ws := <-chs.LeaveChan:
+				reading = false
+				// the websocket replaced by the owner resuming is stale, the reader goes on with the new one
+				if ws != sess.Ws {
+					if sess.Ws != nil {
+						chs.ReadChan <- sess.Ws
+						reading = true
+					}
+					continue
+				}
+				sess.Detach()
+				grace := sess.ResumeGrace()
+				graceTm = time.NewTimer(grace)
+				graceC = graceTm.C
+				writeToMonitors(sess.Monitors, []byte(fmt.Sprintf("\r\n \033[33m %s left, waiting %s for resuming \x1b[0m\r\n", sess.UserName, grace)))
+				gsession.UpsertSession(sess)
+			case <-graceC:
+				writeToMonitors(sess.Monitors, []byte(fmt.Sprintf("\r\n \033[31m %s did not come back \x1b[0m\r\n", sess.UserName)))
+				return fmt.Errorf("%w: resume grace passed", gsession.ErrClientLeave)
+			case r := <-chs.ResumeChan:
+				detached := sess.Detached()
+				// the owner resuming before the websocket is found failed takes over from it
+				sess.Detach()
+				if err := sess.Attach(r.Ws, r.Framing, r.Offset); err != nil {
+					r.Err <- err
+					if !detached {
+						graceTm = time.NewTimer(sess.ResumeGrace())
+						graceC = graceTm.C
+						gsession.UpsertSession(sess)
 					}
+					continue
 				}
-				if cmd, forbidden := sess.SshParser.AddInput(in); forbidden {
-					writeErrMsg(sess, fmt.Sprintf("%s is forbidden\n", cmd))
-					sess.SshParser.AddInput(byteClearAll)
-					chs.Win.Write(byteClearAll)
+				if graceTm != nil {
+					graceTm.Stop()
+					graceTm, graceC = nil, nil
+				}
+				if !reading {
+					chs.ReadChan <- sess.Ws
+					reading = true
+				}
+				r.Err <- nil
+				writeToMonitors(sess.Monitors, []byte(fmt.Sprintf("\r\n \033[33m %s resumed \x1b[0m\r\n", sess.UserName)))
+				gsession.UpsertSession(sess)
+			case in = <-chs.InChan:
+				// the owner is read-only while an admin takes over, resizing and heartbeats still work
+				if takenBy != "" && (sess.SessionType != model.SESSIONTYPE_WEB || in[0] == '1') {
 					continue
 				}
-				if _, err = chs.Win.Write(in); err != nil {
-					return
+				sess.SshParser.Typer = sess.UserName
+			case ci := <-chs.CollabChan:
+				if takenBy != "" && ci.UserName != takenBy {
+					continue
 				}
+				// collaborators type into the same shell, commands are attributed to everyone who typed them
+				in, sess.SshParser.Typer = ci.P, ci.UserName
 			case out := <-chs.OutChan:
 				if _, err = chs.OutBuf.Write(out); err != nil {
 					return
 				}
+				chs.OutPending.Store(int64(chs.OutBuf.Len()))
 				sess.SshParser.AddOutput(out)
-			case <-tk.C:
-				if err = write(sess); err != nil {
+				// both copy the output, so its buffer goes back to the pool
+				gsession.PutOutBuf(out)
+				if chs.OutBuf.Len() < gsession.CoalesceMaxSize {
+					flush.Add()
+				} else if err = flushOut(); err != nil {
 					return
 				}
+			case <-flush.C():
+				if err = flushOut(); err != nil {
+					return
+				}
+			case ca := <-chs.ApprovalChan:
+				if approving == nil || ca.Id != approving.Id {
+					continue
+				}
+				approving, approvalTimeout = nil, nil
+				if ca.Status == model.APPROVAL_STATUS_APPROVED {
+					writeErrMsg(sess, fmt.Sprintf("approved by %s\n", ca.Approver))
+					sess.SshParser.Accept()
+					if _, err = chs.Win.Write(byteR); err != nil {
+						return
+					}
+					continue
+				}
+				writeErrMsg(sess, fmt.Sprintf("rejected by %s %s\n", ca.Approver, ca.Reason))
+				sess.SshParser.AddInput(byteClearAll)
+				chs.Win.Write(byteClearAll)
+				dropMacro()
+			case <-approvalTimeout:
+				expireApproval(approving)
+				approving, approvalTimeout = nil, nil
+				writeErrMsg(sess, "approval timeout\n")
+				sess.SshParser.AddInput(byteClearAll)
+				chs.Win.Write(byteClearAll)
+				dropMacro()
+			case o := <-chs.OverlayChan:
+				// bypass write to keep overlays out of recordings
+				bs := o.Bytes()
+				if sess.SessionType == model.SESSIONTYPE_WEB && sess.Ws != nil {
+					if err = clientErr(sess, sess.WriteTerm(bs)); err != nil {
+						return
+					}
+				}
+				writeToMonitors(sess.Monitors, bs)
+			case bs := <-zmodemOut:
+				// files are for the user only, they are kept out of recordings and monitors
+				if sess.Ws != nil {
+					if err = clientErr(sess, sess.WriteTerm(bs)); err != nil {
+						return
+					}
+				}
+			case <-sess.Sharing.Changed():
+				if by := sess.Sharing.TakenBy(); by != takenBy {
+					writeErrMsg(sess, lo.Ternary(by != "", fmt.Sprintf("taken over by admin %s, input is disabled\n", by), "control is handed back\n"))
+					takenBy = by
+				}
+			case <-delayC:
+				if out := sess.MonitorDelay.Due(time.Now()); len(out) > 0 {
+					writeToWatchers(sess, out)
+				}
+			case <-macroC:
+				if len(macro) == 0 {
+					macroTk.Stop()
+					macroC = nil
+					continue
+				}
+				if approving == nil && !confirming && takenBy == "" {
+					in, macro = macro[0], macro[1:]
+					sess.SshParser.Typer = sess.UserName
+				}
 			case <-tk1s.C:
+				// monitors took empty output of polling as heartbeats, they still get them
+				writeToMonitors(sess.Monitors, nil)
 				if sess.Ws == nil {
 					continue
 				}
-				if err = sess.Ws.WriteMessage(websocket.TextMessage, nil); err != nil {
+				if err = clientErr(sess, sess.WritePing()); err != nil {
 					return
 				}
 			}
+			if len(in) == 0 {
+				continue
+			}
+			if sess.SessionType == model.SESSIONTYPE_WEB {
+				rt := in[0]
+				msg := in[1:]
+				switch rt {
+				case '1':
+					in = msg
+				case '9':
+					continue
+				case 'm':
+					if len(macro) > 0 {
+						writeErrMsg(sess, "another macro is running\n")
+						continue
+					}
+					name := ""
+					if macro, name, err = macroInputs(sess, msg); err != nil {
+						writeErrMsg(sess, fmt.Sprintf("run macro failed: %s\n", err))
+						err = nil
+					} else {
+						writeErrMsg(sess, fmt.Sprintf("running macro %s\n", name))
+						if macroTk != nil {
+							macroTk.Stop()
+						}
+						macroTk = time.NewTicker(macroInterval)
+						macroC = macroTk.C
+					}
+					continue
+				case 'w':
+					wh := strings.Split(string(msg), ",")
+					if len(wh) < 2 {
+						continue
+					}
+					chs.SendWindow(ssh.Window{
+						Width:  cast.ToInt(wh[0]),
+						Height: cast.ToInt(wh[1]),
+					})
+				case 'z':
+					if sess.Zmodem != nil {
+						sess.Zmodem.Input(msg)
+					}
+					continue
+				}
+			}
+			if sess.Zmodem.Busy() {
+				// keys would break frames of the transfer
+				continue
+			}
+			if approving != nil {
+				// input is paused until the command is approved
+				continue
+			}
+			if confirming {
+				confirming = false
+				if !bytes.EqualFold(bytes.TrimSpace(in), []byte("y")) {
+					writeErrMsg(sess, "canceled\n")
+					sess.SshParser.AddInput(byteClearAll)
+					chs.Win.Write(byteClearAll)
+					dropMacro()
+					continue
+				}
+				sess.SshParser.Accept()
+				in = byteR
+			} else {
+				switch cmd, action := sess.SshParser.AddInput(in); action {
+				case model.POLICY_ACTION_BLOCK:
+					writeErrMsg(sess, fmt.Sprintf("%s is forbidden\n", cmd))
+					sess.SshParser.AddInput(byteClearAll)
+					chs.Win.Write(byteClearAll)
+					dropMacro()
+					continue
+				case model.POLICY_ACTION_WARN:
+					writeErrMsg(sess, fmt.Sprintf("%s is risky\n", cmd))
+				case model.POLICY_ACTION_CONFIRM:
+					writeErrMsg(sess, fmt.Sprintf("%s needs confirm, execute it? [y/N] ", cmd))
+					confirming = true
+					continue
+				case model.POLICY_ACTION_APPROVE:
+					if approving, err = requestApproval(sess, cmd); err != nil {
+						logger.L().Error("request approval failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+						writeErrMsg(sess, fmt.Sprintf("%s needs approval, but request failed\n", cmd))
+						sess.SshParser.AddInput(byteClearAll)
+						chs.Win.Write(byteClearAll)
+						dropMacro()
+						approving, err = nil, nil
+						continue
+					}
+					if approving.Status == model.APPROVAL_STATUS_APPROVED {
+						writeErrMsg(sess, fmt.Sprintf("%s is approved by %s\n", cmd, approving.Approver))
+						approving = nil
+						sess.SshParser.Accept()
+						in = byteR
+					} else {
+						writeErrMsg(sess, fmt.Sprintf("%s needs approval, waiting for approver...\n", cmd))
+						approvalTimeout = time.After(commandApprovalTimeout)
+						continue
+					}
+				}
+			}
+			if _, err = chs.Win.Write(in); err != nil {
+				return
+			}
 		}
 	})
 
@@ -237,11 +602,15 @@ func HandleTerm(sess *gsession.Session) (err error) {
 
 func handleGuacd(sess *gsession.Session) (err error) {
 	defer func() {
+		endRdp(sess)
+		sess.Slot.Release()
 		sess.GuacdTunnel.Disconnect()
+		go storage.Archive(sess.RecordingName())
 		sess.Status = model.SESSIONSTATUS_OFFLINE
 		sess.ClosedAt = lo.ToPtr(time.Now())
-		if err = gsession.UpsertSession(sess); err != nil {
-			logger.L().Error("offline ssh session failed", zap.Error(err))
+		sess.CloseReason = endReason(err)
+		if e := gsession.UpsertSession(sess); e != nil {
+			logger.L().Error("offline ssh session failed", zap.Error(e))
 			return
 		}
 	}()
@@ -262,7 +631,7 @@ func handleGuacd(sess *gsession.Session) (err error) {
 				if mysql.DB.Model(asset).Where("id = ?", sess.AssetId).First(asset).Error != nil {
 					continue
 				}
-				if checkTime(asset.AccessAuth) && (sess.ShareId == 0 || time.Now().Before(sess.ShareEnd)) {
+				if checkTime(asset.AccessAuth) && sess.InGrant(time.Now()) {
 					continue
 				}
 				return &ApiError{Code: ErrAccessTime}
@@ -271,201 +640,795 @@ func handleGuacd(sess *gsession.Session) (err error) {
 			case err := <-chs.ErrChan:
 				return err
 			case out := <-chs.OutChan:
+				if out = sess.ClipCopy.Filter(out); len(out) == 0 {
+					continue
+				}
+				sess.Downloads.Track(out)
+				qos.Take(len(out))
 				sess.Ws.WriteMessage(websocket.TextMessage, out)
 			}
 		}
 	})
 
-	if err = sess.G.Wait(); err != nil {
-		logger.L().Debug("sess wait end guacd", zap.String("id", sess.SessionId), zap.Error(err))
-	}
+	if err = sess.G.Wait(); err != nil {
+		logger.L().Debug("sess wait end guacd", zap.String("id", sess.SessionId), zap.Error(err))
+	}
+
+	return
+}
+
+// expandKeys turns keys instructions of web clients into key instructions by the key mapping of config,
+// invalid ones are dropped
+func expandKeys(in []byte) []byte {
+	var mapping map[string]string
+	if cfg := model.GlobalConfig.Load(); cfg != nil {
+		mapping = cfg.KeyMapping
+	}
+	p, err := guacd.ExpandKeys(in, mapping)
+	if err != nil {
+		logger.L().Debug("expand keys failed", zap.ByteString("in", in), zap.Error(err))
+	}
+	return p
+}
+
+// endRdp takes the end action of rdp so that the remote desktop is not left unlocked after the session ends
+func endRdp(sess *gsession.Session) {
+	cfg := model.GlobalConfig.Load()
+	if cfg == nil || !strings.HasPrefix(sess.Protocol, "rdp") {
+		return
+	}
+	switch cfg.RdpConfig.EndAction {
+	case model.RDPENDACTION_LOCK:
+		if err := sess.GuacdTunnel.Lock(); err != nil {
+			logger.L().Warn("lock remote desktop failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		}
+	case model.RDPENDACTION_SCRIPT:
+		if cfg.RdpConfig.EndScript == "" {
+			return
+		}
+		go func() {
+			if err := rotation.RunScript(sess.AssetId, sess.AccountId, cfg.RdpConfig.EndScript); err != nil {
+				logger.L().Warn("run end script of rdp failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+			}
+		}()
+	}
+}
+
+func writeToMonitors(monitors *sync.Map, out []byte) {
+	monitors.Range(func(key, value any) bool {
+		w, ok := value.(*gsession.WsOut)
+		if !ok || w == nil {
+			return true
+		}
+		w.WriteMessage(websocket.TextMessage, out)
+		return true
+	})
+}
+
+func DoConnect(ctx *gin.Context, ws *websocket.Conn) (sess *gsession.Session, err error) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	// phases of connecting are children of the span, the trace of the caller is continued by its traceparent
+	span := tracing.StartRemote(ctx.GetHeader("traceparent"), "connect")
+	span.Set(attribute.String("protocol", ctx.Param("protocol")), attribute.String("assetId", ctx.Param("asset_id")), attribute.String("user", currentUser.GetUserName()))
+	ctx.Set(tracing.KEY, span)
+	defer func() {
+		span.End(err)
+	}()
+	defer func() {
+		if err == nil {
+			return
+		}
+		if sess != nil {
+			sess.Slot.Release()
+			sess.WsOut.Close()
+		}
+		code := 0
+		switch {
+		case sess == nil:
+			code = model.ACCESSCODE_INVALID
+		case sess.ShareId != 0 && ctx.Value("shareErr") != nil:
+			code = model.ACCESSCODE_SHARE
+		}
+		saveAccessLog(ctx, code, err)
+	}()
+
+	assetId, accountId := cast.ToInt(ctx.Param("asset_id")), cast.ToInt(ctx.Param("account_id"))
+	asset, account, gateway, err := util.GetAAG(assetId, accountId)
+	if err != nil {
+		return
+	}
+	protocol, err := resolveProtocol(asset, ctx.Param("protocol"))
+	if err != nil {
+		err = &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}}
+		return
+	}
+
+	sess = gsession.NewSession(ctx)
+	sess.Ws = ws
+	sess.Session = &model.Session{
+		SessionType: ctx.GetInt("sessionType"),
+		SessionId:   uuid.New().String(),
+		Uid:         currentUser.GetUid(),
+		UserName:    currentUser.GetUserName(),
+		AssetId:     assetId,
+		Asset:       asset,
+		AssetInfo:   fmt.Sprintf("%s(%s)", asset.Name, asset.Ip),
+		AccountId:   accountId,
+		AccountInfo: fmt.Sprintf("%s(%s)", account.Name, account.Account),
+		GatewayId:   asset.GatewayId,
+		GatewayInfo: lo.Ternary(asset.GatewayId == 0, "", fmt.Sprintf("%s(%s)", gateway.Name, gateway.Host)),
+		Protocol:    protocol,
+		Status:      model.SESSIONSTATUS_ONLINE,
+		ShareId:     cast.ToInt(ctx.Value("shareId")),
+		Qos:         lo.Ternary(ctx.Query("qos") == model.QOS_BULK, model.QOS_BULK, model.QOS_INTERACTIVE),
+		TraceId:     span.TraceId,
+	}
+	sess.Chans.SessionId = sess.SessionId
+	span.Set(attribute.String("sessionId", sess.SessionId))
+	// sessions of a workspace are closed together with it
+	if id := cast.ToInt(ctx.Query("workspace_id")); id != 0 {
+		if err = joinWorkspace(sess, id); err != nil {
+			return
+		}
+	}
+	if asset.MonitorDelay > 0 {
+		sess.MonitorDelay = gsession.NewDelay(time.Second * time.Duration(asset.MonitorDelay))
+	}
+	if sess.ShareId != 0 {
+		sess.ShareEnd, _ = ctx.Value("shareEnd").(time.Time)
+		if err, _ = ctx.Value("shareErr").(error); err != nil {
+			return
+		}
+	}
+	if !sess.IsGuacd() {
+		if sess.SessionType == model.SESSIONTYPE_WEB && ws != nil {
+			if err = sess.NegotiateFraming(ctx.Query("framing")); err != nil {
+				return
+			}
+			sess.WsOut = gsession.NewWsOut(ws, sess.SessionId)
+			sess.Backlog = gsession.NewBacklog()
+		}
+		w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
+		sess.SshParser = gsession.NewParser(sess.SessionId, w, h)
+		if err = sess.SshParser.LoadRules(asset.AccessAuth.CmdIds, currentUser.GetUid(), currentUser.GetRid(), assetId, accountId); err != nil {
+			return
+		}
+		if sess.SshRecoder, err = gsession.NewAsciinema(sess.SessionId, w, h); err != nil {
+			return
+		}
+	} else {
+		if err = setClipboards(sess, currentUser.GetRid()); err != nil {
+			return
+		}
+		setTransfers(sess)
+	}
+	if strings.HasPrefix(sess.Protocol, "ssh") {
+		setZmodem(sess, currentUser.GetRid())
+	}
+	if sess.SessionType == model.SESSIONTYPE_WEB {
+		sess.ClientIp = ctx.ClientIP()
+	} else if sess.SessionType == model.SESSIONTYPE_CLIENT {
+		sess.ClientIp = ctx.RemoteIP()
+	}
+	if sess.IsGuacd() {
+		if err = setWatermark(sess, currentUser.GetRid()); err != nil {
+			return
+		}
+	}
+
+	_, authSpan := tracing.Start(ctx, "connect.authorize")
+	defer func() {
+		authSpan.End(err)
+	}()
+	if err = authorizeSession(ctx, sess, asset); err != nil {
+		return
+	}
+	authSpan.End(nil)
+	_, queueSpan := tracing.Start(ctx, "connect.queue")
+	err = takeSlot(sess, asset)
+	queueSpan.End(err)
+	if err != nil {
+		return
+	}
+
+	// spans of connecting the protocol are children of it
+	_, protocolSpan := tracing.Start(ctx, "connect."+strings.Split(sess.Protocol, ":")[0])
+	ctx.Set(tracing.KEY, protocolSpan)
+	switch strings.Split(sess.Protocol, ":")[0] {
+	case "ssh":
+		go connectSsh(ctx, sess, asset, account, gateway)
+	case "telnet":
+		go connectTelnet(ctx, sess, asset, account, gateway)
+	case "serial":
+		go connectSerial(ctx, sess, asset, account, gateway)
+	case "k8s":
+		go connectK8s(ctx, sess, asset, account, gateway)
+	case "docker":
+		go connectDocker(ctx, sess, asset, account, gateway)
+	case "redis", "mysql", "postgresql":
+		if hasClientTool(strings.Split(sess.Protocol, ":")[0], sess.Uid, currentUser.GetRid()) {
+			go connectClientTool(ctx, sess, asset, account, gateway)
+		} else {
+			go connectOther(ctx, sess, asset, account, gateway)
+		}
+	case "vnc", "rdp":
+		go connectGuacd(ctx, sess, asset, account, gateway)
+	default:
+		logger.L().Error("wrong protocol " + sess.Protocol)
+	}
+
+	err = <-sess.Chans.ErrChan
+	protocolSpan.End(err)
+	if err != nil {
+		logger.L().Error("failed to connect", zap.Error(err))
+		err = &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}}
+		return
+	}
+
+	gsession.GetOnlineSession().Store(sess.SessionId, sess)
+	gsession.UpsertSession(sess)
+
+	return
+}
+
+// authorizeSession applies checks of opening sessions of all kinds: access time, authorization, the flag of the
+// protocol, mfa of the asset, asset warnings and licensed limits, the maintenance window it is in is set on sess
+func authorizeSession(ctx *gin.Context, sess *gsession.Session, asset *model.Asset) (err error) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	rid := currentUser.GetRid()
+	if !checkTime(asset.AccessAuth) {
+		err = &ApiError{Code: ErrAccessTime}
+		return
+	}
+	if !hasAuthorization(ctx, sess) {
+		err = &ApiError{Code: ErrUnauthorized}
+		if contacts := asset.Escalation(); len(contacts) > 0 {
+			err = &ApiError{Code: ErrNoAccess, Data: map[string]any{
+				"asset":    asset.Name,
+				"contacts": strings.Join(lo.Map(contacts, func(c model.Contact, _ int) string { return c.Name }), ", "),
+			}}
+		}
+		return
+	}
+	// protocols are turned off by flags named protocol.<protocol> without redeploying
+	if err = checkFeature("protocol."+strings.Split(sess.Protocol, ":")[0], sess.Uid, rid); err != nil {
+		return
+	}
+	// share links are authorized by themselves
+	if sess.ShareId == 0 {
+		if err = checkMfa(ctx, sess.Uid, rid, asset.Id); err != nil {
+			return
+		}
+	}
+	if window, e := gsession.GetMaintenanceWindow(sess.Uid, rid, asset); e != nil {
+		logger.L().Warn("get maintenance window failed", zap.String("sessionId", sess.SessionId), zap.Error(e))
+	} else if window != nil {
+		sess.MaintenanceWindowId = window.Id
+	}
+	if titles, e := needConfirm(ctx, asset.Id); e != nil {
+		logger.L().Warn("get asset warnings failed", zap.String("sessionId", sess.SessionId), zap.Error(e))
+	} else if len(titles) > 0 && !cast.ToBool(ctx.Query("confirm")) {
+		err = &ApiError{Code: ErrAssetWarning, Data: map[string]any{"warnings": strings.Join(titles, "; ")}}
+		return
+	}
+	if err = usage.CheckSession(sess.Uid); err != nil {
+		err = licenseError(err)
+		return
+	}
+	return
+}
+
+func connectSsh(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
+	w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
+	chs := sess.Chans
+	defer func() {
+		ggateway.GetGatewayManager().Close(sess.SessionId)
+		if err != nil {
+			chs.ErrChan <- err
+		}
+	}()
+
+	var forward *sshagent.Agent
+	if asset.AgentForward {
+		if forward, err = sshagent.New(sess.SessionId, sess.Uid, sess.UserName, asset, account.Id); err != nil {
+			logger.L().Warn("load agent keys failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		}
+	}
+	var xf *x11.Forwarder
+	if req, ok := ctx.Value("x11").(*x11.Request); ok {
+		if conn, ok := ctx.Value("sshConn").(gossh.Conn); ok && asset.X11.Enable {
+			xf = x11.New(sess.SessionId, sess.Uid, asset.Id, conn, req)
+		} else {
+			logger.L().Debug("x11 forwarding denied", zap.String("sessionId", sess.SessionId), zap.Int("assetId", asset.Id))
+		}
+	}
+	dial := warmup.Dial
+	if (forward != nil && forward.Len() > 0) || xf != nil {
+		// channels of agent and x11 could not be told apart on a pooled client shared by sessions of others
+		dial = warmup.DialDirect
+	}
+	_, span := tracing.Start(ctx, "ssh.dial")
+	sshCli, ip, port, release, err := dial(sess.SessionId, asset, account, gateway)
+	span.End(err)
+	if err != nil {
+		logger.L().Error("ssh dial failed", zap.Error(err))
+		return
+	}
+	defer release()
+
+	if asset.Sudo.Enabled() {
+		if remove, err := installSudoers(sess.SessionId, asset, account, ip, port); err != nil {
+			logger.L().Warn("install sudoers failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		} else {
+			defer remove()
+		}
+	}
+
+	if conf.Cfg.Probe.Enable {
+		if info, err := probeHost(sshCli); err != nil {
+			logger.L().Warn("probe host failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		} else {
+			// the session is saved once it is connected, so it is set before
+			sess.HostInfo = *info
+			go saveHostInfo(asset, info)
+		}
+	}
+
+	_, span = tracing.Start(ctx, "ssh.shell")
+	defer func() {
+		span.End(err)
+	}()
+	sshSess, err := sshCli.NewSession()
+	if err != nil {
+		logger.L().Error("ssh session create failed", zap.Error(err))
+		return
+	}
+	defer sshSess.Close()
+
+	if forward != nil && forward.Len() > 0 {
+		if err := forward.Forward(sshCli, sshSess); err != nil {
+			logger.L().Warn("agent forwarding failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		}
+	}
+	if xf != nil {
+		if err := xf.Forward(sshCli, sshSess); err != nil {
+			logger.L().Warn("x11 forwarding failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		} else if asset.X11.Capture {
+			go xf.Capture(sess.Gctx, time.Second*time.Duration(lo.Ternary(asset.X11.Interval > 0, asset.X11.Interval, 30)))
+		}
+	}
+
+	sshSess.Stdin = chs.Rin
+	sshSess.Stdout = chs.Wout
+	sshSess.Stderr = chs.Wout
+
+	modes := gossh.TerminalModes{
+		gossh.ECHO:          1,
+		gossh.TTY_OP_ISPEED: 14400,
+		gossh.TTY_OP_OSPEED: 14400,
+	}
+	if err = sshSess.RequestPty("xterm", h, w, modes); err != nil {
+		logger.L().Error("ssh request pty failed", zap.Error(err))
+		return
+	}
+	if asset.ShellLog.Enable {
+		err = sshSess.Start(asset.ShellLog.Wrap(sess.SessionId))
+	} else {
+		err = sshSess.Shell()
+	}
+	if err != nil {
+		logger.L().Error("ssh start shell failed", zap.Error(err))
+		return
+	}
+
+	sess.G.Go(func() error {
+		err = sshSess.Wait()
+		return fmt.Errorf("ssh session wait end %w", err)
+	})
+
+	span.End(err)
+	chs.ErrChan <- err
+
+	sess.G.Go(func() error {
+		rd := gsession.NewOutReader(chs.Rout)
+		if sess.Zmodem != nil {
+			rd.Split = zmodem.Index
+		}
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			default:
+				if sess.Zmodem != nil && zmodem.Detect(rd.Reader) {
+					// rz or sz takes over the output until the transfer finishes
+					rest, err := sess.Zmodem.Serve(rd.Reader)
+					if err != nil {
+						logger.L().Warn("zmodem transfer failed", zap.String("session", sess.SessionId), zap.Error(err))
+					}
+					if len(rest) > 0 {
+						chs.SendOut(rest)
+					}
+					continue
+				}
+				p, err := rd.Next()
+				if err != nil {
+					return err
+				}
+				if len(p) > 0 {
+					chs.SendOut(p)
+				}
+			}
+		}
+	})
+	sess.G.Go(func() error {
+		defer sshSess.Close()
+		defer sess.Chans.Rout.Close()
+		defer sess.Chans.Win.Close()
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			case <-chs.AwayChan:
+				return fmt.Errorf("away")
+			case window := <-chs.WindowChan:
+				if err := sshSess.WindowChange(window.Height, window.Width); err != nil {
+					logger.L().Warn("reset window size failed", zap.Error(err))
+					continue
+				}
+				sess.SshRecoder.Resize(window.Width, window.Height)
+				sess.SshParser.Resize(window.Width, window.Height)
+			}
+		}
+	})
+
+	sess.G.Wait()
+
+	return
+}
+
+func connectTelnet(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
+	w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
+	chs := sess.Chans
+	defer func() {
+		ggateway.GetGatewayManager().Close(sess.SessionId)
+		if err != nil {
+			chs.ErrChan <- err
+		}
+	}()
+
+	ip, port, err := util.Proxy(false, sess.SessionId, "telnet", asset, gateway)
+	if err != nil {
+		return
+	}
+
+	cli, err := telnet.Dial(net.JoinHostPort(ip, fmt.Sprint(port)), time.Second*3, w, h)
+	if err != nil {
+		logger.L().Error("telnet dial failed", zap.Error(err))
+		return
+	}
+	defer cli.Close()
+
+	banner, err := cli.Login(account.Account, account.Password, time.Second*10)
+	if err != nil {
+		logger.L().Error("telnet login failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		return
+	}
+
+	chs.ErrChan <- err
+
+	if len(banner) > 0 {
+		chs.SendOut(banner)
+	}
+	sess.G.Go(func() error {
+		_, err := io.Copy(cli, chs.Rin)
+		return fmt.Errorf("telnet input end %w", err)
+	})
+	sess.G.Go(func() error {
+		rd := gsession.NewOutReader(cli)
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			default:
+				p, err := rd.Next()
+				if err != nil {
+					return fmt.Errorf("telnet session end %w", err)
+				}
+				if len(p) > 0 {
+					chs.SendOut(p)
+				}
+			}
+		}
+	})
+	sess.G.Go(func() error {
+		defer cli.Close()
+		defer sess.Chans.Rout.Close()
+		defer sess.Chans.Win.Close()
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			case <-chs.AwayChan:
+				return fmt.Errorf("away")
+			case window := <-chs.WindowChan:
+				if err := cli.WindowChange(window.Width, window.Height); err != nil {
+					logger.L().Warn("reset window size failed", zap.Error(err))
+					continue
+				}
+				sess.SshRecoder.Resize(window.Width, window.Height)
+				sess.SshParser.Resize(window.Width, window.Height)
+			}
+		}
+	})
+
+	sess.G.Wait()
+
+	return
+}
+
+// connectSerial attaches to a port of console server, the console is woken up by a CR
+// and its login prompt if any is answered by the account
+func connectSerial(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
+	w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
+	chs := sess.Chans
+	defer func() {
+		ggateway.GetGatewayManager().Close(sess.SessionId)
+		if err != nil {
+			chs.ErrChan <- err
+		}
+	}()
+
+	ip, port, err := util.Proxy(false, sess.SessionId, "serial", asset, gateway)
+	if err != nil {
+		return
+	}
+
+	var cli io.ReadWriteCloser
+	var banner []byte
+	addr := net.JoinHostPort(ip, fmt.Sprint(port))
+	if asset.Serial.Mode == model.SERIALMODE_RAW {
+		if cli, err = net.DialTimeout("tcp", addr, time.Second*3); err != nil {
+			logger.L().Error("serial dial failed", zap.Error(err))
+			return
+		}
+		defer cli.Close()
+		_, err = cli.Write([]byte("\r"))
+	} else {
+		tc, e := telnet.Dial(addr, time.Second*3, w, h)
+		if e != nil {
+			logger.L().Error("serial dial failed", zap.Error(e))
+			return e
+		}
+		cli = tc
+		defer cli.Close()
+		err = tc.SetComPort(&telnet.ComPort{
+			Baudrate: asset.Serial.Baudrate,
+			Datasize: asset.Serial.Databits,
+			Parity:   asset.Serial.Parity,
+			Stopsize: asset.Serial.Stopbits,
+		})
+		if err == nil {
+			_, err = tc.Write([]byte("\r"))
+		}
+		if err == nil && account.Account != "" {
+			banner, err = tc.Login(account.Account, account.Password, time.Second*3)
+		}
+	}
+	if err != nil {
+		logger.L().Error("serial login failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		return
+	}
+
+	chs.ErrChan <- err
+
+	if len(banner) > 0 {
+		chs.SendOut(banner)
+	}
+	sess.G.Go(func() error {
+		_, err := io.Copy(cli, chs.Rin)
+		return fmt.Errorf("serial input end %w", err)
+	})
+	sess.G.Go(func() error {
+		rd := gsession.NewOutReader(cli)
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			default:
+				p, err := rd.Next()
+				if err != nil {
+					return fmt.Errorf("serial session end %w", err)
+				}
+				if len(p) > 0 {
+					chs.SendOut(p)
+				}
+			}
+		}
+	})
+	sess.G.Go(func() error {
+		defer cli.Close()
+		defer sess.Chans.Rout.Close()
+		defer sess.Chans.Win.Close()
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			case <-chs.AwayChan:
+				return fmt.Errorf("away")
+			case window := <-chs.WindowChan:
+				// serial lines have no window size, only the record follows
+				sess.SshRecoder.Resize(window.Width, window.Height)
+				sess.SshParser.Resize(window.Width, window.Height)
+			}
+		}
+	})
+
+	sess.G.Wait()
 
 	return
 }
 
-func writeToMonitors(monitors *sync.Map, out []byte) {
-	monitors.Range(func(key, value any) bool {
-		ws, ok := value.(*websocket.Conn)
-		if !ok || ws == nil {
-			return true
+func connectK8s(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
+	w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
+	chs := sess.Chans
+	defer func() {
+		ggateway.GetGatewayManager().Close(sess.SessionId)
+		if err != nil {
+			chs.ErrChan <- err
 		}
-		ws.WriteMessage(websocket.TextMessage, out)
-		return true
-	})
-}
-
-func DoConnect(ctx *gin.Context, ws *websocket.Conn) (sess *gsession.Session, err error) {
-	currentUser, _ := acl.GetSessionFromCtx(ctx)
-
-	assetId, accountId := cast.ToInt(ctx.Param("asset_id")), cast.ToInt(ctx.Param("account_id"))
-	asset, account, gateway, err := util.GetAAG(assetId, accountId)
-	if err != nil {
-		return
-	}
+	}()
 
-	sess = gsession.NewSession(ctx)
-	sess.Ws = ws
-	sess.Session = &model.Session{
-		SessionType: ctx.GetInt("sessionType"),
-		SessionId:   uuid.New().String(),
-		Uid:         currentUser.GetUid(),
-		UserName:    currentUser.GetUserName(),
-		AssetId:     assetId,
-		Asset:       asset,
-		AssetInfo:   fmt.Sprintf("%s(%s)", asset.Name, asset.Ip),
-		AccountId:   accountId,
-		AccountInfo: fmt.Sprintf("%s(%s)", account.Name, account.Account),
-		GatewayId:   asset.GatewayId,
-		GatewayInfo: lo.Ternary(asset.GatewayId == 0, "", fmt.Sprintf("%s(%s)", gateway.Name, gateway.Host)),
-		Protocol:    ctx.Param("protocol"),
-		Status:      model.SESSIONSTATUS_ONLINE,
-		ShareId:     cast.ToInt(ctx.Value("shareId")),
-	}
-	if sess.ShareId != 0 {
-		sess.ShareEnd, _ = ctx.Value("shareEnd").(time.Time)
-		if err, _ = ctx.Value("shareErr").(error); err != nil {
-			return
-		}
-	}
-	if !sess.IsGuacd() {
-		w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
-		sess.SshParser = gsession.NewParser(sess.SessionId, w, h)
-		if err = mysql.DB.Model(sess.SshParser.Cmds).Where("id IN ? AND enable=?", []int(asset.AccessAuth.CmdIds), true).
-			Find(&sess.SshParser.Cmds).Error; err != nil {
-			return
-		}
-		for _, c := range sess.SshParser.Cmds {
-			if c.IsRe {
-				c.Re, _ = regexp.Compile(c.Cmd)
-			}
-		}
-		if sess.SshRecoder, err = gsession.NewAsciinema(sess.SessionId, w, h); err != nil {
+	cfg := k8s.TokenConfig(account.Password)
+	if account.AccountType == model.AUTHMETHOD_KUBECONFIG {
+		if cfg, err = k8s.ParseKubeconfig(account.Pk); err != nil {
 			return
 		}
 	}
-	if sess.SessionType == model.SESSIONTYPE_WEB {
-		sess.ClientIp = ctx.ClientIP()
-	} else if sess.SessionType == model.SESSIONTYPE_CLIENT {
-		sess.ClientIp = ctx.RemoteIP()
+	target := &k8s.Target{
+		Namespace: ctx.Query("namespace"),
+		Pod:       ctx.Query("pod"),
+		Container: ctx.Query("container"),
+		Command:   ctx.QueryArray("command"),
 	}
 
-	if !checkTime(asset.AccessAuth) {
-		err = &ApiError{Code: ErrAccessTime}
+	ip, port, err := util.Proxy(false, sess.SessionId, "k8s", asset, gateway)
+	if err != nil {
 		return
 	}
-	if !hasAuthorization(ctx, sess) {
-		err = &ApiError{Code: ErrUnauthorized}
+
+	cli, err := k8s.Dial(net.JoinHostPort(ip, fmt.Sprint(port)), cfg, target, w, h)
+	if err != nil {
+		logger.L().Error("k8s exec failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
 		return
 	}
+	defer cli.Close()
 
-	switch strings.Split(sess.Protocol, ":")[0] {
-	case "ssh":
-		go connectSsh(ctx, sess, asset, account, gateway)
-	case "redis", "mysql":
-		go connectOther(ctx, sess, asset, account, gateway)
-	case "vnc", "rdp":
-		go connectGuacd(ctx, sess, asset, account, gateway)
-	default:
-		logger.L().Error("wrong protocol " + sess.Protocol)
-	}
+	chs.ErrChan <- err
 
-	if err = <-sess.Chans.ErrChan; err != nil {
-		logger.L().Error("failed to connect", zap.Error(err))
-		err = &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}}
-		return
-	}
+	sess.G.Go(func() error {
+		_, err := io.Copy(cli, chs.Rin)
+		return fmt.Errorf("k8s input end %w", err)
+	})
+	sess.G.Go(func() error {
+		rd := gsession.NewOutReader(cli)
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			default:
+				p, err := rd.Next()
+				if err != nil {
+					return fmt.Errorf("k8s session end %w", err)
+				}
+				if len(p) > 0 {
+					chs.SendOut(p)
+				}
+			}
+		}
+	})
+	sess.G.Go(func() error {
+		defer cli.Close()
+		defer sess.Chans.Rout.Close()
+		defer sess.Chans.Win.Close()
+		for {
+			select {
+			case <-sess.Gctx.Done():
+				return nil
+			case <-chs.AwayChan:
+				return fmt.Errorf("away")
+			case window := <-chs.WindowChan:
+				if err := cli.WindowChange(window.Width, window.Height); err != nil {
+					logger.L().Warn("reset window size failed", zap.Error(err))
+					continue
+				}
+				sess.SshRecoder.Resize(window.Width, window.Height)
+				sess.SshParser.Resize(window.Width, window.Height)
+			}
+		}
+	})
 
-	gsession.GetOnlineSession().Store(sess.SessionId, sess)
-	gsession.UpsertSession(sess)
+	sess.G.Wait()
 
 	return
 }
 
-func connectSsh(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
+func connectDocker(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
 	w, h := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h"))
 	chs := sess.Chans
+	// every call of engine api needs a connection, while a gateway tunnel accepts only one
+	tunnelIds, mtx := make([]string, 0), &sync.Mutex{}
 	defer func() {
-		ggateway.GetGatewayManager().Close(sess.SessionId)
+		mtx.Lock()
+		ggateway.GetGatewayManager().Close(tunnelIds...)
+		mtx.Unlock()
 		if err != nil {
 			chs.ErrChan <- err
 		}
 	}()
-
-	ip, port, err := util.Proxy(false, sess.SessionId, "ssh", asset, gateway)
-	if err != nil {
-		return
+	dial := func(dctx context.Context) (net.Conn, error) {
+		mtx.Lock()
+		tunnelId := fmt.Sprintf("%s-%d", sess.SessionId, len(tunnelIds))
+		tunnelIds = append(tunnelIds, tunnelId)
+		mtx.Unlock()
+		ip, port, err := util.Proxy(false, tunnelId, "docker", asset, gateway)
+		if err != nil {
+			return nil, err
+		}
+		return (&net.Dialer{}).DialContext(dctx, "tcp", net.JoinHostPort(ip, fmt.Sprint(port)))
 	}
 
-	auth, err := util.GetAuth(account)
-	if err != nil {
-		return
+	var tlsCfg *tls.Config
+	if account.AccountType == model.AUTHMETHOD_TLSCERT {
+		if tlsCfg, err = docker.ParseTLS(account.Pk); err != nil {
+			return
+		}
 	}
-
-	sshCli, err := gossh.Dial("tcp", fmt.Sprintf("%s:%d", ip, port), &gossh.ClientConfig{
-		User:            account.Account,
-		Auth:            []gossh.AuthMethod{auth},
-		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
-		Timeout:         time.Second,
-	})
-	if err != nil {
-		logger.L().Error("ssh dial failed", zap.Error(err))
-		return
+	target := &docker.Target{
+		Container: ctx.Query("container"),
+		User:      ctx.Query("user"),
+		Command:   ctx.QueryArray("command"),
 	}
 
-	sshSess, err := sshCli.NewSession()
+	cli, err := docker.Dial(dial, asset.Ip, tlsCfg, target, w, h)
 	if err != nil {
-		logger.L().Error("ssh session create failed", zap.Error(err))
+		logger.L().Error("docker exec failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
 		return
 	}
-	defer sshSess.Close()
+	defer cli.Close()
 
-	sshSess.Stdin = chs.Rin
-	sshSess.Stdout = chs.Wout
-	sshSess.Stderr = chs.Wout
+	chs.ErrChan <- err
 
-	modes := gossh.TerminalModes{
-		gossh.ECHO:          1,
-		gossh.TTY_OP_ISPEED: 14400,
-		gossh.TTY_OP_OSPEED: 14400,
-	}
-	if err = sshSess.RequestPty("xterm", h, w, modes); err != nil {
-		logger.L().Error("ssh request pty failed", zap.Error(err))
-		return
-	}
-	if err = sshSess.Shell(); err != nil {
-		logger.L().Error("ssh start shell failed", zap.Error(err))
-		return
-	}
+	pipeDocker(sess, cli)
+
+	return
+}
 
+// pipeDocker streams the tty of cli through the terminal pipeline until the session ends
+func pipeDocker(sess *gsession.Session, cli *docker.Client) {
+	chs := sess.Chans
 	sess.G.Go(func() error {
-		err = sshSess.Wait()
-		return fmt.Errorf("ssh session wait end %w", err)
+		_, err := io.Copy(cli, chs.Rin)
+		return fmt.Errorf("docker input end %w", err)
 	})
-
-	chs.ErrChan <- err
-
 	sess.G.Go(func() error {
-		buf := bufio.NewReader(chs.Rout)
+		rd := gsession.NewOutReader(cli)
 		for {
 			select {
 			case <-sess.Gctx.Done():
 				return nil
 			default:
-				rn, size, err := buf.ReadRune()
+				p, err := rd.Next()
 				if err != nil {
-					return err
+					return fmt.Errorf("docker session end %w", err)
 				}
-				if size <= 0 || rn == utf8.RuneError {
-					continue
+				if len(p) > 0 {
+					chs.SendOut(p)
 				}
-				p := make([]byte, utf8.RuneLen(rn))
-				utf8.EncodeRune(p, rn)
-				chs.OutChan <- p
 			}
 		}
 	})
 	sess.G.Go(func() error {
-		defer sshSess.Close()
+		defer cli.Close()
 		defer sess.Chans.Rout.Close()
 		defer sess.Chans.Win.Close()
 		for {
@@ -475,7 +1438,7 @@ func connectSsh(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, ac
 			case <-chs.AwayChan:
 				return fmt.Errorf("away")
 			case window := <-chs.WindowChan:
-				if err := sshSess.WindowChange(window.Height, window.Width); err != nil {
+				if err := cli.WindowChange(window.Width, window.Height); err != nil {
 					logger.L().Warn("reset window size failed", zap.Error(err))
 					continue
 				}
@@ -486,8 +1449,6 @@ func connectSsh(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, ac
 	})
 
 	sess.G.Wait()
-
-	return
 }
 
 func connectGuacd(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway) (err error) {
@@ -500,7 +1461,7 @@ func connectGuacd(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 
 	w, h, dpi := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h")), cast.ToInt(ctx.Query("dpi"))
 
-	t, err := guacd.NewTunnel("", sess.SessionId, w, h, dpi, sess.Protocol, asset, account, gateway)
+	t, err := guacd.NewTunnel(ctx, "", sess.SessionId, w, h, dpi, sess.Protocol, asset, account, gateway)
 	if err != nil {
 		logger.L().Error("guacd tunnel failed", zap.Error(err))
 		return
@@ -512,6 +1473,31 @@ func connectGuacd(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 
 	chs.ErrChan <- nil
 
+	if sess.Watermark != nil {
+		// lengths of guacd elements are in characters, base64 keeps them the same as bytes
+		bs, _ := json.Marshal(sess.Watermark)
+		chs.SendOut(guacd.NewInstruction(watermarkOpcode, base64.StdEncoding.EncodeToString(bs)).Bytes())
+	}
+
+	var pacer *guacd.Pacer
+	if conf.Cfg.Guacd.Adaptive {
+		pacer = guacd.NewPacer()
+		sess.G.Go(func() error {
+			tk := time.NewTicker(time.Millisecond * 20)
+			defer tk.Stop()
+			for {
+				select {
+				case <-sess.Gctx.Done():
+					return nil
+				case <-tk.C:
+					if p := pacer.Tick(); len(p) > 0 {
+						chs.SendOut(p)
+					}
+				}
+			}
+		})
+	}
+
 	sess.G.Go(func() error {
 		for {
 			select {
@@ -525,11 +1511,17 @@ func connectGuacd(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 				if len(p) <= 0 {
 					continue
 				}
-				chs.OutChan <- p
+				if pacer != nil {
+					if p = pacer.Out(p); len(p) <= 0 {
+						continue
+					}
+				}
+				chs.SendOut(p)
 			}
 		}
 	})
 	sess.G.Go(func() error {
+		noticed := ""
 		for {
 			select {
 			case <-sess.Gctx.Done():
@@ -537,6 +1529,20 @@ func connectGuacd(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 			case <-chs.AwayChan:
 				return fmt.Errorf("away")
 			case in := <-chs.InChan:
+				if in = expandKeys(in); len(in) == 0 {
+					continue
+				}
+				if in = sess.ClipPaste.Filter(in); len(in) == 0 {
+					continue
+				}
+				// the owner shares control with invitees who have joined
+				if !takeControl(sess, sess.UserName, in, &noticed, chs.SendOut) {
+					continue
+				}
+				sess.Uploads.Track(in)
+				if pacer != nil {
+					pacer.In(in)
+				}
 				t.Write(in)
 			}
 		}
@@ -569,7 +1575,7 @@ func connectOther(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 	switch protocol {
 	case "redis":
 		rdb = redis.NewClient(&redis.Options{
-			Addr:        fmt.Sprintf("%s:%d", ip, port),
+			Addr:        net.JoinHostPort(ip, fmt.Sprint(port)),
 			Password:    account.Password,
 			DialTimeout: time.Second,
 		})
@@ -578,11 +1584,13 @@ func connectOther(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 			return
 		}
 	case "mysql":
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?charset=utf8mb4&parseTime=True&loc=Local", account.Account, account.Password, ip, port)
+		dsn := fmt.Sprintf("%s:%s@tcp(%s)/?charset=utf8mb4&parseTime=True&loc=Local", account.Account, account.Password, net.JoinHostPort(ip, fmt.Sprint(port)))
 		db, err = gorm.Open(mysqlDriver.Open(dsn))
 		if err != nil {
 			return
 		}
+	default:
+		return fmt.Errorf("%s needs client tools", protocol)
 	}
 
 	chs.ErrChan <- err
@@ -596,7 +1604,7 @@ func connectOther(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 			pt = ss[1]
 		}
 		sess.Prompt = fmt.Sprintf("%s@%s:%s> ", account.Account, asset.Name, pt)
-		chs.OutChan <- append(byteRN, []byte(sess.Prompt)...)
+		chs.SendOut(append(byteRN, []byte(sess.Prompt)...))
 		for {
 			select {
 			case <-sess.Gctx.Done():
@@ -620,14 +1628,14 @@ func connectOther(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 						for i := 0; ok && i < lipgloss.Width(string(last)); i++ {
 							dels = append(dels, byteClearCur...)
 						}
-						chs.OutChan <- dels
+						chs.SendOut(dels)
 						buf.Truncate(buf.Len() - len([]byte(string(last))))
 					}
 				}
 				if len(p) <= 0 {
 					continue
 				}
-				chs.OutChan <- p
+				chs.SendOut(p)
 				buf.Write(p)
 				bs := buf.Bytes()
 				if idx := bytes.LastIndex(bs, byteClearAll); idx >= 0 {
@@ -669,7 +1677,7 @@ func connectOther(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 						}
 					}
 				}
-				chs.OutChan <- []byte(fmt.Sprintf("\n%s\r\n%s", lo.Ternary[any](err == nil, lo.Ternary(res == nil, "", res), err), sess.Prompt))
+				chs.SendOut([]byte(fmt.Sprintf("\n%s\r\n%s", lo.Ternary[any](err == nil, lo.Ternary(res == nil, "", res), err), sess.Prompt)))
 				err = nil
 			}
 		}
@@ -698,7 +1706,14 @@ func connectOther(ctx *gin.Context, sess *gsession.Session, asset *model.Asset,
 //	@Success	200	{object}	HttpResponse
 //	@Param		w	query		int	false	"width"
 //	@Param		h	query		int	false	"height"
-//	@Param		dpi	query		int	false	"dpi"
+//	@Param		dpi			query		int		false	"dpi"
+//	@Param		namespace	query		string	false	"namespace of k8s"
+//	@Param		pod			query		string	false	"pod of k8s"
+//	@Param		container	query		string	false	"container of k8s or docker"
+//	@Param		command		query		[]string	false	"command of k8s or docker, default is a shell"
+//	@Param		user		query		string	false	"user of docker exec"
+//	@Param		qos			query		string	false	"interactive or bulk, output of bulk sessions is shrunk first under bandwidth pressure"
+//	@Param		workspace_id	query		int		false	"workspace the session joins, it is closed together with the workspace"
 //	@Success	200	{object}	HttpResponse{}
 //	@Router		/connect/:asset_id/:account_id/:protocol [get]
 func (c *Controller) Connect(ctx *gin.Context) {
@@ -713,7 +1728,21 @@ func (c *Controller) Connect(ctx *gin.Context) {
 	}
 	defer ws.Close()
 
-	var sess *gsession.Session
+	var (
+		sess *gsession.Session
+		end  error
+	)
+	defer func() {
+		// the owner could have resumed on another websocket, it is closed here as well
+		cur := ws
+		if sess != nil && sess.Ws != nil {
+			cur = sess.Ws
+		}
+		closeWs(cur, lo.Ternary(err != nil, err, end), err == nil)
+		if cur != ws {
+			cur.Close()
+		}
+	}()
 	defer func() {
 		handleError(ctx, sess, err, ws, nil)
 	}()
@@ -724,22 +1753,27 @@ func (c *Controller) Connect(ctx *gin.Context) {
 	}
 
 	if sess.IsGuacd() {
-		handleGuacd(sess)
+		end = handleGuacd(sess)
 	} else {
-		HandleTerm(sess)
+		end = HandleTerm(sess)
 	}
 }
 
 // ConnectMonitor godoc
 //
 //	@Tags		connect
-//	@Success	200	{object}	HttpResponse
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		token		query		string	false	"token of the share link, collaborators granted by it type into the terminal session"
+//	@Success	200			{object}	HttpResponse
 //	@Router		/connect/monitor/:session_id [get]
 func (c *Controller) ConnectMonitor(ctx *gin.Context) {
 
 	currentUser, _ := acl.GetSessionFromCtx(ctx)
 
 	sessionId := ctx.Param("session_id")
+	if forward(ctx, sessionId) {
+		return
+	}
 	var sess *gsession.Session
 	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
 		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
@@ -755,15 +1789,24 @@ func (c *Controller) ConnectMonitor(ctx *gin.Context) {
 		handleError(ctx, sess, err, ws, chs)
 	}()
 
-	if !acl.IsAdmin(currentUser) {
-		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "monitor session"}})
+	if sess = gsession.GetOnlineSessionById(sessionId); sess == nil {
+		err = &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}}
 		return
 	}
 
-	if sess = gsession.GetOnlineSessionById(sessionId); sess == nil {
-		err = &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}}
+	// collaborators must be authorized to the asset and account of the session as if they connected by themselves
+	collab := !sess.IsGuacd() && sess.Sharing.Granted(ctx.Query("token"), currentUser.GetUid()) &&
+		hasAuthorization(ctx, &gsession.Session{Session: &model.Session{AssetId: sess.AssetId, AccountId: sess.AccountId}})
+	if !acl.IsAdmin(currentUser) && !collab {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "monitor session"}})
 		return
 	}
+	if collab {
+		if err = sess.AddParticipant(currentUser.GetUserName()); err != nil {
+			logger.L().Error("add participant failed", zap.String("sessionId", sessionId), zap.Error(err))
+			err = nil
+		}
+	}
 
 	g, gctx := errgroup.WithContext(ctx)
 	if sess.IsGuacd() {
@@ -773,7 +1816,10 @@ func (c *Controller) ConnectMonitor(ctx *gin.Context) {
 	}
 
 	key := fmt.Sprintf("%d-%s-%d", currentUser.GetUid(), sessionId, time.Now().Nanosecond())
-	sess.Monitors.Store(key, ws)
+	mon := gsession.NewWsOut(ws, sessionId)
+	mon.KeepAlive()
+	defer mon.Close()
+	sess.Monitors.Store(key, mon)
 	defer sess.Monitors.Delete(key)
 
 	g.Go(func() error {
@@ -788,6 +1834,16 @@ func (c *Controller) ConnectMonitor(ctx *gin.Context) {
 				}
 				if sess.IsGuacd() {
 					chs.InChan <- p
+				} else if len(p) > 1 && p[0] == 'o' {
+					handleOverlay(currentUser, sess, p[1:])
+				} else if collab && len(p) > 1 && p[0] == '1' {
+					// resizing and heartbeats of collaborators never go to the session
+					sess.SetIdle()
+					select {
+					case <-gctx.Done():
+					case <-sess.Gctx.Done():
+					case sess.Chans.CollabChan <- &gsession.CollabInput{UserName: currentUser.GetUserName(), P: p}:
+					}
 				}
 			}
 		}
@@ -798,6 +1854,20 @@ func (c *Controller) ConnectMonitor(ctx *gin.Context) {
 	}
 }
 
+// handleOverlay forwards highlight of monitor to session owner, it never goes to the input of session
+func handleOverlay(currentUser *acl.Session, sess *gsession.Session, p []byte) {
+	o := &gsession.Overlay{}
+	if err := json.Unmarshal(p, o); err != nil || !o.Valid() {
+		logger.L().Debug("invalid overlay", zap.String("sessionId", sess.SessionId), zap.ByteString("overlay", p), zap.Error(err))
+		return
+	}
+	o.Monitor = currentUser.GetUserName()
+	select {
+	case sess.Chans.OverlayChan <- o:
+	default:
+	}
+}
+
 func monitGuacd(ctx *gin.Context, sess *gsession.Session, chs *gsession.SessionChans, ws *websocket.Conn) (err error) {
 	w, h, dpi := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h")), cast.ToInt(ctx.Query("dpi"))
 
@@ -805,13 +1875,25 @@ func monitGuacd(ctx *gin.Context, sess *gsession.Session, chs *gsession.SessionC
 		chs.ErrChan <- err
 	}()
 
-	t, err := guacd.NewTunnel(sess.ConnectionId, "", w, h, dpi, ":", nil, nil, nil)
+	t, err := guacd.NewTunnel(ctx, sess.ConnectionId, "", w, h, dpi, ":", nil, nil, nil)
 	if err != nil {
 		logger.L().Error("guacd tunnel failed", zap.Error(err))
 		return
 	}
 	defer t.Disconnect()
 
+	// each monitor of guacd has its own stream, so it has its own delay as well
+	var (
+		monitorDelay *gsession.Delay
+		delayTk      <-chan time.Time
+	)
+	if sess.MonitorDelay != nil {
+		monitorDelay = gsession.NewDelay(sess.MonitorDelay.Duration())
+		tk := time.NewTicker(time.Millisecond * 100)
+		defer tk.Stop()
+		delayTk = tk.C
+	}
+
 	g, gctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		for {
@@ -834,6 +1916,10 @@ func monitGuacd(ctx *gin.Context, sess *gsession.Session, chs *gsession.SessionC
 	g.Go(func() error {
 		for {
 			select {
+			case <-delayTk:
+				if out := monitorDelay.Due(time.Now()); len(out) > 0 {
+					ws.WriteMessage(websocket.TextMessage, out)
+				}
 			case <-sess.Chans.AwayChan:
 				err := fmt.Errorf("monitored session closed")
 				ws.WriteMessage(websocket.TextMessage, guacd.NewInstruction("disconnect", err.Error()).Bytes())
@@ -841,6 +1927,10 @@ func monitGuacd(ctx *gin.Context, sess *gsession.Session, chs *gsession.SessionC
 			case err := <-chs.ErrChan:
 				return err
 			case out := <-chs.OutChan:
+				if monitorDelay != nil {
+					monitorDelay.Push(out)
+					continue
+				}
 				ws.WriteMessage(websocket.TextMessage, out)
 			case in := <-chs.InChan:
 				t.Write(in)
@@ -865,34 +1955,51 @@ func (c *Controller) ConnectClose(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "close session"}})
 		return
 	}
+	sessionId := ctx.Param("session_id")
+	langs, _ := locale(ctx)
+	// sessions held by other instances are closed by them, rows of ones not held by any instance are closed here
+	if gsession.GetOnlineSessionById(sessionId) == nil &&
+		gsession.SendControl(&gsession.Control{Op: gsession.CONTROL_CLOSE, SessionId: sessionId, Closer: currentUser.GetUserName(), Langs: langs}) == nil {
+		ctx.JSON(http.StatusOK, defaultHttpResponse)
+		return
+	}
+
+	if err := closeSession(i18n.NewLocalizer(myi18n.Bundle, langs...), sessionId, currentUser.GetUserName()); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "invalid session id"}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
 
+// closeSession closes the online session by the closer, its row is closed even if it is not held by this instance
+func closeSession(localizer *i18n.Localizer, sessionId, closer string) (err error) {
 	session := &gsession.Session{}
-	err := mysql.DB.
+	err = mysql.AuditDB.
 		Model(session).
-		Where("session_id = ?", ctx.Param("session_id")).
+		Where("session_id = ?", sessionId).
 		Where("status = ?", model.SESSIONSTATUS_ONLINE).
 		First(session).
 		Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		ctx.JSON(http.StatusOK, defaultHttpResponse)
-		return
+		return nil
 	}
 	if err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "invalid session id"}})
 		return
 	}
 
 	logger.L().Info("closing...", zap.String("sessionId", session.SessionId), zap.Int("type", session.SessionType))
-	defer offlineSession(ctx, session.SessionId, currentUser.GetUserName())
+	defer offlineSession(localizer, session.SessionId, closer)
 
 	session.Status = model.SESSIONSTATUS_OFFLINE
 	session.ClosedAt = lo.ToPtr(time.Now())
+	session.CloseReason = model.CLOSEREASON_ADMIN_CLOSE
 	gsession.UpsertSession(session)
 
-	ctx.JSON(http.StatusOK, defaultHttpResponse)
+	return
 }
 
-func offlineSession(ctx *gin.Context, sessionId string, closer string) {
+func offlineSession(localizer *i18n.Localizer, sessionId string, closer string) {
 	logger.L().Debug("offline", zap.String("session_id", sessionId), zap.String("closer", closer))
 	defer gsession.GetOnlineSession().Delete(sessionId)
 	session := gsession.GetOnlineSessionById(sessionId)
@@ -902,25 +2009,25 @@ func offlineSession(ctx *gin.Context, sessionId string, closer string) {
 	if closer != "" && session.Chans != nil {
 		select {
 		case session.Chans.CloseChan <- closer:
-			break
+			eventbus.PublishAdminClose(session.Session, closer)
 		case <-time.After(time.Second):
 			break
 		}
 
 	}
 	session.Monitors.Range(func(key, value any) bool {
-		ws, ok := value.(*websocket.Conn)
-		if ok && ws != nil {
-			lang := ctx.PostForm("lang")
-			accept := ctx.GetHeader("Accept-Language")
-			localizer := i18n.NewLocalizer(myi18n.Bundle, lang, accept)
+		w, ok := value.(*gsession.WsOut)
+		if ok && w != nil {
 			cfg := &i18n.LocalizeConfig{
 				TemplateData:   map[string]any{"sessionId": sessionId},
 				DefaultMessage: myi18n.MsgSessionEnd,
 			}
 			msg, _ := localizer.Localize(cfg)
-			ws.WriteMessage(websocket.TextMessage, []byte(msg))
-			ws.Close()
+			w.WriteMessage(websocket.TextMessage, []byte(msg))
+			// the message queued goes before the close frame
+			w.Close()
+			closeWs(w.Ws, &ApiError{Code: ErrAdminClose}, true)
+			w.Ws.Close()
 		}
 		return true
 	})
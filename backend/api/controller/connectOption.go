@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+var (
+	// connectProtocols are protocols of assets sessions are connected by, others like winrm are only for management
+	connectProtocols = []string{"ssh", "telnet", "serial", "k8s", "docker", "redis", "mysql", "postgresql", "vnc", "rdp"}
+)
+
+// ConnectOption is a way the user could connect to the asset, value is the protocol of connecting,
+// e.g. /connect/:asset_id/:account_id/:value
+type ConnectOption struct {
+	Protocol    string `json:"protocol"`
+	Port        int    `json:"port"`
+	Value       string `json:"value"`
+	AccountId   int    `json:"account_id"`
+	AccountName string `json:"account_name"`
+	Account     string `json:"account"`
+}
+
+// GetConnectOptions godoc
+//
+//	@Tags		connect
+//	@Param		asset_id	path		int	true	"asset id"
+//	@Success	200			{object}	HttpResponse{data=[]ConnectOption}	"protocols and accounts of the asset the current user is allowed to connect by"
+//	@Router		/connect/options/:asset_id [get]
+func (c *Controller) GetConnectOptions(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	uid, rid := currentUser.GetUid(), currentUser.GetRid()
+
+	asset := &model.Asset{}
+	if err := mysql.DB.Model(asset).Where("id = ?", cast.ToInt(ctx.Param("asset_id"))).First(asset).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	res := make([]*ConnectOption, 0)
+	// connecting out of the access time fails whatever the protocol and account are
+	if !checkTime(asset.AccessAuth) {
+		ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+		return
+	}
+
+	accounts := make([]*model.Account, 0)
+	if err := mysql.DB.Model(model.DefaultAccount).Where("id IN ?", lo.Keys(asset.Authorization)).Find(&accounts).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	accounts = lo.Filter(accounts, func(a *model.Account, _ int) bool {
+		return hasAuthorization(ctx, &gsession.Session{Session: &model.Session{Uid: uid, AssetId: asset.Id, Asset: asset, AccountId: a.Id}})
+	})
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Id < accounts[j].Id })
+
+	for _, p := range asset.Protocols {
+		name, port, _ := strings.Cut(p, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !lo.Contains(connectProtocols, name) || checkFeature("protocol."+name, uid, rid) != nil {
+			continue
+		}
+		for _, a := range accounts {
+			res = append(res, &ConnectOption{
+				Protocol:    name,
+				Port:        cast.ToInt(port),
+				Value:       fmt.Sprintf("%s:%s", name, port),
+				AccountId:   a.Id,
+				AccountName: a.Name,
+				Account:     a.Account,
+			})
+		}
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// resolveProtocol returns the protocol of the asset the requested one stands for, so that connecting never takes
+// protocols or ports from clients which are not of the asset. A requested one without port takes the first of its name
+func resolveProtocol(asset *model.Asset, protocol string) (string, error) {
+	name, port, _ := strings.Cut(protocol, ":")
+	name = strings.ToLower(name)
+	for _, p := range asset.Protocols {
+		n, pt, _ := strings.Cut(p, ":")
+		if strings.ToLower(strings.TrimSpace(n)) == name && lo.Contains(connectProtocols, name) && (port == "" || pt == port) {
+			return fmt.Sprintf("%s:%s", name, pt), nil
+		}
+	}
+	return "", fmt.Errorf("protocol %s is not of asset %s", protocol, asset.Name)
+}
@@ -291,10 +291,18 @@ func doUpdate[T model.Model](ctx *gin.Context, needAcl bool, md T, resourceType
 				handleRemoteErr(ctx, err)
 				return
 			}
+			// they are only set by cloud and cmdb synchronizations, host probes and health checks
+			omits = append(omits, "cloud_account_id", "cloud_instance_id", "cloud_region", "cloud_tags", "ci_id")
+			omits = append(omits, "host_hostname", "host_os", "host_kernel", "host_build", "host_arch", "host_probed_at")
+			omits = append(omits, "health_status", "health_latency", "health_checked_at")
 			if cast.ToBool(ctx.Value("isAuthWithKey")) {
 				selects = []string{"ip", "protocols", "authorization"}
 			}
+		case *model.CloudAccount:
+			omits = append(omits, "synced_at", "message")
 		case *model.Account:
+			// it is only set by rotations
+			omits = append(omits, "rotation_rotated_at")
 			if cast.ToBool(ctx.Value("isAuthWithKey")) {
 				selects = []string{"account", "password", "phrase", "pk", "account_type"}
 			}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/dbproxy"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/util"
+)
+
+// CreateProxyToken godoc
+//
+//	@Tags		connect
+//	@Param		asset_id	path		int		true	"asset id"
+//	@Param		account_id	path		int		true	"account id"
+//	@Param		protocol	path		string	true	"protocol, e.g. mysql"
+//	@Success	200			{object}	HttpResponse{data=dbproxy.Token}
+//	@Router		/connect/proxy/:asset_id/:account_id/:protocol [post]
+func (c *Controller) CreateProxyToken(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	assetId, accountId := cast.ToInt(ctx.Param("asset_id")), cast.ToInt(ctx.Param("account_id"))
+	asset, _, _, err := util.GetAAG(assetId, accountId)
+	if err != nil {
+		err = &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}}
+		saveAccessLog(ctx, 0, err)
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	sess := &gsession.Session{Session: &model.Session{
+		Uid:       currentUser.GetUid(),
+		AssetId:   assetId,
+		Asset:     asset,
+		AccountId: accountId,
+		Protocol:  ctx.Param("protocol"),
+	}}
+	if err = authorizeSession(ctx, sess, asset); err != nil {
+		saveAccessLog(ctx, 0, err)
+		ctx.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+
+	token, err := dbproxy.NewToken(currentUser.GetUid(), currentUser.GetRid(), currentUser.GetUserName(), assetId, accountId, ctx.Param("protocol"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(token))
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/discovery"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/schedule"
+	"github.com/veops/oneterm/usage"
+	"github.com/veops/oneterm/util"
+)
+
+type DiscoveryScanReq struct {
+	// Cidrs the configured ones are scanned if it is empty
+	Cidrs []string `json:"cidrs"`
+}
+
+type DiscoveryReviewReq struct {
+	Ids       []int `json:"ids" binding:"required"`
+	ParentId  int   `json:"parent_id"`
+	GatewayId int   `json:"gateway_id"`
+}
+
+// GetDiscoveredAssets godoc
+//
+//	@Tags		discovery
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"ip, hostname or banner"
+//	@Param		status		query		int		false	"status"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.DiscoveredAsset}}
+//	@Router		/discovery [get]
+func (c *Controller) GetDiscoveredAssets(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get discovered asset") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultDiscoveredAsset)
+	db = filterSearch(ctx, db, "ip", "hostname", "banner")
+	db = filterEqual(ctx, db, "id", "status")
+
+	doGet[*model.DiscoveredAsset](ctx, false, db, "")
+}
+
+// ScanDiscovery godoc
+//
+//	@Tags		discovery
+//	@Param		req	body		DiscoveryScanReq	false	"cidrs to scan"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/discovery/scan [post]
+func (c *Controller) ScanDiscovery(ctx *gin.Context) {
+	if !checkAdmin(ctx, "scan discovery") {
+		return
+	}
+	req := &DiscoveryScanReq{}
+	ctx.ShouldBindBodyWithJSON(req)
+	if len(req.Cidrs) == 0 {
+		req.Cidrs = conf.Cfg.Discovery.Cidrs
+	}
+	if len(req.Cidrs) == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "no cidrs to scan"}})
+		return
+	}
+	if discovery.Running() {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "discovery scan is running"}})
+		return
+	}
+
+	// results are reviewed later since it may take minutes
+	go discovery.Scan(req.Cidrs)
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// IgnoreDiscoveredAssets godoc
+//
+//	@Tags		discovery
+//	@Param		req	body		DiscoveryReviewReq	true	"ids of discovered assets"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/discovery/ignore [post]
+func (c *Controller) IgnoreDiscoveredAssets(ctx *gin.Context) {
+	if !checkAdmin(ctx, "ignore discovered asset") {
+		return
+	}
+	req := &DiscoveryReviewReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	if err := mysql.DB.Model(model.DefaultDiscoveredAsset).
+		Where("id IN ? AND status = ?", req.Ids, model.DISCOVERYSTATUS_PENDING).
+		Update("status", model.DISCOVERYSTATUS_IGNORED).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// ImportDiscoveredAssets godoc
+//
+//	@Tags		discovery
+//	@Param		req	body		DiscoveryReviewReq	true	"ids of discovered assets, node and gateway of new assets"
+//	@Success	200	{object}	HttpResponse{data=map[int]any}	"asset id or error of each discovered asset"
+//	@Router		/discovery/import [post]
+func (c *Controller) ImportDiscoveredAssets(ctx *gin.Context) {
+	if !checkAdmin(ctx, "import discovered asset") {
+		return
+	}
+	req := &DiscoveryReviewReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	ds := make([]*model.DiscoveredAsset, 0)
+	if err := mysql.DB.Model(model.DefaultDiscoveredAsset).
+		Where("id IN ? AND status <> ?", req.Ids, model.DISCOVERYSTATUS_IMPORTED).
+		Find(&ds).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+
+	res, assetIds := map[int]any{}, make([]int, 0)
+	for _, d := range ds {
+		asset := &model.Asset{
+			Name:          lo.Ternary(d.Hostname != "", d.Hostname, d.Ip),
+			Ip:            d.Ip,
+			Protocols:     d.Protocols,
+			ParentId:      req.ParentId,
+			GatewayId:     req.GatewayId,
+			Authorization: make(model.Map[int, model.Slice[int]]),
+		}
+		if err := importAsset(ctx, asset); err != nil {
+			res[d.Id] = err.Error()
+			continue
+		}
+		mysql.DB.Model(d).Updates(map[string]any{"status": model.DISCOVERYSTATUS_IMPORTED, "asset_id": asset.Id})
+		res[d.Id] = asset.Id
+		assetIds = append(assetIds, asset.Id)
+	}
+	if len(assetIds) > 0 {
+		schedule.UpdateConnectables(assetIds...)
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// importAsset creates the asset as doCreate does without binding the request
+func importAsset(ctx *gin.Context, asset *model.Asset) (err error) {
+	if err = usage.CheckAsset(1); err != nil {
+		return
+	}
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if asset.ResourceId, err = acl.CreateGrantAcl(ctx, currentUser, conf.RESOURCE_ASSET, asset.Name); err != nil {
+		return
+	}
+	asset.CreatorId, asset.UpdaterId = currentUser.Uid, currentUser.Uid
+
+	err = mysql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Model(asset).Create(asset).Error; err != nil {
+			return
+		}
+		if err = handleAuthorization(ctx, tx, model.ACTION_CREATE, asset, nil); err != nil {
+			return
+		}
+		return tx.Create(&model.History{
+			RemoteIp:   ctx.ClientIP(),
+			Type:       asset.TableName(),
+			TargetId:   asset.GetId(),
+			ActionType: model.ACTION_CREATE,
+			Old:        nil,
+			New:        toMap(asset),
+			CreatorId:  currentUser.Uid,
+			CreatedAt:  time.Now(),
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		err = errors.New("asset name " + asset.Name + " is used")
+	}
+	return
+}
@@ -25,6 +25,13 @@ const (
 	ErrAccessTime       = 4011
 	ErrIdleTimeout      = 4012
 	ErrWrongPvk         = 4013
+	ErrAssetWarning     = 4014
+	ErrStepUp           = 4015
+	ErrMfa              = 4016
+	ErrFeatureOff       = 4017
+	ErrSessionLimit     = 4018
+	ErrNoAccess         = 4019
+	ErrLicenseLimit     = 4020
 	ErrUnauthorized     = 4401
 	ErrInternal         = 5000
 	ErrRemoteServer     = 5001
@@ -49,6 +56,13 @@ var (
 		ErrLogin:            myi18n.MsgLoginError,
 		ErrAccessTime:       myi18n.MsgAccessTime,
 		ErrIdleTimeout:      myi18n.MsgIdleTimeout,
+		ErrAssetWarning:     myi18n.MsgAssetWarning,
+		ErrStepUp:           myi18n.MsgStepUp,
+		ErrMfa:              myi18n.MsgMfa,
+		ErrFeatureOff:       myi18n.MsgFeatureOff,
+		ErrSessionLimit:     myi18n.MsgSessionLimit,
+		ErrNoAccess:         myi18n.MsgNoAccess,
+		ErrLicenseLimit:     myi18n.MsgLicenseLimit,
 		ErrUnauthorized:     myi18n.MsgUnauthorized,
 		ErrInternal:         myi18n.MsgInternalError,
 		ErrRemoteServer:     myi18n.MsgRemoteServer,
@@ -86,10 +100,10 @@ func (ae *ApiError) MessageWithCtx(ctx *gin.Context) string {
 	if ae == nil {
 		return ""
 	}
-	lang := ctx.PostForm("lang")
-	accept := ctx.GetHeader("Accept-Language")
-	localizer := i18n.NewLocalizer(myi18n.Bundle, lang, accept)
-	return ae.Message(localizer)
+	// times in data are rendered for the user like other text made by the server
+	langs, timezone := locale(ctx)
+	e := &ApiError{Code: ae.Code, Data: myi18n.TimeData(ae.Data, timezone, langs...)}
+	return e.Message(i18n.NewLocalizer(myi18n.Bundle, langs...))
 }
 
 func (ae *ApiError) MessageBase64(ctx *gin.Context) string {
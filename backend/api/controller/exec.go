@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/util"
+	"github.com/veops/oneterm/warmup"
+)
+
+type execReq struct {
+	Command string `json:"command" binding:"required"`
+	// Timeout unit is second, the default of config is taken if it is 0
+	Timeout int `json:"timeout"`
+}
+
+type execRes struct {
+	SessionId string `json:"session_id"`
+	// ExitCode is -1 if the command is killed by timeout
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Timeout   bool   `json:"timeout"`
+	Truncated bool   `json:"truncated"`
+}
+
+// limitedBuffer keeps the first n bytes written and drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	n         int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := b.n - b.Len(); rest < len(p) {
+		b.truncated = true
+		b.Buffer.Write(p[:max(rest, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// Exec godoc
+//
+//	@Tags		exec
+//	@Param		asset_id	path		int		true	"asset id"
+//	@Param		account_id	path		int		true	"account id"
+//	@Param		req			body		execReq	true	"command and its timeout in seconds"
+//	@Success	200			{object}	HttpResponse{data=execRes}	"the command is kept as a session of the exec type"
+//	@Router		/exec/:asset_id/:account_id [post]
+func (c *Controller) Exec(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	req := &execReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	assetId, accountId := cast.ToInt(ctx.Param("asset_id")), cast.ToInt(ctx.Param("account_id"))
+	asset, account, gateway, err := util.GetAAG(assetId, accountId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	protocol, ok := lo.Find(asset.Protocols, func(p string) bool { return strings.HasPrefix(strings.ToLower(p), "ssh") })
+	if !ok {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Errorf("asset %s has no ssh protocol", asset.Name)}})
+		return
+	}
+
+	sess := &gsession.Session{
+		Session: &model.Session{
+			SessionType: model.SESSIONTYPE_EXEC,
+			SessionId:   uuid.New().String(),
+			Uid:         currentUser.GetUid(),
+			UserName:    currentUser.GetUserName(),
+			AssetId:     assetId,
+			Asset:       asset,
+			AssetInfo:   fmt.Sprintf("%s(%s)", asset.Name, asset.Ip),
+			AccountId:   accountId,
+			AccountInfo: fmt.Sprintf("%s(%s)", account.Name, account.Account),
+			GatewayId:   asset.GatewayId,
+			GatewayInfo: lo.Ternary(asset.GatewayId == 0, "", fmt.Sprintf("%s(%s)", gateway.Name, gateway.Host)),
+			ClientIp:    ctx.ClientIP(),
+			Protocol:    protocol,
+			Status:      model.SESSIONSTATUS_ONLINE,
+		},
+	}
+
+	if err = authorizeSession(ctx, sess, asset); err != nil {
+		ctx.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+	if err = takeSlot(sess, asset); err != nil {
+		ctx.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+	defer sess.Slot.Release()
+	// commands needing confirm or approval are refused since there is no one to answer
+	parser := &gsession.Parser{SessionId: sess.SessionId}
+	if err = parser.LoadRules(asset.AccessAuth.CmdIds, sess.Uid, currentUser.GetRid(), assetId, accountId); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	if filter, forbidden := parser.IsForbidden(req.Command); forbidden {
+		eventbus.PublishBlocked(sess.SessionId, sess.UserName, req.Command, filter)
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": fmt.Errorf("command is forbidden by %s", filter)}})
+		return
+	}
+	if policy, pattern := parser.MatchPolicy(req.Command); policy != nil && policy.Action != model.POLICY_ACTION_WARN {
+		eventbus.PublishBlocked(sess.SessionId, sess.UserName, req.Command, fmt.Sprintf("%s: %s", policy.Name, pattern))
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": fmt.Errorf("command is refused by policy %s: %s", policy.Name, pattern)}})
+		return
+	}
+
+	if err = gsession.UpsertSession(sess); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	res, err := runExec(ctx, sess, asset, account, gateway, req)
+	sess.Status, sess.ClosedAt = model.SESSIONSTATUS_OFFLINE, lo.ToPtr(time.Now())
+	if e := gsession.UpsertSession(sess); e != nil {
+		logger.L().Error("offline exec session failed", zap.String("sessionId", sess.SessionId), zap.Error(e))
+	}
+	if err != nil {
+		logger.L().Warn("exec failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}})
+		return
+	}
+
+	cmd := &model.SessionCmd{
+		SessionId: sess.SessionId,
+		Cmd:       req.Command,
+		Result:    strings.ToValidUTF8(res.Stdout+res.Stderr, ""),
+		UserName:  sess.UserName,
+	}
+	if err = mysql.AuditDB.Create(cmd).Error; err != nil {
+		logger.L().Error("write exec cmd failed", zap.String("sessionId", sess.SessionId), zap.Error(err))
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// runExec runs the command without a pty, it is killed once it times out or the request is canceled
+func runExec(ctx *gin.Context, sess *gsession.Session, asset *model.Asset, account *model.Account, gateway *model.Gateway, req *execReq) (res *execRes, err error) {
+	cfg := conf.Cfg.Exec
+	timeout := time.Second * time.Duration(lo.Ternary(req.Timeout > 0, min(req.Timeout, cfg.MaxTimeout), cfg.Timeout))
+	defer ggateway.GetGatewayManager().Close(sess.SessionId)
+
+	sshCli, _, _, release, err := warmup.Dial(sess.SessionId, asset, account, gateway)
+	if err != nil {
+		return
+	}
+	defer release()
+	sshSess, err := sshCli.NewSession()
+	if err != nil {
+		return
+	}
+	defer sshSess.Close()
+
+	stdout, stderr := &limitedBuffer{n: cfg.MaxOutput * 1024}, &limitedBuffer{n: cfg.MaxOutput * 1024}
+	sshSess.Stdout, sshSess.Stderr = stdout, stderr
+	done := make(chan error, 1)
+	go func() {
+		done <- sshSess.Run(req.Command)
+	}()
+
+	kill := func() {
+		sshSess.Signal(gossh.SIGKILL)
+		sshSess.Close()
+		<-done
+	}
+
+	res = &execRes{SessionId: sess.SessionId}
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		res.Timeout = true
+		kill()
+	case <-ctx.Request.Context().Done():
+		kill()
+		return nil, ctx.Request.Context().Err()
+	}
+
+	exitErr := &gossh.ExitError{}
+	switch {
+	case res.Timeout:
+		res.ExitCode, err = -1, nil
+	case errors.As(err, &exitErr):
+		res.ExitCode, err = exitErr.ExitStatus(), nil
+	case err != nil:
+		return nil, err
+	}
+	res.Stdout, res.Stderr, res.Truncated = stdout.String(), stderr.String(), stdout.truncated || stderr.truncated
+	return
+}
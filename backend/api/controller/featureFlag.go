@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/feature"
+	"github.com/veops/oneterm/model"
+)
+
+var (
+	featureFlagPreHooks = []preHook[*model.FeatureFlag]{
+		func(ctx *gin.Context, data *model.FeatureFlag) {
+			if data.Name == "" || data.Rollout < 0 || data.Rollout > 100 {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "name is required and rollout is a percent"}})
+			}
+		},
+	}
+)
+
+// checkFeature refuses the feature if it is turned off for the user
+func checkFeature(name string, uid, rid int) error {
+	if feature.On(name, uid, rid, true) {
+		return nil
+	}
+	return &ApiError{Code: ErrFeatureOff, Data: map[string]any{"feature": name}}
+}
+
+// CreateFeatureFlag godoc
+//
+//	@Tags		feature_flag
+//	@Param		flag	body		model.FeatureFlag	true	"feature flag"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/feature_flag [post]
+func (c *Controller) CreateFeatureFlag(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create feature flag") {
+		return
+	}
+	doCreate(ctx, false, &model.FeatureFlag{}, "", featureFlagPreHooks...)
+	feature.Reload(ctx)
+}
+
+// DeleteFeatureFlag godoc
+//
+//	@Tags		feature_flag
+//	@Param		id	path		int	true	"feature flag id"
+//	@Success	200	{object}	HttpResponse	"the feature falls back to its default"
+//	@Router		/feature_flag/:id [delete]
+func (c *Controller) DeleteFeatureFlag(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete feature flag") {
+		return
+	}
+	doDelete(ctx, false, &model.FeatureFlag{}, "")
+	feature.Reload(ctx)
+}
+
+// UpdateFeatureFlag godoc
+//
+//	@Tags		feature_flag
+//	@Param		id		path		int					true	"feature flag id"
+//	@Param		flag	body		model.FeatureFlag	true	"feature flag"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/feature_flag/:id [put]
+func (c *Controller) UpdateFeatureFlag(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update feature flag") {
+		return
+	}
+	doUpdate(ctx, false, &model.FeatureFlag{}, "", featureFlagPreHooks...)
+	feature.Reload(ctx)
+}
+
+// GetFeatureFlags godoc
+//
+//	@Tags		feature_flag
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"feature flag id"
+//	@Param		enable		query		int		false	"feature flag enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.FeatureFlag}}
+//	@Router		/feature_flag [get]
+func (c *Controller) GetFeatureFlags(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get feature flag") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultFeatureFlag)
+	db = filterEqual(ctx, db, "id", "enable")
+	db = filterSearch(ctx, db, "name", "comment")
+
+	doGet[*model.FeatureFlag](ctx, false, db, "")
+}
+
+// GetMyFeatures godoc
+//
+//	@Tags		feature_flag
+//	@Param		names	query		[]string	true	"names of features"
+//	@Success	200		{object}	HttpResponse{data=map[string]bool}	"whether each feature is on for the current user, undefined ones are on"
+//	@Router		/feature_flag/mine [get]
+func (c *Controller) GetMyFeatures(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	res := lo.SliceToMap(ctx.QueryArray("names"), func(name string) (string, bool) {
+		return name, feature.On(name, currentUser.GetUid(), currentUser.GetRid(), true)
+	})
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
@@ -6,9 +6,12 @@ import (
 	"io"
 	"io/fs"
 	"net/http"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/sftp"
 	"github.com/samber/lo"
 	"github.com/spf13/cast"
 	"go.uber.org/zap"
@@ -18,6 +21,7 @@ import (
 	mysql "github.com/veops/oneterm/db"
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/qos"
 	gsession "github.com/veops/oneterm/session"
 )
 
@@ -34,10 +38,11 @@ import (
 //	@Param		asset_id	query		int		false	"asset id"
 //	@Param		accout_id	query		int		false	"account id"
 //	@Param		client_ip	query		string	false	"client_ip"
+//	@Param		session_id	query		string	false	"session_id"
 //	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Session}}
 //	@Router		/file/history [get]
 func (c *Controller) GetFileHistory(ctx *gin.Context) {
-	db := mysql.DB.Model(&model.FileHistory{})
+	db := mysql.AuditDB.Model(&model.FileHistory{})
 	currentUser, _ := acl.GetSessionFromCtx(ctx)
 	if !acl.IsAdmin(currentUser) {
 		db = db.Where("uid = ?", currentUser.Uid)
@@ -47,7 +52,7 @@ func (c *Controller) GetFileHistory(ctx *gin.Context) {
 	if err != nil {
 		return
 	}
-	db = filterEqual(ctx, db, "status", "uid", "asset_id", "account_id", "client_ip")
+	db = filterEqual(ctx, db, "status", "uid", "asset_id", "account_id", "client_ip", "session_id")
 
 	doGet[*model.FileHistory](ctx, false, db, "")
 }
@@ -139,8 +144,9 @@ func (c *Controller) FileMkdir(ctx *gin.Context) {
 		ClientIp:  ctx.ClientIP(),
 		Action:    model.FILE_ACTION_MKDIR,
 		Dir:       ctx.Query("dir"),
+		SessionId: ctx.GetString("sessionId"),
 	}
-	if err = mysql.DB.Model(h).Create(h).Error; err != nil {
+	if err = mysql.AuditDB.Model(h).Create(h).Error; err != nil {
 		logger.L().Error("record mkdir failed", zap.Error(err), zap.Any("history", h))
 	}
 	ctx.JSON(http.StatusOK, defaultHttpResponse)
@@ -191,7 +197,7 @@ func (c *Controller) FileUpload(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
 		return
 	}
-	if _, err = rf.Write(content); err != nil {
+	if _, err = io.Copy(rf, qos.NewReader(ctx, bytes.NewReader(content))); err != nil {
 		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
 		return
 	}
@@ -205,10 +211,12 @@ func (c *Controller) FileUpload(ctx *gin.Context) {
 		Action:    model.FILE_ACTION_UPLOAD,
 		Dir:       ctx.Query("dir"),
 		Filename:  fh.Filename,
+		SessionId: ctx.GetString("sessionId"),
 	}
-	if err = mysql.DB.Model(h).Create(h).Error; err != nil {
+	if err = mysql.AuditDB.Model(h).Create(h).Error; err != nil {
 		logger.L().Error("record upload failed", zap.Error(err), zap.Any("history", h))
 	}
+	recordSftpTransfer(ctx, model.FILETRANSFER_UPLOAD, ctx.Query("dir"), fh.Filename, content)
 
 	ctx.JSON(http.StatusOK, defaultHttpResponse)
 }
@@ -254,8 +262,9 @@ func (c *Controller) FileDownload(ctx *gin.Context) {
 	ctx.Header("Content-Type", "application/text/plain")
 	buf := &bytes.Buffer{}
 	rf.WriteTo(buf)
-	ctx.Header("Accept-Length", fmt.Sprintf("%d", len(buf.Bytes())))
-	ctx.Writer.Write(buf.Bytes())
+	content := buf.Bytes()
+	ctx.Header("Accept-Length", fmt.Sprintf("%d", len(content)))
+	io.Copy(ctx.Writer, qos.NewReader(ctx, bytes.NewReader(content)))
 
 	h := &model.FileHistory{
 		Uid:       currentUser.GetUid(),
@@ -263,12 +272,194 @@ func (c *Controller) FileDownload(ctx *gin.Context) {
 		AssetId:   cast.ToInt(ctx.Param("asset_id")),
 		AccountId: cast.ToInt(ctx.Param("account_id")),
 		ClientIp:  ctx.ClientIP(),
-		Action:    model.FILE_ACTION_UPLOAD,
+		Action:    model.FILE_ACTION_DOWNLOAD,
 		Dir:       ctx.Query("dir"),
 		Filename:  ctx.Query("filename"),
+		SessionId: ctx.GetString("sessionId"),
 	}
 
-	if err = mysql.DB.Model(h).Create(h).Error; err != nil {
+	if err = mysql.AuditDB.Model(h).Create(h).Error; err != nil {
 		logger.L().Error("record download failed", zap.Error(err), zap.Any("history", h))
 	}
+	recordSftpTransfer(ctx, model.FILETRANSFER_DOWNLOAD, ctx.Query("dir"), ctx.Query("filename"), content)
+}
+
+// FileRm godoc
+//
+//	@Tags		file
+//	@Param		asset_id	path		int		true	"asset_id"
+//	@Param		account_id	path		int		true	"account_id"
+//	@Param		dir			query		string	true	"dir"
+//	@Param		filename	query		string	true	"filename"
+//	@Param		recursive	query		bool	false	"remove a directory with all in it"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/file/rm/:asset_id/:account_id [delete]
+func (c *Controller) FileRm(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sess := &gsession.Session{
+		Session: &model.Session{
+			AssetId:   cast.ToInt(ctx.Param("asset_id")),
+			AccountId: cast.ToInt(ctx.Param("account_id")),
+		},
+	}
+
+	if !hasAuthorization(ctx, sess) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{}})
+		return
+	}
+
+	cli, err := file.GetFileManager().GetFileClient(cast.ToInt(ctx.Param("asset_id")), cast.ToInt(ctx.Param("account_id")))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{}})
+		return
+	}
+
+	target, err := rmTarget(cli, ctx.Query("dir"), ctx.Query("filename"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	// directories with things in them are only removed if it is asked for explicitly
+	rm := lo.Ternary(cast.ToBool(ctx.Query("recursive")), cli.RemoveAll, cli.Remove)
+	if err = rm(target); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	h := &model.FileHistory{
+		Uid:       currentUser.GetUid(),
+		UserName:  currentUser.GetUserName(),
+		AssetId:   cast.ToInt(ctx.Param("asset_id")),
+		AccountId: cast.ToInt(ctx.Param("account_id")),
+		ClientIp:  ctx.ClientIP(),
+		Action:    model.FILE_ACTION_RM,
+		Dir:       ctx.Query("dir"),
+		Filename:  ctx.Query("filename"),
+		SessionId: ctx.GetString("sessionId"),
+	}
+	if err = mysql.AuditDB.Model(h).Create(h).Error; err != nil {
+		logger.L().Error("record rm failed", zap.Error(err), zap.Any("history", h))
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// SessionFileLS godoc
+//
+//	@Tags		file
+//	@Param		session_id	path		string	true	"session_id"
+//	@Param		dir			query		string	true	"dir"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/file/session/:session_id/ls [get]
+func (c *Controller) SessionFileLS(ctx *gin.Context) {
+	if !sessionFileParams(ctx) {
+		return
+	}
+	c.FileLS(ctx)
+}
+
+// SessionFileMkdir godoc
+//
+//	@Tags		file
+//	@Param		session_id	path		string	true	"session_id"
+//	@Param		dir			query		string	true	"dir"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/file/session/:session_id/mkdir [post]
+func (c *Controller) SessionFileMkdir(ctx *gin.Context) {
+	if !sessionFileParams(ctx) {
+		return
+	}
+	c.FileMkdir(ctx)
+}
+
+// SessionFileUpload godoc
+//
+//	@Tags		file
+//	@Param		session_id	path		string	true	"session_id"
+//	@Param		dir			query		string	true	"dir"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/file/session/:session_id/upload [post]
+func (c *Controller) SessionFileUpload(ctx *gin.Context) {
+	if !sessionFileParams(ctx) {
+		return
+	}
+	c.FileUpload(ctx)
+}
+
+// SessionFileDownload godoc
+//
+//	@Tags		file
+//	@Param		session_id	path		string	true	"session_id"
+//	@Param		dir			query		string	true	"dir"
+//	@Param		filename	query		string	true	"filename"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/file/session/:session_id/download [get]
+func (c *Controller) SessionFileDownload(ctx *gin.Context) {
+	if !sessionFileParams(ctx) {
+		return
+	}
+	c.FileDownload(ctx)
+}
+
+// SessionFileRm godoc
+//
+//	@Tags		file
+//	@Param		session_id	path		string	true	"session_id"
+//	@Param		dir			query		string	true	"dir"
+//	@Param		filename	query		string	true	"filename"
+//	@Param		recursive	query		bool	false	"remove a directory with all in it"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/file/session/:session_id/rm [delete]
+func (c *Controller) SessionFileRm(ctx *gin.Context) {
+	if !sessionFileParams(ctx) {
+		return
+	}
+	c.FileRm(ctx)
+}
+
+// sessionFileParams resolves asset and account from an online ssh session of current user
+// so that the file handlers above can be reused
+func sessionFileParams(ctx *gin.Context) bool {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil || !sess.IsSsh() {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return false
+	}
+	if sess.Uid != currentUser.GetUid() && !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "file"}})
+		return false
+	}
+
+	ctx.Params = lo.Filter(ctx.Params, func(p gin.Param, _ int) bool {
+		return !lo.Contains([]string{"account_id", "asset_id"}, p.Key)
+	})
+	ctx.Params = append(ctx.Params, gin.Param{Key: "account_id", Value: cast.ToString(sess.AccountId)})
+	ctx.Params = append(ctx.Params, gin.Param{Key: "asset_id", Value: cast.ToString(sess.AssetId)})
+	ctx.Set("sessionId", sessionId)
+
+	return true
+}
+
+// rmTarget is the path to remove, the filename must be one in dir and the root, the working directory and home are
+// never removed as a whole
+func rmTarget(cli *sftp.Client, dir, filename string) (target string, err error) {
+	if filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, "/\\") {
+		return "", fmt.Errorf("invalid filename %q", filename)
+	}
+	if lo.Contains(strings.Split(filepath.ToSlash(dir), "/"), "..") {
+		return "", fmt.Errorf("invalid dir %q", dir)
+	}
+	target = path.Join(filepath.ToSlash(dir), filename)
+	wd, err := cli.Getwd()
+	if err != nil {
+		return
+	}
+	if lo.Contains([]string{"/", ".", path.Clean(wd)}, path.Clean(target)) {
+		return "", fmt.Errorf("refuse to remove %s", target)
+	}
+	return
 }
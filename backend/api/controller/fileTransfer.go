@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/api/guacd"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/feature"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/zmodem"
+)
+
+// GetFileTransfers godoc
+//
+//	@Tags		file
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"filename or user name"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Param		uid			query		int		false	"uid, admins only"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		account_id	query		int		false	"account id"
+//	@Param		session_id	query		string	false	"session id"
+//	@Param		source		query		string	false	"sftp, rdp or zmodem"
+//	@Param		direction	query		string	false	"upload or download"
+//	@Param		sha256		query		string	false	"sha256 of the content"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.FileTransfer}}
+//	@Router		/file/transfer [get]
+func (c *Controller) GetFileTransfers(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.AuditDB.Model(model.DefaultFileTransfer)
+	if acl.IsAdmin(currentUser) {
+		db = filterEqual(ctx, db, "uid")
+	} else {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+	db = filterSearch(ctx, db, "filename", "user_name")
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = filterEqual(ctx, db, "asset_id", "account_id", "session_id", "source", "direction", "sha256")
+	db = db.Order("id DESC")
+
+	doGet[*model.FileTransfer](ctx, false, db, "")
+}
+
+// setTransfers audits files transferred by the drive of the rdp session
+func setTransfers(sess *gsession.Session) {
+	sess.Uploads = guacd.NewTransfer(recordGuacdTransfer(sess, model.FILETRANSFER_UPLOAD))
+	sess.Downloads = guacd.NewTransfer(recordGuacdTransfer(sess, model.FILETRANSFER_DOWNLOAD))
+}
+
+// setZmodem lets rz and sz of web ssh terminals transfer files through the websocket
+func setZmodem(sess *gsession.Session, rid int) {
+	if sess.SessionType != model.SESSIONTYPE_WEB || !feature.On("zmodem", sess.Uid, rid, true) {
+		return
+	}
+	sess.Zmodem = zmodem.New(sess.Gctx, sess.Chans.Win, func(upload bool, filename string, size int64, sum string) {
+		recordTransfer(&model.FileTransfer{
+			Uid:       sess.Uid,
+			UserName:  sess.UserName,
+			AssetId:   sess.AssetId,
+			AccountId: sess.AccountId,
+			SessionId: sess.SessionId,
+			ClientIp:  sess.ClientIp,
+			Source:    model.FILETRANSFER_ZMODEM,
+			Direction: lo.Ternary(upload, model.FILETRANSFER_UPLOAD, model.FILETRANSFER_DOWNLOAD),
+			Filename:  filename,
+			Size:      size,
+			Sha256:    sum,
+		})
+	})
+}
+
+func recordGuacdTransfer(sess *gsession.Session, direction string) func(string, int64, string) {
+	return func(filename string, size int64, sum string) {
+		recordTransfer(&model.FileTransfer{
+			Uid:       sess.Uid,
+			UserName:  sess.UserName,
+			AssetId:   sess.AssetId,
+			AccountId: sess.AccountId,
+			SessionId: sess.SessionId,
+			ClientIp:  sess.ClientIp,
+			Source:    model.FILETRANSFER_RDP,
+			Direction: direction,
+			Filename:  filename,
+			Size:      size,
+			Sha256:    sum,
+		})
+	}
+}
+
+// recordSftpTransfer audits the file transferred by the file api, content is hashed at once since it is in memory
+func recordSftpTransfer(ctx *gin.Context, direction, dir, filename string, content []byte) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	sum := sha256.Sum256(content)
+	recordTransfer(&model.FileTransfer{
+		Uid:       currentUser.GetUid(),
+		UserName:  currentUser.GetUserName(),
+		AssetId:   cast.ToInt(ctx.Param("asset_id")),
+		AccountId: cast.ToInt(ctx.Param("account_id")),
+		SessionId: ctx.GetString("sessionId"),
+		ClientIp:  ctx.ClientIP(),
+		Source:    model.FILETRANSFER_SFTP,
+		Direction: direction,
+		Dir:       dir,
+		Filename:  filename,
+		Size:      int64(len(content)),
+		Sha256:    hex.EncodeToString(sum[:]),
+	})
+}
+
+func recordTransfer(t *model.FileTransfer) {
+	t.CreatedAt = time.Now()
+	if err := mysql.AuditDB.Create(t).Error; err != nil {
+		logger.L().Error("record file transfer failed", zap.Error(err), zap.Any("transfer", t))
+	}
+}
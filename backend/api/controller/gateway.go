@@ -2,6 +2,7 @@ package controller
 
 import (
 	"errors"
+	"net"
 	"net/http"
 	"strings"
 
@@ -20,6 +21,15 @@ import (
 
 var (
 	gatewayPreHooks = []preHook[*model.Gateway]{
+		func(ctx *gin.Context, data *model.Gateway) {
+			if !lo.Contains([]int{model.GATEWAYTYPE_SSH, model.GATEWAYTYPE_SOCKS5, model.GATEWAYTYPE_HTTP}, data.Type) {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "invalid gateway type"}})
+				return
+			}
+			if data.Type != model.GATEWAYTYPE_SSH && data.AccountType == model.AUTHMETHOD_PUBLICKEY {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "proxies authenticate by password only"}})
+			}
+		},
 		func(ctx *gin.Context, data *model.Gateway) {
 			if data.AccountType == model.AUTHMETHOD_PUBLICKEY {
 				if data.Phrase == "" {
@@ -37,6 +47,18 @@ var (
 				}
 			}
 		},
+		func(ctx *gin.Context, data *model.Gateway) {
+			if data.DnsServer == "" {
+				return
+			}
+			host, _, err := net.SplitHostPort(data.DnsServer)
+			if err != nil {
+				host = data.DnsServer
+			}
+			if net.ParseIP(host) == nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "dns server must be an ip"}})
+			}
+		},
 		func(ctx *gin.Context, data *model.Gateway) {
 			data.Password = util.EncryptAES(data.Password)
 			data.Pk = util.EncryptAES(data.Pk)
@@ -84,6 +106,16 @@ var (
 			err = lo.Ternary[error](err == nil, &ApiError{Code: ErrHasDepency, Data: map[string]any{"name": assetName}}, err)
 			ctx.AbortWithError(code, err)
 		},
+		func(ctx *gin.Context, id int) {
+			groups := make([]*model.GatewayGroup, 0)
+			if err := mysql.DB.Model(model.DefaultGatewayGroup).Find(&groups).Error; err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			if g, ok := lo.Find(groups, func(g *model.GatewayGroup) bool { return lo.Contains(g.GatewayIds, id) }); ok {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrHasDepency, Data: map[string]any{"name": g.Name}})
+			}
+		},
 	}
 )
 
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+var (
+	gatewayGroupPreHooks = []preHook[*model.GatewayGroup]{
+		func(ctx *gin.Context, data *model.GatewayGroup) {
+			if data.Strategy == "" {
+				data.Strategy = model.GATEWAYSTRATEGY_ROUNDROBIN
+			}
+			if !lo.Contains([]string{model.GATEWAYSTRATEGY_ROUNDROBIN, model.GATEWAYSTRATEGY_LEASTSESSIONS}, data.Strategy) {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "strategy must be round_robin or least_sessions"}})
+				return
+			}
+			data.GatewayIds = lo.Uniq(lo.Without(data.GatewayIds, 0))
+			cnt := int64(0)
+			if err := mysql.DB.Model(model.DefaultGateway).Where("id IN ?", []int(data.GatewayIds)).Count(&cnt).Error; err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+				return
+			}
+			if cnt == 0 || int(cnt) != len(data.GatewayIds) {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "gateways of the group are empty or missing"}})
+			}
+		},
+	}
+	gatewayGroupPostHooks = []postHook[*model.GatewayGroup]{
+		func(ctx *gin.Context, data []*model.GatewayGroup) {
+			post := make([]*model.GatewayCount, 0)
+			if err := mysql.DB.
+				Model(model.DefaultAsset).
+				Select("gateway_group_id AS id, COUNT(*) AS count").
+				Where("gateway_group_id IN ?", lo.Map(data, func(d *model.GatewayGroup, _ int) int { return d.Id })).
+				Group("gateway_group_id").
+				Find(&post).
+				Error; err != nil {
+				return
+			}
+			m := lo.SliceToMap(post, func(p *model.GatewayCount) (int, int64) { return p.Id, p.Count })
+			for _, d := range data {
+				d.AssetCount = m[d.Id]
+			}
+		},
+	}
+	gatewayGroupDcs = []deleteCheck{
+		func(ctx *gin.Context, id int) {
+			assetName := ""
+			err := mysql.DB.
+				Model(model.DefaultAsset).
+				Select("name").
+				Where("gateway_group_id = ?", id).
+				First(&assetName).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return
+			}
+			code := lo.Ternary(err == nil, http.StatusBadRequest, http.StatusInternalServerError)
+			err = lo.Ternary[error](err == nil, &ApiError{Code: ErrHasDepency, Data: map[string]any{"name": assetName}}, err)
+			ctx.AbortWithError(code, err)
+		},
+	}
+)
+
+// CreateGatewayGroup godoc
+//
+//	@Tags		gateway_group
+//	@Param		group	body		model.GatewayGroup	true	"gateway group"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/gateway_group [post]
+func (c *Controller) CreateGatewayGroup(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create gateway group") {
+		return
+	}
+	doCreate(ctx, false, &model.GatewayGroup{}, "", gatewayGroupPreHooks...)
+}
+
+// DeleteGatewayGroup godoc
+//
+//	@Tags		gateway_group
+//	@Param		id	path		int	true	"gateway group id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/gateway_group/:id [delete]
+func (c *Controller) DeleteGatewayGroup(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete gateway group") {
+		return
+	}
+	doDelete(ctx, false, &model.GatewayGroup{}, "", gatewayGroupDcs...)
+}
+
+// UpdateGatewayGroup godoc
+//
+//	@Tags		gateway_group
+//	@Param		id		path		int					true	"gateway group id"
+//	@Param		group	body		model.GatewayGroup	true	"gateway group"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/gateway_group/:id [put]
+func (c *Controller) UpdateGatewayGroup(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update gateway group") {
+		return
+	}
+	doUpdate(ctx, false, &model.GatewayGroup{}, "", gatewayGroupPreHooks...)
+}
+
+// GetGatewayGroups godoc
+//
+//	@Tags		gateway_group
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"gateway group id"
+//	@Param		strategy	query		string	false	"strategy, round_robin or least_sessions"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.GatewayGroup}}
+//	@Router		/gateway_group [get]
+func (c *Controller) GetGatewayGroups(ctx *gin.Context) {
+	db := mysql.DB.Model(model.DefaultGatewayGroup)
+	db = filterSearch(ctx, db, "name", "comment")
+	db = filterEqual(ctx, db, "id", "strategy")
+	db = db.Order("name")
+
+	doGet(ctx, false, db, "", gatewayGroupPostHooks...)
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/health"
+	"github.com/veops/oneterm/model"
+)
+
+// GetAssetHealth godoc
+//
+//	@Tags		asset
+//	@Param		id			path		int		true	"asset id"
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		protocol	query		string	false	"protocol"
+//	@Param		reachable	query		bool	false	"reachable"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.AssetHealth}}
+//	@Router		/asset/:id/health [get]
+func (c *Controller) GetAssetHealth(ctx *gin.Context) {
+	id := cast.ToInt(ctx.Param("id"))
+	if !canAccessAsset(ctx, id) {
+		return
+	}
+
+	db := mysql.AuditDB.Model(model.DefaultAssetHealth).Where("asset_id = ?", id)
+	db = filterEqual(ctx, db, "protocol")
+	if q, ok := ctx.GetQuery("reachable"); ok {
+		db = db.Where("reachable = ?", cast.ToBool(q))
+	}
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = db.Order("id DESC")
+
+	doGet[*model.AssetHealth](ctx, false, db, "")
+}
+
+// CheckAssetHealth godoc
+//
+//	@Tags		asset
+//	@Param		id	path		int	true	"asset id"
+//	@Success	200	{object}	HttpResponse{data=model.Health}
+//	@Router		/asset/:id/health [post]
+func (c *Controller) CheckAssetHealth(ctx *gin.Context) {
+	id := cast.ToInt(ctx.Param("id"))
+	if !canAccessAsset(ctx, id) {
+		return
+	}
+
+	asset := &model.Asset{}
+	if err := health.Check(id); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	if err := mysql.DB.Model(asset).Where("id = ?", id).First(asset).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(asset.Health))
+}
+
+// canAccessAsset aborts if the current user is neither an admin nor authorized to the asset
+func canAccessAsset(ctx *gin.Context, id int) bool {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if acl.IsAdmin(currentUser) {
+		return true
+	}
+	ids, err := GetAssetIdsByAuthorization(ctx)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return false
+	}
+	if !lo.Contains(ids, id) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": acl.READ}})
+		return false
+	}
+	return true
+}
@@ -43,9 +43,7 @@ func (c *Controller) GetHistories(ctx *gin.Context) {
 //	@Success	200	{object}	HttpResponse{data=map[string]string}
 //	@Router		/history/type/mapping [get]
 func (c *Controller) GetHistoryTypeMapping(ctx *gin.Context) {
-	lang := ctx.PostForm("lang")
-	accept := ctx.GetHeader("Accept-Language")
-	localizer := i18n.NewLocalizer(myi18n.Bundle, lang, accept)
+	localizer := newLocalizer(ctx)
 	cfg := &i18n.LocalizeConfig{}
 	key2msg := map[string]*i18n.Message{
 		"account":    myi18n.MsgTypeMappingAccount,
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/importer"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/schedule"
+	"github.com/veops/oneterm/usage"
+	"github.com/veops/oneterm/util"
+)
+
+type ImportResult struct {
+	Gateways int                  `json:"gateways"`
+	Accounts int                  `json:"accounts"`
+	Assets   int                  `json:"assets"`
+	Errors   []*importer.RowError `json:"errors"`
+}
+
+// Import godoc
+//
+//	@Tags		import
+//	@Param		file	formData	file	true	"csv or xlsx, rows of gateways, accounts and assets"
+//	@Param		dry_run	query		bool	false	"validate only"
+//	@Success	200		{object}	HttpResponse{data=ImportResult}
+//	@Failure	400		{object}	HttpResponse{data=ImportResult}	"nothing is created if any row is invalid"
+//	@Router		/import [post]
+func (c *Controller) Import(ctx *gin.Context) {
+	if !checkAdmin(ctx, "import") {
+		return
+	}
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	f, fh, err := ctx.Request.FormFile("file")
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	defer f.Close()
+	sheets, err := importer.Read(fh.Filename, f, fh.Size)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	plan, errs, err := importer.Parse(sheets)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	res := &ImportResult{Gateways: len(plan.Gateways), Accounts: len(plan.Accounts), Assets: len(plan.Assets), Errors: errs}
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusBadRequest, HttpResponse{Code: ErrInvalidArgument, Message: fmt.Sprintf("%d invalid rows", len(errs)), Data: res})
+		return
+	}
+	if len(plan.Assets) > 0 {
+		if err = usage.CheckAsset(len(plan.Assets)); err != nil {
+			ctx.AbortWithError(http.StatusForbidden, licenseError(err))
+			return
+		}
+	}
+	if cast.ToBool(ctx.Query("dry_run")) {
+		ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+		return
+	}
+
+	// acl resources are out of the transaction, so they are deleted if it fails
+	resourceIds := make([]int, 0)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, id := range resourceIds {
+			if e := acl.DeleteResource(ctx, currentUser.GetUid(), id); e != nil {
+				logger.L().Warn("delete acl resource of failed import failed", zap.Int("resourceId", id), zap.Error(e))
+			}
+		}
+	}()
+	mds := make([]model.Model, 0)
+	for _, g := range plan.Gateways {
+		mds = append(mds, g)
+	}
+	for _, a := range plan.Accounts {
+		mds = append(mds, a)
+	}
+	for _, a := range plan.Assets {
+		mds = append(mds, a.Asset)
+	}
+	for _, md := range mds {
+		resourceType := map[string]string{
+			model.DefaultGateway.TableName(): conf.RESOURCE_GATEWAY,
+			model.DefaultAccount.TableName(): conf.RESOURCE_ACCOUNT,
+			model.DefaultAsset.TableName():   conf.RESOURCE_ASSET,
+		}[md.TableName()]
+		resourceId := 0
+		if resourceId, err = acl.CreateGrantAcl(ctx, currentUser, resourceType, md.GetName()); err != nil {
+			handleRemoteErr(ctx, err)
+			return
+		}
+		resourceIds = append(resourceIds, resourceId)
+		md.SetResourceId(resourceId)
+		md.SetCreatorId(currentUser.GetUid())
+		md.SetUpdaterId(currentUser.GetUid())
+	}
+
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultGateway)
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAccount)
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+	if err = mysql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		gatewayIds, accountIds := map[string]int{}, map[string]int{}
+		if err = importIds(tx, model.DefaultGateway, gatewayIds); err != nil {
+			return
+		}
+		if err = importIds(tx, model.DefaultAccount, accountIds); err != nil {
+			return
+		}
+		for _, g := range plan.Gateways {
+			g.Password, g.Pk, g.Phrase = util.EncryptAES(g.Password), util.EncryptAES(g.Pk), util.EncryptAES(g.Phrase)
+			if err = tx.Create(g).Error; err != nil {
+				return
+			}
+			gatewayIds[g.Name] = g.Id
+		}
+		for _, a := range plan.Accounts {
+			a.Password, a.Pk, a.Phrase = util.EncryptAES(a.Password), util.EncryptAES(a.Pk), util.EncryptAES(a.Phrase)
+			if err = tx.Create(a).Error; err != nil {
+				return
+			}
+			accountIds[a.Name] = a.Id
+		}
+		for _, a := range plan.Assets {
+			a.GatewayId = gatewayIds[a.Gateway]
+			for _, name := range a.Accounts {
+				a.Authorization[accountIds[name]] = model.Slice[int]{}
+			}
+			if err = tx.Create(a.Asset).Error; err != nil {
+				return
+			}
+			if err = handleAuthorization(ctx, tx, model.ACTION_CREATE, a.Asset); err != nil {
+				return
+			}
+		}
+
+		now := time.Now()
+		return tx.Create(lo.Map(mds, func(md model.Model, _ int) *model.History {
+			return &model.History{
+				RemoteIp:   ctx.ClientIP(),
+				Type:       md.TableName(),
+				TargetId:   md.GetId(),
+				ActionType: model.ACTION_CREATE,
+				New:        toMap(md),
+				CreatorId:  currentUser.GetUid(),
+				CreatedAt:  now,
+			}
+		})).Error
+	}); err != nil {
+		handleRemoteErr(ctx, err)
+		return
+	}
+
+	schedule.UpdateConnectables(lo.Map(plan.Assets, func(a *importer.Asset, _ int) int { return a.Id })...)
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// importIds maps names of existing ones to their ids
+func importIds(tx *gorm.DB, md model.Model, ids map[string]int) (err error) {
+	rows := make([]*struct {
+		Id   int
+		Name string
+	}, 0)
+	if err = tx.Model(md).Select("id", "name").Find(&rows).Error; err != nil {
+		return
+	}
+	for _, r := range rows {
+		ids[r.Name] = r.Id
+	}
+	return
+}
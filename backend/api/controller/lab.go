@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/lab"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	labTemplatePreHooks = []preHook[*model.LabTemplate]{
+		func(ctx *gin.Context, data *model.LabTemplate) {
+			if data.ProvisionUrl == "" || data.AccountId == 0 || len(data.Protocols) == 0 {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "provision url, account and protocols are required"}})
+				return
+			}
+			// tokens are never returned, so the saved one is kept if it is not given on update
+			if id, ok := ctx.Params.Get("id"); ok && data.Token == "" {
+				old := &model.LabTemplate{}
+				if err := mysql.DB.Model(old).Where("id = ?", cast.ToInt(id)).First(old).Error; err == nil {
+					data.Token = old.Token
+				}
+				return
+			}
+			data.Token = util.EncryptAES(data.Token)
+		},
+	}
+	labTemplatePostHooks = []postHook[*model.LabTemplate]{
+		func(ctx *gin.Context, data []*model.LabTemplate) {
+			for _, d := range data {
+				d.Token = ""
+			}
+		},
+	}
+)
+
+// CreateLabTemplate godoc
+//
+//	@Tags		lab_template
+//	@Param		template	body		model.LabTemplate	true	"lab template"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/lab_template [post]
+func (c *Controller) CreateLabTemplate(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create lab template") {
+		return
+	}
+	doCreate(ctx, false, &model.LabTemplate{}, "", labTemplatePreHooks...)
+}
+
+// DeleteLabTemplate godoc
+//
+//	@Tags		lab_template
+//	@Param		id	path		int	true	"lab template id"
+//	@Success	200	{object}	HttpResponse	"labs provisioned expire as usual"
+//	@Router		/lab_template/:id [delete]
+func (c *Controller) DeleteLabTemplate(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete lab template") {
+		return
+	}
+	doDelete(ctx, false, &model.LabTemplate{}, "")
+}
+
+// UpdateLabTemplate godoc
+//
+//	@Tags		lab_template
+//	@Param		id			path		int					true	"lab template id"
+//	@Param		template	body		model.LabTemplate	true	"lab template, the saved token is kept if it is empty"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/lab_template/:id [put]
+func (c *Controller) UpdateLabTemplate(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update lab template") {
+		return
+	}
+	doUpdate(ctx, false, &model.LabTemplate{}, "", labTemplatePreHooks...)
+}
+
+// GetLabTemplates godoc
+//
+//	@Tags		lab_template
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"lab template id"
+//	@Param		enable		query		int		false	"lab template enable, admins only"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.LabTemplate}}
+//	@Router		/lab_template [get]
+func (c *Controller) GetLabTemplates(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.DB.Model(model.DefaultLabTemplate)
+	db = filterSearch(ctx, db, "name", "comment")
+	db = filterEqual(ctx, db, "id")
+	if acl.IsAdmin(currentUser) {
+		db = filterEqual(ctx, db, "enable")
+	} else {
+		db = db.Where("enable = ?", true)
+	}
+
+	doGet(ctx, false, db, "", labTemplatePostHooks...)
+}
+
+// GetLabs godoc
+//
+//	@Tags		lab
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		id			query		int		false	"lab id"
+//	@Param		template_id	query		int		false	"lab template id"
+//	@Param		uid			query		int		false	"requester, admins only"
+//	@Param		status		query		string	false	"provisioning, ready, failed or expired"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Lab}}
+//	@Router		/lab [get]
+func (c *Controller) GetLabs(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.DB.Model(model.DefaultLab)
+	db = filterEqual(ctx, db, "id", "template_id", "status")
+	if acl.IsAdmin(currentUser) {
+		db = filterEqual(ctx, db, "uid")
+	} else {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+	db = db.Order("id DESC")
+
+	doGet[*model.Lab](ctx, false, db, "")
+}
+
+// LabWebhook godoc
+//
+//	@Tags		lab
+//	@Param		X-Oneterm-Token	header		string		true	"token of the lab template"
+//	@Param		report			body		lab.Report	true	"external id, ip and readiness of the lab, or the error of provisioning"
+//	@Success	200				{object}	HttpResponse
+//	@Router		/lab/webhook [post]
+func (c *Controller) LabWebhook(ctx *gin.Context) {
+	r := &lab.Report{}
+	if err := ctx.ShouldBindBodyWithJSON(r); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	tpl := &model.LabTemplate{}
+	if err := mysql.DB.Unscoped().Model(tpl).
+		Where("id = (?)", mysql.DB.Model(model.DefaultLab).Select("template_id").Where("id = ?", r.LabId)).
+		First(tpl).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	token := ""
+	if tpl.Token != "" {
+		token = util.DecryptAES(tpl.Token)
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(ctx.GetHeader("X-Oneterm-Token")), []byte(token)) != 1 {
+		ctx.AbortWithError(http.StatusUnauthorized, &ApiError{Code: ErrUnauthorized, Data: map[string]any{"err": fmt.Errorf("invalid webhook token")}})
+		return
+	}
+
+	if err := lab.Apply(ctx, tpl, r); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
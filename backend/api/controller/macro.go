@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+const (
+	// macroInterval is how often the next line of a running macro is tried
+	macroInterval = time.Millisecond * 100
+)
+
+var (
+	macroPreHooks = []preHook[*model.Macro]{
+		func(ctx *gin.Context, data *model.Macro) {
+			currentUser, _ := acl.GetSessionFromCtx(ctx)
+			if data.Public && !acl.IsAdmin(currentUser) {
+				ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "public macro"}})
+				return
+			}
+			data.Uid, data.UserName = currentUser.GetUid(), currentUser.GetUserName()
+			if ctx.Param("id") == "" {
+				return
+			}
+			// the owner is kept when admins update macros of others
+			old := &model.Macro{}
+			if err := mysql.DB.Model(old).Where("id = ?", ctx.Param("id")).First(old).Error; err == nil {
+				data.Uid, data.UserName = old.Uid, old.UserName
+			}
+			checkMacroOwner(ctx, old)
+		},
+		func(ctx *gin.Context, data *model.Macro) {
+			if strings.TrimSpace(data.Content) == "" {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "empty content"}})
+			}
+		},
+	}
+	macroDcs = []deleteCheck{
+		func(ctx *gin.Context, id int) {
+			old := &model.Macro{}
+			if err := mysql.DB.Model(old).Where("id = ?", id).First(old).Error; err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+				return
+			}
+			checkMacroOwner(ctx, old)
+		},
+	}
+)
+
+// checkMacroOwner only lets owners and admins modify macros
+func checkMacroOwner(ctx *gin.Context, m *model.Macro) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if m.Id != 0 && m.Uid != currentUser.GetUid() && !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "macro of others"}})
+	}
+}
+
+// CreateMacro godoc
+//
+//	@Tags		macro
+//	@Param		macro	body		model.Macro	true	"macro"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/macro [post]
+func (c *Controller) CreateMacro(ctx *gin.Context) {
+	doCreate(ctx, false, &model.Macro{}, "", macroPreHooks...)
+}
+
+// DeleteMacro godoc
+//
+//	@Tags		macro
+//	@Param		id	path		int	true	"macro id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/macro/:id [delete]
+func (c *Controller) DeleteMacro(ctx *gin.Context) {
+	doDelete(ctx, false, &model.Macro{}, "", macroDcs...)
+}
+
+// UpdateMacro godoc
+//
+//	@Tags		macro
+//	@Param		id		path		int			true	"macro id"
+//	@Param		macro	body		model.Macro	true	"macro"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/macro/:id [put]
+func (c *Controller) UpdateMacro(ctx *gin.Context) {
+	doUpdate(ctx, false, &model.Macro{}, "", macroPreHooks...)
+}
+
+// GetMacros godoc
+//
+//	@Tags		macro
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or content"
+//	@Param		id			query		int		false	"macro id"
+//	@Param		public		query		int		false	"public macro"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Macro}}
+//	@Router		/macro [get]
+func (c *Controller) GetMacros(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.DB.Model(&model.Macro{})
+	db = filterSearch(ctx, db, "name", "content")
+	db = filterEqual(ctx, db, "id", "public")
+	if !acl.IsAdmin(currentUser) {
+		db = db.Where("uid = ? OR public = ?", currentUser.GetUid(), true)
+	}
+
+	doGet[*model.Macro](ctx, false, db, "")
+}
+
+// macroReq is the control frame of web clients running a macro, it is sent as m{"id":1,"vars":{"host":"a"}}
+type macroReq struct {
+	Id   int               `json:"id"`
+	Vars map[string]string `json:"vars"`
+}
+
+// macroInputs returns lines of the macro in msg as inputs of web clients,
+// they are typed one by one so that every line passes command filters like typed by hand
+func macroInputs(sess *gsession.Session, msg []byte) (inputs [][]byte, name string, err error) {
+	req := &macroReq{}
+	if err = json.Unmarshal(msg, req); err != nil {
+		return
+	}
+	m := &model.Macro{}
+	if err = mysql.DB.Model(m).Where("id = ? AND (uid = ? OR public = ?)", req.Id, sess.Uid, true).First(m).Error; err != nil {
+		return nil, "", fmt.Errorf("macro %d is not found", req.Id)
+	}
+	lines, err := m.Render(req.Vars)
+	if err != nil {
+		return
+	}
+	for _, l := range lines {
+		inputs = append(inputs, []byte("1"+l+"\r"))
+	}
+	return inputs, m.Name, nil
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/approval"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+// MaintenanceNotice is sent to approvers like CommandApprovalNotice when a window is planned, Contacts are of the
+// assets it covers so that they are told of the maintenance
+type MaintenanceNotice struct {
+	MaintenanceWindow *model.MaintenanceWindow `json:"maintenance_window"`
+	Contacts          []model.Contact          `json:"contacts"`
+}
+
+var (
+	maintenanceWindowPreHooks = []preHook[*model.MaintenanceWindow]{
+		func(ctx *gin.Context, data *model.MaintenanceWindow) {
+			if !data.Start.Before(data.End) {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "start must be before end"}})
+			}
+		},
+	}
+)
+
+// CreateMaintenanceWindow godoc
+//
+//	@Tags		maintenance_window
+//	@Param		window	body		model.MaintenanceWindow	true	"maintenance window"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/maintenance_window [post]
+func (c *Controller) CreateMaintenanceWindow(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create maintenance window") {
+		return
+	}
+	window := &model.MaintenanceWindow{}
+	if err := doCreate(ctx, false, window, "", maintenanceWindowPreHooks...); err == nil && !ctx.IsAborted() {
+		notifyMaintenance(window)
+	}
+}
+
+// DeleteMaintenanceWindow godoc
+//
+//	@Tags		maintenance_window
+//	@Param		id	path		int	true	"maintenance window id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/maintenance_window/:id [delete]
+func (c *Controller) DeleteMaintenanceWindow(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete maintenance window") {
+		return
+	}
+	doDelete(ctx, false, &model.MaintenanceWindow{}, "")
+}
+
+// UpdateMaintenanceWindow godoc
+//
+//	@Tags		maintenance_window
+//	@Param		id		path		int						true	"maintenance window id"
+//	@Param		window	body		model.MaintenanceWindow	true	"maintenance window"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/maintenance_window/:id [put]
+func (c *Controller) UpdateMaintenanceWindow(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update maintenance window") {
+		return
+	}
+	window := &model.MaintenanceWindow{}
+	if err := doUpdate(ctx, false, window, "", maintenanceWindowPreHooks...); err == nil && !ctx.IsAborted() {
+		window.Id = cast.ToInt(ctx.Param("id"))
+		notifyMaintenance(window)
+	}
+}
+
+// GetMaintenanceWindows godoc
+//
+//	@Tags		maintenance_window
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"maintenance window id"
+//	@Param		enable		query		int		false	"maintenance window enable"
+//	@Param		active		query		bool	false	"only windows in effect now"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.MaintenanceWindow}}
+//	@Router		/maintenance_window [get]
+func (c *Controller) GetMaintenanceWindows(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get maintenance window") {
+		return
+	}
+
+	db := mysql.DB.Model(&model.MaintenanceWindow{})
+	db = filterEqual(ctx, db, "id", "enable")
+	db = filterSearch(ctx, db, "name", "comment")
+	if cast.ToBool(ctx.Query("active")) {
+		now := time.Now()
+		db = db.Where("enable = ? AND `start` <= ? AND `end` > ?", true, now, now)
+	}
+
+	doGet[*model.MaintenanceWindow](ctx, false, db, "")
+}
+
+// notifyMaintenance tells contacts of assets covered by the enabled window in the background
+func notifyMaintenance(window *model.MaintenanceWindow) {
+	if !window.Enable {
+		return
+	}
+	go func() {
+		assets := make([]*model.Asset, 0)
+		if err := mysql.DB.Model(model.DefaultAsset).Select("id", "parent_id", "owners", "contacts").Find(&assets).Error; err != nil {
+			logger.L().Error("get contacts of maintenance failed", zap.Int("id", window.Id), zap.Error(err))
+			return
+		}
+		nodes := make([]*model.Node, 0)
+		if err := mysql.DB.Model(model.DefaultNode).Select("id", "parent_id").Find(&nodes).Error; err != nil {
+			logger.L().Error("get contacts of maintenance failed", zap.Int("id", window.Id), zap.Error(err))
+			return
+		}
+		parents := lo.SliceToMap(nodes, func(n *model.Node) (int, int) { return n.Id, n.ParentId })
+
+		contacts := make([]model.Contact, 0)
+		for _, a := range assets {
+			nodeIds := make([]int, 0)
+			for id := a.ParentId; id != 0 && !lo.Contains(nodeIds, id); id = parents[id] {
+				nodeIds = append(nodeIds, id)
+			}
+			if window.CoversAsset(a.Id, nodeIds) {
+				contacts = append(contacts, a.Contacts...)
+			}
+		}
+		approval.Notify(&MaintenanceNotice{
+			MaintenanceWindow: window,
+			Contacts:          lo.UniqBy(contacts, func(c model.Contact) string { return fmt.Sprintf("%s:%d", c.Type, c.Id) }),
+		})
+	}()
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/stepup"
+)
+
+var (
+	mfaPolicyPreHooks = []preHook[*model.MfaPolicy]{
+		func(ctx *gin.Context, data *model.MfaPolicy) {
+			if data.Grace < 0 {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "grace must not be negative"}})
+			}
+		},
+	}
+)
+
+type connectMfaReq struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type connectMfa struct {
+	Required bool `json:"required"`
+	// Enrolled tells whether the user has a totp credential to verify with
+	Enrolled bool `json:"enrolled"`
+	Grace    int  `json:"grace"`
+}
+
+// checkMfa refuses connections to the asset until the user verifies a totp code if any mfa policy covers them
+func checkMfa(ctx context.Context, uid, rid, assetId int) (err error) {
+	policy, err := stepup.ConnectPolicy(uid, rid, assetId)
+	if err != nil || policy == nil {
+		return
+	}
+	ok, err := stepup.ConnectVerified(ctx, uid, assetId, policy)
+	if err == nil && !ok {
+		err = &ApiError{Code: ErrMfa, Data: map[string]any{"asset_id": assetId}}
+	}
+	return
+}
+
+// CreateMfaPolicy godoc
+//
+//	@Tags		mfa_policy
+//	@Param		policy	body		model.MfaPolicy	true	"mfa policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/mfa_policy [post]
+func (c *Controller) CreateMfaPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create mfa policy") {
+		return
+	}
+	doCreate(ctx, false, &model.MfaPolicy{}, "", mfaPolicyPreHooks...)
+}
+
+// DeleteMfaPolicy godoc
+//
+//	@Tags		mfa_policy
+//	@Param		id	path		int	true	"mfa policy id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/mfa_policy/:id [delete]
+func (c *Controller) DeleteMfaPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete mfa policy") {
+		return
+	}
+	doDelete(ctx, false, &model.MfaPolicy{}, "")
+}
+
+// UpdateMfaPolicy godoc
+//
+//	@Tags		mfa_policy
+//	@Param		id		path		int				true	"mfa policy id"
+//	@Param		policy	body		model.MfaPolicy	true	"mfa policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/mfa_policy/:id [put]
+func (c *Controller) UpdateMfaPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update mfa policy") {
+		return
+	}
+	doUpdate(ctx, false, &model.MfaPolicy{}, "", mfaPolicyPreHooks...)
+}
+
+// GetMfaPolicies godoc
+//
+//	@Tags		mfa_policy
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"mfa policy id"
+//	@Param		enable		query		int		false	"mfa policy enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.MfaPolicy}}
+//	@Router		/mfa_policy [get]
+func (c *Controller) GetMfaPolicies(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get mfa policy") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultMfaPolicy)
+	db = filterEqual(ctx, db, "id", "enable")
+	db = filterSearch(ctx, db, "name", "comment")
+
+	doGet[*model.MfaPolicy](ctx, false, db, "")
+}
+
+// GetConnectMfa godoc
+//
+//	@Tags		connect
+//	@Param		asset_id	path		int	true	"asset id"
+//	@Success	200			{object}	HttpResponse{data=connectMfa}	"whether connecting to the asset needs a totp verification first"
+//	@Router		/connect/mfa/:asset_id [get]
+func (c *Controller) GetConnectMfa(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	policy, err := stepup.ConnectPolicy(currentUser.GetUid(), currentUser.GetRid(), cast.ToInt(ctx.Param("asset_id")))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	cnt := int64(0)
+	if err = mysql.DB.Model(model.DefaultStepUpCredential).Where("uid = ? AND method = ?", currentUser.GetUid(), model.STEPUPMETHOD_TOTP).Count(&cnt).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(&connectMfa{
+		Required: policy != nil,
+		Enrolled: cnt > 0,
+		Grace:    lo.TernaryF(policy != nil, func() int { return policy.Grace }, func() int { return 0 }),
+	}))
+}
+
+// CreateConnectMfa godoc
+//
+//	@Tags		connect
+//	@Param		asset_id	path		int				true	"asset id"
+//	@Param		code		body		connectMfaReq	true	"totp code"
+//	@Success	200			{object}	HttpResponse	"connect to the asset within the grace of the policy"
+//	@Router		/connect/mfa/:asset_id [post]
+func (c *Controller) CreateConnectMfa(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	uid, assetId := currentUser.GetUid(), cast.ToInt(ctx.Param("asset_id"))
+
+	req := &connectMfaReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	policy, err := stepup.ConnectPolicy(uid, currentUser.GetRid(), assetId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	if policy == nil {
+		ctx.JSON(http.StatusOK, defaultHttpResponse)
+		return
+	}
+	res, err := stepup.Verify(ctx, uid, currentUser.GetUserName(), ctx.ClientIP(), requestHost(ctx), &stepup.Proof{Method: model.STEPUPMETHOD_TOTP, Code: req.Code})
+	if err == nil {
+		// the step up is used up by the connection right now, so it could not be replayed for recordings
+		err = stepup.Use(uid, res.Id)
+	}
+	if err == nil {
+		err = stepup.RememberConnect(ctx, uid, assetId, policy)
+	}
+	if err != nil {
+		code := lo.Ternary(errors.Is(err, stepup.ErrFailed), http.StatusBadRequest, http.StatusInternalServerError)
+		ctx.AbortWithError(code, &ApiError{Code: lo.Ternary(code == http.StatusBadRequest, ErrMfa, ErrInternal), Data: map[string]any{"asset_id": assetId, "err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
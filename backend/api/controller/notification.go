@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/notify"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	notificationPreHooks = []preHook[*model.Notification]{
+		func(ctx *gin.Context, data *model.Notification) {
+			if err := notify.Validate(data); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+				return
+			}
+			// secrets are never returned, so the saved one is kept if it is not given on update
+			if id, ok := ctx.Params.Get("id"); ok && data.Secret == "" {
+				old := &model.Notification{}
+				if err := mysql.DB.Model(old).Where("id = ?", cast.ToInt(id)).First(old).Error; err == nil {
+					data.Secret = old.Secret
+				}
+				return
+			}
+			if data.Secret != "" {
+				data.Secret = util.EncryptAES(data.Secret)
+			}
+		},
+	}
+	notificationPostHooks = []postHook[*model.Notification]{
+		func(ctx *gin.Context, data []*model.Notification) {
+			for _, d := range data {
+				d.Secret = ""
+			}
+		},
+	}
+)
+
+// CreateNotification godoc
+//
+//	@Tags		notification
+//	@Param		notification	body		model.Notification	true	"notification"
+//	@Success	200				{object}	HttpResponse
+//	@Router		/notification [post]
+func (c *Controller) CreateNotification(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create notification") {
+		return
+	}
+	doCreate(ctx, false, &model.Notification{}, "", notificationPreHooks...)
+}
+
+// DeleteNotification godoc
+//
+//	@Tags		notification
+//	@Param		id	path		int	true	"notification id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/notification/:id [delete]
+func (c *Controller) DeleteNotification(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete notification") {
+		return
+	}
+	doDelete(ctx, false, &model.Notification{}, "")
+}
+
+// UpdateNotification godoc
+//
+//	@Tags		notification
+//	@Param		id				path		int					true	"notification id"
+//	@Param		notification	body		model.Notification	true	"notification, the saved secret is kept if it is empty"
+//	@Success	200				{object}	HttpResponse
+//	@Router		/notification/:id [put]
+func (c *Controller) UpdateNotification(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update notification") {
+		return
+	}
+	doUpdate(ctx, false, &model.Notification{}, "", notificationPreHooks...)
+}
+
+// GetNotifications godoc
+//
+//	@Tags		notification
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"notification id"
+//	@Param		channel		query		string	false	"dingtalk, feishu or slack"
+//	@Param		enable		query		int		false	"notification enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Notification}}
+//	@Router		/notification [get]
+func (c *Controller) GetNotifications(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get notification") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultNotification)
+	db = filterSearch(ctx, db, "name", "comment")
+	db = filterEqual(ctx, db, "id", "channel", "enable")
+
+	doGet(ctx, false, db, "", notificationPostHooks...)
+}
+
+// TestNotification godoc
+//
+//	@Tags		notification
+//	@Param		id	path		int	true	"notification id"
+//	@Success	200	{object}	HttpResponse	"a test message is sent to the bot"
+//	@Router		/notification/:id/test [post]
+func (c *Controller) TestNotification(ctx *gin.Context) {
+	if !checkAdmin(ctx, "test notification") {
+		return
+	}
+
+	n := &model.Notification{}
+	if err := mysql.DB.Model(n).Where("id = ?", cast.ToInt(ctx.Param("id"))).First(n).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if err := notify.Test(ctx, n); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/samber/lo"
+	"golang.org/x/text/language"
+	"gorm.io/gorm/clause"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	myi18n "github.com/veops/oneterm/i18n"
+	"github.com/veops/oneterm/model"
+)
+
+// GetPreference godoc
+//
+//	@Tags		preference
+//	@Success	200	{object}	HttpResponse{data=model.Preference}	"empty fields fall back to lang of requests and the timezone of the server"
+//	@Router		/preference [get]
+func (c *Controller) GetPreference(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(getPreference(ctx)))
+}
+
+// UpdatePreference godoc
+//
+//	@Tags		preference
+//	@Param		preference	body		model.Preference	true	"lang is en or zh, timezone is an iana name e.g. Asia/Shanghai"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/preference [put]
+func (c *Controller) UpdatePreference(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	data := &model.Preference{}
+	if err := ctx.ShouldBindBodyWithJSON(data); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	tags := lo.Map(myi18n.Bundle.LanguageTags(), func(t language.Tag, _ int) string { return t.String() })
+	if data.Lang != "" && !lo.Contains(tags, data.Lang) {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("lang %s is not one of %v", data.Lang, tags)}})
+		return
+	}
+	if _, err := time.LoadLocation(data.Timezone); data.Timezone != "" && err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	data.Id, data.Uid = 0, currentUser.GetUid()
+	if err := mysql.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"lang", "timezone", "updated_at"}),
+	}).Create(data).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// getPreference returns the preference of the current user, it is an empty one if the user has not set it
+func getPreference(ctx *gin.Context) *model.Preference {
+	if p, ok := ctx.Value("preference").(*model.Preference); ok {
+		return p
+	}
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	p := &model.Preference{}
+	if currentUser != nil {
+		mysql.DB.Model(p).Where("uid = ?", currentUser.GetUid()).Limit(1).Find(p)
+	}
+	ctx.Set("preference", p)
+	return p
+}
+
+// locale returns languages of the request in order of the preference, the lang form and Accept-Language, and the
+// timezone of the preference
+func locale(ctx *gin.Context) (langs []string, timezone string) {
+	p := getPreference(ctx)
+	return []string{p.Lang, ctx.PostForm("lang"), ctx.GetHeader("Accept-Language")}, p.Timezone
+}
+
+func newLocalizer(ctx *gin.Context) *i18n.Localizer {
+	langs, _ := locale(ctx)
+	return i18n.NewLocalizer(myi18n.Bundle, langs...)
+}
+
+// formatTime renders t for the current user, it is for text made by the server, json keeps times as they are
+func formatTime(ctx *gin.Context, t time.Time) string {
+	langs, timezone := locale(ctx)
+	return myi18n.FormatTime(t, timezone, langs...)
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/health"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/usage"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	PREFLIGHT_ACL       = "acl"
+	PREFLIGHT_TIME      = "time"
+	PREFLIGHT_FEATURE   = "feature"
+	PREFLIGHT_MFA       = "mfa"
+	PREFLIGHT_APPROVAL  = "approval"
+	PREFLIGHT_WARNING   = "warning"
+	PREFLIGHT_SLOT      = "slot"
+	PREFLIGHT_LICENSE   = "license"
+	PREFLIGHT_REACHABLE = "reachable"
+)
+
+const (
+	PREFLIGHT_ACTION_REQUEST_ACCESS = "request_access"
+	PREFLIGHT_ACTION_WAIT_APPROVAL  = "wait_approval"
+	PREFLIGHT_ACTION_VERIFY_MFA     = "verify_mfa"
+	PREFLIGHT_ACTION_CONFIRM        = "confirm"
+	PREFLIGHT_ACTION_QUEUE          = "queue"
+)
+
+// PreflightItem is one check of connecting, code and message are of the error connecting would fail with.
+// Action tells what the user could do about it, e.g. request access or verify a totp code
+type PreflightItem struct {
+	Name    string `json:"name"`
+	Ok      bool   `json:"ok"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Action  string `json:"action,omitempty"`
+}
+
+type Preflight struct {
+	Ok    bool             `json:"ok"`
+	Items []*PreflightItem `json:"items"`
+}
+
+// ConnectPreflight godoc
+//
+//	@Tags		connect
+//	@Param		asset_id	path		int		true	"asset id"
+//	@Param		account_id	path		int		true	"account id"
+//	@Param		protocol	path		string	true	"protocol"
+//	@Success	200			{object}	HttpResponse{data=Preflight}	"checks connecting goes through without creating a session, ok is false if any of them fails"
+//	@Router		/connect/preflight/:asset_id/:account_id/:protocol [get]
+func (c *Controller) ConnectPreflight(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	uid, rid := currentUser.GetUid(), currentUser.GetRid()
+	assetId, accountId, protocol := cast.ToInt(ctx.Param("asset_id")), cast.ToInt(ctx.Param("account_id")), strings.Split(ctx.Param("protocol"), ":")[0]
+
+	asset, _, gateway, err := util.GetAAG(assetId, accountId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if _, err = resolveProtocol(asset, ctx.Param("protocol")); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	res := &Preflight{Items: make([]*PreflightItem, 0)}
+	add := func(name string, err error, action string) {
+		item := &PreflightItem{Name: name, Ok: err == nil}
+		if ae := (&ApiError{}); errors.As(err, &ae) {
+			item.Code, item.Message, item.Action = ae.Code, ae.MessageWithCtx(ctx), action
+		} else if err != nil {
+			item.Code, item.Message, item.Action = ErrInternal, err.Error(), action
+		}
+		res.Items = append(res.Items, item)
+	}
+
+	// every check is done even if former ones fail, so that the user sees all of what is in the way at once
+	sess := &gsession.Session{Session: &model.Session{Uid: uid, AssetId: assetId, Asset: asset, AccountId: accountId}}
+	authorized := hasAuthorization(ctx, sess)
+	add(PREFLIGHT_ACL, lo.Ternary[error](authorized, nil, &ApiError{Code: ErrUnauthorized}), "")
+
+	var approvalErr error
+	action := ""
+	if !authorized {
+		approvalErr = &ApiError{Code: ErrUnauthorized}
+		if contacts := asset.Escalation(); len(contacts) > 0 {
+			approvalErr = &ApiError{Code: ErrNoAccess, Data: map[string]any{
+				"asset":    asset.Name,
+				"contacts": strings.Join(lo.Map(contacts, func(c model.Contact, _ int) string { return c.Name }), ", "),
+			}}
+		}
+		var pending int64
+		mysql.DB.Model(model.DefaultAccessRequest).
+			Where("uid = ? AND asset_id = ? AND account_id = ? AND status = ?", uid, assetId, accountId, model.APPROVAL_STATUS_PENDING).
+			Count(&pending)
+		action = lo.Ternary(pending > 0, PREFLIGHT_ACTION_WAIT_APPROVAL, PREFLIGHT_ACTION_REQUEST_ACCESS)
+	}
+	add(PREFLIGHT_APPROVAL, approvalErr, action)
+
+	add(PREFLIGHT_TIME, lo.Ternary[error](checkTime(asset.AccessAuth), nil, &ApiError{Code: ErrAccessTime}), "")
+	add(PREFLIGHT_FEATURE, checkFeature("protocol."+protocol, uid, rid), "")
+	add(PREFLIGHT_MFA, checkMfa(ctx, uid, rid, assetId), PREFLIGHT_ACTION_VERIFY_MFA)
+
+	titles, err := needConfirm(ctx, assetId)
+	if err == nil && len(titles) > 0 {
+		err = &ApiError{Code: ErrAssetWarning, Data: map[string]any{"warnings": strings.Join(titles, "; ")}}
+	}
+	add(PREFLIGHT_WARNING, err, PREFLIGHT_ACTION_CONFIRM)
+
+	// slots are only looked at, they may be taken by others before connecting
+	err, action = nil, ""
+	if cfg := asset.Concurrency; cfg.Max > 0 {
+		if used, queued := gsession.SlotUsage(assetId); used+queued >= cfg.Max {
+			err = &ApiError{Code: ErrSessionLimit, Data: map[string]any{"asset_id": assetId, "max": cfg.Max}}
+			action = lo.Ternary(cfg.Queue, PREFLIGHT_ACTION_QUEUE, "")
+		}
+	}
+	add(PREFLIGHT_SLOT, err, action)
+	add(PREFLIGHT_LICENSE, licenseError(usage.CheckSession(uid)), "")
+
+	err = nil
+	if h := health.Probe(asset, gateway, protocol); !h.Reachable {
+		err = &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": h.Message}}
+	}
+	add(PREFLIGHT_REACHABLE, err, "")
+
+	res.Ok = lo.EveryBy(res.Items, func(item *PreflightItem) bool { return item.Ok })
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/api/guacd"
+	"github.com/veops/oneterm/logger"
+	gsession "github.com/veops/oneterm/session"
+)
+
+type Thumbnail struct {
+	SessionId string `json:"session_id"`
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+}
+
+// ConnectThumbnail godoc
+//
+//	@Tags		connect
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		interval	query		int		false	"seconds between two thumbnails, default 5"
+//	@Param		lines		query		int		false	"last lines of ssh session, default 10"
+//	@Param		cols		query		int		false	"columns of ssh session, default 120"
+//	@Param		width		query		int		false	"image width of rdp and vnc session, default 320"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/connect/thumbnail/:session_id [get]
+func (c *Controller) ConnectThumbnail(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "monitor session"}})
+		return
+	}
+
+	sessionId := ctx.Param("session_id")
+	if forward(ctx, sessionId) {
+		return
+	}
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+
+	errChan := make(chan error, 2)
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	var display *guacd.Display
+	if sess.IsGuacd() {
+		t, err := guacd.NewDisplayTunnel(sess.ConnectionId, 1024, 768, 96)
+		if err != nil {
+			logger.L().Error("guacd tunnel failed", zap.Error(err))
+			return
+		}
+		defer t.Disconnect()
+		display = guacd.NewDisplay()
+		go func() {
+			errChan <- display.Run(t)
+		}()
+	}
+
+	interval := cast.ToInt(ctx.Query("interval"))
+	tk := time.NewTicker(time.Second * time.Duration(max(interval, 1)))
+	if interval <= 0 {
+		tk.Reset(time.Second * 5)
+	}
+	defer tk.Stop()
+	for {
+		select {
+		case err = <-errChan:
+			logger.L().Debug("thumbnail stopped", zap.String("sessionId", sessionId), zap.Error(err))
+			return
+		case <-sess.Chans.AwayChan:
+			return
+		case <-tk.C:
+			tn, err := getThumbnail(ctx, sess, display)
+			if err != nil {
+				logger.L().Warn("get thumbnail failed", zap.String("sessionId", sessionId), zap.Error(err))
+				continue
+			}
+			if err = ws.WriteJSON(tn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func getThumbnail(ctx *gin.Context, sess *gsession.Session, display *guacd.Display) (tn *Thumbnail, err error) {
+	tn = &Thumbnail{SessionId: sess.SessionId}
+	if display == nil {
+		cols, lines := cast.ToInt(ctx.Query("cols")), cast.ToInt(ctx.Query("lines"))
+		tn.Type = "text"
+		tn.Content = strings.Join(sess.Tail.Lines(lo.Ternary(cols > 0, cols, 120), lo.Ternary(lines > 0, lines, 10)), "\n")
+		return
+	}
+
+	width := cast.ToInt(ctx.Query("width"))
+	img := display.Thumbnail(lo.Ternary(width > 0, width, 320))
+	if img.Bounds().Empty() {
+		err = fmt.Errorf("empty display")
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 60}); err != nil {
+		return
+	}
+	tn.Type = "image/jpeg"
+	tn.Content = base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	return
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	// probeCmd only reads, each field is on its own line in the order of parseHostInfo
+	probeCmd = "uname -s; uname -r; uname -v; uname -m; hostname"
+)
+
+// probeHost runs probeCmd in an exec channel apart from the shell, so it is neither shown to users nor recorded as a command
+func probeHost(cli *gossh.Client) (info *model.HostInfo, err error) {
+	sess, err := cli.NewSession()
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(max(conf.Cfg.Probe.Timeout, 1)))
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		sess.Close()
+	}()
+
+	out, err := sess.Output(probeCmd)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("probe timeout")
+	}
+	if err != nil {
+		return
+	}
+	return parseHostInfo(string(out))
+}
+
+func parseHostInfo(out string) (info *model.HostInfo, err error) {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(out, "\r", "")), "\n")
+	if len(lines) != 5 {
+		return nil, fmt.Errorf("unexpected probe output %q", out)
+	}
+	now := time.Now()
+	return &model.HostInfo{
+		Os:       lines[0],
+		Kernel:   lines[1],
+		Build:    lines[2],
+		Arch:     lines[3],
+		Hostname: lines[4],
+		ProbedAt: &now,
+	}, nil
+}
+
+// saveHostInfo keeps the latest probe on the asset, the cached assets are dropped only if it is changed
+func saveHostInfo(asset *model.Asset, info *model.HostInfo) {
+	old := asset.HostInfo
+	if err := mysql.DB.Model(model.DefaultAsset).Where("id = ?", asset.Id).Updates(map[string]any{
+		"host_hostname":  info.Hostname,
+		"host_os":        info.Os,
+		"host_kernel":    info.Kernel,
+		"host_build":     info.Build,
+		"host_arch":      info.Arch,
+		"host_probed_at": info.ProbedAt,
+	}).Error; err != nil {
+		logger.L().Warn("save host info failed", zap.Int("assetId", asset.Id), zap.Error(err))
+		return
+	}
+	if old.Hostname != info.Hostname || old.Os != info.Os || old.Kernel != info.Kernel || old.Build != info.Build || old.Arch != info.Arch {
+		util.DeleteAllFromCacheDb(context.Background(), model.DefaultAsset)
+	}
+}
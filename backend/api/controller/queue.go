@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/api/guacd"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+const (
+	// defaultQueueTimeout is how long queued connections wait if the asset does not set it
+	defaultQueueTimeout = time.Minute * 10
+	// queueNotifyInterval is how often positions are sent, it keeps websockets alive and finds clients gone
+	queueNotifyInterval = time.Second * 5
+	// queueOpcode is not of guacamole, clients ignore it unless they register a handler for it
+	queueOpcode = "queue"
+)
+
+// takeSlot takes a session slot of the asset if its sessions are limited. Web connections wait in the queue if the
+// asset queues them, positions are sent over the websocket until the slot is taken and the connection leaves the
+// queue once sending fails
+func takeSlot(sess *gsession.Session, asset *model.Asset) (err error) {
+	cfg := asset.Concurrency
+	if cfg.Max <= 0 {
+		return
+	}
+	limitErr := &ApiError{Code: ErrSessionLimit, Data: map[string]any{"asset_id": asset.Id, "max": cfg.Max}}
+	queue := cfg.Queue && sess.SessionType == model.SESSIONTYPE_WEB && sess.Ws != nil
+	if sess.Slot, err = gsession.AcquireSlot(asset.Id, cfg.Max, queue); err != nil {
+		return limitErr
+	}
+
+	timeout := time.After(lo.Ternary(cfg.Timeout > 0, time.Second*time.Duration(cfg.Timeout), defaultQueueTimeout))
+	tk := time.NewTicker(queueNotifyInterval)
+	defer tk.Stop()
+	for last := 0; ; {
+		if pos := sess.Slot.Position(); pos > 0 {
+			if err = notifyQueue(sess, pos, pos != last); err != nil {
+				return
+			}
+			last = pos
+		}
+		select {
+		case <-sess.Slot.Ready():
+			return
+		case <-timeout:
+			return limitErr
+		case <-tk.C:
+		}
+	}
+}
+
+// notifyQueue sends the position to the client, terminals print it only if it changes
+func notifyQueue(sess *gsession.Session, pos int, changed bool) error {
+	switch {
+	case sess.IsGuacd():
+		return sess.Ws.WriteMessage(websocket.TextMessage, guacd.NewInstruction(queueOpcode, cast.ToString(pos)).Bytes())
+	case changed:
+		return sess.WriteTerm([]byte(fmt.Sprintf("\r\n \033[33m sessions of the asset are full, waiting in the queue at %d \x1b[0m", pos)))
+	default:
+		return sess.WritePing()
+	}
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	gsession "github.com/veops/oneterm/session"
+)
+
+// ConnectResume godoc
+//
+//	@Tags		connect
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		framing		query		string	false	"framings the client speaks, e.g. 1,2"
+//	@Param		offset		query		int		false	"bytes of terminal output the client has received, output after it is replayed, default is output since the websocket dropped"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/connect/resume/:session_id [get]
+func (c *Controller) ConnectResume(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	// the session keeps running after its owner leaves only if it is resumable
+	if sess == nil || sess.Gctx.Err() != nil || sess.ResumeGrace() <= 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	if sess.Uid != currentUser.GetUid() {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "resume session"}})
+		return
+	}
+
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the websocket belongs to the session once it is taken, it is closed by the session then
+	r := gsession.NewResume(ws, ctx.Query("framing"), cast.ToInt64(ctx.DefaultQuery("offset", "-1")))
+	select {
+	case sess.Chans.ResumeChan <- r:
+		select {
+		case err = <-r.Err:
+		case <-sess.Gctx.Done():
+			err = errors.New("session ended")
+		}
+	case <-sess.Gctx.Done():
+		err = &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}}
+	}
+	if err != nil {
+		closeWs(ws, err, false)
+		ws.Close()
+	}
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/rotation"
+)
+
+// RotateAccount godoc
+//
+//	@Tags		account
+//	@Param		id	path		int	true	"account id"
+//	@Success	200	{object}	HttpResponse{data=[]model.RotationHistory}
+//	@Router		/account/:id/rotate [post]
+func (c *Controller) RotateAccount(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "rotate account password") {
+		return
+	}
+
+	histories, err := rotation.Rotate(cast.ToInt(ctx.Param("id")), currentUser.GetUid())
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(histories))
+}
+
+// GetRotationHistories godoc
+//
+//	@Tags		rotation_history
+//	@Param		page_index	query		int		true	"page_index"
+//	@Param		page_size	query		int		true	"page_size"
+//	@Param		search		query		string	false	"account or message"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Param		batch_id	query		string	false	"batch id"
+//	@Param		account_id	query		int		false	"account id"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		status		query		int		false	"status"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.RotationHistory}}
+//	@Router		/rotation_history [get]
+func (c *Controller) GetRotationHistories(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get rotation history") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultRotationHistory)
+	db = filterSearch(ctx, db, "account", "message")
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = filterEqual(ctx, db, "batch_id", "account_id", "asset_id", "status")
+
+	doGet[*model.RotationHistory](ctx, false, db, "")
+}
@@ -1,23 +1,68 @@
 package controller
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"image/png"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
+	"github.com/spf13/cast"
 	"go.uber.org/zap"
 
 	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/api/guacd"
 	mysql "github.com/veops/oneterm/db"
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/storage"
 )
 
+const (
+	maxMultiReplay = 10
+	// replayStampInterval is how often the viewer is drawn again in terminal recordings downloaded
+	replayStampInterval = time.Second * 30
+
+	REPLAY_EVENT_START = "start"
+	REPLAY_EVENT_CMD   = "cmd"
+	REPLAY_EVENT_END   = "end"
+)
+
+// ReplayEvent is an event of sessions in a multi session replay, position is in milliseconds on the merged timeline
+type ReplayEvent struct {
+	SessionId string    `json:"session_id"`
+	Track     int       `json:"track"`
+	UserName  string    `json:"user_name"`
+	AssetInfo string    `json:"asset_info"`
+	Type      string    `json:"type"`
+	Data      string    `json:"data"`
+	Time      time.Time `json:"time"`
+	Position  int64     `json:"position"`
+}
+
+// OnlineInspection compares online sessions in memory with online rows of the db, sessions connecting or closing at
+// the moment could show up in either side for a while
+type OnlineInspection struct {
+	// Goroutines is of the whole process
+	Goroutines int                    `json:"goroutines"`
+	Sessions   []*gsession.Inspection `json:"sessions"`
+	// DbOnly are online in the db but not in memory, e.g. sessions whose close is not saved
+	DbOnly []string `json:"db_only"`
+	// MemoryOnly are in memory but not online in the db, e.g. sessions not removed after they end
+	MemoryOnly []string `json:"memory_only"`
+	// Upserts counts saves of session states, failed ones are reconciled every minute
+	Upserts *gsession.UpsertGauge `json:"upserts"`
+}
+
 var (
 	sessionPostHooks = []postHook[*model.Session]{
 		func(ctx *gin.Context, data []*model.Session) {
@@ -26,7 +71,7 @@ var (
 				return
 			}
 			post := make([]*model.CmdCount, 0)
-			if err := mysql.DB.
+			if err := mysql.AuditDB.
 				Model(&model.SessionCmd{}).
 				Select("session_id, COUNT(*) AS count").
 				Where("session_id IN ?", sessionIds).
@@ -66,7 +111,7 @@ func (c *Controller) CreateSessionCmd(ctx *gin.Context) {
 		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
 		return
 	}
-	if err := mysql.DB.
+	if err := mysql.AuditDB.
 		Create(data).
 		Error; err != nil {
 		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
@@ -88,24 +133,148 @@ func (c *Controller) CreateSessionCmd(ctx *gin.Context) {
 //	@Param		uid			query		int		false	"uid"
 //	@Param		asset_id	query		int		false	"asset id"
 //	@Param		client_ip	query		string	false	"client_ip"
+//	@Param		maintenance_window_id	query		int		false	"maintenance window id"
+//	@Param		workspace_id	query		int		false	"workspace id"
 //	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Session}}
 //	@Router		/session [get]
 func (c *Controller) GetSessions(ctx *gin.Context) {
-	db := mysql.DB.Model(model.DefaultSession)
+	db := mysql.AuditDB.Model(model.DefaultSession)
 	currentUser, _ := acl.GetSessionFromCtx(ctx)
 	if !acl.IsAdmin(currentUser) {
 		db = db.Where("uid = ?", currentUser.Uid)
 	}
-	db = filterSearch(ctx, db, "user_name", "asset_info", "gateway_info", "account_info")
+	db = filterSearch(ctx, db, "user_name", "asset_info", "gateway_info", "account_info", "host_hostname", "host_kernel")
 	db, err := filterStartEnd(ctx, db)
 	if err != nil {
 		return
 	}
-	db = filterEqual(ctx, db, "status", "uid", "asset_id", "client_ip")
+	db = filterEqual(ctx, db, "status", "uid", "asset_id", "client_ip", "maintenance_window_id", "workspace_id")
 
 	doGet(ctx, false, db, "", sessionPostHooks...)
 }
 
+// GetMySessions godoc
+//
+//	@Tags		session
+//	@Param		page_index	query		int		true	"page_index"
+//	@Param		page_size	query		int		true	"page_size"
+//	@Param		search		query		string	false	"search"
+//	@Param		status		query		int		false	"status, online=1, offline=2"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Session}}
+//	@Router		/session/mine [get]
+func (c *Controller) GetMySessions(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	ss := selfService()
+	if !ss.Enable {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "self service"}})
+		return
+	}
+
+	db := mysql.AuditDB.Model(model.DefaultSession).Where("uid = ?", currentUser.GetUid())
+	if ss.Days > 0 {
+		db = db.Where("created_at > ?", time.Now().AddDate(0, 0, -ss.Days))
+	}
+	db = filterSearch(ctx, db, "asset_info", "account_info")
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = filterEqual(ctx, db, "status", "asset_id")
+
+	doGet(ctx, false, db, "", sessionPostHooks...)
+}
+
+// GetMySessionCmds godoc
+//
+//	@Tags		session
+//	@Param		page_index	query		int		true	"page_index"
+//	@Param		page_size	query		int		true	"page_size"
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		search		query		string	false	"search"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.SessionCmd}}
+//	@Router		/session/mine/:session_id/cmd [get]
+func (c *Controller) GetMySessionCmds(ctx *gin.Context) {
+	session, ok := getMySession(ctx)
+	if !ok {
+		return
+	}
+	db := mysql.AuditDB.Model(&model.SessionCmd{}).Where("session_id = ?", session.SessionId)
+	db = filterSearch(ctx, db, "cmd", "result")
+
+	doGet[*model.SessionCmd](ctx, false, db, "")
+}
+
+// GetMySessionReplay godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		step_up_id	query		int		false	"a fresh step up, required if the asset is restricted"
+//	@Success	200			{object}	string
+//	@Router		/session/mine/:session_id/replay [get]
+func (c *Controller) GetMySessionReplay(ctx *gin.Context) {
+	session, ok := getMySession(ctx)
+	if !ok {
+		return
+	}
+	if !authorizeMyReplay(ctx, session) || !authorizeReplay(ctx, session) {
+		return
+	}
+
+	filename, f, err := openRecording(ctx, session, false)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	defer f.Close()
+	sendRecording(ctx, session, filename, f)
+}
+
+// authorizeMyReplay checks what self service requires of users replaying their own sessions, otherwise aborts
+func authorizeMyReplay(ctx *gin.Context, sessions ...*model.Session) bool {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	ss := selfService()
+	if lo.ContainsBy(sessions, func(s *model.Session) bool { return !ss.Covers(s, currentUser.GetUid()) }) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "self service"}})
+		return false
+	}
+	if !ss.Replay {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "replay"}})
+		return false
+	}
+	if lo.ContainsBy(sessions, func(s *model.Session) bool { return s.Status == model.SESSIONSTATUS_ONLINE }) {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "session is online"}})
+		return false
+	}
+	return true
+}
+
+func selfService() model.SelfService {
+	if cfg := model.GlobalConfig.Load(); cfg != nil {
+		return cfg.SelfService
+	}
+	return model.SelfService{}
+}
+
+// getMySession returns the session of current user if self service covers it, otherwise aborts
+func getMySession(ctx *gin.Context) (session *model.Session, ok bool) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	sessionId := ctx.Param("session_id")
+	session = &model.Session{}
+	if err := mysql.AuditDB.Model(session).Where("session_id = ?", sessionId).First(session).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	ss := selfService()
+	if !ss.Covers(session, currentUser.GetUid()) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "self service"}})
+		return
+	}
+	return session, true
+}
+
 // GetSessionCmds godoc
 //
 //	@Tags		session
@@ -116,7 +285,7 @@ func (c *Controller) GetSessions(ctx *gin.Context) {
 //	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.SessionCmd}}
 //	@Router		/session/:session_id/cmd [get]
 func (c *Controller) GetSessionCmds(ctx *gin.Context) {
-	db := mysql.DB.Model(&model.SessionCmd{})
+	db := mysql.AuditDB.Model(&model.SessionCmd{})
 	db = db.Where("session_id = ?", ctx.Param("session_id"))
 	db = filterSearch(ctx, db, "cmd", "result")
 
@@ -149,7 +318,7 @@ func (c *Controller) GetSessionOptionAsset(ctx *gin.Context) {
 //	@Router		/session/option/clientip [get]
 func (c *Controller) GetSessionOptionClientIp(ctx *gin.Context) {
 	opts := make([]string, 0)
-	if err := mysql.DB.
+	if err := mysql.AuditDB.
 		Model(model.DefaultSession).
 		Distinct("client_ip").
 		Find(&opts).
@@ -180,13 +349,15 @@ func (c *Controller) CreateSessionReplay(ctx *gin.Context) {
 		return
 	}
 
-	f, err := os.Create(filepath.Join("/replay", fmt.Sprintf("%s.cast", ctx.Param("session_id"))))
-	if err != nil {
+	name := fmt.Sprintf("%s.cast", ctx.Param("session_id"))
+	if err = os.WriteFile(storage.Path(name), content, 0644); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	if err = storage.Archive(name); err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
 		return
 	}
-	defer f.Close()
-	f.Write(content)
 
 	ctx.JSON(http.StatusOK, defaultHttpResponse)
 }
@@ -195,17 +366,477 @@ func (c *Controller) CreateSessionReplay(ctx *gin.Context) {
 //
 //	@Tags		session
 //	@Param		session_id	path		string	true	"session id"
+//	@Param		redacted	query		bool	false	"download the redacted copy, non-admins always get it if there is one"
+//	@Param		step_up_id	query		int		false	"a fresh step up, required if the asset is restricted"
 //	@Success	200			{object}	string
 //	@Router		/session/replay/:session_id [get]
 func (c *Controller) GetSessionReplay(ctx *gin.Context) {
 	sessionId := ctx.Param("session_id")
 	session := &model.Session{}
-	if err := mysql.DB.Model(session).Where("session_id = ?", sessionId).First(session).Error; err != nil {
+	if err := mysql.AuditDB.Model(session).Where("session_id = ?", sessionId).First(session).Error; err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
 	}
-	filename := sessionId
-	if !session.IsGuacd() {
-		filename += ".cast"
+	if !authorizeReplay(ctx, session) {
+		return
+	}
+	filename, f, err := openRecording(ctx, session, cast.ToBool(ctx.Query("redacted")))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
 	}
-	ctx.FileAttachment(filepath.Join("/replay", filename), filename)
+	defer f.Close()
+	sendRecording(ctx, session, filename, f)
+}
+
+// CreateSessionRedaction godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string				true	"session id"
+//	@Param		redaction	body		gsession.Redaction	true	"time ranges in milliseconds and regexp patterns"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/session/:session_id/redact [post]
+func (c *Controller) CreateSessionRedaction(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "redact replay"}})
+		return
+	}
+
+	redaction := &gsession.Redaction{}
+	if err := ctx.ShouldBindBodyWithJSON(redaction); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if err := redaction.Compile(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	sessionId := ctx.Param("session_id")
+	session := &model.Session{}
+	if err := mysql.AuditDB.Model(session).Where("session_id = ?", sessionId).First(session).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	if session.Status == model.SESSIONSTATUS_ONLINE {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "session is online"}})
+		return
+	}
+
+	src, err := storage.Open(session.RecordingName())
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	defer src.Close()
+	name := session.RedactedRecordingName()
+	dst, err := os.Create(storage.Path(name))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	err = lo.Ternary(session.IsGuacd(), redaction.RedactGuacd, redaction.RedactAsciinema)(src, dst)
+	dst.Close()
+	if err != nil {
+		os.Remove(storage.Path(name))
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if err = storage.Archive(name); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// ConnectSessionReplay godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		step_up_id	query		int		false	"a fresh step up, required if the asset is restricted"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/session/:session_id/replay [get]
+func (c *Controller) ConnectSessionReplay(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	session := &model.Session{}
+	if err := mysql.AuditDB.Model(session).Where("session_id = ?", sessionId).First(session).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	if !acl.IsAdmin(currentUser) && !authorizeMyReplay(ctx, session) {
+		return
+	}
+	if !authorizeReplay(ctx, session) {
+		return
+	}
+
+	_, f, err := openRecording(ctx, session, false)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	defer f.Close()
+	rec, err := lo.Ternary(session.IsGuacd(), gsession.LoadGuacd, gsession.LoadAsciinema)(f)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	err = playRecording(ctx, rec)
+	logger.L().Debug("replay stopped", zap.String("sessionId", sessionId), zap.Error(err))
+}
+
+// openRecording opens the recording of session, admins get the redacted copy only if they ask for it. What admins
+// redacted must not be seen by others, so they get the redacted copy whenever there is one
+func openRecording(ctx *gin.Context, session *model.Session, redacted bool) (filename string, f io.ReadCloser, err error) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if acl.IsAdmin(currentUser) {
+		filename = lo.Ternary(redacted, session.RedactedRecordingName(), session.RecordingName())
+		f, err = storage.Open(filename)
+		return
+	}
+
+	filename = session.RedactedRecordingName()
+	if f, err = storage.Open(filename); err == nil {
+		return
+	}
+	filename = session.RecordingName()
+	f, err = storage.Open(filename)
+	return
+}
+
+// playRecording plays rec to the websocket client which controls it by ReplayCtrl
+func playRecording(ctx *gin.Context, rec *gsession.Recording) (err error) {
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctrlChan := make(chan *gsession.ReplayCtrl)
+	go func() {
+		defer cancel()
+		for {
+			ctrl := &gsession.ReplayCtrl{}
+			if err := ws.ReadJSON(ctrl); err != nil {
+				return
+			}
+			select {
+			case ctrlChan <- ctrl:
+			case <-cctx.Done():
+				return
+			}
+		}
+	}()
+
+	return gsession.NewPlayer(rec, viewerStamp(ctx)).Play(cctx, func(msg *gsession.ReplayMsg) error { return ws.WriteJSON(msg) }, ctrlChan)
+}
+
+// sendRecording sends the recording as a download, terminal ones are stamped with the viewer so that copies leaked are
+// traced. Graphical ones are sent as they are since drawing text in them needs rendering, downloads are in replay logs
+func sendRecording(ctx *gin.Context, session *model.Session, filename string, f io.Reader) {
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if session.IsGuacd() {
+		ctx.DataFromReader(http.StatusOK, -1, "application/octet-stream", f, nil)
+		return
+	}
+
+	stamp := viewerStamp(ctx)
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(gsession.StampAsciinema(f, pw, stamp, replayStampInterval))
+	}()
+	ctx.DataFromReader(http.StatusOK, -1, "application/octet-stream", pr, nil)
+}
+
+// viewerStamp is who replays or downloads recordings and when
+func viewerStamp(ctx *gin.Context) string {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	return fmt.Sprintf("%s %s %s", currentUser.GetUserName(), ctx.ClientIP(), formatTime(ctx, time.Now()))
+}
+
+// getMultiReplaySessions returns sessions of session_ids ordered by start time, which is also the order of tracks
+func getMultiReplaySessions(ctx *gin.Context) (sessions []*model.Session, ok bool) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	ids := lo.Uniq(lo.FlatMap(ctx.QueryArray("session_ids"), func(s string, _ int) []string {
+		return lo.Compact(strings.Split(s, ","))
+	}))
+	if len(ids) == 0 || len(ids) > maxMultiReplay {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("1 to %d session ids are required", maxMultiReplay)}})
+		return
+	}
+	if err := mysql.AuditDB.Model(model.DefaultSession).Where("session_id IN ?", ids).Order("created_at, id").Find(&sessions).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	if len(sessions) != len(ids) {
+		missing, _ := lo.Difference(ids, lo.Map(sessions, func(s *model.Session, _ int) string { return s.SessionId }))
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": strings.Join(missing, ",")}})
+		return
+	}
+	if !acl.IsAdmin(currentUser) && !authorizeMyReplay(ctx, sessions...) {
+		return
+	}
+
+	return sessions, true
+}
+
+// ConnectMultiSessionReplay godoc
+//
+//	@Tags		session
+//	@Param		session_ids	query		[]string	true	"session ids, recordings are aligned on wall clock as tracks in the order of start time"
+//	@Param		step_up_id	query		int		false	"a fresh step up, required if the asset is restricted"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/session/multi/replay [get]
+func (c *Controller) ConnectMultiSessionReplay(ctx *gin.Context) {
+	sessions, ok := getMultiReplaySessions(ctx)
+	if !ok || !authorizeReplay(ctx, sessions...) {
+		return
+	}
+
+	recs := make([]*gsession.Recording, 0, len(sessions))
+	for _, s := range sessions {
+		_, f, err := openRecording(ctx, s, false)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+			return
+		}
+		rec, err := lo.Ternary(s.IsGuacd(), gsession.LoadGuacd, gsession.LoadAsciinema)(f)
+		f.Close()
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+			return
+		}
+		recs = append(recs, rec)
+	}
+	rec := gsession.MergeRecordings(recs,
+		lo.Map(sessions, func(s *model.Session, _ int) time.Time { return s.CreatedAt }),
+		lo.Map(sessions, func(s *model.Session, _ int) *gsession.ReplayTrack {
+			return &gsession.ReplayTrack{SessionId: s.SessionId, Guacd: s.IsGuacd()}
+		}))
+
+	err := playRecording(ctx, rec)
+	logger.L().Debug("multi replay stopped", zap.Int("tracks", len(sessions)), zap.Error(err))
+}
+
+// GetMultiSessionReplayEvents godoc
+//
+//	@Tags		session
+//	@Param		session_ids	query		[]string	true	"session ids"
+//	@Success	200			{object}	HttpResponse{data=[]ReplayEvent}
+//	@Router		/session/multi/events [get]
+func (c *Controller) GetMultiSessionReplayEvents(ctx *gin.Context) {
+	sessions, ok := getMultiReplaySessions(ctx)
+	if !ok {
+		return
+	}
+	first := sessions[0].CreatedAt
+	tracks := make(map[string]int)
+	for i, s := range sessions {
+		tracks[s.SessionId] = i
+	}
+	newEvent := func(s *model.Session, typ, data string, t time.Time) *ReplayEvent {
+		return &ReplayEvent{
+			SessionId: s.SessionId,
+			Track:     tracks[s.SessionId],
+			UserName:  s.UserName,
+			AssetInfo: s.AssetInfo,
+			Type:      typ,
+			Data:      data,
+			Time:      t,
+			Position:  t.Sub(first).Milliseconds(),
+		}
+	}
+
+	events := make([]*ReplayEvent, 0)
+	for _, s := range sessions {
+		events = append(events, newEvent(s, REPLAY_EVENT_START, s.AccountInfo, s.CreatedAt))
+		if s.ClosedAt != nil {
+			events = append(events, newEvent(s, REPLAY_EVENT_END, "", *s.ClosedAt))
+		}
+	}
+	cmds := make([]*model.SessionCmd, 0)
+	if err := mysql.AuditDB.Model(&model.SessionCmd{}).Where("session_id IN ?", lo.Keys(tracks)).Find(&cmds).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	bySession := lo.KeyBy(sessions, func(s *model.Session) string { return s.SessionId })
+	for _, cmd := range cmds {
+		events = append(events, newEvent(bySession[cmd.SessionId], REPLAY_EVENT_CMD, cmd.Cmd, cmd.CreatedAt))
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(events))
+}
+
+// GetSessionScreenshot godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		cols		query		int		false	"columns of ssh session, default 120"
+//	@Param		lines		query		int		false	"lines of ssh session, default 40"
+//	@Success	200			{object}	string
+//	@Router		/session/screenshot/:session_id [get]
+func (c *Controller) GetSessionScreenshot(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	if sess.Uid != currentUser.GetUid() && !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "screenshot"}})
+		return
+	}
+
+	if !sess.IsGuacd() {
+		cols, lines := cast.ToInt(ctx.Query("cols")), cast.ToInt(ctx.Query("lines"))
+		text := strings.Join(sess.Tail.Lines(lo.Ternary(cols > 0, cols, 120), lo.Ternary(lines > 0, lines, 40)), "\n")
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+		return
+	}
+
+	t, err := guacd.NewDisplayTunnel(sess.ConnectionId, 1024, 768, 96)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}})
+		return
+	}
+	defer t.Disconnect()
+
+	display := guacd.NewDisplay()
+	if err = display.RunUntilSync(t, time.Second*5); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err = png.Encode(buf, display.Image()); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	ctx.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// GetSessionX11Captures godoc
+//
+//	@Tags		session
+//	@Param		page_index	query		int		true	"page_index"
+//	@Param		page_size	query		int		true	"page_size"
+//	@Param		session_id	path		string	true	"session id"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.X11Capture}}
+//	@Router		/session/:session_id/x11 [get]
+func (c *Controller) GetSessionX11Captures(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.AuditDB.Model(model.DefaultX11Capture)
+	db = db.Where("session_id = ?", ctx.Param("session_id"))
+	if !acl.IsAdmin(currentUser) {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+
+	doGet[*model.X11Capture](ctx, false, db, "")
+}
+
+// GetSessionX11Capture godoc
+//
+//	@Tags		session
+//	@Param		id	path		int	true	"x11 capture id"
+//	@Success	200	{object}	string
+//	@Router		/session/x11/:id [get]
+func (c *Controller) GetSessionX11Capture(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	capture := &model.X11Capture{}
+	if err := mysql.AuditDB.Model(capture).Where("id = ?", cast.ToInt(ctx.Param("id"))).First(capture).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if capture.Uid != currentUser.GetUid() && !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "x11 capture"}})
+		return
+	}
+
+	f, err := storage.Open(capture.Name)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	defer f.Close()
+	ctx.DataFromReader(http.StatusOK, -1, "image/png", f, nil)
+}
+
+// GetSessionChanStats godoc
+//
+//	@Tags		session
+//	@Success	200	{object}	HttpResponse{data=map[string]map[string]gsession.ChanGauge}
+//	@Router		/session/chanstat [get]
+func (c *Controller) GetSessionChanStats(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "session channel stats"}})
+		return
+	}
+
+	res := make(map[string]map[string]*gsession.ChanGauge)
+	gsession.GetOnlineSession().Range(func(key, value any) bool {
+		sess, ok := value.(*gsession.Session)
+		if ok && sess.Chans != nil {
+			res[cast.ToString(key)] = sess.Gauges()
+		}
+		return true
+	})
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// GetOnlineSessionInspection godoc
+//
+//	@Tags		session
+//	@Success	200	{object}	HttpResponse{data=OnlineInspection}
+//	@Router		/session/inspect [get]
+func (c *Controller) GetOnlineSessionInspection(ctx *gin.Context) {
+	if !checkAdmin(ctx, "inspect online sessions") {
+		return
+	}
+
+	now := time.Now()
+	res := &OnlineInspection{Sessions: make([]*gsession.Inspection, 0)}
+	gsession.GetOnlineSession().Range(func(key, value any) bool {
+		if sess, ok := value.(*gsession.Session); ok {
+			res.Sessions = append(res.Sessions, sess.Inspect(now))
+		}
+		return true
+	})
+	sort.Slice(res.Sessions, func(i, j int) bool { return res.Sessions[i].CreatedAt.Before(res.Sessions[j].CreatedAt) })
+
+	online := make([]string, 0)
+	if err := mysql.AuditDB.Model(model.DefaultSession).Where("status = ?", model.SESSIONSTATUS_ONLINE).Pluck("session_id", &online).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	// sessions held by other instances are in their memory
+	remotes, err := gsession.GetOnlineSession().Remotes()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	inMemory := lo.Map(res.Sessions, func(ins *gsession.Inspection, _ int) string { return ins.SessionId })
+	inMemory = append(inMemory, lo.Map(remotes, func(e *gsession.Entry, _ int) string { return e.Session.SessionId })...)
+	res.DbOnly, res.MemoryOnly = lo.Difference(online, inMemory)
+	res.Goroutines = runtime.NumGoroutine()
+	res.Upserts = gsession.GetUpsertGauge()
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
 }
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/review"
+)
+
+type sessionReviewReq struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type sessionReviewDoneReq struct {
+	Comment string `json:"comment" binding:"required"`
+}
+
+type sessionReviewAssignReq struct {
+	ReviewerId int `json:"reviewer_id" binding:"required"`
+}
+
+// ReviewerWorkload is pending and overdue reviews of a reviewer
+type ReviewerWorkload struct {
+	model.Reviewer
+	Pending int64 `json:"pending"`
+	Overdue int64 `json:"overdue"`
+}
+
+// CreateSessionReview godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string				true	"session id"
+//	@Param		review		body		sessionReviewReq	true	"reason of flagging"
+//	@Success	200			{object}	HttpResponse{data=model.SessionReview}
+//	@Router		/session/:session_id/review [post]
+func (c *Controller) CreateSessionReview(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "flag session") {
+		return
+	}
+
+	req := &sessionReviewReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	r, err := review.Flag(ctx.Param("session_id"), req.Reason, currentUser.GetUserName())
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(r))
+}
+
+// GetSessionReviews godoc
+//
+//	@Tags		session_review
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		reviewer_id	query		int		false	"reviewer, admins only, others get reviews of their own"
+//	@Param		session_id	query		string	false	"session id"
+//	@Param		asset_id	query		int		false	"asset id"
+//	@Param		status		query		int		false	"1 pending, 2 done"
+//	@Param		overdue		query		bool	false	"pending ones past due only"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.SessionReview}}
+//	@Router		/session_review [get]
+func (c *Controller) GetSessionReviews(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.DB.Model(model.DefaultSessionReview)
+	if acl.IsAdmin(currentUser) {
+		db = filterEqual(ctx, db, "reviewer_id")
+	} else {
+		db = db.Where("reviewer_id = ?", currentUser.GetUid())
+	}
+	db = filterEqual(ctx, db, "session_id", "asset_id", "status")
+	if cast.ToBool(ctx.Query("overdue")) {
+		db = db.Where("status = ? AND due_at < ?", model.REVIEW_STATUS_PENDING, time.Now())
+	}
+	db = db.Order("id DESC")
+
+	doGet[*model.SessionReview](ctx, false, db, "")
+}
+
+// DoneSessionReview godoc
+//
+//	@Tags		session_review
+//	@Param		id		path		int						true	"session review id"
+//	@Param		review	body		sessionReviewDoneReq	true	"conclusion of the review"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/session_review/:id/done [post]
+func (c *Controller) DoneSessionReview(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	req := &sessionReviewDoneReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	id := cast.ToInt(ctx.Param("id"))
+	db := mysql.DB.Model(model.DefaultSessionReview).Where("id = ? AND status = ?", id, model.REVIEW_STATUS_PENDING)
+	// admins conclude reviews of anyone, such as unassigned or overdue ones
+	if !acl.IsAdmin(currentUser) {
+		db = db.Where("reviewer_id = ?", currentUser.GetUid())
+	}
+	res := db.Updates(map[string]any{
+		"status":      model.REVIEW_STATUS_DONE,
+		"comment":     req.Comment,
+		"reviewer_id": currentUser.GetUid(),
+		"reviewer":    currentUser.GetUserName(),
+		"reviewed_at": time.Now(),
+	})
+	if res.Error != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": res.Error}})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("session review %d is not pending for you", id)}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// AssignSessionReview godoc
+//
+//	@Tags		session_review
+//	@Param		id		path		int						true	"session review id"
+//	@Param		review	body		sessionReviewAssignReq	true	"reviewer of the config"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/session_review/:id/assign [post]
+func (c *Controller) AssignSessionReview(ctx *gin.Context) {
+	if !checkAdmin(ctx, "assign session review") {
+		return
+	}
+
+	req := &sessionReviewAssignReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	reviewer, ok := review.Reviewer(req.ReviewerId)
+	if !ok {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("%d is not a reviewer", req.ReviewerId)}})
+		return
+	}
+
+	id := cast.ToInt(ctx.Param("id"))
+	res := mysql.DB.Model(model.DefaultSessionReview).
+		Where("id = ? AND status = ?", id, model.REVIEW_STATUS_PENDING).
+		Updates(map[string]any{"reviewer_id": reviewer.Uid, "reviewer": reviewer.UserName})
+	if res.Error != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": res.Error}})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("session review %d is not pending", id)}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// GetSessionReviewWorkload godoc
+//
+//	@Tags		session_review
+//	@Success	200	{object}	HttpResponse{data=[]ReviewerWorkload}
+//	@Router		/session_review/workload [get]
+func (c *Controller) GetSessionReviewWorkload(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get session review workload") {
+		return
+	}
+
+	reviewers := model.GlobalConfig.Load().Review.Reviewers
+	uids := lo.Map(reviewers, func(r model.Reviewer, _ int) int { return r.Uid })
+	pending, err := review.Workload(uids, false)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	overdue, err := review.Workload(uids, true)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(lo.Map(reviewers, func(r model.Reviewer, _ int) *ReviewerWorkload {
+		return &ReviewerWorkload{Reviewer: r, Pending: pending[r.Uid], Overdue: overdue[r.Uid]}
+	})))
+}
+
+// flagSession flags the session for review in the background, failures are only logged
+func flagSession(sessionId, reason, by string) {
+	go func() {
+		if _, err := review.Flag(sessionId, reason, by); err != nil {
+			logger.L().Warn("flag session failed", zap.String("sessionId", sessionId), zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/api/guacd"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+type inviteReq struct {
+	Uids []int `json:"uids" binding:"required,min=1"`
+}
+
+type collabReq struct {
+	Uids []int `json:"uids" binding:"required,min=1"`
+	// Minutes the link expires after, default 60
+	Minutes int `json:"minutes" binding:"gte=0"`
+}
+
+// ConnectInvite godoc
+//
+//	@Tags		connect
+//	@Param		session_id	path		string		true	"session id"
+//	@Param		req			body		inviteReq	true	"users invited to join the rdp or vnc session with control"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/connect/invite/:session_id [post]
+func (c *Controller) ConnectInvite(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil || !sess.IsGuacd() {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	if sess.Uid != currentUser.GetUid() && !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "invite to session"}})
+		return
+	}
+
+	req := &inviteReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	sess.Sharing.Invite(req.Uids...)
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// ConnectCollab godoc
+//
+//	@Tags		connect
+//	@Param		session_id	path		string		true	"session id"
+//	@Param		req			body		collabReq	true	"users granted to type into the terminal session"
+//	@Success	200			{object}	HttpResponse{data=string}	"token of the share link, join by /connect/monitor/:session_id?token="
+//	@Router		/connect/collab/:session_id [post]
+func (c *Controller) ConnectCollab(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil || sess.IsGuacd() {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	if sess.Uid != currentUser.GetUid() && !acl.IsAdmin(currentUser) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "share session"}})
+		return
+	}
+
+	req := &collabReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	token := sess.Sharing.Grant(req.Uids, time.Minute*time.Duration(lo.Ternary(req.Minutes > 0, req.Minutes, 60)))
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(token))
+}
+
+// ConnectJoin godoc
+//
+//	@Tags		connect
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		w			query		int		false	"width"
+//	@Param		h			query		int		false	"height"
+//	@Param		dpi			query		int		false	"dpi"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/connect/join/:session_id [get]
+func (c *Controller) ConnectJoin(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil || !sess.IsGuacd() {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	// the invitee must be authorized to the asset and account of the session as if it connected by itself
+	if sess.Uid == currentUser.GetUid() || !sess.Sharing.Invited(currentUser.GetUid()) ||
+		!hasAuthorization(ctx, &gsession.Session{Session: &model.Session{AssetId: sess.AssetId, AccountId: sess.AccountId}}) {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrNoPerm, Data: map[string]any{"perm": "join session"}})
+		return
+	}
+
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+
+	w, h, dpi := cast.ToInt(ctx.Query("w")), cast.ToInt(ctx.Query("h")), cast.ToInt(ctx.Query("dpi"))
+	t, err := guacd.NewSharedTunnel(sess.ConnectionId, w, h, dpi)
+	if err != nil {
+		logger.L().Error("guacd tunnel failed", zap.Error(err))
+		ws.WriteMessage(websocket.TextMessage, guacd.NewInstruction("error", err.Error(), cast.ToString(ErrAdminClose)).Bytes())
+		return
+	}
+	defer t.Disconnect()
+
+	userName := currentUser.GetUserName()
+	if err = sess.AddParticipant(userName); err != nil {
+		logger.L().Error("add participant failed", zap.String("sessionId", sessionId), zap.Error(err))
+	}
+	defer sess.Sharing.Release(userName)
+
+	outChan := make(chan []byte, 8)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		for {
+			p, err := t.Read()
+			if err != nil {
+				return err
+			}
+			if len(p) <= 0 {
+				continue
+			}
+			select {
+			case <-gctx.Done():
+				return nil
+			case outChan <- p:
+			}
+		}
+	})
+	g.Go(func() error {
+		noticed := ""
+		for {
+			_, p, err := ws.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if p = expandKeys(p); len(p) == 0 {
+				continue
+			}
+			if !takeControl(sess, userName, p, &noticed, func(notice []byte) {
+				select {
+				case <-gctx.Done():
+				case outChan <- notice:
+				}
+			}) {
+				continue
+			}
+			if guacd.IsActive(p) {
+				sess.SetIdle()
+			}
+			t.Write(p)
+		}
+	})
+	// the writer closes the websocket when it stops to unblock the reader of it, guacd keeps sending sync to unblock the other
+	g.Go(func() error {
+		defer ws.Close()
+		for {
+			select {
+			case <-gctx.Done():
+				return nil
+			case <-sess.Chans.AwayChan:
+				err := fmt.Errorf("shared session closed")
+				ws.WriteMessage(websocket.TextMessage, guacd.NewInstruction("disconnect", err.Error()).Bytes())
+				return err
+			case out := <-outChan:
+				ws.WriteMessage(websocket.TextMessage, out)
+			}
+		}
+	})
+	if err = g.Wait(); err != nil {
+		logger.L().Debug("join session stopped", zap.String("sessionId", sessionId), zap.String("user", userName), zap.Error(err))
+	}
+}
+
+// takeControl passes mouse and key of who only if who drives the shared connection now,
+// the others are told who drives once until they take control
+func takeControl(sess *gsession.Session, who string, in []byte, noticed *string, notify func([]byte)) bool {
+	ok, driver := sess.Sharing.Take(who, in)
+	if ok {
+		*noticed = ""
+		return true
+	}
+	if driver != *noticed {
+		*noticed = driver
+		notify(gsession.ControlNotice(driver))
+	}
+	return false
+}
+
+// ConnectTakeover godoc
+//
+//	@Tags		connect
+//	@Param		session_id	path		string	true	"session id"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/connect/takeover/:session_id [get]
+func (c *Controller) ConnectTakeover(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "take over session") {
+		return
+	}
+
+	sessionId := ctx.Param("session_id")
+	sess := gsession.GetOnlineSessionById(sessionId)
+	if sess == nil || sess.IsGuacd() {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidSessionId, Data: map[string]any{"sessionId": sessionId}})
+		return
+	}
+	userName := currentUser.GetUserName()
+	if ok, by := sess.Sharing.TakeOver(userName); !ok {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Errorf("session is taken over by %s", by)}})
+		return
+	}
+	defer sess.Sharing.HandBack(userName)
+
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+
+	takeover := &model.SessionTakeover{
+		SessionId: sessionId,
+		Uid:       currentUser.GetUid(),
+		UserName:  userName,
+		ClientIp:  ctx.ClientIP(),
+	}
+	if err = mysql.AuditDB.Create(takeover).Error; err != nil {
+		logger.L().Error("create session takeover failed", zap.String("sessionId", sessionId), zap.Error(err))
+		return
+	}
+	defer func() {
+		if err := mysql.AuditDB.Model(takeover).Update("ended_at", time.Now()).Error; err != nil {
+			logger.L().Error("end session takeover failed", zap.String("sessionId", sessionId), zap.Error(err))
+		}
+	}()
+	if err = sess.AddParticipant(userName); err != nil {
+		logger.L().Error("add participant failed", zap.String("sessionId", sessionId), zap.Error(err))
+	}
+
+	// output of the session goes to the admin like monitors
+	key := fmt.Sprintf("%d-%s-%d", currentUser.GetUid(), sessionId, time.Now().Nanosecond())
+	mon := gsession.NewWsOut(ws, sessionId)
+	mon.KeepAlive()
+	defer mon.Close()
+	sess.Monitors.Store(key, mon)
+	defer sess.Monitors.Delete(key)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		for {
+			_, p, err := ws.ReadMessage()
+			if err != nil {
+				return err
+			}
+			// only input of the admin goes to the session, its terminal is sized by the owner
+			if len(p) <= 1 || p[0] != '1' {
+				continue
+			}
+			sess.SetIdle()
+			select {
+			case <-gctx.Done():
+				return nil
+			case <-sess.Gctx.Done():
+				return fmt.Errorf("session closed")
+			case sess.Chans.CollabChan <- &gsession.CollabInput{UserName: userName, P: p}:
+			}
+		}
+	})
+	g.Go(func() error {
+		select {
+		case <-gctx.Done():
+			return nil
+		case <-sess.Gctx.Done():
+		case <-sess.Chans.AwayChan:
+		}
+		// unblocks the reader
+		ws.Close()
+		return fmt.Errorf("session closed")
+	})
+	if err = g.Wait(); err != nil {
+		logger.L().Debug("session takeover stopped", zap.String("sessionId", sessionId), zap.String("admin", userName), zap.Error(err))
+	}
+}
+
+// GetSessionTakeovers godoc
+//
+//	@Tags		session
+//	@Param		session_id	path		string	true	"session id"
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.SessionTakeover}}
+//	@Router		/session/:session_id/takeover [get]
+func (c *Controller) GetSessionTakeovers(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get session takeovers") {
+		return
+	}
+
+	db := mysql.AuditDB.Model(model.DefaultSessionTakeover).Where("session_id = ?", ctx.Param("session_id")).Order("id DESC")
+
+	doGet[*model.SessionTakeover](ctx, false, db, "")
+}
@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	sshCaKeysPath = "/etc/ssh/oneterm_ca.pub"
+	sshdConfig    = "/etc/ssh/sshd_config"
+)
+
+var (
+	sshCaPreHooks = []preHook[*model.SshCa]{
+		func(ctx *gin.Context, data *model.SshCa) {
+			if err := util.GenerateSshCa(data); err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+				return
+			}
+			// the first one signs without an explicit rotation
+			cnt := int64(0)
+			mysql.DB.Model(model.DefaultSshCa).Where("active = ?", true).Count(&cnt)
+			data.Active = cnt == 0
+		},
+	}
+	sshCaDcs = []deleteCheck{
+		func(ctx *gin.Context, id int) {
+			ca := &model.SshCa{}
+			if err := mysql.DB.Model(ca).Where("id = ?", id).First(ca).Error; err == nil && ca.Active {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": "active ssh ca can not be deleted, rotate it first"}})
+			}
+		},
+	}
+)
+
+type SshCaDistributeReq struct {
+	// AccountId is the privileged account to write sshd config
+	AccountId int `json:"account_id" binding:"required"`
+}
+
+// CreateSshCa godoc
+//
+//	@Tags		ssh_ca
+//	@Param		ca	body		model.SshCa	true	"ssh ca, only name and comment are used"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/ssh_ca [post]
+func (c *Controller) CreateSshCa(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create ssh ca") {
+		return
+	}
+	doCreate(ctx, false, &model.SshCa{}, "", sshCaPreHooks...)
+}
+
+// DeleteSshCa godoc
+//
+//	@Tags		ssh_ca
+//	@Param		id	path		int	true	"ssh ca id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/ssh_ca/:id [delete]
+func (c *Controller) DeleteSshCa(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete ssh ca") {
+		return
+	}
+	doDelete(ctx, false, &model.SshCa{}, "", sshCaDcs...)
+}
+
+// GetSshCas godoc
+//
+//	@Tags		ssh_ca
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		active		query		int		false	"ssh ca active"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.SshCa}}
+//	@Router		/ssh_ca [get]
+func (c *Controller) GetSshCas(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get ssh ca") {
+		return
+	}
+
+	db := mysql.DB.Model(&model.SshCa{})
+	db = filterEqual(ctx, db, "id", "active")
+	db = filterSearch(ctx, db, "name", "comment")
+
+	doGet[*model.SshCa](ctx, false, db, "")
+}
+
+// RotateSshCa godoc
+//
+//	@Tags		ssh_ca
+//	@Param		ca	body		model.SshCa	false	"new ssh ca, only name and comment are used"
+//	@Success	200	{object}	HttpResponse{data=model.SshCa}
+//	@Router		/ssh_ca/rotate [post]
+func (c *Controller) RotateSshCa(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	if !checkAdmin(ctx, "rotate ssh ca") {
+		return
+	}
+
+	ca := &model.SshCa{}
+	ctx.ShouldBindBodyWithJSON(ca)
+	if ca.Name == "" {
+		ca.Name = time.Now().Format("20060102150405")
+	}
+	if err := util.GenerateSshCa(ca); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	ca.Active = true
+	ca.CreatorId, ca.UpdaterId = currentUser.GetUid(), currentUser.GetUid()
+
+	// old ones are kept trusted so that certificates signed by them still work until they are deleted
+	if err := mysql.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(model.DefaultSshCa).Where("active = ?", true).Update("active", false).Error; err != nil {
+			return err
+		}
+		return tx.Create(ca).Error
+	}); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(ca))
+}
+
+// GetSshCaPublicKeys godoc
+//
+//	@Tags		ssh_ca
+//	@Success	200	{string}	string	"public keys of all ssh cas in the format of TrustedUserCAKeys"
+//	@Router		/ssh_ca/public_key [get]
+func (c *Controller) GetSshCaPublicKeys(ctx *gin.Context) {
+	keys, err := util.TrustedSshCaKeys()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.String(http.StatusOK, keys)
+}
+
+// GetSshKnownHosts godoc
+//
+//	@Tags		ssh_ca
+//	@Param		host	query		string	false	"names of oneterm separated by comma, the host requested by default"
+//	@Success	200		{string}	string	"host key of the ssh server of oneterm in the format of known_hosts"
+//	@Router		/ssh_ca/known_hosts [get]
+func (c *Controller) GetSshKnownHosts(ctx *gin.Context) {
+	hosts := lo.Compact(lo.Map(strings.Split(ctx.Query("host"), ","), func(h string, _ int) string { return strings.TrimSpace(h) }))
+	if len(hosts) == 0 {
+		host, _, err := net.SplitHostPort(ctx.Request.Host)
+		hosts = append(hosts, lo.Ternary(err == nil, host, ctx.Request.Host))
+	}
+	lines, err := util.KnownHosts(hosts)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.String(http.StatusOK, lines)
+}
+
+// GetSshCaPrincipals godoc
+//
+//	@Tags		ssh_ca
+//	@Param		asset_id	path		int		true	"asset id"
+//	@Param		user		query		string	false	"login user, e.g. %u of AuthorizedPrincipalsCommand"
+//	@Success	200			{string}	string	"principals of the user in the format of AuthorizedPrincipalsFile, or lines of users and their principals without user"
+//	@Router		/ssh_ca/principals/:asset_id [get]
+func (c *Controller) GetSshCaPrincipals(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get ssh ca principals") {
+		return
+	}
+	asset := &model.Asset{}
+	if err := mysql.DB.Model(asset).Where("id = ?", cast.ToInt(ctx.Param("asset_id"))).First(asset).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	principals, err := util.SshCaPrincipals(asset)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	if user, ok := ctx.GetQuery("user"); ok {
+		ctx.String(http.StatusOK, strings.Join(lo.Map(principals[user], func(p string, _ int) string { return p + "\n" }), ""))
+		return
+	}
+	// users and principals are paired by lines so that config management writes a file per user
+	users := lo.Keys(principals)
+	slices.Sort(users)
+	lines := ""
+	for _, user := range users {
+		for _, p := range principals[user] {
+			lines += user + " " + p + "\n"
+		}
+	}
+	ctx.String(http.StatusOK, lines)
+}
+
+// DistributeSshCa godoc
+//
+//	@Tags		ssh_ca
+//	@Param		asset_id	path		int					true	"asset id"
+//	@Param		req			body		SshCaDistributeReq	true	"privileged account"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/ssh_ca/distribute/:asset_id [post]
+func (c *Controller) DistributeSshCa(ctx *gin.Context) {
+	if !checkAdmin(ctx, "distribute ssh ca") {
+		return
+	}
+	req := &SshCaDistributeReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	asset, _, gateway, err := util.GetAAG(cast.ToInt(ctx.Param("asset_id")), req.AccountId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	keys, err := util.TrustedSshCaKeys()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	tunnelId := uuid.New().String()
+	defer ggateway.GetGatewayManager().Close(tunnelId)
+	ip, port, err := util.Proxy(false, tunnelId, "ssh", asset, gateway)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}})
+		return
+	}
+
+	// sshd is reloaded only if the config is valid, the unit is named ssh on debian and sshd on others
+	line := "TrustedUserCAKeys " + sshCaKeysPath
+	script := fmt.Sprintf("tmp=$(mktemp) && printf '%%s' %s > $tmp && install -m 0644 $tmp %s && rm -f $tmp && "+
+		"(grep -q %s %s || printf '\\n%%s\\n' %s >> %s) && sshd -t && "+
+		"(systemctl reload sshd 2>/dev/null || systemctl reload ssh 2>/dev/null || service ssh reload 2>/dev/null || kill -HUP $(cat /var/run/sshd.pid))",
+		shellQuote(keys), sshCaKeysPath,
+		shellQuote("^"+line+"$"), sshdConfig, shellQuote(line), sshdConfig)
+	if err = runPrivileged(req.AccountId, ip, port, script); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
@@ -8,12 +8,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
+	"github.com/spf13/cast"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/veops/oneterm/acl"
 	redis "github.com/veops/oneterm/cache"
 	mysql "github.com/veops/oneterm/db"
 	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
 	"github.com/veops/oneterm/util"
 )
 
@@ -67,7 +69,7 @@ func (c *Controller) StatCount(ctx *gin.Context) {
 
 	eg := &errgroup.Group{}
 	eg.Go(func() error {
-		return mysql.DB.
+		return mysql.AuditDB.
 			Model(model.DefaultSession).
 			Select("COUNT(DISTINCT asset_id, account_id) as connect, COUNT(DISTINCT uid) as user, COUNT(DISTINCT gateway_id) as gateway, COUNT(*) as session").
 			Where("status = 1").
@@ -122,20 +124,35 @@ func (c *Controller) StatAccount(ctx *gin.Context) {
 		return
 	}
 
-	err := mysql.DB.
-		Model(&model.Account{}).
-		Select("account.name, COUNT(*) AS count").
-		Joins("LEFT JOIN session ON account.id = session.account_id").
-		Group("account.id").
+	// sessions may be kept in another database, so accounts are not joined
+	err := mysql.AuditDB.
+		Model(model.DefaultSession).
+		Select("account_id, COUNT(*) AS count").
+		Where("created_at >= ? AND created_at <= ?", start, end).
+		Group("account_id").
 		Order("count DESC").
 		Limit(10).
-		Where("session.created_at >= ? AND session.created_at <= ?", start, end).
 		Find(&stat).
 		Error
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	accounts := make([]*model.Account, 0)
+	if err = mysql.DB.
+		Model(model.DefaultAccount).
+		Select("id", "name").
+		Where("id IN ?", lo.Map(stat, func(s *model.StatAccount, _ int) int { return s.AccountId })).
+		Find(&accounts).
+		Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	m := lo.SliceToMap(accounts, func(a *model.Account) (int, string) { return a.Id, a.Name })
+	stat = lo.Filter(stat, func(s *model.StatAccount, _ int) bool {
+		s.Name = m[s.AccountId]
+		return s.Name != ""
+	})
 
 	redis.SetEx(ctx, key, stat, time.Minute)
 
@@ -174,7 +191,7 @@ func (c *Controller) StatAsset(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, NewHttpResponseWithData(toListData(stat)))
 		return
 	}
-	err := mysql.DB.
+	err := mysql.AuditDB.
 		Model(model.DefaultSession).
 		Select("COUNT(DISTINCT asset_id, uid) AS connect, COUNT(*) AS session, COUNT(DISTINCT asset_id) AS asset, COUNT(DISTINCT uid) AS user, DATE_FORMAT(created_at, ?) AS time", dateFmt).
 		Where("session.created_at >= ? AND session.created_at <= ?", start, end).
@@ -212,7 +229,7 @@ func (c *Controller) StatCountOfUser(ctx *gin.Context) {
 
 	eg := &errgroup.Group{}
 	eg.Go(func() error {
-		return mysql.DB.
+		return mysql.AuditDB.
 			Model(model.DefaultSession).
 			Select("COUNT(DISTINCT asset_id, account_id) as connect, COUNT(DISTINCT asset_id) as asset, COUNT(*) as session").
 			Where("status = 1").
@@ -255,7 +272,7 @@ func (c *Controller) StatRankOfUser(ctx *gin.Context) {
 		return
 	}
 
-	if err := mysql.DB.
+	if err := mysql.AuditDB.
 		Model(model.DefaultSession).
 		Select("uid, COUNT(*) AS count, MAX(created_at) AS last_time").
 		Group("uid").
@@ -272,6 +289,51 @@ func (c *Controller) StatRankOfUser(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, NewHttpResponseWithData(toListData(stat)))
 }
 
+// StatHeatmap godoc
+//
+//	@Tags		stat
+//	@Param		uid		query		int		false	"user id, default is the current user and only admins could get others"
+//	@Param		start	query		string	false	"start in rfc3339, default is 30 days ago"
+//	@Param		end		query		string	false	"end in rfc3339, default is now"
+//	@Success	200		{object}	HttpResponse{data=model.StatHeatmap}
+//	@Router		/stat/heatmap [get]
+func (c *Controller) StatHeatmap(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	uid := cast.ToInt(ctx.DefaultQuery("uid", cast.ToString(currentUser.GetUid())))
+	if uid != currentUser.GetUid() && !checkAdmin(ctx, "get heatmap of other users") {
+		return
+	}
+
+	end, start := time.Now(), time.Time{}
+	for k, t := range map[string]*time.Time{"start": &start, "end": &end} {
+		q, ok := ctx.GetQuery(k)
+		if !ok {
+			continue
+		}
+		v, err := time.Parse(time.RFC3339, q)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+			return
+		}
+		*t = v
+	}
+	if start.IsZero() {
+		start = end.AddDate(0, 0, -30)
+	}
+	if !start.Before(end) {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "start must be before end"}})
+		return
+	}
+
+	stat, err := gsession.Heatmap(uid, start, end)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(stat))
+}
+
 func toListData[T any](data []T) *ListData {
 	return &ListData{
 		Count: int64(len(data)),
@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/status"
+)
+
+// GetStatus godoc
+//
+//	@Tags		status
+//	@Success	200	{object}	HttpResponse{data=status.Status}
+//	@Router		/status [get]
+func (c *Controller) GetStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(status.Get()))
+}
+
+// Readyz tells load balancers and probes whether the service is ready by checking dependencies now, it is 503 once
+// any required one is down
+func (c *Controller) Readyz(ctx *gin.Context) {
+	s := status.Check(ctx)
+	ctx.JSON(lo.Ternary(s.Status == status.STATUS_DOWN, http.StatusServiceUnavailable, http.StatusOK), NewHttpResponseWithData(s))
+}
@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/stepup"
+	"github.com/veops/oneterm/util"
+)
+
+type stepUpCredentialReq struct {
+	Name   string `json:"name" binding:"required,max=64"`
+	Method string `json:"method" binding:"required,oneof=totp webauthn"`
+	// Code of the secret from /step_up/totp, which enrolls it
+	Code         string `json:"code"`
+	CredentialId string `json:"credential_id" binding:"max=512"`
+	PublicKey    string `json:"public_key"`
+	// StepUpId is a fresh step up by an enrolled credential, which is required unless it is the first one
+	StepUpId int `json:"step_up_id"`
+}
+
+type stepUpTotp struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+// GetStepUpCredentials godoc
+//
+//	@Tags		step_up
+//	@Param		uid	query		int	false	"uid, admins only, current user by default"
+//	@Success	200	{object}	HttpResponse{data=[]model.StepUpCredential}
+//	@Router		/step_up/credential [get]
+func (c *Controller) GetStepUpCredentials(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	uid := currentUser.GetUid()
+	if q, ok := ctx.GetQuery("uid"); ok && cast.ToInt(q) != uid {
+		if !checkAdmin(ctx, "get step up credentials of others") {
+			return
+		}
+		uid = cast.ToInt(q)
+	}
+
+	creds := make([]*model.StepUpCredential, 0)
+	if err := mysql.DB.Model(model.DefaultStepUpCredential).Where("uid = ?", uid).Order("id").Find(&creds).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(creds))
+}
+
+// NewStepUpTotp godoc
+//
+//	@Tags		step_up
+//	@Success	200	{object}	HttpResponse{data=stepUpTotp}	"a secret valid for enrollment in 10 minutes"
+//	@Router		/step_up/totp [get]
+func (c *Controller) NewStepUpTotp(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	secret := stepup.NewSecret()
+	if err := redis.RC.SetEx(ctx, totpSecretKey(currentUser.GetUid()), secret, time.Minute*10).Err(); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(&stepUpTotp{
+		Secret: secret,
+		Uri:    stepup.Uri(conf.Cfg.StepUp.Issuer, currentUser.GetUserName(), secret),
+	}))
+}
+
+// CreateStepUpCredential godoc
+//
+//	@Tags		step_up
+//	@Param		credential	body		stepUpCredentialReq	true	"a totp secret confirmed by its code, or the public key of a webauthn credential"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/step_up/credential [post]
+func (c *Controller) CreateStepUpCredential(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+	uid := currentUser.GetUid()
+
+	req := &stepUpCredentialReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	// otherwise a stolen login could add its own factor
+	if !useStepUpIfEnrolled(ctx, uid, req.StepUpId) {
+		return
+	}
+
+	cred := &model.StepUpCredential{Uid: uid, Name: req.Name, Method: req.Method}
+	switch req.Method {
+	case model.STEPUPMETHOD_TOTP:
+		secret, err := redis.RC.Get(ctx, totpSecretKey(uid)).Result()
+		if err != nil || !stepup.VerifySecret(secret, req.Code) {
+			ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": "wrong code or expired secret"}})
+			return
+		}
+		redis.RC.Del(ctx, totpSecretKey(uid))
+		cred.Secret = util.EncryptAES(secret)
+	case model.STEPUPMETHOD_WEBAUTHN:
+		if _, err := stepup.ParsePublicKey(req.PublicKey); err != nil || req.CredentialId == "" {
+			ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Errorf("credential id and public key are required: %v", err)}})
+			return
+		}
+		cred.CredentialId, cred.PublicKey = req.CredentialId, req.PublicKey
+	}
+	if err := mysql.DB.Create(cred).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(map[string]any{"id": cred.Id}))
+}
+
+// DeleteStepUpCredential godoc
+//
+//	@Tags		step_up
+//	@Param		id			path		int	true	"credential id"
+//	@Param		step_up_id	query		int	false	"a fresh step up, admins delete credentials of others without it"
+//	@Success	200			{object}	HttpResponse
+//	@Router		/step_up/credential/:id [delete]
+func (c *Controller) DeleteStepUpCredential(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	cred := &model.StepUpCredential{}
+	if err := mysql.DB.Model(cred).Where("id = ?", cast.ToInt(ctx.Param("id"))).First(cred).Error; err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if cred.Uid != currentUser.GetUid() {
+		if !checkAdmin(ctx, "delete step up credentials of others") {
+			return
+		}
+	} else if !useStepUpIfEnrolled(ctx, cred.Uid, cast.ToInt(ctx.Query("step_up_id"))) {
+		return
+	}
+	if err := mysql.DB.Delete(cred).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// CreateStepUpChallenge godoc
+//
+//	@Tags		step_up
+//	@Success	200	{object}	HttpResponse{data=stepup.Challenge}
+//	@Router		/step_up/challenge [post]
+func (c *Controller) CreateStepUpChallenge(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	res, err := stepup.NewChallenge(ctx, currentUser.GetUid(), requestHost(ctx))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// CreateStepUp godoc
+//
+//	@Tags		step_up
+//	@Param		proof	body		stepup.Proof	true	"totp code or webauthn assertion"
+//	@Success	200		{object}	HttpResponse{data=model.StepUp}	"pass its id as step_up_id to replays of restricted assets"
+//	@Router		/step_up [post]
+func (c *Controller) CreateStepUp(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	p := &stepup.Proof{}
+	if err := ctx.ShouldBindBodyWithJSON(p); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	res, err := stepup.Verify(ctx, currentUser.GetUid(), currentUser.GetUserName(), ctx.ClientIP(), requestHost(ctx), p)
+	if err != nil {
+		code := lo.Ternary(errors.Is(err, stepup.ErrFailed), http.StatusBadRequest, http.StatusInternalServerError)
+		ctx.AbortWithError(code, &ApiError{Code: lo.Ternary(code == http.StatusBadRequest, ErrStepUp, ErrInternal), Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// GetReplayLogs godoc
+//
+//	@Tags		replay_log
+//	@Param		page_index	query		int		true	"page_index"
+//	@Param		page_size	query		int		true	"page_size"
+//	@Param		start		query		string	false	"start, RFC3339"
+//	@Param		end			query		string	false	"end, RFC3339"
+//	@Param		uid			query		int		false	"uid"
+//	@Param		session_id	query		string	false	"session id"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.ReplayLog}}
+//	@Router		/replay_log [get]
+func (c *Controller) GetReplayLogs(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get replay logs") {
+		return
+	}
+
+	db := mysql.AuditDB.Model(model.DefaultReplayLog)
+	db, err := filterStartEnd(ctx, db)
+	if err != nil {
+		return
+	}
+	db = filterEqual(ctx, db, "uid", "session_id")
+	db = db.Order("id DESC")
+
+	doGet[*model.ReplayLog](ctx, false, db, "")
+}
+
+// authorizeReplay uses the step up in the query if any of the sessions is of a restricted asset, and logs the replay
+func authorizeReplay(ctx *gin.Context, sessions ...*model.Session) bool {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	restricted, err := stepup.Restricted(lo.Map(sessions, func(s *model.Session, _ int) int { return s.AssetId })...)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return false
+	}
+	stepUpId := 0
+	if restricted {
+		stepUpId = cast.ToInt(ctx.Query("step_up_id"))
+		if err = stepup.Use(currentUser.GetUid(), stepUpId); err != nil {
+			code := lo.Ternary(errors.Is(err, stepup.ErrUnused), http.StatusForbidden, http.StatusInternalServerError)
+			ctx.AbortWithError(code, &ApiError{Code: lo.Ternary(code == http.StatusForbidden, ErrStepUp, ErrInternal), Data: map[string]any{"err": err}})
+			return false
+		}
+	}
+
+	logs := lo.Map(sessions, func(s *model.Session, _ int) *model.ReplayLog {
+		return &model.ReplayLog{
+			SessionId: s.SessionId,
+			Uid:       currentUser.GetUid(),
+			UserName:  currentUser.GetUserName(),
+			ClientIp:  ctx.ClientIP(),
+			StepUpId:  stepUpId,
+		}
+	})
+	if err = mysql.AuditDB.Create(&logs).Error; err != nil {
+		logger.L().Error("save replay log failed", zap.Error(err))
+	}
+	return true
+}
+
+// useStepUpIfEnrolled requires a fresh step up of users who have any credential
+func useStepUpIfEnrolled(ctx *gin.Context, uid, stepUpId int) bool {
+	ok, err := stepup.HasCredentials(uid)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return false
+	}
+	if !ok {
+		return true
+	}
+	if err = stepup.Use(uid, stepUpId); err != nil {
+		ctx.AbortWithError(http.StatusForbidden, &ApiError{Code: ErrStepUp, Data: map[string]any{"err": err}})
+		return false
+	}
+	return true
+}
+
+func requestHost(ctx *gin.Context) string {
+	host, _, err := net.SplitHostPort(ctx.Request.Host)
+	if err != nil {
+		return ctx.Request.Host
+	}
+	return host
+}
+
+func totpSecretKey(uid int) string {
+	return fmt.Sprintf("stepup-totp-%d", uid)
+}
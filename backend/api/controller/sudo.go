@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/credential"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	sudoersDir = "/etc/sudoers.d"
+)
+
+var (
+	// sudoers needs absolute path and some characters must be escaped, just reject them
+	reSudoCmd  = regexp.MustCompile(`^/[^\r\n,:=\\]+$`)
+	reSudoUser = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`)
+)
+
+func sudoersPath(sessionId string) string {
+	// files containing '.' are ignored by sudo
+	return path.Join(sudoersDir, "oneterm-"+strings.ReplaceAll(sessionId, ".", "-"))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// installSudoers pushes a sudoers drop-in scoped to sudo cmds of asset for the session account, the returned func removes it
+func installSudoers(sessionId string, asset *model.Asset, account *model.Account, ip string, port int) (remove func(), err error) {
+	if !reSudoUser.MatchString(account.Account) {
+		err = fmt.Errorf("invalid sudo user %s", account.Account)
+		return
+	}
+	cmds := lo.Filter(asset.Sudo.Cmds, func(c string, _ int) bool { return reSudoCmd.MatchString(c) })
+	if len(cmds) == 0 {
+		err = fmt.Errorf("no valid sudo cmds")
+		return
+	}
+
+	p := sudoersPath(sessionId)
+	content := fmt.Sprintf("# oneterm session %s\n%s ALL=(ALL) NOPASSWD: %s\n", sessionId, account.Account, strings.Join(cmds, ", "))
+	script := fmt.Sprintf("tmp=$(mktemp) && printf '%%s' %s > $tmp && visudo -cf $tmp && install -m 0440 $tmp %s; rc=$?; rm -f $tmp; exit $rc",
+		shellQuote(content), p)
+	if err = runPrivileged(asset.Sudo.AccountId, ip, port, script); err != nil {
+		return
+	}
+
+	remove = func() {
+		if err := runPrivileged(asset.Sudo.AccountId, ip, port, "rm -f "+p); err != nil {
+			logger.L().Error("remove sudoers failed", zap.String("sessionId", sessionId), zap.String("path", p), zap.Error(err))
+		}
+	}
+
+	return
+}
+
+func runPrivileged(accountId int, ip string, port int, script string) (err error) {
+	account := &model.Account{}
+	if err = mysql.DB.Model(account).Where("id = ?", accountId).First(account).Error; err != nil {
+		return
+	}
+	account.Password = util.DecryptAES(account.Password)
+	account.Pk = util.DecryptAES(account.Pk)
+	account.Phrase = util.DecryptAES(account.Phrase)
+	if err = credential.Resolve(context.Background(), nil, account); err != nil {
+		return
+	}
+	auth, err := util.GetAuth(account)
+	if err != nil {
+		return
+	}
+
+	cli, err := gossh.Dial("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), &gossh.ClientConfig{
+		User:            account.Account,
+		Auth:            []gossh.AuthMethod{auth},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second * 5,
+	})
+	if err != nil {
+		return
+	}
+	defer cli.Close()
+	sess, err := cli.NewSession()
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+
+	if account.Account != "root" {
+		script = "sudo -n sh -c " + shellQuote(script)
+	}
+	out, err := sess.CombinedOutput(script)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", err, out)
+	}
+
+	return
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/usage"
+)
+
+const (
+	// usageMonths is how many recent months are listed with quotas
+	usageMonths = 12
+)
+
+// UsageRes is usage of licensed limits now and of recent months
+type UsageRes struct {
+	Quotas []*usage.Quota `json:"quotas"`
+	Months []*model.Usage `json:"months"`
+}
+
+// GetUsage godoc
+//
+//	@Tags		usage
+//	@Success	200	{object}	HttpResponse{data=UsageRes}
+//	@Router		/usage [get]
+func (c *Controller) GetUsage(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get usage") {
+		return
+	}
+	quotas, err := usage.Quotas()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	months := make([]*model.Usage, 0)
+	if err = mysql.DB.Model(model.DefaultUsage).Order("month DESC").Limit(usageMonths).Find(&months).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(&UsageRes{Quotas: quotas, Months: months}))
+}
+
+// GetUserUsage godoc
+//
+//	@Tags		usage
+//	@Param		month	query		string	false	"month, e.g. 2006-01, the current one if it is empty"
+//	@Success	200		{object}	HttpResponse{data=[]model.UserUsage}
+//	@Router		/usage/user [get]
+func (c *Controller) GetUserUsage(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get user usage") {
+		return
+	}
+	res, ok := userUsage(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(res))
+}
+
+// ExportUsage godoc
+//
+//	@Tags		usage
+//	@Param		month	query	string	false	"month, e.g. 2006-01, the current one if it is empty"
+//	@Success	200		{file}	file	"csv of usage of users in the month"
+//	@Router		/usage/export [get]
+func (c *Controller) ExportUsage(ctx *gin.Context) {
+	if !checkAdmin(ctx, "export usage") {
+		return
+	}
+	res, ok := userUsage(ctx)
+	if !ok {
+		return
+	}
+
+	month := ctx.DefaultQuery("month", "current")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.csv"`, month))
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Status(http.StatusOK)
+	w := csv.NewWriter(ctx.Writer)
+	w.Write([]string{"uid", "user_name", "sessions", "assets", "duration"})
+	for _, u := range res {
+		w.Write([]string{strconv.Itoa(u.Uid), u.UserName, strconv.FormatInt(u.Sessions, 10), strconv.FormatInt(u.Assets, 10), strconv.FormatInt(u.Duration, 10)})
+	}
+	w.Flush()
+}
+
+func userUsage(ctx *gin.Context) (res []*model.UserUsage, ok bool) {
+	start, end, err := usage.Month(ctx.Query("month"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	if res, err = usage.Users(start, end); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+	return res, true
+}
+
+// licenseError turns refusing by licensed limits into the api error, others are kept
+func licenseError(err error) error {
+	le := &usage.LimitError{}
+	if !errors.As(err, &le) {
+		return err
+	}
+	return &ApiError{Code: ErrLicenseLimit, Data: map[string]any{"quota": le.Quota, "limit": le.Limit}}
+}
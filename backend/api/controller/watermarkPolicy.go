@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+)
+
+const (
+	// watermarkOpcode is not of guacamole, clients ignore it unless they register a handler for it
+	watermarkOpcode = "watermark"
+)
+
+var (
+	colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	watermarkPolicyPreHooks = []preHook[*model.WatermarkPolicy]{
+		func(ctx *gin.Context, data *model.WatermarkPolicy) {
+			msg := ""
+			switch {
+			case strings.TrimSpace(data.Content) == "":
+				msg = "content must not be empty"
+			case data.Color != "" && !colorRegexp.MatchString(data.Color):
+				msg = "color must be like #rrggbb"
+			case data.Opacity < 0 || data.Opacity > 100:
+				msg = "opacity must be a percentage"
+			case data.FontSize < 0:
+				msg = "font size must not be negative"
+			}
+			if msg != "" {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrBadRequest, Data: map[string]any{"err": msg}})
+			}
+		},
+	}
+)
+
+// CreateWatermarkPolicy godoc
+//
+//	@Tags		watermark_policy
+//	@Param		policy	body		model.WatermarkPolicy	true	"watermark policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/watermark_policy [post]
+func (c *Controller) CreateWatermarkPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create watermark policy") {
+		return
+	}
+	doCreate(ctx, false, &model.WatermarkPolicy{}, "", watermarkPolicyPreHooks...)
+}
+
+// DeleteWatermarkPolicy godoc
+//
+//	@Tags		watermark_policy
+//	@Param		id	path		int	true	"watermark policy id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/watermark_policy/:id [delete]
+func (c *Controller) DeleteWatermarkPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete watermark policy") {
+		return
+	}
+	doDelete(ctx, false, &model.WatermarkPolicy{}, "")
+}
+
+// UpdateWatermarkPolicy godoc
+//
+//	@Tags		watermark_policy
+//	@Param		id		path		int						true	"watermark policy id"
+//	@Param		policy	body		model.WatermarkPolicy	true	"watermark policy"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/watermark_policy/:id [put]
+func (c *Controller) UpdateWatermarkPolicy(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update watermark policy") {
+		return
+	}
+	doUpdate(ctx, false, &model.WatermarkPolicy{}, "", watermarkPolicyPreHooks...)
+}
+
+// GetWatermarkPolicies godoc
+//
+//	@Tags		watermark_policy
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name or comment"
+//	@Param		id			query		int		false	"watermark policy id"
+//	@Param		enable		query		int		false	"watermark policy enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.WatermarkPolicy}}
+//	@Router		/watermark_policy [get]
+func (c *Controller) GetWatermarkPolicies(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get watermark policy") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultWatermarkPolicy)
+	db = filterEqual(ctx, db, "id", "enable")
+	db = filterSearch(ctx, db, "name", "comment")
+
+	doGet[*model.WatermarkPolicy](ctx, false, db, "")
+}
+
+// setWatermark renders the watermark of the policy covering the rdp or vnc session, it is sent to web clients once the
+// tunnel is open
+func setWatermark(sess *gsession.Session, rid int) (err error) {
+	if sess.SessionType != model.SESSIONTYPE_WEB {
+		return
+	}
+	policies := make([]*model.WatermarkPolicy, 0)
+	if err = mysql.DB.Model(model.DefaultWatermarkPolicy).Where("enable = ?", true).Order("id").Find(&policies).Error; err != nil {
+		return
+	}
+	p, ok := lo.Find(policies, func(p *model.WatermarkPolicy) bool { return p.InScope(sess.Uid, rid, sess.AssetId) })
+	if !ok {
+		return
+	}
+
+	sess.Watermark = &model.Watermark{
+		Content: strings.NewReplacer(
+			"{user}", sess.UserName,
+			"{ip}", sess.ClientIp,
+			"{asset}", sess.AssetInfo,
+			"{account}", sess.AccountInfo,
+			"{session}", sess.SessionId,
+		).Replace(p.Content),
+		Color:    p.Color,
+		Opacity:  p.Opacity,
+		FontSize: p.FontSize,
+		Angle:    p.Angle,
+	}
+
+	return
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+	"github.com/veops/oneterm/webhook"
+)
+
+var (
+	webhookPreHooks = []preHook[*model.Webhook]{
+		func(ctx *gin.Context, data *model.Webhook) {
+			if err := webhook.Validate(data); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+				return
+			}
+			// secrets are never returned, so the saved one is kept if it is not given on update
+			if id, ok := ctx.Params.Get("id"); ok && data.Secret == "" {
+				old := &model.Webhook{}
+				if err := mysql.DB.Model(old).Where("id = ?", cast.ToInt(id)).First(old).Error; err == nil {
+					data.Secret = old.Secret
+				}
+				return
+			}
+			if data.Secret != "" {
+				data.Secret = util.EncryptAES(data.Secret)
+			}
+		},
+	}
+	webhookPostHooks = []postHook[*model.Webhook]{
+		func(ctx *gin.Context, data []*model.Webhook) {
+			for _, d := range data {
+				d.Secret = ""
+			}
+		},
+	}
+)
+
+// CreateWebhook godoc
+//
+//	@Tags		webhook
+//	@Param		webhook	body		model.Webhook	true	"webhook"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/webhook [post]
+func (c *Controller) CreateWebhook(ctx *gin.Context) {
+	if !checkAdmin(ctx, "create webhook") {
+		return
+	}
+	doCreate(ctx, false, &model.Webhook{}, "", webhookPreHooks...)
+}
+
+// DeleteWebhook godoc
+//
+//	@Tags		webhook
+//	@Param		id	path		int	true	"webhook id"
+//	@Success	200	{object}	HttpResponse
+//	@Router		/webhook/:id [delete]
+func (c *Controller) DeleteWebhook(ctx *gin.Context) {
+	if !checkAdmin(ctx, "delete webhook") {
+		return
+	}
+	doDelete(ctx, false, &model.Webhook{}, "")
+}
+
+// UpdateWebhook godoc
+//
+//	@Tags		webhook
+//	@Param		id		path		int				true	"webhook id"
+//	@Param		webhook	body		model.Webhook	true	"webhook, the saved secret is kept if it is empty"
+//	@Success	200		{object}	HttpResponse
+//	@Router		/webhook/:id [put]
+func (c *Controller) UpdateWebhook(ctx *gin.Context) {
+	if !checkAdmin(ctx, "update webhook") {
+		return
+	}
+	doUpdate(ctx, false, &model.Webhook{}, "", webhookPreHooks...)
+}
+
+// GetWebhooks godoc
+//
+//	@Tags		webhook
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		search		query		string	false	"name, comment or url"
+//	@Param		id			query		int		false	"webhook id"
+//	@Param		enable		query		int		false	"webhook enable"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Webhook}}
+//	@Router		/webhook [get]
+func (c *Controller) GetWebhooks(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get webhook") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultWebhook)
+	db = filterSearch(ctx, db, "name", "comment", "url")
+	db = filterEqual(ctx, db, "id", "enable")
+
+	doGet(ctx, false, db, "", webhookPostHooks...)
+}
+
+// GetWebhookDeliveries godoc
+//
+//	@Tags		webhook
+//	@Param		page_index	query		int		true	"page index"
+//	@Param		page_size	query		int		true	"page size"
+//	@Param		webhook_id	query		int		false	"webhook id"
+//	@Param		status		query		int		false	"1 pending, 2 success or 3 failed"
+//	@Param		type		query		string	false	"event type, e.g. session.open"
+//	@Param		event_id	query		string	false	"event id"
+//	@Param		session_id	query		string	false	"session id"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.WebhookDelivery}}
+//	@Router		/webhook/delivery [get]
+func (c *Controller) GetWebhookDeliveries(ctx *gin.Context) {
+	if !checkAdmin(ctx, "get webhook delivery") {
+		return
+	}
+
+	db := mysql.DB.Model(model.DefaultWebhookDelivery)
+	db = filterEqual(ctx, db, "webhook_id", "status", "type", "event_id", "session_id")
+
+	doGet[*model.WebhookDelivery](ctx, false, db, "")
+}
+
+// RedeliverWebhook godoc
+//
+//	@Tags		webhook
+//	@Param		id	path		int	true	"webhook delivery id"
+//	@Success	200	{object}	HttpResponse	"the delivery is pending with all attempts again and posted within seconds"
+//	@Router		/webhook/delivery/:id/redeliver [post]
+func (c *Controller) RedeliverWebhook(ctx *gin.Context) {
+	if !checkAdmin(ctx, "redeliver webhook") {
+		return
+	}
+
+	if err := webhook.Redeliver(cast.ToInt(ctx.Param("id"))); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
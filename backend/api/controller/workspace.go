@@ -0,0 +1,296 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/acl"
+	mysql "github.com/veops/oneterm/db"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	// forwardBufSize is the most bytes of a forwarded connection sent in one message
+	forwardBufSize = 32 * 1024
+)
+
+type workspaceReq struct {
+	AssetId   int   `json:"asset_id" binding:"required"`
+	AccountId int   `json:"account_id" binding:"required"`
+	Ports     []int `json:"ports"`
+}
+
+// CreateWorkspace godoc
+//
+//	@Tags		workspace
+//	@Param		workspace	body		workspaceReq	true	"asset, account and ports to forward, ports must be of protocols of the asset"
+//	@Success	200			{object}	HttpResponse{data=model.Workspace}	"terminals join it by workspace_id of connect, the file browser goes by its terminals"
+//	@Router		/workspace [post]
+func (c *Controller) CreateWorkspace(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	req := &workspaceReq{}
+	if err := ctx.ShouldBindBodyWithJSON(req); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	asset, _, _, err := util.GetAAG(req.AssetId, req.AccountId)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": err}})
+		return
+	}
+	sess := &gsession.Session{Session: &model.Session{Uid: currentUser.GetUid(), AssetId: req.AssetId, AccountId: req.AccountId, Protocol: "tcp"}}
+	if err = authorizeSession(ctx, sess, asset); err != nil {
+		ctx.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+	ports := assetPorts(asset)
+	if invalid, _ := lo.Difference(req.Ports, ports); len(invalid) > 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("ports %v are not of protocols of the asset", invalid)}})
+		return
+	}
+
+	workspace := &model.Workspace{
+		Uid:       currentUser.GetUid(),
+		UserName:  currentUser.GetUserName(),
+		AssetId:   req.AssetId,
+		AccountId: req.AccountId,
+		Ports:     lo.Uniq(req.Ports),
+		Status:    model.WORKSPACESTATUS_OPEN,
+	}
+	if err = mysql.AuditDB.Create(workspace).Error; err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": err}})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewHttpResponseWithData(workspace))
+}
+
+// GetWorkspaces godoc
+//
+//	@Tags		workspace
+//	@Param		page_index	query		int	true	"page index"
+//	@Param		page_size	query		int	true	"page size"
+//	@Param		uid			query		int	false	"owner, admins only, others get workspaces of their own"
+//	@Param		asset_id	query		int	false	"asset id"
+//	@Param		status		query		int	false	"1 open, 2 closed"
+//	@Success	200			{object}	HttpResponse{data=ListData{list=[]model.Workspace}}	"sessions of workspaces are listed by workspace_id of sessions"
+//	@Router		/workspace [get]
+func (c *Controller) GetWorkspaces(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	db := mysql.AuditDB.Model(model.DefaultWorkspace)
+	if acl.IsAdmin(currentUser) {
+		db = filterEqual(ctx, db, "uid")
+	} else {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+	db = filterEqual(ctx, db, "asset_id", "status")
+	db = db.Order("id DESC")
+
+	doGet[*model.Workspace](ctx, false, db, "")
+}
+
+// CloseWorkspace godoc
+//
+//	@Tags		workspace
+//	@Param		id	path		int	true	"workspace id"
+//	@Success	200	{object}	HttpResponse	"terminals and port forwards of it are closed together"
+//	@Router		/workspace/:id/close [post]
+func (c *Controller) CloseWorkspace(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	id, now := cast.ToInt(ctx.Param("id")), time.Now()
+	db := mysql.AuditDB.Model(model.DefaultWorkspace).Where("id = ? AND status = ?", id, model.WORKSPACESTATUS_OPEN)
+	// admins close workspaces of anyone
+	if !acl.IsAdmin(currentUser) {
+		db = db.Where("uid = ?", currentUser.GetUid())
+	}
+	res := db.Updates(map[string]any{"status": model.WORKSPACESTATUS_CLOSED, "closed_by": currentUser.GetUserName(), "closed_at": now})
+	if res.Error != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, &ApiError{Code: ErrInternal, Data: map[string]any{"err": res.Error}})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("workspace %d is not open for you", id)}})
+		return
+	}
+	n := gsession.CloseSessions(func(s *gsession.Session) bool { return s.WorkspaceId == id }, currentUser.GetUserName())
+	logger.L().Info("workspace closed", zap.Int("id", id), zap.String("by", currentUser.GetUserName()), zap.Int("closed", n))
+
+	ctx.JSON(http.StatusOK, defaultHttpResponse)
+}
+
+// ConnectWorkspaceForward godoc
+//
+//	@Tags		workspace
+//	@Param		id		path		int	true	"workspace id"
+//	@Param		port	path		int	true	"port of the asset, one of ports of the workspace"
+//	@Success	200		{object}	HttpResponse	"binary messages carry the tcp stream both ways"
+//	@Router		/workspace/:id/forward/:port [get]
+func (c *Controller) ConnectWorkspaceForward(ctx *gin.Context) {
+	currentUser, _ := acl.GetSessionFromCtx(ctx)
+
+	ws, err := Upgrader.Upgrade(ctx.Writer, ctx.Request, http.Header{
+		"sec-websocket-protocol": {ctx.GetHeader("sec-websocket-protocol")},
+	})
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+	started := false
+	defer func() {
+		closeWs(ws, err, started)
+	}()
+
+	workspace, err := getOpenWorkspace(cast.ToInt(ctx.Param("id")), currentUser.GetUid())
+	if err != nil {
+		return
+	}
+	port := cast.ToInt(ctx.Param("port"))
+	if !lo.Contains(workspace.Ports, port) {
+		err = &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("port %d is not forwarded in workspace %d", port, workspace.Id)}}
+		return
+	}
+	asset, account, gateway, err := util.GetAAG(workspace.AssetId, workspace.AccountId)
+	if err != nil {
+		return
+	}
+
+	sess := gsession.NewSession(ctx)
+	defer sess.IdleTk.Stop()
+	sess.Session = &model.Session{
+		SessionType: model.SESSIONTYPE_WEB,
+		SessionId:   uuid.New().String(),
+		Uid:         currentUser.GetUid(),
+		UserName:    currentUser.GetUserName(),
+		AssetId:     asset.Id,
+		AssetInfo:   fmt.Sprintf("%s(%s)", asset.Name, asset.Ip),
+		AccountId:   account.Id,
+		AccountInfo: fmt.Sprintf("%s(%s)", account.Name, account.Account),
+		GatewayId:   asset.GatewayId,
+		GatewayInfo: lo.Ternary(asset.GatewayId == 0, "", fmt.Sprintf("%s(%s)", gateway.Name, gateway.Host)),
+		ClientIp:    ctx.ClientIP(),
+		Protocol:    fmt.Sprintf("tcp:%d", port),
+		Status:      model.SESSIONSTATUS_ONLINE,
+		WorkspaceId: workspace.Id,
+	}
+	sess.Chans.SessionId = sess.SessionId
+	// forwards are sessions like terminals, so they go through the same checks and limits
+	if err = authorizeSession(ctx, sess, asset); err != nil {
+		return
+	}
+	if err = takeSlot(sess, asset); err != nil {
+		return
+	}
+	defer sess.Slot.Release()
+
+	ip := asset.Ip
+	if asset.GatewayId != 0 && gateway != nil {
+		g, e := ggateway.GetGatewayManager().Open(false, sess.SessionId, ip, port, gateway)
+		if err = e; err != nil {
+			return
+		}
+		defer ggateway.GetGatewayManager().Close(sess.SessionId)
+		ip, port = g.LocalIp, g.LocalPort
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, cast.ToString(port)), time.Second*3)
+	if err != nil {
+		err = &ApiError{Code: ErrConnectServer, Data: map[string]any{"err": err}}
+		return
+	}
+	defer conn.Close()
+
+	started = true
+	gsession.GetOnlineSession().Store(sess.SessionId, sess)
+	gsession.UpsertSession(sess)
+	defer func() {
+		gsession.GetOnlineSession().Delete(sess.SessionId)
+		sess.Status, sess.ClosedAt = model.SESSIONSTATUS_OFFLINE, lo.ToPtr(time.Now())
+		gsession.UpsertSession(sess)
+	}()
+
+	sess.G.Go(func() error {
+		for {
+			_, p, err := ws.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if _, err = conn.Write(p); err != nil {
+				return err
+			}
+		}
+	})
+	sess.G.Go(func() error {
+		buf := make([]byte, forwardBufSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return err
+			}
+			if err = ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				return err
+			}
+		}
+	})
+	// readers of both ends are unblocked by closing them once either stops or the workspace is closed
+	sess.G.Go(func() error {
+		defer conn.Close()
+		defer ws.Close()
+		select {
+		case <-sess.Gctx.Done():
+			return nil
+		case closer := <-sess.Chans.CloseChan:
+			closeWs(ws, &ApiError{Code: ErrAdminClose, Data: map[string]any{"admin": closer}}, true)
+			return fmt.Errorf("closed by %s", closer)
+		}
+	})
+	if e := sess.G.Wait(); e != nil {
+		logger.L().Debug("forward stopped", zap.String("sessionId", sess.SessionId), zap.Error(e))
+	}
+}
+
+// joinWorkspace puts the session into the workspace, only its owner joins it with the asset and account of it
+func joinWorkspace(sess *gsession.Session, id int) error {
+	workspace, err := getOpenWorkspace(id, sess.Uid)
+	if err != nil {
+		return err
+	}
+	if workspace.AssetId != sess.AssetId || workspace.AccountId != sess.AccountId {
+		return &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("workspace %d is of another asset or account", id)}}
+	}
+	sess.WorkspaceId = id
+	return nil
+}
+
+func getOpenWorkspace(id, uid int) (workspace *model.Workspace, err error) {
+	workspace = &model.Workspace{}
+	if err = mysql.AuditDB.Model(workspace).Where("id = ? AND uid = ? AND status = ?", id, uid, model.WORKSPACESTATUS_OPEN).First(workspace).Error; err != nil {
+		err = &ApiError{Code: ErrInvalidArgument, Data: map[string]any{"err": fmt.Sprintf("workspace %d is not open for you", id)}}
+	}
+	return
+}
+
+// assetPorts returns ports of protocols of the asset, e.g. 22 of ssh:22
+func assetPorts(asset *model.Asset) []int {
+	return lo.FilterMap(asset.Protocols, func(p string, _ int) (int, bool) {
+		ss := strings.Split(p, ":")
+		port := cast.ToInt(ss[len(ss)-1])
+		return port, len(ss) == 2 && port > 0
+	})
+}
@@ -2,6 +2,7 @@ package file
 
 import (
 	"fmt"
+	"net"
 	"sync"
 	"time"
 
@@ -85,7 +86,7 @@ func (fm *FileManager) GetFileClient(assetId, accountId int) (cli *sftp.Client,
 		return
 	}
 
-	sshCli, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", ip, port), &ssh.ClientConfig{
+	sshCli, err := ssh.Dial("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), &ssh.ClientConfig{
 		User:            account.Account,
 		Auth:            []ssh.AuthMethod{auth},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
@@ -0,0 +1,114 @@
+package guacd
+
+import (
+	"bytes"
+	"encoding/base64"
+)
+
+const (
+	clipboardPrefix = "9.clipboard,"
+	blobPrefix      = "4.blob,"
+	endPrefix       = "3.end,"
+)
+
+// ClipboardRule is what a clipboard filter lets through, MaxSize is in bytes and 0 means no limit
+type ClipboardRule struct {
+	Allow   bool
+	MaxSize int
+}
+
+type clipStream struct {
+	mimetype string
+	held     [][]byte
+	data     []byte
+	blocked  bool
+}
+
+// Clipboard filters clipboard streams of one direction, streams are held until they end so that ones beyond
+// the size limit are dropped as a whole. OnEnd is called with the content of every stream ended, blocked ones included
+//
+//	https://guacamole.apache.org/doc/gug/protocol-reference.html#clipboard
+type Clipboard struct {
+	Rule    ClipboardRule
+	OnEnd   func(mimetype string, data []byte, blocked bool)
+	streams map[string]*clipStream
+}
+
+func NewClipboard(rule ClipboardRule, onEnd func(mimetype string, data []byte, blocked bool)) *Clipboard {
+	return &Clipboard{
+		Rule:    rule,
+		OnEnd:   onEnd,
+		streams: map[string]*clipStream{},
+	}
+}
+
+// Filter returns data without clipboard streams being held or blocked, data may contain several instructions
+func (c *Clipboard) Filter(data []byte) []byte {
+	if c == nil || (len(c.streams) == 0 && !bytes.Contains(data, []byte(clipboardPrefix))) {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	for idx := 0; idx < len(data); {
+		end := instructionEnd(data[idx:])
+		if end < 0 {
+			// incomplete ones are not ours to judge
+			out = append(out, data[idx:]...)
+			break
+		}
+		ins := data[idx : idx+end+1]
+		idx += end + 1
+		out = append(out, c.filter(ins)...)
+	}
+	return out
+}
+
+func (c *Clipboard) filter(ins []byte) []byte {
+	isClipboard, isBlob, isEnd := bytes.HasPrefix(ins, []byte(clipboardPrefix)), bytes.HasPrefix(ins, []byte(blobPrefix)), bytes.HasPrefix(ins, []byte(endPrefix))
+	if !isClipboard && !isBlob && !isEnd {
+		return ins
+	}
+	i := (&Instruction{}).Parse(string(ins))
+	if len(i.Args) == 0 {
+		return ins
+	}
+	index := i.Args[0]
+
+	if isClipboard {
+		s := &clipStream{blocked: !c.Rule.Allow}
+		if len(i.Args) > 1 {
+			s.mimetype = i.Args[1]
+		}
+		if !s.blocked {
+			s.held = append(s.held, ins)
+		}
+		c.streams[index] = s
+		return nil
+	}
+
+	s, ok := c.streams[index]
+	if !ok {
+		return ins
+	}
+	if isBlob {
+		if s.blocked || len(i.Args) < 2 {
+			return nil
+		}
+		bs, _ := base64.StdEncoding.DecodeString(i.Args[1])
+		s.data = append(s.data, bs...)
+		s.held = append(s.held, ins)
+		if c.Rule.MaxSize > 0 && len(s.data) > c.Rule.MaxSize {
+			s.blocked, s.held = true, nil
+		}
+		return nil
+	}
+
+	delete(c.streams, index)
+	if c.OnEnd != nil {
+		c.OnEnd(s.mimetype, s.data, s.blocked)
+	}
+	if s.blocked {
+		return nil
+	}
+	return append(bytes.Join(s.held, nil), ins...)
+}
@@ -2,6 +2,7 @@ package guacd
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	ggateway "github.com/veops/oneterm/gateway"
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/tracing"
 )
 
 const (
@@ -20,6 +22,8 @@ const (
 	RECORDING_PATH   = "/replay"
 	CREATE_RECORDING = "true"
 	IGNORE_CERT      = "true"
+	// DRIVE_PATH drives of sessions are under it on guacd
+	DRIVE_PATH = "/drive"
 )
 
 type Configuration struct {
@@ -43,8 +47,25 @@ type Tunnel struct {
 	gw           *ggateway.GatewayTunnel
 }
 
-func NewTunnel(connectionId, sessionId string, w, h, dpi int, protocol string, asset *model.Asset, account *model.Account, gateway *model.Gateway) (t *Tunnel, err error) {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", conf.Cfg.Guacd.Host, conf.Cfg.Guacd.Port), time.Second*3)
+// NewTunnel connects guacd, phases of it are traced as children of the span in ctx
+func NewTunnel(ctx context.Context, connectionId, sessionId string, w, h, dpi int, protocol string, asset *model.Asset, account *model.Account, gateway *model.Gateway) (t *Tunnel, err error) {
+	return newTunnel(ctx, connectionId, sessionId, w, h, dpi, protocol, asset, account, gateway, []string{"image/jpeg", "image/png", "image/webp"}, true)
+}
+
+// NewSharedTunnel joins an existing connection with control, mouse and key of it are arbitrated by the caller
+func NewSharedTunnel(connectionId string, w, h, dpi int) (t *Tunnel, err error) {
+	return newTunnel(context.Background(), connectionId, "", w, h, dpi, ":", nil, nil, nil, []string{"image/jpeg", "image/png", "image/webp"}, false)
+}
+
+// NewDisplayTunnel joins an existing connection as read-only with the image types could be decoded by Display
+func NewDisplayTunnel(connectionId string, w, h, dpi int) (t *Tunnel, err error) {
+	return newTunnel(context.Background(), connectionId, "", w, h, dpi, ":", nil, nil, nil, []string{"image/jpeg", "image/png"}, true)
+}
+
+func newTunnel(ctx context.Context, connectionId, sessionId string, w, h, dpi int, protocol string, asset *model.Asset, account *model.Account, gateway *model.Gateway, images []string, readOnly bool) (t *Tunnel, err error) {
+	_, span := tracing.Start(ctx, "guacd.dial")
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(conf.Cfg.Guacd.Host, fmt.Sprint(conf.Cfg.Guacd.Port)), time.Second*3)
+	span.End(err)
 	if err != nil {
 		return
 	}
@@ -82,7 +103,7 @@ func NewTunnel(connectionId, sessionId string, w, h, dpi int, protocol string, a
 						"width":     cast.ToString(w),
 						"height":    cast.ToString(h),
 						"dpi":       cast.ToString(dpi),
-						"read-only": "true",
+						"read-only": lo.Ternary(readOnly, "true", ""),
 					}
 				}),
 		},
@@ -90,9 +111,17 @@ func NewTunnel(connectionId, sessionId string, w, h, dpi int, protocol string, a
 	if t.ConnectionId == "" {
 		t.SessionId = sessionId
 		t.Config.Parameters["recording-name"] = t.SessionId
+		if protocol == "rdp" && cfg.RdpConfig.Drive {
+			t.Config.Parameters["enable-drive"] = "true"
+			t.Config.Parameters["drive-name"] = "oneterm"
+			t.Config.Parameters["drive-path"] = DRIVE_PATH + "/" + t.SessionId
+			t.Config.Parameters["create-drive-path"] = "true"
+		}
 	}
 	if gateway != nil && gateway.Id != 0 && t.ConnectionId == "" {
+		_, span = tracing.Start(ctx, "gateway.open")
 		t.gw, err = ggateway.GetGatewayManager().Open(false, t.SessionId, asset.Ip, cast.ToInt(port), gateway)
+		span.End(err)
 		if err != nil {
 			return t, err
 		}
@@ -100,7 +129,10 @@ func NewTunnel(connectionId, sessionId string, w, h, dpi int, protocol string, a
 		t.Config.Parameters["port"] = cast.ToString(t.gw.LocalPort)
 	}
 
-	err = t.handshake()
+	// the handshake waits for guacd to connect the remote desktop, it is the most of the time usually
+	_, span = tracing.Start(ctx, "guacd.handshake")
+	err = t.handshake(images)
+	span.End(err)
 
 	return
 }
@@ -108,7 +140,7 @@ func NewTunnel(connectionId, sessionId string, w, h, dpi int, protocol string, a
 // handshake
 //
 //	https://guacamole.apache.org/doc/gug/guacamole-protocol.html#handshake-phase
-func (t *Tunnel) handshake() (err error) {
+func (t *Tunnel) handshake(images []string) (err error) {
 	defer func() {
 		if err != nil {
 			t.conn.Close()
@@ -140,7 +172,7 @@ func (t *Tunnel) handshake() (err error) {
 	if _, err = t.WriteInstruction(NewInstruction("video")); err != nil {
 		return
 	}
-	if _, err = t.WriteInstruction(NewInstruction("image", "image/jpeg", "image/png", "image/webp")); err != nil {
+	if _, err = t.WriteInstruction(NewInstruction("image", images...)); err != nil {
 		return
 	}
 
@@ -215,6 +247,15 @@ func (t *Tunnel) Close() {
 	ggateway.GetGatewayManager().Close(t.SessionId)
 }
 
+// Lock presses win+l on the remote desktop, the keys are given some time to reach it before disconnection
+func (t *Tunnel) Lock() (err error) {
+	if _, err = t.Write(Combo([]int{keysyms["win"], 'l'})); err != nil {
+		return
+	}
+	time.Sleep(time.Millisecond * 500)
+	return
+}
+
 func (t *Tunnel) Disconnect() {
 	logger.L().Debug("client disconnect")
 	t.WriteInstruction(NewInstruction("disconnect"))
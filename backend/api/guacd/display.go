@@ -0,0 +1,227 @@
+package guacd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	maskSrc = 0xC
+)
+
+type imgStream struct {
+	layer int
+	mask  int
+	x, y  int
+	data  bytes.Buffer
+}
+
+// Display renders the drawing instructions of the default layer, it is enough for previews and screenshots
+//
+//	https://guacamole.apache.org/doc/gug/protocol-reference.html#drawing-instructions
+type Display struct {
+	layers  map[int]*image.RGBA
+	streams map[string]*imgStream
+	paths   map[int]image.Rectangle
+	mtx     sync.Mutex
+}
+
+func NewDisplay() *Display {
+	return &Display{
+		layers:  map[int]*image.RGBA{},
+		streams: map[string]*imgStream{},
+		paths:   map[int]image.Rectangle{},
+	}
+}
+
+// Run reads instructions from tunnel until it is broken
+func (d *Display) Run(t *Tunnel) error {
+	return d.run(t, nil)
+}
+
+// RunUntilSync reads instructions from tunnel until the first frame of default layer is completed
+func (d *Display) RunUntilSync(t *Tunnel, timeout time.Duration) error {
+	t.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer t.conn.SetReadDeadline(time.Time{})
+	return d.run(t, func() bool {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return !d.layer(0).Bounds().Empty()
+	})
+}
+
+func (d *Display) run(t *Tunnel, stop func() bool) (err error) {
+	for {
+		var ins *Instruction
+		if ins, err = t.ReadInstruction(); err != nil {
+			return
+		}
+		switch ins.Opcode {
+		case "sync":
+			if _, err = t.WriteInstruction(NewInstruction("sync", ins.Args...)); err != nil {
+				return
+			}
+			if stop != nil && stop() {
+				return
+			}
+		case "error", "disconnect":
+			return fmt.Errorf("guacd %s %v", ins.Opcode, ins.Args)
+		default:
+			d.Handle(ins)
+		}
+	}
+}
+
+func (d *Display) Handle(ins *Instruction) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	args := ins.Args
+	switch ins.Opcode {
+	case "size":
+		if len(args) < 3 {
+			return
+		}
+		d.resize(cast.ToInt(args[0]), cast.ToInt(args[1]), cast.ToInt(args[2]))
+	case "img":
+		if len(args) < 6 {
+			return
+		}
+		d.streams[args[0]] = &imgStream{
+			mask:  cast.ToInt(args[1]),
+			layer: cast.ToInt(args[2]),
+			x:     cast.ToInt(args[4]),
+			y:     cast.ToInt(args[5]),
+		}
+	case "blob":
+		if len(args) < 2 {
+			return
+		}
+		s, ok := d.streams[args[0]]
+		if !ok {
+			return
+		}
+		bs, err := base64.StdEncoding.DecodeString(args[1])
+		if err != nil {
+			return
+		}
+		s.data.Write(bs)
+	case "end":
+		if len(args) < 1 {
+			return
+		}
+		s, ok := d.streams[args[0]]
+		if !ok {
+			return
+		}
+		delete(d.streams, args[0])
+		img, _, err := image.Decode(&s.data)
+		if err != nil {
+			return
+		}
+		dst := d.layer(s.layer)
+		draw.Draw(dst, img.Bounds().Add(image.Pt(s.x, s.y)), img, img.Bounds().Min, toOp(s.mask))
+	case "rect":
+		if len(args) < 5 {
+			return
+		}
+		x, y := cast.ToInt(args[1]), cast.ToInt(args[2])
+		r := image.Rect(x, y, x+cast.ToInt(args[3]), y+cast.ToInt(args[4]))
+		idx := cast.ToInt(args[0])
+		d.paths[idx] = d.paths[idx].Union(r)
+	case "cfill":
+		if len(args) < 6 {
+			return
+		}
+		idx := cast.ToInt(args[1])
+		r, ok := d.paths[idx]
+		if !ok {
+			return
+		}
+		delete(d.paths, idx)
+		c := color.NRGBA{R: uint8(cast.ToInt(args[2])), G: uint8(cast.ToInt(args[3])), B: uint8(cast.ToInt(args[4])), A: uint8(cast.ToInt(args[5]))}
+		draw.Draw(d.layer(idx), r, image.NewUniform(c), image.Point{}, toOp(cast.ToInt(args[0])))
+	case "copy":
+		if len(args) < 9 {
+			return
+		}
+		src, ok := d.layers[cast.ToInt(args[0])]
+		if !ok {
+			return
+		}
+		sx, sy := cast.ToInt(args[1]), cast.ToInt(args[2])
+		w, h := cast.ToInt(args[3]), cast.ToInt(args[4])
+		dx, dy := cast.ToInt(args[7]), cast.ToInt(args[8])
+		draw.Draw(d.layer(cast.ToInt(args[6])), image.Rect(dx, dy, dx+w, dy+h), src, image.Pt(sx, sy), toOp(cast.ToInt(args[5])))
+	case "dispose":
+		if len(args) < 1 {
+			return
+		}
+		delete(d.layers, cast.ToInt(args[0]))
+	}
+}
+
+// Image returns a copy of the default layer
+func (d *Display) Image() *image.RGBA {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	src := d.layer(0)
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+
+	return dst
+}
+
+// Thumbnail returns the default layer scaled to width w
+func (d *Display) Thumbnail(w int) *image.RGBA {
+	src := d.Image()
+	b := src.Bounds()
+	if w <= 0 || b.Dx() <= w {
+		return src
+	}
+	h := b.Dy() * w / b.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := y * b.Dy() / h
+		for x := 0; x < w; x++ {
+			sx := x * b.Dx() / w
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+func (d *Display) layer(idx int) *image.RGBA {
+	l, ok := d.layers[idx]
+	if !ok {
+		l = image.NewRGBA(image.Rect(0, 0, 0, 0))
+		d.layers[idx] = l
+	}
+	return l
+}
+
+func (d *Display) resize(idx, w, h int) {
+	old := d.layer(idx)
+	l := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(l, old.Bounds(), old, image.Point{}, draw.Src)
+	d.layers[idx] = l
+}
+
+func toOp(mask int) draw.Op {
+	if mask == maskSrc {
+		return draw.Src
+	}
+	return draw.Over
+}
@@ -2,7 +2,8 @@ package guacd
 
 import (
 	"fmt"
-	"strings"
+	"strconv"
+	"unicode/utf8"
 )
 
 const (
@@ -32,9 +33,10 @@ func (i *Instruction) String() string {
 		return i.cache
 	}
 
-	i.cache = fmt.Sprintf("%d.%s", len(i.Opcode), i.Opcode)
+	// lengths are of unicode code points instead of bytes
+	i.cache = fmt.Sprintf("%d.%s", utf8.RuneCountInString(i.Opcode), i.Opcode)
 	for _, value := range i.Args {
-		i.cache += fmt.Sprintf(",%d.%s", len(value), value)
+		i.cache += fmt.Sprintf(",%d.%s", utf8.RuneCountInString(value), value)
 	}
 	i.cache += string(delimiter)
 	return i.cache
@@ -45,20 +47,28 @@ func (i *Instruction) Bytes() []byte {
 }
 
 func (i *Instruction) Parse(content string) *Instruction {
-	if strings.LastIndex(content, ";") > 0 {
-		content = strings.TrimRight(content, ";")
-	}
-	elements := strings.Split(content, ",")
-
-	var args = make([]string, len(elements))
-	for i, e := range elements {
-		ss := strings.Split(e, ".")
-		if len(ss) < 2 {
-			continue
+	rs := []rune(content)
+	elements := make([]string, 0)
+	for idx := 0; idx < len(rs); {
+		dot := idx
+		for dot < len(rs) && rs[dot] != '.' {
+			dot++
+		}
+		n, err := strconv.Atoi(string(rs[idx:dot]))
+		if err != nil || n < 0 || dot+1+n > len(rs) {
+			break
 		}
-		args[i] = ss[1]
+		elements = append(elements, string(rs[dot+1:dot+1+n]))
+		idx = dot + 1 + n
+		if idx >= len(rs) || rs[idx] == delimiter {
+			break
+		}
+		idx++
+	}
+	if len(elements) == 0 {
+		return NewInstruction("")
 	}
-	return NewInstruction(args[0], args[1:]...)
+	return NewInstruction(elements[0], elements[1:]...)
 }
 
 func IsActive(p []byte) bool {
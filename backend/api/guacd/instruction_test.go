@@ -0,0 +1,138 @@
+package guacd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestInstructionParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		opcode  string
+		args    []string
+	}{
+		{
+			name:    "opcode only",
+			content: "4.sync;",
+			opcode:  "sync",
+		},
+		{
+			name:    "args",
+			content: "4.size,1.0,4.1024,3.768;",
+			opcode:  "size",
+			args:    []string{"0", "1024", "768"},
+		},
+		{
+			name:    "empty arg",
+			content: "9.clipboard,0.,10.text/plain;",
+			opcode:  "clipboard",
+			args:    []string{"", "text/plain"},
+		},
+		{
+			name:    "without delimiter",
+			content: "4.sync,8.12345678",
+			opcode:  "sync",
+			args:    []string{"12345678"},
+		},
+		{
+			name:    "only the first instruction",
+			content: "4.sync,1.1;5.mouse,1.0,1.0;",
+			opcode:  "sync",
+			args:    []string{"1"},
+		},
+		{
+			name:    "lengths of multi-byte utf-8 are of code points",
+			content: "4.blob,1.1,5.你好，世界;",
+			opcode:  "blob",
+			args:    []string{"1", "你好，世界"},
+		},
+		{
+			name:    "delimiters and dots inside values",
+			content: "4.name,5.a.b;c;",
+			opcode:  "name",
+			args:    []string{"a.b;c"},
+		},
+		{
+			name:    "emoji of 4 bytes",
+			content: "3.key,2.😀x;",
+			opcode:  "key",
+			args:    []string{"😀x"},
+		},
+		{
+			name:    "truncated element",
+			content: "4.size,1.0,4.10",
+			opcode:  "size",
+			args:    []string{"0"},
+		},
+		{
+			name:    "truncated opcode",
+			content: "5.mou",
+			opcode:  "",
+		},
+		{
+			name:    "length without dot",
+			content: "4.sync,12",
+			opcode:  "sync",
+		},
+		{
+			name:    "length of bytes instead of code points",
+			content: "4.blob,6.你好;",
+			opcode:  "blob",
+		},
+		{
+			name:    "non-numeric length",
+			content: "x.sync;",
+			opcode:  "",
+		},
+		{
+			name:    "negative length",
+			content: "4.size,-1.0;",
+			opcode:  "size",
+		},
+		{
+			name:    "empty length",
+			content: ".sync;",
+			opcode:  "",
+		},
+		{
+			name:    "empty",
+			content: "",
+			opcode:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := (&Instruction{}).Parse(tt.content)
+			if got.Opcode != tt.opcode || !slices.Equal(got.Args, tt.args) {
+				t.Errorf("Parse() = %q %q, want %q %q", got.Opcode, got.Args, tt.opcode, tt.args)
+			}
+		})
+	}
+}
+
+func TestInstructionString(t *testing.T) {
+	tests := []struct {
+		name string
+		ins  *Instruction
+		want string
+	}{
+		{
+			name: "opcode only",
+			ins:  NewInstruction("sync"),
+			want: "4.sync;",
+		},
+		{
+			name: "multi-byte utf-8",
+			ins:  NewInstruction("blob", "你好"),
+			want: "4.blob,2.你好;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ins.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package guacd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	// KeysOpcode is a private instruction of web clients asking to press a combination like ctrl+alt+del,
+	// which is captured by browsers or the os if it is pressed for real
+	KeysOpcode = "oneterm-keys"
+)
+
+var (
+	// keysyms of names in combinations, letters and digits are keysyms of themselves
+	//
+	//	https://www.cl.cam.ac.uk/~mgk25/ucs/keysymdef.h
+	//	https://cgit.freedesktop.org/xorg/proto/x11proto/tree/XF86keysym.h
+	keysyms = map[string]int{
+		"ctrl":        0xffe3,
+		"alt":         0xffe9,
+		"shift":       0xffe1,
+		"win":         0xffeb,
+		"del":         0xffff,
+		"backspace":   0xff08,
+		"tab":         0xff09,
+		"enter":       0xff0d,
+		"esc":         0xff1b,
+		"space":       0x0020,
+		"home":        0xff50,
+		"left":        0xff51,
+		"up":          0xff52,
+		"right":       0xff53,
+		"down":        0xff54,
+		"pageup":      0xff55,
+		"pagedown":    0xff56,
+		"end":         0xff57,
+		"insert":      0xff63,
+		"printscreen": 0xff61,
+		"menu":        0xff67,
+		"mute":        0x1008ff12,
+		"volumedown":  0x1008ff11,
+		"volumeup":    0x1008ff13,
+		"play":        0x1008ff14,
+		"stop":        0x1008ff15,
+		"prev":        0x1008ff16,
+		"next":        0x1008ff17,
+	}
+)
+
+func init() {
+	for i := 1; i <= 24; i++ {
+		keysyms[fmt.Sprintf("f%d", i)] = 0xffbe + i - 1
+	}
+}
+
+// ParseCombo parses names joined by + into keysyms, e.g. ctrl+alt+del
+func ParseCombo(combo string) (keys []int, err error) {
+	for _, name := range strings.Split(strings.ToLower(strings.TrimSpace(combo)), "+") {
+		name = strings.TrimSpace(name)
+		k, ok := keysyms[name]
+		if !ok && len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= '0' && name[0] <= '9') {
+			k, ok = int(name[0]), true
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q in %q", name, combo)
+		}
+		keys = append(keys, k)
+	}
+	return
+}
+
+// Combo presses keys in order and releases them in reverse
+func Combo(keys []int) (p []byte) {
+	for _, k := range keys {
+		p = append(p, NewInstruction("key", cast.ToString(k), "1").Bytes()...)
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		p = append(p, NewInstruction("key", cast.ToString(keys[i]), "0").Bytes()...)
+	}
+	return
+}
+
+// ExpandKeys turns the keys instruction in p into key instructions of the combination, the combination is looked up
+// in mapping first so that web clients could bind shortcuts which are not captured to it. Other instructions are returned as they are
+func ExpandKeys(p []byte, mapping map[string]string) ([]byte, error) {
+	i := (&Instruction{}).Parse(string(p))
+	if i.Opcode != KeysOpcode || len(i.Args) == 0 {
+		return p, nil
+	}
+	combo := strings.ToLower(strings.TrimSpace(i.Args[0]))
+	if v, ok := mapping[combo]; ok {
+		combo = v
+	}
+	keys, err := ParseCombo(combo)
+	if err != nil {
+		return nil, err
+	}
+	return Combo(keys), nil
+}
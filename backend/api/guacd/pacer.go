@@ -0,0 +1,182 @@
+package guacd
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	syncPrefix = "4.sync,"
+	// weight of new samples in the moving average of latency
+	latencyAlpha = 0.2
+	// a level is restored when latency drops below this ratio of its threshold, so that it does not flap
+	latencyRestore = 0.7
+)
+
+// paceLevels are latency thresholds of clients and the minimal intervals of frames sent to them
+var paceLevels = []struct {
+	latency  time.Duration
+	interval time.Duration
+}{
+	{0, 0},
+	{time.Millisecond * 150, time.Millisecond * 66},
+	{time.Millisecond * 300, time.Millisecond * 200},
+	{time.Millisecond * 600, time.Millisecond * 500},
+}
+
+// Pacer measures the round trip latency of a client by the sync instructions it acknowledges,
+// and holds frames to a lower rate for slow clients instead of flooding them until they stutter.
+// Acks of held frames reach guacd late as well, so guacd sees the lag and lowers its own frame rate and lossy quality,
+// both are restored when the latency improves.
+//
+//	https://guacamole.apache.org/doc/gug/protocol-reference.html#sync
+type Pacer struct {
+	mtx     sync.Mutex
+	buf     bytes.Buffer
+	frames  int
+	sent    map[string]time.Time
+	latency time.Duration
+	level   int
+	last    time.Time
+}
+
+func NewPacer() *Pacer {
+	return &Pacer{
+		sent: map[string]time.Time{},
+	}
+}
+
+// Out takes an instruction from guacd and returns what should be sent to the client now, nil if it is held
+func (p *Pacer) Out(ins []byte) []byte {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.buf.Write(ins)
+	if !bytes.HasPrefix(ins, []byte(syncPrefix)) {
+		// instructions are held with their frame once there is a frame held
+		if p.frames > 0 || p.level > 0 {
+			return nil
+		}
+		return p.flush()
+	}
+	p.frames++
+	if time.Since(p.last) < paceLevels[p.level].interval {
+		return nil
+	}
+	return p.flush()
+}
+
+// Tick returns held frames whose interval has passed, it should be called periodically while there is no output of guacd
+func (p *Pacer) Tick() []byte {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.frames <= 0 || time.Since(p.last) < paceLevels[p.level].interval {
+		return nil
+	}
+	return p.flush()
+}
+
+// In takes data from the client and updates the latency by the syncs in it
+func (p *Pacer) In(data []byte) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for _, ts := range syncTimestamps(data) {
+		t, ok := p.sent[ts]
+		if !ok {
+			continue
+		}
+		delete(p.sent, ts)
+		rtt := time.Since(t)
+		if p.latency == 0 {
+			p.latency = rtt
+		} else {
+			p.latency = time.Duration(latencyAlpha*float64(rtt) + (1-latencyAlpha)*float64(p.latency))
+		}
+	}
+	for p.level+1 < len(paceLevels) && p.latency >= paceLevels[p.level+1].latency {
+		p.level++
+	}
+	for p.level > 0 && float64(p.latency) < float64(paceLevels[p.level].latency)*latencyRestore {
+		p.level--
+	}
+}
+
+// Latency returns the moving average of round trip latency and the current level, 0 means not limited
+func (p *Pacer) Latency() (time.Duration, int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.latency, p.level
+}
+
+func (p *Pacer) flush() []byte {
+	if p.buf.Len() <= 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, ts := range syncTimestamps(p.buf.Bytes()) {
+		p.sent[ts] = now
+	}
+	// syncs lost by the client are not waited forever
+	for ts, t := range p.sent {
+		if now.Sub(t) > time.Minute {
+			delete(p.sent, ts)
+		}
+	}
+	out := bytes.Clone(p.buf.Bytes())
+	p.buf.Reset()
+	p.frames = 0
+	p.last = now
+	return out
+}
+
+// syncTimestamps returns timestamps of sync instructions in data, which may contain several instructions
+func syncTimestamps(data []byte) (tss []string) {
+	for idx := 0; idx < len(data); {
+		end := instructionEnd(data[idx:])
+		if end < 0 {
+			return
+		}
+		if ins := data[idx : idx+end]; bytes.HasPrefix(ins, []byte(syncPrefix)) {
+			if i := (&Instruction{}).Parse(string(ins)); len(i.Args) > 0 {
+				tss = append(tss, i.Args[0])
+			}
+		}
+		idx += end + 1
+	}
+	return
+}
+
+// instructionEnd returns the index of the delimiter ending the first instruction of data, elements are skipped by their lengths
+// since they may contain the delimiter as well
+func instructionEnd(data []byte) int {
+	for idx := 0; idx < len(data); {
+		dot := bytes.IndexByte(data[idx:], '.')
+		if dot < 0 {
+			return -1
+		}
+		n, err := strconv.Atoi(string(data[idx : idx+dot]))
+		if err != nil {
+			return -1
+		}
+		// lengths are counted in characters
+		idx += dot + 1
+		for ; n > 0 && idx < len(data); n-- {
+			_, size := utf8.DecodeRune(data[idx:])
+			idx += size
+		}
+		if idx >= len(data) {
+			return -1
+		}
+		if data[idx] == delimiter {
+			return idx
+		}
+		idx++
+	}
+	return -1
+}
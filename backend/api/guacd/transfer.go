@@ -0,0 +1,88 @@
+package guacd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+)
+
+const (
+	filePrefix = "4.file,"
+	putPrefix  = "3.put,"
+	bodyPrefix = "4.body,"
+
+	// streamIndexMimetype is of directory listings of drives which are not files
+	streamIndexMimetype = "application/vnd.glyptodon.guacamole.stream-index+json"
+)
+
+type transferStream struct {
+	filename string
+	size     int64
+	hash     hash.Hash
+}
+
+// Transfer tracks file streams of one direction, which are files dropped to or pushed from the remote desktop
+// and ones put to or got from its drive. Content is hashed as it passes and OnEnd is called once a stream ends
+//
+//	https://guacamole.apache.org/doc/gug/protocol-reference.html#streaming-instructions
+type Transfer struct {
+	OnEnd   func(filename string, size int64, sum string)
+	streams map[string]*transferStream
+}
+
+func NewTransfer(onEnd func(filename string, size int64, sum string)) *Transfer {
+	return &Transfer{
+		OnEnd:   onEnd,
+		streams: map[string]*transferStream{},
+	}
+}
+
+// Track looks into data without changing it, data may contain several instructions
+func (t *Transfer) Track(data []byte) {
+	if t == nil || (len(t.streams) == 0 &&
+		!bytes.Contains(data, []byte(filePrefix)) && !bytes.Contains(data, []byte(putPrefix)) && !bytes.Contains(data, []byte(bodyPrefix))) {
+		return
+	}
+
+	for idx := 0; idx < len(data); {
+		end := instructionEnd(data[idx:])
+		if end < 0 {
+			return
+		}
+		t.track(data[idx : idx+end+1])
+		idx += end + 1
+	}
+}
+
+func (t *Transfer) track(ins []byte) {
+	isFile, isStream := bytes.HasPrefix(ins, []byte(filePrefix)), bytes.HasPrefix(ins, []byte(putPrefix)) || bytes.HasPrefix(ins, []byte(bodyPrefix))
+	isBlob, isEnd := bytes.HasPrefix(ins, []byte(blobPrefix)), bytes.HasPrefix(ins, []byte(endPrefix))
+	if !isFile && !isStream && !isBlob && !isEnd {
+		return
+	}
+	i := (&Instruction{}).Parse(string(ins))
+
+	switch {
+	case isFile && len(i.Args) >= 3:
+		t.streams[i.Args[0]] = &transferStream{filename: i.Args[2], hash: sha256.New()}
+	case isStream && len(i.Args) >= 4:
+		if i.Args[2] != streamIndexMimetype {
+			t.streams[i.Args[1]] = &transferStream{filename: i.Args[3], hash: sha256.New()}
+		}
+	case isBlob && len(i.Args) >= 2:
+		if s, ok := t.streams[i.Args[0]]; ok {
+			bs, _ := base64.StdEncoding.DecodeString(i.Args[1])
+			s.hash.Write(bs)
+			s.size += int64(len(bs))
+		}
+	case isEnd && len(i.Args) >= 1:
+		if s, ok := t.streams[i.Args[0]]; ok {
+			delete(t.streams, i.Args[0])
+			if t.OnEnd != nil {
+				t.OnEnd(s.filename, s.size, hex.EncodeToString(s.hash.Sum(nil)))
+			}
+		}
+	}
+}
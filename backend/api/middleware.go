@@ -8,12 +8,10 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"go.uber.org/zap"
 
 	"github.com/veops/oneterm/acl"
 	"github.com/veops/oneterm/api/controller"
-	myi18n "github.com/veops/oneterm/i18n"
 	"github.com/veops/oneterm/logger"
 )
 
@@ -102,7 +100,7 @@ func (w bodyWriter) Write(b []byte) (int, error) {
 
 func Error2Resp() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if strings.Contains(ctx.Request.URL.String(), "session/replay") {
+		if url := ctx.Request.URL.String(); strings.Contains(url, "session/replay") || strings.Contains(url, "session/screenshot") || strings.Contains(url, "session/x11/") {
 			ctx.Next()
 			return
 		}
@@ -128,11 +126,7 @@ func Error2Resp() gin.HandlerFunc {
 
 				ae, ok := e.(*controller.ApiError)
 				if ok {
-					lang := ctx.PostForm("lang")
-					accept := ctx.GetHeader("Accept-Language")
-					localizer := i18n.NewLocalizer(myi18n.Bundle, lang, accept)
-					obj["message"] = ae.Message(localizer)
-
+					obj["message"] = ae.MessageWithCtx(ctx)
 				}
 			}
 		}
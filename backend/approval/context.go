@@ -0,0 +1,77 @@
+package approval
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	recentSessionCount = 10
+	buildTimeout       = time.Second * 5
+)
+
+// Context is the information attached to an approval request so that approvers do not need to look it up elsewhere
+type Context struct {
+	AssetId        int              `json:"asset_id"`
+	AssetInfo      string           `json:"asset_info"`
+	RiskTags       []string         `json:"risk_tags"`
+	Owners         []model.Contact  `json:"owners"`
+	RecentSessions []*model.Session `json:"recent_sessions"`
+	ChangeFreeze   bool             `json:"change_freeze"`
+	FreezeReason   string           `json:"freeze_reason"`
+}
+
+// BuildContext assembles approval context of the requester and the target asset
+func BuildContext(ctx context.Context, uid, assetId int) (res *Context, err error) {
+	res = &Context{AssetId: assetId, RiskTags: []string{}, Owners: []model.Contact{}, RecentSessions: []*model.Session{}}
+
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		asset := &model.Asset{}
+		if err := mysql.DB.WithContext(gctx).Model(asset).Select("id", "name", "ip", "risk_tags", "owners").Where("id = ?", assetId).First(asset).Error; err != nil {
+			return err
+		}
+		res.AssetInfo = asset.Name + "(" + asset.Ip + ")"
+		res.RiskTags = append(res.RiskTags, asset.RiskTags...)
+		res.Owners = append(res.Owners, asset.Owners...)
+		return nil
+	})
+	eg.Go(func() error {
+		return mysql.AuditDB.WithContext(gctx).
+			Model(model.DefaultSession).
+			Where("uid = ?", uid).
+			Order("id DESC").
+			Limit(recentSessionCount).
+			Find(&res.RecentSessions).
+			Error
+	})
+	if cfg := model.GlobalConfig.Load(); cfg != nil {
+		res.ChangeFreeze = cfg.ChangeFreeze.InEffect(time.Now())
+		res.FreezeReason = cfg.ChangeFreeze.Reason
+	}
+
+	err = eg.Wait()
+
+	return
+}
+
+// BuildContextAsync assembles approval context in background and calls fn when it is done,
+// a partial context is passed to fn if some of the sources failed
+func BuildContextAsync(uid, assetId int, fn func(*Context)) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+		defer cancel()
+		res, err := BuildContext(ctx, uid, assetId)
+		if err != nil {
+			logger.L().Warn("build approval context failed", zap.Int("uid", uid), zap.Int("assetId", assetId), zap.Error(err))
+		}
+		fn(res)
+	}()
+}
@@ -0,0 +1,31 @@
+package approval
+
+import (
+	"sync"
+)
+
+var (
+	subscribers = &sync.Map{}
+)
+
+// Subscribe returns a channel receiving notifications for approvers, it must be unsubscribed with the same key
+func Subscribe(key string) <-chan any {
+	ch := make(chan any, 16)
+	subscribers.Store(key, ch)
+	return ch
+}
+
+func Unsubscribe(key string) {
+	subscribers.Delete(key)
+}
+
+// Notify sends v to all subscribers, slow subscribers miss it rather than block the sender
+func Notify(v any) {
+	subscribers.Range(func(key, value any) bool {
+		select {
+		case value.(chan any) <- v:
+		default:
+		}
+		return true
+	})
+}
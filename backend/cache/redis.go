@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -20,7 +21,7 @@ var (
 
 func init() {
 	ctx := context.Background()
-	addr := fmt.Sprintf("%s:%d", conf.Cfg.Redis.Host, conf.Cfg.Redis.Port)
+	addr := net.JoinHostPort(conf.Cfg.Redis.Host, fmt.Sprint(conf.Cfg.Redis.Port))
 	RC = redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: conf.Cfg.Redis.Password,
@@ -0,0 +1,245 @@
+package canary
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/api/guacd"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	running atomic.Bool
+	last    time.Time
+	// failures in a row by targets, only touched by the one holding running
+	failures = map[string]int{}
+)
+
+// RunDue runs all targets if the interval has passed since the last run
+func RunDue() {
+	cfg := conf.Cfg.Canary
+	if cfg.Interval <= 0 || len(cfg.Targets) == 0 || time.Since(last) < time.Minute*time.Duration(cfg.Interval) {
+		return
+	}
+	last = time.Now()
+	if _, err := Run(); err != nil {
+		logger.L().Warn("run canary targets failed", zap.Error(err))
+	}
+}
+
+// Run opens a synthetic session of each target at the same time and saves the results, targets failing in a row are
+// published as canary.failed so that admins are alerted
+func Run() (runs []*model.CanaryRun, err error) {
+	if !running.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("canary targets are running")
+	}
+	defer running.Store(false)
+
+	cfg := conf.Cfg.Canary
+	runs = make([]*model.CanaryRun, len(cfg.Targets))
+	wg := sync.WaitGroup{}
+	for i, t := range cfg.Targets {
+		wg.Add(1)
+		go func(i int, t conf.CanaryTarget) {
+			defer wg.Done()
+			runs[i] = run(t, time.Second*time.Duration(max(cfg.Timeout, 1)))
+		}(i, t)
+	}
+	wg.Wait()
+
+	for i, r := range runs {
+		k := key(cfg.Targets[i])
+		if r.Success {
+			delete(failures, k)
+			continue
+		}
+		failures[k]++
+		r.Failures = failures[k]
+		logger.L().Warn("canary target failed", zap.String("target", k), zap.String("stage", r.Stage), zap.String("message", r.Message))
+	}
+	if len(runs) > 0 {
+		if err = mysql.AuditDB.Create(runs).Error; err != nil {
+			return
+		}
+	}
+	for _, r := range runs {
+		if !r.Success && r.Failures >= max(cfg.Failures, 1) {
+			eventbus.Publish(eventbus.TYPE_CANARY_FAILED, "", r)
+		}
+	}
+
+	return
+}
+
+// Expire deletes runs before t
+func Expire(t time.Time) (n int64, err error) {
+	db := mysql.AuditDB.Where("created_at < ?", t).Delete(model.DefaultCanaryRun)
+	return db.RowsAffected, db.Error
+}
+
+func key(t conf.CanaryTarget) string {
+	return fmt.Sprintf("%d-%d-%s", t.AssetId, t.AccountId, strings.ToLower(t.Protocol))
+}
+
+// run opens a synthetic session of the target, sessions stuck beyond timeout are taken as failed and left to end by
+// themselves
+func run(t conf.CanaryTarget, timeout time.Duration) (r *model.CanaryRun) {
+	r = &model.CanaryRun{AssetId: t.AssetId, AccountId: t.AccountId, Protocol: strings.ToLower(t.Protocol), CreatedAt: time.Now()}
+	ch, res, stage := make(chan *model.CanaryRun, 1), *r, &atomic.Value{}
+	stage.Store(model.CANARYSTAGE_PREPARE)
+	go func() {
+		if err := probe(&res, timeout, stage); err != nil {
+			res.Stage, res.Message = stage.Load().(string), err.Error()
+		}
+		res.Success = res.Stage == ""
+		ch <- &res
+	}()
+
+	start := time.Now()
+	select {
+	case r = <-ch:
+	case <-time.After(timeout):
+		r.Stage, r.Message = stage.Load().(string), fmt.Sprintf("timeout after %s", timeout)
+	}
+	r.Latency = int(time.Since(start).Milliseconds())
+	return
+}
+
+// probe stores the stage it is in, so that where it is stuck is known if it times out
+func probe(r *model.CanaryRun, timeout time.Duration, stage *atomic.Value) (err error) {
+	asset, account, gateway, err := util.GetAAG(r.AssetId, r.AccountId)
+	if err != nil {
+		return
+	}
+	protocol, ok := lo.Find(asset.Protocols, func(p string) bool {
+		return strings.ToLower(strings.Split(p, ":")[0]) == r.Protocol
+	})
+	if !ok {
+		return fmt.Errorf("protocol %s is not of asset %s", r.Protocol, asset.Name)
+	}
+	// recordings and tunnels of gateways are named by it, so they are told apart from sessions of users
+	sessionId := "canary-" + uuid.NewString()
+
+	switch r.Protocol {
+	case "ssh":
+		return probeSsh(sessionId, asset, account, gateway, timeout, stage)
+	case "rdp", "vnc":
+		return probeGuacd(sessionId, strings.ToLower(protocol), asset, account, gateway, timeout, stage)
+	default:
+		return fmt.Errorf("protocol %s is not supported by canaries", r.Protocol)
+	}
+}
+
+// probeSsh logins by the account, opens a shell with a pty and waits for the output of a command like users do
+func probeSsh(sessionId string, asset *model.Asset, account *model.Account, gateway *model.Gateway, timeout time.Duration, stage *atomic.Value) (err error) {
+	defer ggateway.GetGatewayManager().Close(sessionId)
+
+	auth, err := util.GetAuth(account)
+	if err != nil {
+		return
+	}
+	stage.Store(model.CANARYSTAGE_CONNECT)
+	ip, port, err := util.Proxy(false, sessionId, "ssh", asset, gateway)
+	if err != nil {
+		return
+	}
+	cli, err := gossh.Dial("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), &gossh.ClientConfig{
+		User:            account.Account,
+		Auth:            []gossh.AuthMethod{auth},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return
+	}
+	defer cli.Close()
+
+	stage.Store(model.CANARYSTAGE_SESSION)
+	sess, err := cli.NewSession()
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+	if err = sess.RequestPty("xterm", 24, 80, gossh.TerminalModes{gossh.ECHO: 1}); err != nil {
+		return
+	}
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err = sess.Shell(); err != nil {
+		return
+	}
+
+	// the marker is printed in two parts so that the echo of the command typed is not taken as its output
+	stage.Store(model.CANARYSTAGE_OUTPUT)
+	marker := uuid.NewString()
+	if _, err = fmt.Fprintf(stdin, "printf '%%s-%%s\\n' canary %s\n", marker); err != nil {
+		return
+	}
+	if err = waitFor(stdout, []byte("canary-"+marker)); err != nil {
+		return
+	}
+	fmt.Fprint(stdin, "exit\n")
+	return
+}
+
+// waitFor reads r until want is seen, it is left to the caller to close r if it never is
+func waitFor(r io.Reader, want []byte) (err error) {
+	buf, p := &bytes.Buffer{}, make([]byte, 4096)
+	for {
+		n, e := r.Read(p)
+		buf.Write(p[:n])
+		if bytes.Contains(buf.Bytes(), want) {
+			return nil
+		}
+		if e != nil {
+			return fmt.Errorf("output ends before the marker: %w", e)
+		}
+		// only the tail could still be the start of the marker
+		if buf.Len() > len(p)*4 {
+			buf.Next(buf.Len() - len(want))
+		}
+	}
+}
+
+// probeGuacd connects the remote desktop by guacd and waits for its first screen
+func probeGuacd(sessionId, protocol string, asset *model.Asset, account *model.Account, gateway *model.Gateway, timeout time.Duration, stage *atomic.Value) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stage.Store(model.CANARYSTAGE_CONNECT)
+	t, err := guacd.NewTunnel(ctx, "", sessionId, 1024, 768, 96, protocol, asset, account, gateway)
+	if t != nil {
+		defer t.Close()
+	}
+	if err != nil {
+		return
+	}
+	defer t.Disconnect()
+
+	stage.Store(model.CANARYSTAGE_OUTPUT)
+	return guacd.NewDisplay().RunUntilSync(t, timeout)
+}
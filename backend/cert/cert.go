@@ -0,0 +1,170 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	CHALLENGE_HTTP = "http"
+	CHALLENGE_DNS  = "dns"
+
+	// reloadInterval of checking files changed and certificates of dns challenges due
+	reloadInterval = time.Minute
+	// renewBefore renews certificates of dns challenges so long before they expire, like autocert does
+	renewBefore = time.Hour * 24 * 30
+	// retryInterval of failed renewals, so that rate limits of the acme server are not hit
+	retryInterval = time.Hour
+)
+
+var (
+	current  atomic.Pointer[tls.Certificate]
+	modTime  time.Time
+	manager  *autocert.Manager
+	retryAt  time.Time
+	ctx, cnl = context.WithCancel(context.Background())
+)
+
+// TLSConfig returns the config serving certificates by the config, it is nil if tls is off. Certificates of acme are
+// issued before it returns if there are none cached
+func TLSConfig() (*tls.Config, error) {
+	cfg := conf.Cfg.Http.Tls
+	if !cfg.Enable {
+		return nil, nil
+	}
+	switch a := cfg.Acme; {
+	case a.Enable && len(a.Domains) == 0:
+		return nil, fmt.Errorf("domains of acme are empty")
+	case a.Enable && a.Challenge == CHALLENGE_HTTP:
+		// tls-alpn challenges are answered by the config of autocert as well, which also renews certificates
+		manager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(a.Domains...),
+			Cache:      autocert.DirCache(a.Cache),
+			Email:      a.Email,
+		}
+		if a.Directory != "" {
+			manager.Client = &acme.Client{DirectoryURL: a.Directory}
+		}
+		return manager.TLSConfig(), nil
+	case a.Enable && a.Challenge == CHALLENGE_DNS:
+		if a.DnsHook == "" {
+			return nil, fmt.Errorf("dns hook of acme is empty")
+		}
+		if err := renewDns(); err != nil {
+			return nil, err
+		}
+	case a.Enable:
+		return nil, fmt.Errorf("unsupported acme challenge %s", a.Challenge)
+	default:
+		if _, err := reloadFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return current.Load(), nil
+		},
+	}, nil
+}
+
+// HttpHandler answers http challenges and redirects other requests to https, it is nil unless acme is on
+func HttpHandler() http.Handler {
+	if manager != nil {
+		return manager.HTTPHandler(nil)
+	}
+	if !conf.Cfg.Http.Tls.Acme.Enable {
+		return nil
+	}
+	return http.HandlerFunc(redirect)
+}
+
+// Run reloads files changed and renews certificates of dns challenges until it is stopped, autocert renews its own
+func Run() {
+	cfg := conf.Cfg.Http.Tls
+	if !cfg.Enable || manager != nil {
+		return
+	}
+	tk := time.NewTicker(reloadInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+		if cfg.Acme.Enable {
+			if err := renewDns(); err != nil {
+				logger.L().Error("renew certificate failed", zap.Strings("domains", cfg.Acme.Domains), zap.Error(err))
+			}
+			continue
+		}
+		if ok, err := reloadFiles(); err != nil {
+			logger.L().Error("reload certificate failed", zap.String("cert", cfg.Cert), zap.Error(err))
+		} else if ok {
+			logger.L().Info("certificate reloaded", zap.String("cert", cfg.Cert))
+		}
+	}
+}
+
+func Stop() {
+	defer cnl()
+}
+
+// reloadFiles loads the cert and the key once either of them is modified after the last load, the former one is
+// kept if they fail, e.g. if they are being written
+func reloadFiles() (ok bool, err error) {
+	cfg := conf.Cfg.Http.Tls
+	t := time.Time{}
+	for _, name := range []string{cfg.Cert, cfg.Key} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	if !t.After(modTime) {
+		return
+	}
+	c, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return
+	}
+	current.Store(&c)
+	modTime = t
+	return true, nil
+}
+
+func redirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "use https", http.StatusBadRequest)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	port := conf.Cfg.Http.Port
+	if port != 443 {
+		host = net.JoinHostPort(host, fmt.Sprint(port))
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
@@ -0,0 +1,219 @@
+package cert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	// issueTimeout bounds one issuance including waits of propagation
+	issueTimeout = time.Minute * 10
+	hookTimeout  = time.Minute
+
+	accountKeyFile = "acme_account.key"
+	dnsCertFile    = "dns.crt"
+	dnsKeyFile     = "dns.key"
+)
+
+// renewDns loads the certificate cached and issues a new one by dns challenges once it is due, failures are not
+// retried before the retry interval passes
+func renewDns() (err error) {
+	cfg := conf.Cfg.Http.Tls.Acme
+	if current.Load() == nil {
+		if c, err := tls.LoadX509KeyPair(filepath.Join(cfg.Cache, dnsCertFile), filepath.Join(cfg.Cache, dnsKeyFile)); err == nil {
+			if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err == nil {
+				current.Store(&c)
+			}
+		}
+	}
+	if c := current.Load(); c != nil && c.Leaf != nil && time.Until(c.Leaf.NotAfter) > renewBefore && coversDomains(c.Leaf, cfg.Domains) {
+		return
+	}
+	if time.Now().Before(retryAt) {
+		return
+	}
+
+	ictx, cancel := context.WithTimeout(ctx, issueTimeout)
+	defer cancel()
+	c, err := issueDns(ictx, cfg)
+	if err != nil {
+		retryAt = time.Now().Add(retryInterval)
+		return
+	}
+	current.Store(c)
+	logger.L().Info("certificate issued by dns challenges", zap.Strings("domains", cfg.Domains), zap.Time("notAfter", c.Leaf.NotAfter))
+	return
+}
+
+// issueDns orders a certificate of the domains, txt records of challenges are set and removed by the hook
+//
+//	https://datatracker.ietf.org/doc/html/rfc8555#section-8.4
+func issueDns(ctx context.Context, cfg conf.AcmeConfig) (c *tls.Certificate, err error) {
+	if err = os.MkdirAll(cfg.Cache, 0700); err != nil {
+		return
+	}
+	accountKey, err := loadKey(filepath.Join(cfg.Cache, accountKeyFile))
+	if err != nil {
+		return
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: cfg.Directory}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+	account := &acme.Account{}
+	if cfg.Email != "" {
+		account.Contact = []string{"mailto:" + cfg.Email}
+	}
+	if _, err = client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(cfg.Domains...))
+	if err != nil {
+		return
+	}
+	for _, u := range order.AuthzURLs {
+		if err = authorizeDns(ctx, client, u, cfg); err != nil {
+			return
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: cfg.Domains}, key)
+	if err != nil {
+		return
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return
+	}
+	c = &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}
+
+	// a pair saved in half fails to load, so that it is issued again instead of being served
+	certPem := []byte{}
+	for _, b := range der {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	if err = os.WriteFile(filepath.Join(cfg.Cache, dnsCertFile), certPem, 0600); err != nil {
+		return
+	}
+	err = saveKey(filepath.Join(cfg.Cache, dnsKeyFile), key)
+	return
+}
+
+func authorizeDns(ctx context.Context, client *acme.Client, u string, cfg conf.AcmeConfig) (err error) {
+	z, err := client.GetAuthorization(ctx, u)
+	if err != nil || z.Status == acme.StatusValid {
+		return
+	}
+	var chal *acme.Challenge
+	for _, ch := range z.Challenges {
+		if ch.Type == "dns-01" {
+			chal = ch
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no dns challenge of %s", z.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return
+	}
+	// wildcards are validated by the record of their base domains
+	fqdn := "_acme-challenge." + strings.TrimPrefix(z.Identifier.Value, "*.")
+	if err = runHook(ctx, cfg.DnsHook, "present", fqdn, value); err != nil {
+		return
+	}
+	defer func() {
+		if e := runHook(context.Background(), cfg.DnsHook, "cleanup", fqdn, value); e != nil {
+			logger.L().Warn("clean up txt record failed", zap.String("fqdn", fqdn), zap.Error(e))
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Second * time.Duration(cfg.Propagation)):
+	}
+	if _, err = client.Accept(ctx, chal); err != nil {
+		return
+	}
+	_, err = client.WaitAuthorization(ctx, z.URI)
+	return
+}
+
+func runHook(ctx context.Context, hook string, args ...string) error {
+	hctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(hctx, hook, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", hook, args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// coversDomains tells whether the certificate is of all the domains, so that changing domains gets a new one
+func coversDomains(leaf *x509.Certificate, domains []string) bool {
+	for _, d := range domains {
+		if leaf.VerifyHostname(strings.Replace(d, "*", "x", 1)) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loadKey reads the ec key of the file, a new one is generated and saved if it does not exist
+func loadKey(name string) (crypto.Signer, error) {
+	bs, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return key, saveKey(name, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("no pem in %s", name)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func saveKey(name string, key *ecdsa.PrivateKey) error {
+	bs, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: bs}), 0600)
+}
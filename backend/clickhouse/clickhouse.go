@@ -0,0 +1,81 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/remote"
+)
+
+var (
+	ddls = []string{
+		`CREATE TABLE IF NOT EXISTS %s.session_cmd (
+			id UInt64,
+			session_id String,
+			cmd String,
+			result String,
+			level UInt8,
+			user_name String,
+			created_at DateTime64(3)
+		) ENGINE = MergeTree PARTITION BY toYYYYMM(created_at) ORDER BY (session_id, created_at)`,
+		// typers of commands are recorded since collaborators could type into sessions
+		`ALTER TABLE %s.session_cmd ADD COLUMN IF NOT EXISTS user_name String AFTER level`,
+		`CREATE TABLE IF NOT EXISTS %s.session_event (
+			session_id String,
+			session_type UInt8,
+			uid Int64,
+			user_name String,
+			asset_id Int64,
+			asset_info String,
+			account_id Int64,
+			account_info String,
+			gateway_id Int64,
+			client_ip String,
+			protocol String,
+			status UInt8,
+			created_at DateTime64(3),
+			closed_at Nullable(DateTime64(3))
+		) ENGINE = MergeTree PARTITION BY toYYYYMM(created_at) ORDER BY (uid, created_at, session_id)`,
+	}
+)
+
+// migrate creates tables which do not exist and adds columns which are added later
+func migrate(ctx context.Context) (err error) {
+	for _, ddl := range ddls {
+		if err = exec(ctx, fmt.Sprintf(ddl, conf.Cfg.Clickhouse.Database), nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// insert writes rows of json lines into the table
+func insert(ctx context.Context, table string, rows []byte) error {
+	return exec(ctx, fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", conf.Cfg.Clickhouse.Database, table), rows)
+}
+
+// exec runs the query by the http interface, data of inserts follows the query in the body
+//
+//	https://clickhouse.com/docs/en/interfaces/http
+func exec(ctx context.Context, query string, data []byte) (err error) {
+	cfg := conf.Cfg.Clickhouse
+	resp, err := remote.RC.R().
+		SetContext(ctx).
+		SetHeader("X-ClickHouse-User", cfg.User).
+		SetHeader("X-ClickHouse-Key", cfg.Password).
+		SetQueryParams(map[string]string{
+			"date_time_input_format":           "best_effort",
+			"input_format_skip_unknown_fields": "1",
+		}).
+		SetBody(bytes.NewReader(append([]byte(query+"\n"), data...))).
+		Post(cfg.Url)
+	if err != nil {
+		return
+	}
+	if resp.IsError() {
+		return fmt.Errorf("clickhouse %s: %s", resp.Status(), bytes.TrimSpace(resp.Body()))
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+)
+
+var (
+	// tables maps tables of the audit db to tables of clickhouse, sessions are written on creation and closing
+	tables = map[string]string{
+		"session_cmd": "session_cmd",
+		"session":     "session_event",
+	}
+	rows        chan *row
+	dropped     atomic.Int64
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+type row struct {
+	table string
+	data  []byte
+}
+
+func init() {
+	cfg := conf.Cfg.Clickhouse
+	if cfg.Url == "" {
+		return
+	}
+	rows = make(chan *row, cfg.BufferSize)
+	if err := mysql.AuditDB.Callback().Create().After("gorm:create").Register("clickhouse:sink", capture); err != nil {
+		logger.L().Fatal("register clickhouse sink failed", zap.Error(err))
+	}
+}
+
+// capture queues created rows of tables in clickhouse, they are dropped rather than blocking sessions if the buffer is full
+func capture(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	table, ok := tables[db.Statement.Schema.Table]
+	if !ok {
+		return
+	}
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	vs := []reflect.Value{rv}
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		vs = make([]reflect.Value, rv.Len())
+		for i := range vs {
+			vs[i] = rv.Index(i)
+		}
+	}
+	for _, v := range vs {
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+		bs, err := json.Marshal(v.Interface())
+		if err != nil {
+			continue
+		}
+		select {
+		case rows <- &row{table: table, data: bs}:
+		default:
+			dropped.Add(1)
+		}
+	}
+}
+
+// RunSink writes queued rows in batches until it is stopped, it only waits if clickhouse is not configured
+func RunSink() (err error) {
+	cfg := conf.Cfg.Clickhouse
+	if cfg.Url == "" {
+		<-ctx.Done()
+		return
+	}
+	if err := migrate(ctx); err != nil {
+		logger.L().Error("migrate clickhouse failed", zap.Error(err))
+	}
+
+	batches := map[string]*bytes.Buffer{}
+	counts := map[string]int{}
+	add := func(r *row) {
+		if batches[r.table] == nil {
+			batches[r.table] = &bytes.Buffer{}
+		}
+		batches[r.table].Write(r.data)
+		batches[r.table].WriteByte('\n')
+		counts[r.table]++
+	}
+	flush := func(table string) {
+		if counts[table] == 0 {
+			return
+		}
+		// rows of a failed batch are lost, mysql is still the source of truth
+		fctx, fcancel := context.WithTimeout(context.Background(), time.Minute)
+		defer fcancel()
+		if err := insert(fctx, table, batches[table].Bytes()); err != nil {
+			logger.L().Warn("write clickhouse failed", zap.String("table", table), zap.Int("count", counts[table]), zap.Error(err))
+		}
+		batches[table].Reset()
+		counts[table] = 0
+	}
+	flushAll := func() {
+		for table := range batches {
+			flush(table)
+		}
+		if n := dropped.Swap(0); n > 0 {
+			logger.L().Warn("clickhouse buffer is full, rows are dropped", zap.Int64("count", n))
+		}
+	}
+
+	tk := time.NewTicker(time.Second * time.Duration(max(cfg.FlushInterval, 1)))
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for len(rows) > 0 {
+				add(<-rows)
+			}
+			flushAll()
+			return
+		case <-tk.C:
+			flushAll()
+		case r := <-rows:
+			if add(r); counts[r.table] >= cfg.BatchSize {
+				flush(r.table)
+			}
+		}
+	}
+}
+
+func StopSink() {
+	defer cancel()
+}
@@ -0,0 +1,138 @@
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/model"
+)
+
+func init() {
+	register(model.CLOUDPROVIDER_ALIYUN, &aliyun{})
+}
+
+type ecsIps struct {
+	IpAddress []string `json:"IpAddress"`
+}
+
+type ecsDescribeInstances struct {
+	TotalCount int `json:"TotalCount"`
+	Instances  struct {
+		Instance []struct {
+			InstanceId      string `json:"InstanceId"`
+			InstanceName    string `json:"InstanceName"`
+			OSType          string `json:"OSType"`
+			PublicIpAddress ecsIps `json:"PublicIpAddress"`
+			InnerIpAddress  ecsIps `json:"InnerIpAddress"`
+			EipAddress      struct {
+				IpAddress string `json:"IpAddress"`
+			} `json:"EipAddress"`
+			VpcAttributes struct {
+				PrivateIpAddress ecsIps `json:"PrivateIpAddress"`
+			} `json:"VpcAttributes"`
+			Tags struct {
+				Tag []struct {
+					TagKey   string `json:"TagKey"`
+					TagValue string `json:"TagValue"`
+				} `json:"Tag"`
+			} `json:"Tags"`
+		} `json:"Instance"`
+	} `json:"Instances"`
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+// aliyun lists ecs instances by the rpc api signed with signature v1
+//
+//	https://help.aliyun.com/zh/ecs/developer-reference/api-ecs-2014-05-26-describeinstances
+//	https://help.aliyun.com/zh/sdk/product-overview/rpc-mechanism
+type aliyun struct{}
+
+func (p *aliyun) List(ctx context.Context, ak, sk, region string) (instances []*Instance, err error) {
+	for page := 1; ; page++ {
+		res := &ecsDescribeInstances{}
+		if err = p.call(ctx, ak, sk, region, map[string]string{
+			"Action":     "DescribeInstances",
+			"Version":    "2014-05-26",
+			"RegionId":   region,
+			"PageNumber": cast.ToString(page),
+			"PageSize":   "100",
+		}, res); err != nil {
+			return
+		}
+		for _, i := range res.Instances.Instance {
+			instance := &Instance{
+				Id:        i.InstanceId,
+				Name:      i.InstanceName,
+				Region:    region,
+				PrivateIp: lo.FirstOr(append(i.VpcAttributes.PrivateIpAddress.IpAddress, i.InnerIpAddress.IpAddress...), ""),
+				PublicIp:  lo.Ternary(i.EipAddress.IpAddress != "", i.EipAddress.IpAddress, lo.FirstOr(i.PublicIpAddress.IpAddress, "")),
+				Windows:   strings.EqualFold(i.OSType, "windows"),
+				Tags:      map[string]string{},
+			}
+			for _, t := range i.Tags.Tag {
+				instance.Tags[t.TagKey] = t.TagValue
+			}
+			instances = append(instances, instance)
+		}
+		if len(res.Instances.Instance) == 0 || page*100 >= res.TotalCount {
+			return
+		}
+	}
+}
+
+func (p *aliyun) call(ctx context.Context, ak, sk, region string, params map[string]string, res any) (err error) {
+	query := url.Values{
+		"Format":           {"JSON"},
+		"AccessKeyId":      {ak},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"SignatureVersion": {"1.0"},
+		"SignatureNonce":   {uuid.NewString()},
+		"Timestamp":        {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+	}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	canonical := aliyunEscape(query.Encode())
+	h := hmac.New(sha1.New, []byte(sk+"&"))
+	h.Write([]byte("GET&%2F&" + aliyunEscape(url.QueryEscape(canonical))))
+	query.Set("Signature", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://ecs.%s.aliyuncs.com/?%s", region, aliyunEscape(query.Encode())), nil)
+	if err != nil {
+		return
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &ecsDescribeInstances{}
+		json.Unmarshal(bs, e)
+		return fmt.Errorf("aliyun ecs %s: %s %s", resp.Status, e.Code, e.Message)
+	}
+	return json.Unmarshal(bs, res)
+}
+
+// aliyunEscape turns form encoding into the percent encoding of rfc3986 which signatures are calculated on
+func aliyunEscape(s string) string {
+	return strings.NewReplacer("+", "%20", "*", "%2A", "%7E", "~").Replace(s)
+}
@@ -0,0 +1,156 @@
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/veops/oneterm/model"
+)
+
+func init() {
+	register(model.CLOUDPROVIDER_AWS, &aws{})
+}
+
+type ec2Instance struct {
+	InstanceId       string `xml:"instanceId"`
+	PrivateIpAddress string `xml:"privateIpAddress"`
+	IpAddress        string `xml:"ipAddress"`
+	Platform         string `xml:"platform"`
+	State            struct {
+		Name string `xml:"name"`
+	} `xml:"instanceState"`
+	Tags []struct {
+		Key   string `xml:"key"`
+		Value string `xml:"value"`
+	} `xml:"tagSet>item"`
+}
+
+type ec2DescribeInstances struct {
+	Reservations []struct {
+		Instances []*ec2Instance `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+type ec2Error struct {
+	Code    string `xml:"Errors>Error>Code"`
+	Message string `xml:"Errors>Error>Message"`
+}
+
+// aws lists ec2 instances by the query api, the name is the Name tag as the console shows
+//
+//	https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_DescribeInstances.html
+type aws struct{}
+
+func (p *aws) List(ctx context.Context, ak, sk, region string) (instances []*Instance, err error) {
+	token := ""
+	for {
+		query := url.Values{
+			"Action":     {"DescribeInstances"},
+			"Version":    {"2016-11-15"},
+			"MaxResults": {"1000"},
+		}
+		if token != "" {
+			query.Set("NextToken", token)
+		}
+		res := &ec2DescribeInstances{}
+		if err = p.call(ctx, ak, sk, region, query, res); err != nil {
+			return
+		}
+		for _, r := range res.Reservations {
+			for _, i := range r.Instances {
+				if i.State.Name == "terminated" || i.State.Name == "shutting-down" {
+					continue
+				}
+				instance := &Instance{
+					Id:        i.InstanceId,
+					Region:    region,
+					PrivateIp: i.PrivateIpAddress,
+					PublicIp:  i.IpAddress,
+					Windows:   i.Platform == "windows",
+					Tags:      map[string]string{},
+				}
+				for _, t := range i.Tags {
+					instance.Tags[t.Key] = t.Value
+				}
+				instance.Name = instance.Tags["Name"]
+				instances = append(instances, instance)
+			}
+		}
+		if token = res.NextToken; token == "" {
+			return
+		}
+	}
+}
+
+func (p *aws) call(ctx context.Context, ak, sk, region string, query url.Values, res any) (err error) {
+	host := fmt.Sprintf("ec2.%s.amazonaws.com", region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/?%s", host, awsQuery(query)), nil)
+	if err != nil {
+		return
+	}
+	awsSign(req, "ec2", region, ak, sk)
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &ec2Error{}
+		xml.Unmarshal(bs, e)
+		return fmt.Errorf("aws ec2 %s: %s %s", resp.Status, e.Code, e.Message)
+	}
+	return xml.Unmarshal(bs, res)
+}
+
+// awsSign signs a get request with signature v4, the query must be canonical already
+//
+//	https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func awsSign(r *http.Request, service, region, ak, sk string) {
+	now := time.Now().UTC()
+	date, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "host;x-amz-date"
+	payloadHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		r.Method, "/", r.URL.RawQuery,
+		"host:" + r.URL.Host + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + sk)
+	for _, v := range []string{date, region, service, "aws4_request", stringToSign} {
+		key = hmacSha256(key, v)
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		ak, scope, signedHeaders, hex.EncodeToString(key)))
+}
+
+// awsQuery encodes query sorted by keys with spaces as %20 as signature v4 requires
+func awsQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,242 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/acl"
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/usage"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	// kAllNodes is the cache of node trees which is dropped once nodes are changed
+	kAllNodes = "allNodes"
+)
+
+var (
+	providers = map[string]Provider{}
+	syncing   sync.Map
+
+	cli = &http.Client{Timeout: time.Second * 30}
+)
+
+// Instance is a vm of clouds, tags are flattened to key and value
+type Instance struct {
+	Id        string
+	Name      string
+	Region    string
+	PrivateIp string
+	PublicIp  string
+	Windows   bool
+	Tags      map[string]string
+}
+
+// Provider lists instances of a region with the access key of a cloud account
+type Provider interface {
+	List(ctx context.Context, ak, sk, region string) ([]*Instance, error)
+}
+
+func register(name string, p Provider) {
+	providers[name] = p
+}
+
+// Validate checks the provider and regions of a cloud account
+func Validate(a *model.CloudAccount) error {
+	if _, ok := providers[a.Provider]; !ok {
+		return fmt.Errorf("unknown cloud provider %s", a.Provider)
+	}
+	if len(a.Regions) == 0 {
+		return fmt.Errorf("regions are required")
+	}
+	return nil
+}
+
+// SyncDue synchronizes enabled cloud accounts whose interval has passed since the last synchronization
+func SyncDue() {
+	accounts := make([]*model.CloudAccount, 0)
+	if err := mysql.DB.Model(model.DefaultCloudAccount).
+		Where("enable = ? AND `interval` > 0", true).
+		Where("synced_at IS NULL OR DATE_ADD(synced_at, INTERVAL `interval` MINUTE) <= ?", time.Now()).
+		Find(&accounts).Error; err != nil {
+		logger.L().Error("get due cloud accounts failed", zap.Error(err))
+		return
+	}
+	for _, a := range accounts {
+		if _, ok := syncing.Load(a.Id); ok {
+			continue
+		}
+		if _, err := Sync(a); err != nil {
+			logger.L().Warn("sync cloud account failed", zap.Int("id", a.Id), zap.String("name", a.Name), zap.Error(err))
+		}
+	}
+}
+
+// Sync imports instances of all regions of the cloud account, assets of known instances are updated in place.
+// Assets of instances gone are kept for admins to review since they may be stopped only
+func Sync(a *model.CloudAccount) (n int, err error) {
+	if _, loaded := syncing.LoadOrStore(a.Id, struct{}{}); loaded {
+		return 0, fmt.Errorf("cloud account %d is being synchronized", a.Id)
+	}
+	defer syncing.Delete(a.Id)
+
+	ctx := context.Background()
+	defer func() {
+		msg := fmt.Sprintf("%d instances synchronized", n)
+		if err != nil {
+			msg = err.Error()
+		}
+		mysql.DB.Model(a).Updates(map[string]any{"synced_at": time.Now(), "message": msg})
+	}()
+
+	if err = Validate(a); err != nil {
+		return
+	}
+	instances := make([]*Instance, 0)
+	for _, region := range a.Regions {
+		is, err := providers[a.Provider].List(ctx, a.AccessKey, util.DecryptAES(a.SecretKey), region)
+		if err != nil {
+			return 0, fmt.Errorf("list instances of %s failed: %w", region, err)
+		}
+		instances = append(instances, is...)
+	}
+
+	assets := make([]*model.Asset, 0)
+	if err = mysql.DB.Model(model.DefaultAsset).Where("cloud_account_id = ?", a.Id).Find(&assets).Error; err != nil {
+		return
+	}
+	byInstance := lo.KeyBy(assets, func(asset *model.Asset) string { return asset.Cloud.InstanceId })
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+
+	nodes := map[string]int{}
+	for _, i := range instances {
+		ip := lo.Ternary(a.PublicIp, i.PublicIp, i.PrivateIp)
+		if ip == "" {
+			continue
+		}
+		parentId := a.ParentId
+		if v := i.Tags[a.TagKey]; a.TagKey != "" && v != "" {
+			if _, ok := nodes[v]; !ok {
+				if nodes[v], err = tagNode(ctx, a, v); err != nil {
+					return
+				}
+			}
+			parentId = nodes[v]
+		}
+		cloud := model.Cloud{AccountId: a.Id, InstanceId: i.Id, Region: i.Region, Tags: i.Tags}
+
+		if asset, ok := byInstance[i.Id]; ok {
+			if asset.Ip != ip || asset.ParentId != parentId || asset.Cloud.Region != i.Region || !maps.Equal(asset.Cloud.Tags, i.Tags) {
+				if err = mysql.DB.Model(asset).Updates(map[string]any{
+					"ip": ip, "parent_id": parentId, "cloud_region": i.Region, "cloud_tags": cloud.Tags,
+				}).Error; err != nil {
+					return
+				}
+			}
+		} else if err = create(ctx, a, &model.Asset{
+			Name:          lo.Ternary(i.Name != "", i.Name, i.Id),
+			Ip:            ip,
+			Protocols:     lo.Ternary(len(a.Protocols) > 0, a.Protocols, lo.Ternary(i.Windows, model.Slice[string]{"rdp:3389"}, model.Slice[string]{"ssh:22"})),
+			ParentId:      parentId,
+			GatewayId:     a.GatewayId,
+			Authorization: make(model.Map[int, model.Slice[int]]),
+			Cloud:         cloud,
+		}); err != nil {
+			return
+		}
+		n++
+	}
+
+	return
+}
+
+// create names the asset by instance id as well if the name of the instance is used
+func create(ctx context.Context, a *model.CloudAccount, asset *model.Asset) (err error) {
+	if err = usage.CheckAsset(1); err != nil {
+		return
+	}
+	cnt := int64(0)
+	if err = mysql.DB.Model(asset).Where("name = ?", asset.Name).Count(&cnt).Error; err != nil {
+		return
+	}
+	if cnt > 0 {
+		asset.Name = fmt.Sprintf("%s-%s", asset.Name, asset.Cloud.InstanceId)
+	}
+	resource, err := acl.AddResource(ctx, a.CreatorId, conf.RESOURCE_ASSET, asset.Name)
+	if err != nil {
+		return
+	}
+	asset.ResourceId, asset.CreatorId, asset.UpdaterId = resource.ResourceId, a.CreatorId, a.CreatorId
+
+	return mysql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Create(asset).Error; err != nil {
+			return
+		}
+		return tx.Create(&model.History{
+			Type:       asset.TableName(),
+			TargetId:   asset.Id,
+			ActionType: model.ACTION_CREATE,
+			New:        toMap(asset),
+			CreatorId:  a.CreatorId,
+			CreatedAt:  time.Now(),
+		}).Error
+	})
+}
+
+// tagNode returns the child node named by the tag value under the node of the cloud account
+func tagNode(ctx context.Context, a *model.CloudAccount, name string) (id int, err error) {
+	node := &model.Node{}
+	err = mysql.DB.Model(node).Where("parent_id = ? AND name = ?", a.ParentId, name).First(node).Error
+	if err == nil {
+		return node.Id, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+
+	resource, err := acl.AddResource(ctx, a.CreatorId, conf.RESOURCE_NODE, name+time.Now().Format(time.RFC3339))
+	if err != nil {
+		return
+	}
+	node = &model.Node{
+		Name:          name,
+		ParentId:      a.ParentId,
+		Authorization: make(model.Map[int, model.Slice[int]]),
+		ResourceId:    resource.ResourceId,
+		CreatorId:     a.CreatorId,
+		UpdaterId:     a.CreatorId,
+	}
+	if err = mysql.DB.Create(node).Error; err != nil {
+		return
+	}
+	if err = acl.UpdateResource(ctx, a.CreatorId, resource.ResourceId, map[string]string{"name": cast.ToString(node.Id)}); err != nil {
+		return
+	}
+	redis.RC.Del(ctx, kAllNodes)
+	util.DeleteAllFromCacheDb(ctx, model.DefaultNode)
+
+	return node.Id, nil
+}
+
+func toMap(data any) model.Map[string, any] {
+	bs, _ := json.Marshal(data)
+	res := make(map[string]any)
+	json.Unmarshal(bs, &res)
+	return res
+}
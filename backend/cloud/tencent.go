@@ -0,0 +1,136 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	tencentHost = "cvm.tencentcloudapi.com"
+)
+
+func init() {
+	register(model.CLOUDPROVIDER_TENCENT, &tencent{})
+}
+
+type cvmDescribeInstances struct {
+	Response struct {
+		TotalCount  int `json:"TotalCount"`
+		InstanceSet []struct {
+			InstanceId         string   `json:"InstanceId"`
+			InstanceName       string   `json:"InstanceName"`
+			OsName             string   `json:"OsName"`
+			PrivateIpAddresses []string `json:"PrivateIpAddresses"`
+			PublicIpAddresses  []string `json:"PublicIpAddresses"`
+			Tags               []struct {
+				Key   string `json:"Key"`
+				Value string `json:"Value"`
+			} `json:"Tags"`
+		} `json:"InstanceSet"`
+		Error *struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Error"`
+	} `json:"Response"`
+}
+
+// tencent lists cvm instances by the api 3.0 signed with tc3-hmac-sha256
+//
+//	https://cloud.tencent.com/document/api/213/15728
+//	https://cloud.tencent.com/document/api/213/30654
+type tencent struct{}
+
+func (p *tencent) List(ctx context.Context, ak, sk, region string) (instances []*Instance, err error) {
+	for offset := 0; ; offset += 100 {
+		res := &cvmDescribeInstances{}
+		if err = p.call(ctx, ak, sk, region, "DescribeInstances", map[string]any{"Offset": offset, "Limit": 100}, res); err != nil {
+			return
+		}
+		for _, i := range res.Response.InstanceSet {
+			instance := &Instance{
+				Id:        i.InstanceId,
+				Name:      i.InstanceName,
+				Region:    region,
+				PrivateIp: lo.FirstOr(i.PrivateIpAddresses, ""),
+				PublicIp:  lo.FirstOr(i.PublicIpAddresses, ""),
+				Windows:   strings.Contains(strings.ToLower(i.OsName), "windows"),
+				Tags:      map[string]string{},
+			}
+			for _, t := range i.Tags {
+				instance.Tags[t.Key] = t.Value
+			}
+			instances = append(instances, instance)
+		}
+		if len(res.Response.InstanceSet) == 0 || offset+100 >= res.Response.TotalCount {
+			return
+		}
+	}
+}
+
+func (p *tencent) call(ctx context.Context, ak, sk, region, action string, params any, res *cvmDescribeInstances) (err error) {
+	body, _ := json.Marshal(params)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+tencentHost, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-TC-Action", action)
+	req.Header.Set("X-TC-Version", "2017-03-12")
+	req.Header.Set("X-TC-Region", region)
+	tencentSign(req, body, "cvm", ak, sk, time.Now())
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(bs, res); err != nil {
+		return fmt.Errorf("tencent cvm %s: %w", resp.Status, err)
+	}
+	// errors are returned in the body with status 200
+	if e := res.Response.Error; e != nil {
+		return fmt.Errorf("tencent cvm: %s %s", e.Code, e.Message)
+	}
+	return
+}
+
+// tencentSign signs r with tc3-hmac-sha256, content type and host are signed
+func tencentSign(r *http.Request, body []byte, service, ak, sk string, now time.Time) {
+	timestamp, date := cast.ToString(now.Unix()), now.UTC().Format("2006-01-02")
+	r.Header.Set("X-TC-Timestamp", timestamp)
+
+	signedHeaders := "content-type;host"
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		r.Method, "/", "",
+		"content-type:" + r.Header.Get("Content-Type") + "\nhost:" + r.URL.Host + "\n",
+		signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + service + "/tc3_request"
+	stringToSign := strings.Join([]string{"TC3-HMAC-SHA256", timestamp, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("TC3" + sk)
+	for _, v := range []string{date, service, "tc3_request", stringToSign} {
+		key = hmacSha256(key, v)
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("TC3-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		ak, scope, signedHeaders, hex.EncodeToString(key)))
+}
@@ -0,0 +1,172 @@
+package cmdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/usage"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	running  atomic.Bool
+	last     time.Time
+	lastPush time.Time
+)
+
+// Ci is a configuration item synchronized as an asset, protocols are kept as they are if it is empty
+type Ci struct {
+	Id        string   `json:"id" binding:"required"`
+	Name      string   `json:"name"`
+	Ip        string   `json:"ip"`
+	Protocols []string `json:"protocols"`
+}
+
+// SyncDue pulls cis and pushes session statistics if the interval has passed since the last synchronization
+func SyncDue() {
+	cfg := conf.Cfg.Cmdb
+	if cfg.Url == "" || cfg.Interval <= 0 || time.Since(last) < time.Minute*time.Duration(cfg.Interval) {
+		return
+	}
+	last = time.Now()
+	if _, err := Sync(); err != nil {
+		logger.L().Warn("sync cmdb failed", zap.Error(err))
+	}
+}
+
+// Sync pulls cis from veops cmdb into assets and then pushes session statistics of them back, only one runs at a time
+func Sync() (n int, err error) {
+	if conf.Cfg.Cmdb.Url == "" {
+		return 0, fmt.Errorf("url of cmdb is not configured")
+	}
+	if !running.CompareAndSwap(false, true) {
+		return 0, fmt.Errorf("cmdb synchronization is running")
+	}
+	defer running.Store(false)
+
+	ctx := context.Background()
+	cis, err := pull(ctx)
+	if err != nil {
+		return
+	}
+	if n, err = Upsert(ctx, cis); err != nil {
+		return
+	}
+	err = push(ctx)
+	return
+}
+
+// Upsert creates assets of new cis and updates name, ip and protocols of linked ones if they are changed
+func Upsert(ctx context.Context, cis []*Ci) (n int, err error) {
+	ids := lo.Map(cis, func(ci *Ci, _ int) string { return ci.Id })
+	assets := make([]*model.Asset, 0)
+	if err = mysql.DB.Model(model.DefaultAsset).Where("ci_id IN ?", ids).Find(&assets).Error; err != nil {
+		return
+	}
+	byCi := lo.KeyBy(assets, func(a *model.Asset) string { return a.CiId })
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+
+	for _, ci := range cis {
+		if ci.Id == "" || ci.Ip == "" {
+			continue
+		}
+		asset, ok := byCi[ci.Id]
+		if !ok {
+			if err = create(ctx, ci); err != nil {
+				return
+			}
+			n++
+			continue
+		}
+		updates := map[string]any{}
+		if ci.Name != "" && ci.Name != asset.Name {
+			updates["name"] = ci.Name
+		}
+		if ci.Ip != asset.Ip {
+			updates["ip"] = ci.Ip
+		}
+		if added, removed := lo.Difference(ci.Protocols, []string(asset.Protocols)); len(ci.Protocols) > 0 && len(added)+len(removed) > 0 {
+			updates["protocols"] = model.Slice[string](ci.Protocols)
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		// a renamed ci may take the name of another asset, which fails this one only
+		if e := mysql.DB.Model(asset).Updates(updates).Error; e != nil {
+			logger.L().Warn("update asset of ci failed", zap.String("ciId", ci.Id), zap.Error(e))
+			continue
+		}
+		n++
+	}
+
+	return
+}
+
+// Unlink keeps assets of deleted cis for admins to review
+func Unlink(ctx context.Context, ids []string) (err error) {
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+	return mysql.DB.Model(model.DefaultAsset).Where("ci_id IN ?", ids).Update("ci_id", "").Error
+}
+
+// create names the asset by ci id as well if the name of the ci is used
+func create(ctx context.Context, ci *Ci) (err error) {
+	if err = usage.CheckAsset(1); err != nil {
+		return
+	}
+	cfg := conf.Cfg.Cmdb
+	asset := &model.Asset{
+		Name:          lo.Ternary(ci.Name != "", ci.Name, ci.Ip),
+		Ip:            ci.Ip,
+		Protocols:     lo.Ternary(len(ci.Protocols) > 0, ci.Protocols, []string{"ssh:22"}),
+		ParentId:      cfg.ParentId,
+		Authorization: make(model.Map[int, model.Slice[int]]),
+		CiId:          ci.Id,
+		CreatorId:     cfg.Uid,
+		UpdaterId:     cfg.Uid,
+	}
+	cnt := int64(0)
+	if err = mysql.DB.Model(asset).Where("name = ?", asset.Name).Count(&cnt).Error; err != nil {
+		return
+	}
+	if cnt > 0 {
+		asset.Name = fmt.Sprintf("%s-%s", asset.Name, ci.Id)
+	}
+	resource, err := acl.AddResource(ctx, cfg.Uid, conf.RESOURCE_ASSET, asset.Name)
+	if err != nil {
+		return
+	}
+	asset.ResourceId = resource.ResourceId
+
+	return mysql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Create(asset).Error; err != nil {
+			return
+		}
+		return tx.Create(&model.History{
+			Type:       asset.TableName(),
+			TargetId:   asset.Id,
+			ActionType: model.ACTION_CREATE,
+			New:        toMap(asset),
+			CreatorId:  cfg.Uid,
+			CreatedAt:  time.Now(),
+		}).Error
+	})
+}
+
+func toMap(data any) model.Map[string, any] {
+	bs, _ := json.Marshal(data)
+	res := make(map[string]any)
+	json.Unmarshal(bs, &res)
+	return res
+}
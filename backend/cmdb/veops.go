@@ -0,0 +1,143 @@
+package cmdb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/remote"
+)
+
+const (
+	pageSize = 200
+)
+
+type searchResp struct {
+	NumFound int              `json:"numfound"`
+	Result   []map[string]any `json:"result"`
+}
+
+type stat struct {
+	AssetId int       `gorm:"column:asset_id"`
+	Count   int64     `gorm:"column:count"`
+	Last    time.Time `gorm:"column:last"`
+}
+
+// pull searches cis of the configured type page by page
+//
+//	https://github.com/veops/cmdb/blob/master/docs/cmdb_api.md
+func pull(ctx context.Context) (cis []*Ci, err error) {
+	cfg := conf.Cfg.Cmdb
+	for page := 1; ; page++ {
+		params := map[string]string{
+			"q":     "_type:" + cfg.CiType,
+			"page":  cast.ToString(page),
+			"count": cast.ToString(pageSize),
+		}
+		res := &searchResp{}
+		resp, err := remote.RC.R().
+			SetContext(ctx).
+			SetQueryParams(sign("/api/v0.1/ci/s", params)).
+			SetResult(res).
+			Get(cfg.Url + "/api/v0.1/ci/s")
+		if err = remote.HandleErr(err, resp, nil); err != nil {
+			return nil, err
+		}
+		for _, r := range res.Result {
+			cis = append(cis, &Ci{
+				Id:   cast.ToString(r["_id"]),
+				Name: cast.ToString(r[cfg.Attrs.Name]),
+				Ip:   cast.ToString(r[cfg.Attrs.Ip]),
+			})
+		}
+		if len(res.Result) < pageSize || page*pageSize >= res.NumFound {
+			return cis, nil
+		}
+	}
+}
+
+// push updates session count and time of the last session of assets which have sessions since the last push
+func push(ctx context.Context) (err error) {
+	cfg := conf.Cfg.Cmdb
+	if cfg.Attrs.SessionCount == "" && cfg.Attrs.LastSession == "" {
+		return
+	}
+	now := time.Now()
+	assetIds := make([]int, 0)
+	if err = mysql.AuditDB.Model(model.DefaultSession).Where("created_at >= ?", lastPush).Distinct().Pluck("asset_id", &assetIds).Error; err != nil {
+		return
+	}
+	assets := make([]*model.Asset, 0)
+	if err = mysql.DB.Model(model.DefaultAsset).Where("id IN ? AND ci_id <> ''", assetIds).Find(&assets).Error; err != nil {
+		return
+	}
+	if len(assets) == 0 {
+		lastPush = now
+		return
+	}
+	stats := make([]*stat, 0)
+	if err = mysql.AuditDB.Model(model.DefaultSession).
+		Select("asset_id, COUNT(*) AS count, MAX(created_at) AS last").
+		Where("asset_id IN ?", lo.Map(assets, func(a *model.Asset, _ int) int { return a.Id })).
+		Group("asset_id").
+		Find(&stats).Error; err != nil {
+		return
+	}
+	byAsset := lo.KeyBy(stats, func(s *stat) int { return s.AssetId })
+
+	failed := 0
+	for _, a := range assets {
+		s, ok := byAsset[a.Id]
+		if !ok {
+			continue
+		}
+		body := map[string]string{}
+		if cfg.Attrs.SessionCount != "" {
+			body[cfg.Attrs.SessionCount] = cast.ToString(s.Count)
+		}
+		if cfg.Attrs.LastSession != "" {
+			body[cfg.Attrs.LastSession] = s.Last.Format(time.DateTime)
+		}
+		path := "/api/v0.1/ci/" + url.PathEscape(a.CiId)
+		resp, err := remote.RC.R().
+			SetContext(ctx).
+			SetBody(sign(path, body)).
+			Put(cfg.Url + path)
+		if err = remote.HandleErr(err, resp, nil); err != nil {
+			logger.L().Warn("push session statistics to cmdb failed", zap.String("ciId", a.CiId), zap.Error(err))
+			failed++
+		}
+	}
+	// failed ones are pushed again next time
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cis failed to push", failed, len(assets))
+	}
+	lastPush = now
+
+	return
+}
+
+// sign adds _key and _secret, the secret is sha1 of path, secret and values sorted by their keys
+func sign(path string, params map[string]string) map[string]string {
+	cfg := conf.Cfg.Cmdb
+	keys := lo.Keys(params)
+	sort.Strings(keys)
+	values := lo.Map(keys, func(k string, _ int) string { return params[k] })
+	h := sha1.Sum([]byte(path + cfg.Secret + strings.Join(values, "")))
+
+	res := lo.Assign(params, map[string]string{"_key": cfg.Key, "_secret": hex.EncodeToString(h[:])})
+	return res
+}
@@ -2,6 +2,7 @@ package conf
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -18,12 +19,22 @@ const (
 
 var (
 	PermResource = []string{RESOURCE_NODE, RESOURCE_ACCOUNT, RESOURCE_ASSET, RESOURCE_COMMAND, RESOURCE_GATEWAY}
+	// EventBusDrivers are drivers of publishers of the event bus
+	EventBusDrivers = []string{"kafka", "nats"}
 
 	Cfg = &ConfigYaml{
 		Mode: "debug",
 		Http: HttpConfig{
 			Host: "0.0.0.0",
 			Port: 80,
+			Tls: TlsConfig{
+				Acme: AcmeConfig{
+					Challenge:   "http",
+					HttpPort:    80,
+					Cache:       "certs",
+					Propagation: 60,
+				},
+			},
 		},
 		Log: LogConfig{
 			Level:         "info",
@@ -34,6 +45,165 @@ var (
 			Path:          "app.log",
 			ConsoleEnable: true,
 		},
+		Clickhouse: ClickhouseConfig{
+			Database:      "oneterm",
+			BatchSize:     1000,
+			FlushInterval: 5,
+			BufferSize:    100000,
+		},
+		Guacd: GuacdConfig{
+			Adaptive: true,
+		},
+		Storage: StorageConfig{
+			Type: "local",
+			Path: "/replay",
+			S3: S3Config{
+				Region:   "us-east-1",
+				PartSize: 16,
+			},
+		},
+		Profile: ProfileConfig{
+			ChanBlockWarn: 200,
+		},
+		DbProxy: DbProxyConfig{
+			Host:     "0.0.0.0",
+			TokenTtl: 10,
+		},
+		Warmup: WarmupConfig{
+			Idle:     10,
+			Lifetime: 60,
+		},
+		SshCa: SshCaConfig{
+			CertTtl:     5,
+			KeyCertTtl:  30,
+			RenewBefore: 7,
+		},
+		Credential: CredentialConfig{
+			CacheTtl: 60,
+			Vault: VaultConfig{
+				KvMount: "secret",
+			},
+		},
+		Rotation: RotationConfig{
+			Length:    20,
+			WinrmPort: 5985,
+		},
+		Discovery: DiscoveryConfig{
+			Ports:       []int{22, 3389, 5900},
+			Timeout:     500,
+			Concurrency: 256,
+		},
+		Probe: ProbeConfig{
+			Timeout: 5,
+		},
+		Health: HealthConfig{
+			Interval:      5,
+			Timeout:       1000,
+			Banner:        true,
+			Concurrency:   32,
+			RetentionDays: 7,
+		},
+		Canary: CanaryConfig{
+			Interval:      5,
+			Timeout:       30,
+			Failures:      2,
+			RetentionDays: 30,
+		},
+		Cmdb: CmdbConfig{
+			CiType: "server",
+			Attrs: CmdbAttrs{
+				Name:         "hostname",
+				Ip:           "private_ip",
+				SessionCount: "oneterm_session_count",
+				LastSession:  "oneterm_last_session",
+			},
+		},
+		Warning: WarningConfig{
+			Interval: 5,
+			Confirm:  "critical",
+		},
+		StepUp: StepUpConfig{
+			Tag:         "restricted",
+			Ttl:         120,
+			Issuer:      "OneTerm",
+			MaxAttempts: 5,
+			LockTime:    300,
+		},
+		ClientTool: ClientToolConfig{
+			Docker:  "unix:///var/run/docker.sock",
+			Network: "host",
+			Images: map[string]string{
+				"mysql":      "mysql:8.0",
+				"postgresql": "postgres:16",
+				"redis":      "redis:7",
+			},
+		},
+		Qos: QosConfig{
+			Reserve:   20,
+			BulkFlush: 4096,
+		},
+		Exec: ExecConfig{
+			Timeout:    30,
+			MaxTimeout: 600,
+			MaxOutput:  1024,
+		},
+		Backpressure: BackpressureConfig{
+			Queue:        256,
+			Policy:       "drop",
+			WriteTimeout: 30,
+			PingInterval: 30,
+			PongTimeout:  90,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "oneterm",
+			Slow:        3000,
+		},
+		EventBus: EventBusConfig{
+			Topic:         "oneterm.audit",
+			BatchSize:     100,
+			FlushInterval: 1,
+			BufferSize:    10000,
+		},
+		Syslog: SyslogConfig{
+			Network:    "udp",
+			Facility:   13,
+			Events:     []string{"session.open", "session.close", "session.admin_close", "command.blocked"},
+			BufferSize: 10000,
+		},
+		Webhook: WebhookConfig{
+			Timeout:       10,
+			MaxAttempts:   5,
+			Backoff:       30,
+			Concurrency:   8,
+			BufferSize:    10000,
+			RetentionDays: 30,
+		},
+		Alert: AlertConfig{
+			Smtp: SmtpConfig{
+				Port:    587,
+				Tls:     "starttls",
+				Timeout: 10,
+			},
+			Lang:           "en",
+			FailedConnects: 5,
+			Window:         10,
+			Cooldown:       30,
+		},
+		Cluster: ClusterConfig{
+			Ttl: 30,
+		},
+		Status: StatusConfig{
+			Interval: 30,
+			Timeout:  3,
+		},
+		License: LicenseConfig{
+			Warn: 90,
+		},
+		Proxy: ProxyConfig{
+			TrustedProxies:  []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"},
+			RemoteIPHeaders: []string{"X-Forwarded-For", "X-Real-IP"},
+			HeaderTimeout:   5,
+		},
 	}
 )
 
@@ -54,12 +224,43 @@ func init() {
 	if err = viper.Unmarshal(Cfg); err != nil {
 		panic(fmt.Sprintf("parse config from config.yaml failed:%s", err))
 	}
+	if d := Cfg.EventBus.Driver; d != "" && !slices.Contains(EventBusDrivers, d) {
+		panic(fmt.Sprintf("unsupported event bus driver %s, it is one of %v", d, EventBusDrivers))
+	}
 
 }
 
 type HttpConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host string    `yaml:"host"`
+	Port int       `yaml:"port"`
+	Tls  TlsConfig `yaml:"tls"`
+}
+
+// TlsConfig serves https and wss on the port of http, certificates are of files or issued by acme
+type TlsConfig struct {
+	Enable bool `yaml:"enable"`
+	// Cert and Key are pem files used if acme is off, they are reloaded once changed
+	Cert string     `yaml:"cert"`
+	Key  string     `yaml:"key"`
+	Acme AcmeConfig `yaml:"acme"`
+}
+
+type AcmeConfig struct {
+	Enable  bool     `yaml:"enable"`
+	Domains []string `yaml:"domains"`
+	Email   string   `yaml:"email"`
+	// Directory of the acme server, it is the one of let's encrypt if empty
+	Directory string `yaml:"directory"`
+	// Challenge is http, which also answers tls-alpn, or dns
+	Challenge string `yaml:"challenge"`
+	// HttpPort answers http challenges and redirects others to https, 0 means disabled
+	HttpPort int `yaml:"httpPort"`
+	// Cache is the directory of certificates and the account key
+	Cache string `yaml:"cache"`
+	// DnsHook is run as `<hook> present|cleanup <fqdn> <value>` to set and remove txt records of dns challenges
+	DnsHook string `yaml:"dnsHook"`
+	// Propagation waits for txt records to be seen by the acme server, unit is second
+	Propagation int `yaml:"propagation"`
 }
 
 type RedisConfig struct {
@@ -75,6 +276,28 @@ type MysqlConfig struct {
 	Password string `yaml:"password"`
 }
 
+// AuditDbConfig puts sessions, commands and other high-write records into another database, they stay in mysql if Driver is empty
+type AuditDbConfig struct {
+	// Driver is a name registered by db.RegisterDriver, mysql is built in
+	Driver string `yaml:"driver"`
+	Dsn    string `yaml:"dsn"`
+}
+
+// ClickhouseConfig writes commands and session events into clickhouse as well for analytics on large installs
+type ClickhouseConfig struct {
+	// Url of the http interface, e.g. http://clickhouse:8123, nothing is written if it is empty
+	Url      string `yaml:"url"`
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// BatchSize of rows of each insert
+	BatchSize int `yaml:"batchSize"`
+	// FlushInterval between inserts of partial batches, unit is second
+	FlushInterval int `yaml:"flushInterval"`
+	// BufferSize of rows waiting for inserts, more rows are dropped
+	BufferSize int `yaml:"bufferSize"`
+}
+
 type KV struct {
 	Key   string
 	Value string
@@ -122,17 +345,432 @@ type SshConfig struct {
 type GuacdConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
+	// Adaptive lowers frame rate for clients with high latency
+	Adaptive bool `yaml:"adaptive"`
+}
+
+type S3Config struct {
+	// Endpoint with scheme, e.g. http://minio:9000
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+	Bucket    string `yaml:"bucket"`
+	// Prefix of object keys, e.g. oneterm/, recordings are put under it and only objects under it are expired
+	Prefix string `yaml:"prefix"`
+	// PathStyle use endpoint/bucket/key instead of bucket.endpoint/key, minio needs it
+	PathStyle bool `yaml:"pathStyle"`
+	// PartSize size of multipart upload part, unit is MB
+	PartSize int `yaml:"partSize"`
+}
+
+type StorageConfig struct {
+	// Type local or s3
+	Type string `yaml:"type"`
+	// Path local directory of recordings, guacd must write to the same directory
+	Path string `yaml:"path"`
+	// RetentionDays recordings older than it will be removed, 0 means forever
+	RetentionDays int `yaml:"retentionDays"`
+	// Compress deduplicate and zstd recordings when they are archived
+	Compress bool     `yaml:"compress"`
+	S3       S3Config `yaml:"s3"`
+}
+
+type DbProxyConfig struct {
+	Host string `yaml:"host"`
+	// PublicHost is returned to clients with tokens, it is the host clients could reach
+	PublicHost string `yaml:"publicHost"`
+	// MysqlPort 0 means disabled
+	MysqlPort    int `yaml:"mysqlPort"`
+	RedisPort    int `yaml:"redisPort"`
+	PostgresPort int `yaml:"postgresPort"`
+	// TokenTtl unit is minute
+	TokenTtl int `yaml:"tokenTtl"`
+}
+
+type WarmupConfig struct {
+	// Idle pooled connections not used for it are closed, unit is minute
+	Idle int `yaml:"idle"`
+	// Lifetime pooled connections are redialed after it to pick up changes of target, unit is minute, 0 means forever
+	Lifetime int `yaml:"lifetime"`
+}
+
+type CredentialConfig struct {
+	// CacheTtl fetched secrets are reused within it, unit is second, 0 means no cache
+	CacheTtl int         `yaml:"cacheTtl"`
+	Vault    VaultConfig `yaml:"vault"`
+	Aws      AwsConfig   `yaml:"aws"`
+}
+
+type AwsConfig struct {
+	// Region is used if it is not in arn
+	Region string `yaml:"region"`
+	// AccessKey environment variables AWS_ACCESS_KEY_ID etc. are used if it is empty
+	AccessKey    string `yaml:"accessKey"`
+	SecretKey    string `yaml:"secretKey"`
+	SessionToken string `yaml:"sessionToken"`
+}
+
+type VaultConfig struct {
+	Addr      string `yaml:"addr"`
+	Namespace string `yaml:"namespace"`
+	// Token is used if it is set, otherwise it logs in by approle
+	Token    string `yaml:"token"`
+	RoleId   string `yaml:"roleId"`
+	SecretId string `yaml:"secretId"`
+	// KvMount is the mount of kv version 2, paths of kv secrets are relative to it
+	KvMount            string `yaml:"kvMount"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+type SshCaConfig struct {
+	// CertTtl certificates signed for target logins expire after it, unit is minute
+	CertTtl int `yaml:"certTtl"`
+	// KeyCertTtl certificates issued for keys of accounts expire after it, unit is day
+	KeyCertTtl int `yaml:"keyCertTtl"`
+	// RenewBefore certificates of accounts are re-issued once they expire within it, unit is day
+	RenewBefore int `yaml:"renewBefore"`
+}
+
+type RotationConfig struct {
+	// Length of generated passwords
+	Length int `yaml:"length"`
+	// WinrmPort is used for windows assets without a winrm protocol, https is used if it is 5986
+	WinrmPort int `yaml:"winrmPort"`
+}
+
+type DiscoveryConfig struct {
+	// Cidrs are scanned for assets, e.g. 10.0.0.0/24
+	Cidrs []string `yaml:"cidrs"`
+	// Ports are probed on each host, protocols are detected by what they answer
+	Ports []int `yaml:"ports"`
+	// Interval between scans, unit is hour, 0 means only manually
+	Interval int `yaml:"interval"`
+	// Timeout of each probe, unit is ms
+	Timeout     int `yaml:"timeout"`
+	Concurrency int `yaml:"concurrency"`
+}
+
+type HealthConfig struct {
+	// Interval between checks of protocol ports of all assets, unit is minute, 0 means never
+	Interval int `yaml:"interval"`
+	// Timeout of each check, unit is ms
+	Timeout int `yaml:"timeout"`
+	// Banner reads greetings of ssh ports, so ports answered by other services are not taken as reachable
+	Banner      bool `yaml:"banner"`
+	Concurrency int  `yaml:"concurrency"`
+	// RetentionDays of check history
+	RetentionDays int `yaml:"retentionDays"`
+}
+
+type CanaryConfig struct {
+	// Interval between synthetic sessions of all targets, unit is minute, 0 means only manually
+	Interval int `yaml:"interval"`
+	// Timeout of each synthetic session from dialing to the first screen or output, unit is second
+	Timeout int `yaml:"timeout"`
+	// Failures in a row of a target before it is alerted, so that a single glitch of network is not
+	Failures int `yaml:"failures"`
+	// RetentionDays of results
+	RetentionDays int `yaml:"retentionDays"`
+	// Targets are connected end to end like users do, through gateways and guacd
+	Targets []CanaryTarget `yaml:"targets"`
+}
+
+// CanaryTarget is an asset connected by the account and protocol, e.g. ssh or rdp, the port is of the asset
+type CanaryTarget struct {
+	AssetId   int    `yaml:"assetId"`
+	AccountId int    `yaml:"accountId"`
+	Protocol  string `yaml:"protocol"`
+}
+
+type CmdbConfig struct {
+	// Url of veops cmdb, e.g. http://cmdb-api:5000, pulling and pushing are disabled if it is empty
+	Url    string `yaml:"url"`
+	Key    string `yaml:"key"`
+	Secret string `yaml:"secret"`
+	// CiType of cis synchronized as assets
+	CiType string `yaml:"ciType"`
+	// Interval between synchronizations, unit is minute, 0 means only manually
+	Interval int `yaml:"interval"`
+	// ParentId is the node of new assets
+	ParentId int `yaml:"parentId"`
+	// Uid owns acl resources of new assets
+	Uid   int       `yaml:"uid"`
+	Attrs CmdbAttrs `yaml:"attrs"`
+	// WebhookToken authorizes pushes of the generic format, the webhook is disabled if it is empty
+	WebhookToken string `yaml:"webhookToken"`
+}
+
+// CmdbAttrs are names of ci attributes, session statistics are not pushed if their names are empty
+type CmdbAttrs struct {
+	Name         string `yaml:"name"`
+	Ip           string `yaml:"ip"`
+	SessionCount string `yaml:"sessionCount"`
+	LastSession  string `yaml:"lastSession"`
+}
+
+type WarningConfig struct {
+	// Url returns open warnings of all assets in the format of the webhook, pulling is disabled if it is empty
+	Url string `yaml:"url"`
+	// Token is sent as the bearer token of pulls
+	Token string `yaml:"token"`
+	// Interval between pulls, unit is minute
+	Interval int `yaml:"interval"`
+	// Confirm is the lowest severity of warnings which connecting needs an extra confirmation for, empty means never
+	Confirm string `yaml:"confirm"`
+	// WebhookToken authorizes pushes of warnings, the webhook is disabled if it is empty
+	WebhookToken string `yaml:"webhookToken"`
+}
+
+type StepUpConfig struct {
+	// Tag is the risk tag of assets whose recordings are replayed only after a step up, empty means never
+	Tag string `yaml:"tag"`
+	// Ttl of a step up before it is used by a replay, unit is second
+	Ttl int `yaml:"ttl"`
+	// RpId of webauthn, the host of requests is used if it is empty
+	RpId string `yaml:"rpId"`
+	// Origins of webauthn assertions, origins with the host of the rp id are allowed if it is empty
+	Origins []string `yaml:"origins"`
+	// Issuer of totp shown by authenticator apps
+	Issuer string `yaml:"issuer"`
+	// MaxAttempts of failed verifications of a user before it is locked for LockTime, 0 means unlimited
+	MaxAttempts int `yaml:"maxAttempts"`
+	// LockTime is counted from the first failure, unit is second
+	LockTime int `yaml:"lockTime"`
+}
+
+type ClientToolConfig struct {
+	// Enable runs mysql, psql and redis-cli of sessions in ephemeral containers instead of the built-in clients
+	Enable bool `yaml:"enable"`
+	// Docker is the engine running containers, like unix:///var/run/docker.sock or tcp://host:2375
+	Docker string `yaml:"docker"`
+	// Network of containers, it must reach gateway tunnels listening on localhost of the bastion
+	Network string `yaml:"network"`
+	// Images of client tools by protocol, protocols without images use the built-in clients
+	Images map[string]string `yaml:"images"`
+}
+
+type QosConfig struct {
+	// Bandwidth shared by output of sessions and file transfers, unit is KB/s, 0 means no limit
+	Bandwidth int `yaml:"bandwidth"`
+	// Reserve of the bandwidth which bulk traffic never takes, unit is percent
+	Reserve int `yaml:"reserve"`
+	// BulkFlush is the size of output flushes of interactive sessions beyond which they are taken as bulk, unit is byte
+	BulkFlush int `yaml:"bulkFlush"`
+}
+
+type ExecConfig struct {
+	// Timeout of commands which do not give one, unit is second
+	Timeout int `yaml:"timeout"`
+	// MaxTimeout is the longest timeout commands could give, unit is second
+	MaxTimeout int `yaml:"maxTimeout"`
+	// MaxOutput of stdout and stderr each, the rest is dropped, unit is KB
+	MaxOutput int `yaml:"maxOutput"`
+}
+
+type BackpressureConfig struct {
+	// Queue is how many messages wait for a websocket of terminals or monitors, the policy applies once it is full
+	Queue int `yaml:"queue"`
+	// Policy of slow websockets, drop drops output they cannot take in time, close closes them
+	Policy string `yaml:"policy"`
+	// WriteTimeout closes websockets stalled longer than it whatever the policy is, unit is second
+	WriteTimeout int `yaml:"writeTimeout"`
+	// PingInterval of websockets of monitors, they are closed if pongs do not come back within PongTimeout, unit is
+	// second and 0 means never
+	PingInterval int `yaml:"pingInterval"`
+	PongTimeout  int `yaml:"pongTimeout"`
+}
+
+// TracingConfig traces phases of connecting by OpenTelemetry, spans are exported to an otlp collector over http
+type TracingConfig struct {
+	// Endpoint of the otlp collector, e.g. http://otel-collector:4318, spans are only logged if it is empty
+	Endpoint string `yaml:"endpoint"`
+	// Headers sent to the collector, e.g. authorization of hosted ones
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"serviceName"`
+	// Slow traces of connecting are logged as warnings with spans of them, unit is ms, 0 means never
+	Slow int `yaml:"slow"`
+}
+
+// EventBusConfig publishes session lifecycle, command and file transfer events for consumers like SOC pipelines
+type EventBusConfig struct {
+	// Driver is kafka by its rest proxy or nats, nothing is published if it is empty
+	Driver string `yaml:"driver"`
+	// Url of the rest proxy, e.g. http://kafka-rest:8082, or of the nats server, e.g. nats://nats:4222
+	Url      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// Token of nats, used instead of user and password if it is set
+	Token string `yaml:"token"`
+	// Topic is the prefix of topics or subjects, events go to <topic>.session, <topic>.command and <topic>.file
+	Topic string `yaml:"topic"`
+	// BatchSize of events of each publish
+	BatchSize int `yaml:"batchSize"`
+	// FlushInterval between publishes of partial batches, unit is second
+	FlushInterval int `yaml:"flushInterval"`
+	// BufferSize of events waiting for publishes, more events are dropped
+	BufferSize int `yaml:"bufferSize"`
+}
+
+// SyslogConfig forwards events of sessions to a syslog collector in rfc 5424
+type SyslogConfig struct {
+	// Addr of the collector, e.g. syslog:514, nothing is sent if it is empty
+	Addr string `yaml:"addr"`
+	// Network is udp, tcp or tls, messages of tcp and tls are framed by octet counting of rfc 6587
+	Network            string `yaml:"network"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	// Facility of messages, 13 is log audit
+	Facility int `yaml:"facility"`
+	// Events are types of events forwarded, e.g. session.open, session.close, session.admin_close, command.blocked,
+	// command, file.transfer and connect.failed
+	Events []string `yaml:"events"`
+	// BufferSize of messages waiting for sending, more messages are dropped
+	BufferSize int `yaml:"bufferSize"`
+}
+
+// WebhookConfig is of delivering events to webhooks, webhooks themselves are managed by the api
+type WebhookConfig struct {
+	// Timeout of a request, unit is second
+	Timeout int `yaml:"timeout"`
+	// MaxAttempts of a delivery, it is failed once they run out
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Backoff before the first retry, it doubles each time, unit is second
+	Backoff     int `yaml:"backoff"`
+	Concurrency int `yaml:"concurrency"`
+	// BufferSize of events waiting for deliveries, more events are dropped
+	BufferSize int `yaml:"bufferSize"`
+	// RetentionDays of deliveries, 0 means forever
+	RetentionDays int `yaml:"retentionDays"`
+}
+
+// AlertConfig mails admins of high severity events, which are blocked commands and failed connects repeated
+type AlertConfig struct {
+	Smtp SmtpConfig `yaml:"smtp"`
+	// To are addresses of admins alerted, nothing is sent if it is empty
+	To []string `yaml:"to"`
+	// Lang of mails, en or zh
+	Lang string `yaml:"lang"`
+	// FailedConnects of a user within the window raise an alert, 0 means never
+	FailedConnects int `yaml:"failedConnects"`
+	// Window of counting failed connects, unit is minute
+	Window int `yaml:"window"`
+	// Cooldown of alerts of the same user or session, later ones within it are dropped, unit is minute
+	Cooldown int `yaml:"cooldown"`
+}
+
+type SmtpConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address, the username is used if it is empty
+	From string `yaml:"from"`
+	// Tls is starttls, tls or none, tls is implicit tls usually of port 465
+	Tls                string `yaml:"tls"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	// Timeout of sending a mail, unit is second
+	Timeout int `yaml:"timeout"`
+}
+
+// ClusterConfig lets instances behind a load balancer share online sessions by redis, closing sessions held by other
+// instances is sent to them by redis pub/sub and monitors of them are forwarded to their advertised addresses
+type ClusterConfig struct {
+	Enable bool `yaml:"enable"`
+	// AdvertiseAddr is the address other instances reach the http server of this one, e.g. http://10.0.0.1:8888,
+	// monitors of sessions held by this one are not available on others if it is empty
+	AdvertiseAddr string `yaml:"advertiseAddr"`
+	// Ttl of heartbeats of instances, sessions of instances missing heartbeats are taken as gone, unit is second
+	Ttl int `yaml:"ttl"`
+}
+
+// LicenseConfig is of licensed limits of usage, 0 means unlimited. Usage reaching the warn percent of a limit is
+// warned in logs and usage reports, it is blocked only beyond the limit
+type LicenseConfig struct {
+	// MaxUsers of a month, users without sessions in the month are blocked connecting beyond it
+	MaxUsers int `yaml:"maxUsers"`
+	// MaxSessions online at the same time of all instances
+	MaxSessions int `yaml:"maxSessions"`
+	// MaxAssets managed, creating assets is blocked beyond it
+	MaxAssets int `yaml:"maxAssets"`
+	// Warn percent of limits
+	Warn int `yaml:"warn"`
+}
+
+// StatusConfig is of the status of the service for status pages, it is driven by the same checks as /readyz
+type StatusConfig struct {
+	// Public serves the status without login
+	Public bool `yaml:"public"`
+	// Interval between checks of dependencies, unit is second
+	Interval int `yaml:"interval"`
+	// Timeout of checking dependencies, unit is second
+	Timeout int `yaml:"timeout"`
+}
+
+// ProxyConfig tells real addresses of clients behind load balancers, they are recorded on sessions and audits and
+// taken by policies of ips
+type ProxyConfig struct {
+	// TrustedProxies are ips or cidrs of load balancers, headers and proxy protocol of others are ignored
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// RemoteIPHeaders of http are looked at in order, the first of them set by trusted proxies wins
+	RemoteIPHeaders []string `yaml:"remoteIPHeaders"`
+	// TrustedPlatform is a header of the client ip set by platforms, e.g. CF-Connecting-IP, it wins over others
+	TrustedPlatform string `yaml:"trustedPlatform"`
+	// ProxyProtocol are listeners of http, ssh and dbProxy taking proxy protocol v1 and v2 of trusted proxies
+	ProxyProtocol []string `yaml:"proxyProtocol"`
+	// HeaderTimeout of reading proxy protocol headers, unit is second
+	HeaderTimeout int `yaml:"headerTimeout"`
+}
+
+type ProbeConfig struct {
+	// Enable runs uname and hostname on targets when ssh sessions start, results are kept on sessions and assets
+	Enable bool `yaml:"enable"`
+	// Timeout of the probe, unit is second
+	Timeout int `yaml:"timeout"`
+}
+
+type ProfileConfig struct {
+	// ChanBlockWarn warn if a send to session channels blocks longer than it, unit is ms, 0 means never
+	ChanBlockWarn int `yaml:"chanBlockWarn"`
 }
 
 type ConfigYaml struct {
-	Mode      string      `yaml:"mode"`
-	I18nDir   string      `yaml:"i18nDir"`
-	Log       LogConfig   `yaml:"log"`
-	Redis     RedisConfig `yaml:"redis"`
-	Mysql     MysqlConfig `yaml:"mysql"`
-	Guacd     GuacdConfig `yaml:"guacd"`
-	Http      HttpConfig  `yaml:"http"`
-	Ssh       SshConfig   `yaml:"ssh"`
-	Auth      Auth        `yaml:"auth"`
-	SecretKey string      `yaml:"secretKey"`
+	Mode         string             `yaml:"mode"`
+	I18nDir      string             `yaml:"i18nDir"`
+	Log          LogConfig          `yaml:"log"`
+	Redis        RedisConfig        `yaml:"redis"`
+	Mysql        MysqlConfig        `yaml:"mysql"`
+	AuditDb      AuditDbConfig      `yaml:"auditDb"`
+	Clickhouse   ClickhouseConfig   `yaml:"clickhouse"`
+	Guacd        GuacdConfig        `yaml:"guacd"`
+	Http         HttpConfig         `yaml:"http"`
+	Ssh          SshConfig          `yaml:"ssh"`
+	Auth         Auth               `yaml:"auth"`
+	Storage      StorageConfig      `yaml:"storage"`
+	Profile      ProfileConfig      `yaml:"profile"`
+	DbProxy      DbProxyConfig      `yaml:"dbProxy"`
+	Warmup       WarmupConfig       `yaml:"warmup"`
+	SshCa        SshCaConfig        `yaml:"sshCa"`
+	Credential   CredentialConfig   `yaml:"credential"`
+	Rotation     RotationConfig     `yaml:"rotation"`
+	Discovery    DiscoveryConfig    `yaml:"discovery"`
+	Probe        ProbeConfig        `yaml:"probe"`
+	Health       HealthConfig       `yaml:"health"`
+	Canary       CanaryConfig       `yaml:"canary"`
+	Cmdb         CmdbConfig         `yaml:"cmdb"`
+	Warning      WarningConfig      `yaml:"warning"`
+	StepUp       StepUpConfig       `yaml:"stepUp"`
+	ClientTool   ClientToolConfig   `yaml:"clientTool"`
+	Qos          QosConfig          `yaml:"qos"`
+	Exec         ExecConfig         `yaml:"exec"`
+	Backpressure BackpressureConfig `yaml:"backpressure"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+	EventBus     EventBusConfig     `yaml:"eventBus"`
+	Syslog       SyslogConfig       `yaml:"syslog"`
+	Webhook      WebhookConfig      `yaml:"webhook"`
+	Alert        AlertConfig        `yaml:"alert"`
+	Status       StatusConfig       `yaml:"status"`
+	License      LicenseConfig      `yaml:"license"`
+	Cluster      ClusterConfig      `yaml:"cluster"`
+	Proxy        ProxyConfig        `yaml:"proxy"`
+	SecretKey    string             `yaml:"secretKey"`
 }
@@ -0,0 +1,189 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	PROVIDER_AWS = "aws"
+
+	// AWS_ENGINE_SECRETSMANAGER path is arn or name of the secret, role is the version stage
+	AWS_ENGINE_SECRETSMANAGER = "secretsmanager"
+	// AWS_ENGINE_KMS saved secrets of account are base64 ciphertext blobs, path is the key id if it is restricted
+	AWS_ENGINE_KMS = "kms"
+)
+
+func init() {
+	Register(PROVIDER_AWS, &awsProvider{cli: &http.Client{Timeout: time.Second * 10}})
+}
+
+// awsProvider calls json apis of secrets manager and kms signed with signature v4,
+// a secret string in json could have fields password, private_key and passphrase, otherwise it is the password
+//
+//	https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetSecretValue.html
+//	https://docs.aws.amazon.com/kms/latest/APIReference/API_Decrypt.html
+type awsProvider struct {
+	cli *http.Client
+}
+
+func (a *awsProvider) Validate(ref *model.CredentialRef) error {
+	switch ref.Engine {
+	case "", AWS_ENGINE_SECRETSMANAGER:
+		if ref.Path == "" {
+			return fmt.Errorf("arn of secret is required")
+		}
+	case AWS_ENGINE_KMS:
+	default:
+		return fmt.Errorf("unknown aws engine %s", ref.Engine)
+	}
+	return nil
+}
+
+func (a *awsProvider) Fetch(ctx context.Context, ref *model.CredentialRef, asset *model.Asset, account *model.Account) (s *Secret, err error) {
+	switch ref.Engine {
+	case "", AWS_ENGINE_SECRETSMANAGER:
+		req := map[string]string{"SecretId": ref.Path}
+		if ref.Role != "" {
+			req["VersionStage"] = ref.Role
+		}
+		res := &struct {
+			SecretString string `json:"SecretString"`
+			SecretBinary []byte `json:"SecretBinary"`
+		}{}
+		if err = a.call(ctx, "secretsmanager", "secretsmanager.GetSecretValue", ref.Path, req, res); err != nil {
+			return
+		}
+		str := res.SecretString
+		if str == "" {
+			str = string(res.SecretBinary)
+		}
+		fields := map[string]string{}
+		if json.Unmarshal([]byte(str), &fields) != nil {
+			return &Secret{Password: str}, nil
+		}
+		return &Secret{Password: fields["password"], Pk: fields["private_key"], Phrase: fields["passphrase"]}, nil
+	case AWS_ENGINE_KMS:
+		s = &Secret{}
+		for _, f := range []struct{ src, dst *string }{{&account.Password, &s.Password}, {&account.Pk, &s.Pk}, {&account.Phrase, &s.Phrase}} {
+			if *f.src == "" {
+				continue
+			}
+			if *f.dst, err = a.decrypt(ctx, ref.Path, *f.src); err != nil {
+				return nil, err
+			}
+		}
+		return
+	}
+	return nil, fmt.Errorf("unknown aws engine %s", ref.Engine)
+}
+
+func (a *awsProvider) decrypt(ctx context.Context, keyId, blob string) (plain string, err error) {
+	req := map[string]string{"CiphertextBlob": strings.TrimSpace(blob)}
+	if keyId != "" {
+		req["KeyId"] = keyId
+	}
+	res := &struct {
+		Plaintext []byte `json:"Plaintext"`
+	}{}
+	if err = a.call(ctx, "kms", "TrentService.Decrypt", keyId, req, res); err != nil {
+		return
+	}
+	return string(res.Plaintext), nil
+}
+
+// call posts req to the json api of service, region is taken from arn if it is one
+func (a *awsProvider) call(ctx context.Context, service, target, arn string, req, res any) (err error) {
+	cfg := conf.Cfg.Credential.Aws
+	region := cfg.Region
+	if parts := strings.Split(arn, ":"); len(parts) > 3 && parts[0] == "arn" && parts[3] != "" {
+		region = parts[3]
+	}
+	if region == "" {
+		return fmt.Errorf("region of aws is not configured")
+	}
+	ak, sk, token := cfg.AccessKey, cfg.SecretKey, cfg.SessionToken
+	if ak == "" {
+		ak, sk, token = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if ak == "" || sk == "" {
+		return fmt.Errorf("credentials of aws are not configured")
+	}
+
+	body, _ := json.Marshal(req)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	r.Header.Set("X-Amz-Target", target)
+	if token != "" {
+		r.Header.Set("X-Amz-Security-Token", token)
+	}
+	awsSign(r, body, service, region, ak, sk)
+
+	resp, err := a.cli.Do(r)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}{}
+		json.Unmarshal(bs, e)
+		return fmt.Errorf("aws %s %s: %s %s", service, resp.Status, e.Type, e.Message)
+	}
+	return json.Unmarshal(bs, res)
+}
+
+// awsSign signs r with signature v4, all set headers of x-amz and host are signed
+//
+//	https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func awsSign(r *http.Request, body []byte, service, region, ak, sk string) {
+	now := time.Now().UTC()
+	date, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	names := []string{"host"}
+	headers := "host:" + r.URL.Host + "\n"
+	for _, k := range []string{"X-Amz-Date", "X-Amz-Security-Token", "X-Amz-Target"} {
+		if v := r.Header.Get(k); v != "" {
+			names = append(names, strings.ToLower(k))
+			headers += strings.ToLower(k) + ":" + v + "\n"
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{r.Method, "/", "", headers, signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + sk)
+	for _, v := range []string{date, region, service, "aws4_request", stringToSign} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(v))
+		key = h.Sum(nil)
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		ak, scope, signedHeaders, hex.EncodeToString(key)))
+}
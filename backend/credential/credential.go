@@ -0,0 +1,107 @@
+package credential
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/model"
+)
+
+// Secret is what providers return, empty fields keep the saved ones of account
+type Secret struct {
+	Password string
+	Pk       string
+	Phrase   string
+	// OneTime secrets like otp must not be cached
+	OneTime bool
+}
+
+// Provider fetches secrets of accounts from an external store, asset is nil if it is not for a specific asset
+type Provider interface {
+	// Validate checks fields of ref when accounts are saved
+	Validate(ref *model.CredentialRef) error
+	Fetch(ctx context.Context, ref *model.CredentialRef, asset *model.Asset, account *model.Account) (*Secret, error)
+}
+
+type cached struct {
+	secret *Secret
+	expire time.Time
+}
+
+var (
+	providers = map[string]Provider{}
+	cache     sync.Map
+)
+
+// Register is called in init of providers
+func Register(name string, p Provider) {
+	providers[name] = p
+}
+
+// Validate checks whether the provider and engine of ref are supported
+func Validate(ref *model.CredentialRef) error {
+	if ref.Provider == "" {
+		return nil
+	}
+	p, ok := providers[ref.Provider]
+	if !ok {
+		return fmt.Errorf("unknown credential provider %s", ref.Provider)
+	}
+	return p.Validate(ref)
+}
+
+// Resolve fills secrets of account from its provider, accounts without provider are left as they are
+func Resolve(ctx context.Context, asset *model.Asset, account *model.Account) (err error) {
+	ref := &account.Credential
+	if ref.Provider == "" {
+		return
+	}
+	p, ok := providers[ref.Provider]
+	if !ok {
+		return fmt.Errorf("unknown credential provider %s", ref.Provider)
+	}
+
+	// secrets of kms differ by account even if refs are the same
+	key := strings.Join([]string{ref.Provider, ref.Engine, ref.Path, ref.Role, fmt.Sprint(account.Id), account.Account}, "\x00")
+	if v, ok := cache.Load(key); ok && time.Now().Before(v.(*cached).expire) {
+		fill(account, v.(*cached).secret)
+		return
+	}
+	s, err := p.Fetch(ctx, ref, asset, account)
+	if err != nil {
+		return fmt.Errorf("fetch credential from %s failed: %w", ref.Provider, err)
+	}
+	if ttl := time.Second * time.Duration(conf.Cfg.Credential.CacheTtl); ttl > 0 && !s.OneTime {
+		cache.Store(key, &cached{secret: s, expire: time.Now().Add(ttl)})
+	}
+	fill(account, s)
+
+	return
+}
+
+// Forget drops cached secrets of the ref, it is called when the account is changed
+func Forget(ref *model.CredentialRef) {
+	prefix := strings.Join([]string{ref.Provider, ref.Engine, ref.Path, ref.Role}, "\x00") + "\x00"
+	cache.Range(func(k, _ any) bool {
+		if strings.HasPrefix(k.(string), prefix) {
+			cache.Delete(k)
+		}
+		return true
+	})
+}
+
+func fill(account *model.Account, s *Secret) {
+	if s.Password != "" {
+		account.Password = s.Password
+	}
+	if s.Pk != "" {
+		account.Pk = s.Pk
+	}
+	if s.Phrase != "" {
+		account.Phrase = s.Phrase
+	}
+}
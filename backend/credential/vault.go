@@ -0,0 +1,182 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	PROVIDER_VAULT = "vault"
+
+	VAULT_ENGINE_KV  = "kv"
+	VAULT_ENGINE_SSH = "ssh"
+)
+
+func init() {
+	Register(PROVIDER_VAULT, &vault{})
+}
+
+// vault reads kv version 2 secrets with fields password, private_key and passphrase,
+// or gets otp of ssh secrets engine as password
+//
+//	https://developer.hashicorp.com/vault/api-docs/secret/kv/kv-v2
+//	https://developer.hashicorp.com/vault/api-docs/secret/ssh
+type vault struct {
+	once   sync.Once
+	cli    *http.Client
+	mtx    sync.Mutex
+	token  string
+	expire time.Time
+}
+
+type vaultResp struct {
+	Data   map[string]any `json:"data"`
+	Errors []string       `json:"errors"`
+	Auth   *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+func (v *vault) Validate(ref *model.CredentialRef) error {
+	switch ref.Engine {
+	case "", VAULT_ENGINE_KV:
+		if ref.Path == "" {
+			return fmt.Errorf("path of kv secret is required")
+		}
+	case VAULT_ENGINE_SSH:
+		if ref.Role == "" {
+			return fmt.Errorf("role of ssh secrets engine is required")
+		}
+	default:
+		return fmt.Errorf("unknown vault engine %s", ref.Engine)
+	}
+	return nil
+}
+
+func (v *vault) Fetch(ctx context.Context, ref *model.CredentialRef, asset *model.Asset, account *model.Account) (s *Secret, err error) {
+	if conf.Cfg.Credential.Vault.Addr == "" {
+		return nil, fmt.Errorf("vault is not configured")
+	}
+	switch ref.Engine {
+	case "", VAULT_ENGINE_KV:
+		res, err := v.do(ctx, http.MethodGet, path.Join(conf.Cfg.Credential.Vault.KvMount, "data", ref.Path), nil)
+		if err != nil {
+			return nil, err
+		}
+		// kv v2 wraps the secret with its metadata
+		data, _ := res.Data["data"].(map[string]any)
+		str := func(k string) string { s, _ := data[k].(string); return s }
+		return &Secret{Password: str("password"), Pk: str("private_key"), Phrase: str("passphrase")}, nil
+	case VAULT_ENGINE_SSH:
+		if asset == nil {
+			return nil, fmt.Errorf("otp of ssh secrets engine needs an asset")
+		}
+		mount := ref.Path
+		if mount == "" {
+			mount = "ssh"
+		}
+		res, err := v.do(ctx, http.MethodPost, path.Join(mount, "creds", ref.Role), map[string]string{"ip": asset.Ip, "username": account.Account})
+		if err != nil {
+			return nil, err
+		}
+		key, _ := res.Data["key"].(string)
+		if key == "" {
+			return nil, fmt.Errorf("no otp in response")
+		}
+		return &Secret{Password: key, OneTime: true}, nil
+	}
+	return nil, fmt.Errorf("unknown vault engine %s", ref.Engine)
+}
+
+// do retries once with a new token of approle if the current one is denied since it may be revoked
+func (v *vault) do(ctx context.Context, method, p string, body any) (res *vaultResp, err error) {
+	v.once.Do(func() {
+		v.cli = &http.Client{
+			Timeout:   time.Second * 10,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.Cfg.Credential.Vault.InsecureSkipVerify}},
+		}
+	})
+	for i := 0; i < 2; i++ {
+		token, e := v.getToken(ctx)
+		if e != nil {
+			return nil, e
+		}
+		var status int
+		status, res, err = v.request(ctx, method, p, token, body)
+		if status != http.StatusForbidden || conf.Cfg.Credential.Vault.Token != "" {
+			break
+		}
+		v.mtx.Lock()
+		v.token = ""
+		v.mtx.Unlock()
+	}
+	return
+}
+
+func (v *vault) getToken(ctx context.Context) (token string, err error) {
+	cfg := conf.Cfg.Credential.Vault
+	if cfg.Token != "" {
+		return cfg.Token, nil
+	}
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if v.token != "" && time.Now().Before(v.expire) {
+		return v.token, nil
+	}
+	_, res, err := v.request(ctx, http.MethodPost, "auth/approle/login", "", map[string]string{"role_id": cfg.RoleId, "secret_id": cfg.SecretId})
+	if err != nil {
+		return
+	}
+	if res.Auth == nil || res.Auth.ClientToken == "" {
+		return "", fmt.Errorf("no token of approle login")
+	}
+	// renewed a bit earlier than the lease ends
+	v.token, v.expire = res.Auth.ClientToken, time.Now().Add(time.Second*time.Duration(res.Auth.LeaseDuration)*4/5)
+	return v.token, nil
+}
+
+func (v *vault) request(ctx context.Context, method, p, token string, body any) (status int, res *vaultResp, err error) {
+	cfg := conf.Cfg.Credential.Vault
+	var rd io.Reader
+	if body != nil {
+		bs, _ := json.Marshal(body)
+		rd = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(cfg.Addr, "/")+"/v1/"+strings.TrimPrefix(p, "/"), rd)
+	if err != nil {
+		return
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", cfg.Namespace)
+	}
+	resp, err := v.cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	res = &vaultResp{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(res); err != nil && resp.StatusCode/100 == 2 {
+		return resp.StatusCode, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, nil, fmt.Errorf("vault %s: %s", resp.Status, strings.Join(res.Errors, "; "))
+	}
+	return resp.StatusCode, res, nil
+}
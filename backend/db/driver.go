@@ -0,0 +1,32 @@
+package mysql
+
+import (
+	"fmt"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// Driver opens a dialector of a database by its dsn
+type Driver func(dsn string) gorm.Dialector
+
+var (
+	drivers = map[string]Driver{
+		"mysql": mysql.Open,
+	}
+)
+
+// RegisterDriver makes a database available as the audit store by its name, call it in init of a file built with the driver
+func RegisterDriver(name string, d Driver) {
+	drivers[name] = d
+}
+
+func open(driver, dsn string) (*gorm.DB, error) {
+	d, ok := drivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %s", driver)
+	}
+	return gorm.Open(d(dsn), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+}
@@ -2,10 +2,10 @@ package mysql
 
 import (
 	"fmt"
+	"net"
 	"strings"
 
 	"go.uber.org/zap"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 
 	"github.com/veops/oneterm/conf"
@@ -14,30 +14,55 @@ import (
 )
 
 var (
+	// DB stores configurations like assets, accounts and policies
 	DB *gorm.DB
+	// AuditDB stores high-write records of sessions, it is DB unless another database is configured
+	AuditDB *gorm.DB
 )
 
 func init() {
 	var err error
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/oneterm?charset=utf8mb4&parseTime=True&loc=Local",
-		conf.Cfg.Mysql.User, conf.Cfg.Mysql.Password, conf.Cfg.Mysql.Host, conf.Cfg.Mysql.Port)
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-		DisableForeignKeyConstraintWhenMigrating: true,
-	})
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/oneterm?charset=utf8mb4&parseTime=True&loc=Local",
+		conf.Cfg.Mysql.User, conf.Cfg.Mysql.Password, net.JoinHostPort(conf.Cfg.Mysql.Host, conf.Cfg.Mysql.Port))
+	DB, err = open("mysql", dsn)
 	if err != nil {
 		logger.L().Fatal("init mysql failed", zap.Error(err))
 	}
 
 	err = DB.AutoMigrate(
 		model.DefaultAccount, model.DefaultAsset, model.DefaultAuthorization, model.DefaultCommand,
-		model.DefaultConfig, model.DefaultFileHistory, model.DefaultGateway, model.DefaultHistory,
-		model.DefaultNode, model.DefaultPublicKey, model.DefaultSession, model.DefaultSessionCmd,
-		model.DefaultShare,
+		model.DefaultCommandApproval, model.DefaultCommandPolicy, model.DefaultConfig, model.DefaultGateway, model.DefaultHistory,
+		model.DefaultNode, model.DefaultPublicKey,
+		model.DefaultShare, model.DefaultMaintenanceWindow, model.DefaultSshCa,
+		model.DefaultRotationHistory, model.DefaultAgentKey,
+		model.DefaultDiscoveredAsset, model.DefaultCloudAccount,
+		model.DefaultAssetWarning, model.DefaultGatewayGroup, model.DefaultStepUpCredential,
+		model.DefaultMacro, model.DefaultMfaPolicy, model.DefaultAccessRequest,
+		model.DefaultLabTemplate, model.DefaultLab, model.DefaultClipboardPolicy,
+		model.DefaultFeatureFlag, model.DefaultSessionReview, model.DefaultWatermarkPolicy,
+		model.DefaultPreference, model.DefaultWebhook, model.DefaultWebhookDelivery,
+		model.DefaultNotification, model.DefaultUsage,
 	)
 	if err != nil {
 		logger.L().Fatal("auto migrate mysql failed", zap.Error(err))
 	}
 
+	AuditDB = DB
+	if cfg := conf.Cfg.AuditDb; cfg.Driver != "" {
+		if AuditDB, err = open(cfg.Driver, cfg.Dsn); err != nil {
+			logger.L().Fatal("init audit db failed", zap.String("driver", cfg.Driver), zap.Error(err))
+		}
+	}
+	err = AuditDB.AutoMigrate(
+		model.DefaultSession, model.DefaultSessionCmd, model.DefaultAccessLog, model.DefaultFileHistory,
+		model.DefaultAgentSignLog, model.DefaultX11Capture, model.DefaultAssetHealth, model.DefaultCanaryRun,
+		model.DefaultStepUp, model.DefaultReplayLog, model.DefaultSessionTakeover,
+		model.DefaultClipboardLog, model.DefaultFileTransfer, model.DefaultWorkspace,
+	)
+	if err != nil {
+		logger.L().Fatal("auto migrate audit db failed", zap.Error(err))
+	}
+
 	dropIndexs := map[string]any{
 		"asset_account_id_del": &model.Authorization{},
 	}
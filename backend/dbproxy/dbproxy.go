@@ -0,0 +1,327 @@
+package dbproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/proxyproto"
+	gsession "github.com/veops/oneterm/session"
+	"github.com/veops/oneterm/usage"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	kFmtToken = "oneterm-dbproxy-token-%s"
+)
+
+var (
+	ctx, cancel = context.WithCancel(context.Background())
+	listeners   []net.Listener
+
+	errToken = errors.New("invalid token")
+)
+
+// Token is issued by the api after authorization checks, native clients log in proxies with it instead of the account
+type Token struct {
+	User      string `json:"user"`
+	Password  string `json:"password"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	ExpireAt  int64  `json:"expire_at"`
+	Uid       int    `json:"-"`
+	Rid       int    `json:"-"`
+	UserName  string `json:"-"`
+	AssetId   int    `json:"-"`
+	AccountId int    `json:"-"`
+	Protocol  string `json:"-"`
+}
+
+// tokenInfo is what is stored in redis, Token hides ids from json
+type tokenInfo struct {
+	Password  string `json:"password"`
+	Uid       int    `json:"uid"`
+	Rid       int    `json:"rid"`
+	UserName  string `json:"user_name"`
+	AssetId   int    `json:"asset_id"`
+	AccountId int    `json:"account_id"`
+	Protocol  string `json:"protocol"`
+}
+
+// NewToken saves a token which could be used by many connections until it expires
+func NewToken(uid, rid int, userName string, assetId, accountId int, protocol string) (t *Token, err error) {
+	port, ok := Ports()[protocol]
+	if !ok {
+		err = fmt.Errorf("proxy of %s is not enabled", protocol)
+		return
+	}
+	ttl := time.Minute * time.Duration(max(conf.Cfg.DbProxy.TokenTtl, 1))
+	t = &Token{
+		User:      randHex(16),
+		Password:  randHex(16),
+		Host:      conf.Cfg.DbProxy.PublicHost,
+		Port:      port,
+		ExpireAt:  time.Now().Add(ttl).Unix(),
+		Uid:       uid,
+		Rid:       rid,
+		UserName:  userName,
+		AssetId:   assetId,
+		AccountId: accountId,
+		Protocol:  protocol,
+	}
+	err = redis.SetEx(ctx, fmt.Sprintf(kFmtToken, t.User), &tokenInfo{
+		Password:  t.Password,
+		Uid:       uid,
+		Rid:       rid,
+		UserName:  userName,
+		AssetId:   assetId,
+		AccountId: accountId,
+		Protocol:  protocol,
+	}, ttl)
+	return
+}
+
+func getToken(user, protocol string) (t *Token, err error) {
+	info := &tokenInfo{}
+	if err = redis.Get(ctx, fmt.Sprintf(kFmtToken, user), info); err != nil {
+		err = errToken
+		return
+	}
+	if info.Protocol != protocol {
+		err = fmt.Errorf("%w: token is not for %s", errToken, protocol)
+		return
+	}
+	t = &Token{
+		User:      user,
+		Password:  info.Password,
+		Uid:       info.Uid,
+		Rid:       info.Rid,
+		UserName:  info.UserName,
+		AssetId:   info.AssetId,
+		AccountId: info.AccountId,
+		Protocol:  info.Protocol,
+	}
+	return
+}
+
+func randHex(n int) string {
+	bs := make([]byte, n/2)
+	rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+// Ports returns enabled proxies
+func Ports() map[string]int {
+	cfg := conf.Cfg.DbProxy
+	return lo.PickBy(map[string]int{
+		"mysql":    cfg.MysqlPort,
+		"redis":    cfg.RedisPort,
+		"postgres": cfg.PostgresPort,
+	}, func(_ string, port int) bool { return port > 0 })
+}
+
+// Conn is a proxied connection of one session
+type Conn struct {
+	Sess    *gsession.Session
+	Parser  *gsession.Parser
+	Asset   *model.Asset
+	Account *model.Account
+	Gateway *model.Gateway
+}
+
+// newConn creates an online session for the token
+func newConn(t *Token, clientIp string) (c *Conn, err error) {
+	asset, account, gateway, err := util.GetAAG(t.AssetId, t.AccountId)
+	if err != nil {
+		return
+	}
+	sess := gsession.NewSession(ctx)
+	sess.Session = &model.Session{
+		SessionType: model.SESSIONTYPE_PROXY,
+		SessionId:   uuid.New().String(),
+		Uid:         t.Uid,
+		UserName:    t.UserName,
+		AssetId:     asset.Id,
+		Asset:       asset,
+		AssetInfo:   fmt.Sprintf("%s(%s)", asset.Name, asset.Ip),
+		AccountId:   account.Id,
+		AccountInfo: fmt.Sprintf("%s(%s)", account.Name, account.Account),
+		GatewayId:   asset.GatewayId,
+		GatewayInfo: lo.Ternary(asset.GatewayId == 0, "", fmt.Sprintf("%s(%s)", gateway.Name, gateway.Host)),
+		ClientIp:    clientIp,
+		Protocol:    t.Protocol,
+		Status:      model.SESSIONSTATUS_ONLINE,
+	}
+	sess.Chans.SessionId = sess.SessionId
+	if window, e := gsession.GetMaintenanceWindow(t.Uid, t.Rid, asset); e != nil {
+		logger.L().Warn("get maintenance window failed", zap.String("sessionId", sess.SessionId), zap.Error(e))
+	} else if window != nil {
+		sess.MaintenanceWindowId = window.Id
+	}
+	parser := gsession.NewParser(sess.SessionId, 80, 24)
+	if err = parser.LoadRules(asset.AccessAuth.CmdIds, t.Uid, t.Rid, asset.Id, account.Id); err != nil {
+		return
+	}
+	// limits are checked again since tokens are valid for a while, native clients can not wait in the queue
+	if err = usage.CheckSession(t.Uid); err != nil {
+		return
+	}
+	if cfg := asset.Concurrency; cfg.Max > 0 {
+		if sess.Slot, err = gsession.AcquireSlot(asset.Id, cfg.Max, false); err != nil {
+			return
+		}
+	}
+	c = &Conn{Sess: sess, Parser: parser, Asset: asset, Account: account, Gateway: gateway}
+	return
+}
+
+// Abort frees what the conn holds if it fails before getting online
+func (c *Conn) Abort() {
+	ggateway.GetGatewayManager().Close(c.Sess.SessionId)
+	c.Sess.Slot.Release()
+}
+
+// Dial connects the target through gateway if there is one
+func (c *Conn) Dial(protocol string) (net.Conn, error) {
+	ip, port, err := util.Proxy(false, c.Sess.SessionId, protocol, c.Asset, c.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), time.Second*3)
+}
+
+func (c *Conn) Online() {
+	gsession.GetOnlineSession().Store(c.Sess.SessionId, c.Sess)
+	gsession.UpsertSession(c.Sess)
+}
+
+func (c *Conn) Offline() {
+	ggateway.GetGatewayManager().Close(c.Sess.SessionId)
+	c.Sess.Slot.Release()
+	gsession.GetOnlineSession().Delete(c.Sess.SessionId)
+	c.Sess.Once.Do(func() { close(c.Sess.Chans.AwayChan) })
+	c.Sess.Status = model.SESSIONSTATUS_OFFLINE
+	c.Sess.ClosedAt = lo.ToPtr(time.Now())
+	if err := gsession.UpsertSession(c.Sess); err != nil {
+		logger.L().Error("offline proxy session failed", zap.String("sessionId", c.Sess.SessionId), zap.Error(err))
+	}
+}
+
+// Check returns the reason if the statement is not allowed, statements can not wait for confirm or approval,
+// so they are blocked as well
+func (c *Conn) Check(stmt string) (reason string, blocked bool) {
+	defer func() {
+		if blocked {
+			eventbus.PublishBlocked(c.Sess.SessionId, c.Sess.UserName, stmt, reason)
+		}
+	}()
+	if filter, forbidden := c.Parser.IsForbidden(stmt); forbidden {
+		return fmt.Sprintf("%s is forbidden", filter), true
+	}
+	policy, pattern := c.Parser.MatchPolicy(stmt)
+	if policy == nil {
+		return
+	}
+	switch policy.Action {
+	case model.POLICY_ACTION_WARN:
+		return
+	case model.POLICY_ACTION_BLOCK:
+		return fmt.Sprintf("%s: %s is forbidden", policy.Name, pattern), true
+	default:
+		return fmt.Sprintf("%s: %s needs confirm or approval which is not supported by proxy", policy.Name, pattern), true
+	}
+}
+
+// Audit saves the statement as a command of session
+func (c *Conn) Audit(stmt, result string) {
+	m := &model.SessionCmd{
+		SessionId: c.Sess.SessionId,
+		Cmd:       stmt,
+		Result:    result,
+	}
+	if err := mysql.AuditDB.Model(m).Create(m).Error; err != nil {
+		logger.L().Error("write session cmd failed", zap.Error(err), zap.Any("cmd", *m))
+	}
+}
+
+// saveAccessLog records failed logins, connections closed before presenting a token are not recorded
+func saveAccessLog(protocol string, conn net.Conn, c *Conn, err error) {
+	if c == nil && !errors.Is(err, errToken) {
+		return
+	}
+	l := &model.AccessLog{
+		Protocol:    protocol,
+		SessionType: model.SESSIONTYPE_PROXY,
+		ClientIp:    util.IpFromNetAddr(conn.RemoteAddr()),
+		Code:        model.ACCESSCODE_CREDENTIAL,
+		Reason:      err.Error(),
+	}
+	if c != nil {
+		l.Uid, l.UserName = c.Sess.Uid, c.Sess.UserName
+		l.AssetId, l.AccountId = c.Asset.Id, c.Account.Id
+		l.Code = model.ACCESSCODE_CONNECT
+	}
+	if err := mysql.AuditDB.Model(l).Create(l).Error; err != nil {
+		logger.L().Error("save access log failed", zap.Error(err))
+	}
+}
+
+// serve accepts connections until proxy stops
+func serve(l net.Listener, handle func(net.Conn)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+func RunDbProxy() (err error) {
+	handlers := map[string]func(net.Conn){
+		"mysql":    handleMysql,
+		"redis":    handleRedis,
+		"postgres": handlePostgres,
+	}
+	ports := Ports()
+	for protocol, port := range ports {
+		l, err := proxyproto.Listen(proxyproto.LISTENER_DBPROXY, net.JoinHostPort(conf.Cfg.DbProxy.Host, fmt.Sprint(port)))
+		if err != nil {
+			StopDbProxy()
+			return err
+		}
+		listeners = append(listeners, l)
+		go func(protocol string, handle func(net.Conn)) {
+			if err := serve(l, handle); err != nil {
+				logger.L().Error("db proxy stopped", zap.String("protocol", protocol), zap.Error(err))
+			}
+		}(protocol, handlers[protocol])
+	}
+	<-ctx.Done()
+	return
+}
+
+func StopDbProxy() {
+	defer cancel()
+	for _, l := range listeners {
+		l.Close()
+	}
+}
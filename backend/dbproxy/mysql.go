@@ -0,0 +1,565 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/util"
+)
+
+// capabilities and commands of mysql protocol
+//
+//	https://dev.mysql.com/doc/dev/mysql-server/latest/PAGE_PROTOCOL.html
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientFoundRows        = 0x00000002
+	mysqlClientLongFlag         = 0x00000004
+	mysqlClientConnectWithDb    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientTransactions     = 0x00002000
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientMultiStatements  = 0x00010000
+	mysqlClientMultiResults     = 0x00020000
+	mysqlClientPluginAuth       = 0x00080000
+	mysqlClientPluginAuthLenenc = 0x00200000
+
+	// mysqlProxyCaps are what the proxy understands, ssl, compression and deprecated eof are not supported
+	mysqlProxyCaps = mysqlClientLongPassword | mysqlClientFoundRows | mysqlClientLongFlag | mysqlClientConnectWithDb |
+		mysqlClientProtocol41 | mysqlClientTransactions | mysqlClientSecureConnection | mysqlClientMultiStatements |
+		mysqlClientMultiResults | mysqlClientPluginAuth | mysqlClientPluginAuthLenenc
+
+	mysqlComQuit        = 0x01
+	mysqlComQuery       = 0x03
+	mysqlComStmtPrepare = 0x16
+
+	mysqlNativePassword = "mysql_native_password"
+	mysqlCachingSha2    = "caching_sha2_password"
+
+	mysqlMaxPacket = 0xffffff
+
+	mysqlErrAccessDenied    = 1045
+	mysqlErrSpecificAccess  = 1227
+	mysqlServerVersion      = "5.7.99-oneterm"
+	mysqlCharsetUtf8General = 33
+)
+
+var (
+	// mysqlMaxPayload limits packets joined from ones of max size, it is the max of max_allowed_packet of servers
+	mysqlMaxPayload = 1 << 30
+)
+
+type mysqlHandshakeResponse struct {
+	caps      uint32
+	maxPacket uint32
+	charset   byte
+	user      string
+	auth      []byte
+	db        string
+	plugin    string
+}
+
+type mysqlConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// start is the sequence of the last packet read, seq is the sequence of the next packet
+	start byte
+	seq   byte
+	mtx   sync.Mutex
+}
+
+func newMysqlConn(conn net.Conn) *mysqlConn {
+	return &mysqlConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// readPacket reads one packet, packets of max size are joined with the following ones
+func (c *mysqlConn) readPacket() (payload []byte, err error) {
+	head := make([]byte, 4)
+	for i := 0; ; i++ {
+		if _, err = io.ReadFull(c.reader, head); err != nil {
+			return
+		}
+		n := int(uint32(head[0]) | uint32(head[1])<<8 | uint32(head[2])<<16)
+		if i == 0 {
+			c.start = head[3]
+		}
+		c.seq = head[3] + 1
+		if len(payload)+n > mysqlMaxPayload {
+			return nil, fmt.Errorf("packet is over %d bytes", mysqlMaxPayload)
+		}
+		p := make([]byte, n)
+		if _, err = io.ReadFull(c.reader, p); err != nil {
+			return
+		}
+		payload = append(payload, p...)
+		if n < mysqlMaxPacket {
+			return
+		}
+	}
+}
+
+func (c *mysqlConn) writePacket(payload []byte) (err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for {
+		n := min(len(payload), mysqlMaxPacket)
+		head := []byte{byte(n), byte(n >> 8), byte(n >> 16), c.seq}
+		c.seq++
+		if _, err = c.conn.Write(append(head, payload[:n]...)); err != nil {
+			return
+		}
+		payload = payload[n:]
+		if n < mysqlMaxPacket {
+			return
+		}
+	}
+}
+
+func (c *mysqlConn) writeErr(code uint16, state, msg string) error {
+	p := []byte{0xff, byte(code), byte(code >> 8), '#'}
+	p = append(p, state...)
+	return c.writePacket(append(p, msg...))
+}
+
+func (c *mysqlConn) writeOk() error {
+	return c.writePacket([]byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00})
+}
+
+// Write makes conn a writer of relayed responses, it shares the lock with writePacket
+func (c *mysqlConn) Write(p []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.conn.Write(p)
+}
+
+func handleMysql(conn net.Conn) {
+	defer conn.Close()
+	client := newMysqlConn(conn)
+
+	c, target, err := mysqlAccept(client)
+	if err != nil {
+		logger.L().Warn("mysql proxy login failed", zap.String("client", conn.RemoteAddr().String()), zap.Error(err))
+		saveAccessLog("mysql", conn, c, err)
+		return
+	}
+	defer target.conn.Close()
+	c.Online()
+	defer c.Offline()
+
+	go func() {
+		select {
+		case closer := <-c.Sess.Chans.CloseChan:
+			logger.L().Info("closed by", zap.String("admin", closer))
+		case <-c.Sess.Chans.AwayChan:
+		}
+		conn.Close()
+		target.conn.Close()
+	}()
+	go func() {
+		io.Copy(client, target.reader)
+		conn.Close()
+	}()
+
+	for {
+		payload, err := client.readPacket()
+		if err != nil {
+			return
+		}
+		if client.start == 0 && len(payload) > 0 && payload[0] == mysqlComQuit {
+			return
+		}
+		if stmt, ok := mysqlStmt(payload); ok && client.start == 0 {
+			if reason, blocked := c.Check(stmt); blocked {
+				c.Audit(stmt, reason)
+				if err = client.writeErr(mysqlErrSpecificAccess, "42000", reason); err != nil {
+					return
+				}
+				continue
+			}
+			c.Audit(stmt, "")
+		}
+		target.seq = client.start
+		if err = target.writePacket(payload); err != nil {
+			return
+		}
+	}
+}
+
+// mysqlStmt returns the statement of queries and prepares, other commands have none
+func mysqlStmt(payload []byte) (stmt string, ok bool) {
+	if len(payload) == 0 || payload[0] != mysqlComQuery && payload[0] != mysqlComStmtPrepare {
+		return
+	}
+	return string(payload[1:]), true
+}
+
+// mysqlAccept authenticates client by token and logs in target with the account
+func mysqlAccept(client *mysqlConn) (c *Conn, target *mysqlConn, err error) {
+	salt := make([]byte, 20)
+	rand.Read(salt)
+	// salt must not contain NUL since it is terminated by NUL in handshake
+	for i := range salt {
+		salt[i] = salt[i]%94 + 33
+	}
+	if err = client.writePacket(mysqlServerHandshake(salt)); err != nil {
+		return
+	}
+	payload, err := client.readPacket()
+	if err != nil {
+		return
+	}
+	resp, err := parseMysqlHandshakeResponse(payload)
+	if err != nil {
+		return
+	}
+	if resp.plugin != mysqlNativePassword || len(resp.auth) != sha1.Size {
+		p := append([]byte{0xfe}, mysqlNativePassword+"\x00"...)
+		if err = client.writePacket(append(append(p, salt...), 0)); err != nil {
+			return
+		}
+		if resp.auth, err = client.readPacket(); err != nil {
+			return
+		}
+	}
+
+	t, err := getToken(resp.user, "mysql")
+	if err == nil && subtle.ConstantTimeCompare(resp.auth, scrambleNative([]byte(t.Password), salt)) != 1 {
+		err = fmt.Errorf("%w: wrong password", errToken)
+	}
+	if err != nil {
+		client.writeErr(mysqlErrAccessDenied, "28000", fmt.Sprintf("Access denied for user '%s': %s", resp.user, err))
+		return
+	}
+
+	if c, err = newConn(t, util.IpFromNetAddr(client.conn.RemoteAddr())); err != nil {
+		client.writeErr(mysqlErrAccessDenied, "28000", err.Error())
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Abort()
+		}
+	}()
+	conn, err := c.Dial("mysql")
+	if err != nil {
+		client.writeErr(mysqlErrAccessDenied, "28000", err.Error())
+		return
+	}
+	target = newMysqlConn(conn)
+	if err = mysqlLogin(target, resp, c.Account.Account, c.Account.Password); err != nil {
+		conn.Close()
+		client.writeErr(mysqlErrAccessDenied, "28000", err.Error())
+		return
+	}
+	err = client.writeOk()
+
+	return
+}
+
+func mysqlServerHandshake(salt []byte) []byte {
+	caps := uint32(mysqlProxyCaps)
+	buf := &bytes.Buffer{}
+	buf.WriteByte(10)
+	buf.WriteString(mysqlServerVersion + "\x00")
+	binary.Write(buf, binary.LittleEndian, uint32(1))
+	buf.Write(salt[:8])
+	buf.WriteByte(0)
+	binary.Write(buf, binary.LittleEndian, uint16(caps))
+	buf.WriteByte(mysqlCharsetUtf8General)
+	binary.Write(buf, binary.LittleEndian, uint16(0x0002))
+	binary.Write(buf, binary.LittleEndian, uint16(caps>>16))
+	buf.WriteByte(byte(len(salt) + 1))
+	buf.Write(make([]byte, 10))
+	buf.Write(salt[8:])
+	buf.WriteByte(0)
+	buf.WriteString(mysqlNativePassword + "\x00")
+	return buf.Bytes()
+}
+
+func parseMysqlHandshakeResponse(p []byte) (resp *mysqlHandshakeResponse, err error) {
+	if len(p) < 32 {
+		return nil, fmt.Errorf("invalid handshake response")
+	}
+	resp = &mysqlHandshakeResponse{
+		caps:      binary.LittleEndian.Uint32(p),
+		maxPacket: binary.LittleEndian.Uint32(p[4:]),
+		charset:   p[8],
+	}
+	if resp.caps&mysqlClientProtocol41 == 0 {
+		return nil, fmt.Errorf("protocol 4.1 is required")
+	}
+	r := bytes.NewBuffer(p[32:])
+	if resp.user, err = readNulString(r); err != nil {
+		return
+	}
+	switch {
+	case resp.caps&mysqlClientPluginAuthLenenc != 0:
+		n, e := readLenencInt(r)
+		if e != nil {
+			return nil, e
+		}
+		resp.auth = r.Next(int(n))
+	case resp.caps&mysqlClientSecureConnection != 0:
+		n, e := r.ReadByte()
+		if e != nil {
+			return nil, e
+		}
+		resp.auth = r.Next(int(n))
+	default:
+		s, e := readNulString(r)
+		if e != nil {
+			return nil, e
+		}
+		resp.auth = []byte(s)
+	}
+	if resp.caps&mysqlClientConnectWithDb != 0 {
+		resp.db, _ = readNulString(r)
+	}
+	if resp.caps&mysqlClientPluginAuth != 0 {
+		resp.plugin, _ = readNulString(r)
+	}
+	return
+}
+
+// mysqlLogin logs in target on behalf of client, the capabilities of client are kept so that responses could be relayed as they are
+func mysqlLogin(target *mysqlConn, resp *mysqlHandshakeResponse, user, password string) (err error) {
+	p, err := target.readPacket()
+	if err != nil {
+		return
+	}
+	if len(p) > 0 && p[0] == 0xff {
+		return mysqlError(p)
+	}
+	r := bytes.NewBuffer(p)
+	if v, _ := r.ReadByte(); v != 10 {
+		return fmt.Errorf("unsupported mysql protocol %d", v)
+	}
+	if _, err = readNulString(r); err != nil {
+		return
+	}
+	if r.Len() < 31 {
+		return fmt.Errorf("invalid handshake of mysql")
+	}
+	r.Next(4)
+	salt := append([]byte(nil), r.Next(8)...)
+	r.Next(1)
+	caps := uint32(binary.LittleEndian.Uint16(r.Next(2)))
+	r.Next(3)
+	caps |= uint32(binary.LittleEndian.Uint16(r.Next(2))) << 16
+	authLen, _ := r.ReadByte()
+	r.Next(10)
+	salt = append(salt, r.Next(max(13, int(authLen)-8))...)
+	salt = bytes.TrimRight(salt, "\x00")
+	plugin := mysqlNativePassword
+	if caps&mysqlClientPluginAuth != 0 {
+		plugin, _ = readNulString(r)
+	}
+
+	caps &= resp.caps & mysqlProxyCaps
+	auth, err := scrambleMysql(plugin, []byte(password), salt)
+	if err != nil {
+		return
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, caps)
+	binary.Write(buf, binary.LittleEndian, resp.maxPacket)
+	buf.WriteByte(resp.charset)
+	buf.Write(make([]byte, 23))
+	buf.WriteString(user + "\x00")
+	if caps&mysqlClientPluginAuthLenenc != 0 {
+		buf.Write(lenencInt(uint64(len(auth))))
+	} else {
+		buf.WriteByte(byte(len(auth)))
+	}
+	buf.Write(auth)
+	if caps&mysqlClientConnectWithDb != 0 {
+		buf.WriteString(resp.db + "\x00")
+	}
+	if caps&mysqlClientPluginAuth != 0 {
+		buf.WriteString(plugin + "\x00")
+	}
+	if err = target.writePacket(buf.Bytes()); err != nil {
+		return
+	}
+
+	for {
+		if p, err = target.readPacket(); err != nil {
+			return
+		}
+		if len(p) == 0 {
+			return fmt.Errorf("empty auth response")
+		}
+		switch p[0] {
+		case 0x00:
+			return
+		case 0xff:
+			return mysqlError(p)
+		case 0xfe:
+			r := bytes.NewBuffer(p[1:])
+			if plugin, err = readNulString(r); err != nil {
+				return
+			}
+			salt = bytes.TrimRight(r.Bytes(), "\x00")
+			if auth, err = scrambleMysql(plugin, []byte(password), salt); err != nil {
+				return
+			}
+			err = target.writePacket(auth)
+		case 0x01:
+			if plugin != mysqlCachingSha2 || len(p) < 2 {
+				return fmt.Errorf("unexpected auth data")
+			}
+			switch p[1] {
+			case 3:
+				// fast auth succeeded, ok packet follows
+			case 4:
+				// full auth without tls, password is encrypted by public key of server
+				err = target.writePacket([]byte{0x02})
+			default:
+				if auth, err = encryptMysqlPassword(p[1:], []byte(password), salt); err != nil {
+					return
+				}
+				err = target.writePacket(auth)
+			}
+		default:
+			return fmt.Errorf("unexpected auth response %d", p[0])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func scrambleMysql(plugin string, password, salt []byte) ([]byte, error) {
+	switch plugin {
+	case mysqlNativePassword:
+		return scrambleNative(password, salt), nil
+	case mysqlCachingSha2:
+		return scrambleSha2(password, salt), nil
+	default:
+		return nil, fmt.Errorf("unsupported auth plugin %s", plugin)
+	}
+}
+
+// scrambleNative is SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+func scrambleNative(password, salt []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+	h1 := sha1.Sum(password)
+	h2 := sha1.Sum(h1[:])
+	h3 := sha1.Sum(append(append([]byte(nil), salt...), h2[:]...))
+	for i := range h1 {
+		h1[i] ^= h3[i]
+	}
+	return h1[:]
+}
+
+// scrambleSha2 is SHA256(password) XOR SHA256(SHA256(SHA256(password)) + salt)
+func scrambleSha2(password, salt []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+	h1 := sha256.Sum256(password)
+	h2 := sha256.Sum256(h1[:])
+	h3 := sha256.Sum256(append(h2[:], salt...))
+	for i := range h1 {
+		h1[i] ^= h3[i]
+	}
+	return h1[:]
+}
+
+func encryptMysqlPassword(pemKey, password, salt []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key of server")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key of server")
+	}
+	plain := append(append([]byte(nil), password...), 0)
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, key, plain, nil)
+}
+
+func mysqlError(p []byte) error {
+	if len(p) < 3 {
+		return fmt.Errorf("mysql error")
+	}
+	msg := p[3:]
+	if len(msg) > 6 && msg[0] == '#' {
+		msg = msg[6:]
+	}
+	return fmt.Errorf("mysql error %d: %s", binary.LittleEndian.Uint16(p[1:]), msg)
+}
+
+func readNulString(r *bytes.Buffer) (string, error) {
+	s, err := r.ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("invalid string")
+	}
+	return s[:len(s)-1], nil
+}
+
+func readLenencInt(r *bytes.Buffer) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch b {
+	case 0xfc:
+		bs := r.Next(2)
+		if len(bs) < 2 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return uint64(binary.LittleEndian.Uint16(bs)), nil
+	case 0xfd:
+		bs := r.Next(3)
+		if len(bs) < 3 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return uint64(bs[0]) | uint64(bs[1])<<8 | uint64(bs[2])<<16, nil
+	case 0xfe:
+		bs := r.Next(8)
+		if len(bs) < 8 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return binary.LittleEndian.Uint64(bs), nil
+	}
+	return uint64(b), nil
+}
+
+func lenencInt(n uint64) []byte {
+	switch {
+	case n < 0xfb:
+		return []byte{byte(n)}
+	case n < 1<<16:
+		return []byte{0xfc, byte(n), byte(n >> 8)}
+	case n < 1<<24:
+		return []byte{0xfd, byte(n), byte(n >> 8), byte(n >> 16)}
+	}
+	bs := make([]byte, 9)
+	bs[0] = 0xfe
+	binary.LittleEndian.PutUint64(bs[1:], n)
+	return bs
+}
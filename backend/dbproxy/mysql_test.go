@@ -0,0 +1,230 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+// mysqlFrame frames payloads as packets from seq, payloads are not split
+func mysqlFrame(seq byte, payloads ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	for _, p := range payloads {
+		n := len(p)
+		buf.Write([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq})
+		buf.Write(p)
+		seq++
+	}
+	return buf.Bytes()
+}
+
+func TestMysqlReadPacket(t *testing.T) {
+	full := bytes.Repeat([]byte{'a'}, mysqlMaxPacket)
+	tests := []struct {
+		name      string
+		data      []byte
+		want      []byte
+		wantStart byte
+		wantSeq   byte
+		wantErr   bool
+	}{
+		{
+			name:    "query",
+			data:    mysqlFrame(0, []byte("\x03select 1")),
+			want:    []byte("\x03select 1"),
+			wantSeq: 1,
+		},
+		{
+			name:      "sequence of a response",
+			data:      mysqlFrame(2, []byte{0x00, 0x00, 0x00, 0x02, 0x00}),
+			want:      []byte{0x00, 0x00, 0x00, 0x02, 0x00},
+			wantStart: 2,
+			wantSeq:   3,
+		},
+		{
+			name:    "only the first packet",
+			data:    mysqlFrame(0, []byte("\x03select 1"), []byte("\x01")),
+			want:    []byte("\x03select 1"),
+			wantSeq: 1,
+		},
+		{
+			name:    "split into packets of max size",
+			data:    mysqlFrame(0, full, []byte("tail")),
+			want:    append(append([]byte{}, full...), "tail"...),
+			wantSeq: 2,
+		},
+		{
+			name:    "max size followed by an empty packet",
+			data:    mysqlFrame(0, full, nil),
+			want:    full,
+			wantSeq: 2,
+		},
+		{
+			name:    "empty",
+			data:    mysqlFrame(0, nil),
+			want:    nil,
+			wantSeq: 1,
+		},
+		{
+			name:    "truncated header",
+			data:    []byte{0x05, 0x00},
+			wantErr: true,
+		},
+		{
+			name:    "truncated payload",
+			data:    mysqlFrame(0, []byte("\x03select 1"))[:8],
+			wantErr: true,
+		},
+		{
+			name:    "missing packet after max size",
+			data:    mysqlFrame(0, full),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &mysqlConn{reader: bufio.NewReader(bytes.NewReader(tt.data))}
+			got, err := c.readPacket()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readPacket() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("readPacket() = %d bytes %.16q, want %d bytes %.16q", len(got), got, len(tt.want), tt.want)
+			}
+			if c.start != tt.wantStart || c.seq != tt.wantSeq {
+				t.Errorf("readPacket() start, seq = %d, %d, want %d, %d", c.start, c.seq, tt.wantStart, tt.wantSeq)
+			}
+		})
+	}
+}
+
+func TestMysqlReadPacketOversized(t *testing.T) {
+	defer func(n int) { mysqlMaxPayload = n }(mysqlMaxPayload)
+	mysqlMaxPayload = mysqlMaxPacket + 3
+
+	full := bytes.Repeat([]byte{'a'}, mysqlMaxPacket)
+	c := &mysqlConn{reader: bufio.NewReader(bytes.NewReader(mysqlFrame(0, full, []byte("abc"))))}
+	if _, err := c.readPacket(); err != nil {
+		t.Errorf("readPacket() of the max error = %v", err)
+	}
+	c = &mysqlConn{reader: bufio.NewReader(bytes.NewReader(mysqlFrame(0, full, []byte("abcd"))))}
+	if _, err := c.readPacket(); err == nil {
+		t.Errorf("readPacket() over the max error = nil, want an error")
+	}
+}
+
+func TestMysqlWritePacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		wantSeq byte
+	}{
+		{
+			name:    "small",
+			payload: []byte("\x03select 1"),
+			wantSeq: 1,
+		},
+		{
+			name:    "empty",
+			payload: []byte{},
+			wantSeq: 1,
+		},
+		{
+			name:    "split",
+			payload: bytes.Repeat([]byte{'a'}, mysqlMaxPacket+10),
+			wantSeq: 2,
+		},
+		{
+			name:    "max size",
+			payload: bytes.Repeat([]byte{'a'}, mysqlMaxPacket),
+			wantSeq: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			w := newMysqlConn(client)
+			errCh := make(chan error, 1)
+			go func() { errCh <- w.writePacket(tt.payload) }()
+
+			r := newMysqlConn(server)
+			got, err := r.readPacket()
+			if err != nil {
+				t.Fatalf("readPacket() error = %v", err)
+			}
+			if err = <-errCh; err != nil {
+				t.Fatalf("writePacket() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.payload) {
+				t.Errorf("writePacket() wrote %d bytes, want %d", len(got), len(tt.payload))
+			}
+			if w.seq != tt.wantSeq || r.seq != tt.wantSeq {
+				t.Errorf("seq of writer, reader = %d, %d, want %d", w.seq, r.seq, tt.wantSeq)
+			}
+		})
+	}
+}
+
+func TestMysqlStmt(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    string
+		wantOk  bool
+	}{
+		{
+			name:    "query",
+			payload: []byte("\x03select * from t where a = 'b'"),
+			want:    "select * from t where a = 'b'",
+			wantOk:  true,
+		},
+		{
+			name:    "prepare",
+			payload: []byte("\x16delete from t where id = ?"),
+			want:    "delete from t where id = ?",
+			wantOk:  true,
+		},
+		{
+			name:    "multi-byte utf-8",
+			payload: []byte("\x03select '你好'"),
+			want:    "select '你好'",
+			wantOk:  true,
+		},
+		{
+			name:    "empty query",
+			payload: []byte{mysqlComQuery},
+			want:    "",
+			wantOk:  true,
+		},
+		{
+			name:    "quit",
+			payload: []byte{mysqlComQuit},
+		},
+		{
+			name:    "ping",
+			payload: []byte{0x0e},
+		},
+		{
+			name:    "init db",
+			payload: []byte("\x02mysql"),
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mysqlStmt(tt.payload)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("mysqlStmt() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/pbkdf2"
+
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/util"
+)
+
+// messages of postgres protocol
+//
+//	https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	pgProtocol3    = 196608
+	pgSslRequest   = 80877103
+	pgGssRequest   = 80877104
+	pgCancelReq    = 80877102
+	pgMaxMessage   = 1 << 30
+	pgMaxStartup   = 10000
+	pgAuthOk       = 0
+	pgAuthClear    = 3
+	pgAuthMd5      = 5
+	pgAuthSasl     = 10
+	pgAuthSaslCont = 11
+	pgAuthSaslFin  = 12
+	pgScramSha256  = "SCRAM-SHA-256"
+)
+
+var (
+	// pgCancelKeys maps backend keys of target to sessions, cancel requests come from new connections
+	pgCancelKeys = &sync.Map{}
+)
+
+type pgConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mtx    sync.Mutex
+}
+
+func newPgConn(conn net.Conn) *pgConn {
+	return &pgConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// readStartup reads a message without type which is sent at first by client
+func (c *pgConn) readStartup() (code uint32, payload []byte, err error) {
+	head := make([]byte, 8)
+	if _, err = io.ReadFull(c.reader, head); err != nil {
+		return
+	}
+	n := binary.BigEndian.Uint32(head)
+	if n < 8 || n > pgMaxStartup {
+		return 0, nil, fmt.Errorf("invalid startup message length %d", n)
+	}
+	payload = make([]byte, n-8)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	return binary.BigEndian.Uint32(head[4:]), payload, nil
+}
+
+func (c *pgConn) readMessage() (typ byte, payload []byte, err error) {
+	head := make([]byte, 5)
+	if _, err = io.ReadFull(c.reader, head); err != nil {
+		return
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if n < 4 || n > pgMaxMessage {
+		return 0, nil, fmt.Errorf("invalid message length %d", n)
+	}
+	payload = make([]byte, n-4)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	return head[0], payload, nil
+}
+
+func (c *pgConn) writeMessage(typ byte, payload []byte) error {
+	p := make([]byte, 5, 5+len(payload))
+	p[0] = typ
+	binary.BigEndian.PutUint32(p[1:], uint32(len(payload)+4))
+	_, err := c.Write(append(p, payload...))
+	return err
+}
+
+func (c *pgConn) writeStartup(code uint32, payload []byte) error {
+	p := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(p, uint32(len(payload)+8))
+	binary.BigEndian.PutUint32(p[4:], code)
+	_, err := c.Write(append(p, payload...))
+	return err
+}
+
+func (c *pgConn) writeAuth(code uint32, data []byte) error {
+	return c.writeMessage('R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+}
+
+func (c *pgConn) writeErr(severity, code, msg string) error {
+	buf := &bytes.Buffer{}
+	for _, f := range [][2]string{{"S", severity}, {"V", severity}, {"C", code}, {"M", msg}} {
+		buf.WriteString(f[0] + f[1] + "\x00")
+	}
+	buf.WriteByte(0)
+	return c.writeMessage('E', buf.Bytes())
+}
+
+// Write makes conn a writer of relayed messages, it shares the lock with writeMessage
+func (c *pgConn) Write(p []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.conn.Write(p)
+}
+
+func handlePostgres(conn net.Conn) {
+	defer conn.Close()
+	client := newPgConn(conn)
+
+	c, target, err := pgAccept(client)
+	if err != nil {
+		logger.L().Warn("postgres proxy login failed", zap.String("client", conn.RemoteAddr().String()), zap.Error(err))
+		saveAccessLog("postgres", conn, c, err)
+		return
+	}
+	if c == nil {
+		return
+	}
+	defer target.conn.Close()
+	c.Online()
+	defer c.Offline()
+
+	go func() {
+		select {
+		case closer := <-c.Sess.Chans.CloseChan:
+			logger.L().Info("closed by", zap.String("admin", closer))
+		case <-c.Sess.Chans.AwayChan:
+		}
+		conn.Close()
+		target.conn.Close()
+	}()
+	go func() {
+		io.Copy(client, target.reader)
+		conn.Close()
+	}()
+
+	for {
+		typ, payload, err := client.readMessage()
+		if err != nil {
+			return
+		}
+		if typ == 'X' {
+			target.writeMessage(typ, payload)
+			return
+		}
+		if stmt, ok := pgStmt(typ, payload); ok {
+			if reason, blocked := c.Check(stmt); blocked {
+				c.Audit(stmt, reason)
+				payload = pgBlock(typ, payload, reason)
+			} else {
+				c.Audit(stmt, "")
+			}
+		}
+		if err = target.writeMessage(typ, payload); err != nil {
+			return
+		}
+	}
+}
+
+// pgStmt returns the statement of simple queries and parses of extended queries, other messages have none
+func pgStmt(typ byte, payload []byte) (stmt string, ok bool) {
+	switch typ {
+	case 'Q':
+		return string(bytes.TrimRight(payload, "\x00")), true
+	case 'P':
+		_, rest, _ := bytes.Cut(payload, []byte{0})
+		query, _, _ := bytes.Cut(rest, []byte{0})
+		return string(query), true
+	}
+	return
+}
+
+// pgBlock replaces the statement of the query or parse by raising reason
+func pgBlock(typ byte, payload []byte, reason string) []byte {
+	if typ == 'Q' {
+		return append([]byte(pgRaise(reason)), 0)
+	}
+	name, _, _ := bytes.Cut(payload, []byte{0})
+	// parameter types are dropped since the replacement has no parameter
+	return append(append(append(append([]byte{}, name...), 0), pgRaise(reason)...), 0, 0, 0)
+}
+
+// pgRaise is the replacement of blocked statements, errors raised by target keep the order of responses
+// and the state of transaction as they should be
+func pgRaise(reason string) string {
+	return fmt.Sprintf("DO $oneterm$BEGIN RAISE EXCEPTION USING MESSAGE = '%s', ERRCODE = '42501'; END$oneterm$",
+		strings.ReplaceAll(strings.ReplaceAll(reason, "'", "''"), "$oneterm$", ""))
+}
+
+// pgAccept authenticates client by token with md5 and logs in target with the account, c is nil for cancel requests
+func pgAccept(client *pgConn) (c *Conn, target *pgConn, err error) {
+	var code uint32
+	var payload []byte
+	for {
+		if code, payload, err = client.readStartup(); err != nil {
+			return
+		}
+		switch code {
+		case pgSslRequest, pgGssRequest:
+			if _, err = client.Write([]byte{'N'}); err != nil {
+				return
+			}
+			continue
+		case pgCancelReq:
+			err = pgCancel(payload)
+			return
+		case pgProtocol3:
+		default:
+			client.writeErr("FATAL", "0A000", fmt.Sprintf("unsupported frontend protocol %d.%d", code>>16, code&0xffff))
+			return nil, nil, fmt.Errorf("unsupported protocol %d", code)
+		}
+		break
+	}
+
+	params := pgParams(payload)
+	user := params["user"]
+	salt := make([]byte, 4)
+	rand.Read(salt)
+	if err = client.writeAuth(pgAuthMd5, salt); err != nil {
+		return
+	}
+	typ, pwd, err := client.readMessage()
+	if err != nil {
+		return
+	}
+	if typ != 'p' {
+		return nil, nil, fmt.Errorf("expected password message, got %q", typ)
+	}
+
+	t, err := getToken(user, "postgres")
+	if err == nil && subtle.ConstantTimeCompare(bytes.TrimRight(pwd, "\x00"), []byte(pgMd5(t.Password, user, salt))) != 1 {
+		err = fmt.Errorf("%w: wrong password", errToken)
+	}
+	if err != nil {
+		client.writeErr("FATAL", "28P01", fmt.Sprintf("password authentication failed for user \"%s\": %s", user, err))
+		return
+	}
+
+	if c, err = newConn(t, util.IpFromNetAddr(client.conn.RemoteAddr())); err != nil {
+		client.writeErr("FATAL", "28000", err.Error())
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Abort()
+		}
+	}()
+	conn, err := c.Dial("postgres")
+	if err != nil {
+		client.writeErr("FATAL", "08001", err.Error())
+		return
+	}
+	target = newPgConn(conn)
+	params["user"] = c.Account.Account
+	if err = pgLogin(target, params, c.Account.Password); err != nil {
+		conn.Close()
+		client.writeErr("FATAL", "28000", err.Error())
+		return
+	}
+	if err = client.writeAuth(pgAuthOk, nil); err != nil {
+		return
+	}
+
+	// parameters, backend key and ready for query are relayed, key is kept for cancel requests
+	for {
+		typ, payload, err = target.readMessage()
+		if err != nil {
+			return
+		}
+		if typ == 'K' && len(payload) >= 8 {
+			key := string(payload[:8])
+			pgCancelKeys.Store(key, c)
+			go func() {
+				<-c.Sess.Chans.AwayChan
+				pgCancelKeys.Delete(key)
+			}()
+		}
+		if err = client.writeMessage(typ, payload); err != nil {
+			return
+		}
+		switch typ {
+		case 'E':
+			conn.Close()
+			return c, nil, pgError(payload)
+		case 'Z':
+			return
+		}
+	}
+}
+
+// pgCancel forwards cancel request to the target of the session, gateway tunnels accept only one connection,
+// so a new one is opened for it
+func pgCancel(payload []byte) (err error) {
+	v, ok := pgCancelKeys.Load(string(payload))
+	if !ok {
+		return
+	}
+	c := v.(*Conn)
+	tunnelId := fmt.Sprintf("%s-cancel-%d", c.Sess.SessionId, time.Now().UnixNano())
+	defer ggateway.GetGatewayManager().Close(tunnelId)
+	ip, port, err := util.Proxy(false, tunnelId, "postgres", c.Asset, c.Gateway)
+	if err != nil {
+		return
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), time.Second*3)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	return newPgConn(conn).writeStartup(pgCancelReq, payload)
+}
+
+func pgParams(payload []byte) map[string]string {
+	params := map[string]string{}
+	fields := bytes.Split(bytes.TrimRight(payload, "\x00"), []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		params[string(fields[i])] = string(fields[i+1])
+	}
+	return params
+}
+
+// pgLogin logs in target on behalf of client with cleartext, md5 or scram-sha-256
+func pgLogin(target *pgConn, params map[string]string, password string) (err error) {
+	buf := &bytes.Buffer{}
+	for k, v := range params {
+		buf.WriteString(k + "\x00" + v + "\x00")
+	}
+	buf.WriteByte(0)
+	if err = target.writeStartup(pgProtocol3, buf.Bytes()); err != nil {
+		return
+	}
+
+	var scram *pgScram
+	for {
+		typ, p, err := target.readMessage()
+		if err != nil {
+			return err
+		}
+		if typ == 'E' {
+			return pgError(p)
+		}
+		if typ != 'R' || len(p) < 4 {
+			return fmt.Errorf("unexpected message %q during authentication", typ)
+		}
+		code, data := binary.BigEndian.Uint32(p), p[4:]
+		switch code {
+		case pgAuthOk:
+			return nil
+		case pgAuthClear:
+			err = target.writeMessage('p', append([]byte(password), 0))
+		case pgAuthMd5:
+			if len(data) < 4 {
+				return fmt.Errorf("invalid md5 salt")
+			}
+			err = target.writeMessage('p', append([]byte(pgMd5(password, params["user"], data[:4])), 0))
+		case pgAuthSasl:
+			if !bytes.Contains(data, []byte(pgScramSha256+"\x00")) {
+				return fmt.Errorf("no supported sasl mechanism")
+			}
+			scram = newPgScram(password)
+			first := scram.first()
+			msg := append([]byte(pgScramSha256+"\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(first)))...)
+			err = target.writeMessage('p', append(msg, first...))
+		case pgAuthSaslCont:
+			if scram == nil {
+				return fmt.Errorf("unexpected sasl continue")
+			}
+			final, e := scram.final(data)
+			if e != nil {
+				return e
+			}
+			err = target.writeMessage('p', final)
+		case pgAuthSaslFin:
+			if scram == nil || !scram.verify(data) {
+				return fmt.Errorf("invalid server signature")
+			}
+		default:
+			return fmt.Errorf("unsupported authentication %d", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func pgMd5(password, user string, salt []byte) string {
+	h := md5.Sum([]byte(password + user))
+	h = md5.Sum(append([]byte(hex.EncodeToString(h[:])), salt...))
+	return "md5" + hex.EncodeToString(h[:])
+}
+
+// pgError returns the message of ErrorResponse
+func pgError(p []byte) error {
+	for _, f := range bytes.Split(p, []byte{0}) {
+		if len(f) > 1 && f[0] == 'M' {
+			return fmt.Errorf("%s", f[1:])
+		}
+	}
+	return fmt.Errorf("unknown error")
+}
+
+// pgScram is the client of scram-sha-256 without channel binding
+//
+//	https://datatracker.ietf.org/doc/html/rfc5802
+type pgScram struct {
+	password    string
+	clientNonce string
+	authMessage string
+	salted      []byte
+}
+
+func newPgScram(password string) *pgScram {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	return &pgScram{password: password, clientNonce: base64.StdEncoding.EncodeToString(nonce)}
+}
+
+func (s *pgScram) first() []byte {
+	return []byte("n,,n=,r=" + s.clientNonce)
+}
+
+func (s *pgScram) final(serverFirst []byte) ([]byte, error) {
+	var nonce, salt string
+	iter := 0
+	for _, kv := range strings.Split(string(serverFirst), ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "r":
+			nonce = v
+		case "s":
+			salt = v
+		case "i":
+			fmt.Sscan(v, &iter)
+		}
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || !strings.HasPrefix(nonce, s.clientNonce) || iter <= 0 {
+		return nil, fmt.Errorf("invalid sasl server first message")
+	}
+	s.salted = pbkdf2.Key([]byte(s.password), saltBytes, iter, sha256.Size, sha256.New)
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = "n=,r=" + s.clientNonce + "," + string(serverFirst) + "," + withoutProof
+
+	clientKey := pgHmac(s.salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := pgHmac(storedKey[:], s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (s *pgScram) verify(serverFinal []byte) bool {
+	v, ok := strings.CutPrefix(string(serverFinal), "v=")
+	if !ok {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(v)
+	return err == nil && hmac.Equal(sig, pgHmac(pgHmac(s.salted, "Server Key"), s.authMessage))
+}
+
+func pgHmac(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
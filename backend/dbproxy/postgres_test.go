@@ -0,0 +1,260 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+// pgFrame frames payload as a message of typ
+func pgFrame(typ byte, payload []byte) []byte {
+	p := binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(payload)+4))
+	return append(p, payload...)
+}
+
+func TestPgReadMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		wantTyp     byte
+		wantPayload []byte
+		wantErr     bool
+	}{
+		{
+			name:        "query",
+			data:        pgFrame('Q', []byte("select 1\x00")),
+			wantTyp:     'Q',
+			wantPayload: []byte("select 1\x00"),
+		},
+		{
+			name:        "only the first message",
+			data:        append(pgFrame('S', nil), pgFrame('X', nil)...),
+			wantTyp:     'S',
+			wantPayload: []byte{},
+		},
+		{
+			name:    "length under 4",
+			data:    []byte{'Q', 0, 0, 0, 3},
+			wantErr: true,
+		},
+		{
+			name:    "length over the max",
+			data:    binary.BigEndian.AppendUint32([]byte{'Q'}, pgMaxMessage+1),
+			wantErr: true,
+		},
+		{
+			name:    "truncated header",
+			data:    []byte{'Q', 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "truncated payload",
+			data:    pgFrame('Q', []byte("select 1\x00"))[:9],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &pgConn{reader: bufio.NewReader(bytes.NewReader(tt.data))}
+			typ, payload, err := c.readMessage()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if typ != tt.wantTyp || !bytes.Equal(payload, tt.wantPayload) {
+				t.Errorf("readMessage() = %q, %q, want %q, %q", typ, payload, tt.wantTyp, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestPgReadMessageSplit(t *testing.T) {
+	data := append(pgFrame('Q', []byte("select 1\x00")), pgFrame('X', nil)...)
+	c := &pgConn{reader: bufio.NewReader(iotest.OneByteReader(bytes.NewReader(data)))}
+	for _, want := range []struct {
+		typ     byte
+		payload string
+	}{{'Q', "select 1\x00"}, {'X', ""}} {
+		typ, payload, err := c.readMessage()
+		if err != nil || typ != want.typ || string(payload) != want.payload {
+			t.Errorf("readMessage() = %q, %q, %v, want %q, %q", typ, payload, err, want.typ, want.payload)
+		}
+	}
+}
+
+func TestPgReadStartup(t *testing.T) {
+	startup := func(n, code uint32, payload []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, n), code), payload...)
+	}
+	params := []byte("user\x00alice\x00database\x00db\x00\x00")
+	tests := []struct {
+		name       string
+		data       []byte
+		wantCode   uint32
+		wantParams map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "protocol 3",
+			data:       startup(uint32(len(params)+8), pgProtocol3, params),
+			wantCode:   pgProtocol3,
+			wantParams: map[string]string{"user": "alice", "database": "db"},
+		},
+		{
+			name:       "ssl request",
+			data:       startup(8, pgSslRequest, nil),
+			wantCode:   pgSslRequest,
+			wantParams: map[string]string{},
+		},
+		{
+			name:    "length under 8",
+			data:    startup(7, pgProtocol3, nil),
+			wantErr: true,
+		},
+		{
+			name:    "length over the max",
+			data:    startup(pgMaxStartup+1, pgProtocol3, params),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			data:    startup(uint32(len(params)+8), pgProtocol3, params[:5]),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &pgConn{reader: bufio.NewReader(bytes.NewReader(tt.data))}
+			code, payload, err := c.readStartup()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readStartup() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if code != tt.wantCode {
+				t.Errorf("readStartup() code = %d, want %d", code, tt.wantCode)
+			}
+			got := pgParams(payload)
+			if len(got) != len(tt.wantParams) {
+				t.Errorf("pgParams() = %v, want %v", got, tt.wantParams)
+			}
+			for k, v := range tt.wantParams {
+				if got[k] != v {
+					t.Errorf("pgParams() = %v, want %v", got, tt.wantParams)
+				}
+			}
+		})
+	}
+}
+
+func TestPgWriteMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	payload := []byte("select 1\x00")
+	go newPgConn(client).writeMessage('Q', payload)
+
+	typ, got, err := newPgConn(server).readMessage()
+	if err != nil || typ != 'Q' || !bytes.Equal(got, payload) {
+		t.Errorf("readMessage() of writeMessage() = %q, %q, %v, want %q, %q", typ, got, err, 'Q', payload)
+	}
+}
+
+func TestPgStmt(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     byte
+		payload []byte
+		want    string
+		wantOk  bool
+	}{
+		{
+			name:    "simple query",
+			typ:     'Q',
+			payload: []byte("select 1; drop table t\x00"),
+			want:    "select 1; drop table t",
+			wantOk:  true,
+		},
+		{
+			name:    "parse of a named statement",
+			typ:     'P',
+			payload: []byte("s1\x00delete from t where id = $1\x00\x00\x01\x00\x00\x00\x17"),
+			want:    "delete from t where id = $1",
+			wantOk:  true,
+		},
+		{
+			name:    "parse of the unnamed statement",
+			typ:     'P',
+			payload: []byte("\x00select 1\x00\x00\x00"),
+			want:    "select 1",
+			wantOk:  true,
+		},
+		{
+			name:    "truncated parse",
+			typ:     'P',
+			payload: []byte("s1"),
+			want:    "",
+			wantOk:  true,
+		},
+		{
+			name:    "bind",
+			typ:     'B',
+			payload: []byte("\x00s1\x00\x00\x00\x00\x00\x00\x00"),
+		},
+		{
+			name:    "sync",
+			typ:     'S',
+			payload: []byte{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pgStmt(tt.typ, tt.payload)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("pgStmt() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestPgBlock(t *testing.T) {
+	reason := "it's forbidden"
+	tests := []struct {
+		name    string
+		typ     byte
+		payload []byte
+		want    []byte
+	}{
+		{
+			name:    "simple query",
+			typ:     'Q',
+			payload: []byte("drop table t\x00"),
+			want:    []byte(pgRaise(reason) + "\x00"),
+		},
+		{
+			name:    "parse keeps the name and drops parameter types",
+			typ:     'P',
+			payload: []byte("s1\x00delete from t where id = $1\x00\x00\x01\x00\x00\x00\x17"),
+			want:    []byte("s1\x00" + pgRaise(reason) + "\x00\x00\x00"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pgBlock(tt.typ, tt.payload, reason)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("pgBlock() = %q, want %q", got, tt.want)
+			}
+			if stmt, _ := pgStmt(tt.typ, got); stmt != pgRaise(reason) {
+				t.Errorf("pgStmt() of pgBlock() = %q, want %q", stmt, pgRaise(reason))
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/util"
+)
+
+// resp protocol
+//
+//	https://redis.io/docs/latest/develop/reference/protocol-spec/
+const (
+	redisMaxBulk = 512 * 1024 * 1024
+	// redisMaxAudit longer commands are truncated in audit, values of SET etc. could be very large
+	redisMaxAudit = 1024
+)
+
+var (
+	// redisRawCmds make replies not one per command any more, replies are relayed as they are after them
+	redisRawCmds = map[string]bool{
+		"SUBSCRIBE":  true,
+		"PSUBSCRIBE": true,
+		"SSUBSCRIBE": true,
+		"MONITOR":    true,
+	}
+)
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mtx    sync.Mutex
+}
+
+func newRedisConn(conn net.Conn) *redisConn {
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *redisConn) Write(p []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.conn.Write(p)
+}
+
+func (c *redisConn) writeErr(msg string) error {
+	_, err := c.Write([]byte("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n"))
+	return err
+}
+
+func (c *redisConn) writeCmd(args [][]byte) error {
+	_, err := c.Write(redisCmd(args))
+	return err
+}
+
+func (c *redisConn) readLine() (line []byte, err error) {
+	if line, err = c.reader.ReadSlice('\n'); err != nil {
+		return
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid resp line")
+	}
+	return append([]byte{}, line[:len(line)-2]...), nil
+}
+
+// readCmd reads a command of client, both arrays of bulk strings and inline commands are accepted
+func (c *redisConn) readCmd() (args [][]byte, err error) {
+	line, err := c.readLine()
+	if err != nil {
+		return
+	}
+	if len(line) == 0 || line[0] != '*' {
+		for _, f := range bytes.Fields(line) {
+			args = append(args, f)
+		}
+		return
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > 1024*1024 {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	for i := 0; i < n; i++ {
+		if line, err = c.readLine(); err != nil {
+			return
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got '%s'", line)
+		}
+		size, e := strconv.Atoi(string(line[1:]))
+		if e != nil || size < 0 || size > redisMaxBulk {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		p := make([]byte, size+2)
+		if _, err = io.ReadFull(c.reader, p); err != nil {
+			return
+		}
+		if !bytes.HasSuffix(p, []byte("\r\n")) {
+			return nil, fmt.Errorf("invalid bulk terminator")
+		}
+		args = append(args, p[:size])
+	}
+	return
+}
+
+// readReply reads one reply as it is, it works for both resp2 and resp3
+func (c *redisConn) readReply() (raw []byte, err error) {
+	line, err := c.readLine()
+	if err != nil {
+		return
+	}
+	raw = append(line, '\r', '\n')
+	if len(line) == 0 {
+		return nil, fmt.Errorf("invalid reply")
+	}
+	n := 0
+	switch line[0] {
+	case '+', '-', ':', '_', '#', ',', '(':
+		return
+	case '$', '!', '=':
+		if n, err = strconv.Atoi(string(line[1:])); err != nil || n < 0 {
+			return
+		}
+		p := make([]byte, n+2)
+		if _, err = io.ReadFull(c.reader, p); err != nil {
+			return
+		}
+		return append(raw, p...), nil
+	case '*', '~', '>':
+		n, err = strconv.Atoi(string(line[1:]))
+	case '%', '|':
+		n, err = strconv.Atoi(string(line[1:]))
+		n *= 2
+	default:
+		return nil, fmt.Errorf("invalid reply type %q", line[0])
+	}
+	if err != nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		p, e := c.readReply()
+		if e != nil {
+			return nil, e
+		}
+		raw = append(raw, p...)
+	}
+	// attributes are followed by the actual reply
+	if line[0] == '|' {
+		p, e := c.readReply()
+		if e != nil {
+			return nil, e
+		}
+		raw = append(raw, p...)
+	}
+	return
+}
+
+func redisCmd(args [][]byte) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(buf, "$%d\r\n", len(a))
+		buf.Write(a)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// redisStmt is the command in audit and policy checks, name of command is upper cased
+func redisStmt(args [][]byte) string {
+	ss := make([]string, len(args))
+	for i, a := range args {
+		ss[i] = string(a)
+		if strings.ContainsAny(ss[i], " \t\r\n\"") {
+			ss[i] = strconv.Quote(ss[i])
+		}
+	}
+	ss[0] = strings.ToUpper(ss[0])
+	s := strings.Join(ss, " ")
+	if len(s) > redisMaxAudit {
+		s = s[:redisMaxAudit] + "..."
+	}
+	return s
+}
+
+func handleRedis(conn net.Conn) {
+	defer conn.Close()
+	client := newRedisConn(conn)
+
+	c, target, err := redisAccept(client)
+	if err != nil {
+		logger.L().Warn("redis proxy login failed", zap.String("client", conn.RemoteAddr().String()), zap.Error(err))
+		saveAccessLog("redis", conn, c, err)
+		return
+	}
+	defer target.conn.Close()
+	c.Online()
+	defer c.Offline()
+
+	go func() {
+		select {
+		case closer := <-c.Sess.Chans.CloseChan:
+			logger.L().Info("closed by", zap.String("admin", closer))
+		case <-c.Sess.Chans.AwayChan:
+		}
+		conn.Close()
+		target.conn.Close()
+	}()
+
+	// replies must be in the order of commands, so rejections wait in queue for replies of commands before them,
+	// empty string means a reply of target, raw means relaying all the rest
+	queue := make(chan string, 1024)
+	const raw = "\x00raw"
+	go func() {
+		defer conn.Close()
+		for q := range queue {
+			if q == raw {
+				io.Copy(client, target.reader)
+				return
+			}
+			if q != "" {
+				if client.writeErr(q) != nil {
+					return
+				}
+				continue
+			}
+			for {
+				p, err := target.readReply()
+				if err != nil {
+					return
+				}
+				if _, err = client.Write(p); err != nil {
+					return
+				}
+				// pushes of resp3 are not replies of commands
+				if p[0] != '>' {
+					break
+				}
+			}
+		}
+	}()
+	defer close(queue)
+
+	isRaw := false
+	for {
+		args, err := client.readCmd()
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(string(args[0]))
+		stmt := redisStmt(args)
+		reason, blocked := c.Check(stmt)
+		switch {
+		case name == "AUTH" || name == "HELLO" && lo.ContainsBy(args[1:], func(a []byte) bool { return strings.EqualFold(string(a), "AUTH") }):
+			// the connection is bound to the account, it must not be switched
+			stmt, reason, blocked = name+" ***", "ERR authentication is not allowed through proxy", true
+		case blocked:
+			reason = "NOPERM " + reason
+		}
+		c.Audit(stmt, lo.Ternary(blocked, reason, ""))
+		if blocked {
+			if isRaw {
+				client.writeErr(reason)
+			} else {
+				queue <- reason
+			}
+			continue
+		}
+		if err = target.writeCmd(args); err != nil {
+			return
+		}
+		if !isRaw {
+			isRaw = redisRawCmds[name]
+			queue <- lo.Ternary(isRaw, raw, "")
+		}
+	}
+}
+
+// redisAccept authenticates client by token with AUTH or HELLO and logs in target with the account
+func redisAccept(client *redisConn) (c *Conn, target *redisConn, err error) {
+	for {
+		args, e := client.readCmd()
+		if e != nil {
+			return nil, nil, e
+		}
+		if len(args) == 0 {
+			continue
+		}
+		var user, password string
+		var hello [][]byte
+		switch strings.ToUpper(string(args[0])) {
+		case "AUTH":
+			switch len(args) {
+			case 2:
+				// clients only sending password could use user:password
+				user, password, _ = strings.Cut(string(args[1]), ":")
+			case 3:
+				user, password = string(args[1]), string(args[2])
+			default:
+				client.writeErr("ERR wrong number of arguments for 'auth' command")
+				continue
+			}
+		case "HELLO":
+			for i := 1; i < len(args); i++ {
+				if strings.ToUpper(string(args[i])) == "AUTH" && i+2 < len(args) {
+					user, password = string(args[i+1]), string(args[i+2])
+					hello = append(append(hello, args[:i]...), args[i+3:]...)
+					break
+				}
+			}
+			if hello == nil {
+				client.writeErr("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+				continue
+			}
+		case "QUIT":
+			client.Write([]byte("+OK\r\n"))
+			return nil, nil, fmt.Errorf("quit before authentication")
+		default:
+			client.writeErr("NOAUTH Authentication required.")
+			continue
+		}
+
+		t, e := getToken(user, "redis")
+		if e == nil && subtle.ConstantTimeCompare([]byte(password), []byte(t.Password)) != 1 {
+			e = fmt.Errorf("%w: wrong password", errToken)
+		}
+		if e != nil {
+			client.writeErr("WRONGPASS invalid username-password pair or user is disabled.")
+			return nil, nil, e
+		}
+		return redisConnect(client, t, hello)
+	}
+}
+
+func redisConnect(client *redisConn, t *Token, hello [][]byte) (c *Conn, target *redisConn, err error) {
+	if c, err = newConn(t, util.IpFromNetAddr(client.conn.RemoteAddr())); err != nil {
+		client.writeErr("ERR " + err.Error())
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Abort()
+		}
+	}()
+	conn, err := c.Dial("redis")
+	if err != nil {
+		client.writeErr("ERR " + err.Error())
+		return
+	}
+	target = newRedisConn(conn)
+	if err = redisLogin(target, c.Account.Account, c.Account.Password); err != nil {
+		conn.Close()
+		client.writeErr("ERR " + err.Error())
+		return
+	}
+
+	if hello == nil {
+		_, err = client.Write([]byte("+OK\r\n"))
+		return
+	}
+	var p []byte
+	if err = target.writeCmd(hello); err == nil {
+		if p, err = target.readReply(); err == nil {
+			_, err = client.Write(p)
+		}
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return
+}
+
+// redisLogin authenticates with acl user if account is not the default one, otherwise with requirepass
+func redisLogin(target *redisConn, user, password string) (err error) {
+	if password == "" {
+		return
+	}
+	args := [][]byte{[]byte("AUTH"), []byte(password)}
+	if user != "" && user != "default" {
+		args = [][]byte{[]byte("AUTH"), []byte(user), []byte(password)}
+	}
+	if err = target.writeCmd(args); err != nil {
+		return
+	}
+	p, err := target.readReply()
+	if err != nil {
+		return
+	}
+	if p[0] == '-' {
+		return fmt.Errorf("%s", bytes.TrimSpace(p[1:]))
+	}
+	return
+}
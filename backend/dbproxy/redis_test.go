@@ -0,0 +1,199 @@
+package dbproxy
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRedisReadCmd(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "multibulk",
+			data: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nvalue\r\n",
+			want: []string{"SET", "k", "value"},
+		},
+		{
+			name: "binary bulk with crlf",
+			data: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n",
+			want: []string{"SET", "k", "a\r\nb"},
+		},
+		{
+			name: "empty bulk",
+			data: "*2\r\n$3\r\nGET\r\n$0\r\n\r\n",
+			want: []string{"GET", ""},
+		},
+		{
+			name: "only the first command",
+			data: "*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nQUIT\r\n",
+			want: []string{"PING"},
+		},
+		{
+			name: "inline",
+			data: "GET  key\r\n",
+			want: []string{"GET", "key"},
+		},
+		{
+			name: "empty inline",
+			data: "\r\n",
+		},
+		{
+			name:    "line without cr",
+			data:    "PING\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad multibulk length",
+			data:    "*x\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "multibulk length over the max",
+			data:    "*1048577\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing $",
+			data:    "*1\r\n:1\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative bulk length",
+			data:    "*1\r\n$-1\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bulk length over the max",
+			data:    "*1\r\n$536870913\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bulk longer than its length",
+			data:    "*1\r\n$3\r\nPING\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "truncated bulk",
+			data:    "*2\r\n$3\r\nGET\r\n$3\r\nke",
+			wantErr: true,
+		},
+		{
+			name:    "truncated multibulk",
+			data:    "*2\r\n$3\r\nGET\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &redisConn{reader: bufio.NewReader(strings.NewReader(tt.data))}
+			got, err := c.readCmd()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readCmd() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !equalArgs(got, tt.want) {
+				t.Errorf("readCmd() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisReadCmdSplit(t *testing.T) {
+	data := "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\nPING\r\n"
+	c := &redisConn{reader: bufio.NewReader(iotest.OneByteReader(strings.NewReader(data)))}
+	for _, want := range [][]string{{"GET", "key"}, {"PING"}} {
+		if got, err := c.readCmd(); err != nil || !equalArgs(got, want) {
+			t.Errorf("readCmd() = %q, %v, want %q", got, err, want)
+		}
+	}
+}
+
+func TestRedisReadReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "simple string", data: "+OK\r\n", want: "+OK\r\n"},
+		{name: "error", data: "-ERR wrong\r\n", want: "-ERR wrong\r\n"},
+		{name: "integer", data: ":1\r\n", want: ":1\r\n"},
+		{name: "bulk", data: "$5\r\nhello\r\n+OK\r\n", want: "$5\r\nhello\r\n"},
+		{name: "null bulk", data: "$-1\r\n", want: "$-1\r\n"},
+		{name: "null array", data: "*-1\r\n", want: "*-1\r\n"},
+		{name: "nested array", data: "*2\r\n*1\r\n:1\r\n$1\r\na\r\n", want: "*2\r\n*1\r\n:1\r\n$1\r\na\r\n"},
+		{name: "map of resp3", data: "%1\r\n+k\r\n:1\r\n", want: "%1\r\n+k\r\n:1\r\n"},
+		{name: "push of resp3", data: ">2\r\n+message\r\n+hi\r\n", want: ">2\r\n+message\r\n+hi\r\n"},
+		{name: "attribute followed by the reply", data: "|1\r\n+ttl\r\n:3\r\n+OK\r\n", want: "|1\r\n+ttl\r\n:3\r\n+OK\r\n"},
+		{name: "truncated bulk", data: "$5\r\nhel", wantErr: true},
+		{name: "truncated array", data: "*2\r\n:1\r\n", wantErr: true},
+		{name: "unknown type", data: "?1\r\n", wantErr: true},
+		{name: "empty line", data: "\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &redisConn{reader: bufio.NewReader(strings.NewReader(tt.data))}
+			got, err := c.readReply()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readReply() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("readReply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisCmd(t *testing.T) {
+	args := [][]byte{[]byte("SET"), []byte("k"), []byte("a\r\nb")}
+	c := &redisConn{reader: bufio.NewReader(bytes.NewReader(redisCmd(args)))}
+	if got, err := c.readCmd(); err != nil || !equalArgs(got, []string{"SET", "k", "a\r\nb"}) {
+		t.Errorf("readCmd() of redisCmd() = %q, %v", got, err)
+	}
+}
+
+func TestRedisStmt(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "name upper cased", args: []string{"get", "Key"}, want: "GET Key"},
+		{name: "quoted", args: []string{"set", "k", "a b\r\n"}, want: `SET k "a b\r\n"`},
+		{name: "truncated", args: []string{"set", "k", strings.Repeat("v", redisMaxAudit)}, want: "SET k " + strings.Repeat("v", redisMaxAudit-6) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := make([][]byte, len(tt.args))
+			for i, a := range tt.args {
+				args[i] = []byte(a)
+			}
+			if got := redisStmt(args); got != tt.want {
+				t.Errorf("redisStmt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalArgs(args [][]byte, want []string) bool {
+	if len(args) != len(want) {
+		return false
+	}
+	for i := range args {
+		if string(args[i]) != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// maxHosts limits a scan to a /16 in total
+	maxHosts = 1 << 16
+)
+
+var (
+	running atomic.Bool
+	last    time.Time
+
+	// rdpProbe is a x.224 connection request with rdp negotiation of tls and credssp, servers answer it in a tpkt
+	//
+	//	https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr/18a27ef9-6f9a-4501-b000-94b1fe3c2c10
+	rdpProbe = []byte{0x03, 0x00, 0x00, 0x13, 0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x08, 0x00, 0x03, 0x00, 0x00, 0x00}
+)
+
+type found struct {
+	ip        string
+	protocols []string
+	banner    string
+}
+
+// ScanDue scans the configured cidrs if the interval has passed since the last scan
+func ScanDue() {
+	cfg := conf.Cfg.Discovery
+	if cfg.Interval <= 0 || len(cfg.Cidrs) == 0 || time.Since(last) < time.Hour*time.Duration(cfg.Interval) {
+		return
+	}
+	last = time.Now()
+	if err := Scan(cfg.Cidrs); err != nil {
+		logger.L().Warn("discovery scan failed", zap.Error(err))
+	}
+}
+
+// Scan probes ports of hosts in cidrs and saves the ones answering a known protocol as candidates,
+// hosts which are assets already are skipped. Only one scan runs at a time
+func Scan(cidrs []string) (err error) {
+	addrs, err := hosts(cidrs)
+	if err != nil {
+		return
+	}
+	if !running.CompareAndSwap(false, true) {
+		return fmt.Errorf("discovery scan is running")
+	}
+	defer running.Store(false)
+
+	existing := make([]string, 0)
+	if err = mysql.DB.Model(model.DefaultAsset).Pluck("ip", &existing).Error; err != nil {
+		return
+	}
+	addrs = lo.Without(addrs, existing...)
+
+	cfg := conf.Cfg.Discovery
+	timeout := time.Millisecond * time.Duration(max(cfg.Timeout, 100))
+	sem := make(chan struct{}, max(cfg.Concurrency, 1))
+	mtx, wg := sync.Mutex{}, sync.WaitGroup{}
+	results := map[string]*found{}
+	for _, ip := range addrs {
+		for _, port := range cfg.Ports {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(ip string, port int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				protocol, banner := probe(ip, port, timeout)
+				if protocol == "" {
+					return
+				}
+				mtx.Lock()
+				defer mtx.Unlock()
+				if results[ip] == nil {
+					results[ip] = &found{ip: ip}
+				}
+				results[ip].protocols = append(results[ip].protocols, fmt.Sprintf("%s:%d", protocol, port))
+				if banner != "" {
+					results[ip].banner = banner
+				}
+			}(ip, port)
+		}
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for _, f := range results {
+		d := &model.DiscoveredAsset{
+			Ip:        f.ip,
+			Hostname:  lookup(f.ip),
+			Protocols: f.protocols,
+			Banner:    f.banner,
+			Status:    model.DISCOVERYSTATUS_PENDING,
+			LastSeen:  now,
+		}
+		// status of reviewed ones is kept
+		if err := mysql.DB.
+			Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "ip"}},
+				DoUpdates: clause.AssignmentColumns([]string{"hostname", "protocols", "banner", "last_seen", "updated_at"}),
+			}).
+			Create(d).Error; err != nil {
+			logger.L().Warn("save discovered asset failed", zap.String("ip", f.ip), zap.Error(err))
+		}
+	}
+	logger.L().Info("discovery scan done", zap.Int("hosts", len(addrs)), zap.Int("found", len(results)), zap.Duration("cost", time.Since(now)))
+
+	return
+}
+
+// Running reports whether a scan is running
+func Running() bool {
+	return running.Load()
+}
+
+// hosts returns addresses of cidrs without network and broadcast addresses of ipv4 ones, single ips are accepted as well
+func hosts(cidrs []string) (addrs []string, err error) {
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip, err := netip.ParseAddr(c)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, ip.String())
+			continue
+		}
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, err
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().BitLen()-prefix.Bits() > 16 {
+			return nil, fmt.Errorf("%s is too large to scan", c)
+		}
+		ips := make([]string, 0)
+		for ip := prefix.Addr(); ip.IsValid() && prefix.Contains(ip); ip = ip.Next() {
+			ips = append(ips, ip.String())
+		}
+		if prefix.Addr().Is4() && prefix.Bits() < 31 {
+			ips = ips[1 : len(ips)-1]
+		}
+		if addrs = append(addrs, ips...); len(addrs) > maxHosts {
+			return nil, fmt.Errorf("too many hosts to scan, at most %d", maxHosts)
+		}
+	}
+	return lo.Uniq(addrs), nil
+}
+
+// probe detects the protocol by what the port answers, ssh and vnc servers speak first while rdp ones wait for a request
+func probe(ip string, port int, timeout time.Duration) (protocol, banner string) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 256)
+	conn.SetDeadline(time.Now().Add(timeout))
+	n, _ := conn.Read(buf)
+	line := strings.TrimSpace(strings.SplitN(string(buf[:n]), "\n", 2)[0])
+	switch {
+	case strings.HasPrefix(line, "SSH-"):
+		return "ssh", line
+	case strings.HasPrefix(line, "RFB "):
+		return "vnc", line
+	case n > 0:
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err = conn.Write(rdpProbe); err != nil {
+		return
+	}
+	if n, _ = conn.Read(buf); n >= 4 && buf[0] == 0x03 && buf[1] == 0x00 {
+		return "rdp", ""
+	}
+	return
+}
+
+func lookup(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
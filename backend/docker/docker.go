@@ -0,0 +1,241 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultCommand prefers bash and falls back to sh since images may have only one of them
+	DefaultCommand = []string{"sh", "-c", "command -v bash >/dev/null && exec bash || exec sh"}
+)
+
+// ParseTLS parses a pem bundle of ca certificates, client certificate and its key,
+// the certificate matching the key is the client one and others are ca
+func ParseTLS(bundle string) (cfg *tls.Config, err error) {
+	var certs [][]byte
+	var key []byte
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			certs = append(certs, pem.EncodeToMemory(block))
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			key = pem.EncodeToMemory(block)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no private key in bundle")
+	}
+
+	cfg = &tls.Config{RootCAs: x509.NewCertPool()}
+	for _, c := range certs {
+		if pair, e := tls.X509KeyPair(c, key); e == nil && cfg.Certificates == nil {
+			cfg.Certificates = []tls.Certificate{pair}
+			continue
+		}
+		cfg.RootCAs.AppendCertsFromPEM(c)
+	}
+	if cfg.Certificates == nil {
+		return nil, fmt.Errorf("no certificate matches the private key")
+	}
+	return
+}
+
+// Target is the container to exec in
+type Target struct {
+	Container string
+	User      string
+	Command   []string
+}
+
+// Client is a hijacked exec or attach stream with tty, stdout and stderr are not multiplexed with tty
+type Client struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	api         *api
+	execId      string
+	containerId string
+}
+
+// DialFunc returns a new connection to the engine, every call of api may need a new one
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+type api struct {
+	host string
+	tls  *tls.Config
+	cli  *http.Client
+}
+
+func newApi(host string, dial DialFunc, tlsCfg *tls.Config) *api {
+	a := &api{host: host, tls: tlsCfg}
+	a.cli = &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			DialContext:     func(ctx context.Context, _, _ string) (net.Conn, error) { return dial(ctx) },
+			TLSClientConfig: tlsCfg,
+		},
+	}
+	return a
+}
+
+func (a *api) url(path string, q url.Values) string {
+	u := url.URL{Scheme: "http", Host: a.host, Path: path, RawQuery: q.Encode()}
+	if a.tls != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+func (a *api) post(path string, q url.Values, body, res any) (err error) {
+	bs, _ := json.Marshal(body)
+	resp, err := a.cli.Post(a.url(path, q), "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return apiError(resp)
+	}
+	if res != nil {
+		err = json.NewDecoder(resp.Body).Decode(res)
+	}
+	return
+}
+
+func apiError(resp *http.Response) error {
+	msg := &struct {
+		Message string `json:"message"`
+	}{}
+	bs, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(bs, msg) != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(bs))
+	}
+	return fmt.Errorf("docker api %s: %s", resp.Status, msg.Message)
+}
+
+// Dial creates an exec of the container and starts it, host is the name of engine in certificate,
+// tlsCfg nil means plain http
+func Dial(dial DialFunc, host string, tlsCfg *tls.Config, target *Target, w, h int) (cli *Client, err error) {
+	if target.Container == "" {
+		return nil, fmt.Errorf("container is required")
+	}
+	cmd := target.Command
+	if len(cmd) == 0 {
+		cmd = DefaultCommand
+	}
+	if tlsCfg != nil {
+		tlsCfg = tlsCfg.Clone()
+		if tlsCfg.ServerName == "" {
+			tlsCfg.ServerName = host
+		}
+	}
+	a := newApi(host, dial, tlsCfg)
+
+	exec := &struct {
+		Id string `json:"Id"`
+	}{}
+	err = a.post(fmt.Sprintf("/containers/%s/exec", url.PathEscape(target.Container)), nil, map[string]any{
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          true,
+		"User":         target.User,
+		"Cmd":          cmd,
+		"Env":          []string{"TERM=xterm"},
+	}, exec)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	conn, err := dial(ctx)
+	if err != nil {
+		return
+	}
+	if tlsCfg != nil {
+		conn = tls.Client(conn, tlsCfg)
+	}
+	cli = &Client{conn: conn, reader: bufio.NewReader(conn), api: a, execId: exec.Id}
+	if err = cli.start(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if w > 0 && h > 0 {
+		cli.WindowChange(w, h)
+	}
+	return
+}
+
+// start upgrades the connection to the raw stream of exec
+func (c *Client) start() (err error) {
+	bs, _ := json.Marshal(map[string]bool{"Detach": false, "Tty": true})
+	req, err := http.NewRequest(http.MethodPost, c.api.url(fmt.Sprintf("/exec/%s/start", c.execId), nil), bytes.NewReader(bs))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.upgrade(req)
+}
+
+// upgrade hijacks the connection by req
+func (c *Client) upgrade(req *http.Request) (err error) {
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	c.conn.SetDeadline(time.Now().Add(time.Second * 10))
+	defer c.conn.SetDeadline(time.Time{})
+	if err = req.Write(c.conn); err != nil {
+		return
+	}
+	resp, err := http.ReadResponse(c.reader, req)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return apiError(resp)
+	}
+	return
+}
+
+func (c *Client) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *Client) Write(p []byte) (int, error) {
+	return c.conn.Write(p)
+}
+
+func (c *Client) WindowChange(w, h int) error {
+	path := fmt.Sprintf("/exec/%s/resize", c.execId)
+	if c.containerId != "" {
+		path = fmt.Sprintf("/containers/%s/resize", c.containerId)
+	}
+	return c.api.post(path, url.Values{"w": {fmt.Sprint(w)}, "h": {fmt.Sprint(h)}}, nil, nil)
+}
+
+// Close removes the container if it is run by the client
+func (c *Client) Close() error {
+	if c.containerId != "" {
+		c.api.remove(c.containerId)
+	}
+	c.api.cli.CloseIdleConnections()
+	return c.conn.Close()
+}
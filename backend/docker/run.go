@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Spec is an ephemeral container running a client tool, it is removed when the client is closed
+type Spec struct {
+	Image   string
+	Command []string
+	// Env carries credentials so that they are not shown in the process list
+	Env     []string
+	Network string
+	Labels  map[string]string
+}
+
+// DialEngine returns the dial func and the host of an engine address like unix:///var/run/docker.sock or tcp://host:2375
+func DialEngine(addr string) (dial DialFunc, host string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return
+	}
+	switch u.Scheme {
+	case "unix":
+		return func(ctx context.Context) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", u.Path)
+		}, "docker", nil
+	case "tcp":
+		return func(ctx context.Context) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", u.Host)
+		}, u.Host, nil
+	default:
+		return nil, "", fmt.Errorf("invalid docker engine %s", addr)
+	}
+}
+
+// Run creates a container of spec with tty, attaches to it and starts it
+func Run(dial DialFunc, host string, spec *Spec, w, h int) (cli *Client, err error) {
+	a := newApi(host, dial, nil)
+
+	container := &struct {
+		Id string `json:"Id"`
+	}{}
+	err = a.post("/containers/create", nil, map[string]any{
+		"Image":        spec.Image,
+		"Cmd":          spec.Command,
+		"Env":          append([]string{"TERM=xterm"}, spec.Env...),
+		"Labels":       spec.Labels,
+		"Tty":          true,
+		"OpenStdin":    true,
+		"StdinOnce":    true,
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"HostConfig": map[string]any{
+			"AutoRemove":  true,
+			"NetworkMode": spec.Network,
+		},
+	}, container)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			a.remove(container.Id)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	conn, err := dial(ctx)
+	if err != nil {
+		return
+	}
+	cli = &Client{conn: conn, reader: bufio.NewReader(conn), api: a, containerId: container.Id}
+	// output is lost if the container starts before it is attached
+	if err = cli.attach(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = a.post(fmt.Sprintf("/containers/%s/start", container.Id), nil, nil, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if w > 0 && h > 0 {
+		cli.WindowChange(w, h)
+	}
+	return
+}
+
+func (c *Client) attach() (err error) {
+	q := url.Values{"stream": {"1"}, "stdin": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	req, err := http.NewRequest(http.MethodPost, c.api.url(fmt.Sprintf("/containers/%s/attach", c.containerId), q), nil)
+	if err != nil {
+		return
+	}
+	return c.upgrade(req)
+}
+
+func (a *api) remove(containerId string) {
+	req, err := http.NewRequest(http.MethodDelete, a.url("/containers/"+containerId, url.Values{"force": {"1"}}), nil)
+	if err != nil {
+		return
+	}
+	resp, err := a.cli.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
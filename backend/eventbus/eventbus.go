@@ -0,0 +1,293 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// VERSION of the schema of events, it is raised only if fields are changed or removed, not added
+	VERSION = 1
+
+	// maxBackoff between retries of connecting the bus
+	maxBackoff = time.Minute
+
+	TYPE_SESSION_OPEN        = "session.open"
+	TYPE_SESSION_CLOSE       = "session.close"
+	TYPE_SESSION_ADMIN_CLOSE = "session.admin_close"
+	TYPE_COMMAND             = "command"
+	TYPE_COMMAND_BLOCKED     = "command.blocked"
+	TYPE_FILE_TRANSFER       = "file.transfer"
+	TYPE_CONNECT_FAILED      = "connect.failed"
+	TYPE_CANARY_FAILED       = "canary.failed"
+)
+
+var (
+	// topics are suffixes of the topic of the config by types of events
+	topics = map[string]string{
+		TYPE_SESSION_OPEN:        "session",
+		TYPE_SESSION_CLOSE:       "session",
+		TYPE_SESSION_ADMIN_CLOSE: "session",
+		TYPE_COMMAND:             "command",
+		TYPE_COMMAND_BLOCKED:     "command",
+		TYPE_FILE_TRANSFER:       "file",
+		TYPE_CONNECT_FAILED:      "session",
+		TYPE_CANARY_FAILED:       "canary",
+	}
+	// tables maps tables of the audit db to types of events of rows created, sessions are published by Publish
+	// since they are closed by updates
+	tables = map[string]string{
+		"session_cmd":   TYPE_COMMAND,
+		"file_transfer": TYPE_FILE_TRANSFER,
+		"access_log":    TYPE_CONNECT_FAILED,
+	}
+	publishers  = map[string]func(cfg conf.EventBusConfig) (Publisher, error){}
+	listeners   []func(*Event)
+	events      chan *Event
+	dropped     atomic.Int64
+	hostname, _ = os.Hostname()
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+// Event is the json published for each audit record, consumers tell them by type and take data by it:
+//
+//	session.open, session.close	model.Session, closed_at is set on closing
+//	session.admin_close			AdminClose, sessions closed by admins are closed as well later
+//	command						model.SessionCmd
+//	command.blocked				BlockedCommand, blocked commands are not run so they are not of command
+//	file.transfer				model.FileTransfer, sha256 is the hex digest of the file
+//	connect.failed				model.AccessLog, connects denied or failed have no sessions
+//	canary.failed				model.CanaryRun, synthetic sessions of canary targets failing in a row
+//
+// Events of a session share its session id, which is also the key of messages so that they stay in order
+type Event struct {
+	Id        string    `json:"id"`
+	Version   int       `json:"version"`
+	Type      string    `json:"type"`
+	Source    string    `json:"source"`
+	Time      time.Time `json:"time"`
+	SessionId string    `json:"session_id"`
+	Data      any       `json:"data"`
+}
+
+// AdminClose is a session closed by an admin or by closing things it belongs to, e.g. workspaces
+type AdminClose struct {
+	Session model.Session `json:"session"`
+	Closer  string        `json:"closer"`
+}
+
+// BlockedCommand is a command refused by command filters or policies, rule is the one refusing it
+type BlockedCommand struct {
+	SessionId string `json:"session_id"`
+	UserName  string `json:"user_name"`
+	Cmd       string `json:"cmd"`
+	Rule      string `json:"rule"`
+}
+
+// Message is an event encoded, the key is the session id
+type Message struct {
+	Key   string
+	Value []byte
+}
+
+// Publisher sends messages to a topic of the bus, they are sent in order
+type Publisher interface {
+	Publish(ctx context.Context, topic string, msgs []*Message) error
+	Close() error
+}
+
+// RegisterPublisher makes a bus available by its name of the driver of the config
+func RegisterPublisher(name string, fn func(cfg conf.EventBusConfig) (Publisher, error)) {
+	publishers[name] = fn
+}
+
+func init() {
+	// rows are captured even if no bus is configured since listeners like syslog take them as well
+	if err := mysql.AuditDB.Callback().Create().After("gorm:create").Register("eventbus:capture", capture); err != nil {
+		logger.L().Fatal("register eventbus capture failed", zap.Error(err))
+	}
+	if cfg := conf.Cfg.EventBus; cfg.Driver != "" {
+		events = make(chan *Event, cfg.BufferSize)
+	}
+}
+
+// Listen calls fn with every event published, it must be called in init and fn must not block
+func Listen(fn func(*Event)) {
+	listeners = append(listeners, fn)
+}
+
+// Publish queues an event of the type, it is dropped rather than blocking callers if the buffer is full
+func Publish(typ, sessionId string, data any) {
+	if events == nil && len(listeners) == 0 {
+		return
+	}
+	e := &Event{
+		Id:        uuid.NewString(),
+		Version:   VERSION,
+		Type:      typ,
+		Source:    hostname,
+		Time:      time.Now(),
+		SessionId: sessionId,
+		Data:      data,
+	}
+	for _, fn := range listeners {
+		fn(e)
+	}
+	if events == nil {
+		return
+	}
+	select {
+	case events <- e:
+	default:
+		dropped.Add(1)
+	}
+}
+
+// PublishAdminClose publishes the session closed by closer
+func PublishAdminClose(sess *model.Session, closer string) {
+	Publish(TYPE_SESSION_ADMIN_CLOSE, sess.SessionId, &AdminClose{Session: *sess, Closer: closer})
+}
+
+// PublishBlocked publishes the command blocked by the rule
+func PublishBlocked(sessionId, userName, cmd, rule string) {
+	Publish(TYPE_COMMAND_BLOCKED, sessionId, &BlockedCommand{SessionId: sessionId, UserName: userName, Cmd: cmd, Rule: rule})
+}
+
+// PublishSession publishes the session opening or closing by its status
+func PublishSession(sess *model.Session) {
+	state := *sess
+	typ := TYPE_SESSION_OPEN
+	if sess.Status == model.SESSIONSTATUS_OFFLINE {
+		typ = TYPE_SESSION_CLOSE
+	}
+	Publish(typ, sess.SessionId, &state)
+}
+
+// capture publishes created rows of tables of events
+func capture(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	typ, ok := tables[db.Statement.Schema.Table]
+	if !ok {
+		return
+	}
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	vs := []reflect.Value{rv}
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		vs = make([]reflect.Value, rv.Len())
+		for i := range vs {
+			vs[i] = rv.Index(i)
+		}
+	}
+	for _, v := range vs {
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+		switch data := v.Interface().(type) {
+		case *model.SessionCmd:
+			Publish(typ, data.SessionId, *data)
+		case *model.FileTransfer:
+			Publish(typ, data.SessionId, *data)
+		case *model.AccessLog:
+			Publish(typ, "", *data)
+		}
+	}
+}
+
+// Run publishes queued events in batches until it is stopped, it only waits if the bus is not configured or not
+// supported, and connecting the bus is retried until it succeeds
+func Run() (err error) {
+	cfg := conf.Cfg.EventBus
+	if cfg.Driver == "" {
+		<-ctx.Done()
+		return
+	}
+	fn, ok := publishers[cfg.Driver]
+	if !ok {
+		logger.L().Error("unsupported event bus driver, nothing is published", zap.String("driver", cfg.Driver))
+		<-ctx.Done()
+		return
+	}
+	// a bus down at startup must not stop others, events are buffered and dropped once full until it is connected
+	var p Publisher
+	for backoff := time.Second; ; backoff = min(backoff*2, maxBackoff) {
+		if p, err = fn(cfg); err == nil {
+			break
+		}
+		logger.L().Warn("connect event bus failed", zap.String("driver", cfg.Driver), zap.Duration("retryIn", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+	defer p.Close()
+
+	batches := map[string][]*Message{}
+	add := func(e *Event) string {
+		topic := cfg.Topic + "." + topics[e.Type]
+		bs, err := json.Marshal(e)
+		if err != nil {
+			return topic
+		}
+		batches[topic] = append(batches[topic], &Message{Key: e.SessionId, Value: bs})
+		return topic
+	}
+	flush := func(topic string) {
+		if len(batches[topic]) == 0 {
+			return
+		}
+		// events of a failed batch are lost, the audit db is still the source of truth
+		fctx, fcancel := context.WithTimeout(context.Background(), time.Minute)
+		defer fcancel()
+		if err := p.Publish(fctx, topic, batches[topic]); err != nil {
+			logger.L().Warn("publish events failed", zap.String("topic", topic), zap.Int("count", len(batches[topic])), zap.Error(err))
+		}
+		batches[topic] = batches[topic][:0]
+	}
+	flushAll := func() {
+		for topic := range batches {
+			flush(topic)
+		}
+		if n := dropped.Swap(0); n > 0 {
+			logger.L().Warn("event bus buffer is full, events are dropped", zap.Int64("count", n))
+		}
+	}
+
+	tk := time.NewTicker(time.Second * time.Duration(max(cfg.FlushInterval, 1)))
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for len(events) > 0 {
+				add(<-events)
+			}
+			flushAll()
+			return
+		case <-tk.C:
+			flushAll()
+		case e := <-events:
+			if topic := add(e); len(batches[topic]) >= cfg.BatchSize {
+				flush(topic)
+			}
+		}
+	}
+}
+
+func Stop() {
+	defer cancel()
+}
@@ -0,0 +1,79 @@
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/remote"
+)
+
+func init() {
+	RegisterPublisher("kafka", newKafka)
+}
+
+// kafka publishes by the rest proxy of kafka so that no client of the kafka protocol is needed
+//
+//	https://docs.confluent.io/platform/current/kafka-rest/api.html#records-v2
+type kafka struct {
+	url      string
+	user     string
+	password string
+}
+
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func newKafka(cfg conf.EventBusConfig) (Publisher, error) {
+	if cfg.Url == "" {
+		return nil, fmt.Errorf("url of the kafka rest proxy is empty")
+	}
+	return &kafka{url: strings.TrimSuffix(cfg.Url, "/"), user: cfg.User, password: cfg.Password}, nil
+}
+
+func (k *kafka) Publish(ctx context.Context, topic string, msgs []*Message) (err error) {
+	records := make([]*kafkaRecord, 0, len(msgs))
+	for _, m := range msgs {
+		records = append(records, &kafkaRecord{Key: m.Key, Value: m.Value})
+	}
+	req := remote.RC.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/vnd.kafka.json.v2+json").
+		SetHeader("Accept", "application/vnd.kafka.v2+json").
+		SetBody(map[string]any{"records": records}).
+		SetResult(&kafkaOffsets{})
+	if k.user != "" {
+		req = req.SetBasicAuth(k.user, k.password)
+	}
+	resp, err := req.Post(k.url + "/topics/" + url.PathEscape(topic))
+	if err != nil {
+		return
+	}
+	if resp.IsError() {
+		return fmt.Errorf("kafka rest proxy %s: %s", resp.Status(), bytes.TrimSpace(resp.Body()))
+	}
+	// records are taken one by one, the first failed one is returned
+	for _, o := range resp.Result().(*kafkaOffsets).Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("kafka error %d: %s", *o.ErrorCode, o.Error)
+		}
+	}
+	return
+}
+
+func (k *kafka) Close() error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veops/oneterm/conf"
+)
+
+const (
+	natsDialTimeout = time.Second * 5
+)
+
+func init() {
+	RegisterPublisher("nats", newNats)
+}
+
+// nats publishes by the core protocol of nats, a batch is confirmed by a ping answered after it so that errors of
+// the server are found. The connection is dialed again once it fails
+//
+//	https://docs.nats.io/reference/reference-protocols/nats-protocol
+type nats struct {
+	cfg  conf.EventBusConfig
+	addr string
+	mtx  sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newNats(cfg conf.EventBusConfig) (Publisher, error) {
+	u, err := url.Parse(cfg.Url)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid url of nats %q", cfg.Url)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &nats{cfg: cfg, addr: addr}, nil
+}
+
+func (n *nats) Publish(ctx context.Context, topic string, msgs []*Message) (err error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	// idle connections may be closed by the server, the batch is published once more on a new one
+	for i := 0; i < 2; i++ {
+		if err = n.publish(ctx, topic, msgs); err == nil {
+			return
+		}
+		n.close()
+	}
+	return
+}
+
+func (n *nats) publish(ctx context.Context, topic string, msgs []*Message) (err error) {
+	if n.conn == nil {
+		if err = n.connect(); err != nil {
+			return
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		n.conn.SetDeadline(deadline)
+	}
+	w := bufio.NewWriter(n.conn)
+	for _, m := range msgs {
+		fmt.Fprintf(w, "PUB %s %d\r\n", topic, len(m.Value))
+		w.Write(m.Value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err = w.Flush(); err != nil {
+		return
+	}
+	return n.pong()
+}
+
+// connect reads the info of the server and sends the connect of the client, pings of the server are answered later
+func (n *nats) connect() (err error) {
+	conn, err := net.DialTimeout("tcp", n.addr, natsDialTimeout)
+	if err != nil {
+		return
+	}
+	n.conn, n.rd = conn, bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	line, err := n.rd.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting of nats %q", strings.TrimSpace(line))
+	}
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "oneterm", "lang": "go", "version": "1.0.0", "protocol": 1}
+	if n.cfg.Token != "" {
+		opts["auth_token"] = n.cfg.Token
+	} else if n.cfg.User != "" {
+		opts["user"], opts["pass"] = n.cfg.User, n.cfg.Password
+	}
+	bs, _ := json.Marshal(opts)
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", bs); err != nil {
+		return
+	}
+	return n.pong()
+}
+
+// pong waits for the answer of the ping sent, errors of the server before it fail the batch
+func (n *nats) pong() error {
+	for {
+		line, err := n.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err = n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (n *nats) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.rd = nil, nil
+	}
+}
+
+func (n *nats) Close() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	n.close()
+	return nil
+}
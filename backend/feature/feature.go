@@ -0,0 +1,79 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	redis "github.com/veops/oneterm/cache"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// kFlags is the cache of all flags shared by instances, it is dropped once flags are changed
+	kFlags = "featureFlags"
+)
+
+var (
+	flags atomic.Pointer[map[string]*model.FeatureFlag]
+)
+
+// Load refreshes flags of this instance from the cache or the db, instances load every few seconds
+// so that a flag turned off takes effect everywhere without redeploying
+func Load(ctx context.Context) (err error) {
+	fs := make([]*model.FeatureFlag, 0)
+	if err = redis.Get(ctx, kFlags, &fs); err != nil {
+		if err = mysql.DB.Model(model.DefaultFeatureFlag).Find(&fs).Error; err != nil {
+			return
+		}
+		redis.SetEx(ctx, kFlags, fs, time.Hour)
+	}
+	m := lo.KeyBy(fs, func(f *model.FeatureFlag) string { return f.Name })
+	flags.Store(&m)
+	return
+}
+
+// Reload drops the cache and loads flags at once, it is called once flags are changed
+func Reload(ctx context.Context) {
+	redis.RC.Del(ctx, kFlags)
+	if err := Load(ctx); err != nil {
+		logger.L().Error("load feature flags failed", zap.Error(err))
+	}
+}
+
+// On tells whether the feature is on for the user of the role, def is taken if the flag is not defined.
+// Uid 0 means no user, the rollout is skipped then
+func On(name string, uid, rid int, def bool) bool {
+	m := flags.Load()
+	if m == nil {
+		return def
+	}
+	f, ok := (*m)[name]
+	if !ok {
+		return def
+	}
+	if o, ok := lo.Find(f.Overrides, func(o model.FeatureOverride) bool { return o.Uid != 0 && o.Uid == uid }); ok {
+		return o.Enable
+	}
+	if o, ok := lo.Find(f.Overrides, func(o model.FeatureOverride) bool { return o.Rid != 0 && o.Rid == rid }); ok {
+		return o.Enable
+	}
+	if !f.Enable {
+		return false
+	}
+	return f.Rollout <= 0 || f.Rollout >= 100 || uid == 0 || bucket(name, uid) < f.Rollout
+}
+
+// bucket places the user in 0-99 for the flag, the same user stays in the same bucket as the rollout grows
+func bucket(name string, uid int) int {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", name, uid)
+	return int(h.Sum32() % 100)
+}
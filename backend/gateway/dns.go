@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/veops/oneterm/model"
+)
+
+// resolve returns the host gateway dials, internal-only hostnames usually could not be resolved by oneterm,
+// so they are left to gateway host or the dns server of gateway unless the gateway resolves locally
+func (gt *GatewayTunnel) resolve(cli client) (string, error) {
+	host := gt.RemoteIp
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	var resolver *net.Resolver
+	switch {
+	case gt.DnsMode == model.DNSMODE_LOCAL:
+		resolver = net.DefaultResolver
+	case gt.DnsServer != "":
+		server := gt.DnsServer
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		// conns of ssh and proxies are not packet conns, so queries are sent over tcp
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return cli.DialContext(ctx, "tcp", server)
+			},
+		}
+	default:
+		// direct-tcpip, socks5 and connect carry the hostname, the gateway resolves it with its own resolver
+		return host, nil
+	}
+
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return ips[0].IP.String(), nil
+}
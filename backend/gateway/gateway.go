@@ -17,10 +17,12 @@ import (
 
 var (
 	manager = &GateWayManager{
-		gatewayTunnels:  map[string]*GatewayTunnel{},
-		sshClients:      map[int]*ssh.Client{},
-		sshClientsCount: map[int]int{},
-		mtx:             sync.Mutex{},
+		gatewayTunnels: map[string]*GatewayTunnel{},
+		clients:        map[int]client{},
+		clientsCount:   map[int]int{},
+		picks:          map[int]int{},
+		failed:         map[int]time.Time{},
+		mtx:            sync.Mutex{},
 	}
 )
 
@@ -40,6 +42,8 @@ type GatewayTunnel struct {
 	LocalPort  int
 	RemoteIp   string
 	RemotePort int
+	DnsMode    int
+	DnsServer  string
 	LocalConn  net.Conn
 	RemoteConn net.Conn
 	Opened     chan error
@@ -61,10 +65,17 @@ func (gt *GatewayTunnel) Open(isConnectable bool) (err error) {
 		logger.L().Error("accept failed", zap.String("sessionId", gt.SessionId), zap.Error(err))
 		return
 	}
-	remoteAddr := fmt.Sprintf("%s:%d", gt.RemoteIp, gt.RemotePort)
+	cli := manager.clients[gt.GatewayId]
+	remoteIp, err := gt.resolve(cli)
+	if err != nil {
+		gt.LocalConn.Close()
+		logger.L().Error("resolve remote failed", zap.String("sessionId", gt.SessionId), zap.String("host", gt.RemoteIp), zap.Error(err))
+		return
+	}
+	remoteAddr := net.JoinHostPort(remoteIp, fmt.Sprint(gt.RemotePort))
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	gt.RemoteConn, err = manager.sshClients[gt.GatewayId].DialContext(ctx, "tcp", remoteAddr)
+	gt.RemoteConn, err = cli.DialContext(ctx, "tcp", remoteAddr)
 	if err != nil {
 		defer func() {
 			if gt.LocalConn != nil {
@@ -87,10 +98,14 @@ func (gt *GatewayTunnel) Open(isConnectable bool) (err error) {
 }
 
 type GateWayManager struct {
-	gatewayTunnels  map[string]*GatewayTunnel
-	sshClients      map[int]*ssh.Client
-	sshClientsCount map[int]int
-	mtx             sync.Mutex
+	gatewayTunnels map[string]*GatewayTunnel
+	clients        map[int]client
+	clientsCount   map[int]int
+	// picks counts round robin picks of each gateway group
+	picks map[int]int
+	// failed is when each gateway failed to dial last time
+	failed map[int]time.Time
+	mtx    sync.Mutex
 }
 
 func (gm *GateWayManager) Open(isConnectable bool, sessionId, remoteIp string, remotePort int, gateway *model.Gateway) (g *GatewayTunnel, err error) {
@@ -101,35 +116,26 @@ func (gm *GateWayManager) Open(isConnectable bool, sessionId, remoteIp string, r
 	gm.mtx.Lock()
 	defer gm.mtx.Unlock()
 
-	sshCli, ok := gm.sshClients[gateway.Id]
-	if !ok {
-		var auth ssh.AuthMethod
-		auth, err = gm.getAuth(gateway)
-		if err != nil {
-			return
+	for i, gw := range append([]*model.Gateway{gateway}, gateway.Fallbacks...) {
+		if err = gm.dial(gw); err != nil {
+			continue
 		}
-		sshCli, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", gateway.Host, gateway.Port), &ssh.ClientConfig{
-			User:            gateway.Account,
-			Auth:            []ssh.AuthMethod{auth},
-			Timeout:         time.Second,
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		})
-		if err != nil {
-			logger.L().Error("open gateway sshcli failed", zap.Int("gatewayId", gateway.Id), zap.Error(err))
-			return
+		// the caller sees the gateway actually used
+		if i > 0 {
+			logger.L().Warn("gateway failed over", zap.String("sessionId", sessionId), zap.Int("from", gateway.Id), zap.Int("to", gw.Id))
+			*gateway = *gw
 		}
-		go func() {
-			logger.L().Debug("ssh proxy wait closed", zap.Int("gatewayId", gateway.Id), zap.Error(sshCli.Wait()))
-			delete(gm.sshClients, gateway.Id)
-		}()
+		break
 	}
-	gm.sshClients[gateway.Id] = sshCli
-	gm.sshClientsCount[gateway.Id] += 1
+	if err != nil {
+		return
+	}
+	gm.clientsCount[gateway.Id] += 1
 	localPort, err := getAvailablePort()
 	if err != nil {
 		return
 	}
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", "localhost", localPort))
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", fmt.Sprint(localPort)))
 	if err != nil {
 		return
 	}
@@ -141,6 +147,8 @@ func (gm *GateWayManager) Open(isConnectable bool, sessionId, remoteIp string, r
 		LocalPort:  localPort,
 		RemoteIp:   remoteIp,
 		RemotePort: remotePort,
+		DnsMode:    gateway.DnsMode,
+		DnsServer:  gateway.DnsServer,
 		Opened:     make(chan error),
 	}
 	gm.gatewayTunnels[sessionId] = g
@@ -153,6 +161,49 @@ func (gm *GateWayManager) Open(isConnectable bool, sessionId, remoteIp string, r
 	return
 }
 
+// dial connects to the gateway if there is no client of it yet, it must be called with the lock held
+func (gm *GateWayManager) dial(gateway *model.Gateway) (err error) {
+	if _, ok := gm.clients[gateway.Id]; ok {
+		return
+	}
+	var cli client
+	switch gateway.Type {
+	case model.GATEWAYTYPE_SOCKS5, model.GATEWAYTYPE_HTTP:
+		cli, err = newProxy(gateway)
+	default:
+		cli, err = gm.dialSsh(gateway)
+	}
+	if err != nil {
+		gm.failed[gateway.Id] = time.Now()
+		logger.L().Error("open gateway client failed", zap.Int("gatewayId", gateway.Id), zap.Int("type", gateway.Type), zap.Error(err))
+		return
+	}
+	delete(gm.failed, gateway.Id)
+	gm.clients[gateway.Id] = cli
+	return
+}
+
+func (gm *GateWayManager) dialSsh(gateway *model.Gateway) (cli client, err error) {
+	auth, err := gm.getAuth(gateway)
+	if err != nil {
+		return
+	}
+	sshCli, err := ssh.Dial("tcp", net.JoinHostPort(gateway.Host, fmt.Sprint(gateway.Port)), &ssh.ClientConfig{
+		User:            gateway.Account,
+		Auth:            []ssh.AuthMethod{auth},
+		Timeout:         time.Second,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return
+	}
+	go func() {
+		logger.L().Debug("ssh proxy wait closed", zap.Int("gatewayId", gateway.Id), zap.Error(sshCli.Wait()))
+		delete(gm.clients, gateway.Id)
+	}()
+	return sshCli, nil
+}
+
 func (gm *GateWayManager) Close(sessionIds ...string) {
 	gm.mtx.Lock()
 	defer gm.mtx.Unlock()
@@ -161,13 +212,13 @@ func (gm *GateWayManager) Close(sessionIds ...string) {
 		if !ok {
 			return
 		}
-		gm.sshClientsCount[gt.GatewayId] -= 1
-		if gm.sshClientsCount[gt.GatewayId] <= 0 {
-			if g := gm.sshClients[gt.GatewayId]; g != nil {
+		gm.clientsCount[gt.GatewayId] -= 1
+		if gm.clientsCount[gt.GatewayId] <= 0 {
+			if g := gm.clients[gt.GatewayId]; g != nil {
 				g.Close()
 			}
-			delete(gm.sshClients, gt.GatewayId)
-			delete(gm.sshClientsCount, gt.GatewayId)
+			delete(gm.clients, gt.GatewayId)
+			delete(gm.clientsCount, gt.GatewayId)
 		}
 	}
 }
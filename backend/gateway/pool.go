@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"sort"
+	"time"
+
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// failedTtl is how long a gateway failed to dial is tried after healthy ones
+	failedTtl = time.Minute
+)
+
+// Pick orders gateways of the group by its strategy, ones failed to dial recently are put last
+func (gm *GateWayManager) Pick(group *model.GatewayGroup, gateways []*model.Gateway) []*model.Gateway {
+	gm.mtx.Lock()
+	defer gm.mtx.Unlock()
+
+	res := make([]*model.Gateway, len(gateways))
+	copy(res, gateways)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	switch group.Strategy {
+	case model.GATEWAYSTRATEGY_LEASTSESSIONS:
+		sort.SliceStable(res, func(i, j int) bool {
+			return gm.clientsCount[res[i].Id] < gm.clientsCount[res[j].Id]
+		})
+	default:
+		if len(res) > 0 {
+			n := gm.picks[group.Id] % len(res)
+			res = append(res[n:], res[:n]...)
+		}
+		gm.picks[group.Id]++
+	}
+	sort.SliceStable(res, func(i, j int) bool { return !gm.failedRecently(res[i].Id) && gm.failedRecently(res[j].Id) })
+
+	return res
+}
+
+func (gm *GateWayManager) failedRecently(id int) bool {
+	t, ok := gm.failed[id]
+	return ok && time.Since(t) < failedTtl
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/veops/oneterm/model"
+)
+
+// client dials assets through a gateway, it is an ssh client of a jump host or a proxy
+type client interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	Close() error
+}
+
+// newProxy returns a client of a socks5 or http proxy, the proxy is probed first
+// since there is no persistent connection to it, otherwise failover never happens
+func newProxy(gateway *model.Gateway) (cli client, err error) {
+	addr := net.JoinHostPort(gateway.Host, fmt.Sprint(gateway.Port))
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return
+	}
+	conn.Close()
+
+	switch gateway.Type {
+	case model.GATEWAYTYPE_SOCKS5:
+		var auth *proxy.Auth
+		if gateway.Account != "" {
+			auth = &proxy.Auth{User: gateway.Account, Password: gateway.Password}
+		}
+		d, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{Timeout: time.Second * 3})
+		if err != nil {
+			return nil, err
+		}
+		return &socks5Proxy{ContextDialer: d.(proxy.ContextDialer)}, nil
+	case model.GATEWAYTYPE_HTTP:
+		return &httpProxy{addr: addr, user: gateway.Account, password: gateway.Password}, nil
+	default:
+		return nil, fmt.Errorf("invalid proxy type %d", gateway.Type)
+	}
+}
+
+// socks5Proxy sends hostnames to the proxy as they are, which resolves them like sshd of jump hosts
+type socks5Proxy struct {
+	proxy.ContextDialer
+}
+
+func (p *socks5Proxy) Close() error {
+	return nil
+}
+
+// httpProxy tunnels by the connect method
+//
+//	https://www.rfc-editor.org/rfc/rfc9110#name-connect
+type httpProxy struct {
+	addr     string
+	user     string
+	password string
+}
+
+func (p *httpProxy) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	conn, err = (&net.Dialer{}).DialContext(ctx, network, p.addr)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if p.user != "" {
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(p.user+":"+p.password)))
+	}
+	if err = req.Write(conn); err != nil {
+		return
+	}
+	// bytes after the response belong to the tunnel, so the response is read byte by byte
+	hdr := make([]byte, 0, 512)
+	for b := make([]byte, 1); !bytes.HasSuffix(hdr, []byte("\r\n\r\n")); hdr = append(hdr, b[0]) {
+		if len(hdr) >= 8192 {
+			err = fmt.Errorf("http proxy connect %s: response header too large", addr)
+			return
+		}
+		if _, err = io.ReadFull(conn, b); err != nil {
+			return
+		}
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(hdr)), req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("http proxy connect %s: %s", addr, resp.Status)
+	}
+	return
+}
+
+func (p *httpProxy) Close() error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	running atomic.Bool
+	last    time.Time
+)
+
+// CheckDue checks all assets if the interval has passed since the last check
+func CheckDue() {
+	cfg := conf.Cfg.Health
+	if cfg.Interval <= 0 || time.Since(last) < time.Minute*time.Duration(cfg.Interval) {
+		return
+	}
+	last = time.Now()
+	if !running.CompareAndSwap(false, true) {
+		return
+	}
+	defer running.Store(false)
+	if err := Check(); err != nil {
+		logger.L().Warn("check health of assets failed", zap.Error(err))
+	}
+}
+
+// Check dials protocol ports of assets, all of them if ids is empty, and saves results as their health and history
+func Check(ids ...int) (err error) {
+	cfg := conf.Cfg.Health
+	assets := make([]*model.Asset, 0)
+	db := mysql.DB.Model(model.DefaultAsset)
+	if len(ids) > 0 {
+		db = db.Where("id IN ?", ids)
+	}
+	if err = db.Find(&assets).Error; err != nil {
+		return
+	}
+	gateways := make([]*model.Gateway, 0)
+	if gids := lo.Without(lo.Uniq(lo.Map(assets, func(a *model.Asset, _ int) int { return a.GatewayId })), 0); len(gids) > 0 {
+		if err = mysql.DB.Model(model.DefaultGateway).Where("id IN ?", gids).Find(&gateways).Error; err != nil {
+			return
+		}
+	}
+	for _, g := range gateways {
+		g.Password = util.DecryptAES(g.Password)
+		g.Pk = util.DecryptAES(g.Pk)
+		g.Phrase = util.DecryptAES(g.Phrase)
+	}
+	gatewayMap := lo.SliceToMap(gateways, func(g *model.Gateway) (int, *model.Gateway) { return g.Id, g })
+	for _, a := range assets {
+		if a.GatewayGroupId == 0 {
+			continue
+		}
+		if g, err := util.PickGateway(a); err == nil {
+			gatewayMap[g.Id] = g
+		}
+	}
+
+	timeout := time.Millisecond * time.Duration(max(cfg.Timeout, 100))
+	sem := make(chan struct{}, max(cfg.Concurrency, 1))
+	mtx, wg := sync.Mutex{}, sync.WaitGroup{}
+	rows := make([]*model.AssetHealth, 0)
+	changed := false
+	for _, a := range assets {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(a *model.Asset) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res := lo.Map(a.Protocols, func(p string, _ int) *model.AssetHealth {
+				return check(a, gatewayMap[a.GatewayId], strings.Split(p, ":")[0], timeout, cfg.Banner)
+			})
+			if len(res) == 0 {
+				return
+			}
+			now := time.Now()
+			h := model.Health{Status: status(res), CheckedAt: &now}
+			h.Latency = lo.Max(lo.FilterMap(res, func(r *model.AssetHealth, _ int) (int, bool) { return r.Latency, r.Reachable }))
+			if err := mysql.DB.Model(a).UpdateColumns(map[string]any{
+				"health_status":     h.Status,
+				"health_latency":    h.Latency,
+				"health_checked_at": h.CheckedAt,
+			}).Error; err != nil {
+				logger.L().Warn("save health of asset failed", zap.Int("assetId", a.Id), zap.Error(err))
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			rows = append(rows, res...)
+			changed = changed || h.Status != a.Health.Status
+		}(a)
+	}
+	wg.Wait()
+
+	if changed {
+		util.DeleteAllFromCacheDb(context.Background(), model.DefaultAsset)
+	}
+	if len(rows) > 0 {
+		err = mysql.AuditDB.CreateInBatches(rows, 500).Error
+	}
+
+	return
+}
+
+// Expire deletes history checked before t
+func Expire(t time.Time) (n int64, err error) {
+	db := mysql.AuditDB.Where("created_at < ?", t).Delete(model.DefaultAssetHealth)
+	return db.RowsAffected, db.Error
+}
+
+// Probe dials the port of protocol of the asset once like checks do, results are not saved
+func Probe(asset *model.Asset, gateway *model.Gateway, protocol string) *model.AssetHealth {
+	cfg := conf.Cfg.Health
+	return check(asset, gateway, protocol, time.Millisecond*time.Duration(max(cfg.Timeout, 100)), cfg.Banner)
+}
+
+// check dials the port of protocol through the gateway if there is one, greetings of ssh are read if banner is on
+func check(asset *model.Asset, gateway *model.Gateway, protocol string, timeout time.Duration, banner bool) (res *model.AssetHealth) {
+	res = &model.AssetHealth{AssetId: asset.Id, Protocol: protocol, CreatedAt: time.Now()}
+	start := time.Now()
+	sid := uuid.NewString()
+	defer ggateway.GetGatewayManager().Close(sid)
+
+	err := func() (err error) {
+		ip, port, err := util.Proxy(true, sid, protocol, asset, gateway)
+		if err != nil {
+			return
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), timeout)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if asset.GatewayId != 0 && gateway != nil {
+			t := ggateway.GetGatewayTunnelBySessionId(sid)
+			if t == nil {
+				return fmt.Errorf("tunnel of gateway %d is not found", gateway.Id)
+			}
+			select {
+			case err = <-t.Opened:
+			case <-time.After(timeout):
+				err = fmt.Errorf("open tunnel of gateway %d timeout", gateway.Id)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if !banner || protocol != "ssh" {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		// the identification string is at most 255 bytes by rfc 4253
+		line, err := bufio.NewReader(io.LimitReader(conn, 255)).ReadString('\n')
+		res.Banner = strings.TrimSpace(line)
+		if !strings.HasPrefix(res.Banner, "SSH-") {
+			return fmt.Errorf("not a ssh server: %w", lo.Ternary(err != nil, err, fmt.Errorf("greeting is %q", res.Banner)))
+		}
+		return nil
+	}()
+
+	res.Latency = int(time.Since(start).Milliseconds())
+	res.Reachable = err == nil
+	if err != nil {
+		res.Message = err.Error()
+	}
+	return
+}
+
+func status(res []*model.AssetHealth) string {
+	switch n := lo.CountBy(res, func(r *model.AssetHealth) bool { return r.Reachable }); n {
+	case len(res):
+		return model.HEALTHSTATUS_UP
+	case 0:
+		return model.HEALTHSTATUS_DOWN
+	default:
+		return model.HEALTHSTATUS_PARTIAL
+	}
+}
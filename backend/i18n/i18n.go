@@ -124,6 +124,41 @@ var (
 		One:   "Load Session Faild",
 		Other: "Load Session Faild",
 	}
+	MsgAssetWarning = &i18n.Message{
+		ID:    "MsgAssetWarning",
+		One:   "Bad Request: asset has open warnings, connect again with confirmation: {{.warnings}}",
+		Other: "Bad Request: asset has open warnings, connect again with confirmation: {{.warnings}}",
+	}
+	MsgStepUp = &i18n.Message{
+		ID:    "MsgStepUp",
+		One:   "Forbidden: a fresh step-up authentication is required: {{.err}}",
+		Other: "Forbidden: a fresh step-up authentication is required: {{.err}}",
+	}
+	MsgMfa = &i18n.Message{
+		ID:    "MsgMfa",
+		One:   "Forbidden: verify a totp code before connecting to asset {{.asset_id}}",
+		Other: "Forbidden: verify a totp code before connecting to asset {{.asset_id}}",
+	}
+	MsgFeatureOff = &i18n.Message{
+		ID:    "MsgFeatureOff",
+		One:   "Forbidden: feature {{.feature}} is turned off",
+		Other: "Forbidden: feature {{.feature}} is turned off",
+	}
+	MsgSessionLimit = &i18n.Message{
+		ID:    "MsgSessionLimit",
+		One:   "Forbidden: sessions of asset {{.asset_id}} reach the limit of {{.max}}",
+		Other: "Forbidden: sessions of asset {{.asset_id}} reach the limit of {{.max}}",
+	}
+	MsgNoAccess = &i18n.Message{
+		ID:    "MsgNoAccess",
+		One:   "Forbidden: no access to asset {{.asset}}, ask {{.contacts}} for it",
+		Other: "Forbidden: no access to asset {{.asset}}, ask {{.contacts}} for it",
+	}
+	MsgLicenseLimit = &i18n.Message{
+		ID:    "MsgLicenseLimit",
+		One:   "Forbidden: licensed limit of {{.limit}} {{.quota}} is reached",
+		Other: "Forbidden: licensed limit of {{.limit}} {{.quota}} is reached",
+	}
 	MsgConnectServer = &i18n.Message{
 		ID:    "MsgConnectServer",
 		One:   "Connect Server Error",
@@ -167,6 +202,65 @@ var (
 		Other: "Public Key",
 	}
 
+	// alerts
+	MsgAlertCanaryFailed = &i18n.Message{
+		ID:    "MsgAlertCanaryFailed",
+		One:   "Canary session to {{.asset}} by {{.protocol}} as account {{.account}} failed {{.count}} times in a row, the last one at {{.time}} failed in {{.stage}}: {{.reason}}",
+		Other: "Canary session to {{.asset}} by {{.protocol}} as account {{.account}} failed {{.count}} times in a row, the last one at {{.time}} failed in {{.stage}}: {{.reason}}",
+	}
+	MsgAlertCanaryFailedSubject = &i18n.Message{
+		ID:    "MsgAlertCanaryFailedSubject",
+		One:   "[OneTerm] Canary session to {{.asset}} by {{.protocol}} failed",
+		Other: "[OneTerm] Canary session to {{.asset}} by {{.protocol}} failed",
+	}
+	MsgAlertCommandBlocked = &i18n.Message{
+		ID:    "MsgAlertCommandBlocked",
+		One:   "{{.user}} tried to run a command blocked by {{.rule}} on {{.asset}} as {{.account}} from {{.ip}} at {{.time}}:\n\n{{.cmd}}\n\nSession: {{.session}}",
+		Other: "{{.user}} tried to run a command blocked by {{.rule}} on {{.asset}} as {{.account}} from {{.ip}} at {{.time}}:\n\n{{.cmd}}\n\nSession: {{.session}}",
+	}
+	MsgAlertCommandBlockedSubject = &i18n.Message{
+		ID:    "MsgAlertCommandBlockedSubject",
+		One:   "[OneTerm] Command of {{.user}} on {{.asset}} was blocked",
+		Other: "[OneTerm] Command of {{.user}} on {{.asset}} was blocked",
+	}
+	MsgAlertConnectFailed = &i18n.Message{
+		ID:    "MsgAlertConnectFailed",
+		One:   "{{.user}} failed to connect {{.count}} times within {{.minutes}} minutes, the last one is to {{.asset}} by {{.protocol}} from {{.ip}} at {{.time}}: {{.reason}}",
+		Other: "{{.user}} failed to connect {{.count}} times within {{.minutes}} minutes, the last one is to {{.asset}} by {{.protocol}} from {{.ip}} at {{.time}}: {{.reason}}",
+	}
+	MsgAlertConnectFailedSubject = &i18n.Message{
+		ID:    "MsgAlertConnectFailedSubject",
+		One:   "[OneTerm] {{.user}} failed to connect {{.count}} times",
+		Other: "[OneTerm] {{.user}} failed to connect {{.count}} times",
+	}
+
+	// notifications
+	MsgNotifyAdminClose = &i18n.Message{
+		ID:    "MsgNotifyAdminClose",
+		One:   "Session of {{.user}} on {{.asset}} was closed by {{.closer}} at {{.time}}",
+		Other: "Session of {{.user}} on {{.asset}} was closed by {{.closer}} at {{.time}}",
+	}
+	MsgNotifyCommandBlocked = &i18n.Message{
+		ID:    "MsgNotifyCommandBlocked",
+		One:   "Command of {{.user}} on {{.asset}} was blocked by {{.rule}} at {{.time}}: {{.cmd}}",
+		Other: "Command of {{.user}} on {{.asset}} was blocked by {{.rule}} at {{.time}}: {{.cmd}}",
+	}
+	MsgNotifySessionClose = &i18n.Message{
+		ID:    "MsgNotifySessionClose",
+		One:   "{{.user}} disconnected from {{.asset}} as {{.account}} at {{.time}}",
+		Other: "{{.user}} disconnected from {{.asset}} as {{.account}} at {{.time}}",
+	}
+	MsgNotifySessionOpen = &i18n.Message{
+		ID:    "MsgNotifySessionOpen",
+		One:   "{{.user}} connected to {{.asset}} as {{.account}} by {{.protocol}} from {{.ip}} at {{.time}}",
+		Other: "{{.user}} connected to {{.asset}} as {{.account}} by {{.protocol}} from {{.ip}} at {{.time}}",
+	}
+	MsgNotifyTest = &i18n.Message{
+		ID:    "MsgNotifyTest",
+		One:   "This is a test notification of OneTerm",
+		Other: "This is a test notification of OneTerm",
+	}
+
 	// SSH
 	MsgSshShowAssetResults = &i18n.Message{
 		ID:    "MsgSshShowAssetResults",
@@ -0,0 +1,63 @@
+package i18n
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+var (
+	// timeLayouts of timestamps by base languages of the bundle, others take the english one
+	timeLayouts = map[string]string{
+		"en": "Jan 2, 2006 15:04:05 MST",
+		"zh": "2006年1月2日 15:04:05 MST",
+	}
+	zones = &sync.Map{}
+)
+
+// Zone returns the location of the iana name, the local one of the server if it is empty or unknown
+func Zone(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.Local
+	}
+	zones.Store(name, loc)
+	return loc
+}
+
+// FormatTime renders t in the timezone by the layout of the language matched first of langs, which are like the
+// ones of localizers, e.g. a preference of the user followed by Accept-Language
+func FormatTime(t time.Time, timezone string, langs ...string) string {
+	tag, _ := language.MatchStrings(language.NewMatcher(Bundle.LanguageTags()), langs...)
+	base, _ := tag.Base()
+	layout, ok := timeLayouts[base.String()]
+	if !ok {
+		layout = timeLayouts["en"]
+	}
+	return t.In(Zone(timezone)).Format(layout)
+}
+
+// TimeData returns a copy of data of templates with times formatted by FormatTime, so that messages show them
+// the same way as stamps and exports do
+func TimeData(data map[string]any, timezone string, langs ...string) map[string]any {
+	res := make(map[string]any, len(data))
+	for k, v := range data {
+		switch t := v.(type) {
+		case time.Time:
+			v = FormatTime(t, timezone, langs...)
+		case *time.Time:
+			if t != nil {
+				v = FormatTime(*t, timezone, langs...)
+			}
+		}
+		res[k] = v
+	}
+	return res
+}
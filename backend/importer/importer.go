@@ -0,0 +1,232 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"golang.org/x/crypto/ssh"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	KIND_GATEWAY = "gateway"
+	KIND_ACCOUNT = "account"
+	KIND_ASSET   = "asset"
+)
+
+var (
+	protocols   = []string{"ssh", "rdp", "vnc", "telnet", "serial", "k8s", "docker", "redis", "mysql"}
+	authMethods = map[string]int{"password": model.AUTHMETHOD_PASSWORD, "publickey": model.AUTHMETHOD_PUBLICKEY}
+)
+
+// RowError is an invalid row, rows are numbered from 1 with the header as spreadsheets show
+type RowError struct {
+	Sheet string `json:"sheet"`
+	Row   int    `json:"row"`
+	Err   string `json:"err"`
+}
+
+// Asset refers its gateway and accounts by names, which are created by the same file or existing
+type Asset struct {
+	*model.Asset
+	Gateway  string
+	Accounts []string
+}
+
+// Plan is what a file creates, secrets are plain
+type Plan struct {
+	Gateways []*model.Gateway
+	Accounts []*model.Account
+	Assets   []*Asset
+}
+
+type row struct {
+	sheet string
+	n     int
+	kind  string
+	cells map[string]string
+}
+
+func (r *row) get(col string) string {
+	return strings.TrimSpace(r.cells[col])
+}
+
+// Parse validates all rows of sheets, the kind of rows is the kind column or the name of the sheet like assets.
+// Names must be unique among the file and existing ones, gateways and accounts of assets are referred by names
+// and multiple protocols or accounts are separated by commas
+func Parse(sheets []*Sheet) (plan *Plan, errs []*RowError, err error) {
+	rows := make([]*row, 0)
+	for _, s := range sheets {
+		if len(s.Rows) == 0 {
+			continue
+		}
+		header := lo.Map(s.Rows[0], func(h string, _ int) string { return strings.ToLower(strings.TrimSpace(h)) })
+		for i, cells := range s.Rows[1:] {
+			if lo.EveryBy(cells, func(c string) bool { return strings.TrimSpace(c) == "" }) {
+				continue
+			}
+			r := &row{sheet: s.Name, n: i + 2, cells: map[string]string{}}
+			for j, c := range cells {
+				if j < len(header) && header[j] != "" {
+					r.cells[header[j]] = c
+				}
+			}
+			r.kind = strings.TrimSuffix(strings.ToLower(lo.Ternary(r.get("kind") != "", r.get("kind"), strings.TrimSpace(s.Name))), "s")
+			rows = append(rows, r)
+		}
+	}
+
+	existing, err := names()
+	if err != nil {
+		return
+	}
+	plan = &Plan{}
+	seen := map[string]map[string]bool{KIND_GATEWAY: {}, KIND_ACCOUNT: {}, KIND_ASSET: {}}
+	fail := func(r *row, format string, args ...any) {
+		errs = append(errs, &RowError{Sheet: r.sheet, Row: r.n, Err: fmt.Sprintf(format, args...)})
+	}
+	for _, r := range rows {
+		name := r.get("name")
+		if _, ok := seen[r.kind]; !ok {
+			fail(r, "unknown kind %q, it must be gateway, account or asset", r.kind)
+			continue
+		}
+		if name == "" {
+			fail(r, "name is required")
+			continue
+		}
+		if seen[r.kind][name] || existing[r.kind][name] {
+			fail(r, "%s %s already exists", r.kind, name)
+			continue
+		}
+		seen[r.kind][name] = true
+
+		switch r.kind {
+		case KIND_GATEWAY:
+			g := &model.Gateway{Name: name, Host: r.get("host"), Port: 22, Account: r.get("account"), Password: r.get("password"), Pk: r.get("pk"), Phrase: r.get("phrase")}
+			if p := r.get("port"); p != "" {
+				g.Port = cast.ToInt(p)
+			}
+			if g.Host == "" || g.Port <= 0 || g.Port > 65535 {
+				fail(r, "host and port are required and port must be in 1-65535")
+				continue
+			}
+			t, e := credential(r)
+			if e != nil {
+				fail(r, "%s", e)
+				continue
+			}
+			g.AccountType = t
+			plan.Gateways = append(plan.Gateways, g)
+		case KIND_ACCOUNT:
+			a := &model.Account{Name: name, Account: r.get("account"), Password: r.get("password"), Pk: r.get("pk"), Phrase: r.get("phrase")}
+			t, e := credential(r)
+			if e != nil {
+				fail(r, "%s", e)
+				continue
+			}
+			a.AccountType = t
+			plan.Accounts = append(plan.Accounts, a)
+		case KIND_ASSET:
+			a := &Asset{
+				Asset: &model.Asset{
+					Name:          name,
+					Comment:       r.get("comment"),
+					Ip:            r.get("ip"),
+					ParentId:      cast.ToInt(r.get("parent_id")),
+					Protocols:     split(r.get("protocols")),
+					Authorization: make(model.Map[int, model.Slice[int]]),
+				},
+				Gateway:  r.get("gateway"),
+				Accounts: split(r.get("accounts")),
+			}
+			if e := checkAsset(a, seen, existing); e != nil {
+				fail(r, "%s", e)
+				continue
+			}
+			plan.Assets = append(plan.Assets, a)
+		}
+	}
+
+	return
+}
+
+func checkAsset(a *Asset, seen, existing map[string]map[string]bool) error {
+	if a.Ip == "" {
+		return fmt.Errorf("ip is required")
+	}
+	if len(a.Protocols) == 0 {
+		return fmt.Errorf("protocols are required")
+	}
+	for _, p := range a.Protocols {
+		name, port, ok := strings.Cut(p, ":")
+		if !ok || !lo.Contains(protocols, name) || cast.ToInt(port) <= 0 || cast.ToInt(port) > 65535 {
+			return fmt.Errorf("invalid protocol %q, it must be like ssh:22 of %s", p, strings.Join(protocols, ", "))
+		}
+	}
+	if a.ParentId != 0 && !existing["node"][cast.ToString(a.ParentId)] {
+		return fmt.Errorf("node %d does not exist", a.ParentId)
+	}
+	if a.Gateway != "" && !seen[KIND_GATEWAY][a.Gateway] && !existing[KIND_GATEWAY][a.Gateway] {
+		return fmt.Errorf("gateway %s does not exist", a.Gateway)
+	}
+	for _, name := range a.Accounts {
+		if !seen[KIND_ACCOUNT][name] && !existing[KIND_ACCOUNT][name] {
+			return fmt.Errorf("account %s does not exist", name)
+		}
+	}
+	return nil
+}
+
+// credential returns the account type after checking the secret matches it
+func credential(r *row) (accountType int, err error) {
+	t := strings.ToLower(lo.Ternary(r.get("account_type") != "", r.get("account_type"), "password"))
+	accountType, ok := authMethods[t]
+	if !ok {
+		return 0, fmt.Errorf("invalid account type %q, it must be password or publickey", t)
+	}
+	if r.get("account") == "" {
+		return 0, fmt.Errorf("account is required")
+	}
+	switch accountType {
+	case model.AUTHMETHOD_PASSWORD:
+		if r.get("password") == "" {
+			return 0, fmt.Errorf("password is required")
+		}
+	case model.AUTHMETHOD_PUBLICKEY:
+		if r.get("phrase") == "" {
+			_, err = ssh.ParsePrivateKey([]byte(r.get("pk")))
+		} else {
+			_, err = ssh.ParsePrivateKeyWithPassphrase([]byte(r.get("pk")), []byte(r.get("phrase")))
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid private key: %w", err)
+		}
+	}
+	return
+}
+
+// names returns existing names of each kind and ids of nodes
+func names() (res map[string]map[string]bool, err error) {
+	res = map[string]map[string]bool{}
+	for kind, m := range map[string]model.Model{KIND_GATEWAY: model.DefaultGateway, KIND_ACCOUNT: model.DefaultAccount, KIND_ASSET: model.DefaultAsset, "node": model.DefaultNode} {
+		vs := make([]string, 0)
+		if err = mysql.DB.Model(m).Pluck(lo.Ternary(kind == "node", "id", "name"), &vs).Error; err != nil {
+			return
+		}
+		res[kind] = lo.SliceToMap(vs, func(v string) (string, bool) { return v, true })
+	}
+	return
+}
+
+// split splits values separated by commas, semicolons or line breaks as cells in spreadsheets often are
+func split(s string) []string {
+	return lo.Uniq(lo.FilterMap(strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' || r == '\n' }), func(v string, _ int) (string, bool) {
+		v = strings.TrimSpace(v)
+		return v, v != ""
+	}))
+}
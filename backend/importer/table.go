@@ -0,0 +1,185 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// Sheet is a table of cells, the first row is the header
+type Sheet struct {
+	Name string
+	Rows [][]string
+}
+
+// Read reads sheets of a csv or xlsx file by the extension of name
+func Read(name string, r io.ReaderAt, size int64) (sheets []*Sheet, err error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		var rows [][]string
+		rows, err = readCsv(io.NewSectionReader(r, 0, size))
+		sheets = []*Sheet{{Name: strings.TrimSuffix(path.Base(name), path.Ext(name)), Rows: rows}}
+	case ".xlsx":
+		sheets, err = readXlsx(r, size)
+	default:
+		err = fmt.Errorf("unsupported file %s, only csv and xlsx are supported", name)
+	}
+	return
+}
+
+func readCsv(r io.Reader) (rows [][]string, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err = cr.ReadAll()
+	// the bom written by excel would be a part of the first header otherwise
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		Rid  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRel struct {
+	Id     string `xml:"Id,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+type xlsxRels struct {
+	Rels []xlsxRel `xml:"Relationship"`
+}
+
+// xlsxText is a plain or rich text of which runs are concatenated
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(t.T)
+	for _, r := range t.Runs {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxSst struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXlsx reads values of cells in all sheets, formulas are taken as their cached results and styles are ignored
+//
+//	https://learn.microsoft.com/en-us/openspecs/office_standards/ms-oe376
+func readXlsx(r io.ReaderAt, size int64) (sheets []*Sheet, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	wb, rels, sst := &xlsxWorkbook{}, &xlsxRels{}, &xlsxSst{}
+	if err = readXml(zr, "xl/workbook.xml", wb); err != nil {
+		return
+	}
+	if err = readXml(zr, "xl/_rels/workbook.xml.rels", rels); err != nil {
+		return
+	}
+	// workbooks without any text have no shared strings
+	if e := readXml(zr, "xl/sharedStrings.xml", sst); e != nil && !errors.Is(e, fs.ErrNotExist) {
+		return nil, e
+	}
+	strs := lo.Map(sst.Items, func(t xlsxText, _ int) string { return t.String() })
+	targets := lo.SliceToMap(rels.Rels, func(r xlsxRel) (string, string) { return r.Id, r.Target })
+
+	for _, s := range wb.Sheets {
+		target := targets[s.Rid]
+		target = lo.Ternary(strings.HasPrefix(target, "/"), strings.TrimPrefix(target, "/"), path.Join("xl", target))
+		ws := &xlsxSheet{}
+		if err = readXml(zr, target, ws); err != nil {
+			return
+		}
+		sheet := &Sheet{Name: s.Name}
+		for _, row := range ws.Rows {
+			cells := make([]string, 0, len(row.Cells))
+			for i, c := range row.Cells {
+				col := i
+				if c.Ref != "" {
+					col = column(c.Ref)
+				}
+				if col < len(cells) || col > 16384 {
+					return nil, fmt.Errorf("invalid cell %s of sheet %s", c.Ref, s.Name)
+				}
+				for len(cells) < col {
+					cells = append(cells, "")
+				}
+				v := c.Value
+				switch c.Type {
+				case "s":
+					idx := 0
+					if _, err = fmt.Sscan(c.Value, &idx); err != nil || idx < 0 || idx >= len(strs) {
+						return nil, fmt.Errorf("invalid shared string of cell %s of sheet %s", c.Ref, s.Name)
+					}
+					v = strs[idx]
+				case "inlineStr":
+					v = c.Inline.String()
+				}
+				cells = append(cells, v)
+			}
+			sheet.Rows = append(sheet.Rows, cells)
+		}
+		sheets = append(sheets, sheet)
+	}
+
+	return
+}
+
+// readXml returns fs.ErrNotExist if the part does not exist
+func readXml(zr *zip.Reader, name string, v any) (err error) {
+	f, ok := lo.Find(zr.File, func(f *zip.File) bool { return f.Name == name })
+	if !ok {
+		return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	return xml.NewDecoder(io.LimitReader(rc, 64*1024*1024)).Decode(v)
+}
+
+// column returns the zero based column of a cell reference like AB12
+func column(ref string) (col int) {
+	for _, c := range strings.ToUpper(ref) {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
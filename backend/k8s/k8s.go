@@ -0,0 +1,279 @@
+package k8s
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+// channels of exec streams
+//
+//	https://github.com/kubernetes/apimachinery/blob/master/pkg/util/remotecommand/constants.go
+const (
+	subprotocol = "v4.channel.k8s.io"
+
+	chanStdin  = 0
+	chanStdout = 1
+	chanStderr = 2
+	chanError  = 3
+	chanResize = 4
+)
+
+var (
+	// DefaultCommand prefers bash and falls back to sh since images may have only one of them
+	DefaultCommand = []string{"sh", "-c", "command -v bash >/dev/null && exec bash || exec sh"}
+)
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTlsVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TlsServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// Config is what is needed to exec in pods, it comes from the current context of kubeconfig or a bearer token
+type Config struct {
+	ServerName string
+	Namespace  string
+	Token      string
+	TLS        *tls.Config
+}
+
+// ParseKubeconfig parses the current context of kubeconfig, only embedded data is supported since files are not on oneterm
+func ParseKubeconfig(content string) (cfg *Config, err error) {
+	kc := &kubeconfig{}
+	if err = yaml.Unmarshal([]byte(content), kc); err != nil {
+		return
+	}
+	if len(kc.Contexts) == 0 {
+		return nil, fmt.Errorf("no context in kubeconfig")
+	}
+	ctx := kc.Contexts[0].Context
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			ctx = c.Context
+		}
+	}
+	cfg = &Config{Namespace: ctx.Namespace, TLS: &tls.Config{}}
+
+	for _, c := range kc.Clusters {
+		if c.Name != ctx.Cluster {
+			continue
+		}
+		if u, e := url.Parse(c.Cluster.Server); e == nil {
+			cfg.ServerName = u.Hostname()
+		}
+		if c.Cluster.TlsServerName != "" {
+			cfg.ServerName = c.Cluster.TlsServerName
+		}
+		cfg.TLS.InsecureSkipVerify = c.Cluster.InsecureSkipTlsVerify
+		if c.Cluster.CertificateAuthorityData != "" {
+			pem, e := base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData)
+			if e != nil {
+				return nil, fmt.Errorf("invalid certificate-authority-data: %w", e)
+			}
+			cfg.TLS.RootCAs = x509.NewCertPool()
+			if !cfg.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("invalid certificate-authority-data")
+			}
+		}
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != ctx.User {
+			continue
+		}
+		cfg.Token = u.User.Token
+		if u.User.ClientCertificateData == "" {
+			continue
+		}
+		cert, e := base64.StdEncoding.DecodeString(u.User.ClientCertificateData)
+		if e != nil {
+			return nil, fmt.Errorf("invalid client-certificate-data: %w", e)
+		}
+		key, e := base64.StdEncoding.DecodeString(u.User.ClientKeyData)
+		if e != nil {
+			return nil, fmt.Errorf("invalid client-key-data: %w", e)
+		}
+		pair, e := tls.X509KeyPair(cert, key)
+		if e != nil {
+			return nil, e
+		}
+		cfg.TLS.Certificates = []tls.Certificate{pair}
+	}
+
+	if cfg.Token == "" && len(cfg.TLS.Certificates) == 0 {
+		return nil, fmt.Errorf("no credential of user in kubeconfig")
+	}
+	return
+}
+
+// TokenConfig is for service account tokens, the certificate of server is not verified since there is no ca
+func TokenConfig(token string) *Config {
+	return &Config{Token: token, TLS: &tls.Config{InsecureSkipVerify: true}}
+}
+
+// Target is the container to exec in, namespace of config is used if it is empty
+type Target struct {
+	Namespace string
+	Pod       string
+	Container string
+	Command   []string
+}
+
+// Client is an exec stream with tty, stdout and stderr are merged by tty
+type Client struct {
+	conn *websocket.Conn
+	buf  []byte
+	mtx  sync.Mutex
+}
+
+// Dial opens an exec stream at addr which may be a local address of gateway tunnel
+func Dial(addr string, cfg *Config, target *Target, w, h int) (cli *Client, err error) {
+	if target.Pod == "" {
+		return nil, fmt.Errorf("pod is required")
+	}
+	ns := target.Namespace
+	if ns == "" {
+		ns = cfg.Namespace
+	}
+	if ns == "" {
+		ns = "default"
+	}
+	cmd := target.Command
+	if len(cmd) == 0 {
+		cmd = DefaultCommand
+	}
+	q := url.Values{"command": cmd, "stdin": {"true"}, "stdout": {"true"}, "tty": {"true"}}
+	if target.Container != "" {
+		q.Set("container", target.Container)
+	}
+	u := url.URL{
+		Scheme:   "wss",
+		Host:     addr,
+		Path:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/exec", url.PathEscape(ns), url.PathEscape(target.Pod)),
+		RawQuery: q.Encode(),
+	}
+
+	tlsCfg := cfg.TLS.Clone()
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = cfg.ServerName
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	dialer := &websocket.Dialer{
+		TLSClientConfig:  tlsCfg,
+		HandshakeTimeout: time.Second * 5,
+		Subprotocols:     []string{subprotocol},
+	}
+	header := http.Header{}
+	if cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	conn, resp, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		if resp != nil {
+			bs, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(bs)))
+		}
+		return
+	}
+	cli = &Client{conn: conn}
+	if w > 0 && h > 0 {
+		cli.WindowChange(w, h)
+	}
+	return
+}
+
+func (c *Client) Read(p []byte) (n int, err error) {
+	for len(c.buf) == 0 {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case chanStdout, chanStderr:
+			c.buf = msg[1:]
+		case chanError:
+			return 0, statusError(msg[1:])
+		}
+	}
+	n = copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return
+}
+
+func (c *Client) Write(p []byte) (n int, err error) {
+	if err = c.write(chanStdin, p); err != nil {
+		return
+	}
+	return len(p), nil
+}
+
+func (c *Client) write(ch byte, p []byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, append([]byte{ch}, p...))
+}
+
+func (c *Client) WindowChange(w, h int) error {
+	bs, _ := json.Marshal(map[string]int{"Width": w, "Height": h})
+	return c.write(chanResize, bs)
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// statusError returns io.EOF if the command exits successfully, otherwise the message of status
+func statusError(bs []byte) error {
+	status := &struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(bs, status); err != nil {
+		return fmt.Errorf("%s", bs)
+	}
+	if status.Status == "Success" {
+		return io.EOF
+	}
+	return fmt.Errorf("%s", status.Message)
+}
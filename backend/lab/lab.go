@@ -0,0 +1,303 @@
+package lab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/acl"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/remote"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	ACTION_PROVISION   = "provision"
+	ACTION_DEPROVISION = "deprovision"
+
+	defaultTimeout = 30
+)
+
+var (
+	running atomic.Bool
+)
+
+// Report is the state of a lab, it is both the response of provisioning and the body of callbacks
+type Report struct {
+	LabId      int    `json:"lab_id"`
+	ExternalId string `json:"external_id"`
+	Ip         string `json:"ip"`
+	Ready      bool   `json:"ready"`
+	Error      string `json:"error"`
+}
+
+// Provision calls the provisioning webhook of the template for the approved access request,
+// the lab is registered at once if the orchestrator responds it is ready, or by a callback later
+func Provision(ar *model.AccessRequest) (err error) {
+	ctx := context.Background()
+	tpl := &model.LabTemplate{}
+	if err = mysql.DB.Model(tpl).Where("id = ?", ar.TemplateId).First(tpl).Error; err != nil {
+		return
+	}
+	l := &model.Lab{
+		TemplateId:      tpl.Id,
+		AccessRequestId: ar.Id,
+		Uid:             ar.Uid,
+		UserName:        ar.UserName,
+		Status:          model.LABSTATUS_PROVISIONING,
+	}
+	if err = mysql.DB.Create(l).Error; err != nil {
+		return
+	}
+
+	r := &Report{}
+	if err = call(tpl, tpl.ProvisionUrl, ACTION_PROVISION, l, ar, r); err != nil {
+		fail(ctx, tpl, l, err.Error())
+		return
+	}
+	r.LabId = l.Id
+	return Apply(ctx, tpl, r)
+}
+
+// Apply takes the report of a lab being provisioned, ready ones are registered as assets and failed ones are deprovisioned
+func Apply(ctx context.Context, tpl *model.LabTemplate, r *Report) (err error) {
+	l := &model.Lab{}
+	if err = mysql.DB.Model(l).Where("id = ? AND template_id = ?", r.LabId, tpl.Id).First(l).Error; err != nil {
+		return
+	}
+	if l.Status != model.LABSTATUS_PROVISIONING {
+		return fmt.Errorf("lab %d is %s", l.Id, l.Status)
+	}
+	if r.ExternalId != "" && r.ExternalId != l.ExternalId {
+		l.ExternalId = r.ExternalId
+		if err = mysql.DB.Model(l).Update("external_id", l.ExternalId).Error; err != nil {
+			return
+		}
+	}
+
+	switch {
+	case r.Error != "":
+		fail(ctx, tpl, l, r.Error)
+	case r.Ready && r.Ip != "":
+		l.Ip = r.Ip
+		if err = register(ctx, tpl, l); err != nil {
+			fail(ctx, tpl, l, err.Error())
+		}
+	}
+	return
+}
+
+// ExpireDue deprovisions labs whose access has ended and fails ones not ready in time, only one runs at a time
+func ExpireDue() {
+	if !running.CompareAndSwap(false, true) {
+		return
+	}
+	defer running.Store(false)
+
+	ctx, now := context.Background(), time.Now()
+	labs := make([]*model.Lab, 0)
+	if err := mysql.DB.Model(model.DefaultLab).
+		Where("status IN ?", []string{model.LABSTATUS_PROVISIONING, model.LABSTATUS_READY}).
+		Find(&labs).Error; err != nil {
+		logger.L().Error("get labs failed", zap.Error(err))
+		return
+	}
+	if len(labs) == 0 {
+		return
+	}
+	tpls, ars := make([]*model.LabTemplate, 0), make([]*model.AccessRequest, 0)
+	if err := mysql.DB.Unscoped().Model(model.DefaultLabTemplate).
+		Where("id IN ?", lo.Uniq(lo.Map(labs, func(l *model.Lab, _ int) int { return l.TemplateId }))).
+		Find(&tpls).Error; err != nil {
+		logger.L().Error("get lab templates failed", zap.Error(err))
+		return
+	}
+	if err := mysql.DB.Model(model.DefaultAccessRequest).
+		Where("id IN ?", lo.Map(labs, func(l *model.Lab, _ int) int { return l.AccessRequestId })).
+		Find(&ars).Error; err != nil {
+		logger.L().Error("get access requests of labs failed", zap.Error(err))
+		return
+	}
+	tplById := lo.KeyBy(tpls, func(t *model.LabTemplate) int { return t.Id })
+	arById := lo.KeyBy(ars, func(ar *model.AccessRequest) int { return ar.Id })
+
+	for _, l := range labs {
+		tpl, ok := tplById[l.TemplateId]
+		if !ok {
+			continue
+		}
+		ar := arById[l.AccessRequestId]
+		switch l.Status {
+		case model.LABSTATUS_PROVISIONING:
+			timeout := time.Minute * time.Duration(lo.Ternary(tpl.Timeout > 0, tpl.Timeout, defaultTimeout))
+			if now.Sub(l.CreatedAt) > timeout {
+				fail(ctx, tpl, l, "not ready in time")
+			}
+		case model.LABSTATUS_READY:
+			if ar == nil || ar.End == nil || !now.Before(*ar.End) {
+				expire(ctx, tpl, l, ar)
+			}
+		}
+	}
+}
+
+// register creates the asset of the ready lab and starts the access from now on
+func register(ctx context.Context, tpl *model.LabTemplate, l *model.Lab) (err error) {
+	ar := &model.AccessRequest{}
+	if err = mysql.DB.Model(ar).Where("id = ?", l.AccessRequestId).First(ar).Error; err != nil {
+		return
+	}
+	asset := &model.Asset{
+		Name:          fmt.Sprintf("lab-%s-%d", tpl.Name, l.Id),
+		Comment:       fmt.Sprintf("lab of %s for %s", tpl.Name, l.UserName),
+		Ip:            l.Ip,
+		Protocols:     tpl.Protocols,
+		ParentId:      tpl.ParentId,
+		GatewayId:     tpl.GatewayId,
+		Authorization: model.Map[int, model.Slice[int]]{tpl.AccountId: {}},
+	}
+	resource, err := acl.AddResource(ctx, tpl.CreatorId, conf.RESOURCE_ASSET, asset.Name)
+	if err != nil {
+		return
+	}
+	asset.ResourceId, asset.CreatorId, asset.UpdaterId = resource.ResourceId, tpl.CreatorId, tpl.CreatorId
+	defer util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+
+	now := time.Now()
+	end := now.Add(time.Minute * time.Duration(ar.Duration))
+	err = mysql.DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Create(asset).Error; err != nil {
+			return
+		}
+		if err = tx.Create(&model.History{
+			Type:       asset.TableName(),
+			TargetId:   asset.Id,
+			ActionType: model.ACTION_CREATE,
+			New:        toMap(asset),
+			CreatorId:  tpl.CreatorId,
+			CreatedAt:  now,
+		}).Error; err != nil {
+			return
+		}
+		if err = tx.Model(ar).Updates(map[string]any{"asset_id": asset.Id, "end": end}).Error; err != nil {
+			return
+		}
+		res := tx.Model(l).Where("status = ?", model.LABSTATUS_PROVISIONING).
+			Updates(map[string]any{"status": model.LABSTATUS_READY, "asset_id": asset.Id, "ip": l.Ip, "ready_at": now})
+		if res.Error == nil && res.RowsAffected == 0 {
+			return fmt.Errorf("lab %d is not provisioning", l.Id)
+		}
+		return res.Error
+	})
+	if err != nil {
+		acl.DeleteResource(ctx, tpl.CreatorId, asset.ResourceId)
+		return
+	}
+	l.Status, l.AssetId = model.LABSTATUS_READY, asset.Id
+	logger.L().Info("lab is ready", zap.Int("id", l.Id), zap.String("template", tpl.Name), zap.String("user", l.UserName),
+		zap.Int("assetId", asset.Id), zap.Time("end", end))
+
+	return
+}
+
+// fail marks the lab failed and deprovisions whatever the orchestrator has created
+func fail(ctx context.Context, tpl *model.LabTemplate, l *model.Lab, msg string) {
+	res := mysql.DB.Model(l).Where("status = ?", model.LABSTATUS_PROVISIONING).
+		Updates(map[string]any{"status": model.LABSTATUS_FAILED, "message": msg})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	l.Status = model.LABSTATUS_FAILED
+	logger.L().Warn("provision lab failed", zap.Int("id", l.Id), zap.String("template", tpl.Name), zap.String("err", msg))
+	if err := call(tpl, deprovisionUrl(tpl), ACTION_DEPROVISION, l, nil, nil); err != nil {
+		logger.L().Warn("deprovision failed lab failed", zap.Int("id", l.Id), zap.Error(err))
+	}
+}
+
+// expire deprovisions the lab and deletes its asset, the deletion is kept in histories like the creation
+func expire(ctx context.Context, tpl *model.LabTemplate, l *model.Lab, ar *model.AccessRequest) {
+	msg := ""
+	if err := call(tpl, deprovisionUrl(tpl), ACTION_DEPROVISION, l, ar, nil); err != nil {
+		// the asset is deleted anyway since the access has ended, admins clean up by the message
+		msg = err.Error()
+		logger.L().Warn("deprovision lab failed", zap.Int("id", l.Id), zap.Error(err))
+	}
+
+	asset := &model.Asset{}
+	if err := mysql.DB.Model(asset).Where("id = ?", l.AssetId).First(asset).Error; err == nil {
+		if err = mysql.DB.Transaction(func(tx *gorm.DB) (err error) {
+			if err = tx.Delete(asset).Error; err != nil {
+				return
+			}
+			return tx.Create(&model.History{
+				Type:       asset.TableName(),
+				TargetId:   asset.Id,
+				ActionType: model.ACTION_DELETE,
+				Old:        toMap(asset),
+				CreatorId:  tpl.CreatorId,
+				CreatedAt:  time.Now(),
+			}).Error
+		}); err != nil {
+			logger.L().Error("delete asset of lab failed", zap.Int("id", l.Id), zap.Int("assetId", asset.Id), zap.Error(err))
+			return
+		}
+		acl.DeleteResource(ctx, tpl.CreatorId, asset.ResourceId)
+		util.DeleteAllFromCacheDb(ctx, model.DefaultAsset)
+	}
+
+	if err := mysql.DB.Model(l).Updates(map[string]any{"status": model.LABSTATUS_EXPIRED, "message": msg, "expired_at": time.Now()}).Error; err != nil {
+		logger.L().Error("expire lab failed", zap.Int("id", l.Id), zap.Error(err))
+		return
+	}
+	logger.L().Info("lab expired", zap.Int("id", l.Id), zap.String("template", tpl.Name), zap.String("user", l.UserName), zap.Int("assetId", l.AssetId))
+}
+
+func deprovisionUrl(tpl *model.LabTemplate) string {
+	return lo.Ternary(tpl.DeprovisionUrl != "", tpl.DeprovisionUrl, tpl.ProvisionUrl)
+}
+
+// call posts the action of the lab to the orchestrator, res takes the response if it is not nil
+func call(tpl *model.LabTemplate, url, action string, l *model.Lab, ar *model.AccessRequest, res any) (err error) {
+	body := map[string]any{
+		"action":      action,
+		"lab_id":      l.Id,
+		"external_id": l.ExternalId,
+		"template":    tpl.Name,
+		"params":      tpl.Params,
+		"user":        l.UserName,
+	}
+	if ar != nil {
+		body["access_request_id"], body["duration"] = ar.Id, ar.Duration
+	}
+	req := remote.RC.R().SetBody(body)
+	if tpl.Token != "" {
+		req.SetAuthToken(util.DecryptAES(tpl.Token))
+	}
+	if res != nil {
+		req.SetResult(res)
+	}
+	resp, err := req.Post(url)
+	if err != nil {
+		return
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s %s: %s", action, resp.Status(), resp.String())
+	}
+	return
+}
+
+func toMap(data any) model.Map[string, any] {
+	bs, _ := json.Marshal(data)
+	res := make(map[string]any)
+	json.Unmarshal(bs, &res)
+	return res
+}
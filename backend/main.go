@@ -7,10 +7,20 @@ import (
 	"syscall"
 
 	"github.com/oklog/run"
+	"github.com/veops/oneterm/alert"
 	"github.com/veops/oneterm/api"
+	"github.com/veops/oneterm/clickhouse"
+	"github.com/veops/oneterm/dbproxy"
+	"github.com/veops/oneterm/eventbus"
 	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/notify"
 	"github.com/veops/oneterm/schedule"
+	"github.com/veops/oneterm/session"
 	"github.com/veops/oneterm/sshsrv"
+	"github.com/veops/oneterm/status"
+	"github.com/veops/oneterm/syslog"
+	"github.com/veops/oneterm/tracing"
+	"github.com/veops/oneterm/webhook"
 	"go.uber.org/zap"
 )
 
@@ -38,6 +48,76 @@ func main() {
 			sshsrv.StopSsh()
 		})
 	}
+	{
+		rg.Add(func() error {
+			return dbproxy.RunDbProxy()
+		}, func(err error) {
+			dbproxy.StopDbProxy()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return clickhouse.RunSink()
+		}, func(err error) {
+			clickhouse.StopSink()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return eventbus.Run()
+		}, func(err error) {
+			eventbus.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return syslog.Run()
+		}, func(err error) {
+			syslog.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return webhook.Run()
+		}, func(err error) {
+			webhook.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return notify.Run()
+		}, func(err error) {
+			notify.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return alert.Run()
+		}, func(err error) {
+			alert.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return status.Run()
+		}, func(err error) {
+			status.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return tracing.Run()
+		}, func(err error) {
+			tracing.Stop()
+		})
+	}
+	{
+		rg.Add(func() error {
+			return session.RunControl()
+		}, func(err error) {
+			session.StopControl()
+		})
+	}
 	{
 		rg.Add(func() error {
 			return schedule.RunSchedule()
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	ACCESSCODE_INVALID = iota + 1
+	ACCESSCODE_TIME
+	ACCESSCODE_UNAUTHORIZED
+	ACCESSCODE_SHARE
+	ACCESSCODE_CREDENTIAL
+	ACCESSCODE_CONNECT
+	ACCESSCODE_OTHER
+)
+
+// AccessLog is a denied or failed connect attempt, successful ones are sessions
+type AccessLog struct {
+	Id          int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid         int    `json:"uid" gorm:"column:uid;index"`
+	UserName    string `json:"user_name" gorm:"column:user_name"`
+	AssetId     int    `json:"asset_id" gorm:"column:asset_id;index"`
+	AccountId   int    `json:"account_id" gorm:"column:account_id"`
+	Protocol    string `json:"protocol" gorm:"column:protocol"`
+	SessionType int    `json:"session_type" gorm:"column:session_type"`
+	ClientIp    string `json:"client_ip" gorm:"column:client_ip;size:64;index"`
+	Code        int    `json:"code" gorm:"column:code"`
+	Reason      string `json:"reason" gorm:"column:reason;type:text"`
+	TraceId     string `json:"trace_id" gorm:"column:trace_id;size:32"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (m *AccessLog) TableName() string {
+	return "access_log"
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+)
+
+// AccessRequest is submitted by a user without permission on an asset, it grants access to the account of the asset
+// from approval until End. Admins grant access directly by approved ones. Status is one of APPROVAL_STATUS_*.
+// Requests of lab templates provision a lab once approved, End counts from the lab being ready
+type AccessRequest struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid       int    `json:"uid" gorm:"column:uid;index"`
+	UserName  string `json:"user_name" gorm:"column:user_name"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id"`
+	AccountId int    `json:"account_id" gorm:"column:account_id"`
+	// TemplateId is the lab template requested, the asset is set once the lab is ready
+	TemplateId int `json:"template_id" gorm:"column:template_id"`
+	// Duration of the access after approval, unit is minute
+	Duration   int        `json:"duration" gorm:"column:duration"`
+	Reason     string     `json:"reason" gorm:"column:reason"`
+	Status     int        `json:"status" gorm:"column:status"`
+	ApproverId int        `json:"approver_id" gorm:"column:approver_id"`
+	Approver   string     `json:"approver" gorm:"column:approver"`
+	Comment    string     `json:"comment" gorm:"column:comment"`
+	End        *time.Time `json:"end" gorm:"column:end"`
+	Revoker    string     `json:"revoker" gorm:"column:revoker"`
+	RevokedAt  *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *AccessRequest) TableName() string {
+	return "access_request"
+}
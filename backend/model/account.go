@@ -1,11 +1,24 @@
 package model
 
 import (
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"gorm.io/plugin/soft_delete"
 )
 
+const (
+	POLICYVIOLATION_MIN_LENGTH = "min_length"
+	POLICYVIOLATION_UPPER      = "upper"
+	POLICYVIOLATION_LOWER      = "lower"
+	POLICYVIOLATION_DIGIT      = "digit"
+	POLICYVIOLATION_SIGN       = "sign"
+	// POLICYVIOLATION_OVERDUE the password is not rotated within the interval
+	POLICYVIOLATION_OVERDUE = "overdue"
+)
+
 type Account struct {
 	Id          int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
 	Name        string `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
@@ -14,6 +27,12 @@ type Account struct {
 	Password    string `json:"password" gorm:"column:password"`
 	Pk          string `json:"pk" gorm:"column:pk"`
 	Phrase      string `json:"phrase" gorm:"column:phrase"`
+	Cert        string `json:"cert" gorm:"column:cert;type:text"`
+	// CertExpiredAt is when cert expires, it is derived from cert
+	CertExpiredAt *time.Time     `json:"cert_expired_at,omitempty" gorm:"column:cert_expired_at"`
+	Credential    CredentialRef  `json:"credential" gorm:"embedded;embeddedPrefix:cred_"`
+	Rotation      Rotation       `json:"rotation" gorm:"embedded;embeddedPrefix:rotation_"`
+	Policy        PasswordPolicy `json:"policy" gorm:"embedded;embeddedPrefix:policy_"`
 
 	Permissions []string              `json:"permissions" gorm:"-"`
 	ResourceId  int                   `json:"resource_id" gorm:"column:resource_id"`
@@ -26,6 +45,86 @@ type Account struct {
 	AssetCount int64 `json:"asset_count" gorm:"-"`
 }
 
+// CredentialRef locates secrets of account in an external provider, saved secrets are ignored if provider is set.
+// Meanings of other fields depend on the provider
+type CredentialRef struct {
+	Provider string `json:"provider" gorm:"column:provider"`
+	Engine   string `json:"engine" gorm:"column:engine"`
+	Path     string `json:"path" gorm:"column:path"`
+	Role     string `json:"role" gorm:"column:role"`
+}
+
+// Rotation changes the password of account on its assets every interval days
+type Rotation struct {
+	Enable    bool       `json:"enable" gorm:"column:enable"`
+	Interval  int        `json:"interval" gorm:"column:interval"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" gorm:"column:rotated_at"`
+}
+
+// PasswordPolicy requires passwords to be rotated every interval days and to be complex, zero fields require nothing.
+// Policies of nodes are inherited by their child nodes and by accounts authorized on their assets, enabled ones of
+// nodes or accounts override the inherited ones. The interval takes over the one of rotation if it is set
+type PasswordPolicy struct {
+	Enable    bool `json:"enable" gorm:"column:enable"`
+	Interval  int  `json:"interval" gorm:"column:interval"`
+	MinLength int  `json:"min_length" gorm:"column:min_length"`
+	Upper     bool `json:"upper" gorm:"column:upper"`
+	Lower     bool `json:"lower" gorm:"column:lower"`
+	Digit     bool `json:"digit" gorm:"column:digit"`
+	Sign      bool `json:"sign" gorm:"column:sign"`
+}
+
+// Merge returns the stricter of each requirement of m and p, accounts on assets of several nodes take all of them
+func (m PasswordPolicy) Merge(p PasswordPolicy) PasswordPolicy {
+	if !m.Enable {
+		return p
+	}
+	if !p.Enable {
+		return m
+	}
+	if m.Interval <= 0 || (p.Interval > 0 && p.Interval < m.Interval) {
+		m.Interval = p.Interval
+	}
+	m.MinLength = max(m.MinLength, p.MinLength)
+	m.Upper, m.Lower, m.Digit, m.Sign = m.Upper || p.Upper, m.Lower || p.Lower, m.Digit || p.Digit, m.Sign || p.Sign
+	return m
+}
+
+// Violations returns the requirements of complexity the password fails
+func (m PasswordPolicy) Violations(password string) (res []string) {
+	if !m.Enable {
+		return
+	}
+	has := func(f func(rune) bool) bool { return strings.IndexFunc(password, f) >= 0 }
+	if utf8.RuneCountInString(password) < m.MinLength {
+		res = append(res, POLICYVIOLATION_MIN_LENGTH)
+	}
+	if m.Upper && !has(unicode.IsUpper) {
+		res = append(res, POLICYVIOLATION_UPPER)
+	}
+	if m.Lower && !has(unicode.IsLower) {
+		res = append(res, POLICYVIOLATION_LOWER)
+	}
+	if m.Digit && !has(unicode.IsDigit) {
+		res = append(res, POLICYVIOLATION_DIGIT)
+	}
+	if m.Sign && !has(func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) }) {
+		res = append(res, POLICYVIOLATION_SIGN)
+	}
+	return
+}
+
+// PolicyCompliance is an account violating its effective policy, inherited is false if the policy is its own
+type PolicyCompliance struct {
+	AccountId  int            `json:"account_id"`
+	Name       string         `json:"name"`
+	Account    string         `json:"account"`
+	Policy     PasswordPolicy `json:"policy"`
+	Inherited  bool           `json:"inherited"`
+	RotatedAt  *time.Time     `json:"rotated_at,omitempty"`
+	Violations []string       `json:"violations"`
+}
+
 func (m *Account) TableName() string {
 	return "account"
 }
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+// AgentKey is a private key of a user kept by oneterm, it signs as the forwarded agent in ssh sessions
+// on assets allowed by its scopes while the key itself never leaves oneterm. Empty scopes allow no asset
+type AgentKey struct {
+	Id          int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid         int        `json:"uid" gorm:"column:uid;index"`
+	UserName    string     `json:"user_name" gorm:"column:user_name"`
+	Name        string     `json:"name" gorm:"column:name;uniqueIndex:uid_name_del,priority:2;size:128"`
+	Comment     string     `json:"comment" gorm:"column:comment"`
+	Enable      bool       `json:"enable" gorm:"column:enable"`
+	Pk          string     `json:"pk,omitempty" gorm:"column:pk;type:text"`
+	Phrase      string     `json:"phrase,omitempty" gorm:"column:phrase"`
+	PublicKey   string     `json:"public_key" gorm:"column:public_key;type:text"`
+	Fingerprint string     `json:"fingerprint" gorm:"column:fingerprint"`
+	NodeIds     Slice[int] `json:"node_ids" gorm:"column:node_ids;type:text"`
+	AssetIds    Slice[int] `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id;uniqueIndex:uid_name_del,priority:1"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:uid_name_del,priority:3"`
+}
+
+func (m *AgentKey) TableName() string {
+	return "agent_key"
+}
+func (m *AgentKey) SetId(id int) {
+	m.Id = id
+}
+func (m *AgentKey) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *AgentKey) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *AgentKey) SetResourceId(resourceId int) {
+
+}
+func (m *AgentKey) GetResourceId() int {
+	return 0
+}
+func (m *AgentKey) GetName() string {
+	return m.Name
+}
+func (m *AgentKey) GetId() int {
+	return m.Id
+}
+
+func (m *AgentKey) SetPerms(perms []string) {}
+
+// InScope reports whether the asset may request signatures, nodeIds are the node and its ancestors of the asset
+func (m *AgentKey) InScope(assetId int, nodeIds []int) bool {
+	return lo.Contains(m.AssetIds, assetId) || len(lo.Intersect(m.NodeIds, nodeIds)) > 0
+}
+
+// AgentSignLog is a signature request of a target to the forwarded agent, denied ones are kept as well
+type AgentSignLog struct {
+	Id          int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId   string `json:"session_id" gorm:"column:session_id;size:128;index"`
+	Uid         int    `json:"uid" gorm:"column:uid;index"`
+	UserName    string `json:"user_name" gorm:"column:user_name"`
+	AssetId     int    `json:"asset_id" gorm:"column:asset_id;index"`
+	AccountId   int    `json:"account_id" gorm:"column:account_id"`
+	KeyId       int    `json:"key_id" gorm:"column:key_id;index"`
+	Fingerprint string `json:"fingerprint" gorm:"column:fingerprint"`
+	Algorithm   string `json:"algorithm" gorm:"column:algorithm"`
+	// DataHash is sha256 of the signed data, which is the session identifier and request of the next hop
+	DataHash string `json:"data_hash" gorm:"column:data_hash"`
+	Allowed  bool   `json:"allowed" gorm:"column:allowed"`
+	Reason   string `json:"reason" gorm:"column:reason"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (m *AgentSignLog) TableName() string {
+	return "agent_sign_log"
+}
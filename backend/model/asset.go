@@ -1,8 +1,11 @@
 package model
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/samber/lo"
 	"gorm.io/plugin/soft_delete"
 )
 
@@ -11,17 +14,34 @@ const (
 )
 
 type Asset struct {
-	Id            int                  `json:"id" gorm:"column:id;primarykey;autoIncrement"`
-	Name          string               `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
-	Comment       string               `json:"comment" gorm:"column:comment"`
-	ParentId      int                  `json:"parent_id" gorm:"column:parent_id"`
-	Ip            string               `json:"ip" gorm:"column:ip"`
-	Protocols     Slice[string]        `json:"protocols" gorm:"column:protocols;type:text"`
-	GatewayId     int                  `json:"gateway_id" gorm:"column:gateway_id"`
-	Authorization Map[int, Slice[int]] `json:"authorization" gorm:"column:authorization;type:text"`
-	AccessAuth    AccessAuth           `json:"access_auth" gorm:"embedded;column:access_auth"`
-	Connectable   bool                 `json:"connectable" gorm:"column:connectable"`
-	NodeChain     string               `json:"node_chain" gorm:"-"`
+	Id             int                  `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name           string               `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment        string               `json:"comment" gorm:"column:comment"`
+	ParentId       int                  `json:"parent_id" gorm:"column:parent_id"`
+	Ip             string               `json:"ip" gorm:"column:ip"`
+	Protocols      Slice[string]        `json:"protocols" gorm:"column:protocols;type:text"`
+	GatewayId      int                  `json:"gateway_id" gorm:"column:gateway_id"`
+	GatewayGroupId int                  `json:"gateway_group_id" gorm:"column:gateway_group_id"`
+	Authorization  Map[int, Slice[int]] `json:"authorization" gorm:"column:authorization;type:text"`
+	AccessAuth     AccessAuth           `json:"access_auth" gorm:"embedded;column:access_auth"`
+	Connectable    bool                 `json:"connectable" gorm:"column:connectable"`
+	RiskTags       Slice[string]        `json:"risk_tags" gorm:"column:risk_tags;type:text"`
+	Sudo           Sudo                 `json:"sudo" gorm:"embedded;embeddedPrefix:sudo_"`
+	MonitorDelay   int                  `json:"monitor_delay" gorm:"column:monitor_delay"`
+	Warmup         bool                 `json:"warmup" gorm:"column:warmup"`
+	Serial         Serial               `json:"serial" gorm:"embedded;embeddedPrefix:serial_"`
+	AgentForward   bool                 `json:"agent_forward" gorm:"column:agent_forward"`
+	X11            X11                  `json:"x11" gorm:"embedded;embeddedPrefix:x11_"`
+	ShellLog       ShellLog             `json:"shell_log" gorm:"embedded;embeddedPrefix:shell_log_"`
+	Cloud          Cloud                `json:"cloud" gorm:"embedded;embeddedPrefix:cloud_"`
+	HostInfo       HostInfo             `json:"host_info" gorm:"embedded;embeddedPrefix:host_"`
+	CiId           string               `json:"ci_id" gorm:"column:ci_id;size:128;index"`
+	Health         Health               `json:"health" gorm:"embedded;embeddedPrefix:health_"`
+	Concurrency    Concurrency          `json:"concurrency" gorm:"embedded;embeddedPrefix:concurrency_"`
+	Owners         Slice[Contact]       `json:"owners" gorm:"column:owners;type:text"`
+	Contacts       Slice[Contact]       `json:"contacts" gorm:"column:contacts;type:text"`
+	NodeChain      string               `json:"node_chain" gorm:"-"`
+	Warnings       []*AssetWarning      `json:"warnings" gorm:"-"`
 
 	Permissions []string              `json:"permissions" gorm:"-"`
 	ResourceId  int                   `json:"resource_id" gorm:"column:resource_id"`
@@ -40,6 +60,103 @@ type AccessAuth struct {
 	Allow  bool         `json:"allow" gorm:"column:allow"`
 }
 
+// Sudo grants cmds to the session account by a sudoers drop-in which is installed by the privileged account
+type Sudo struct {
+	AccountId int           `json:"account_id" gorm:"column:account_id"`
+	Cmds      Slice[string] `json:"cmds" gorm:"column:cmds;type:text"`
+}
+
+func (s Sudo) Enabled() bool {
+	return s.AccountId > 0 && len(s.Cmds) > 0
+}
+
+const (
+	SERIALMODE_RFC2217 = "rfc2217"
+	SERIALMODE_RAW     = "raw"
+)
+
+// Serial is the port of console server for serial protocol, rfc2217 ones are set to the line while raw ones
+// like ser2net are taken as they are configured
+type Serial struct {
+	Mode     string  `json:"mode" gorm:"column:mode"`
+	Baudrate int     `json:"baudrate" gorm:"column:baudrate"`
+	Databits int     `json:"databits" gorm:"column:databits"`
+	Parity   string  `json:"parity" gorm:"column:parity"`
+	Stopbits float64 `json:"stopbits" gorm:"column:stopbits"`
+}
+
+// X11 forwarding of ssh sessions is denied unless it is enabled, windows forwarded are captured
+// into the audit trail every interval seconds if capture is on
+type X11 struct {
+	Enable   bool `json:"enable" gorm:"column:enable"`
+	Capture  bool `json:"capture" gorm:"column:capture"`
+	Interval int  `json:"interval" gorm:"column:interval"`
+}
+
+const (
+	defaultShellLogDir     = "$HOME/.oneterm"
+	defaultShellLogCommand = "mkdir -p {dir} && exec script -q -f {dir}/{session}.log"
+)
+
+// ShellLog wraps shells of ssh sessions by script so that targets log sessions besides recordings of the bastion.
+// Command replaces the default one if it is set, {dir} and {session} in it are replaced by Dir and the session id.
+// Sessions fail rather than go unlogged if the command fails
+type ShellLog struct {
+	Enable  bool   `json:"enable" gorm:"column:enable"`
+	Dir     string `json:"dir" gorm:"column:dir"`
+	Command string `json:"command" gorm:"column:command"`
+}
+
+// Wrap returns the command started instead of the login shell
+func (s ShellLog) Wrap(sessionId string) string {
+	cmd := lo.Ternary(s.Command != "", s.Command, defaultShellLogCommand)
+	return strings.NewReplacer("{dir}", lo.Ternary(s.Dir != "", s.Dir, defaultShellLogDir), "{session}", sessionId).Replace(cmd)
+}
+
+// Cloud is the instance an asset is synchronized from, it is set by synchronizations only
+type Cloud struct {
+	AccountId  int                 `json:"account_id" gorm:"column:account_id;index"`
+	InstanceId string              `json:"instance_id" gorm:"column:instance_id;size:128"`
+	Region     string              `json:"region" gorm:"column:region"`
+	Tags       Map[string, string] `json:"tags" gorm:"column:tags;type:text"`
+}
+
+// Concurrency limits online sessions of the asset, connections beyond Max are rejected unless Queue is on.
+// Queued web connections start in the order they come once slots are freed, or fail after Timeout seconds
+type Concurrency struct {
+	Max     int  `json:"max" gorm:"column:max"`
+	Queue   bool `json:"queue" gorm:"column:queue"`
+	Timeout int  `json:"timeout" gorm:"column:timeout"`
+}
+
+// HostInfo is what the target reports by uname and hostname when a ssh session starts
+type HostInfo struct {
+	Hostname string     `json:"hostname" gorm:"column:hostname"`
+	Os       string     `json:"os" gorm:"column:os"`
+	Kernel   string     `json:"kernel" gorm:"column:kernel"`
+	Build    string     `json:"build" gorm:"column:build"`
+	Arch     string     `json:"arch" gorm:"column:arch"`
+	ProbedAt *time.Time `json:"probed_at" gorm:"column:probed_at"`
+}
+
+const (
+	CONTACT_TYPE_USER = "user"
+	CONTACT_TYPE_ROLE = "role"
+)
+
+// Contact is a user or a role of acl responsible for assets. Owners take reviews and approvals of their assets first,
+// contacts are told of maintenance of them, users denied are pointed to both
+type Contact struct {
+	Type string `json:"type"`
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Escalation returns owners then contacts of the asset, each of them once
+func (m *Asset) Escalation() []Contact {
+	return lo.UniqBy(append(append([]Contact{}, m.Owners...), m.Contacts...), func(c Contact) string { return c.Type + ":" + strconv.Itoa(c.Id) })
+}
+
 type Range struct {
 	Week  int           `json:"week" gorm:"column:week"`
 	Times Slice[string] `json:"times" gorm:"column:times"`
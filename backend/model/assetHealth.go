@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	HEALTHSTATUS_UP      = "up"
+	HEALTHSTATUS_PARTIAL = "partial"
+	HEALTHSTATUS_DOWN    = "down"
+)
+
+// Health is the result of the latest check of protocol ports of an asset, status is empty if it is never checked
+type Health struct {
+	Status string `json:"status" gorm:"column:status"`
+	// Latency is the slowest one of reachable ports, unit is ms
+	Latency   int        `json:"latency" gorm:"column:latency"`
+	CheckedAt *time.Time `json:"checked_at,omitempty" gorm:"column:checked_at"`
+}
+
+// AssetHealth is a check of a protocol port of an asset
+type AssetHealth struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id;index:asset_created"`
+	Protocol  string `json:"protocol" gorm:"column:protocol"`
+	Reachable bool   `json:"reachable" gorm:"column:reachable"`
+	Latency   int    `json:"latency" gorm:"column:latency"`
+	Banner    string `json:"banner" gorm:"column:banner"`
+	Message   string `json:"message" gorm:"column:message"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index:asset_created;index"`
+}
+
+func (m *AssetHealth) TableName() string {
+	return "asset_health"
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	WARNINGTYPE_INCIDENT      = "incident"
+	WARNINGTYPE_VULNERABILITY = "vulnerability"
+
+	SEVERITY_LOW      = "low"
+	SEVERITY_MEDIUM   = "medium"
+	SEVERITY_HIGH     = "high"
+	SEVERITY_CRITICAL = "critical"
+)
+
+var (
+	severityLevels = map[string]int{SEVERITY_LOW: 1, SEVERITY_MEDIUM: 2, SEVERITY_HIGH: 3, SEVERITY_CRITICAL: 4}
+)
+
+// AssetWarning is an open incident or vulnerability of an asset reported by an external system,
+// it is removed once it is resolved there
+type AssetWarning struct {
+	Id         int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	AssetId    int    `json:"asset_id" gorm:"column:asset_id;uniqueIndex:source_external_asset"`
+	Source     string `json:"source" gorm:"column:source;size:64;uniqueIndex:source_external_asset"`
+	ExternalId string `json:"external_id" gorm:"column:external_id;size:128;uniqueIndex:source_external_asset"`
+	Type       string `json:"type" gorm:"column:type"`
+	Severity   string `json:"severity" gorm:"column:severity"`
+	Title      string `json:"title" gorm:"column:title"`
+	Url        string `json:"url" gorm:"column:url"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *AssetWarning) TableName() string {
+	return "asset_warning"
+}
+
+// AtLeast reports whether the severity is not lower than severity, unknown ones are the lowest
+func (m *AssetWarning) AtLeast(severity string) bool {
+	return severityLevels[severity] > 0 && severityLevels[m.Severity] >= severityLevels[severity]
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	CANARYSTAGE_PREPARE = "prepare"
+	CANARYSTAGE_CONNECT = "connect"
+	CANARYSTAGE_SESSION = "session"
+	CANARYSTAGE_OUTPUT  = "output"
+)
+
+// CanaryRun is a synthetic session of a canary target, stage is where it failed and it is empty if it succeeded
+type CanaryRun struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id;index:asset_created"`
+	AccountId int    `json:"account_id" gorm:"column:account_id"`
+	Protocol  string `json:"protocol" gorm:"column:protocol"`
+	Success   bool   `json:"success" gorm:"column:success"`
+	// Latency is from dialing to the first screen or output, unit is ms
+	Latency int    `json:"latency" gorm:"column:latency"`
+	Stage   string `json:"stage" gorm:"column:stage"`
+	Message string `json:"message" gorm:"column:message"`
+	// Failures in a row of the target until this one
+	Failures int `json:"failures" gorm:"column:failures"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index:asset_created;index"`
+}
+
+func (m *CanaryRun) TableName() string {
+	return "canary_run"
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	CLIPBOARD_COPY  = "copy"
+	CLIPBOARD_PASTE = "paste"
+)
+
+// ClipboardPolicy restricts clipboards of rdp and vnc sessions on top of the global config, empty scopes mean all.
+// Sessions covered by several policies take the strictest of them, MaxSize is in bytes and 0 means no limit
+type ClipboardPolicy struct {
+	Id       int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name     string     `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment  string     `json:"comment" gorm:"column:comment"`
+	Enable   bool       `json:"enable" gorm:"column:enable"`
+	Copy     bool       `json:"copy" gorm:"column:copy"`
+	Paste    bool       `json:"paste" gorm:"column:paste"`
+	MaxSize  int        `json:"max_size" gorm:"column:max_size"`
+	Record   bool       `json:"record" gorm:"column:record"`
+	Uids     Slice[int] `json:"uids" gorm:"column:uids;type:text"`
+	Rids     Slice[int] `json:"rids" gorm:"column:rids;type:text"`
+	AssetIds Slice[int] `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *ClipboardPolicy) TableName() string {
+	return "clipboard_policy"
+}
+func (m *ClipboardPolicy) SetId(id int) {
+	m.Id = id
+}
+func (m *ClipboardPolicy) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *ClipboardPolicy) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *ClipboardPolicy) SetResourceId(resourceId int) {
+
+}
+func (m *ClipboardPolicy) GetResourceId() int {
+	return 0
+}
+func (m *ClipboardPolicy) GetName() string {
+	return m.Name
+}
+func (m *ClipboardPolicy) GetId() int {
+	return m.Id
+}
+
+func (m *ClipboardPolicy) SetPerms(perms []string) {}
+
+func (m *ClipboardPolicy) InScope(uid, rid, assetId int) bool {
+	in := func(s Slice[int], id int) bool { return len(s) == 0 || lo.Contains(s, id) }
+	return in(m.Uids, uid) && in(m.Rids, rid) && in(m.AssetIds, assetId)
+}
+
+// ClipboardLog is a clipboard transfer of a rdp or vnc session, Content is kept for text allowed by policies recording it
+type ClipboardLog struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId string `json:"session_id" gorm:"column:session_id;size:128;index"`
+	Uid       int    `json:"uid" gorm:"column:uid"`
+	UserName  string `json:"user_name" gorm:"column:user_name"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id"`
+	Direction string `json:"direction" gorm:"column:direction"`
+	Mimetype  string `json:"mimetype" gorm:"column:mimetype"`
+	Size      int    `json:"size" gorm:"column:size"`
+	Content   string `json:"content" gorm:"column:content;type:text"`
+	Blocked   bool   `json:"blocked" gorm:"column:blocked"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (m *ClipboardLog) TableName() string {
+	return "clipboard_log"
+}
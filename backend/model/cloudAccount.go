@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	CLOUDPROVIDER_AWS     = "aws"
+	CLOUDPROVIDER_ALIYUN  = "aliyun"
+	CLOUDPROVIDER_TENCENT = "tencent"
+)
+
+// CloudAccount imports instances of its regions as assets under the node of ParentId every interval minutes.
+// Instances with the tag of TagKey are put into child nodes named by the tag value, which are created if missing
+type CloudAccount struct {
+	Id        int           `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name      string        `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment   string        `json:"comment" gorm:"column:comment"`
+	Provider  string        `json:"provider" gorm:"column:provider"`
+	AccessKey string        `json:"access_key" gorm:"column:access_key"`
+	SecretKey string        `json:"secret_key,omitempty" gorm:"column:secret_key"`
+	Regions   Slice[string] `json:"regions" gorm:"column:regions;type:text"`
+	ParentId  int           `json:"parent_id" gorm:"column:parent_id"`
+	GatewayId int           `json:"gateway_id" gorm:"column:gateway_id"`
+	TagKey    string        `json:"tag_key" gorm:"column:tag_key"`
+	// PublicIp imports public addresses instead of private ones
+	PublicIp bool `json:"public_ip" gorm:"column:public_ip"`
+	// Protocols of imported assets, ssh:22 for linux and rdp:3389 for windows if it is empty
+	Protocols Slice[string] `json:"protocols" gorm:"column:protocols;type:text"`
+	Interval  int           `json:"interval" gorm:"column:interval"`
+	Enable    bool          `json:"enable" gorm:"column:enable"`
+	SyncedAt  *time.Time    `json:"synced_at" gorm:"column:synced_at"`
+	Message   string        `json:"message" gorm:"column:message;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *CloudAccount) TableName() string {
+	return "cloud_account"
+}
+func (m *CloudAccount) SetId(id int) {
+	m.Id = id
+}
+func (m *CloudAccount) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *CloudAccount) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *CloudAccount) SetResourceId(resourceId int) {
+
+}
+func (m *CloudAccount) GetResourceId() int {
+	return 0
+}
+func (m *CloudAccount) GetName() string {
+	return m.Name
+}
+func (m *CloudAccount) GetId() int {
+	return m.Id
+}
+
+func (m *CloudAccount) SetPerms(perms []string) {}
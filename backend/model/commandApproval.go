@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	APPROVAL_STATUS_PENDING = iota + 1
+	APPROVAL_STATUS_APPROVED
+	APPROVAL_STATUS_REJECTED
+	APPROVAL_STATUS_TIMEOUT
+)
+
+// CommandApproval is created when a command matches a policy needs approval
+type CommandApproval struct {
+	Id         int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId  string `json:"session_id" gorm:"column:session_id;index"`
+	Uid        int    `json:"uid" gorm:"column:uid"`
+	UserName   string `json:"user_name" gorm:"column:user_name"`
+	AssetId    int    `json:"asset_id" gorm:"column:asset_id"`
+	AccountId  int    `json:"account_id" gorm:"column:account_id"`
+	Cmd        string `json:"cmd" gorm:"column:cmd"`
+	Rule       string `json:"rule" gorm:"column:rule"`
+	Status     int    `json:"status" gorm:"column:status"`
+	ApproverId int    `json:"approver_id" gorm:"column:approver_id"`
+	Approver   string `json:"approver" gorm:"column:approver"`
+	Reason     string `json:"reason" gorm:"column:reason"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *CommandApproval) TableName() string {
+	return "command_approval"
+}
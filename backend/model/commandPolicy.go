@@ -0,0 +1,101 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	POLICY_ACTION_BLOCK = iota + 1
+	POLICY_ACTION_WARN
+	POLICY_ACTION_CONFIRM
+	POLICY_ACTION_APPROVE
+)
+
+// CommandPolicy matches commands typed in ssh sessions, empty scopes mean all
+type CommandPolicy struct {
+	Id         int           `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name       string        `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Patterns   Slice[string] `json:"patterns" gorm:"column:patterns;type:text"`
+	IsRe       bool          `json:"is_re" gorm:"column:is_re"`
+	Action     int           `json:"action" gorm:"column:action"`
+	Priority   int           `json:"priority" gorm:"column:priority"`
+	Enable     bool          `json:"enable" gorm:"column:enable"`
+	Uids       Slice[int]    `json:"uids" gorm:"column:uids;type:text"`
+	Rids       Slice[int]    `json:"rids" gorm:"column:rids;type:text"`
+	AssetIds   Slice[int]    `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+	AccountIds Slice[int]    `json:"account_ids" gorm:"column:account_ids;type:text"`
+
+	Res []*regexp.Regexp `json:"-" gorm:"-"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *CommandPolicy) TableName() string {
+	return "command_policy"
+}
+func (m *CommandPolicy) SetId(id int) {
+	m.Id = id
+}
+func (m *CommandPolicy) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *CommandPolicy) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *CommandPolicy) SetResourceId(resourceId int) {
+
+}
+func (m *CommandPolicy) GetResourceId() int {
+	return 0
+}
+func (m *CommandPolicy) GetName() string {
+	return m.Name
+}
+func (m *CommandPolicy) GetId() int {
+	return m.Id
+}
+
+func (m *CommandPolicy) SetPerms(perms []string) {}
+
+// Compile compiles patterns if it is regexp policy
+func (m *CommandPolicy) Compile() (err error) {
+	if !m.IsRe {
+		return
+	}
+	m.Res = make([]*regexp.Regexp, 0, len(m.Patterns))
+	for _, p := range m.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		m.Res = append(m.Res, re)
+	}
+	return
+}
+
+func (m *CommandPolicy) InScope(uid, rid, assetId, accountId int) bool {
+	in := func(s Slice[int], id int) bool { return len(s) == 0 || lo.Contains(s, id) }
+	return in(m.Uids, uid) && in(m.Rids, rid) && in(m.AssetIds, assetId) && in(m.AccountIds, accountId)
+}
+
+// Match returns the matched pattern
+func (m *CommandPolicy) Match(cmd string) (string, bool) {
+	if m.IsRe {
+		for i, re := range m.Res {
+			if re.MatchString(cmd) {
+				return m.Patterns[i], true
+			}
+		}
+		return "", false
+	}
+	return lo.Find(m.Patterns, func(p string) bool { return p != "" && strings.Contains(cmd, p) })
+}
@@ -15,21 +15,84 @@ type SshConfig struct {
 	Copy  bool `json:"copy" gorm:"column:copy"`
 	Paste bool `json:"paste" gorm:"column:paste"`
 }
+
+const (
+	RDPENDACTION_NONE = iota
+	// RDPENDACTION_LOCK presses win+l on the remote desktop before disconnection
+	RDPENDACTION_LOCK
+	// RDPENDACTION_SCRIPT runs the script by winrm as the account of the session, it works even if the connection is broken
+	RDPENDACTION_SCRIPT
+)
+
 type RdpConfig struct {
 	Copy  bool `json:"copy" gorm:"column:copy"`
 	Paste bool `json:"paste" gorm:"column:paste"`
+	// Drive redirects a drive of guacd to remote desktops, files transferred by it are audited
+	Drive bool `json:"drive" gorm:"column:drive"`
+	// EndAction is taken when a session ends so that the remote desktop is not left unlocked
+	EndAction int    `json:"end_action" gorm:"column:end_action"`
+	EndScript string `json:"end_script" gorm:"column:end_script;type:text"`
 }
 type VncConfig struct {
 	Copy  bool `json:"copy" gorm:"column:copy"`
 	Paste bool `json:"paste" gorm:"column:paste"`
 }
 
+type ChangeFreeze struct {
+	Enable bool       `json:"enable" gorm:"column:enable"`
+	Reason string     `json:"reason" gorm:"column:reason"`
+	Start  *time.Time `json:"start,omitempty" gorm:"column:start"`
+	End    *time.Time `json:"end,omitempty" gorm:"column:end"`
+}
+
+// InEffect reports whether the change freeze covers t
+func (m *ChangeFreeze) InEffect(t time.Time) bool {
+	if !m.Enable {
+		return false
+	}
+	return (m.Start == nil || !t.Before(*m.Start)) && (m.End == nil || !t.After(*m.End))
+}
+
+// SelfService lets users review their own sessions without asking admins
+type SelfService struct {
+	Enable bool `json:"enable" gorm:"column:enable"`
+	Replay bool `json:"replay" gorm:"column:replay"`
+	// Days sessions older than it are not accessible, 0 means no limit
+	Days int `json:"days" gorm:"column:days"`
+}
+
+// Covers reports whether the user could review the session by self service
+func (m *SelfService) Covers(session *Session, uid int) bool {
+	return m.Enable && session.Uid == uid &&
+		(m.Days <= 0 || session.CreatedAt.After(time.Now().AddDate(0, 0, -m.Days)))
+}
+
+// Review assigns flagged sessions to Reviewers by Strategy, reviews not done in Sla hours are escalated
+type Review struct {
+	Reviewers Slice[Reviewer] `json:"reviewers" gorm:"column:reviewers;type:text"`
+	Strategy  string          `json:"strategy" gorm:"column:strategy"`
+	Sla       int             `json:"sla" gorm:"column:sla"`
+}
+
+type Reviewer struct {
+	Uid      int    `json:"uid"`
+	UserName string `json:"user_name"`
+}
+
 type Config struct {
-	Id        int       `json:"id" gorm:"column:id;primarykey;autoIncrement"`
-	Timeout   int       `json:"timeout" gorm:"column:timeout"`
-	SshConfig SshConfig `json:"ssh_config" gorm:"embedded;embeddedPrefix:ssh_;column:ssh_config"`
-	RdpConfig RdpConfig `json:"rdp_config" gorm:"embedded;embeddedPrefix:rdp_;column:rdp_config"`
-	VncConfig VncConfig `json:"vnc_config" gorm:"embedded;embeddedPrefix:vnc_;column:vnc_config"`
+	Id           int          `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Timeout      int          `json:"timeout" gorm:"column:timeout"`
+	SshConfig    SshConfig    `json:"ssh_config" gorm:"embedded;embeddedPrefix:ssh_;column:ssh_config"`
+	RdpConfig    RdpConfig    `json:"rdp_config" gorm:"embedded;embeddedPrefix:rdp_;column:rdp_config"`
+	VncConfig    VncConfig    `json:"vnc_config" gorm:"embedded;embeddedPrefix:vnc_;column:vnc_config"`
+	ChangeFreeze ChangeFreeze `json:"change_freeze" gorm:"embedded;embeddedPrefix:freeze_;column:change_freeze"`
+	SelfService  SelfService  `json:"self_service" gorm:"embedded;embeddedPrefix:self_;column:self_service"`
+	Review       Review       `json:"review" gorm:"embedded;embeddedPrefix:review_;column:review"`
+	// KeyMapping maps shortcuts bound by web clients to combinations sent to rdp and vnc, e.g. ctrl+alt+end to ctrl+alt+del
+	KeyMapping Map[string, string] `json:"key_mapping" gorm:"column:key_mapping;type:text"`
+	// ResumeGrace is the seconds terminal sessions of web wait for their owners to come back after websockets drop,
+	// 0 means they end at once
+	ResumeGrace int `json:"resume_grace" gorm:"column:resume_grace"`
 
 	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
 	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
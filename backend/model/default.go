@@ -1,17 +1,53 @@
 package model
 
 var (
-	DefaultAccount       = &Account{}
-	DefaultAsset         = &Asset{}
-	DefaultAuthorization = &Authorization{}
-	DefaultCommand       = &Command{}
-	DefaultConfig        = &Config{}
-	DefaultFileHistory   = &FileHistory{}
-	DefaultGateway       = &Gateway{}
-	DefaultHistory       = &History{}
-	DefaultNode          = &Node{}
-	DefaultPublicKey     = &PublicKey{}
-	DefaultSession       = &Session{}
-	DefaultSessionCmd    = &SessionCmd{}
-	DefaultShare         = &Share{}
+	DefaultAccessLog         = &AccessLog{}
+	DefaultAccessRequest     = &AccessRequest{}
+	DefaultAccount           = &Account{}
+	DefaultAgentKey          = &AgentKey{}
+	DefaultAgentSignLog      = &AgentSignLog{}
+	DefaultAsset             = &Asset{}
+	DefaultAssetHealth       = &AssetHealth{}
+	DefaultAssetWarning      = &AssetWarning{}
+	DefaultAuthorization     = &Authorization{}
+	DefaultCanaryRun         = &CanaryRun{}
+	DefaultClipboardLog      = &ClipboardLog{}
+	DefaultClipboardPolicy   = &ClipboardPolicy{}
+	DefaultCloudAccount      = &CloudAccount{}
+	DefaultCommand           = &Command{}
+	DefaultCommandApproval   = &CommandApproval{}
+	DefaultCommandPolicy     = &CommandPolicy{}
+	DefaultConfig            = &Config{}
+	DefaultDiscoveredAsset   = &DiscoveredAsset{}
+	DefaultFeatureFlag       = &FeatureFlag{}
+	DefaultFileHistory       = &FileHistory{}
+	DefaultFileTransfer      = &FileTransfer{}
+	DefaultGateway           = &Gateway{}
+	DefaultGatewayGroup      = &GatewayGroup{}
+	DefaultHistory           = &History{}
+	DefaultLab               = &Lab{}
+	DefaultLabTemplate       = &LabTemplate{}
+	DefaultMacro             = &Macro{}
+	DefaultMaintenanceWindow = &MaintenanceWindow{}
+	DefaultMfaPolicy         = &MfaPolicy{}
+	DefaultNode              = &Node{}
+	DefaultNotification      = &Notification{}
+	DefaultPreference        = &Preference{}
+	DefaultPublicKey         = &PublicKey{}
+	DefaultReplayLog         = &ReplayLog{}
+	DefaultRotationHistory   = &RotationHistory{}
+	DefaultSession           = &Session{}
+	DefaultSessionCmd        = &SessionCmd{}
+	DefaultSessionReview     = &SessionReview{}
+	DefaultSessionTakeover   = &SessionTakeover{}
+	DefaultShare             = &Share{}
+	DefaultSshCa             = &SshCa{}
+	DefaultStepUp            = &StepUp{}
+	DefaultStepUpCredential  = &StepUpCredential{}
+	DefaultUsage             = &Usage{}
+	DefaultWatermarkPolicy   = &WatermarkPolicy{}
+	DefaultWebhook           = &Webhook{}
+	DefaultWebhookDelivery   = &WebhookDelivery{}
+	DefaultWorkspace         = &Workspace{}
+	DefaultX11Capture        = &X11Capture{}
 )
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	DISCOVERYSTATUS_PENDING = iota + 1
+	DISCOVERYSTATUS_IMPORTED
+	DISCOVERYSTATUS_IGNORED
+)
+
+// DiscoveredAsset is a host found by network scans waiting for review, protocols are in the same format of assets
+type DiscoveredAsset struct {
+	Id        int           `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Ip        string        `json:"ip" gorm:"column:ip;uniqueIndex;size:64"`
+	Hostname  string        `json:"hostname" gorm:"column:hostname"`
+	Protocols Slice[string] `json:"protocols" gorm:"column:protocols;type:text"`
+	Banner    string        `json:"banner" gorm:"column:banner"`
+	Status    int           `json:"status" gorm:"column:status"`
+	AssetId   int           `json:"asset_id" gorm:"column:asset_id"`
+	LastSeen  time.Time     `json:"last_seen" gorm:"column:last_seen"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *DiscoveredAsset) TableName() string {
+	return "discovered_asset"
+}
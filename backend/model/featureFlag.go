@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+// FeatureFlag turns a capability on for a share of users, Rollout is the percent of users and 0 means all.
+// Overrides of users or roles take precedence over the flag, the first one of the user wins over ones of the role
+type FeatureFlag struct {
+	Id        int                    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name      string                 `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment   string                 `json:"comment" gorm:"column:comment"`
+	Enable    bool                   `json:"enable" gorm:"column:enable"`
+	Rollout   int                    `json:"rollout" gorm:"column:rollout"`
+	Overrides Slice[FeatureOverride] `json:"overrides" gorm:"column:overrides;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+// FeatureOverride is the state of a flag for a user or a role
+type FeatureOverride struct {
+	Uid    int  `json:"uid"`
+	Rid    int  `json:"rid"`
+	Enable bool `json:"enable"`
+}
+
+func (m *FeatureFlag) TableName() string {
+	return "feature_flag"
+}
+func (m *FeatureFlag) SetId(id int) {
+	m.Id = id
+}
+func (m *FeatureFlag) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *FeatureFlag) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *FeatureFlag) SetResourceId(resourceId int) {
+
+}
+func (m *FeatureFlag) GetResourceId() int {
+	return 0
+}
+func (m *FeatureFlag) GetName() string {
+	return m.Name
+}
+func (m *FeatureFlag) GetId() int {
+	return m.Id
+}
+
+func (m *FeatureFlag) SetPerms(perms []string) {}
@@ -9,6 +9,7 @@ const (
 	FILE_ACTION_MKDIR
 	FILE_ACTION_UPLOAD
 	FILE_ACTION_DOWNLOAD
+	FILE_ACTION_RM
 )
 
 type FileHistory struct {
@@ -21,6 +22,7 @@ type FileHistory struct {
 	Action    int    `json:"action" gorm:"column:action"`
 	Dir       string `json:"dir" gorm:"column:dir"`
 	Filename  string `json:"filename" gorm:"column:filename"`
+	SessionId string `json:"session_id" gorm:"column:session_id"`
 
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	FILETRANSFER_UPLOAD   = "upload"
+	FILETRANSFER_DOWNLOAD = "download"
+
+	FILETRANSFER_SFTP   = "sftp"
+	FILETRANSFER_RDP    = "rdp"
+	FILETRANSFER_ZMODEM = "zmodem"
+)
+
+// FileTransfer is a file uploaded to or downloaded from an asset, Sha256 is the hex digest of its content
+type FileTransfer struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid       int    `json:"uid" gorm:"column:uid;index"`
+	UserName  string `json:"user_name" gorm:"column:user_name"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id;index"`
+	AccountId int    `json:"account_id" gorm:"column:account_id"`
+	SessionId string `json:"session_id" gorm:"column:session_id;size:128;index"`
+	ClientIp  string `json:"client_ip" gorm:"column:client_ip"`
+	Source    string `json:"source" gorm:"column:source"`
+	Direction string `json:"direction" gorm:"column:direction"`
+	Dir       string `json:"dir" gorm:"column:dir"`
+	Filename  string `json:"filename" gorm:"column:filename"`
+	Size      int64  `json:"size" gorm:"column:size"`
+	Sha256    string `json:"sha256" gorm:"column:sha256;size:64;index"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (m *FileTransfer) TableName() string {
+	return "file_transfer"
+}
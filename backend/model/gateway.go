@@ -6,9 +6,26 @@ import (
 	"gorm.io/plugin/soft_delete"
 )
 
+const (
+	// DNSMODE_REMOTE hostnames of assets are resolved by the gateway host
+	DNSMODE_REMOTE = iota
+	// DNSMODE_LOCAL hostnames of assets are resolved by oneterm before dialing through gateway
+	DNSMODE_LOCAL
+)
+
+const (
+	// GATEWAYTYPE_SSH jump host dialing assets by direct-tcpip
+	GATEWAYTYPE_SSH = iota
+	// GATEWAYTYPE_SOCKS5 socks5 proxy, account and password are optional
+	GATEWAYTYPE_SOCKS5
+	// GATEWAYTYPE_HTTP http proxy tunneling by connect, account and password are optional
+	GATEWAYTYPE_HTTP
+)
+
 type Gateway struct {
 	Id          int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
 	Name        string `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Type        int    `json:"type" gorm:"column:type"`
 	Host        string `json:"host" gorm:"column:host"`
 	Port        int    `json:"port" gorm:"column:port"`
 	AccountType int    `json:"account_type" gorm:"column:account_type"`
@@ -16,6 +33,8 @@ type Gateway struct {
 	Password    string `json:"password" gorm:"column:password"`
 	Pk          string `json:"pk" gorm:"column:pk"`
 	Phrase      string `json:"phrase" gorm:"column:phrase"`
+	DnsMode     int    `json:"dns_mode" gorm:"column:dns_mode"`
+	DnsServer   string `json:"dns_server" gorm:"column:dns_server"`
 
 	Permissions []string              `json:"permissions" gorm:"-"`
 	ResourceId  int                   `json:"resource_id" gorm:"column:resource_id"`
@@ -26,6 +45,8 @@ type Gateway struct {
 	DeletedAt   soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
 
 	AssetCount int64 `json:"asset_count" gorm:"-"`
+	// Fallbacks are other gateways of the group of the asset, which are tried in turn if this one fails to dial
+	Fallbacks []*Gateway `json:"-" gorm:"-"`
 }
 
 func (m *Gateway) TableName() string {
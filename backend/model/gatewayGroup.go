@@ -0,0 +1,57 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	GATEWAYSTRATEGY_ROUNDROBIN    = "round_robin"
+	GATEWAYSTRATEGY_LEASTSESSIONS = "least_sessions"
+)
+
+// GatewayGroup is a pool of gateways, assets of the group connect through one of them picked by Strategy,
+// and the others are tried in turn if the picked one fails to dial
+type GatewayGroup struct {
+	Id         int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name       string     `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment    string     `json:"comment" gorm:"column:comment"`
+	GatewayIds Slice[int] `json:"gateway_ids" gorm:"column:gateway_ids;type:text"`
+	Strategy   string     `json:"strategy" gorm:"column:strategy"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+
+	AssetCount int64 `json:"asset_count" gorm:"-"`
+}
+
+func (m *GatewayGroup) TableName() string {
+	return "gateway_group"
+}
+func (m *GatewayGroup) SetId(id int) {
+	m.Id = id
+}
+func (m *GatewayGroup) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *GatewayGroup) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *GatewayGroup) SetResourceId(resourceId int) {
+
+}
+func (m *GatewayGroup) GetResourceId() int {
+	return 0
+}
+func (m *GatewayGroup) GetName() string {
+	return m.Name
+}
+func (m *GatewayGroup) GetId() int {
+	return m.Id
+}
+
+func (m *GatewayGroup) SetPerms(perms []string) {}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	LABSTATUS_PROVISIONING = "provisioning"
+	LABSTATUS_READY        = "ready"
+	LABSTATUS_FAILED       = "failed"
+	LABSTATUS_EXPIRED      = "expired"
+)
+
+// LabTemplate is an asset provisioned on demand, approved access requests of it call ProvisionUrl and the asset
+// is registered once the orchestrator reports it ready. DeprovisionUrl is called when the access ends, ProvisionUrl
+// is called with the deprovision action if it is empty. Token is sent as the bearer token and authorizes callbacks
+type LabTemplate struct {
+	Id             int                 `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name           string              `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment        string              `json:"comment" gorm:"column:comment"`
+	Enable         bool                `json:"enable" gorm:"column:enable"`
+	ParentId       int                 `json:"parent_id" gorm:"column:parent_id"`
+	GatewayId      int                 `json:"gateway_id" gorm:"column:gateway_id"`
+	Protocols      Slice[string]       `json:"protocols" gorm:"column:protocols;type:text"`
+	AccountId      int                 `json:"account_id" gorm:"column:account_id"`
+	ProvisionUrl   string              `json:"provision_url" gorm:"column:provision_url"`
+	DeprovisionUrl string              `json:"deprovision_url" gorm:"column:deprovision_url"`
+	Token          string              `json:"token,omitempty" gorm:"column:token"`
+	Params         Map[string, string] `json:"params" gorm:"column:params;type:text"`
+	// Timeout of waiting for the lab to be ready, unit is minute
+	Timeout int `json:"timeout" gorm:"column:timeout"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *LabTemplate) TableName() string {
+	return "lab_template"
+}
+func (m *LabTemplate) SetId(id int) {
+	m.Id = id
+}
+func (m *LabTemplate) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *LabTemplate) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *LabTemplate) SetResourceId(resourceId int) {
+
+}
+func (m *LabTemplate) GetResourceId() int {
+	return 0
+}
+func (m *LabTemplate) GetName() string {
+	return m.Name
+}
+func (m *LabTemplate) GetId() int {
+	return m.Id
+}
+
+func (m *LabTemplate) SetPerms(perms []string) {}
+
+// Lab is an asset provisioned of a template for an access request, the asset is deleted when it expires
+type Lab struct {
+	Id              int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	TemplateId      int        `json:"template_id" gorm:"column:template_id;index"`
+	AccessRequestId int        `json:"access_request_id" gorm:"column:access_request_id;index"`
+	Uid             int        `json:"uid" gorm:"column:uid;index"`
+	UserName        string     `json:"user_name" gorm:"column:user_name"`
+	AssetId         int        `json:"asset_id" gorm:"column:asset_id"`
+	ExternalId      string     `json:"external_id" gorm:"column:external_id"`
+	Ip              string     `json:"ip" gorm:"column:ip"`
+	Status          string     `json:"status" gorm:"column:status;index"`
+	Message         string     `json:"message" gorm:"column:message"`
+	ReadyAt         *time.Time `json:"ready_at" gorm:"column:ready_at"`
+	ExpiredAt       *time.Time `json:"expired_at" gorm:"column:expired_at"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *Lab) TableName() string {
+	return "lab"
+}
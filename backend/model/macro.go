@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+var (
+	macroVarRe = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+)
+
+// Macro is a snippet typed into ssh sessions line by line, {{name}} in content are variables filled when it runs.
+// Public ones are defined by admins for everyone
+type Macro struct {
+	Id       int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid      int    `json:"uid" gorm:"column:uid;uniqueIndex:uid_name_del,priority:1"`
+	UserName string `json:"user_name" gorm:"column:user_name"`
+	Name     string `json:"name" gorm:"column:name;uniqueIndex:uid_name_del,priority:2;size:128"`
+	Content  string `json:"content" gorm:"column:content;type:text"`
+	Public   bool   `json:"public" gorm:"column:public"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:uid_name_del,priority:3"`
+}
+
+func (m *Macro) TableName() string {
+	return "macro"
+}
+func (m *Macro) SetId(id int) {
+	m.Id = id
+}
+func (m *Macro) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *Macro) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *Macro) SetResourceId(resourceId int) {
+
+}
+func (m *Macro) GetResourceId() int {
+	return 0
+}
+func (m *Macro) GetName() string {
+	return m.Name
+}
+func (m *Macro) GetId() int {
+	return m.Id
+}
+
+func (m *Macro) SetPerms(perms []string) {}
+
+// Vars returns names of variables in content
+func (m *Macro) Vars() []string {
+	return lo.Uniq(lo.Map(macroVarRe.FindAllStringSubmatch(m.Content, -1), func(s []string, _ int) string { return s[1] }))
+}
+
+// Render fills variables and returns non-empty lines of content, values must not break lines
+// since every line is checked by command filters as a command
+func (m *Macro) Render(vars map[string]string) (lines []string, err error) {
+	for _, name := range m.Vars() {
+		v, ok := vars[name]
+		if !ok {
+			return nil, fmt.Errorf("variable %s of macro %s is missing", name, m.Name)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("variable %s of macro %s has line breaks", name, m.Name)
+		}
+	}
+	content := macroVarRe.ReplaceAllStringFunc(m.Content, func(s string) string {
+		return vars[macroVarRe.FindStringSubmatch(s)[1]]
+	})
+	for _, l := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+// MaintenanceWindow is reserved planned work, commands needing approval are approved automatically
+// for users of the groups on assets of the nodes during the window, empty scopes mean all
+type MaintenanceWindow struct {
+	Id       int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name     string     `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment  string     `json:"comment" gorm:"column:comment"`
+	Enable   bool       `json:"enable" gorm:"column:enable"`
+	Start    time.Time  `json:"start" gorm:"column:start"`
+	End      time.Time  `json:"end" gorm:"column:end"`
+	NodeIds  Slice[int] `json:"node_ids" gorm:"column:node_ids;type:text"`
+	AssetIds Slice[int] `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+	Rids     Slice[int] `json:"rids" gorm:"column:rids;type:text"`
+	Uids     Slice[int] `json:"uids" gorm:"column:uids;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *MaintenanceWindow) TableName() string {
+	return "maintenance_window"
+}
+func (m *MaintenanceWindow) SetId(id int) {
+	m.Id = id
+}
+func (m *MaintenanceWindow) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *MaintenanceWindow) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *MaintenanceWindow) SetResourceId(resourceId int) {
+
+}
+func (m *MaintenanceWindow) GetResourceId() int {
+	return 0
+}
+func (m *MaintenanceWindow) GetName() string {
+	return m.Name
+}
+func (m *MaintenanceWindow) GetId() int {
+	return m.Id
+}
+
+func (m *MaintenanceWindow) SetPerms(perms []string) {}
+
+// InEffect reports whether the window covers t
+func (m *MaintenanceWindow) InEffect(t time.Time) bool {
+	return m.Enable && !t.Before(m.Start) && t.Before(m.End)
+}
+
+// InScope reports whether the window covers the user and the asset, nodeIds are the node and its ancestors of the asset
+func (m *MaintenanceWindow) InScope(uid, rid, assetId int, nodeIds []int) bool {
+	user := (len(m.Uids) == 0 && len(m.Rids) == 0) || lo.Contains(m.Uids, uid) || lo.Contains(m.Rids, rid)
+	return user && m.CoversAsset(assetId, nodeIds)
+}
+
+// CoversAsset reports whether the window covers the asset whoever the user is
+func (m *MaintenanceWindow) CoversAsset(assetId int, nodeIds []int) bool {
+	return (len(m.AssetIds) == 0 && len(m.NodeIds) == 0) || lo.Contains(m.AssetIds, assetId) || len(lo.Intersect(m.NodeIds, nodeIds)) > 0
+}
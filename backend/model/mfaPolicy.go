@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+// MfaPolicy requires users to verify a totp code before they connect to assets, empty scopes mean all
+type MfaPolicy struct {
+	Id      int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name    string `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment string `json:"comment" gorm:"column:comment"`
+	Enable  bool   `json:"enable" gorm:"column:enable"`
+	// Grace is how long a verification is reused for later connections to the asset, 0 means every connection verifies, unit is second
+	Grace    int        `json:"grace" gorm:"column:grace"`
+	Uids     Slice[int] `json:"uids" gorm:"column:uids;type:text"`
+	Rids     Slice[int] `json:"rids" gorm:"column:rids;type:text"`
+	AssetIds Slice[int] `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *MfaPolicy) TableName() string {
+	return "mfa_policy"
+}
+func (m *MfaPolicy) SetId(id int) {
+	m.Id = id
+}
+func (m *MfaPolicy) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *MfaPolicy) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *MfaPolicy) SetResourceId(resourceId int) {
+
+}
+func (m *MfaPolicy) GetResourceId() int {
+	return 0
+}
+func (m *MfaPolicy) GetName() string {
+	return m.Name
+}
+func (m *MfaPolicy) GetId() int {
+	return m.Id
+}
+
+func (m *MfaPolicy) SetPerms(perms []string) {}
+
+func (m *MfaPolicy) InScope(uid, rid, assetId int) bool {
+	in := func(s Slice[int], id int) bool { return len(s) == 0 || lo.Contains(s, id) }
+	return in(m.Uids, uid) && in(m.Rids, rid) && in(m.AssetIds, assetId)
+}
@@ -11,9 +11,13 @@ const (
 	ACTION_UPDATE
 )
 
-type Slice[T int | string | Range] []T
+type Slice[T int | string | Range | FeatureOverride | Reviewer | Contact] []T
 
+// Scan leaves s empty for null, which columns added later are in existing rows
 func (s *Slice[T]) Scan(value any) error {
+	if value == nil {
+		return nil
+	}
 	return json.Unmarshal(value.([]byte), s)
 }
 
@@ -24,6 +28,9 @@ func (s Slice[T]) Value() (driver.Value, error) {
 type Map[K comparable, V any] map[K]V
 
 func (m *Map[K, V]) Scan(value any) error {
+	if value == nil {
+		return nil
+	}
 	return json.Unmarshal(value.([]byte), m)
 
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	NOTIFYCHANNEL_DINGTALK = "dingtalk"
+	NOTIFYCHANNEL_FEISHU   = "feishu"
+	NOTIFYCHANNEL_SLACK    = "slack"
+)
+
+// Notification pushes events of assets it covers to a chat bot of the channel, Url is the webhook of the bot and
+// Secret signs requests for bots of dingtalk and feishu with signature verification on
+type Notification struct {
+	Id      int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name    string `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment string `json:"comment" gorm:"column:comment"`
+	Channel string `json:"channel" gorm:"column:channel"`
+	Url     string `json:"url" gorm:"column:url"`
+	Secret  string `json:"secret,omitempty" gorm:"column:secret"`
+	// Events are types of events pushed, session.open, session.close, session.admin_close and command.blocked if it is empty
+	Events Slice[string] `json:"events" gorm:"column:events;type:text"`
+	// AssetIds and NodeIds are assets covered, nodes cover assets under them at any depth, all assets if both are empty
+	AssetIds Slice[int] `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+	NodeIds  Slice[int] `json:"node_ids" gorm:"column:node_ids;type:text"`
+	// Lang of messages, en or zh
+	Lang   string `json:"lang" gorm:"column:lang"`
+	Enable bool   `json:"enable" gorm:"column:enable"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *Notification) TableName() string {
+	return "notification"
+}
+func (m *Notification) SetId(id int) {
+	m.Id = id
+}
+func (m *Notification) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *Notification) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *Notification) SetResourceId(resourceId int) {
+
+}
+func (m *Notification) GetResourceId() int {
+	return 0
+}
+func (m *Notification) GetName() string {
+	return m.Name
+}
+func (m *Notification) GetId() int {
+	return m.Id
+}
+
+func (m *Notification) SetPerms(perms []string) {}
+
+// CoversAsset reports whether the notification covers the asset, nodeIds are the node and its ancestors of the asset
+func (m *Notification) CoversAsset(assetId int, nodeIds []int) bool {
+	return (len(m.AssetIds) == 0 && len(m.NodeIds) == 0) || lo.Contains(m.AssetIds, assetId) || len(lo.Intersect(m.NodeIds, nodeIds)) > 0
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+)
+
+// Preference is of each user, Lang and Timezone are used by messages, stamps and exports rendered by the server.
+// Timezone is an iana name, e.g. Asia/Shanghai, the one of the server is used if it is empty
+type Preference struct {
+	Id       int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid      int    `json:"uid" gorm:"column:uid;uniqueIndex"`
+	Lang     string `json:"lang" gorm:"column:lang"`
+	Timezone string `json:"timezone" gorm:"column:timezone"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *Preference) TableName() string {
+	return "preference"
+}
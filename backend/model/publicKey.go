@@ -9,6 +9,15 @@ import (
 const (
 	AUTHMETHOD_PASSWORD  = 1
 	AUTHMETHOD_PUBLICKEY = 2
+	// AUTHMETHOD_KUBECONFIG kubeconfig is saved as pk
+	AUTHMETHOD_KUBECONFIG = 3
+	// AUTHMETHOD_TLSCERT pem bundle of ca, client certificate and key is saved as pk
+	AUTHMETHOD_TLSCERT = 4
+	// AUTHMETHOD_SSHCERT certificates are signed by the active ssh ca at login, nothing is saved
+	AUTHMETHOD_SSHCERT = 5
+	// AUTHMETHOD_SSHKEYCERT private key is saved as pk and its certificate as cert, the certificate is
+	// re-issued by the active ssh ca before it expires
+	AUTHMETHOD_SSHKEYCERT = 6
 )
 
 type PublicKey struct {
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	ROTATIONSTATUS_SUCCESS = iota + 1
+	ROTATIONSTATUS_FAILED
+)
+
+const (
+	// ROTATIONMETHOD_PASSWD the account changes its own password with passwd in a pty
+	ROTATIONMETHOD_PASSWD = "passwd"
+	// ROTATIONMETHOD_CHPASSWD the privileged account of sudo sets the password with chpasswd
+	ROTATIONMETHOD_CHPASSWD = "chpasswd"
+	// ROTATIONMETHOD_WINRM net user is run through winrm
+	ROTATIONMETHOD_WINRM = "winrm"
+)
+
+// RotationHistory is the result of changing password of an account on one asset, assets of the same rotation share the batch id
+type RotationHistory struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	BatchId   string `json:"batch_id" gorm:"column:batch_id;size:64;index"`
+	AccountId int    `json:"account_id" gorm:"column:account_id;index"`
+	Account   string `json:"account" gorm:"column:account"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id;index"`
+	Method    string `json:"method" gorm:"column:method"`
+	Status    int    `json:"status" gorm:"column:status"`
+	Message   string `json:"message" gorm:"column:message;type:text"`
+	// CreatorId is 0 for scheduled rotations
+	CreatorId int `json:"creator_id" gorm:"column:creator_id"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (m *RotationHistory) TableName() string {
+	return "rotation_history"
+}
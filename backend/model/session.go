@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"strings"
 	"time"
 )
@@ -8,6 +9,10 @@ import (
 const (
 	SESSIONTYPE_WEB = iota + 1
 	SESSIONTYPE_CLIENT
+	// SESSIONTYPE_PROXY native clients connect through db proxy
+	SESSIONTYPE_PROXY
+	// SESSIONTYPE_EXEC single commands run by the exec api without a pty
+	SESSIONTYPE_EXEC
 )
 
 const (
@@ -15,6 +20,21 @@ const (
 	SESSIONSTATUS_OFFLINE
 )
 
+// qos classes of sessions, output of bulk ones is shrunk first under bandwidth pressure
+const (
+	QOS_INTERACTIVE = "interactive"
+	QOS_BULK        = "bulk"
+)
+
+// reasons of sessions ending, sessions whose owners left are detached rather than ended until the grace of resuming
+// ends, detached_at is set while they are
+const (
+	CLOSEREASON_CLIENT_LEAVE = "client_leave"
+	CLOSEREASON_BACKEND_END  = "backend_end"
+	CLOSEREASON_ADMIN_CLOSE  = "admin_close"
+	CLOSEREASON_TIMEOUT      = "timeout"
+)
+
 const (
 	SESSIONACTION_NEW = iota + 1
 	SESSIONACTION_MONITOR
@@ -22,24 +42,33 @@ const (
 )
 
 type Session struct {
-	Id          int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
-	SessionType int        `json:"session_type" gorm:"column:session_type"`
-	SessionId   string     `json:"session_id" gorm:"column:session_id;uniqueIndex:session_id;size:128"`
-	Uid         int        `json:"uid" gorm:"column:uid"`
-	UserName    string     `json:"user_name" gorm:"column:user_name"`
-	AssetId     int        `json:"asset_id" gorm:"column:asset_id"`
-	Asset       *Asset     `json:"-" gorm:"-"`
-	AssetInfo   string     `json:"asset_info" gorm:"column:asset_info"`
-	AccountId   int        `json:"account_id" gorm:"column:account_id"`
-	AccountInfo string     `json:"account_info" gorm:"column:account_info"`
-	GatewayId   int        `json:"gateway_id" gorm:"column:gateway_id"`
-	GatewayInfo string     `json:"gateway_info" gorm:"column:gateway_info"`
-	ClientIp    string     `json:"client_ip" gorm:"column:client_ip"`
-	Protocol    string     `json:"protocol" gorm:"column:protocol"`
-	Status      int        `json:"status" gorm:"column:status"`
-	Duration    int64      `json:"duration" gorm:"-"`
-	ClosedAt    *time.Time `json:"closed_at" gorm:"column:closed_at"`
-	ShareId     int        `json:"share_id" gorm:"column:share_id"`
+	Id                  int           `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionType         int           `json:"session_type" gorm:"column:session_type"`
+	SessionId           string        `json:"session_id" gorm:"column:session_id;uniqueIndex:session_id;size:128"`
+	Uid                 int           `json:"uid" gorm:"column:uid"`
+	UserName            string        `json:"user_name" gorm:"column:user_name"`
+	AssetId             int           `json:"asset_id" gorm:"column:asset_id"`
+	Asset               *Asset        `json:"-" gorm:"-"`
+	AssetInfo           string        `json:"asset_info" gorm:"column:asset_info"`
+	AccountId           int           `json:"account_id" gorm:"column:account_id"`
+	AccountInfo         string        `json:"account_info" gorm:"column:account_info"`
+	GatewayId           int           `json:"gateway_id" gorm:"column:gateway_id"`
+	GatewayInfo         string        `json:"gateway_info" gorm:"column:gateway_info"`
+	ClientIp            string        `json:"client_ip" gorm:"column:client_ip"`
+	Protocol            string        `json:"protocol" gorm:"column:protocol"`
+	Status              int           `json:"status" gorm:"column:status"`
+	Duration            int64         `json:"duration" gorm:"-"`
+	ClosedAt            *time.Time    `json:"closed_at" gorm:"column:closed_at"`
+	CloseReason         string        `json:"close_reason" gorm:"column:close_reason;size:32"`
+	DetachedAt          *time.Time    `json:"detached_at" gorm:"column:detached_at"`
+	ShareId             int           `json:"share_id" gorm:"column:share_id"`
+	MaintenanceWindowId int           `json:"maintenance_window_id" gorm:"column:maintenance_window_id"`
+	AccessRequestId     int           `json:"access_request_id" gorm:"column:access_request_id"`
+	WorkspaceId         int           `json:"workspace_id" gorm:"column:workspace_id;index"`
+	TraceId             string        `json:"trace_id" gorm:"column:trace_id;size:32"`
+	Qos                 string        `json:"qos" gorm:"column:qos"`
+	HostInfo            HostInfo      `json:"host_info" gorm:"embedded;embeddedPrefix:host_"`
+	Participants        Slice[string] `json:"participants" gorm:"column:participants;type:text"`
 
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
@@ -57,6 +86,7 @@ type SessionCmd struct {
 	Cmd       string `json:"cmd" gorm:"column:cmd"`
 	Result    string `json:"result" gorm:"column:result"`
 	Level     int    `json:"level" gorm:"column:level"`
+	UserName  string `json:"user_name" gorm:"column:user_name"`
 
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
 }
@@ -65,12 +95,37 @@ func (m *SessionCmd) TableName() string {
 	return "session_cmd"
 }
 
+// RecordingName returns file name of the recording, guacd names it by session id
+func (m *Session) RecordingName() string {
+	if m.IsGuacd() {
+		return m.SessionId
+	}
+	return fmt.Sprintf("%s.cast", m.SessionId)
+}
+
+// RedactedRecordingName returns file name of the redacted copy, the original recording is never modified
+func (m *Session) RedactedRecordingName() string {
+	if m.IsGuacd() {
+		return fmt.Sprintf("%s.redacted", m.SessionId)
+	}
+	return fmt.Sprintf("%s.redacted.cast", m.SessionId)
+}
+
 func (m *Session) IsGuacd() bool {
 	return m.IsRdp() || m.IsVnc()
 }
+func (m *Session) IsBulk() bool {
+	return m.Qos == QOS_BULK
+}
 func (m *Session) IsSsh() bool {
 	return strings.HasPrefix(m.Protocol, "ssh")
 }
+func (m *Session) IsTelnet() bool {
+	return strings.HasPrefix(m.Protocol, "telnet")
+}
+func (m *Session) IsSerial() bool {
+	return strings.HasPrefix(m.Protocol, "serial")
+}
 func (m *Session) IsRdp() bool {
 	return strings.HasPrefix(m.Protocol, "rdp")
 }
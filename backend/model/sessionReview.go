@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	REVIEW_STATUS_PENDING = iota + 1
+	REVIEW_STATUS_DONE
+)
+
+// strategies of assigning flagged sessions to reviewers
+const (
+	// REVIEW_STRATEGY_ROUND_ROBIN assigns sessions to reviewers in turn
+	REVIEW_STRATEGY_ROUND_ROBIN = "round_robin"
+	// REVIEW_STRATEGY_LEAST_LOADED assigns sessions to the reviewer with the fewest pending reviews
+	REVIEW_STRATEGY_LEAST_LOADED = "least_loaded"
+	// REVIEW_STRATEGY_OWNER assigns sessions to an owner or else the creator of the asset if it is a reviewer, or the least loaded one
+	REVIEW_STRATEGY_OWNER = "owner"
+)
+
+// SessionReview is a flagged session waiting for its reviewer, it is escalated once it is not done before DueAt
+type SessionReview struct {
+	Id          int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId   string     `json:"session_id" gorm:"column:session_id;index"`
+	AssetId     int        `json:"asset_id" gorm:"column:asset_id"`
+	Reason      string     `json:"reason" gorm:"column:reason;type:text"`
+	FlaggedBy   string     `json:"flagged_by" gorm:"column:flagged_by"`
+	ReviewerId  int        `json:"reviewer_id" gorm:"column:reviewer_id;index"`
+	Reviewer    string     `json:"reviewer" gorm:"column:reviewer"`
+	Status      int        `json:"status" gorm:"column:status"`
+	Comment     string     `json:"comment" gorm:"column:comment;type:text"`
+	DueAt       time.Time  `json:"due_at" gorm:"column:due_at"`
+	EscalatedAt *time.Time `json:"escalated_at" gorm:"column:escalated_at"`
+	ReviewedAt  *time.Time `json:"reviewed_at" gorm:"column:reviewed_at"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *SessionReview) TableName() string {
+	return "session_review"
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+)
+
+// SessionTakeover is an admin seizing control of an online session, the owner is read-only until it ends
+type SessionTakeover struct {
+	Id        int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId string     `json:"session_id" gorm:"column:session_id;size:128;index"`
+	Uid       int        `json:"uid" gorm:"column:uid;index"`
+	UserName  string     `json:"user_name" gorm:"column:user_name"`
+	ClientIp  string     `json:"client_ip" gorm:"column:client_ip"`
+	EndedAt   *time.Time `json:"ended_at" gorm:"column:ended_at"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (m *SessionTakeover) TableName() string {
+	return "session_takeover"
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+// SshCa signs certificates for target logins, only the active one signs while all are trusted by assets
+// so that certificates signed before rotation still work until the old one is deleted
+type SshCa struct {
+	Id          int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name        string `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment     string `json:"comment" gorm:"column:comment"`
+	Active      bool   `json:"active" gorm:"column:active"`
+	PublicKey   string `json:"public_key" gorm:"column:public_key;type:text"`
+	Fingerprint string `json:"fingerprint" gorm:"column:fingerprint"`
+	PrivateKey  string `json:"-" gorm:"column:private_key;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *SshCa) TableName() string {
+	return "ssh_ca"
+}
+func (m *SshCa) SetId(id int) {
+	m.Id = id
+}
+func (m *SshCa) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *SshCa) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *SshCa) SetResourceId(resourceId int) {
+
+}
+func (m *SshCa) GetResourceId() int {
+	return 0
+}
+func (m *SshCa) GetName() string {
+	return m.Name
+}
+func (m *SshCa) GetId() int {
+	return m.Id
+}
+
+func (m *SshCa) SetPerms(perms []string) {}
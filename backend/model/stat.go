@@ -26,8 +26,9 @@ type StatCount struct {
 }
 
 type StatAccount struct {
-	Name  string `json:"name" gorm:"column:name"`
-	Count int    `json:"count" gorm:"column:count"`
+	AccountId int    `json:"-" gorm:"column:account_id"`
+	Name      string `json:"name" gorm:"column:name"`
+	Count     int    `json:"count" gorm:"column:count"`
 }
 
 type StatAsset struct {
@@ -50,3 +51,18 @@ type StatRankOfUser struct {
 	Count    int64     `json:"count" gorm:"column:count"`
 	LastTime time.Time `json:"last_time" gorm:"column:last_time"`
 }
+
+// StatHeatmap counts sessions of a user by weekday from sunday and hour of day
+type StatHeatmap struct {
+	Uid    int          `json:"uid"`
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Total  int64        `json:"total"`
+	Matrix [7][24]int64 `json:"matrix"`
+}
+
+type StatHeatmapCell struct {
+	Weekday int   `gorm:"column:weekday"`
+	Hour    int   `gorm:"column:hour"`
+	Count   int64 `gorm:"column:count"`
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	STEPUPMETHOD_TOTP     = "totp"
+	STEPUPMETHOD_WEBAUTHN = "webauthn"
+)
+
+// StepUpCredential is a second factor of a user, Secret of totp is encrypted,
+// and PublicKey of webauthn is the der of its subject public key info
+type StepUpCredential struct {
+	Id           int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid          int    `json:"uid" gorm:"column:uid;index"`
+	Name         string `json:"name" gorm:"column:name"`
+	Method       string `json:"method" gorm:"column:method"`
+	Secret       string `json:"-" gorm:"column:secret"`
+	CredentialId string `json:"credential_id" gorm:"column:credential_id;size:512"`
+	PublicKey    string `json:"-" gorm:"column:public_key;type:text"`
+	// Counter is the last time step of totp or the sign count of webauthn, codes and assertions not after it are refused
+	Counter  int64      `json:"-" gorm:"column:counter"`
+	LastUsed *time.Time `json:"last_used" gorm:"column:last_used"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *StepUpCredential) TableName() string {
+	return "step_up_credential"
+}
+
+// StepUp is a fresh authentication by a second factor, which is used up by one replay
+type StepUp struct {
+	Id           int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid          int        `json:"uid" gorm:"column:uid;index"`
+	UserName     string     `json:"user_name" gorm:"column:user_name"`
+	Method       string     `json:"method" gorm:"column:method"`
+	CredentialId int        `json:"credential_id" gorm:"column:credential_id"`
+	ClientIp     string     `json:"client_ip" gorm:"column:client_ip"`
+	UsedAt       *time.Time `json:"used_at" gorm:"column:used_at"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (m *StepUp) TableName() string {
+	return "step_up"
+}
+
+// ReplayLog is a replay or download of a recording, StepUpId is the step up it used if the asset is restricted
+type ReplayLog struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId string `json:"session_id" gorm:"column:session_id;size:128;index"`
+	Uid       int    `json:"uid" gorm:"column:uid;index"`
+	UserName  string `json:"user_name" gorm:"column:user_name"`
+	ClientIp  string `json:"client_ip" gorm:"column:client_ip"`
+	StepUpId  int    `json:"step_up_id" gorm:"column:step_up_id"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+}
+
+func (m *ReplayLog) TableName() string {
+	return "replay_log"
+}
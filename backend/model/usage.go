@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+)
+
+// Usage is the metering of a month, peaks are the highest of samples in the month and active users are distinct
+// users having sessions in it. Rows are kept even after sessions are purged, so that chargeback of past months works
+type Usage struct {
+	Id             int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Month          string     `json:"month" gorm:"column:month;uniqueIndex;size:7"`
+	ActiveUsers    int64      `json:"active_users" gorm:"column:active_users"`
+	PeakSessions   int64      `json:"peak_sessions" gorm:"column:peak_sessions"`
+	PeakSessionsAt *time.Time `json:"peak_sessions_at" gorm:"column:peak_sessions_at"`
+	PeakAssets     int64      `json:"peak_assets" gorm:"column:peak_assets"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *Usage) TableName() string {
+	return "monthly_usage"
+}
+
+// UserUsage is the usage of a user in a month for chargeback, duration is seconds of sessions
+type UserUsage struct {
+	Uid      int    `json:"uid" gorm:"column:uid"`
+	UserName string `json:"user_name" gorm:"column:user_name"`
+	Sessions int64  `json:"sessions" gorm:"column:sessions"`
+	Assets   int64  `json:"assets" gorm:"column:assets"`
+	Duration int64  `json:"duration" gorm:"column:duration"`
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/plugin/soft_delete"
+)
+
+// WatermarkPolicy has web clients of rdp and vnc sessions render a watermark over the remote desktop, empty scopes mean
+// all. Content is a template of {user}, {ip}, {asset}, {account} and {session} filled in by the server, {time} is left
+// to clients to keep it current. Sessions covered by several policies take the one of the smallest id
+type WatermarkPolicy struct {
+	Id       int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name     string     `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment  string     `json:"comment" gorm:"column:comment"`
+	Enable   bool       `json:"enable" gorm:"column:enable"`
+	Content  string     `json:"content" gorm:"column:content"`
+	Color    string     `json:"color" gorm:"column:color"`
+	Opacity  int        `json:"opacity" gorm:"column:opacity"`
+	FontSize int        `json:"font_size" gorm:"column:font_size"`
+	Angle    int        `json:"angle" gorm:"column:angle"`
+	Uids     Slice[int] `json:"uids" gorm:"column:uids;type:text"`
+	Rids     Slice[int] `json:"rids" gorm:"column:rids;type:text"`
+	AssetIds Slice[int] `json:"asset_ids" gorm:"column:asset_ids;type:text"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *WatermarkPolicy) TableName() string {
+	return "watermark_policy"
+}
+func (m *WatermarkPolicy) SetId(id int) {
+	m.Id = id
+}
+func (m *WatermarkPolicy) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *WatermarkPolicy) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *WatermarkPolicy) SetResourceId(resourceId int) {
+
+}
+func (m *WatermarkPolicy) GetResourceId() int {
+	return 0
+}
+func (m *WatermarkPolicy) GetName() string {
+	return m.Name
+}
+func (m *WatermarkPolicy) GetId() int {
+	return m.Id
+}
+
+func (m *WatermarkPolicy) SetPerms(perms []string) {}
+
+func (m *WatermarkPolicy) InScope(uid, rid, assetId int) bool {
+	in := func(s Slice[int], id int) bool { return len(s) == 0 || lo.Contains(s, id) }
+	return in(m.Uids, uid) && in(m.Rids, rid) && in(m.AssetIds, assetId)
+}
+
+// Watermark is what web clients render, it is sent by a guacd instruction of the opcode watermark with it in base64 encoded json
+type Watermark struct {
+	Content  string `json:"content"`
+	Color    string `json:"color"`
+	Opacity  int    `json:"opacity"`
+	FontSize int    `json:"font_size"`
+	Angle    int    `json:"angle"`
+}
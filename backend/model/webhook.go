@@ -0,0 +1,81 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+const (
+	WEBHOOKSTATUS_PENDING = iota + 1
+	WEBHOOKSTATUS_SUCCESS
+	WEBHOOKSTATUS_FAILED
+)
+
+// Webhook posts events of its types to the url, bodies are signed by hmac sha256 of the secret if it is set
+type Webhook struct {
+	Id      int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Name    string `json:"name" gorm:"column:name;uniqueIndex:name_del;size:128"`
+	Comment string `json:"comment" gorm:"column:comment"`
+	Url     string `json:"url" gorm:"column:url"`
+	Secret  string `json:"secret,omitempty" gorm:"column:secret"`
+	// Events are types of events posted, session.open, session.close, session.admin_close and command.blocked if it is empty
+	Events Slice[string] `json:"events" gorm:"column:events;type:text"`
+	Enable bool          `json:"enable" gorm:"column:enable"`
+
+	CreatorId int                   `json:"creator_id" gorm:"column:creator_id"`
+	UpdaterId int                   `json:"updater_id" gorm:"column:updater_id"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt soft_delete.DeletedAt `json:"-" gorm:"column:deleted_at;uniqueIndex:name_del"`
+}
+
+func (m *Webhook) TableName() string {
+	return "webhook"
+}
+func (m *Webhook) SetId(id int) {
+	m.Id = id
+}
+func (m *Webhook) SetCreatorId(creatorId int) {
+	m.CreatorId = creatorId
+}
+func (m *Webhook) SetUpdaterId(updaterId int) {
+	m.UpdaterId = updaterId
+}
+func (m *Webhook) SetResourceId(resourceId int) {
+
+}
+func (m *Webhook) GetResourceId() int {
+	return 0
+}
+func (m *Webhook) GetName() string {
+	return m.Name
+}
+func (m *Webhook) GetId() int {
+	return m.Id
+}
+
+func (m *Webhook) SetPerms(perms []string) {}
+
+// WebhookDelivery is an event posted to a webhook, pending ones are retried at NextAt until attempts run out
+type WebhookDelivery struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	WebhookId int    `json:"webhook_id" gorm:"column:webhook_id;index"`
+	EventId   string `json:"event_id" gorm:"column:event_id;size:64;index"`
+	Type      string `json:"type" gorm:"column:type;size:64"`
+	SessionId string `json:"session_id" gorm:"column:session_id;size:64;index"`
+	Payload   string `json:"payload" gorm:"column:payload;type:mediumtext"`
+	Status    int    `json:"status" gorm:"column:status;index:status_next"`
+	Attempts  int    `json:"attempts" gorm:"column:attempts"`
+	// ResponseCode is the http status of the last attempt, 0 if it did not get a response
+	ResponseCode int       `json:"response_code" gorm:"column:response_code"`
+	Message      string    `json:"message" gorm:"column:message;type:text"`
+	NextAt       time.Time `json:"next_at" gorm:"column:next_at;index:status_next"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;index"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *WebhookDelivery) TableName() string {
+	return "webhook_delivery"
+}
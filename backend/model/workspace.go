@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	WORKSPACESTATUS_OPEN = iota + 1
+	WORKSPACESTATUS_CLOSED
+)
+
+// Workspace groups sessions opened together for an asset, e.g. a terminal, the file browser of it and port forwards,
+// so that they share one lifetime and are closed by one action. Ports are the ones of the asset which could be
+// forwarded in the workspace
+type Workspace struct {
+	Id        int        `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Uid       int        `json:"uid" gorm:"column:uid;index"`
+	UserName  string     `json:"user_name" gorm:"column:user_name"`
+	AssetId   int        `json:"asset_id" gorm:"column:asset_id"`
+	AccountId int        `json:"account_id" gorm:"column:account_id"`
+	Ports     Slice[int] `json:"ports" gorm:"column:ports;type:text"`
+	Status    int        `json:"status" gorm:"column:status;index"`
+	ClosedBy  string     `json:"closed_by" gorm:"column:closed_by"`
+	ClosedAt  *time.Time `json:"closed_at" gorm:"column:closed_at"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (m *Workspace) TableName() string {
+	return "workspace"
+}
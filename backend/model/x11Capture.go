@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+)
+
+// X11Capture is a screenshot of a window forwarded by x11 in a ssh session, the png is kept in storage by name
+type X11Capture struct {
+	Id        int    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionId string `json:"session_id" gorm:"column:session_id;size:128;index"`
+	Uid       int    `json:"uid" gorm:"column:uid"`
+	AssetId   int    `json:"asset_id" gorm:"column:asset_id"`
+	WindowId  uint32 `json:"window_id" gorm:"column:window_id"`
+	Width     int    `json:"width" gorm:"column:width"`
+	Height    int    `json:"height" gorm:"column:height"`
+	Name      string `json:"name" gorm:"column:name"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+func (m *X11Capture) TableName() string {
+	return "x11_capture"
+}
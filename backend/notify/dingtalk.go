@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/veops/oneterm/model"
+)
+
+func init() {
+	register(model.NOTIFYCHANNEL_DINGTALK, &dingtalk{})
+}
+
+// dingtalk posts markdown to custom robots of groups
+//
+//	https://open.dingtalk.com/document/orgapp/custom-robots-send-group-messages
+type dingtalk struct{}
+
+func (d *dingtalk) Send(ctx context.Context, u, secret, title, text string) (err error) {
+	if secret != "" {
+		ts := fmt.Sprint(time.Now().UnixMilli())
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte(ts + "\n" + secret))
+		if u, err = withQuery(u, "timestamp", ts, "sign", base64.StdEncoding.EncodeToString(h.Sum(nil))); err != nil {
+			return
+		}
+	}
+	res := &struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	if err = post(ctx, u, map[string]any{
+		"msgtype":  "markdown",
+		"markdown": map[string]any{"title": title, "text": fmt.Sprintf("#### %s\n\n%s", title, text)},
+	}, res); err != nil {
+		return
+	}
+	if res.ErrCode != 0 {
+		return fmt.Errorf("dingtalk error %d: %s", res.ErrCode, res.ErrMsg)
+	}
+	return
+}
+
+func withQuery(u string, kvs ...string) (string, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	q := pu.Query()
+	for i := 0; i+1 < len(kvs); i += 2 {
+		q.Set(kvs[i], kvs[i+1])
+	}
+	pu.RawQuery = q.Encode()
+	return pu.String(), nil
+}
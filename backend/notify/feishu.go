@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/veops/oneterm/model"
+)
+
+func init() {
+	register(model.NOTIFYCHANNEL_FEISHU, &feishu{})
+}
+
+// feishu posts text to custom bots of groups, lark bots take the same
+//
+//	https://open.feishu.cn/document/client-docs/bot-v3/add-custom-bot
+type feishu struct{}
+
+func (f *feishu) Send(ctx context.Context, u, secret, title, text string) (err error) {
+	body := map[string]any{
+		"msg_type": "text",
+		"content":  map[string]any{"text": fmt.Sprintf("%s\n%s", title, text)},
+	}
+	if secret != "" {
+		// the key is the string to sign and the message is empty, unlike dingtalk
+		ts := fmt.Sprint(time.Now().Unix())
+		h := hmac.New(sha256.New, []byte(ts+"\n"+secret))
+		body["timestamp"], body["sign"] = ts, base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+	res := &struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+	if err = post(ctx, u, body, res); err != nil {
+		return
+	}
+	if res.Code != 0 {
+		return fmt.Errorf("feishu error %d: %s", res.Code, res.Msg)
+	}
+	return
+}
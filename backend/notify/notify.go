@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	myi18n "github.com/veops/oneterm/i18n"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	title       = "OneTerm"
+	bufferSize  = 1000
+	sendTimeout = time.Second * 10
+)
+
+var (
+	// Types are events notifications could take
+	Types = []string{
+		eventbus.TYPE_SESSION_OPEN, eventbus.TYPE_SESSION_CLOSE, eventbus.TYPE_SESSION_ADMIN_CLOSE, eventbus.TYPE_COMMAND_BLOCKED,
+	}
+
+	langs       = []string{"en", "zh"}
+	channels    = map[string]Channel{}
+	events      = make(chan *eventbus.Event, bufferSize)
+	dropped     atomic.Int64
+	cli         = &http.Client{Timeout: sendTimeout}
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+// Channel posts a message to a chat bot by its webhook url, secret is the plain one of signing if it is set
+type Channel interface {
+	Send(ctx context.Context, url, secret, title, text string) error
+}
+
+func register(name string, ch Channel) {
+	channels[name] = ch
+}
+
+func init() {
+	eventbus.Listen(func(e *eventbus.Event) {
+		if !lo.Contains(Types, e.Type) {
+			return
+		}
+		select {
+		case events <- e:
+		default:
+			dropped.Add(1)
+		}
+	})
+}
+
+// Validate checks the channel, url and events of a notification
+func Validate(n *model.Notification) error {
+	if _, ok := channels[n.Channel]; !ok {
+		return fmt.Errorf("unknown notification channel %s", n.Channel)
+	}
+	if n.Url == "" {
+		return errors.New("url is required")
+	}
+	if n.Lang != "" && !lo.Contains(langs, n.Lang) {
+		return fmt.Errorf("unsupported notification lang %s", n.Lang)
+	}
+	if t, ok := lo.Find(n.Events, func(t string) bool { return !lo.Contains(Types, t) }); ok {
+		return fmt.Errorf("unsupported notification event %s", t)
+	}
+	return nil
+}
+
+// Test sends a test message by the notification
+func Test(ctx context.Context, n *model.Notification) error {
+	return send(ctx, n, localize(n.Lang, myi18n.MsgNotifyTest, nil))
+}
+
+// Run pushes events to notifications covering their assets one by one, so that rate limits of bots are less hit
+func Run() (err error) {
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			if n := dropped.Swap(0); n > 0 {
+				logger.L().Warn("notification buffer is full, events are dropped", zap.Int64("count", n))
+			}
+		case e := <-events:
+			handle(e)
+		}
+	}
+}
+
+func Stop() {
+	defer cancel()
+}
+
+func handle(e *eventbus.Event) {
+	var (
+		sess *model.Session
+		msg  *i18n.Message
+		data = map[string]any{"time": e.Time}
+		ns   = make([]*model.Notification, 0)
+	)
+	switch d := e.Data.(type) {
+	case *model.Session:
+		sess = d
+		msg = lo.Ternary(e.Type == eventbus.TYPE_SESSION_OPEN, myi18n.MsgNotifySessionOpen, myi18n.MsgNotifySessionClose)
+	case *eventbus.AdminClose:
+		sess, msg, data["closer"] = &d.Session, myi18n.MsgNotifyAdminClose, d.Closer
+	case *eventbus.BlockedCommand:
+		// blocked commands only have session ids, others of them are of the session
+		sess = &model.Session{}
+		if err := mysql.AuditDB.Model(sess).Where("session_id = ?", d.SessionId).First(sess).Error; err != nil {
+			sess = &model.Session{SessionId: d.SessionId, UserName: d.UserName}
+		}
+		msg, data["rule"], data["cmd"] = myi18n.MsgNotifyCommandBlocked, d.Rule, d.Cmd
+	default:
+		return
+	}
+	data["user"], data["asset"], data["account"], data["protocol"], data["ip"] =
+		sess.UserName, sess.AssetInfo, sess.AccountInfo, sess.Protocol, sess.ClientIp
+
+	if err := mysql.DB.Model(model.DefaultNotification).Where("enable = ?", true).Find(&ns).Error; err != nil {
+		logger.L().Error("get notifications failed", zap.Error(err))
+		return
+	}
+	ns = lo.Filter(ns, func(n *model.Notification, _ int) bool {
+		return lo.Contains(lo.Ternary(len(n.Events) == 0, Types, n.Events), e.Type)
+	})
+	if len(ns) == 0 {
+		return
+	}
+	nodeIds, err := nodesOf(sess.AssetId)
+	if err != nil {
+		logger.L().Error("get nodes of asset failed", zap.Int("assetId", sess.AssetId), zap.Error(err))
+		return
+	}
+	for _, n := range ns {
+		if !n.CoversAsset(sess.AssetId, nodeIds) {
+			continue
+		}
+		sctx, scancel := context.WithTimeout(ctx, sendTimeout)
+		if err := send(sctx, n, localize(n.Lang, msg, data)); err != nil {
+			logger.L().Warn("send notification failed", zap.String("name", n.Name), zap.String("type", e.Type), zap.Error(err))
+		}
+		scancel()
+	}
+}
+
+// nodesOf returns the node and its ancestors of the asset
+func nodesOf(assetId int) (nodeIds []int, err error) {
+	asset := &model.Asset{}
+	if err = mysql.DB.Model(asset).Select("id", "parent_id").Where("id = ?", assetId).First(asset).Error; err != nil {
+		return
+	}
+	nodes := make([]*model.Node, 0)
+	if err = mysql.DB.Model(model.DefaultNode).Select("id", "parent_id").Find(&nodes).Error; err != nil {
+		return
+	}
+	parents := lo.SliceToMap(nodes, func(n *model.Node) (int, int) { return n.Id, n.ParentId })
+	for id := asset.ParentId; id != 0 && !lo.Contains(nodeIds, id); id = parents[id] {
+		nodeIds = append(nodeIds, id)
+	}
+	return
+}
+
+func send(ctx context.Context, n *model.Notification, text string) error {
+	ch, ok := channels[n.Channel]
+	if !ok {
+		return fmt.Errorf("unknown notification channel %s", n.Channel)
+	}
+	return ch.Send(ctx, n.Url, lo.Ternary(n.Secret == "", "", util.DecryptAES(n.Secret)), title, text)
+}
+
+func localize(lang string, msg *i18n.Message, data map[string]any) string {
+	lang = lo.Ternary(lang == "", "en", lang)
+	text, _ := i18n.NewLocalizer(myi18n.Bundle, lang).Localize(&i18n.LocalizeConfig{
+		DefaultMessage: msg,
+		TemplateData:   myi18n.TimeData(data, "", lang),
+	})
+	return text
+}
+
+// post sends body as json, the response is decoded into res if it is not nil
+func post(ctx context.Context, u string, body, res any) error {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rbs, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, rbs)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(rbs, res)
+}
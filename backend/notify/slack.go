@@ -0,0 +1,21 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/veops/oneterm/model"
+)
+
+func init() {
+	register(model.NOTIFYCHANNEL_SLACK, &slack{})
+}
+
+// slack posts text to incoming webhooks of apps, which are not signed so secret is ignored
+//
+//	https://api.slack.com/messaging/webhooks
+type slack struct{}
+
+func (s *slack) Send(ctx context.Context, u, secret, title, text string) error {
+	return post(ctx, u, map[string]any{"text": fmt.Sprintf("*%s*\n%s", title, text)}, nil)
+}
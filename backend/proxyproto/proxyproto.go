@@ -0,0 +1,211 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	LISTENER_HTTP    = "http"
+	LISTENER_SSH     = "ssh"
+	LISTENER_DBPROXY = "dbProxy"
+
+	// v1MaxLen is the longest header of v1 including crlf
+	v1MaxLen = 107
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	trusted []*net.IPNet
+)
+
+func init() {
+	for _, s := range conf.Cfg.Proxy.TrustedProxies {
+		if !strings.Contains(s, "/") {
+			s += lo.Ternary(strings.Contains(s, ":"), "/128", "/32")
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			logger.L().Warn("invalid trusted proxy", zap.String("proxy", s), zap.Error(err))
+			continue
+		}
+		trusted = append(trusted, n)
+	}
+}
+
+// Trusted tells whether the ip is of a trusted proxy
+func Trusted(ip net.IP) bool {
+	return ip != nil && lo.SomeBy(trusted, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// Listen listens on the address, connections of trusted proxies must start with proxy protocol headers if the
+// listener is one of the config, so that their remote addresses are the ones of clients
+func Listen(name, addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil || !lo.Contains(conf.Cfg.Proxy.ProxyProtocol, name) {
+		return l, err
+	}
+	return &listener{Listener: l, timeout: time.Second * time.Duration(conf.Cfg.Proxy.HeaderTimeout)}, nil
+}
+
+type listener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+// Accept does not read headers, they are read by the goroutines of connections so that slow ones block nobody
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); !ok || !Trusted(addr.IP) {
+		return c, nil
+	}
+	return &conn{Conn: c, r: bufio.NewReader(c), timeout: l.timeout}, nil
+}
+
+// conn reads the header on the first read or asking for the remote address, servers speaking first like ssh and
+// mysql could write before it since proxies send headers right after connecting
+type conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	return c.remote
+}
+
+func (c *conn) readHeader() {
+	c.remote = c.Conn.RemoteAddr()
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	addr, err := parse(c.r)
+	if err != nil {
+		c.err = fmt.Errorf("read proxy protocol header of %s: %w", c.remote, err)
+		logger.L().Warn("invalid proxy protocol header", zap.String("proxy", c.remote.String()), zap.Error(err))
+		c.Conn.Close()
+		return
+	}
+	// local commands, e.g. health checks of proxies, keep the address of the proxy
+	if addr != nil {
+		c.remote = addr
+	}
+}
+
+// parse reads a header of v1 or v2, the address is nil for local commands and unknown families
+//
+//	https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+func parse(r *bufio.Reader) (net.Addr, error) {
+	// the shortest v1 header PROXY UNKNOWN\r\n is longer than the signature of v2
+	bs, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(bs, v2Signature):
+		return parseV2(r)
+	case bytes.HasPrefix(bs, v1Prefix):
+		return parseV1(r)
+	}
+	return nil, errors.New("no proxy protocol header")
+}
+
+// parseV1 reads e.g. PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func parseV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, v1MaxLen)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLen {
+			return nil, errors.New("v1 header is too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 {
+		return nil, errors.New("invalid v1 header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid v1 source %s %s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseV2 reads the binary header, tlvs after addresses are skipped
+func parseV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	// 0 is local and 1 is proxy
+	if hdr[12]&0x0f == 0 {
+		return nil, nil
+	}
+	if hdr[12]&0x0f != 1 {
+		return nil, fmt.Errorf("unsupported v2 command %d", hdr[12]&0x0f)
+	}
+	switch hdr[13] >> 4 {
+	case 1:
+		if len(body) < 12 {
+			return nil, errors.New("short v2 ipv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2:
+		if len(body) < 36 {
+			return nil, errors.New("short v2 ipv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	// unix sockets and unspecified families keep the address of the proxy
+	return nil, nil
+}
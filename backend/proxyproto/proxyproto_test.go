@@ -0,0 +1,286 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2Header frames body as a v2 header of the version and command, and the family and transport
+func v2Header(verCmd, fam byte, body []byte) []byte {
+	hdr := append(append([]byte{}, v2Signature...), verCmd, fam)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return append(hdr, body...)
+}
+
+// v2Addrs is the body of source and destination addresses and ports
+func v2Addrs(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	body := append(append([]byte{}, src...), dst...)
+	body = binary.BigEndian.AppendUint16(body, srcPort)
+	return binary.BigEndian.AppendUint16(body, dstPort)
+}
+
+func TestParse(t *testing.T) {
+	ip4 := func(s string) net.IP { return net.ParseIP(s).To4() }
+	ip6 := func(s string) net.IP { return net.ParseIP(s).To16() }
+	tests := []struct {
+		name     string
+		data     []byte
+		want     string
+		wantRest string
+		wantErr  bool
+	}{
+		{
+			name:     "v1 tcp4",
+			data:     []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\npayload"),
+			want:     "192.168.0.1:56324",
+			wantRest: "payload",
+		},
+		{
+			name:     "v1 tcp6",
+			data:     []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\npayload"),
+			want:     "[2001:db8::1]:56324",
+			wantRest: "payload",
+		},
+		{
+			name:     "v1 unknown",
+			data:     []byte("PROXY UNKNOWN\r\npayload"),
+			wantRest: "payload",
+		},
+		{
+			name:     "v1 unknown with addresses",
+			data:     []byte("PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\npayload"),
+			wantRest: "payload",
+		},
+		{
+			name:    "v1 over long",
+			data:    []byte("PROXY UNKNOWN " + strings.Repeat("x", v1MaxLen) + "\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 over long without crlf",
+			data:    []byte("PROXY TCP4 " + strings.Repeat("1", v1MaxLen*2)),
+			wantErr: true,
+		},
+		{
+			name:    "v1 truncated",
+			data:    []byte("PROXY TCP4 192.168.0.1 192.168"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 unsupported protocol",
+			data:    []byte("PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 missing port",
+			data:    []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 invalid ip",
+			data:    []byte("PROXY TCP4 192.168.0.256 192.168.0.11 56324 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 port out of range",
+			data:    []byte("PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:     "v2 local",
+			data:     append(v2Header(0x20, 0x00, nil), "payload"...),
+			wantRest: "payload",
+		},
+		{
+			name:     "v2 local with addresses skipped",
+			data:     append(v2Header(0x20, 0x11, v2Addrs(ip4("10.0.0.1"), ip4("10.0.0.2"), 1234, 443)), "payload"...),
+			wantRest: "payload",
+		},
+		{
+			name:     "v2 proxy tcp4",
+			data:     append(v2Header(0x21, 0x11, v2Addrs(ip4("192.168.0.1"), ip4("192.168.0.11"), 56324, 443)), "payload"...),
+			want:     "192.168.0.1:56324",
+			wantRest: "payload",
+		},
+		{
+			name:     "v2 proxy tcp6",
+			data:     append(v2Header(0x21, 0x21, v2Addrs(ip6("2001:db8::1"), ip6("2001:db8::2"), 56324, 443)), "payload"...),
+			want:     "[2001:db8::1]:56324",
+			wantRest: "payload",
+		},
+		{
+			name:     "v2 proxy with tlvs skipped",
+			data:     append(v2Header(0x21, 0x11, append(v2Addrs(ip4("192.168.0.1"), ip4("192.168.0.11"), 56324, 443), 0x04, 0x00, 0x01, 0x00)), "payload"...),
+			want:     "192.168.0.1:56324",
+			wantRest: "payload",
+		},
+		{
+			name:     "v2 proxy of unix sockets",
+			data:     append(v2Header(0x21, 0x31, make([]byte, 216)), "payload"...),
+			wantRest: "payload",
+		},
+		{
+			name:    "v2 short ipv4 addresses",
+			data:    v2Header(0x21, 0x11, make([]byte, 8)),
+			wantErr: true,
+		},
+		{
+			name:    "v2 short ipv6 addresses",
+			data:    v2Header(0x21, 0x21, make([]byte, 12)),
+			wantErr: true,
+		},
+		{
+			name:    "v2 truncated header",
+			data:    v2Header(0x21, 0x11, nil)[:14],
+			wantErr: true,
+		},
+		{
+			name:    "v2 truncated addresses",
+			data:    v2Header(0x21, 0x11, v2Addrs(ip4("192.168.0.1"), ip4("192.168.0.11"), 56324, 443))[:20],
+			wantErr: true,
+		},
+		{
+			name:    "v2 unsupported version",
+			data:    v2Header(0x11, 0x11, v2Addrs(ip4("192.168.0.1"), ip4("192.168.0.11"), 56324, 443)),
+			wantErr: true,
+		},
+		{
+			name:    "v2 unsupported command",
+			data:    v2Header(0x22, 0x11, v2Addrs(ip4("192.168.0.1"), ip4("192.168.0.11"), 56324, 443)),
+			wantErr: true,
+		},
+		{
+			name:    "no header",
+			data:    []byte("SSH-2.0-OpenSSH_9.6\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "shorter than any header",
+			data:    []byte("PROXY"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.data))
+			got, err := parse(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+				t.Errorf("parse() = %v, want %q", got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != tt.wantRest {
+				t.Errorf("rest after parse() = %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		data       string
+		wantRemote string
+		wantRead   string
+		wantErr    bool
+	}{
+		{
+			name:       "trusted proxy",
+			trusted:    "127.0.0.0/8",
+			data:       "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello",
+			wantRemote: "192.168.0.1",
+			wantRead:   "hello",
+		},
+		{
+			name:       "trusted proxy without header",
+			trusted:    "127.0.0.0/8",
+			data:       "hello, without any header",
+			wantRemote: "127.0.0.1",
+			wantErr:    true,
+		},
+		{
+			name:       "untrusted peer",
+			trusted:    "10.0.0.0/8",
+			data:       "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello",
+			wantRemote: "127.0.0.1",
+			wantRead:   "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(old []*net.IPNet) { trusted = old }(trusted)
+			_, n, _ := net.ParseCIDR(tt.trusted)
+			trusted = []*net.IPNet{n}
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pl := &listener{Listener: l, timeout: time.Second}
+			defer pl.Close()
+
+			go func() {
+				c, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				c.Write([]byte(tt.data))
+				io.Copy(io.Discard, c)
+			}()
+			c, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if got := c.RemoteAddr().(*net.TCPAddr).IP.String(); got != tt.wantRemote {
+				t.Errorf("RemoteAddr() = %v, want %v", got, tt.wantRemote)
+			}
+			got := make([]byte, max(len(tt.wantRead), 1))
+			_, err = io.ReadFull(c, got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Read() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && string(got) != tt.wantRead {
+				t.Errorf("Read() = %q, want %q", got, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	defer func(old []*net.IPNet) { trusted = old }(trusted)
+	_, v4, _ := net.ParseCIDR("10.0.0.0/8")
+	_, v6, _ := net.ParseCIDR("fd00::/8")
+	trusted = []*net.IPNet{v4, v6}
+
+	tests := []struct {
+		ip   net.IP
+		want bool
+	}{
+		{ip: net.ParseIP("10.1.2.3"), want: true},
+		{ip: net.ParseIP("fd00::1"), want: true},
+		{ip: net.ParseIP("192.168.0.1"), want: false},
+		{ip: net.ParseIP("2001:db8::1"), want: false},
+		{ip: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := Trusted(tt.ip); got != tt.want {
+			t.Errorf("Trusted(%v) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
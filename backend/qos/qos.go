@@ -0,0 +1,123 @@
+package qos
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// chunk is the most bytes of bulk transfers read at a time
+	chunk = 32 * 1024
+	// backlog of output beyond it is flushed anyway, so that slow sessions never hold unbounded output
+	backlog = 8 * 1024 * 1024
+	// waitStep is how long bulk transfers wait before they try again
+	waitStep = time.Millisecond * 50
+)
+
+var (
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+)
+
+// rate of the shared bucket in bytes per second, the burst is one second of it
+func rate() float64 {
+	return float64(conf.Cfg.Qos.Bandwidth) * 1024
+}
+
+func enabled() bool {
+	return conf.Cfg.Qos.Bandwidth > 0
+}
+
+// refill must be called with mu held
+func refill(now time.Time) {
+	r := rate()
+	if !last.IsZero() {
+		tokens = math.Min(r, tokens+now.Sub(last).Seconds()*r)
+	} else {
+		tokens = r
+	}
+	last = now
+}
+
+// Take counts n bytes of interactive traffic, it never waits so that keystroke echo never queues behind bulk traffic.
+// Tokens may go negative which holds bulk traffic back until they recover
+func Take(n int) {
+	if !enabled() || n <= 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	refill(time.Now())
+	tokens -= float64(n)
+}
+
+// Allow returns how many of n bytes of bulk traffic may be sent now, bulk traffic never takes the reserve of interactive one
+func Allow(n int) int {
+	if !enabled() {
+		return n
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	refill(time.Now())
+	k := min(n, int(math.Max(0, tokens-rate()*float64(conf.Cfg.Qos.Reserve)/100)))
+	tokens -= float64(k)
+	return k
+}
+
+// Wait blocks until n bytes of bulk traffic are allowed
+func Wait(ctx context.Context, n int) error {
+	for n -= Allow(n); n > 0; n -= Allow(n) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitStep):
+		}
+	}
+	return nil
+}
+
+// Flush returns how many of n bytes of output buffered by the session are flushed now. Small flushes of interactive
+// sessions are keystroke echo and go at once, big ones and ones of bulk sessions are shrunk under pressure first
+func Flush(sess *model.Session, n int) int {
+	if !enabled() || n <= 0 {
+		return n
+	}
+	if (!sess.IsBulk() && n <= conf.Cfg.Qos.BulkFlush) || n > backlog {
+		Take(n)
+		return n
+	}
+	return Allow(n)
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err = r.r.Read(p)
+	if n > 0 {
+		if e := Wait(r.ctx, n); e != nil {
+			return n, e
+		}
+	}
+	return
+}
+
+// NewReader limits bulk transfers read from r by the shared bandwidth
+func NewReader(ctx context.Context, r io.Reader) io.Reader {
+	if !enabled() {
+		return r
+	}
+	return &reader{ctx: ctx, r: r}
+}
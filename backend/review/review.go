@@ -0,0 +1,164 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/approval"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// defaultSla is hours of reviews if the config does not set it
+	defaultSla = 24
+)
+
+var (
+	// mu keeps assignments in turn so that pending reviews counted are not stale
+	mu sync.Mutex
+)
+
+type load struct {
+	ReviewerId int
+	Count      int64
+}
+
+// Notice is sent to approvers like notices of approvals when a review is assigned or overdue
+type Notice struct {
+	SessionReview *model.SessionReview `json:"session_review"`
+	Overdue       bool                 `json:"overdue"`
+}
+
+// Flag flags the session for review and assigns it to a reviewer of the config, reasons of sessions flagged
+// already are appended to their pending reviews. Reviews are left unassigned for admins if there is no reviewer
+func Flag(sessionId, reason, by string) (r *model.SessionReview, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	r = &model.SessionReview{}
+	err = mysql.DB.Model(r).Where("session_id = ? AND status = ?", sessionId, model.REVIEW_STATUS_PENDING).First(r).Error
+	if err == nil {
+		r.Reason = fmt.Sprintf("%s; %s", r.Reason, reason)
+		return r, mysql.DB.Model(r).Update("reason", r.Reason).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+
+	sess := &model.Session{}
+	if err = mysql.AuditDB.Model(sess).Where("session_id = ?", sessionId).First(sess).Error; err != nil {
+		return
+	}
+	cfg := model.GlobalConfig.Load().Review
+	reviewer, err := assign(cfg, sess.AssetId)
+	if err != nil {
+		return
+	}
+	r = &model.SessionReview{
+		SessionId:  sessionId,
+		AssetId:    sess.AssetId,
+		Reason:     reason,
+		FlaggedBy:  by,
+		ReviewerId: reviewer.Uid,
+		Reviewer:   reviewer.UserName,
+		Status:     model.REVIEW_STATUS_PENDING,
+		DueAt:      time.Now().Add(time.Hour * time.Duration(lo.Ternary(cfg.Sla > 0, cfg.Sla, defaultSla))),
+	}
+	if err = mysql.DB.Model(r).Create(r).Error; err != nil {
+		return
+	}
+	if r.ReviewerId != 0 {
+		approval.Notify(&Notice{SessionReview: r})
+	}
+	return
+}
+
+// Reviewer returns the reviewer of uid in the config
+func Reviewer(uid int) (model.Reviewer, bool) {
+	return lo.Find(model.GlobalConfig.Load().Review.Reviewers, func(r model.Reviewer) bool { return r.Uid == uid })
+}
+
+// Workload returns how many pending reviews reviewers have, or overdue ones of them if overdue is set
+func Workload(uids []int, overdue bool) (loads map[int]int64, err error) {
+	db := mysql.DB.Model(model.DefaultSessionReview).
+		Select("reviewer_id, COUNT(*) AS count").
+		Where("status = ? AND reviewer_id IN ?", model.REVIEW_STATUS_PENDING, uids)
+	if overdue {
+		db = db.Where("due_at < ?", time.Now())
+	}
+	rows := make([]*load, 0)
+	if err = db.Group("reviewer_id").Scan(&rows).Error; err != nil {
+		return
+	}
+	loads = lo.SliceToMap(rows, func(r *load) (int, int64) { return r.ReviewerId, r.Count })
+	return
+}
+
+func assign(cfg model.Review, assetId int) (reviewer model.Reviewer, err error) {
+	if len(cfg.Reviewers) == 0 {
+		return
+	}
+	switch cfg.Strategy {
+	case model.REVIEW_STRATEGY_OWNER:
+		asset := &model.Asset{}
+		if mysql.DB.Model(asset).Where("id = ?", assetId).First(asset).Error == nil {
+			// owners of the asset come before its creator
+			uids := lo.FilterMap(asset.Owners, func(c model.Contact, _ int) (int, bool) { return c.Id, c.Type == model.CONTACT_TYPE_USER })
+			for _, uid := range append(uids, asset.CreatorId) {
+				if owner, ok := Reviewer(uid); ok {
+					return owner, nil
+				}
+			}
+		}
+		return leastLoaded(cfg.Reviewers)
+	case model.REVIEW_STRATEGY_LEAST_LOADED:
+		return leastLoaded(cfg.Reviewers)
+	default:
+		// the one after the reviewer assigned latest takes it, so the turn survives restarts
+		uids := lo.Map(cfg.Reviewers, func(r model.Reviewer, _ int) int { return r.Uid })
+		last := &model.SessionReview{}
+		err = mysql.DB.Model(last).Where("reviewer_id IN ?", uids).Order("id DESC").First(last).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		return cfg.Reviewers[(lo.IndexOf(uids, last.ReviewerId)+1)%len(uids)], nil
+	}
+}
+
+// leastLoaded returns the reviewer with the fewest pending reviews, ties go to the former one in the config
+func leastLoaded(reviewers []model.Reviewer) (reviewer model.Reviewer, err error) {
+	loads, err := Workload(lo.Map(reviewers, func(r model.Reviewer, _ int) int { return r.Uid }), false)
+	if err != nil {
+		return
+	}
+	return lo.MinBy(reviewers, func(a, b model.Reviewer) bool { return loads[a.Uid] < loads[b.Uid] }), nil
+}
+
+// EscalateOverdue notifies approvers of reviews not done before they are due, each review is escalated once
+func EscalateOverdue() {
+	now := time.Now()
+	reviews := make([]*model.SessionReview, 0)
+	if err := mysql.DB.Model(model.DefaultSessionReview).
+		Where("status = ? AND escalated_at IS NULL AND due_at < ?", model.REVIEW_STATUS_PENDING, now).
+		Find(&reviews).Error; err != nil {
+		logger.L().Error("get overdue session reviews failed", zap.Error(err))
+		return
+	}
+	for _, r := range reviews {
+		if err := mysql.DB.Model(r).Update("escalated_at", now).Error; err != nil {
+			logger.L().Error("escalate session review failed", zap.Int("id", r.Id), zap.Error(err))
+			continue
+		}
+		r.EscalatedAt = &now
+		logger.L().Warn("session review overdue", zap.Int("id", r.Id), zap.String("sessionId", r.SessionId), zap.String("reviewer", r.Reviewer))
+		approval.Notify(&Notice{SessionReview: r, Overdue: true})
+	}
+}
@@ -0,0 +1,106 @@
+package rotation
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+// Policies returns effective policies of the accounts by ids, own enabled policies of accounts win, otherwise
+// policies of nodes of all assets they are authorized on are merged, each asset takes its nearest enabled node.
+// Accounts without any policy are not in res, inherited tells ones taken from nodes
+func Policies(accounts []*model.Account) (res map[int]model.PasswordPolicy, inherited map[int]bool, err error) {
+	nodes := make([]*model.Node, 0)
+	if err = mysql.DB.Model(model.DefaultNode).Find(&nodes).Error; err != nil {
+		return
+	}
+	assets := make([]*model.Asset, 0)
+	if err = mysql.DB.Model(model.DefaultAsset).Select("id", "parent_id", "authorization").Find(&assets).Error; err != nil {
+		return
+	}
+	byId := lo.SliceToMap(nodes, func(n *model.Node) (int, *model.Node) { return n.Id, n })
+	nearest := func(id int) (p model.PasswordPolicy) {
+		for seen := map[int]bool{}; id != 0 && !seen[id] && byId[id] != nil; id = byId[id].ParentId {
+			if seen[id] = true; byId[id].Policy.Enable {
+				return byId[id].Policy
+			}
+		}
+		return
+	}
+
+	res, inherited = map[int]model.PasswordPolicy{}, map[int]bool{}
+	for _, a := range accounts {
+		if a.Policy.Enable {
+			res[a.Id] = a.Policy
+		}
+	}
+	for _, asset := range assets {
+		p := nearest(asset.ParentId)
+		if !p.Enable {
+			continue
+		}
+		for id := range asset.Authorization {
+			if _, own := res[id]; own && !inherited[id] {
+				continue
+			}
+			res[id], inherited[id] = res[id].Merge(p), true
+		}
+	}
+	return
+}
+
+// Compliance returns accounts of passwords violating their effective policies, secrets of providers are not
+// checked since they are not saved
+func Compliance() (res []*model.PolicyCompliance, err error) {
+	accounts := make([]*model.Account, 0)
+	if err = mysql.DB.Model(model.DefaultAccount).
+		Where("account_type = ? AND cred_provider = ''", model.AUTHMETHOD_PASSWORD).
+		Find(&accounts).Error; err != nil {
+		return
+	}
+	policies, inherited, err := Policies(accounts)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, a := range accounts {
+		p, ok := policies[a.Id]
+		if !ok {
+			continue
+		}
+		violations := p.Violations(util.DecryptAES(a.Password))
+		if p.Interval > 0 && !lastRotated(a).AddDate(0, 0, p.Interval).After(now) {
+			violations = append(violations, model.POLICYVIOLATION_OVERDUE)
+		}
+		if len(violations) == 0 {
+			continue
+		}
+		res = append(res, &model.PolicyCompliance{
+			AccountId:  a.Id,
+			Name:       a.Name,
+			Account:    a.Account,
+			Policy:     p,
+			Inherited:  inherited[a.Id],
+			RotatedAt:  a.Rotation.RotatedAt,
+			Violations: violations,
+		})
+	}
+	return
+}
+
+// interval of rotation of the account, the one of the policy takes over its own
+func interval(account *model.Account, p model.PasswordPolicy) int {
+	return lo.Ternary(p.Enable && p.Interval > 0, p.Interval, account.Rotation.Interval)
+}
+
+// lastRotated is when the password was set, accounts never rotated take their creation
+func lastRotated(account *model.Account) time.Time {
+	if account.Rotation.RotatedAt != nil {
+		return *account.Rotation.RotatedAt
+	}
+	return account.CreatedAt
+}
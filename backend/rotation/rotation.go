@@ -0,0 +1,176 @@
+package rotation
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	// characters which are not special in shells, cmd, chpasswd and net user
+	passwordChars = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789-_.@+"
+	passwordDigit = "23456789"
+	passwordUpper = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	passwordLower = "abcdefghijkmnpqrstuvwxyz"
+	passwordSign  = "-_.@+"
+)
+
+var (
+	rotating sync.Map
+)
+
+// Rotate changes the password of account on all assets it is authorized on and saves the new one.
+// Once it is changed on any asset the new password is saved, failed assets are left in histories for admins,
+// since the old password does not work on the changed ones anymore.
+func Rotate(accountId, uid int) (histories []*model.RotationHistory, err error) {
+	if _, loaded := rotating.LoadOrStore(accountId, struct{}{}); loaded {
+		return nil, fmt.Errorf("account %d is being rotated", accountId)
+	}
+	defer rotating.Delete(accountId)
+
+	account := &model.Account{}
+	if err = mysql.DB.Model(account).Where("id = ?", accountId).First(account).Error; err != nil {
+		return
+	}
+	if account.Credential.Provider != "" {
+		return nil, fmt.Errorf("password of account %s is managed by %s", account.Name, account.Credential.Provider)
+	}
+	if account.AccountType != model.AUTHMETHOD_PASSWORD {
+		return nil, fmt.Errorf("account %s does not use password", account.Name)
+	}
+	assets := make([]*model.Asset, 0)
+	if err = mysql.DB.Model(model.DefaultAsset).
+		Where("JSON_CONTAINS_PATH(authorization, 'one', ?)", fmt.Sprintf(`$."%d"`, accountId)).
+		Find(&assets).Error; err != nil {
+		return
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("account %s is not authorized on any asset", account.Name)
+	}
+	policies, _, err := Policies([]*model.Account{account})
+	if err != nil {
+		return
+	}
+	password, err := generate(max(conf.Cfg.Rotation.Length, policies[account.Id].MinLength))
+	if err != nil {
+		return
+	}
+
+	batchId := uuid.New().String()
+	for _, asset := range assets {
+		h := &model.RotationHistory{
+			BatchId:   batchId,
+			AccountId: account.Id,
+			Account:   account.Account,
+			AssetId:   asset.Id,
+			Status:    model.ROTATIONSTATUS_SUCCESS,
+			CreatorId: uid,
+		}
+		if h.Method, err = rotateAsset(asset, account, password); err != nil {
+			h.Status, h.Message = model.ROTATIONSTATUS_FAILED, err.Error()
+			logger.L().Warn("rotate password failed", zap.Int("accountId", account.Id), zap.Int("assetId", asset.Id), zap.Error(err))
+		}
+		histories = append(histories, h)
+	}
+	if err = mysql.DB.Create(&histories).Error; err != nil {
+		logger.L().Error("save rotation histories failed", zap.Error(err))
+	}
+
+	now := time.Now()
+	updates := map[string]any{"rotation_rotated_at": now}
+	if lo.ContainsBy(histories, func(h *model.RotationHistory) bool { return h.Status == model.ROTATIONSTATUS_SUCCESS }) {
+		updates["password"] = util.EncryptAES(password)
+		updates["updated_at"] = now
+	}
+	err = mysql.DB.Model(account).Where("id = ?", account.Id).Updates(updates).Error
+
+	return
+}
+
+// RotateDue rotates enabled accounts whose interval has passed since the last rotation, intervals of effective
+// policies take over their own ones
+func RotateDue() {
+	accounts := make([]*model.Account, 0)
+	if err := mysql.DB.Model(model.DefaultAccount).
+		Where("rotation_enable = ? AND account_type = ? AND cred_provider = ''", true, model.AUTHMETHOD_PASSWORD).
+		Find(&accounts).Error; err != nil {
+		logger.L().Warn("get accounts to rotate failed", zap.Error(err))
+		return
+	}
+	policies, _, err := Policies(accounts)
+	if err != nil {
+		logger.L().Warn("get policies of accounts to rotate failed", zap.Error(err))
+		return
+	}
+	now := time.Now()
+	for _, a := range accounts {
+		interval := interval(a, policies[a.Id])
+		if interval <= 0 || (a.Rotation.RotatedAt != nil && a.Rotation.RotatedAt.AddDate(0, 0, interval).After(now)) {
+			continue
+		}
+		if _, err := Rotate(a.Id, 0); err != nil {
+			logger.L().Warn("rotate account failed", zap.Int("accountId", a.Id), zap.Error(err))
+		}
+	}
+}
+
+func rotateAsset(asset *model.Asset, account *model.Account, password string) (method string, err error) {
+	protocols := strings.ToLower(strings.Join(asset.Protocols, ","))
+	privileged := asset.Sudo.AccountId > 0 && asset.Sudo.AccountId != account.Id
+	switch {
+	case strings.Contains(protocols, "ssh") && privileged:
+		return model.ROTATIONMETHOD_CHPASSWD, chpasswd(asset, account, password)
+	case strings.Contains(protocols, "ssh"):
+		return model.ROTATIONMETHOD_PASSWD, passwd(asset, account, password)
+	case strings.Contains(protocols, "rdp") || strings.Contains(protocols, "winrm"):
+		return model.ROTATIONMETHOD_WINRM, netUser(asset, account, password)
+	}
+	return "", fmt.Errorf("no protocol to rotate password")
+}
+
+// generate returns a random password containing digits, upper and lower letters and signs for common complexity rules
+func generate(length int) (string, error) {
+	length = max(length, 12)
+	bs := make([]byte, 0, length)
+	for i := 0; i < length; i++ {
+		chars := passwordChars
+		switch i {
+		case 0:
+			chars = passwordLower
+		case 1:
+			chars = passwordUpper
+		case 2:
+			chars = passwordDigit
+		case 3:
+			chars = passwordSign
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+		bs = append(bs, chars[n.Int64()])
+	}
+	// shuffle so that the required kinds are not always at the head, it still starts with a letter not to be taken as an option
+	for i := len(bs) - 1; i > 1; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i)))
+		if err != nil {
+			return "", err
+		}
+		j := n.Int64() + 1
+		bs[i], bs[j] = bs[j], bs[i]
+	}
+	return string(bs), nil
+}
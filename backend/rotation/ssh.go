@@ -0,0 +1,143 @@
+package rotation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	gossh "golang.org/x/crypto/ssh"
+
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	rePasswdPrompt  = regexp.MustCompile(`(?i)password[^\n:]*:\s*$`)
+	rePasswdCurrent = regexp.MustCompile(`(?i)(current|old)[^\n]*password[^\n:]*:\s*$`)
+)
+
+// dialSsh dials the asset with the account, close must be called when it is done
+func dialSsh(assetId, accountId int) (cli *gossh.Client, account *model.Account, close func(), err error) {
+	asset, account, gateway, err := util.GetAAG(assetId, accountId)
+	if err != nil {
+		return
+	}
+	tunnelId := uuid.New().String()
+	closeTunnel := func() { ggateway.GetGatewayManager().Close(tunnelId) }
+	ip, port, err := util.Proxy(false, tunnelId, "ssh", asset, gateway)
+	if err != nil {
+		closeTunnel()
+		return
+	}
+	auth, err := util.GetAuth(account)
+	if err != nil {
+		closeTunnel()
+		return
+	}
+	cli, err = gossh.Dial("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), &gossh.ClientConfig{
+		User:            account.Account,
+		Auth:            []gossh.AuthMethod{auth},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second * 5,
+	})
+	if err != nil {
+		closeTunnel()
+		return
+	}
+	close = func() {
+		cli.Close()
+		closeTunnel()
+	}
+	return
+}
+
+// chpasswd sets the password by the privileged account of sudo, which needs no current password
+func chpasswd(asset *model.Asset, account *model.Account, password string) (err error) {
+	cli, priv, close, err := dialSsh(asset.Id, asset.Sudo.AccountId)
+	if err != nil {
+		return
+	}
+	defer close()
+	sess, err := cli.NewSession()
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+
+	script := fmt.Sprintf("printf '%%s\\n' %s | chpasswd", quote(account.Account+":"+password))
+	if priv.Account != "root" {
+		script = "sudo -n sh -c " + quote(script)
+	}
+	if out, err := sess.CombinedOutput(script); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return
+}
+
+// passwd changes the password by the account itself, prompts of passwd are answered in a pty
+// since it reads from the terminal, the current password is asked unless it is root
+func passwd(asset *model.Asset, account *model.Account, password string) (err error) {
+	cli, current, close, err := dialSsh(asset.Id, account.Id)
+	if err != nil {
+		return
+	}
+	defer close()
+	sess, err := cli.NewSession()
+	if err != nil {
+		return
+	}
+	defer sess.Close()
+
+	if err = sess.RequestPty("xterm", 24, 80, gossh.TerminalModes{gossh.ECHO: 0}); err != nil {
+		return
+	}
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err = sess.Start("LC_ALL=C passwd"); err != nil {
+		return
+	}
+	timer := time.AfterFunc(time.Second*30, func() { sess.Close() })
+	defer timer.Stop()
+
+	// output since the last answer, and all of it for errors
+	prompt, out := &bytes.Buffer{}, &bytes.Buffer{}
+	buf := make([]byte, 1024)
+	for {
+		n, e := stdout.Read(buf)
+		prompt.Write(buf[:n])
+		out.Write(buf[:n])
+		if p := prompt.String(); rePasswdPrompt.MatchString(p) {
+			answer := password
+			if rePasswdCurrent.MatchString(p) {
+				answer = current.Password
+			}
+			if _, err = io.WriteString(stdin, answer+"\n"); err != nil {
+				return
+			}
+			prompt.Reset()
+		}
+		if e != nil {
+			break
+		}
+	}
+	if err = sess.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out.String()))
+	}
+	return
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
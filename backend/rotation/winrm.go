@@ -0,0 +1,208 @@
+package rotation
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/conf"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	wsmanShell   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell"
+	wsmanCmd     = wsmanShell + "/cmd"
+	wsmanCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	wsmanDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	wsmanCommand = wsmanShell + "/Command"
+	wsmanReceive = wsmanShell + "/Receive"
+	wsmanDone    = wsmanShell + "/CommandState/Done"
+)
+
+var (
+	reWsmanShellId   = regexp.MustCompile(`<(?:\w+:)?ShellId>([^<]+)<`)
+	reWsmanCommandId = regexp.MustCompile(`<(?:\w+:)?CommandId>([^<]+)<`)
+)
+
+type wsmanReceived struct {
+	Streams []struct {
+		Name string `xml:"Name,attr"`
+		Data string `xml:",chardata"`
+	} `xml:"Body>ReceiveResponse>Stream"`
+	State struct {
+		State    string `xml:"State,attr"`
+		ExitCode int    `xml:"ExitCode"`
+	} `xml:"Body>ReceiveResponse>CommandState"`
+}
+
+// winrm runs commands in a cmd shell with basic auth, so basic auth must be allowed by the service of the target.
+// Https is used if the port is 5986
+//
+//	https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-wsmv
+type winrm struct {
+	url      string
+	user     string
+	password string
+	cli      *http.Client
+}
+
+// netUser sets the password by the privileged account of sudo if there is one, otherwise the account itself must be an administrator
+func netUser(asset *model.Asset, account *model.Account, password string) (err error) {
+	runner := account.Id
+	if asset.Sudo.AccountId > 0 {
+		runner = asset.Sudo.AccountId
+	}
+	code, out, err := runWinrm(asset.Id, runner, "net", "user", account.Account, password)
+	if err != nil {
+		return
+	}
+	if code != 0 {
+		return fmt.Errorf("net user exited with %d: %s", code, strings.TrimSpace(out))
+	}
+	return
+}
+
+// RunScript runs script by cmd on the windows asset as the account
+func RunScript(assetId, accountId int, script string) (err error) {
+	code, out, err := runWinrm(assetId, accountId, "cmd", "/c", script)
+	if err != nil {
+		return
+	}
+	if code != 0 {
+		return fmt.Errorf("script exited with %d: %s", code, strings.TrimSpace(out))
+	}
+	return
+}
+
+// runWinrm connects to the winrm service of the asset through its gateway and runs the command as the account
+func runWinrm(assetId, accountId int, cmd string, args ...string) (code int, out string, err error) {
+	asset, acc, gateway, err := util.GetAAG(assetId, accountId)
+	if err != nil {
+		return
+	}
+	target := conf.Cfg.Rotation.WinrmPort
+	if p, ok := lo.Find(asset.Protocols, func(p string) bool { return strings.HasPrefix(strings.ToLower(p), "winrm:") }); ok {
+		target = cast.ToInt(strings.Split(p, ":")[1])
+	}
+	a := *asset
+	a.Protocols = model.Slice[string]{fmt.Sprintf("winrm:%d", target)}
+	tunnelId := uuid.New().String()
+	defer ggateway.GetGatewayManager().Close(tunnelId)
+	ip, port, err := util.Proxy(false, tunnelId, "winrm", &a, gateway)
+	if err != nil {
+		return
+	}
+
+	scheme := lo.Ternary(target == 5986, "https", "http")
+	w := &winrm{
+		url:      fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(ip, fmt.Sprint(port))),
+		user:     acc.Account,
+		password: acc.Password,
+		cli: &http.Client{
+			Timeout:   time.Second * 30,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+	}
+	return w.run(cmd, args...)
+}
+
+// run executes a command in a new shell and returns its exit code and output
+func (w *winrm) run(cmd string, args ...string) (code int, out string, err error) {
+	res, err := w.post(wsmanCreate, "", `<w:OptionSet><w:Option Name="WINRS_NOPROFILE">TRUE</w:Option><w:Option Name="WINRS_CODEPAGE">65001</w:Option></w:OptionSet>`,
+		`<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`)
+	if err != nil {
+		return
+	}
+	m := reWsmanShellId.FindStringSubmatch(res)
+	if len(m) < 2 {
+		return 0, "", fmt.Errorf("no shell id in response")
+	}
+	shellId := m[1]
+	defer w.post(wsmanDelete, shellId, "", "")
+
+	arguments := ""
+	for _, a := range args {
+		arguments += "<rsp:Arguments>" + escape(a) + "</rsp:Arguments>"
+	}
+	if res, err = w.post(wsmanCommand, shellId, "",
+		"<rsp:CommandLine><rsp:Command>"+escape(cmd)+"</rsp:Command>"+arguments+"</rsp:CommandLine>"); err != nil {
+		return
+	}
+	if m = reWsmanCommandId.FindStringSubmatch(res); len(m) < 2 {
+		return 0, "", fmt.Errorf("no command id in response")
+	}
+	commandId := m[1]
+
+	output := &bytes.Buffer{}
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); {
+		if res, err = w.post(wsmanReceive, shellId, "",
+			`<rsp:Receive><rsp:DesiredStream CommandId="`+escape(commandId)+`">stdout stderr</rsp:DesiredStream></rsp:Receive>`); err != nil {
+			return
+		}
+		received := &wsmanReceived{}
+		if err = xml.Unmarshal([]byte(res), received); err != nil {
+			return
+		}
+		for _, s := range received.Streams {
+			bs, _ := base64.StdEncoding.DecodeString(s.Data)
+			output.Write(bs)
+		}
+		if received.State.State == wsmanDone {
+			return received.State.ExitCode, output.String(), nil
+		}
+	}
+	return 0, output.String(), fmt.Errorf("%s is not done in time", cmd)
+}
+
+func (w *winrm) post(action, shellId, options, body string) (res string, err error) {
+	selector := ""
+	if shellId != "" {
+		selector = `<w:SelectorSet><w:Selector Name="ShellId">` + escape(shellId) + `</w:Selector></w:SelectorSet>`
+	}
+	envelope := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+		`xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="` + wsmanShell + `"><s:Header>` +
+		`<a:To>` + escape(w.url) + `</a:To><a:ReplyTo><a:Address s:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>` +
+		`<a:Action s:mustUnderstand="true">` + action + `</a:Action><a:MessageID>uuid:` + uuid.New().String() + `</a:MessageID>` +
+		`<w:ResourceURI s:mustUnderstand="true">` + wsmanCmd + `</w:ResourceURI><w:MaxEnvelopeSize s:mustUnderstand="true">153600</w:MaxEnvelopeSize>` +
+		`<w:OperationTimeout>PT20S</w:OperationTimeout>` + selector + options + `</s:Header><s:Body>` + body + `</s:Body></s:Envelope>`
+
+	req, err := http.NewRequest(http.MethodPost, w.url, strings.NewReader(envelope))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.SetBasicAuth(w.user, w.password)
+	resp, err := w.cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("winrm %s: %s", resp.Status, strings.TrimSpace(string(bs)))
+	}
+	return string(bs), nil
+}
+
+func escape(s string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,21 @@
+package schedule
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/logger"
+	gsession "github.com/veops/oneterm/session"
+)
+
+// ExpireAccessGrants closes online sessions relying on access requests or grants which have ended
+func ExpireAccessGrants() {
+	now := time.Now()
+	n := gsession.CloseOnlineSessions(func(s *gsession.Session) bool {
+		return s.AccessRequestId != 0 && !now.Before(s.AccessEnd)
+	}, "expiry of access grant")
+	if n > 0 {
+		logger.L().Info("close sessions of expired access grants", zap.Int("count", n))
+	}
+}
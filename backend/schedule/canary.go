@@ -0,0 +1,28 @@
+package schedule
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/canary"
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+// RunCanaries opens synthetic sessions which may be slow, so it is called in a goroutine
+func RunCanaries() {
+	canary.RunDue()
+}
+
+func ExpireCanaries() {
+	days := conf.Cfg.Canary.RetentionDays
+	if days <= 0 {
+		return
+	}
+	n, err := canary.Expire(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logger.L().Warn("expire canary runs failed", zap.Error(err))
+	}
+	logger.L().Info("expire canary runs", zap.Int64("count", n))
+}
@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/cloud"
+)
+
+// SyncClouds calls apis of clouds which may be slow, so it is called in a goroutine
+func SyncClouds() {
+	cloud.SyncDue()
+}
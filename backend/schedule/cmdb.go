@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/cmdb"
+)
+
+// SyncCmdb calls apis of cmdb which may be slow, so it is called in a goroutine
+func SyncCmdb() {
+	cmdb.SyncDue()
+}
@@ -53,6 +53,14 @@ func UpdateConnectables(ids ...int) (err error) {
 		g.Phrase = util.DecryptAES(g.Phrase)
 	}
 	gatewayMap := lo.SliceToMap(gateways, func(g *model.Gateway) (int, *model.Gateway) { return g.Id, g })
+	for _, a := range assets {
+		if a.GatewayGroupId == 0 {
+			continue
+		}
+		if g, err := util.PickGateway(a); err == nil {
+			gatewayMap[g.Id] = g
+		}
+	}
 
 	all, oks := lo.Map(assets, func(a *model.Asset, _ int) int { return a.Id }), make([]int, 0)
 	sids := make([]string, 0)
@@ -85,7 +93,7 @@ func updateConnectable(asset *model.Asset, gateway *model.Gateway) (sid string,
 		logger.L().Debug("connectable proxy failed", zap.String("protocol", ps), zap.Error(err))
 		return
 	}
-	addr := fmt.Sprintf("%s:%d", ip, port)
+	addr := net.JoinHostPort(ip, fmt.Sprint(port))
 	conn, err := net.DialTimeout("tcp", addr, time.Second)
 	if err != nil {
 		logger.L().Debug("dail failed", zap.String("addr", addr), zap.Error(err))
@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/discovery"
+)
+
+// DiscoverAssets scans are long, so it is called in a goroutine and only one runs at a time
+func DiscoverAssets() {
+	discovery.ScanDue()
+}
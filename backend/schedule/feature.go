@@ -0,0 +1,15 @@
+package schedule
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/feature"
+	"github.com/veops/oneterm/logger"
+)
+
+// LoadFeatures refreshes feature flags changed by other instances
+func LoadFeatures() {
+	if err := feature.Load(ctx); err != nil {
+		logger.L().Warn("load feature flags failed", zap.Error(err))
+	}
+}
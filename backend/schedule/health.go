@@ -0,0 +1,28 @@
+package schedule
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/health"
+	"github.com/veops/oneterm/logger"
+)
+
+// CheckHealth dials ports of all assets which may be slow, so it is called in a goroutine
+func CheckHealth() {
+	health.CheckDue()
+}
+
+func ExpireHealth() {
+	days := conf.Cfg.Health.RetentionDays
+	if days <= 0 {
+		return
+	}
+	n, err := health.Expire(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logger.L().Warn("expire health history failed", zap.Error(err))
+	}
+	logger.L().Info("expire health history", zap.Int64("count", n))
+}
@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/lab"
+)
+
+// ExpireLabs calls webhooks of orchestrators which may be slow, so it is called in a goroutine
+func ExpireLabs() {
+	lab.ExpireDue()
+}
@@ -0,0 +1,23 @@
+package schedule
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/storage"
+)
+
+func ExpireRecordings() {
+	days := conf.Cfg.Storage.RetentionDays
+	if days <= 0 {
+		return
+	}
+	n, err := storage.Expire(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logger.L().Warn("expire recordings failed", zap.Error(err))
+	}
+	logger.L().Info("expire recordings", zap.Int("count", n))
+}
@@ -0,0 +1,9 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/review"
+)
+
+func EscalateReviews() {
+	review.EscalateOverdue()
+}
@@ -0,0 +1,9 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/rotation"
+)
+
+func RotatePasswords() {
+	rotation.RotateDue()
+}
@@ -11,19 +11,45 @@ var (
 
 func init() {
 	UpdateConfig()
+	LoadFeatures()
 }
 
 func RunSchedule() (err error) {
+	tk10s := time.NewTicker(time.Second * 10)
 	tk2h := time.NewTicker(time.Hour * 2)
 	tk1m := time.NewTicker(time.Minute)
+	tk24h := time.NewTicker(time.Hour * 24)
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-tk10s.C:
+			ExpireAccessGrants()
+			LoadFeatures()
+			Heartbeat()
 		case <-tk2h.C:
 			UpdateConnectables()
+			RotatePasswords()
+			RenewSshCerts()
 		case <-tk1m.C:
 			UpdateConfig()
+			WarmupAssets()
+			PruneMonitors()
+			go DiscoverAssets()
+			go SyncClouds()
+			go SyncCmdb()
+			go PullWarnings()
+			go CheckHealth()
+			go ExpireLabs()
+			go EscalateReviews()
+			go ReconcileSessions()
+			go SampleUsage()
+			go RunCanaries()
+		case <-tk24h.C:
+			ExpireRecordings()
+			ExpireHealth()
+			ExpireCanaries()
+			ExpireWebhookDeliveries()
 		}
 	}
 }
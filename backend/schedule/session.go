@@ -0,0 +1,22 @@
+package schedule
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/logger"
+	gsession "github.com/veops/oneterm/session"
+)
+
+func ReconcileSessions() {
+	gsession.ReconcileSessions()
+}
+
+func Heartbeat() {
+	gsession.Heartbeat()
+}
+
+func PruneMonitors() {
+	if n := gsession.PruneMonitors(); n > 0 {
+		logger.L().Info("prune dead monitors", zap.Int("count", n))
+	}
+}
@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/usage"
+)
+
+// SampleUsage records usage of this month, peaks of sessions are as fine as samples
+func SampleUsage() {
+	usage.Sample()
+}
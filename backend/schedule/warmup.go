@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/warmup"
+)
+
+func WarmupAssets() {
+	warmup.Expire()
+	warmup.Warm()
+}
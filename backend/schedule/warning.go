@@ -0,0 +1,10 @@
+package schedule
+
+import (
+	"github.com/veops/oneterm/warning"
+)
+
+// PullWarnings calls the api of warnings which may be slow, so it is called in a goroutine
+func PullWarnings() {
+	warning.PullDue()
+}
@@ -0,0 +1,23 @@
+package schedule
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/webhook"
+)
+
+func ExpireWebhookDeliveries() {
+	days := conf.Cfg.Webhook.RetentionDays
+	if days <= 0 {
+		return
+	}
+	n, err := webhook.Expire(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logger.L().Warn("expire webhook deliveries failed", zap.Error(err))
+	}
+	logger.L().Info("expire webhook deliveries", zap.Int64("count", n))
+}
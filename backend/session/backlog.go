@@ -0,0 +1,60 @@
+package session
+
+import (
+	"sync"
+)
+
+const (
+	backlogSize = 256 * 1024
+)
+
+// Backlog keeps the latest output of a web terminal session with the offset of all output written, so that the owner
+// resuming gets what it missed after the offset it has received
+type Backlog struct {
+	buf []byte
+	end int64
+	mtx sync.Mutex
+}
+
+func NewBacklog() *Backlog {
+	return &Backlog{buf: make([]byte, 0, backlogSize)}
+}
+
+func (b *Backlog) Write(p []byte) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.end += int64(len(p))
+	if len(p) >= backlogSize {
+		b.buf = append(b.buf[:0], p[len(p)-backlogSize:]...)
+		return
+	}
+	if over := len(b.buf) + len(p) - backlogSize; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	b.buf = append(b.buf, p...)
+}
+
+// Offset is how many bytes are written in all
+func (b *Backlog) Offset() int64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.end
+}
+
+// Since returns output written after the offset, ok is false if part of it is dropped already and all kept is
+// returned then
+func (b *Backlog) Since(offset int64) (bs []byte, ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	start := b.end - int64(len(b.buf))
+	if offset < start {
+		return append([]byte(nil), b.buf...), false
+	}
+	if offset >= b.end {
+		return nil, offset == b.end
+	}
+	return append([]byte(nil), b.buf[offset-start:]...), true
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	CHAN_IN     = "in"
+	CHAN_OUT    = "out"
+	CHAN_WINDOW = "window"
+	CHAN_WS     = "ws"
+	// CHAN_MONITOR prefixes keys of monitors
+	CHAN_MONITOR = "monitor:"
+)
+
+// ChanStat counts sends of one channel, a send is blocked if the channel is full at the moment
+type ChanStat struct {
+	sends        atomic.Int64
+	blocks       atomic.Int64
+	blockedNanos atomic.Int64
+	maxBlocked   atomic.Int64
+}
+
+// ChanGauge is the snapshot of ChanStat, durations are in milliseconds
+type ChanGauge struct {
+	Len        int     `json:"len"`
+	Cap        int     `json:"cap"`
+	Sends      int64   `json:"sends"`
+	Blocks     int64   `json:"blocks"`
+	BlockedMs  float64 `json:"blocked_ms"`
+	MaxBlocked float64 `json:"max_blocked_ms"`
+	// Drops of queues which drop instead of blocking, e.g. ones of websockets
+	Drops int64 `json:"drops,omitempty"`
+}
+
+func (s *ChanStat) observe(sessionId, name string, blocked time.Duration) {
+	s.sends.Add(1)
+	if blocked <= 0 {
+		return
+	}
+	s.blocks.Add(1)
+	s.blockedNanos.Add(int64(blocked))
+	for {
+		m := s.maxBlocked.Load()
+		if int64(blocked) <= m || s.maxBlocked.CompareAndSwap(m, int64(blocked)) {
+			break
+		}
+	}
+	if threshold := conf.Cfg.Profile.ChanBlockWarn; threshold > 0 && blocked >= time.Duration(threshold)*time.Millisecond {
+		logger.L().Warn("session channel blocked", zap.String("sessionId", sessionId), zap.String("chan", name), zap.Duration("blocked", blocked))
+	}
+}
+
+func (s *ChanStat) gauge(l, c int) *ChanGauge {
+	return &ChanGauge{
+		Len:        l,
+		Cap:        c,
+		Sends:      s.sends.Load(),
+		Blocks:     s.blocks.Load(),
+		BlockedMs:  float64(s.blockedNanos.Load()) / float64(time.Millisecond),
+		MaxBlocked: float64(s.maxBlocked.Load()) / float64(time.Millisecond),
+	}
+}
+
+// send tries a non-blocking send first so that the fast path does not touch the clock
+func send[T any](ch chan T, v T, stat *ChanStat, sessionId, name string) {
+	select {
+	case ch <- v:
+		stat.observe(sessionId, name, 0)
+		return
+	default:
+	}
+	start := time.Now()
+	ch <- v
+	stat.observe(sessionId, name, time.Since(start))
+}
+
+func (m *SessionChans) SendIn(p []byte) {
+	send(m.InChan, p, &m.InStat, m.SessionId, CHAN_IN)
+}
+
+func (m *SessionChans) SendOut(p []byte) {
+	send(m.OutChan, p, &m.OutStat, m.SessionId, CHAN_OUT)
+}
+
+func (m *SessionChans) SendWindow(w ssh.Window) {
+	send(m.WindowChan, w, &m.WindowStat, m.SessionId, CHAN_WINDOW)
+}
+
+// Gauges returns occupancy and blocking of the hot path channels
+func (m *SessionChans) Gauges() map[string]*ChanGauge {
+	return map[string]*ChanGauge{
+		CHAN_IN:     m.InStat.gauge(len(m.InChan), cap(m.InChan)),
+		CHAN_OUT:    m.OutStat.gauge(len(m.OutChan), cap(m.OutChan)),
+		CHAN_WINDOW: m.WindowStat.gauge(len(m.WindowChan), cap(m.WindowChan)),
+	}
+}
+
+// Gauges returns gauges of channels of the session and queues of its websockets and monitors
+func (m *Session) Gauges() map[string]*ChanGauge {
+	res := m.Chans.Gauges()
+	if m.WsOut != nil {
+		res[CHAN_WS] = m.WsOut.Gauge()
+	}
+	m.Monitors.Range(func(key, value any) bool {
+		if w, ok := value.(*WsOut); ok {
+			res[CHAN_MONITOR+key.(string)] = w.Gauge()
+		}
+		return true
+	})
+	return res
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"time"
+)
+
+const (
+	// CoalesceWindow is how long output is gathered after it stops arriving, so that bursts go in one message
+	CoalesceWindow = time.Millisecond * 5
+	// CoalesceMaxLatency bounds how long output waits under continuous output
+	CoalesceMaxLatency = time.Millisecond * 30
+	// CoalesceMaxSize flushes output at once when so many bytes are pending
+	CoalesceMaxSize = 32 * 1024
+)
+
+// Coalescer schedules flushes of output instead of polling, a flush is due once output stops arriving for the window
+// or the max latency passes since the first output pending. It is not safe for concurrent use, the goroutine flushing
+// owns it
+type Coalescer struct {
+	window     time.Duration
+	maxLatency time.Duration
+	timer      *time.Timer
+	first      time.Time
+	pending    bool
+}
+
+func NewCoalescer(window, maxLatency time.Duration) *Coalescer {
+	c := &Coalescer{window: window, maxLatency: maxLatency, timer: time.NewTimer(time.Hour)}
+	c.timer.Stop()
+	return c
+}
+
+// Add tells output arrives and pushes the flush back within the max latency
+func (c *Coalescer) Add() {
+	now := time.Now()
+	if !c.pending {
+		c.first, c.pending = now, true
+	}
+	c.reset(max(min(c.window, c.first.Add(c.maxLatency).Sub(now)), 0))
+}
+
+// C receives when a flush is due, Flushed must be called after the flush
+func (c *Coalescer) C() <-chan time.Time {
+	return c.timer.C
+}
+
+// Flushed clears the pending output, the timer is stopped if the flush is not by it
+func (c *Coalescer) Flushed() {
+	c.pending = false
+	c.reset(-1)
+}
+
+func (c *Coalescer) Stop() {
+	c.reset(-1)
+}
+
+// reset stops the timer and drains it so that stale fires are not taken as due, it is armed again for d unless d < 0
+func (c *Coalescer) reset(d time.Duration) {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	if d >= 0 {
+		c.timer.Reset(d)
+	}
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	CONTROL_CLOSE = "close"
+
+	controlPrefix = "oneterm:control:"
+)
+
+var (
+	controlHandlers           = map[string]func(*Control){}
+	controlCtx, controlCancel = context.WithCancel(context.Background())
+)
+
+// Control is a message to the instance holding a session, e.g. closing it by an admin on another instance.
+// Langs are of the user sending it, so that notices are in the language of the user
+type Control struct {
+	Op        string   `json:"op"`
+	SessionId string   `json:"session_id"`
+	Closer    string   `json:"closer"`
+	Langs     []string `json:"langs"`
+	From      string   `json:"from"`
+}
+
+// HandleControl sets the handler of controls of the op received by this instance, it must be called in init
+func HandleControl(op string, fn func(*Control)) {
+	controlHandlers[op] = fn
+}
+
+// SendControl publishes the control to the instance holding its session, it fails if the session is not held by
+// another instance alive or the instance does not receive it, so that callers could handle it by themselves
+func SendControl(c *Control) error {
+	e := onlineSession.Remote(c.SessionId)
+	if e == nil {
+		return fmt.Errorf("session %s is not held by other instances", c.SessionId)
+	}
+	c.From = InstanceId
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	rctx, rcancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer rcancel()
+	n, err := redis.RC.Publish(rctx, controlPrefix+e.Instance, bs).Result()
+	if err == nil && n == 0 {
+		err = fmt.Errorf("instance %s does not receive controls", e.Instance)
+	}
+	return err
+}
+
+// RunControl receives controls sent to this instance until it is stopped, it only waits if the cluster is not
+// configured
+func RunControl() (err error) {
+	if !clustered() {
+		<-controlCtx.Done()
+		return
+	}
+	sub := redis.RC.Subscribe(controlCtx, controlPrefix+InstanceId)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-controlCtx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			c := &Control{}
+			if err := json.Unmarshal([]byte(msg.Payload), c); err != nil {
+				logger.L().Warn("invalid control", zap.String("payload", msg.Payload), zap.Error(err))
+				continue
+			}
+			fn, ok := controlHandlers[c.Op]
+			if !ok {
+				logger.L().Warn("unsupported control", zap.String("op", c.Op), zap.String("from", c.From))
+				continue
+			}
+			// handlers could wait for sessions, e.g. closing them, others are not blocked by them
+			go fn(c)
+		}
+	}
+}
+
+func StopControl() {
+	defer controlCancel()
+}
+
+// CloseSessions closes sessions of all instances matching fn, sessions of others are closed by controls sent to them.
+// It returns how many are closed or sent
+func CloseSessions(fn func(*Session) bool, closer string) (n int) {
+	n = CloseOnlineSessions(fn, closer)
+	remotes, err := onlineSession.Remotes()
+	if err != nil {
+		logger.L().Warn("get sessions of other instances failed", zap.Error(err))
+		return
+	}
+	for _, e := range remotes {
+		if !fn(&Session{Session: e.Session}) {
+			continue
+		}
+		if err := SendControl(&Control{Op: CONTROL_CLOSE, SessionId: e.Session.SessionId, Closer: closer}); err != nil {
+			logger.L().Warn("send close of session failed", zap.String("sessionId", e.Session.SessionId), zap.Error(err))
+			continue
+		}
+		n++
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxDelaySize oldest output is dropped if monitors lag behind more than it
+	maxDelaySize = 32 << 20
+)
+
+type delayed struct {
+	at time.Time
+	p  []byte
+}
+
+// Delay holds output of a session for monitors like a broadcast delay, output is released d after it is written
+type Delay struct {
+	d      time.Duration
+	frames []*delayed
+	size   int
+	mtx    sync.Mutex
+}
+
+func NewDelay(d time.Duration) *Delay {
+	return &Delay{d: d}
+}
+
+func (dl *Delay) Duration() time.Duration {
+	return dl.d
+}
+
+// Size returns bytes of output not due yet
+func (dl *Delay) Size() int {
+	dl.mtx.Lock()
+	defer dl.mtx.Unlock()
+
+	return dl.size
+}
+
+// Push copies p since the caller may reuse it
+func (dl *Delay) Push(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	dl.mtx.Lock()
+	defer dl.mtx.Unlock()
+
+	dl.frames = append(dl.frames, &delayed{at: time.Now(), p: append([]byte(nil), p...)})
+	dl.size += len(p)
+	for dl.size > maxDelaySize && len(dl.frames) > 1 {
+		dl.size -= len(dl.frames[0].p)
+		dl.frames = dl.frames[1:]
+	}
+}
+
+// Due returns output written before now minus delay
+func (dl *Delay) Due(now time.Time) (out []byte) {
+	dl.mtx.Lock()
+	defer dl.mtx.Unlock()
+
+	i := 0
+	for ; i < len(dl.frames) && now.Sub(dl.frames[i].at) >= dl.d; i++ {
+		out = append(out, dl.frames[i].p...)
+		dl.size -= len(dl.frames[i].p)
+	}
+	dl.frames = dl.frames[i:]
+	return
+}
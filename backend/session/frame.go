@@ -0,0 +1,103 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+const (
+	// FRAMING_TEXT is the legacy framing, input is text messages prefixed by characters like '1' of data and 'w' of
+	// resizing, output is text messages of raw terminal data
+	FRAMING_TEXT = 1
+	// FRAMING_BINARY is binary messages of a header of one byte typing the payload both ways
+	FRAMING_BINARY = 2
+)
+
+const (
+	FRAME_DATA byte = iota
+	// FRAME_RESIZE is columns and rows of the terminal in two big endian uint16
+	FRAME_RESIZE
+	FRAME_PING
+	// FRAME_CONTROL is an opcode byte and its arguments, e.g. the version taken by the server once it is negotiated
+	FRAME_CONTROL
+)
+
+const (
+	CONTROL_VERSION = 'v'
+	CONTROL_MACRO   = 'm'
+	CONTROL_ZMODEM  = 'z'
+)
+
+// NegotiateFraming takes the highest version of framing offered by the web client in a comma separated list, the
+// version is sent back by a control frame if it is binary. Clients not offering any or getting text messages only
+// keep the legacy framing, so that old clients and old servers work with new ones
+func (m *Session) NegotiateFraming(offered string) error {
+	versions := lo.Map(strings.Split(offered, ","), func(s string, _ int) int { return cast.ToInt(strings.TrimSpace(s)) })
+	m.Framing = lo.Ternary(lo.Contains(versions, FRAMING_BINARY), FRAMING_BINARY, FRAMING_TEXT)
+	if m.Framing != FRAMING_BINARY {
+		return nil
+	}
+	return m.writeFrame(FRAME_CONTROL, []byte{CONTROL_VERSION, '0' + FRAMING_BINARY})
+}
+
+// WriteTerm sends terminal output to the web client in its framing
+func (m *Session) WriteTerm(p []byte) error {
+	if m.Framing == FRAMING_BINARY {
+		return m.writeFrame(FRAME_DATA, p)
+	}
+	return m.writeWs(websocket.TextMessage, p)
+}
+
+// WritePing keeps the websocket alive and finds clients gone
+func (m *Session) WritePing() error {
+	if m.Framing == FRAMING_BINARY {
+		return m.writeFrame(FRAME_PING, nil)
+	}
+	return m.writeWs(websocket.TextMessage, nil)
+}
+
+func (m *Session) writeFrame(t byte, p []byte) error {
+	return m.writeWs(websocket.BinaryMessage, append([]byte{t}, p...))
+}
+
+// writeWs goes through the queue of the websocket once it is started, so that slow clients never block the session.
+// Output of zmodem transfers waits for room instead since files break if any of it is dropped
+func (m *Session) writeWs(typ int, p []byte) error {
+	switch {
+	case m.WsOut != nil && m.Zmodem.Busy():
+		return m.WsOut.Send(typ, p)
+	case m.WsOut != nil:
+		return m.WsOut.WriteMessage(typ, p)
+	}
+	return m.Ws.WriteMessage(typ, p)
+}
+
+// DecodeFrame turns a binary frame into the legacy input so that terminals handle both framings the same way,
+// nil is returned for frames malformed or unknown
+func DecodeFrame(msg []byte) []byte {
+	if len(msg) == 0 {
+		return nil
+	}
+	p := msg[1:]
+	switch msg[0] {
+	case FRAME_DATA:
+		return append([]byte{'1'}, p...)
+	case FRAME_RESIZE:
+		if len(p) < 4 {
+			return nil
+		}
+		return []byte(fmt.Sprintf("w%d,%d", binary.BigEndian.Uint16(p), binary.BigEndian.Uint16(p[2:])))
+	case FRAME_PING:
+		return []byte{'9'}
+	case FRAME_CONTROL:
+		if len(p) > 0 && (p[0] == CONTROL_MACRO || p[0] == CONTROL_ZMODEM) {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package session
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Group is an errgroup counting goroutines running in it, so that goroutines leaked by sessions could be told
+type Group struct {
+	*errgroup.Group
+	running atomic.Int64
+}
+
+func NewGroup(ctx context.Context) (*Group, context.Context) {
+	g, gctx := errgroup.WithContext(ctx)
+	return &Group{Group: g}, gctx
+}
+
+func (g *Group) Go(f func() error) {
+	g.running.Add(1)
+	g.Group.Go(func() error {
+		defer g.running.Add(-1)
+		return f()
+	})
+}
+
+// Running returns how many goroutines of the group have not returned
+func (g *Group) Running() int64 {
+	return g.running.Load()
+}
@@ -0,0 +1,31 @@
+package session
+
+import (
+	"time"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+// Heatmap counts sessions of the user started in [start, end) by weekday and hour in the time zone of the database
+func Heatmap(uid int, start, end time.Time) (stat *model.StatHeatmap, err error) {
+	stat = &model.StatHeatmap{Uid: uid, Start: start, End: end}
+	cells := make([]*model.StatHeatmapCell, 0)
+	if err = mysql.AuditDB.
+		Model(model.DefaultSession).
+		Select("DAYOFWEEK(created_at) - 1 AS weekday, HOUR(created_at) AS hour, COUNT(*) AS count").
+		Where("uid = ? AND created_at >= ? AND created_at < ?", uid, start, end).
+		Group("weekday, hour").
+		Find(&cells).
+		Error; err != nil {
+		return
+	}
+	for _, c := range cells {
+		if c.Weekday < 0 || c.Weekday > 6 || c.Hour < 0 || c.Hour > 23 {
+			continue
+		}
+		stat.Matrix[c.Weekday][c.Hour] = c.Count
+		stat.Total += c.Count
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"time"
+)
+
+// Inspection is a snapshot of an online session in memory. Only what is set before the session goes online or is
+// safe for concurrent use is read, so that taking it does not race with the goroutines of the session
+type Inspection struct {
+	SessionId   string    `json:"session_id"`
+	SessionType int       `json:"session_type"`
+	Uid         int       `json:"uid"`
+	UserName    string    `json:"user_name"`
+	AssetId     int       `json:"asset_id"`
+	Protocol    string    `json:"protocol"`
+	ClientIp    string    `json:"client_ip"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Age is seconds since the session is created
+	Age        float64               `json:"age"`
+	Done       bool                  `json:"done"`
+	Goroutines int64                 `json:"goroutines"`
+	Monitors   int                   `json:"monitors"`
+	Chans      map[string]*ChanGauge `json:"chans"`
+	// OutBuf is bytes of output not flushed yet, TailBuf and DelayBuf are bytes kept for previews and delayed monitors
+	OutBuf     int64 `json:"out_buf"`
+	TailBuf    int   `json:"tail_buf"`
+	DelayBuf   int   `json:"delay_buf"`
+	ZmodemBusy bool  `json:"zmodem_busy"`
+}
+
+func (m *Session) Inspect(now time.Time) *Inspection {
+	ins := &Inspection{
+		SessionId:   m.SessionId,
+		SessionType: m.SessionType,
+		Uid:         m.Uid,
+		UserName:    m.UserName,
+		AssetId:     m.AssetId,
+		Protocol:    m.Protocol,
+		ClientIp:    m.ClientIp,
+		CreatedAt:   m.CreatedAt,
+		Age:         now.Sub(m.CreatedAt).Seconds(),
+		ZmodemBusy:  m.Zmodem.Busy(),
+	}
+	if m.Gctx != nil {
+		ins.Done = m.Gctx.Err() != nil
+	}
+	if m.G != nil {
+		ins.Goroutines = m.G.Running()
+	}
+	if m.Monitors != nil {
+		m.Monitors.Range(func(key, value any) bool {
+			ins.Monitors++
+			return true
+		})
+	}
+	if m.Chans != nil {
+		ins.Chans = m.Gauges()
+		ins.OutBuf = m.Chans.OutPending.Load()
+	}
+	if m.Tail != nil {
+		ins.TailBuf = m.Tail.Len()
+	}
+	if m.MonitorDelay != nil {
+		ins.DelayBuf = m.MonitorDelay.Size()
+	}
+	return ins
+}
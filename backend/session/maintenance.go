@@ -0,0 +1,36 @@
+package session
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+// GetMaintenanceWindow returns the window in effect covering the user and the asset, nil if there is none.
+// The earliest started one wins if windows overlap
+func GetMaintenanceWindow(uid, rid int, asset *model.Asset) (window *model.MaintenanceWindow, err error) {
+	now := time.Now()
+	windows := make([]*model.MaintenanceWindow, 0)
+	if err = mysql.DB.Model(model.DefaultMaintenanceWindow).
+		Where("enable = ? AND `start` <= ? AND `end` > ?", true, now, now).
+		Order("`start`, id").
+		Find(&windows).Error; err != nil || len(windows) == 0 {
+		return
+	}
+
+	nodes := make([]*model.Node, 0)
+	if err = mysql.DB.Model(model.DefaultNode).Select("id", "parent_id").Find(&nodes).Error; err != nil {
+		return
+	}
+	parents := lo.SliceToMap(nodes, func(n *model.Node) (int, int) { return n.Id, n.ParentId })
+	nodeIds := make([]int, 0)
+	for id := asset.ParentId; id != 0 && !lo.Contains(nodeIds, id); id = parents[id] {
+		nodeIds = append(nodeIds, id)
+	}
+
+	window, _ = lo.Find(windows, func(w *model.MaintenanceWindow) bool { return w.InScope(uid, rid, asset.Id, nodeIds) })
+	return
+}
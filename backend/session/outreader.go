@@ -0,0 +1,88 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// outChunkSize is the most bytes of output read at a time, it is the capacity of pooled buffers as well
+	outChunkSize = 32 * 1024
+)
+
+var (
+	outPool = sync.Pool{New: func() any { return make([]byte, 0, outChunkSize) }}
+)
+
+// GetOutBuf returns an empty buffer of output from the pool
+func GetOutBuf() []byte {
+	return outPool.Get().([]byte)[:0]
+}
+
+// PutOutBuf returns the buffer to the pool once output in it is consumed, buffers not of the pool are left to gc
+func PutOutBuf(p []byte) {
+	if cap(p) != outChunkSize {
+		return
+	}
+	outPool.Put(p[:0]) //nolint:staticcheck
+}
+
+// OutReader reads output of terminals in chunks of what has arrived into pooled buffers instead of rune by rune.
+// Runes split between reads wait for the rest and invalid bytes are dropped
+type OutReader struct {
+	*bufio.Reader
+	// Split returns where output must be cut so that a sequence handled by the caller starts a chunk, e.g. headers of
+	// zmodem. Output is not cut if it is negative, and nothing is read if it is 0 so that the caller takes the sequence
+	Split func(p []byte) int
+	carry []byte
+}
+
+func NewOutReader(r io.Reader) *OutReader {
+	return &OutReader{Reader: bufio.NewReaderSize(r, outChunkSize)}
+}
+
+// Next blocks until output arrives and returns it, it could be empty if only part of a rune arrives. The buffer is
+// of the pool and should be put back by PutOutBuf after it is consumed
+func (r *OutReader) Next() (p []byte, err error) {
+	if _, err = r.Peek(1); err != nil {
+		return
+	}
+	bs, _ := r.Peek(min(r.Buffered(), outChunkSize-len(r.carry)))
+	if r.Split != nil {
+		switch i := r.Split(bs); {
+		case i == 0:
+			return nil, nil
+		case i > 0:
+			bs = bs[:i]
+		}
+	}
+	p = append(append(GetOutBuf(), r.carry...), bs...)
+	r.Discard(len(bs))
+
+	cut := len(p) - partialRune(p)
+	r.carry = append(r.carry[:0], p[cut:]...)
+	p = p[:cut]
+	if !utf8.Valid(p) {
+		valid := bytes.ToValidUTF8(p, nil)
+		PutOutBuf(p)
+		p = valid
+	}
+	return
+}
+
+// partialRune returns how many bytes at the end of p are the start of a rune not complete yet
+func partialRune(p []byte) int {
+	for i := 1; i <= min(utf8.UTFMax-1, len(p)); i++ {
+		if !utf8.RuneStart(p[len(p)-i]) {
+			continue
+		}
+		if utf8.FullRune(p[len(p)-i:]) {
+			return 0
+		}
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/samber/lo"
+)
+
+const (
+	OVERLAY_TYPE_LINE   = "line"
+	OVERLAY_TYPE_REGION = "region"
+	OVERLAY_TYPE_CLEAR  = "clear"
+
+	// overlayOsc is a private osc code, terminals ignore it while the web client registers a handler for it
+	overlayOsc = 5379
+)
+
+var (
+	reColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// Overlay highlights lines or a region of the terminal, it is only displayed and never written to the session
+type Overlay struct {
+	Type     string `json:"type"`
+	Monitor  string `json:"monitor"`
+	StartRow int    `json:"start_row"`
+	EndRow   int    `json:"end_row"`
+	StartCol int    `json:"start_col"`
+	EndCol   int    `json:"end_col"`
+	Color    string `json:"color"`
+	Label    string `json:"label"`
+}
+
+func (o *Overlay) Valid() bool {
+	if !lo.Contains([]string{OVERLAY_TYPE_LINE, OVERLAY_TYPE_REGION, OVERLAY_TYPE_CLEAR}, o.Type) {
+		return false
+	}
+	if o.Color != "" && !reColor.MatchString(o.Color) {
+		return false
+	}
+	return o.StartRow >= 0 && o.EndRow >= o.StartRow && o.StartCol >= 0 && o.EndCol >= o.StartCol && len(o.Label) <= 64
+}
+
+// Bytes encodes overlay as an osc control sequence
+func (o *Overlay) Bytes() []byte {
+	bs, _ := json.Marshal(o)
+	return []byte(fmt.Sprintf("\x1b]%d;%s\x07", overlayOsc, bs))
+}
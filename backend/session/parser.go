@@ -3,11 +3,13 @@ package session
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/samber/lo"
 	"github.com/veops/go-ansiterm"
 	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
 	"go.uber.org/zap"
@@ -54,7 +56,9 @@ type Parser struct {
 	Input        []byte
 	Output       []byte
 	SessionId    string
+	Typer        string
 	Cmds         []*model.Command
+	Policies     []*model.CommandPolicy
 	isPrompt     bool
 	prompt       string
 	isEdit       bool
@@ -62,9 +66,14 @@ type Parser struct {
 	lastCmd      string
 	lastRes      string
 	curRes       string
+	typers       []string
+	curTypers    []string
+	lastTypers   []string
 }
 
-func (p *Parser) AddInput(bs []byte) (cmd string, forbidden bool) {
+// AddInput returns the matched rule and policy action when a command is entered, commands need confirm or approval are not accepted until Accept.
+// Commands are attributed to all the users who typed them, Typer is the user of bs
+func (p *Parser) AddInput(bs []byte) (cmd string, action int) {
 	if p.isPrompt && !p.isEdit {
 		//TODO: may someone has empty ps1?
 		if ps1 := p.GetOutput(); ps1 != "" {
@@ -76,21 +85,80 @@ func (p *Parser) AddInput(bs []byte) (cmd string, forbidden bool) {
 		p.lastRes = ""
 	}
 	p.Input = append(p.Input, bs...)
+	if p.Typer != "" && !lo.Contains(p.typers, p.Typer) {
+		p.typers = append(p.typers, p.Typer)
+	}
 	if !bytes.HasSuffix(p.Input, []byte("\r")) {
 		return
 	}
 	p.isPrompt = true
-	p.curCmd = p.GetCmd()
+	p.curCmd, p.curTypers, p.typers = p.GetCmd(), p.typers, nil
 	p.Reset()
-	filter := ""
-	if filter, forbidden = p.IsForbidden(p.curCmd); forbidden {
-		cmd = filter
+	if filter, forbidden := p.IsForbidden(p.curCmd); forbidden {
+		eventbus.PublishBlocked(p.SessionId, strings.Join(p.curTypers, ","), p.curCmd, filter)
+		return filter, model.POLICY_ACTION_BLOCK
+	}
+	if policy, pattern := p.MatchPolicy(p.curCmd); policy != nil {
+		cmd, action = fmt.Sprintf("%s: %s", policy.Name, pattern), policy.Action
+		if action == model.POLICY_ACTION_BLOCK {
+			eventbus.PublishBlocked(p.SessionId, strings.Join(p.curTypers, ","), p.curCmd, cmd)
+		}
+		if action != model.POLICY_ACTION_WARN {
+			return
+		}
+	}
+	p.lastCmd, p.lastTypers = p.curCmd, p.curTypers
+	return
+}
+
+// Current returns the command entered just now
+func (p *Parser) Current() string {
+	return p.curCmd
+}
+
+// Accept accepts the command which is waiting for confirm or approval
+func (p *Parser) Accept() {
+	p.lastCmd, p.lastTypers = p.curCmd, p.curTypers
+}
+
+// LoadRules loads forbidden commands of asset and policies in the scope of user, asset and account
+func (p *Parser) LoadRules(cmdIds []int, uid, rid, assetId, accountId int) (err error) {
+	if err = mysql.DB.Model(p.Cmds).Where("id IN ? AND enable=?", cmdIds, true).Find(&p.Cmds).Error; err != nil {
 		return
 	}
-	p.lastCmd = p.curCmd
+	for _, c := range p.Cmds {
+		if c.IsRe {
+			c.Re, _ = regexp.Compile(c.Cmd)
+		}
+	}
+	p.Policies, err = GetCommandPolicies(uid, rid, assetId, accountId)
+	return
+}
+
+// GetCommandPolicies returns enabled policies in the scope of user, asset and account, sorted by priority
+func GetCommandPolicies(uid, rid, assetId, accountId int) (policies []*model.CommandPolicy, err error) {
+	if err = mysql.DB.Model(model.DefaultCommandPolicy).Where("enable = ?", true).Order("priority, id").Find(&policies).Error; err != nil {
+		return
+	}
+	policies = lo.Filter(policies, func(p *model.CommandPolicy, _ int) bool {
+		return p.InScope(uid, rid, assetId, accountId) && p.Compile() == nil
+	})
 	return
 }
 
+// MatchPolicy returns the first matched policy, policies are sorted by priority
+func (p *Parser) MatchPolicy(cmd string) (*model.CommandPolicy, string) {
+	if p.isEdit || cmd == "" {
+		return nil, ""
+	}
+	for _, policy := range p.Policies {
+		if pattern, ok := policy.Match(cmd); ok {
+			return policy, pattern
+		}
+	}
+	return nil, ""
+}
+
 func (p *Parser) IsForbidden(cmd string) (string, bool) {
 	if p.isEdit || cmd == "" {
 		return "", false
@@ -117,8 +185,9 @@ func (p *Parser) WriteDb() {
 		SessionId: p.SessionId,
 		Cmd:       p.lastCmd,
 		Result:    p.lastRes,
+		UserName:  strings.Join(p.lastTypers, ","),
 	}
-	err := mysql.DB.Model(m).Create(m).Error
+	err := mysql.AuditDB.Model(m).Create(m).Error
 	if err != nil {
 		logger.L().Error("write session cmd failed", zap.Error(err), zap.Any("cmd", *m))
 	}
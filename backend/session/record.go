@@ -4,12 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/storage"
 )
 
 type Asciinema struct {
@@ -18,7 +18,7 @@ type Asciinema struct {
 }
 
 func NewAsciinema(id string, w, h int) (ret *Asciinema, err error) {
-	f, err := os.Create(filepath.Join("/replay", fmt.Sprintf("%s.cast", id)))
+	f, err := os.Create(storage.Path(fmt.Sprintf("%s.cast", id)))
 	if err != nil {
 		logger.L().Error("open cast failed", zap.String("id", id), zap.Error(err))
 		return
@@ -56,3 +56,7 @@ func (a *Asciinema) Resize(w, h int) {
 	bs, _ := json.Marshal(r)
 	a.file.Write(append(bs, '\r', '\n'))
 }
+
+func (a *Asciinema) Close() error {
+	return a.file.Close()
+}
@@ -0,0 +1,152 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/api/guacd"
+)
+
+const (
+	redactedMask = "******"
+)
+
+var (
+	redactedNotice = []byte("\r\n\x1b[7m [REDACTED] \x1b[0m\r\n")
+	// guacdKeeps are instructions kept in redacted ranges so that the display after ranges is still valid
+	guacdKeeps = []string{"sync", "size", "dispose"}
+)
+
+// TimeRange is in milliseconds from the start of recording
+type TimeRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type Redaction struct {
+	Ranges   []TimeRange `json:"ranges"`
+	Patterns []string    `json:"patterns"`
+	res      []*regexp.Regexp
+}
+
+// Compile checks ranges and patterns, it must be called before redacting
+func (r *Redaction) Compile() (err error) {
+	for _, tr := range r.Ranges {
+		if tr.Start < 0 || tr.End <= tr.Start {
+			return fmt.Errorf("invalid range %d-%d", tr.Start, tr.End)
+		}
+	}
+	r.res = make([]*regexp.Regexp, 0, len(r.Patterns))
+	for _, p := range r.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		r.res = append(r.res, re)
+	}
+	return
+}
+
+func (r *Redaction) inRange(at time.Duration) bool {
+	ms := at.Milliseconds()
+	return lo.ContainsBy(r.Ranges, func(tr TimeRange) bool { return ms >= tr.Start && ms < tr.End })
+}
+
+func (r *Redaction) mask(s string) string {
+	for _, re := range r.res {
+		s = re.ReplaceAllString(s, redactedMask)
+	}
+	return s
+}
+
+// RedactAsciinema drops output in ranges and masks patterns in output, header is kept as it is
+func (r *Redaction) RedactAsciinema(src io.Reader, dst io.Writer) (err error) {
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		return fmt.Errorf("empty recording")
+	}
+	if _, err = dst.Write(append(sc.Bytes(), '\r', '\n')); err != nil {
+		return
+	}
+	redacting := false
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		e := [3]any{}
+		if err = json.Unmarshal(line, &e); err != nil {
+			return
+		}
+		at := time.Duration(cast.ToFloat64(e[0]) * float64(time.Second))
+		if cast.ToString(e[1]) == REPLAY_TYPE_OUTPUT {
+			in := r.inRange(at)
+			if in && redacting {
+				continue
+			}
+			redacting = in
+			e[2] = lo.Ternary(in, string(redactedNotice), r.mask(cast.ToString(e[2])))
+		}
+		bs, _ := json.Marshal(e)
+		if _, err = dst.Write(append(bs, '\r', '\n')); err != nil {
+			return
+		}
+	}
+
+	return sc.Err()
+}
+
+// RedactGuacd drops drawing instructions in ranges and blanks the default layer at the start of each range,
+// patterns can not be applied to images
+func (r *Redaction) RedactGuacd(src io.Reader, dst io.Writer) (err error) {
+	if len(r.res) > 0 {
+		return fmt.Errorf("patterns are not supported by graphical recordings")
+	}
+	blank := append(guacd.NewInstruction("rect", "0", "0", "0", "65535", "65535").Bytes(),
+		guacd.NewInstruction("cfill", "12", "0", "0", "0", "0", "255").Bytes()...)
+	reader := bufio.NewReader(src)
+	first := int64(-1)
+	at := time.Duration(0)
+	redacting := false
+	for {
+		p, e := reader.ReadBytes(';')
+		if len(p) > 0 {
+			ins := (&guacd.Instruction{}).Parse(string(bytes.TrimSpace(p)))
+			if ins.Opcode == "sync" && len(ins.Args) > 0 {
+				ts := cast.ToInt64(ins.Args[0])
+				if first < 0 {
+					first = ts
+				}
+				at = time.Duration(ts-first) * time.Millisecond
+				if in := r.inRange(at); in != redacting {
+					redacting = in
+					if in {
+						if _, err = dst.Write(blank); err != nil {
+							return
+						}
+					}
+				}
+			}
+			if !redacting || lo.Contains(guacdKeeps, ins.Opcode) {
+				if _, err = dst.Write(p); err != nil {
+					return
+				}
+			}
+		}
+		if e == io.EOF {
+			return
+		}
+		if e != nil {
+			return e
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// HEADER_FORWARDED is set on requests forwarded to the instance holding the session, they are never forwarded again
+	HEADER_FORWARDED = "X-Oneterm-Forwarded"
+
+	registryKey    = "oneterm:session:online"
+	instancePrefix = "oneterm:instance:"
+	redisTimeout   = time.Second * 3
+)
+
+var (
+	hostname, _ = os.Hostname()
+	// InstanceId tells instances apart, it is new on every start so that entries of former runs are never taken as
+	// ones of this run
+	InstanceId = fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8])
+)
+
+// Entry is an online session in the registry, Addr is the advertised address of the instance holding it, which could
+// be empty
+type Entry struct {
+	Instance string         `json:"instance"`
+	Addr     string         `json:"addr"`
+	Session  *model.Session `json:"session"`
+}
+
+// Registry is online sessions of all instances, sessions of this instance are cached locally with their connections
+// and others are only known by their entries in redis. It works like a sync.Map of this instance only if the cluster
+// is not configured
+type Registry struct {
+	local sync.Map
+}
+
+func clustered() bool {
+	return conf.Cfg.Cluster.Enable
+}
+
+func (r *Registry) Load(key any) (any, bool) {
+	return r.local.Load(key)
+}
+
+func (r *Registry) Store(key, value any) {
+	r.local.Store(key, value)
+	if s, ok := value.(*Session); ok && clustered() {
+		rctx, rcancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer rcancel()
+		if err := redis.RC.HSet(rctx, registryKey, cast.ToString(key), entryOf(s)).Err(); err != nil {
+			logger.L().Warn("register online session failed", zap.String("sessionId", s.SessionId), zap.Error(err))
+		}
+	}
+}
+
+func (r *Registry) Delete(key any) {
+	r.local.Delete(key)
+	if clustered() {
+		rctx, rcancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer rcancel()
+		if err := redis.RC.HDel(rctx, registryKey, cast.ToString(key)).Err(); err != nil {
+			logger.L().Warn("unregister online session failed", zap.Any("sessionId", key), zap.Error(err))
+		}
+	}
+}
+
+// Range calls fn for sessions of this instance only, since others have no connections here
+func (r *Registry) Range(fn func(key, value any) bool) {
+	r.local.Range(fn)
+}
+
+// Remote returns the entry of the session if it is held by another instance alive, nil if it is not
+func (r *Registry) Remote(id string) *Entry {
+	if !clustered() {
+		return nil
+	}
+	rctx, rcancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer rcancel()
+	bs, err := redis.RC.HGet(rctx, registryKey, id).Bytes()
+	if err != nil {
+		return nil
+	}
+	e := &Entry{}
+	if json.Unmarshal(bs, e) != nil || e.Instance == InstanceId || !alive(rctx, e.Instance) {
+		return nil
+	}
+	return e
+}
+
+// Remotes returns entries of sessions held by other instances alive
+func (r *Registry) Remotes() (es []*Entry, err error) {
+	if !clustered() {
+		return
+	}
+	rctx, rcancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer rcancel()
+	all, err := redis.RC.HGetAll(rctx, registryKey).Result()
+	if err != nil {
+		return
+	}
+	lives := map[string]bool{}
+	for _, v := range all {
+		e := &Entry{}
+		if json.Unmarshal([]byte(v), e) != nil || e.Session == nil || e.Instance == InstanceId {
+			continue
+		}
+		live, ok := lives[e.Instance]
+		if !ok {
+			live = alive(rctx, e.Instance)
+			lives[e.Instance] = live
+		}
+		if live {
+			es = append(es, e)
+		}
+	}
+	return
+}
+
+// Heartbeat keeps this instance alive in the registry and registers its sessions again in case redis lost them,
+// entries of instances gone are removed so that their sessions are closed by reconciliation
+func Heartbeat() {
+	if !clustered() {
+		return
+	}
+	rctx, rcancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer rcancel()
+	ttl := time.Second * time.Duration(max(conf.Cfg.Cluster.Ttl, 1))
+	if err := redis.RC.Set(rctx, instancePrefix+InstanceId, conf.Cfg.Cluster.AdvertiseAddr, ttl).Err(); err != nil {
+		logger.L().Warn("heartbeat of instance failed", zap.String("instance", InstanceId), zap.Error(err))
+		return
+	}
+	mine, ended := map[string]any{}, []any{}
+	onlineSession.Range(func(key, value any) bool {
+		s, ok := value.(*Session)
+		switch {
+		case !ok:
+		case s.Status == model.SESSIONSTATUS_OFFLINE:
+			ended = append(ended, key)
+		default:
+			mine[cast.ToString(key)] = entryOf(s)
+		}
+		return true
+	})
+	// sessions ended are left in memory by some ways of ending, others must not take them as online
+	for _, key := range ended {
+		onlineSession.Delete(key)
+	}
+	if len(mine) > 0 {
+		if err := redis.RC.HSet(rctx, registryKey, mine).Err(); err != nil {
+			logger.L().Warn("register online sessions failed", zap.Error(err))
+		}
+	}
+
+	all, err := redis.RC.HGetAll(rctx, registryKey).Result()
+	if err != nil {
+		return
+	}
+	lives := map[string]bool{InstanceId: true}
+	for k, v := range all {
+		e := &Entry{}
+		if json.Unmarshal([]byte(v), e) != nil {
+			redis.RC.HDel(rctx, registryKey, k)
+			continue
+		}
+		live, ok := lives[e.Instance]
+		if !ok {
+			live = alive(rctx, e.Instance)
+			lives[e.Instance] = live
+		}
+		if !live {
+			redis.RC.HDel(rctx, registryKey, k)
+		}
+	}
+}
+
+func alive(ctx context.Context, instance string) bool {
+	n, err := redis.RC.Exists(ctx, instancePrefix+instance).Result()
+	// instances are taken as alive if redis fails, so that their sessions are not closed by mistake
+	return err != nil || n > 0
+}
+
+func entryOf(s *Session) []byte {
+	state := *s.Session
+	bs, _ := json.Marshal(&Entry{Instance: InstanceId, Addr: conf.Cfg.Cluster.AdvertiseAddr, Session: &state})
+	return bs
+}
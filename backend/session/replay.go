@@ -0,0 +1,302 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/api/guacd"
+)
+
+const (
+	REPLAY_ACTION_PAUSE  = "pause"
+	REPLAY_ACTION_RESUME = "resume"
+	REPLAY_ACTION_SEEK   = "seek"
+	REPLAY_ACTION_SPEED  = "speed"
+
+	REPLAY_TYPE_META   = "meta"
+	REPLAY_TYPE_RESET  = "reset"
+	REPLAY_TYPE_OUTPUT = "o"
+	REPLAY_TYPE_RESIZE = "r"
+	REPLAY_TYPE_END    = "end"
+)
+
+var (
+	ReplaySpeeds = []float64{1, 2, 4}
+
+	guacdSync = []byte("4.sync,")
+)
+
+type Frame struct {
+	At   time.Duration
+	Type string
+	Data []byte
+	// Track is the index of recording in a merged replay
+	Track int
+}
+
+type Recording struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+	Frames   []*Frame
+	Tracks   []*ReplayTrack
+}
+
+// ReplayTrack is one recording of a merged replay, offset and duration are in milliseconds
+type ReplayTrack struct {
+	SessionId string `json:"session_id"`
+	Guacd     bool   `json:"guacd"`
+	Offset    int64  `json:"offset"`
+	Duration  int64  `json:"duration"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+// ReplayMsg is sent to the player client, position and duration are in milliseconds
+type ReplayMsg struct {
+	Type     string         `json:"type"`
+	Data     string         `json:"data,omitempty"`
+	Position int64          `json:"position"`
+	Duration int64          `json:"duration,omitempty"`
+	Width    int            `json:"width,omitempty"`
+	Height   int            `json:"height,omitempty"`
+	Track    int            `json:"track,omitempty"`
+	Tracks   []*ReplayTrack `json:"tracks,omitempty"`
+	// Watermark is the identity of the viewer in the meta message, clients draw it over the player
+	Watermark string `json:"watermark,omitempty"`
+}
+
+// ReplayCtrl is sent by the player client, position is in milliseconds
+type ReplayCtrl struct {
+	Action   string  `json:"action"`
+	Position int64   `json:"position"`
+	Speed    float64 `json:"speed"`
+}
+
+// LoadAsciinema parses an asciicast v2 recording
+func LoadAsciinema(r io.Reader) (rec *Recording, err error) {
+	rec = &Recording{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		err = fmt.Errorf("empty recording")
+		return
+	}
+	header := make(map[string]any)
+	if err = json.Unmarshal(sc.Bytes(), &header); err != nil {
+		return
+	}
+	rec.Width, rec.Height = cast.ToInt(header["width"]), cast.ToInt(header["height"])
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		e := [3]any{}
+		if err = json.Unmarshal(line, &e); err != nil {
+			return
+		}
+		rec.Frames = append(rec.Frames, &Frame{
+			At:   time.Duration(cast.ToFloat64(e[0]) * float64(time.Second)),
+			Type: cast.ToString(e[1]),
+			Data: []byte(cast.ToString(e[2])),
+		})
+	}
+	err = sc.Err()
+	rec.finish()
+
+	return
+}
+
+// LoadGuacd parses a guacd recording, instructions between two syncs are grouped into one frame
+func LoadGuacd(r io.Reader) (rec *Recording, err error) {
+	rec = &Recording{}
+	reader := bufio.NewReader(r)
+	buf := &bytes.Buffer{}
+	first := int64(-1)
+	at := time.Duration(0)
+	for {
+		p, e := reader.ReadBytes(';')
+		buf.Write(p)
+		if e != nil {
+			if e != io.EOF {
+				err = e
+				return
+			}
+			break
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(p), guacdSync) {
+			continue
+		}
+		ins := (&guacd.Instruction{}).Parse(string(bytes.TrimSpace(p)))
+		if len(ins.Args) > 0 {
+			ts := cast.ToInt64(ins.Args[0])
+			if first < 0 {
+				first = ts
+			}
+			at = time.Duration(ts-first) * time.Millisecond
+		}
+		rec.Frames = append(rec.Frames, &Frame{At: at, Type: REPLAY_TYPE_OUTPUT, Data: append([]byte(nil), buf.Bytes()...)})
+		buf.Reset()
+	}
+	if buf.Len() > 0 {
+		rec.Frames = append(rec.Frames, &Frame{At: at, Type: REPLAY_TYPE_OUTPUT, Data: buf.Bytes()})
+	}
+	rec.finish()
+
+	return
+}
+
+func (rec *Recording) finish() {
+	if last, ok := lo.Last(rec.Frames); ok {
+		rec.Duration = last.At
+	}
+}
+
+// MergeRecordings aligns recordings on wall clock by their start times, tracks keep the order of recordings
+func MergeRecordings(recs []*Recording, starts []time.Time, tracks []*ReplayTrack) (merged *Recording) {
+	merged = &Recording{Tracks: tracks}
+	if len(starts) == 0 {
+		return
+	}
+	first := lo.MinBy(starts, func(a, b time.Time) bool { return a.Before(b) })
+	for i, rec := range recs {
+		offset := starts[i].Sub(first)
+		tracks[i].Offset, tracks[i].Duration = offset.Milliseconds(), rec.Duration.Milliseconds()
+		tracks[i].Width, tracks[i].Height = rec.Width, rec.Height
+		merged.Width, merged.Height = max(merged.Width, rec.Width), max(merged.Height, rec.Height)
+		for _, f := range rec.Frames {
+			merged.Frames = append(merged.Frames, &Frame{At: f.At + offset, Type: f.Type, Data: f.Data, Track: i})
+		}
+	}
+	sort.SliceStable(merged.Frames, func(i, j int) bool { return merged.Frames[i].At < merged.Frames[j].At })
+	merged.finish()
+
+	return
+}
+
+// Player plays a recording in real time, supports pause, seek and speed
+type Player struct {
+	rec    *Recording
+	idx    int
+	pos    time.Duration
+	anchor time.Time
+	speed  float64
+	paused bool
+	// watermark is sent to clients with the meta message
+	watermark string
+}
+
+func NewPlayer(rec *Recording, watermark string) *Player {
+	return &Player{rec: rec, speed: 1, anchor: time.Now(), watermark: watermark}
+}
+
+func (p *Player) position() time.Duration {
+	if p.paused {
+		return p.pos
+	}
+	return p.pos + time.Duration(float64(time.Since(p.anchor))*p.speed)
+}
+
+// Play sends frames until ctx is done or ctrl is closed
+func (p *Player) Play(ctx context.Context, send func(*ReplayMsg) error, ctrl <-chan *ReplayCtrl) (err error) {
+	if err = send(&ReplayMsg{Type: REPLAY_TYPE_META, Duration: p.rec.Duration.Milliseconds(), Width: p.rec.Width, Height: p.rec.Height, Tracks: p.rec.Tracks, Watermark: p.watermark}); err != nil {
+		return
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		// stay paused at the end, client could still seek back
+		if p.idx >= len(p.rec.Frames) && !p.paused {
+			p.pos, p.paused = p.rec.Duration, true
+			if err = send(&ReplayMsg{Type: REPLAY_TYPE_END, Position: p.rec.Duration.Milliseconds()}); err != nil {
+				return
+			}
+		}
+		var wait <-chan time.Time
+		if !p.paused {
+			timer.Reset(time.Duration(float64(p.rec.Frames[p.idx].At-p.position()) / p.speed))
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case c, ok := <-ctrl:
+			if !ok {
+				return
+			}
+			if err = p.control(c, send); err != nil {
+				return
+			}
+		case <-wait:
+			f := p.rec.Frames[p.idx]
+			p.idx++
+			if err = send(&ReplayMsg{Type: f.Type, Data: string(f.Data), Position: f.At.Milliseconds(), Track: f.Track}); err != nil {
+				return
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+func (p *Player) control(c *ReplayCtrl, send func(*ReplayMsg) error) (err error) {
+	switch c.Action {
+	case REPLAY_ACTION_PAUSE:
+		p.pos, p.paused = p.position(), true
+	case REPLAY_ACTION_RESUME:
+		// anchoring again while playing would jump back to where it was anchored last
+		if p.paused {
+			p.paused, p.anchor = false, time.Now()
+		}
+	case REPLAY_ACTION_SPEED:
+		if !lo.Contains(ReplaySpeeds, c.Speed) {
+			return
+		}
+		p.pos, p.anchor, p.speed = p.position(), time.Now(), c.Speed
+	case REPLAY_ACTION_SEEK:
+		target := time.Duration(c.Position) * time.Millisecond
+		if err = send(&ReplayMsg{Type: REPLAY_TYPE_RESET, Position: c.Position}); err != nil {
+			return
+		}
+		// state of terminal and display depends on all previous frames, so replay them at once per track
+		bufs := make([]*bytes.Buffer, max(len(p.rec.Tracks), 1))
+		p.idx = 0
+		for ; p.idx < len(p.rec.Frames) && p.rec.Frames[p.idx].At <= target; p.idx++ {
+			f := p.rec.Frames[p.idx]
+			if f.Type != REPLAY_TYPE_OUTPUT {
+				if err = send(&ReplayMsg{Type: f.Type, Data: string(f.Data), Position: f.At.Milliseconds(), Track: f.Track}); err != nil {
+					return
+				}
+				continue
+			}
+			if bufs[f.Track] == nil {
+				bufs[f.Track] = &bytes.Buffer{}
+			}
+			bufs[f.Track].Write(f.Data)
+		}
+		for track, buf := range bufs {
+			if buf == nil {
+				continue
+			}
+			if err = send(&ReplayMsg{Type: REPLAY_TYPE_OUTPUT, Data: buf.String(), Position: c.Position, Track: track}); err != nil {
+				return
+			}
+		}
+		p.pos, p.anchor = target, time.Now()
+	}
+	return
+}
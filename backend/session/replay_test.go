@@ -0,0 +1,75 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlayerControl(t *testing.T) {
+	tests := []struct {
+		name       string
+		paused     bool
+		actions    []*ReplayCtrl
+		wantPaused bool
+		wantMin    time.Duration
+		wantMax    time.Duration
+	}{
+		{
+			name:    "resume while playing",
+			actions: []*ReplayCtrl{{Action: REPLAY_ACTION_RESUME}},
+			wantMin: time.Second * 2,
+			wantMax: time.Second * 3,
+		},
+		{
+			name:    "resume while paused",
+			paused:  true,
+			actions: []*ReplayCtrl{{Action: REPLAY_ACTION_RESUME}},
+			wantMin: time.Second,
+			wantMax: time.Second * 2,
+		},
+		{
+			name:       "pause",
+			actions:    []*ReplayCtrl{{Action: REPLAY_ACTION_PAUSE}},
+			wantPaused: true,
+			wantMin:    time.Second * 2,
+			wantMax:    time.Second * 3,
+		},
+		{
+			name:       "pause twice",
+			actions:    []*ReplayCtrl{{Action: REPLAY_ACTION_PAUSE}, {Action: REPLAY_ACTION_PAUSE}},
+			wantPaused: true,
+			wantMin:    time.Second * 2,
+			wantMax:    time.Second * 3,
+		},
+		{
+			name:    "speed keeps the position",
+			actions: []*ReplayCtrl{{Action: REPLAY_ACTION_SPEED, Speed: 4}},
+			wantMin: time.Second * 2,
+			wantMax: time.Second * 3,
+		},
+		{
+			name:    "unsupported speed",
+			actions: []*ReplayCtrl{{Action: REPLAY_ACTION_SPEED, Speed: 3}},
+			wantMin: time.Second * 2,
+			wantMax: time.Second * 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// at 1s of the recording, then played 1s if not paused
+			p := NewPlayer(&Recording{Duration: time.Minute}, "")
+			p.pos, p.anchor, p.paused = time.Second, time.Now().Add(-time.Second), tt.paused
+			for _, c := range tt.actions {
+				if err := p.control(c, func(*ReplayMsg) error { return nil }); err != nil {
+					t.Fatalf("control() error = %v", err)
+				}
+			}
+			if p.paused != tt.wantPaused {
+				t.Errorf("paused = %v, want %v", p.paused, tt.wantPaused)
+			}
+			if got := p.position(); got < tt.wantMin || got >= tt.wantMax {
+				t.Errorf("position() = %v, want in [%v, %v)", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
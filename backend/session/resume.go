@@ -0,0 +1,82 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+var (
+	ErrClientLeave = errors.New("client left")
+)
+
+// Resume is a websocket of the owner coming back to the session, Err gets the result once the session takes it.
+// Offset is how many bytes of output the owner has received, negative ones mean output since it left
+type Resume struct {
+	Ws      *websocket.Conn
+	Framing string
+	Offset  int64
+	Err     chan error
+}
+
+func NewResume(ws *websocket.Conn, framing string, offset int64) *Resume {
+	return &Resume{Ws: ws, Framing: framing, Offset: offset, Err: make(chan error, 1)}
+}
+
+// ResumeGrace is how long the session waits for its owner after the websocket drops, only terminal sessions of web
+// wait and 0 means the session ends with the websocket
+func (m *Session) ResumeGrace() time.Duration {
+	cfg := model.GlobalConfig.Load()
+	if m.SessionType != model.SESSIONTYPE_WEB || m.IsGuacd() || m.Backlog == nil || cfg == nil || cfg.ResumeGrace <= 0 {
+		return 0
+	}
+	return time.Second * time.Duration(cfg.ResumeGrace)
+}
+
+// Detached reports whether the session is waiting for its owner to resume
+func (m *Session) Detached() bool {
+	return m.DetachedAt != nil
+}
+
+// Detach lets go of the websocket of the owner, output is still kept by Backlog until the owner resumes
+func (m *Session) Detach() {
+	m.WsOut.Close()
+	if m.Ws != nil {
+		m.Ws.Close()
+	}
+	m.Ws, m.WsOut = nil, nil
+	if m.DetachedAt == nil {
+		m.DetachedAt = lo.ToPtr(time.Now())
+		m.DetachOffset = m.Backlog.Offset()
+	}
+}
+
+// Attach takes the websocket of the owner resuming in the framing it speaks, output after the offset goes first.
+// Output written to a websocket dropped before the session finds it is sent again as long as the owner tells the
+// offset it has received, output dropped by Backlog already is lost and the rest kept is sent
+func (m *Session) Attach(ws *websocket.Conn, framing string, offset int64) (err error) {
+	m.Ws = ws
+	if err = m.NegotiateFraming(framing); err != nil {
+		m.Ws = nil
+		return
+	}
+	m.WsOut = NewWsOut(ws, m.SessionId)
+	if offset < 0 {
+		offset = m.DetachOffset
+	}
+	missed, ok := m.Backlog.Since(offset)
+	if !ok {
+		logger.L().Warn("output missed by resuming is dropped", zap.String("sessionId", m.SessionId), zap.Int64("offset", offset))
+	}
+	m.DetachedAt = nil
+	if len(missed) > 0 {
+		err = m.WriteTerm(missed)
+	}
+	return
+}
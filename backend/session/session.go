@@ -6,46 +6,101 @@ import (
 	"context"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
 	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
-	"gorm.io/gorm/clause"
 
 	"github.com/veops/oneterm/api/guacd"
 	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
 	"github.com/veops/oneterm/logger"
 	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/zmodem"
 )
 
 var (
-	onlineSession = &sync.Map{}
+	onlineSession = &Registry{}
 )
 
 func init() {
 	sessions := make([]*Session, 0)
-	if err := mysql.DB.
+	if err := mysql.AuditDB.
 		Model(sessions).
 		Where("status = ?", model.SESSIONSTATUS_ONLINE).
 		Find(&sessions).
 		Error; err != nil {
 		logger.L().Fatal("get sessions failed", zap.Error(err))
 	}
+	// sessions of other instances alive are still online
+	remotes, err := onlineSession.Remotes()
+	if err != nil {
+		logger.L().Fatal("get sessions of other instances failed", zap.Error(err))
+	}
+	ids := lo.SliceToMap(remotes, func(e *Entry) (string, bool) { return e.Session.SessionId, true })
 	now := time.Now()
 	for _, s := range sessions {
+		if ids[s.SessionId] {
+			continue
+		}
 		s.Status = model.SESSIONSTATUS_OFFLINE
 		s.ClosedAt = &now
 		UpsertSession(s)
 	}
+	Heartbeat()
 }
 
-func GetOnlineSession() *sync.Map {
+func GetOnlineSession() *Registry {
 	return onlineSession
 }
 
+// CloseOnlineSessions sends closer to online sessions which fn matches, it returns how many of them are closed
+func CloseOnlineSessions(fn func(*Session) bool, closer string) (n int) {
+	GetOnlineSession().Range(func(key, value any) bool {
+		s := value.(*Session)
+		if s.Chans == nil || !fn(s) {
+			return true
+		}
+		select {
+		case s.Chans.CloseChan <- closer:
+			n++
+			eventbus.PublishAdminClose(s.Session, closer)
+		case <-time.After(time.Second):
+		}
+		return true
+	})
+	return
+}
+
+// PruneMonitors removes monitors of online sessions whose websockets are dead, e.g. ones gone without closing
+// which readers of them never notice, so that output is no longer queued for them. It returns how many are removed
+func PruneMonitors() (n int) {
+	GetOnlineSession().Range(func(key, value any) bool {
+		s := value.(*Session)
+		if s.Monitors == nil {
+			return true
+		}
+		s.Monitors.Range(func(k, v any) bool {
+			w, ok := v.(*WsOut)
+			if !ok || w == nil || !w.Dead() {
+				return true
+			}
+			s.Monitors.Delete(k)
+			// readers blocked on it return once it is closed, then they clean up the rest of the monitor
+			w.fail(ErrPongTimeout)
+			w.Ws.Close()
+			n++
+			return true
+		})
+		return true
+	})
+	return
+}
+
 func GetOnlineSessionById(id string) (sess *Session) {
 	v, ok := GetOnlineSession().Load(id)
 	if !ok {
@@ -77,53 +132,96 @@ func (rw *CliRW) Write(p []byte) (n int, err error) {
 }
 
 type SessionChans struct {
-	Rin        io.ReadCloser
-	Win        io.WriteCloser
-	Rout       io.ReadCloser
-	Wout       io.WriteCloser
-	ErrChan    chan error
-	InChan     chan []byte
-	OutChan    chan []byte
-	OutBuf     *bytes.Buffer
+	Rin     io.ReadCloser
+	Win     io.WriteCloser
+	Rout    io.ReadCloser
+	Wout    io.WriteCloser
+	ErrChan chan error
+	InChan  chan []byte
+	OutChan chan []byte
+	OutBuf  *bytes.Buffer
+	// OutPending mirrors the length of OutBuf for snapshots, OutBuf is only touched by the goroutine writing output
+	OutPending atomic.Int64
 	WindowChan chan ssh.Window
 	AwayChan   chan struct{}
 	CloseChan  chan string
+	// OverlayChan highlights from monitors
+	OverlayChan chan *Overlay
+	// ApprovalChan results of command approvals
+	ApprovalChan chan *model.CommandApproval
+	// CollabChan input of collaborators joined by share links
+	CollabChan chan *CollabInput
+	// LeaveChan websockets of owners failed, ReadChan gives readers the websocket to read on once owners resume
+	LeaveChan  chan *websocket.Conn
+	ReadChan   chan *websocket.Conn
+	ResumeChan chan *Resume
+	// SessionId is only used to label the channel stats
+	SessionId  string
+	InStat     ChanStat
+	OutStat    ChanStat
+	WindowStat ChanStat
 }
 
 func NewSessionChans() *SessionChans {
 	rin, win := io.Pipe()
 	rout, wout := io.Pipe()
 	return &SessionChans{
-		Rin:        rin,
-		Win:        win,
-		Rout:       rout,
-		Wout:       wout,
-		ErrChan:    make(chan error),
-		InChan:     make(chan []byte, 8),
-		OutChan:    make(chan []byte, 8),
-		OutBuf:     &bytes.Buffer{},
-		WindowChan: make(chan ssh.Window),
-		AwayChan:   make(chan struct{}),
-		CloseChan:  make(chan string),
+		Rin:          rin,
+		Win:          win,
+		Rout:         rout,
+		Wout:         wout,
+		ErrChan:      make(chan error),
+		InChan:       make(chan []byte, 8),
+		OutChan:      make(chan []byte, 8),
+		OutBuf:       &bytes.Buffer{},
+		WindowChan:   make(chan ssh.Window),
+		AwayChan:     make(chan struct{}),
+		CloseChan:    make(chan string),
+		OverlayChan:  make(chan *Overlay, 8),
+		ApprovalChan: make(chan *model.CommandApproval, 1),
+		CollabChan:   make(chan *CollabInput, 8),
+		LeaveChan:    make(chan *websocket.Conn),
+		ReadChan:     make(chan *websocket.Conn),
+		ResumeChan:   make(chan *Resume),
 	}
 }
 
 type Session struct {
 	*model.Session
-	G            *errgroup.Group `json:"-" gorm:"-"`
-	Gctx         context.Context `json:"-" gorm:"-"`
-	Ws           *websocket.Conn `json:"-" gorm:"-"`
-	CliRw        *CliRW          `json:"-" gorm:"-"`
-	Monitors     *sync.Map       `json:"-" gorm:"-"`
-	Chans        *SessionChans   `json:"-" gorm:"-"`
-	ConnectionId string          `json:"-" gorm:"-"`
-	GuacdTunnel  *guacd.Tunnel   `json:"-" gorm:"-"`
-	IdleTk       *time.Ticker    `json:"-" gorm:"-"`
-	SshRecoder   *Asciinema      `json:"-" gorm:"-"`
-	SshParser    *Parser         `json:"-" gorm:"-"`
-	ShareEnd     time.Time       `json:"-" gorm:"-"`
-	Once         sync.Once       `json:"-" gorm:"-"`
-	Prompt       string          `json:"-" gorm:"-"`
+	G            *Group           `json:"-" gorm:"-"`
+	Gctx         context.Context  `json:"-" gorm:"-"`
+	Ws           *websocket.Conn  `json:"-" gorm:"-"`
+	WsOut        *WsOut           `json:"-" gorm:"-"`
+	CliRw        *CliRW           `json:"-" gorm:"-"`
+	Monitors     *sync.Map        `json:"-" gorm:"-"`
+	Chans        *SessionChans    `json:"-" gorm:"-"`
+	ConnectionId string           `json:"-" gorm:"-"`
+	GuacdTunnel  *guacd.Tunnel    `json:"-" gorm:"-"`
+	ClipCopy     *guacd.Clipboard `json:"-" gorm:"-"`
+	ClipPaste    *guacd.Clipboard `json:"-" gorm:"-"`
+	Uploads      *guacd.Transfer  `json:"-" gorm:"-"`
+	Downloads    *guacd.Transfer  `json:"-" gorm:"-"`
+	Zmodem       *zmodem.Zmodem   `json:"-" gorm:"-"`
+	Slot         *Slot            `json:"-" gorm:"-"`
+	Watermark    *model.Watermark `json:"-" gorm:"-"`
+	Framing      int              `json:"-" gorm:"-"`
+	IdleTk       *time.Ticker     `json:"-" gorm:"-"`
+	SshRecoder   *Asciinema       `json:"-" gorm:"-"`
+	SshParser    *Parser          `json:"-" gorm:"-"`
+	Tail         *Tail            `json:"-" gorm:"-"`
+	Backlog      *Backlog         `json:"-" gorm:"-"`
+	DetachOffset int64            `json:"-" gorm:"-"`
+	ShareEnd     time.Time        `json:"-" gorm:"-"`
+	AccessEnd    time.Time        `json:"-" gorm:"-"`
+	Once         sync.Once        `json:"-" gorm:"-"`
+	Prompt       string           `json:"-" gorm:"-"`
+	MonitorDelay *Delay           `json:"-" gorm:"-"`
+	Sharing      *Sharing         `json:"-" gorm:"-"`
+}
+
+// InGrant reports whether the share link or the access request the session relies on is still in effect
+func (m *Session) InGrant(t time.Time) bool {
+	return (m.ShareId == 0 || t.Before(m.ShareEnd)) && (m.AccessRequestId == 0 || t.Before(m.AccessEnd))
 }
 
 func (m *Session) HasMonitors() (has bool) {
@@ -150,18 +248,11 @@ func (m *Session) SetIdle() {
 
 func NewSession(ctx context.Context) *Session {
 	s := &Session{}
-	s.G, s.Gctx = errgroup.WithContext(ctx)
+	s.G, s.Gctx = NewGroup(ctx)
 	s.Chans = NewSessionChans()
 	s.Monitors = &sync.Map{}
+	s.Tail = NewTail()
+	s.Sharing = NewSharing()
 	s.SetIdle()
 	return s
 }
-
-func UpsertSession(data *Session) (err error) {
-	return mysql.DB.
-		Clauses(clause.OnConflict{
-			DoUpdates: clause.AssignmentColumns([]string{"status", "closed_at"}),
-		}).
-		Create(data).
-		Error
-}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+
+	"github.com/veops/oneterm/api/guacd"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	// controlOpcode is a private instruction telling a participant who drives, guacamole clients ignore unknown ones
+	controlOpcode = "oneterm-control"
+	// controlGrace another participant takes over control once the driver has been idle for it
+	controlGrace = time.Second * 3
+)
+
+// CollabInput is typed by a collaborator of a terminal session
+type CollabInput struct {
+	UserName string
+	P        []byte
+}
+
+type grant struct {
+	uids   []int
+	expire time.Time
+}
+
+// Sharing lets invited users join a guacd connection with control, one participant drives mouse and keyboard at a time.
+// Terminal sessions are shared by links granted to selected users instead, all of them type into the same shell
+type Sharing struct {
+	invitees map[int]bool
+	grants   map[string]*grant
+	takenBy  string
+	driver   string
+	last     time.Time
+	changed  chan struct{}
+	mtx      sync.Mutex
+}
+
+func NewSharing() *Sharing {
+	return &Sharing{invitees: map[int]bool{}, grants: map[string]*grant{}, changed: make(chan struct{}, 1)}
+}
+
+func (s *Sharing) Invite(uids ...int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, uid := range uids {
+		s.invitees[uid] = true
+	}
+}
+
+func (s *Sharing) Invited(uid int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.invitees[uid]
+}
+
+// Grant returns the token of a share link, only uids could join by it before it expires
+func (s *Sharing) Grant(uids []int, d time.Duration) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	token := uuid.NewString()
+	s.grants[token] = &grant{uids: uids, expire: time.Now().Add(d)}
+	return token
+}
+
+func (s *Sharing) Granted(token string, uid int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	g, ok := s.grants[token]
+	return ok && time.Now().Before(g.expire) && lo.Contains(g.uids, uid)
+}
+
+// TakeOver lets admin seize control of a terminal session, it fails and returns who holds it if it has been taken over
+func (s *Sharing) TakeOver(admin string) (ok bool, by string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.takenBy != "" {
+		return false, s.takenBy
+	}
+	s.takenBy = admin
+	s.notify()
+	return true, admin
+}
+
+// HandBack returns control to the owner and collaborators
+func (s *Sharing) HandBack(admin string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.takenBy = lo.Ternary(s.takenBy == admin, "", s.takenBy)
+	s.notify()
+}
+
+// Changed receives when the session is taken over or handed back, TakenBy tells by whom
+func (s *Sharing) Changed() <-chan struct{} {
+	return s.changed
+}
+
+func (s *Sharing) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// TakenBy returns the admin who has taken over the session, it is empty if there is none
+func (s *Sharing) TakenBy() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.takenBy
+}
+
+// Take returns whether input p of who goes to the connection and who drives now,
+// instructions other than mouse and key always go since they do not control the remote desktop
+func (s *Sharing) Take(who string, p []byte) (ok bool, driver string) {
+	if !guacd.IsActive(p) {
+		return true, ""
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	if s.driver == "" || s.driver == who || now.Sub(s.last) >= controlGrace {
+		s.driver = who
+		s.last = now
+	}
+	return s.driver == who, s.driver
+}
+
+// Release hands control over at once if who drives now, it is called when a participant leaves
+func (s *Sharing) Release(who string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.driver = lo.Ternary(s.driver == who, "", s.driver)
+}
+
+// ControlNotice tells a participant that driver drives the connection now
+func ControlNotice(driver string) []byte {
+	return guacd.NewInstruction(controlOpcode, driver).Bytes()
+}
+
+// AddParticipant records who on the session so that identities of all the drivers are kept after it ends
+func (m *Session) AddParticipant(who string) (err error) {
+	m.Sharing.mtx.Lock()
+	defer m.Sharing.mtx.Unlock()
+
+	if who == m.UserName || lo.Contains(m.Participants, who) {
+		return
+	}
+	participants := append(model.Slice[string]{}, m.Participants...)
+	participants = append(participants, who)
+	if err = mysql.AuditDB.Model(model.DefaultSession).Where("session_id = ?", m.SessionId).Update("participants", participants).Error; err != nil {
+		return
+	}
+	m.Participants = participants
+	return
+}
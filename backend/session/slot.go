@@ -0,0 +1,124 @@
+package session
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/samber/lo"
+)
+
+var (
+	ErrNoSlot = errors.New("no free session slot of the asset")
+
+	slots = &assetSlots{assets: map[int]*assetSlot{}}
+)
+
+type assetSlots struct {
+	mu     sync.Mutex
+	assets map[int]*assetSlot
+}
+
+type assetSlot struct {
+	used  int
+	limit int
+	queue []*Slot
+}
+
+// Slot is a session slot of an asset, queued ones get it in the order they come once others are released
+type Slot struct {
+	assetId  int
+	ready    chan struct{}
+	taken    bool
+	released bool
+}
+
+// AcquireSlot takes a slot if sessions of the asset are under limit, or queues it if queue is on.
+// limit is of the latest config of the asset so that changing it takes effect on the next acquirement
+func AcquireSlot(assetId, limit int, queue bool) (s *Slot, err error) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	a, ok := slots.assets[assetId]
+	if !ok {
+		a = &assetSlot{}
+		slots.assets[assetId] = a
+	}
+	a.limit = limit
+	s = &Slot{assetId: assetId, ready: make(chan struct{})}
+	switch {
+	case a.used < a.limit && len(a.queue) == 0:
+		a.used++
+		s.taken = true
+		close(s.ready)
+	case queue:
+		a.queue = append(a.queue, s)
+	default:
+		slots.gc(assetId)
+		return nil, ErrNoSlot
+	}
+	return
+}
+
+// SlotUsage returns how many slots of the asset are taken and how many are queued, nothing is acquired
+func SlotUsage(assetId int) (used, queued int) {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	if a, ok := slots.assets[assetId]; ok {
+		used, queued = a.used, len(a.queue)
+	}
+	return
+}
+
+// Ready is closed once the slot is taken
+func (s *Slot) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Position is the place in the queue from 1, it is 0 once the slot is taken
+func (s *Slot) Position() int {
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	if s.taken || s.released {
+		return 0
+	}
+	_, idx, _ := lo.FindIndexOf(slots.assets[s.assetId].queue, func(item *Slot) bool { return item == s })
+	return idx + 1
+}
+
+// Release frees the slot taken or leaves the queue, it is safe to be called more than once and on nil
+func (s *Slot) Release() {
+	if s == nil {
+		return
+	}
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	a := slots.assets[s.assetId]
+	if !s.taken {
+		a.queue = lo.Without(a.queue, s)
+		slots.gc(s.assetId)
+		return
+	}
+	a.used--
+	for a.used < a.limit && len(a.queue) > 0 {
+		next := a.queue[0]
+		a.queue = a.queue[1:]
+		a.used++
+		next.taken = true
+		close(next.ready)
+	}
+	slots.gc(s.assetId)
+}
+
+// gc drops assets without sessions or waiters, it is called with the lock held
+func (m *assetSlots) gc(assetId int) {
+	if a := m.assets[assetId]; a != nil && a.used <= 0 && len(a.queue) == 0 {
+		delete(m.assets, assetId)
+	}
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// StampAsciinema copies an asciicast v2 recording with the stamp burned in, it is the title of the header and is
+// drawn on the top line of the terminal at the start and every interval, so that copies leaked are traced to viewers
+func StampAsciinema(src io.Reader, dst io.Writer, stamp string, every time.Duration) (err error) {
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	w := bufio.NewWriter(dst)
+	if !sc.Scan() {
+		return fmt.Errorf("empty recording")
+	}
+	header := make(map[string]any)
+	if err = json.Unmarshal(sc.Bytes(), &header); err != nil {
+		return
+	}
+	header["title"] = stamp
+	bs, err := json.Marshal(header)
+	if err != nil {
+		return
+	}
+	w.Write(append(bs, '\n'))
+
+	overlay, err := json.Marshal(fmt.Sprintf("\x1b7\x1b[1;1H\x1b[7m %s \x1b[0m\x1b8", stamp))
+	if err != nil {
+		return
+	}
+	writeStamp := func(at float64) {
+		fmt.Fprintf(w, "[%f, %q, %s]\n", at, REPLAY_TYPE_OUTPUT, overlay)
+	}
+	writeStamp(0)
+	next := every.Seconds()
+	for sc.Scan() {
+		line := sc.Bytes()
+		if _, err = w.Write(append(line, '\n')); err != nil {
+			return
+		}
+		e := [3]any{}
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		// the stamp goes after output of the moment so that it is drawn over it
+		if at := cast.ToFloat64(e[0]); at >= next {
+			writeStamp(at)
+			for next <= at {
+				next += every.Seconds()
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return
+	}
+	return w.Flush()
+}
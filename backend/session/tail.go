@@ -0,0 +1,60 @@
+package session
+
+import (
+	"sync"
+
+	"github.com/samber/lo"
+	"github.com/veops/go-ansiterm"
+)
+
+const (
+	tailSize = 64 * 1024
+)
+
+// Tail keeps the latest output of a terminal session for previews
+type Tail struct {
+	buf []byte
+	mtx sync.Mutex
+}
+
+func NewTail() *Tail {
+	return &Tail{buf: make([]byte, 0, tailSize)}
+}
+
+func (t *Tail) Write(p []byte) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if len(p) >= tailSize {
+		t.buf = append(t.buf[:0], p[len(p)-tailSize:]...)
+		return
+	}
+	if over := len(t.buf) + len(p) - tailSize; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	t.buf = append(t.buf, p...)
+}
+
+func (t *Tail) Bytes() []byte {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return append([]byte(nil), t.buf...)
+}
+
+func (t *Tail) Len() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return len(t.buf)
+}
+
+// Lines renders the tail on a virtual screen and returns the last n lines
+func (t *Tail) Lines(w, n int) []string {
+	screen := ansiterm.NewScreen(w, n)
+	stream := ansiterm.InitByteStream(screen, false)
+	stream.Attach(screen)
+	stream.Feed(t.Bytes())
+
+	return lo.DropRightWhile(stream.Listener.Display(), func(item string) bool { return item == "" })
+}
@@ -0,0 +1,160 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	upsertRetries = 3
+	upsertBackoff = time.Millisecond * 200
+	// orphanGrace keeps online rows of sessions not in memory alone for a while, e.g. ones of exec running now
+	orphanGrace = time.Minute * 5
+)
+
+var (
+	// pendingUpserts are sessions whose upserts failed after retries, reconciliation takes them later
+	pendingUpserts = &sync.Map{}
+	upsertStats    = &upsertStat{}
+)
+
+// upsertStat counts upserts of sessions since the start
+type upsertStat struct {
+	Attempts   atomic.Int64
+	Retries    atomic.Int64
+	Failures   atomic.Int64
+	Reconciled atomic.Int64
+	Orphans    atomic.Int64
+}
+
+// UpsertGauge is the snapshot of counts of upserts, Pending are failed ones waiting for reconciliation
+type UpsertGauge struct {
+	Attempts   int64 `json:"attempts"`
+	Retries    int64 `json:"retries"`
+	Failures   int64 `json:"failures"`
+	Pending    int   `json:"pending"`
+	Reconciled int64 `json:"reconciled"`
+	Orphans    int64 `json:"orphans"`
+}
+
+func GetUpsertGauge() *UpsertGauge {
+	g := &UpsertGauge{
+		Attempts:   upsertStats.Attempts.Load(),
+		Retries:    upsertStats.Retries.Load(),
+		Failures:   upsertStats.Failures.Load(),
+		Reconciled: upsertStats.Reconciled.Load(),
+		Orphans:    upsertStats.Orphans.Load(),
+	}
+	pendingUpserts.Range(func(key, value any) bool {
+		g.Pending++
+		return true
+	})
+	return g
+}
+
+// UpsertSession saves the state of the session in a transaction, transient errors are retried with backoff. Sessions
+// failed after retries are left to ReconcileSessions, so callers could go on with the error logged
+func UpsertSession(data *Session) (err error) {
+	upsertStats.Attempts.Add(1)
+	for i := 0; i < upsertRetries; i++ {
+		if i > 0 {
+			upsertStats.Retries.Add(1)
+			time.Sleep(upsertBackoff << (i - 1))
+		}
+		if err = upsertSession(data); err == nil {
+			pendingUpserts.Delete(data.SessionId)
+			return
+		}
+	}
+	upsertStats.Failures.Add(1)
+	// a copy is kept since the session is still changed by its goroutines
+	state := *data.Session
+	pendingUpserts.Store(data.SessionId, &Session{Session: &state})
+	logger.L().Error("upsert session failed", zap.String("sessionId", data.SessionId), zap.Int("status", data.Status), zap.Error(err))
+	return
+}
+
+// upsertSession creates the session or moves it to the new status, sessions offline never go online again so that
+// stale upserts retried later do not reopen them
+func upsertSession(data *Session) (err error) {
+	changed := false
+	defer func() {
+		if err == nil && changed {
+			eventbus.PublishSession(data.Session)
+		}
+	}()
+	return mysql.AuditDB.Transaction(func(tx *gorm.DB) error {
+		cur := &model.Session{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status").Where("session_id = ?", data.SessionId).Take(cur).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			changed = true
+			return tx.Clauses(clause.OnConflict{
+				DoUpdates: clause.AssignmentColumns([]string{"status", "closed_at", "close_reason", "detached_at"}),
+			}).Create(data).Error
+		case err != nil:
+			return err
+		case cur.Status == model.SESSIONSTATUS_OFFLINE:
+			return nil
+		}
+		changed = cur.Status != data.Status
+		return tx.Model(cur).Updates(map[string]any{
+			"status": data.Status, "closed_at": data.ClosedAt, "close_reason": data.CloseReason, "detached_at": data.DetachedAt,
+		}).Error
+	})
+}
+
+// ReconcileSessions retries upserts failed before, and closes online rows of sessions gone from memory which are
+// left by upserts failed before restarts or lost, sessions held by other instances alive are not gone
+func ReconcileSessions() {
+	pendingUpserts.Range(func(key, value any) bool {
+		if upsertSession(value.(*Session)) == nil {
+			pendingUpserts.CompareAndDelete(key, value)
+			upsertStats.Reconciled.Add(1)
+		}
+		return true
+	})
+
+	grace := max(orphanGrace, time.Second*time.Duration(conf.Cfg.Exec.MaxTimeout)+time.Minute)
+	sessions := make([]*Session, 0)
+	if err := mysql.AuditDB.
+		Model(model.DefaultSession).
+		Where("status = ? AND created_at < ?", model.SESSIONSTATUS_ONLINE, time.Now().Add(-grace)).
+		Find(&sessions).
+		Error; err != nil {
+		logger.L().Error("get online sessions failed", zap.Error(err))
+		return
+	}
+	remotes, err := onlineSession.Remotes()
+	if err != nil {
+		logger.L().Error("get sessions of other instances failed", zap.Error(err))
+		return
+	}
+	ids := lo.SliceToMap(remotes, func(e *Entry) (string, bool) { return e.Session.SessionId, true })
+	now := time.Now()
+	for _, s := range sessions {
+		if _, ok := onlineSession.Load(s.SessionId); ok || ids[s.SessionId] {
+			continue
+		}
+		s.Status, s.ClosedAt = model.SESSIONSTATUS_OFFLINE, &now
+		if err := upsertSession(s); err != nil {
+			logger.L().Error("close orphan session failed", zap.String("sessionId", s.SessionId), zap.Error(err))
+			continue
+		}
+		upsertStats.Orphans.Add(1)
+		logger.L().Warn("orphan session closed", zap.String("sessionId", s.SessionId))
+	}
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	WS_POLICY_DROP  = "drop"
+	WS_POLICY_CLOSE = "close"
+)
+
+var (
+	ErrSlowClient  = errors.New("websocket is too slow to take output")
+	ErrPongTimeout = errors.New("websocket did not answer pings in time")
+	errWsClosed    = errors.New("websocket writer closed")
+)
+
+type wsMsg struct {
+	typ int
+	p   []byte
+}
+
+// WsOut writes messages to a websocket in its own goroutine through a bounded queue, so that a stalled browser
+// never blocks the session. Once the queue is full messages are dropped or the websocket is closed by the policy
+type WsOut struct {
+	Ws      *websocket.Conn
+	id      string
+	policy  string
+	timeout time.Duration
+	queue   chan *wsMsg
+	done    chan struct{}
+	mtx     sync.Mutex
+	closed  bool
+	err     atomic.Pointer[error]
+	sends   atomic.Int64
+	drops   atomic.Int64
+	// lastPong is the unix nano of the last pong, it is 0 unless keepalive is on
+	lastPong    atomic.Int64
+	pongTimeout time.Duration
+}
+
+// NewWsOut starts the writer of ws, id is only used to label logs, Close must be called to stop it
+func NewWsOut(ws *websocket.Conn, id string) *WsOut {
+	cfg := conf.Cfg.Backpressure
+	w := &WsOut{
+		Ws:      ws,
+		id:      id,
+		policy:  cfg.Policy,
+		timeout: time.Second * time.Duration(max(cfg.WriteTimeout, 1)),
+		queue:   make(chan *wsMsg, max(cfg.Queue, 1)),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// KeepAlive pings the websocket by the interval of the config, it is closed once pongs do not come back within the
+// pong timeout so that the reader of it returns. Browsers answer pings by themselves, it must be called before
+// reading starts and before w is shared since the read deadline and the timeout are set here
+func (w *WsOut) KeepAlive() {
+	cfg := conf.Cfg.Backpressure
+	if cfg.PingInterval <= 0 {
+		return
+	}
+	w.pongTimeout = time.Second * time.Duration(max(cfg.PongTimeout, cfg.PingInterval))
+	w.lastPong.Store(time.Now().UnixNano())
+	w.Ws.SetReadDeadline(time.Now().Add(w.pongTimeout))
+	w.Ws.SetPongHandler(func(string) error {
+		w.lastPong.Store(time.Now().UnixNano())
+		return w.Ws.SetReadDeadline(time.Now().Add(w.pongTimeout))
+	})
+	go func() {
+		tk := time.NewTicker(time.Second * time.Duration(cfg.PingInterval))
+		defer tk.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-tk.C:
+			}
+			// control frames could be written along with the writer of messages
+			if err := w.Ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(w.timeout)); err != nil {
+				w.fail(err)
+				return
+			}
+		}
+	}()
+}
+
+// Dead reports whether the websocket failed, was closed or stopped answering pings
+func (w *WsOut) Dead() bool {
+	if w.Err() != nil {
+		return true
+	}
+	w.mtx.Lock()
+	closed := w.closed
+	w.mtx.Unlock()
+	if closed {
+		return true
+	}
+	last := w.lastPong.Load()
+	return last > 0 && time.Since(time.Unix(0, last)) > w.pongTimeout
+}
+
+// WriteMessage queues a copy of p without blocking, it returns the error of the websocket once it fails
+func (w *WsOut) WriteMessage(typ int, p []byte) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if err := w.Err(); err != nil {
+		return err
+	}
+	if w.closed {
+		return errWsClosed
+	}
+	select {
+	case w.queue <- &wsMsg{typ: typ, p: append([]byte(nil), p...)}:
+		w.sends.Add(1)
+		return nil
+	default:
+	}
+	if w.drops.Add(1) == 1 {
+		logger.L().Warn("websocket is slow, output is dropped", zap.String("sessionId", w.id), zap.String("policy", w.policy))
+	}
+	if w.policy != WS_POLICY_CLOSE {
+		return nil
+	}
+	w.fail(ErrSlowClient)
+	return ErrSlowClient
+}
+
+// Send queues a copy of p like WriteMessage but waits for room instead of dropping, it is for output which must not
+// be lost, e.g. files of zmodem. The websocket is closed if it has no room within the write timeout
+func (w *WsOut) Send(typ int, p []byte) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if err := w.Err(); err != nil {
+		return err
+	}
+	if w.closed {
+		return errWsClosed
+	}
+	select {
+	case w.queue <- &wsMsg{typ: typ, p: append([]byte(nil), p...)}:
+		w.sends.Add(1)
+		return nil
+	case <-time.After(w.timeout):
+	}
+	w.fail(ErrSlowClient)
+	return ErrSlowClient
+}
+
+// Err returns why the websocket failed, nil if it has not
+func (w *WsOut) Err() error {
+	if err := w.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Close stops the writer once queued messages are written, it waits no longer than the write timeout
+func (w *WsOut) Close() {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mtx.Unlock()
+
+	select {
+	case <-w.done:
+	case <-time.After(w.timeout):
+	}
+}
+
+// Gauge returns the depth of the queue, sends never block so only drops are counted
+func (w *WsOut) Gauge() *ChanGauge {
+	return &ChanGauge{Len: len(w.queue), Cap: cap(w.queue), Sends: w.sends.Load(), Drops: w.drops.Load()}
+}
+
+func (w *WsOut) run() {
+	defer close(w.done)
+	for m := range w.queue {
+		// the rest is dropped once it fails, the queue is drained so that Close never waits for it
+		if w.Err() != nil {
+			continue
+		}
+		w.Ws.SetWriteDeadline(time.Now().Add(w.timeout))
+		if err := w.Ws.WriteMessage(m.typ, m.p); err != nil {
+			w.fail(err)
+		}
+	}
+}
+
+// fail keeps the first error and closes the websocket, so that readers of it end the session or the monitor
+func (w *WsOut) fail(err error) {
+	if !w.err.CompareAndSwap(nil, &err) {
+		return
+	}
+	logger.L().Debug("websocket closed", zap.String("sessionId", w.id), zap.Error(err))
+	w.Ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(time.Second))
+	w.Ws.Close()
+}
@@ -0,0 +1,188 @@
+package sshagent
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+var (
+	errReadOnly = fmt.Errorf("keys of forwarded agent are managed by oneterm")
+)
+
+type entry struct {
+	key    *model.AgentKey
+	signer ssh.Signer
+}
+
+// Agent serves agent keys of the user allowed on the asset to the target of a session, every signature is audited.
+// Keys could not be added or removed by targets
+type Agent struct {
+	sessionId string
+	uid       int
+	userName  string
+	assetId   int
+	accountId int
+	mtx       sync.Mutex
+	keys      []*entry
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+// New loads enabled keys of the user in scope of the asset, keys failed to parse are skipped
+func New(sessionId string, uid int, userName string, asset *model.Asset, accountId int) (a *Agent, err error) {
+	keys := make([]*model.AgentKey, 0)
+	if err = mysql.DB.Model(model.DefaultAgentKey).Where("uid = ? AND enable = ?", uid, true).Find(&keys).Error; err != nil {
+		return
+	}
+	a = &Agent{sessionId: sessionId, uid: uid, userName: userName, assetId: asset.Id, accountId: accountId}
+	if len(keys) == 0 {
+		return
+	}
+
+	nodes := make([]*model.Node, 0)
+	if err = mysql.DB.Model(model.DefaultNode).Select("id", "parent_id").Find(&nodes).Error; err != nil {
+		return
+	}
+	parents := lo.SliceToMap(nodes, func(n *model.Node) (int, int) { return n.Id, n.ParentId })
+	nodeIds := make([]int, 0)
+	for id := asset.ParentId; id != 0 && !lo.Contains(nodeIds, id); id = parents[id] {
+		nodeIds = append(nodeIds, id)
+	}
+
+	for _, k := range keys {
+		if !k.InScope(asset.Id, nodeIds) {
+			continue
+		}
+		signer, err := ParseSigner(util.DecryptAES(k.Pk), util.DecryptAES(k.Phrase))
+		if err != nil {
+			logger.L().Warn("parse agent key failed", zap.Int("keyId", k.Id), zap.Error(err))
+			continue
+		}
+		a.keys = append(a.keys, &entry{key: k, signer: signer})
+	}
+
+	return
+}
+
+// ParseSigner parses a private key which may be protected by phrase
+func ParseSigner(pk, phrase string) (ssh.Signer, error) {
+	if phrase == "" {
+		return ssh.ParsePrivateKey([]byte(pk))
+	}
+	return ssh.ParsePrivateKeyWithPassphrase([]byte(pk), []byte(phrase))
+}
+
+// Len returns the number of keys served
+func (a *Agent) Len() int {
+	return len(a.keys)
+}
+
+// Forward serves agent channels opened by the target and asks the target to forward for the session,
+// cli must not be shared by other sessions since channels of agent are not bound to sessions
+func (a *Agent) Forward(cli *ssh.Client, sess *ssh.Session) (err error) {
+	if err = agent.ForwardToAgent(cli, a); err != nil {
+		return
+	}
+	return agent.RequestAgentForwarding(sess)
+}
+
+func (a *Agent) List() ([]*agent.Key, error) {
+	return lo.Map(a.keys, func(e *entry, _ int) *agent.Key {
+		pub := e.signer.PublicKey()
+		return &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: e.key.Name}
+	}), nil
+}
+
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (sig *ssh.Signature, err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	hash := sha256.Sum256(data)
+	l := &model.AgentSignLog{
+		SessionId:   a.sessionId,
+		Uid:         a.uid,
+		UserName:    a.userName,
+		AssetId:     a.assetId,
+		AccountId:   a.accountId,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Algorithm:   key.Type(),
+		DataHash:    hex.EncodeToString(hash[:]),
+	}
+	defer func() {
+		l.Allowed = err == nil
+		if err != nil {
+			l.Reason = err.Error()
+		}
+		if e := mysql.AuditDB.Create(l).Error; e != nil {
+			logger.L().Error("save agent sign log failed", zap.String("sessionId", a.sessionId), zap.Error(e))
+		}
+	}()
+
+	e, ok := lo.Find(a.keys, func(e *entry) bool { return string(e.signer.PublicKey().Marshal()) == string(key.Marshal()) })
+	if !ok {
+		return nil, fmt.Errorf("key %s is not allowed on this asset", l.Fingerprint)
+	}
+	l.KeyId = e.key.Id
+
+	algo := ""
+	switch {
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		algo = ssh.KeyAlgoRSASHA256
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		algo = ssh.KeyAlgoRSASHA512
+	}
+	if algo == "" {
+		return e.signer.Sign(rand.Reader, data)
+	}
+	l.Algorithm = algo
+	as, ok := e.signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("key %s does not support %s", l.Fingerprint, algo)
+	}
+	return as.SignWithAlgorithm(rand.Reader, data, algo)
+}
+
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	return nil, errReadOnly
+}
+
+func (a *Agent) Add(key agent.AddedKey) error {
+	return errReadOnly
+}
+
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	return errReadOnly
+}
+
+func (a *Agent) RemoveAll() error {
+	return errReadOnly
+}
+
+func (a *Agent) Lock(passphrase []byte) error {
+	return errReadOnly
+}
+
+func (a *Agent) Unlock(passphrase []byte) error {
+	return errReadOnly
+}
+
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
@@ -39,6 +39,10 @@ func handler(sess ssh.Session) {
 	}
 	ctx.Set("sessionType", model.SESSIONTYPE_CLIENT)
 	ctx.Set("session", sess.Context().Value("session"))
+	if x := sess.Context().Value("x11"); x != nil {
+		ctx.Set("x11", x)
+		ctx.Set("sshConn", sess.Context().Value(ssh.ContextKeyConn))
+	}
 
 	eg, gctx := errgroup.WithContext(sess.Context())
 	r, w := io.Pipe()
@@ -3,12 +3,14 @@ package sshsrv
 import (
 	"context"
 	"fmt"
+	"net"
 
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/veops/oneterm/acl"
 	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/proxyproto"
 	"github.com/veops/oneterm/util"
 )
 
@@ -19,7 +21,7 @@ var (
 
 func init() {
 	server = &ssh.Server{
-		Addr:    fmt.Sprintf("%s:%d", conf.Cfg.Ssh.Host, conf.Cfg.Ssh.Port),
+		Addr:    net.JoinHostPort(conf.Cfg.Ssh.Host, fmt.Sprint(conf.Cfg.Ssh.Port)),
 		Handler: handler,
 		PasswordHandler: func(ctx ssh.Context, password string) bool {
 			sess, err := acl.LoginByPassword(ctx, ctx.User(), password, util.IpFromNetAddr(ctx.RemoteAddr()))
@@ -32,11 +34,18 @@ func init() {
 			return err == nil
 		},
 		HostSigners: []ssh.Signer{signer()},
+		ChannelHandlers: map[string]ssh.ChannelHandler{
+			"session": sessionHandler,
+		},
 	}
 }
 
 func RunSsh() error {
-	return server.ListenAndServe()
+	l, err := proxyproto.Listen(proxyproto.LISTENER_SSH, server.Addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(l)
 }
 
 func StopSsh() {
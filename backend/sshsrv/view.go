@@ -28,6 +28,7 @@ import (
 	"github.com/veops/oneterm/session"
 	"github.com/veops/oneterm/sshsrv/textinput"
 	"github.com/veops/oneterm/util"
+	"github.com/veops/oneterm/warning"
 )
 
 const (
@@ -43,9 +44,10 @@ var (
 	hiddenBorder = lipgloss.HiddenBorder()
 
 	p2p = map[string]int{
-		"ssh":   22,
-		"redis": 6379,
-		"mysql": 3306,
+		"ssh":    22,
+		"telnet": 23,
+		"redis":  6379,
+		"mysql":  3306,
 	}
 )
 
@@ -79,6 +81,8 @@ type view struct {
 	cmdsIdx     int
 	combines    map[string][3]int
 	connecting  bool
+	pending     string
+	confirmed   string
 	help        help.Model
 	keys        keymap
 	r           io.ReadCloser
@@ -145,6 +149,11 @@ func (m *view) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else if p, ok := lo.Find(lo.Keys(p2p), func(item string) bool { return strings.HasPrefix(cmd, item) }); ok {
 				pty, _, _ := m.Sess.Pty()
 				m.Ctx.Request.URL.RawQuery = fmt.Sprintf("w=%d&h=%d", pty.Window.Width, pty.Window.Height)
+				// entering the command again after warnings of the asset confirms them
+				if cmd == m.confirmed {
+					m.Ctx.Request.URL.RawQuery += "&confirm=true"
+				}
+				m.pending, m.confirmed = cmd, ""
 				m.Ctx.Params = nil
 				m.Ctx.Params = append(m.Ctx.Params, gin.Param{Key: "account_id", Value: cast.ToString(m.combines[cmd][0])})
 				m.Ctx.Params = append(m.Ctx.Params, gin.Param{Key: "asset_id", Value: cast.ToString(m.combines[cmd][1])})
@@ -183,6 +192,10 @@ func (m *view) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			str := msg.Error()
 			if ae, ok := msg.(*controller.ApiError); ok {
 				str = controller.Err2Msg[ae.Code].One
+				if ae.Code == controller.ErrAssetWarning {
+					m.confirmed = m.pending
+					str = fmt.Sprintf("asset has open warnings: %v, enter the command again to connect anyway", ae.Data["warnings"])
+				}
 			}
 			return m, tea.Printf("  [ERROR] %s\n\n", errStyle.Render(str))
 		}
@@ -337,6 +350,11 @@ func (conn *connector) Run() error {
 	if err != nil {
 		return err
 	}
+	if warnings, err := warning.Get(conn.Ctx, gsess.AssetId); err == nil {
+		for _, w := range warnings[gsess.AssetId] {
+			fmt.Fprintf(conn.stdout, "\x1b[0;33m [WARNING] [%s] %s \x1b[0m\r\n", w.Severity, w.Title)
+		}
+	}
 
 	conn.Vw.magicn()
 
@@ -368,7 +386,7 @@ func (conn *connector) Run() error {
 			case <-gsess.Gctx.Done():
 				return
 			case w := <-ch:
-				gsess.Chans.WindowChan <- w
+				gsess.Chans.SendWindow(w)
 			}
 		}
 	})
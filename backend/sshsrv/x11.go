@@ -0,0 +1,41 @@
+package sshsrv
+
+import (
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/x11"
+)
+
+// sessionHandler keeps the x11 request of the client, which is forwarded or not by the asset connected later
+func sessionHandler(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+	ssh.DefaultSessionHandler(srv, conn, &x11Channel{NewChannel: newChan, ctx: ctx}, ctx)
+}
+
+type x11Channel struct {
+	gossh.NewChannel
+	ctx ssh.Context
+}
+
+func (c *x11Channel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	ch, reqs, err := c.NewChannel.Accept()
+	if err != nil {
+		return ch, reqs, err
+	}
+	out := make(chan *gossh.Request)
+	go func() {
+		defer close(out)
+		for req := range reqs {
+			if req.Type != "x11-req" {
+				out <- req
+				continue
+			}
+			x, err := x11.ParseRequest(req.Payload)
+			if err == nil {
+				c.ctx.SetValue("x11", x)
+			}
+			req.Reply(err == nil, nil)
+		}
+	}()
+	return ch, out, nil
+}
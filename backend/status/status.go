@@ -0,0 +1,177 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	STATUS_OK       = "ok"
+	STATUS_DEGRADED = "degraded"
+	STATUS_DOWN     = "down"
+
+	// window of availability, samples older than it are dropped
+	window = time.Hour * 24
+)
+
+var (
+	started = time.Now()
+	current atomic.Pointer[Status]
+	// samples are the status of each check within the window, oldest first
+	samples     []sample
+	mtx         sync.Mutex
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+// Dependency is a service oneterm relies on, the service is down without required ones and degraded without others
+type Dependency struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Status   string `json:"status"`
+	check    func(ctx context.Context) error
+}
+
+// Status is coarse health of the service, reasons of failures are only logged since it could be public
+type Status struct {
+	Status       string        `json:"status"`
+	StartedAt    time.Time     `json:"started_at"`
+	Uptime       int64         `json:"uptime"`
+	Availability float64       `json:"availability"`
+	Degraded     []string      `json:"degraded"`
+	CheckedAt    time.Time     `json:"checked_at"`
+	Dependencies []*Dependency `json:"dependencies"`
+}
+
+type sample struct {
+	at time.Time
+	up bool
+}
+
+// dependencies are the ones checked, the audit db is only checked if it is another database
+func dependencies() []*Dependency {
+	ds := []*Dependency{
+		{Name: "mysql", Required: true, check: func(ctx context.Context) error { return ping(ctx, mysql.DB) }},
+		{Name: "redis", Required: true, check: func(ctx context.Context) error { return redis.RC.Ping(ctx).Err() }},
+		{Name: "guacd", check: func(ctx context.Context) error {
+			return dial(ctx, net.JoinHostPort(conf.Cfg.Guacd.Host, fmt.Sprint(conf.Cfg.Guacd.Port)))
+		}},
+	}
+	if mysql.AuditDB != mysql.DB {
+		ds = append(ds, &Dependency{Name: "auditDb", Required: true, check: func(ctx context.Context) error { return ping(ctx, mysql.AuditDB) }})
+	}
+	return ds
+}
+
+// Check checks all dependencies at the same time, the service is ready if the status is not down
+func Check(ctx context.Context) *Status {
+	cctx, ccancel := context.WithTimeout(ctx, time.Second*time.Duration(max(conf.Cfg.Status.Timeout, 1)))
+	defer ccancel()
+	ds := dependencies()
+	wg := &sync.WaitGroup{}
+	for _, d := range ds {
+		wg.Add(1)
+		go func(d *Dependency) {
+			defer wg.Done()
+			d.Status = STATUS_OK
+			if err := d.check(cctx); err != nil {
+				d.Status = STATUS_DOWN
+				logger.L().Warn("dependency is down", zap.String("name", d.Name), zap.Error(err))
+			}
+		}(d)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	s := &Status{
+		Status:       STATUS_OK,
+		StartedAt:    started,
+		Uptime:       int64(now.Sub(started).Seconds()),
+		Degraded:     []string{},
+		CheckedAt:    now,
+		Dependencies: ds,
+	}
+	for _, d := range ds {
+		if d.Status == STATUS_OK {
+			continue
+		}
+		s.Degraded = append(s.Degraded, d.Name)
+		s.Status = lo.Ternary(d.Required, STATUS_DOWN, lo.Ternary(s.Status == STATUS_DOWN, STATUS_DOWN, STATUS_DEGRADED))
+	}
+	return s
+}
+
+// Get returns the status of the last check with the availability of the window, which is the percent of checks the
+// service was not down
+func Get() *Status {
+	s := current.Load()
+	if s == nil {
+		s = record(Check(ctx))
+	}
+	state := *s
+	state.Uptime = int64(time.Since(started).Seconds())
+	mtx.Lock()
+	defer mtx.Unlock()
+	state.Availability = 100
+	if len(samples) > 0 {
+		up := lo.CountBy(samples, func(s sample) bool { return s.up })
+		state.Availability = float64(up*10000/len(samples)) / 100
+	}
+	return &state
+}
+
+// Run checks dependencies by the interval of the config until it is stopped
+func Run() (err error) {
+	record(Check(ctx))
+	tk := time.NewTicker(time.Second * time.Duration(max(conf.Cfg.Status.Interval, 1)))
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			record(Check(ctx))
+		}
+	}
+}
+
+func Stop() {
+	defer cancel()
+}
+
+func record(s *Status) *Status {
+	current.Store(s)
+	mtx.Lock()
+	defer mtx.Unlock()
+	since := s.CheckedAt.Add(-window)
+	samples = append(lo.DropWhile(samples, func(s sample) bool { return s.at.Before(since) }), sample{at: s.CheckedAt, up: s.Status != STATUS_DOWN})
+	return s
+}
+
+func ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func dial(ctx context.Context, addr string) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
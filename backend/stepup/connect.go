@@ -0,0 +1,46 @@
+package stepup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+
+	redis "github.com/veops/oneterm/cache"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+)
+
+// ConnectPolicy returns the mfa policy covering the user and the asset, nil if there is none.
+// The one with the shortest grace wins if policies overlap
+func ConnectPolicy(uid, rid, assetId int) (policy *model.MfaPolicy, err error) {
+	policies := make([]*model.MfaPolicy, 0)
+	if err = mysql.DB.Model(model.DefaultMfaPolicy).Where("enable = ?", true).Order("grace, id").Find(&policies).Error; err != nil {
+		return
+	}
+	policy, _ = lo.Find(policies, func(p *model.MfaPolicy) bool { return p.InScope(uid, rid, assetId) })
+	return
+}
+
+// RememberConnect caches the verification of the user for the asset during grace of the policy,
+// it is kept for a while until the connection if there is no grace
+func RememberConnect(ctx context.Context, uid, assetId int, policy *model.MfaPolicy) error {
+	ttl := lo.Ternary(policy.Grace > 0, time.Second*time.Duration(policy.Grace), challengeTtl)
+	return redis.RC.SetEx(ctx, connectKey(uid, assetId), policy.Grace, ttl).Err()
+}
+
+// ConnectVerified tells whether the user has verified for the asset, verifications without grace are used once
+func ConnectVerified(ctx context.Context, uid, assetId int, policy *model.MfaPolicy) (ok bool, err error) {
+	cnt := int64(0)
+	if policy.Grace > 0 {
+		cnt, err = redis.RC.Exists(ctx, connectKey(uid, assetId)).Result()
+	} else {
+		cnt, err = redis.RC.Del(ctx, connectKey(uid, assetId)).Result()
+	}
+	return cnt > 0, err
+}
+
+func connectKey(uid, assetId int) string {
+	return fmt.Sprintf("stepup-connect-%d-%d", uid, assetId)
+}
@@ -0,0 +1,207 @@
+package stepup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+
+	redis "github.com/veops/oneterm/cache"
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	challengeTtl = time.Minute * 2
+)
+
+var (
+	ErrFailed = errors.New("step up failed")
+	ErrUnused = errors.New("no fresh step up")
+	ErrLocked = fmt.Errorf("%w: too many failed attempts, try again later", ErrFailed)
+)
+
+// Proof is a totp code or a webauthn assertion of the challenge
+type Proof struct {
+	Method    string     `json:"method" binding:"required,oneof=totp webauthn"`
+	Code      string     `json:"code"`
+	Assertion *Assertion `json:"assertion"`
+}
+
+// Challenge is passed to navigator.credentials.get
+type Challenge struct {
+	Challenge     string   `json:"challenge"`
+	RpId          string   `json:"rp_id"`
+	CredentialIds []string `json:"credential_ids"`
+}
+
+// NewChallenge creates a challenge of webauthn for the user which is valid once in 2 minutes
+func NewChallenge(ctx context.Context, uid int, host string) (res *Challenge, err error) {
+	ids := make([]string, 0)
+	if err = mysql.DB.Model(model.DefaultStepUpCredential).
+		Where("uid = ? AND method = ?", uid, model.STEPUPMETHOD_WEBAUTHN).
+		Pluck("credential_id", &ids).Error; err != nil {
+		return
+	}
+	bs := make([]byte, 32)
+	rand.Read(bs)
+	res = &Challenge{
+		Challenge:     base64.RawURLEncoding.EncodeToString(bs),
+		RpId:          rpId(host),
+		CredentialIds: ids,
+	}
+	err = redis.RC.SetEx(ctx, challengeKey(uid), res.Challenge, challengeTtl).Err()
+	return
+}
+
+// Verify checks the proof by credentials of the user and records a step up
+func Verify(ctx context.Context, uid int, userName, clientIp, host string, p *Proof) (stepUp *model.StepUp, err error) {
+	if locked(ctx, uid) {
+		return nil, ErrLocked
+	}
+	defer func() { countAttempt(ctx, uid, err) }()
+
+	creds := make([]*model.StepUpCredential, 0)
+	if err = mysql.DB.Model(model.DefaultStepUpCredential).Where("uid = ? AND method = ?", uid, p.Method).Find(&creds).Error; err != nil {
+		return
+	}
+	var (
+		cred    *model.StepUpCredential
+		counter int64
+	)
+	switch p.Method {
+	case model.STEPUPMETHOD_TOTP:
+		for _, c := range creds {
+			if step, ok := verifyTotp(util.DecryptAES(c.Secret), p.Code, c.Counter, time.Now()); ok {
+				cred, counter = c, step
+				break
+			}
+		}
+		if cred == nil {
+			return nil, ErrFailed
+		}
+	case model.STEPUPMETHOD_WEBAUTHN:
+		if p.Assertion == nil {
+			return nil, ErrFailed
+		}
+		c, ok := lo.Find(creds, func(c *model.StepUpCredential) bool { return c.CredentialId == p.Assertion.CredentialId })
+		if !ok {
+			return nil, ErrFailed
+		}
+		// challenges are used once whether the assertion is right or not
+		challenge := ""
+		if challenge, err = redis.RC.GetDel(ctx, challengeKey(uid)).Result(); err != nil {
+			return nil, fmt.Errorf("%w: challenge is expired", ErrFailed)
+		}
+		pub, e := ParsePublicKey(c.PublicKey)
+		if e != nil {
+			return nil, e
+		}
+		if counter, err = verifyAssertion(pub, p.Assertion, challenge, rpId(host), conf.Cfg.StepUp.Origins, c.Counter); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFailed, err)
+		}
+		cred = c
+	}
+
+	now := time.Now()
+	// the counter only moves forward, so the same code or assertion is not accepted twice
+	res := mysql.DB.Model(cred).Where("counter = ?", cred.Counter).UpdateColumns(map[string]any{"counter": counter, "last_used": now})
+	if err = res.Error; err != nil {
+		return
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrFailed
+	}
+	stepUp = &model.StepUp{
+		Uid:          uid,
+		UserName:     userName,
+		Method:       p.Method,
+		CredentialId: cred.Id,
+		ClientIp:     clientIp,
+		CreatedAt:    now,
+	}
+	err = mysql.AuditDB.Create(stepUp).Error
+	return
+}
+
+// Use marks the step up of the user as used, it must be fresh and is used only once
+func Use(uid, id int) (err error) {
+	ttl := time.Second * time.Duration(conf.Cfg.StepUp.Ttl)
+	res := mysql.AuditDB.Model(model.DefaultStepUp).
+		Where("id = ? AND uid = ? AND used_at IS NULL AND created_at >= ?", id, uid, time.Now().Add(-ttl)).
+		Update("used_at", time.Now())
+	if err = res.Error; err != nil {
+		return
+	}
+	if res.RowsAffected == 0 {
+		return ErrUnused
+	}
+	return
+}
+
+// Restricted tells whether any of the assets has the risk tag which needs step ups, deleted assets are included
+func Restricted(assetIds ...int) (ok bool, err error) {
+	tag := conf.Cfg.StepUp.Tag
+	if tag == "" {
+		return
+	}
+	assets := make([]*model.Asset, 0)
+	if err = mysql.DB.Unscoped().Model(model.DefaultAsset).Select("id", "risk_tags").Where("id IN ?", assetIds).Find(&assets).Error; err != nil {
+		return
+	}
+	return lo.ContainsBy(assets, func(a *model.Asset) bool { return lo.Contains(a.RiskTags, tag) }), nil
+}
+
+// HasCredentials tells whether the user enrolled any credential
+func HasCredentials(uid int) (ok bool, err error) {
+	cnt := int64(0)
+	err = mysql.DB.Model(model.DefaultStepUpCredential).Where("uid = ?", uid).Count(&cnt).Error
+	return cnt > 0, err
+}
+
+// VerifySecret checks the code of a new totp secret before it is enrolled
+func VerifySecret(secret, code string) bool {
+	_, ok := verifyTotp(secret, code, 0, time.Now())
+	return ok
+}
+
+// locked tells whether failures of the user reach the max, so that 6 digits codes could not be guessed
+func locked(ctx context.Context, uid int) bool {
+	max := conf.Cfg.StepUp.MaxAttempts
+	if max <= 0 {
+		return false
+	}
+	n, _ := redis.RC.Get(ctx, attemptsKey(uid)).Int()
+	return n >= max
+}
+
+// countAttempt counts failures in the lock time from the first one, a success clears them
+func countAttempt(ctx context.Context, uid int, err error) {
+	key := attemptsKey(uid)
+	switch {
+	case err == nil:
+		redis.RC.Del(ctx, key)
+	case errors.Is(err, ErrFailed) && !errors.Is(err, ErrLocked):
+		if n, e := redis.RC.Incr(ctx, key).Result(); e == nil && n == 1 {
+			redis.RC.Expire(ctx, key, time.Second*time.Duration(conf.Cfg.StepUp.LockTime))
+		}
+	}
+}
+
+func rpId(host string) string {
+	return lo.Ternary(conf.Cfg.StepUp.RpId != "", conf.Cfg.StepUp.RpId, host)
+}
+
+func challengeKey(uid int) string {
+	return fmt.Sprintf("stepup-challenge-%d", uid)
+}
+
+func attemptsKey(uid int) string {
+	return fmt.Sprintf("stepup-attempts-%d", uid)
+}
@@ -0,0 +1,78 @@
+package stepup
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+var (
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewSecret returns a random totp secret in base32
+func NewSecret() string {
+	bs := make([]byte, 20)
+	rand.Read(bs)
+	return b32.EncodeToString(bs)
+}
+
+// Uri is scanned as a qr code by authenticator apps
+//
+//	https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func Uri(issuer, account, secret string) string {
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), q.Encode())
+}
+
+// verifyTotp checks code against time steps around now which are after last, and returns the matched step
+//
+//	https://www.rfc-editor.org/rfc/rfc6238
+func verifyTotp(secret, code string, last int64, now time.Time) (step int64, ok bool) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != totpDigits {
+		return
+	}
+	cur := now.Unix() / totpPeriod
+	for _, s := range []int64{cur - 1, cur, cur + 1} {
+		if s <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return
+}
+
+// hotp is the code of the counter
+//
+//	https://www.rfc-editor.org/rfc/rfc4226#section-5.3
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
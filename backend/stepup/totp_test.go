@@ -0,0 +1,115 @@
+package stepup
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the sha1 seed "12345678901234567890" of the test vectors in base32
+//
+//	https://www.rfc-editor.org/rfc/rfc6238#appendix-B
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHotp(t *testing.T) {
+	key, _ := b32.DecodeString(rfc6238Secret)
+	// codes of the rfc are of 8 digits, the last 6 of them are the same as 6 digits ones
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "59", unix: 59, want: "287082"},
+		{name: "1111111109", unix: 1111111109, want: "081804"},
+		{name: "1111111111", unix: 1111111111, want: "050471"},
+		{name: "1234567890", unix: 1234567890, want: "005924"},
+		{name: "2000000000", unix: 2000000000, want: "279037"},
+		{name: "20000000000", unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hotp(key, tt.unix/totpPeriod); got != tt.want {
+				t.Errorf("hotp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTotp(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / totpPeriod
+	type args struct {
+		secret string
+		code   string
+		last   int64
+		now    time.Time
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantStep int64
+		wantOk   bool
+	}{
+		{
+			name:     "current step",
+			args:     args{secret: rfc6238Secret, code: "081804", now: now},
+			wantStep: step,
+			wantOk:   true,
+		},
+		{
+			name:     "lowercase secret with spaces",
+			args:     args{secret: " gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", code: "081804", now: now},
+			wantStep: step,
+			wantOk:   true,
+		},
+		{
+			name:     "previous step for clock skew",
+			args:     args{secret: rfc6238Secret, code: "081804", now: now.Add(time.Second * totpPeriod)},
+			wantStep: step,
+			wantOk:   true,
+		},
+		{
+			name:     "next step for clock skew",
+			args:     args{secret: rfc6238Secret, code: "081804", now: now.Add(-time.Second * totpPeriod)},
+			wantStep: step,
+			wantOk:   true,
+		},
+		{
+			name: "out of the window",
+			args: args{secret: rfc6238Secret, code: "081804", now: now.Add(time.Second * totpPeriod * 2)},
+		},
+		{
+			name: "replayed in the window",
+			args: args{secret: rfc6238Secret, code: "081804", last: step, now: now},
+		},
+		{
+			name: "replayed in the next step",
+			args: args{secret: rfc6238Secret, code: "081804", last: step, now: now.Add(time.Second * totpPeriod)},
+		},
+		{
+			name:     "after an older step",
+			args:     args{secret: rfc6238Secret, code: "081804", last: step - 1, now: now},
+			wantStep: step,
+			wantOk:   true,
+		},
+		{
+			name: "wrong code",
+			args: args{secret: rfc6238Secret, code: "081805", now: now},
+		},
+		{
+			name: "wrong length",
+			args: args{secret: rfc6238Secret, code: "07081804", now: now},
+		},
+		{
+			name: "bad secret",
+			args: args{secret: "not base32!", code: "081804", now: now},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOk := verifyTotp(tt.args.secret, tt.args.code, tt.args.last, tt.args.now)
+			if gotStep != tt.wantStep || gotOk != tt.wantOk {
+				t.Errorf("verifyTotp() = %v, %v, want %v, %v", gotStep, gotOk, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package stepup
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+const (
+	flagUserPresent = 0x01
+)
+
+// Assertion is the response of navigator.credentials.get, fields are in base64url
+type Assertion struct {
+	CredentialId      string `json:"credential_id" binding:"required"`
+	ClientDataJson    string `json:"client_data_json" binding:"required"`
+	AuthenticatorData string `json:"authenticator_data" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ParsePublicKey parses the der of subject public key info, which is returned by getPublicKey of attestation responses
+func ParsePublicKey(s string) (pub crypto.PublicKey, err error) {
+	der, err := decode(s)
+	if err != nil {
+		return
+	}
+	if pub, err = x509.ParsePKIXPublicKey(der); err != nil {
+		return
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", pub)
+	}
+}
+
+// verifyAssertion checks the assertion is signed by pub for challenge of rpId, and returns its sign count
+//
+//	https://www.w3.org/TR/webauthn-2/#sctn-verifying-assertion
+func verifyAssertion(pub crypto.PublicKey, a *Assertion, challenge, rpId string, origins []string, last int64) (count int64, err error) {
+	cdj, err := decode(a.ClientDataJson)
+	if err != nil {
+		return
+	}
+	authData, err := decode(a.AuthenticatorData)
+	if err != nil {
+		return
+	}
+	sig, err := decode(a.Signature)
+	if err != nil {
+		return
+	}
+
+	cd := &clientData{}
+	if err = json.Unmarshal(cdj, cd); err != nil {
+		return
+	}
+	if cd.Type != "webauthn.get" || cd.Challenge != challenge {
+		return 0, errors.New("wrong type or challenge of client data")
+	}
+	if len(origins) > 0 && !lo.Contains(origins, cd.Origin) {
+		return 0, fmt.Errorf("origin %s is not allowed", cd.Origin)
+	}
+	if u, e := url.Parse(cd.Origin); len(origins) == 0 && (e != nil || u.Hostname() != rpId) {
+		return 0, fmt.Errorf("origin %s is not of %s", cd.Origin, rpId)
+	}
+
+	// rp id hash, flags and sign count
+	if len(authData) < 37 {
+		return 0, errors.New("authenticator data is too short")
+	}
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	if !bytes.Equal(authData[:32], rpIdHash[:]) {
+		return 0, errors.New("wrong rp id hash")
+	}
+	if authData[32]&flagUserPresent == 0 {
+		return 0, errors.New("user is not present")
+	}
+	count = int64(binary.BigEndian.Uint32(authData[33:37]))
+	// authenticators without counters always sign 0
+	if (count != 0 || last != 0) && count <= last {
+		return 0, errors.New("sign count did not increase, the authenticator may be cloned")
+	}
+
+	cdHash := sha256.Sum256(cdj)
+	msg := append(authData[:len(authData):len(authData)], cdHash[:]...)
+	digest := sha256.Sum256(msg)
+	ok := false
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, msg, sig)
+	}
+	if !ok {
+		return 0, errors.New("wrong signature")
+	}
+	return
+}
+
+// decode accepts base64url with or without padding
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,240 @@
+package stepup
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+const (
+	testRpId      = "oneterm.example.com"
+	testOrigin    = "https://oneterm.example.com"
+	testChallenge = "Y2hhbGxlbmdl"
+)
+
+// testAuthenticator signs assertions like authenticators do
+type testAuthenticator struct {
+	signer crypto.Signer
+}
+
+func (a *testAuthenticator) assert(t *testing.T, typ, challenge, origin, rpId string, flags byte, count uint32) *Assertion {
+	cdj, _ := json.Marshal(&clientData{Type: typ, Challenge: challenge, Origin: origin})
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	authData := append(rpIdHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authData[33:], count)
+
+	cdHash := sha256.Sum256(cdj)
+	msg := append(authData[:len(authData):len(authData)], cdHash[:]...)
+	var (
+		sig []byte
+		err error
+	)
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		sig, err = a.signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(msg)
+		sig, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := base64.RawURLEncoding.EncodeToString
+	return &Assertion{
+		CredentialId:      "credential",
+		ClientDataJson:    enc(cdj),
+		AuthenticatorData: enc(authData),
+		Signature:         enc(sig),
+	}
+}
+
+// publicKey returns the key like ParsePublicKey does from getPublicKey of the attestation response
+func (a *testAuthenticator) publicKey(t *testing.T) crypto.PublicKey {
+	der, err := x509.MarshalPKIXPublicKey(a.signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(base64.RawURLEncoding.EncodeToString(der))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec, other := &testAuthenticator{signer: ecKey}, &testAuthenticator{signer: otherKey}
+
+	tests := []struct {
+		name      string
+		auth      *testAuthenticator
+		assertion func(t *testing.T, a *testAuthenticator) *Assertion
+		origins   []string
+		last      int64
+		want      int64
+		wantErr   bool
+	}{
+		{
+			name: "ecdsa",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 8)
+			},
+			last: 7,
+			want: 8,
+		},
+		{
+			name: "rsa",
+			auth: &testAuthenticator{signer: rsaKey},
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+			},
+			want: 1,
+		},
+		{
+			name: "ed25519",
+			auth: &testAuthenticator{signer: edKey},
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+			},
+			want: 1,
+		},
+		{
+			name: "authenticator without counter",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 0)
+			},
+			want: 0,
+		},
+		{
+			name: "allowed origin",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, "https://other.example.com", testRpId, flagUserPresent, 1)
+			},
+			origins: []string{"https://other.example.com"},
+			want:    1,
+		},
+		{
+			name: "counter not increased",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 7)
+			},
+			last:    7,
+			wantErr: true,
+		},
+		{
+			name: "counter gone back to 0",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 0)
+			},
+			last:    7,
+			wantErr: true,
+		},
+		{
+			name: "signed by another key",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return other.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "tampered authenticator data",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				res := a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+				tampered := a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 100)
+				res.AuthenticatorData = tampered.AuthenticatorData
+				return res
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong challenge",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", "b3RoZXI", testOrigin, testRpId, flagUserPresent, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong type",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.create", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "origin of another host",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, "https://evil.example.com", testRpId, flagUserPresent, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "origin not allowed",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+			},
+			origins: []string{"https://other.example.com"},
+			wantErr: true,
+		},
+		{
+			name: "wrong rp id",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, "evil.example.com", flagUserPresent, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "user not present",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				return a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, 0, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "short authenticator data",
+			auth: ec,
+			assertion: func(t *testing.T, a *testAuthenticator) *Assertion {
+				res := a.assert(t, "webauthn.get", testChallenge, testOrigin, testRpId, flagUserPresent, 1)
+				res.AuthenticatorData = res.AuthenticatorData[:20]
+				return res
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyAssertion(tt.auth.publicKey(t), tt.assertion(t, tt.auth), testChallenge, testRpId, tt.origins, tt.last)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyAssertion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("verifyAssertion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
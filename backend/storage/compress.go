@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	chunkLiteral = 0
+	chunkRef     = 1
+
+	// versions of the format follow the magic, recordings archived as gzip before zstd are still read
+	versionGzip = 1
+	versionZstd = 2
+
+	// minDedupSize chunks smaller than it are cheaper to compress than to reference
+	minDedupSize = 256
+	// maxDedupBytes bounds the chunks kept for references on both sides
+	maxDedupBytes = 64 << 20
+)
+
+var (
+	compressedMagic = []byte("OTRZ")
+)
+
+// dedupTable indexes chunks in the order they are written, writer and reader must index the same chunks
+type dedupTable struct {
+	chunks [][]byte
+	size   int
+}
+
+func (t *dedupTable) add(p []byte) bool {
+	if len(p) < minDedupSize || t.size+len(p) > maxDedupBytes {
+		return false
+	}
+	t.chunks = append(t.chunks, p)
+	t.size += len(p)
+	return true
+}
+
+// Compress splits recording into chunks by line of asciicast or instruction of guacd,
+// chunks seen before are written as references, e.g. repeated images of an idle rdp screen,
+// then the whole stream is compressed by zstd
+func Compress(src io.Reader, dst io.Writer) (err error) {
+	if _, err = dst.Write(append(compressedMagic, versionZstd)); err != nil {
+		return
+	}
+	zw, err := zstd.NewWriter(dst, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(zw)
+	reader := bufio.NewReader(src)
+	seen := make(map[[sha256.Size]byte]int)
+	table := &dedupTable{}
+	head := make([]byte, 1+binary.MaxVarintLen64)
+	for {
+		p, e := readChunk(reader)
+		if len(p) > 0 {
+			sum := sha256.Sum256(p)
+			if idx, ok := seen[sum]; ok && len(p) >= minDedupSize {
+				head[0] = chunkRef
+				n := binary.PutUvarint(head[1:], uint64(idx))
+				if _, err = w.Write(head[:1+n]); err != nil {
+					return
+				}
+			} else {
+				head[0] = chunkLiteral
+				n := binary.PutUvarint(head[1:], uint64(len(p)))
+				if _, err = w.Write(head[:1+n]); err != nil {
+					return
+				}
+				if _, err = w.Write(p); err != nil {
+					return
+				}
+				if table.add(p) {
+					seen[sum] = len(table.chunks) - 1
+				}
+			}
+		}
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return e
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	return zw.Close()
+}
+
+func readChunk(r *bufio.Reader) (p []byte, err error) {
+	for {
+		b, e := r.ReadByte()
+		if e != nil {
+			return p, e
+		}
+		p = append(p, b)
+		if b == '\n' || b == ';' {
+			return p, nil
+		}
+	}
+}
+
+// IsCompressed reports whether the header is written by Compress of any version
+func IsCompressed(header []byte) bool {
+	return len(header) > len(compressedMagic) && bytes.HasPrefix(header, compressedMagic)
+}
+
+type decompressor struct {
+	src   io.ReadCloser
+	zr    io.ReadCloser
+	r     *bufio.Reader
+	table *dedupTable
+	buf   []byte
+}
+
+// Decompress wraps r if it is compressed, otherwise r is returned as it is
+func Decompress(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(compressedMagic) + 1)
+	if !IsCompressed(header) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, r}, nil
+	}
+	br.Discard(len(header))
+	var zr io.ReadCloser
+	var err error
+	switch version := header[len(compressedMagic)]; version {
+	case versionGzip:
+		zr, err = gzip.NewReader(br)
+	case versionZstd:
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(br, zstd.WithDecoderConcurrency(1)); err == nil {
+			zr = d.IOReadCloser()
+		}
+	default:
+		err = fmt.Errorf("unsupported version %d of compressed recording", version)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &decompressor{src: r, zr: zr, r: bufio.NewReader(zr), table: &dedupTable{}}, nil
+}
+
+func (d *decompressor) Read(p []byte) (n int, err error) {
+	for len(d.buf) == 0 {
+		if err = d.next(); err != nil {
+			return
+		}
+	}
+	n = copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return
+}
+
+func (d *decompressor) next() (err error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	switch kind {
+	case chunkLiteral:
+		p := make([]byte, v)
+		if _, err = io.ReadFull(d.r, p); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		d.table.add(p)
+		d.buf = p
+	case chunkRef:
+		if v >= uint64(len(d.table.chunks)) {
+			return fmt.Errorf("invalid chunk reference %d", v)
+		}
+		d.buf = d.table.chunks[v]
+	default:
+		return fmt.Errorf("invalid chunk kind %d", kind)
+	}
+	return
+}
+
+func (d *decompressor) Close() error {
+	d.zr.Close()
+	return d.src.Close()
+}
+
+// compressFile replaces the local file with its compressed form
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	header := make([]byte, len(compressedMagic)+1)
+	n, _ := io.ReadFull(src, header)
+	if IsCompressed(header[:n]) {
+		return
+	}
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	err = Compress(src, dst)
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	return os.Rename(tmp, path)
+}
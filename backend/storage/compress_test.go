@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	frame := `[0.5, "o", "` + strings.Repeat("x", minDedupSize) + `"]` + "\n"
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "asciicast", data: `{"version": 2, "width": 80, "height": 24}` + "\n" + `[0.1, "o", "hello"]` + "\n"},
+		{name: "repeated chunks", data: frame + frame + `[1.0, "o", "bye"]` + "\n" + frame},
+		{name: "guacd", data: "4.sync,8.12345678;3.img,1.1,9.image/png;3.img,1.1,9.image/png;"},
+		{name: "without trailing delimiter", data: "tail without newline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := Compress(strings.NewReader(tt.data), buf); err != nil {
+				t.Fatalf("Compress() error = %v", err)
+			}
+			if got := buf.Bytes()[len(compressedMagic)]; got != versionZstd {
+				t.Errorf("Compress() version = %d, want %d", got, versionZstd)
+			}
+			r, err := Decompress(io.NopCloser(buf))
+			if err != nil {
+				t.Fatalf("Decompress() error = %v", err)
+			}
+			defer r.Close()
+			if got, err := io.ReadAll(r); err != nil || string(got) != tt.data {
+				t.Errorf("Decompress() = %q, %v, want %q", got, err, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	gzipped := func(p []byte) []byte {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		zw.Write(p)
+		zw.Close()
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "not compressed",
+			data: []byte(`[0.1, "o", "hello"]` + "\n"),
+			want: `[0.1, "o", "hello"]` + "\n",
+		},
+		{
+			name: "shorter than the header",
+			data: []byte("OTR"),
+			want: "OTR",
+		},
+		{
+			name: "gzip of version 1",
+			data: append([]byte("OTRZ\x01"), gzipped([]byte("\x00\x06hello\n"))...),
+			want: "hello\n",
+		},
+		{
+			name:    "unknown version",
+			data:    []byte("OTRZ\x09"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Decompress(io.NopCloser(bytes.NewReader(tt.data)))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decompress() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			defer r.Close()
+			if got, err := io.ReadAll(r); err != nil || string(got) != tt.want {
+				t.Errorf("Decompress() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) Upload(name, path string) error {
+	dst := filepath.Join(l.dir, name)
+	if dst == filepath.Clean(path) {
+		return nil
+	}
+	return os.Rename(path, dst)
+}
+
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.dir, name))
+}
+
+func (l *Local) Remove(name string) error {
+	return os.Remove(filepath.Join(l.dir, name))
+}
+
+func (l *Local) Walk(fn func(name string, modTime time.Time) error) error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		var info fs.FileInfo
+		if info, err = e.Info(); err != nil {
+			continue
+		}
+		if err = fn(e.Name(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+
+	"github.com/veops/oneterm/conf"
+)
+
+const (
+	minPartSize = 5 << 20
+)
+
+// S3 is a minimal client of s3 compatible storages, requests are signed with signature v4
+//
+//	https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+type S3 struct {
+	cfg      conf.S3Config
+	partSize int64
+	client   *http.Client
+}
+
+type initiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []*completedPart `xml:"Part"`
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func NewS3(cfg conf.S3Config) *S3 {
+	if cfg.Prefix = strings.TrimLeft(cfg.Prefix, "/"); cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+	return &S3{
+		cfg:      cfg,
+		partSize: max(int64(cfg.PartSize)<<20, minPartSize),
+		client:   &http.Client{Timeout: time.Minute * 10},
+	}
+}
+
+func (s *S3) Upload(name, path string) (err error) {
+	key := s.key(name)
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	if info.Size() <= s.partSize {
+		bs, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		if err = s.do(http.MethodPut, key, nil, bs, nil); err != nil {
+			return err
+		}
+	} else if err = s.multipartUpload(key, f); err != nil {
+		return
+	}
+
+	f.Close()
+	return os.Remove(path)
+}
+
+// multipartUpload uploads large recordings like rdp part by part to key, the upload is aborted on failure
+func (s *S3) multipartUpload(key string, r io.Reader) (err error) {
+	res := &initiateMultipartUploadResult{}
+	if err = s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, res); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			s.do(http.MethodDelete, key, url.Values{"uploadId": {res.UploadId}}, nil, nil)
+		}
+	}()
+
+	complete := &completeMultipartUpload{}
+	buf := make([]byte, s.partSize)
+	for i := 1; ; i++ {
+		n, e := io.ReadFull(r, buf)
+		if n > 0 {
+			var etag string
+			if etag, err = s.uploadPart(key, res.UploadId, i, buf[:n]); err != nil {
+				return
+			}
+			complete.Parts = append(complete.Parts, &completedPart{PartNumber: i, ETag: etag})
+		}
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			break
+		}
+		if e != nil {
+			return e
+		}
+	}
+
+	bs, err := xml.Marshal(complete)
+	if err != nil {
+		return
+	}
+	return s.do(http.MethodPost, key, url.Values{"uploadId": {res.UploadId}}, bs, nil)
+}
+
+func (s *S3) uploadPart(key, uploadId string, number int, data []byte) (etag string, err error) {
+	resp, err := s.request(http.MethodPut, key, url.Values{"partNumber": {cast.ToString(number)}, "uploadId": {uploadId}}, data)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	etag = resp.Header.Get("ETag")
+	return
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.request(http.MethodGet, s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Remove(name string) error {
+	return s.do(http.MethodDelete, s.key(name), nil, nil, nil)
+}
+
+// Walk walks objects under the prefix only, names are without the prefix like the ones of Upload
+func (s *S3) Walk(fn func(name string, modTime time.Time) error) (err error) {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res := &listBucketResult{}
+		if err = s.do(http.MethodGet, "", query, nil, res); err != nil {
+			return
+		}
+		for _, c := range res.Contents {
+			if err = fn(strings.TrimPrefix(c.Key, s.cfg.Prefix), c.LastModified); err != nil {
+				return
+			}
+		}
+		if !res.IsTruncated {
+			return
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// key is the object key of name under the prefix
+func (s *S3) key(name string) string {
+	return s.cfg.Prefix + name
+}
+
+// do sends the request and decodes xml response into dst if it is not nil
+func (s *S3) do(method, key string, query url.Values, body []byte, dst any) (err error) {
+	resp, err := s.request(method, key, query, body)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if dst == nil {
+		io.Copy(io.Discard, resp.Body)
+		return
+	}
+	return xml.NewDecoder(resp.Body).Decode(dst)
+}
+
+func (s *S3) request(method, key string, query url.Values, body []byte) (resp *http.Response, err error) {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return
+	}
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	if key != "" {
+		u.Path += "/" + key
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	s.sign(req, body)
+
+	if resp, err = s.client.Do(req); err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("s3 %s %s failed: %s %s", method, key, resp.Status, bs)
+	}
+	return
+}
+
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := time.Now().UTC()
+	date, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	payloadHash := hashHex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.cfg.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + s.cfg.SecretKey)
+	for _, v := range []string{date, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSha256(key, v)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSha256(key, stringToSign))))
+}
+
+// encodeQuery encodes query in the canonical form of signature v4
+func encodeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, escape(k)+"="+escape(query.Get(k)))
+	}
+	return strings.Join(parts, "&")
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hashHex(bs []byte) string {
+	h := sha256.Sum256(bs)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
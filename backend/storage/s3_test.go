@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/veops/oneterm/conf"
+)
+
+func TestS3Prefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		wantPrefix string
+		wantKey    string
+	}{
+		{name: "empty", prefix: "", wantPrefix: "", wantKey: "/oneterm/a.cast"},
+		{name: "with slash", prefix: "recordings/", wantPrefix: "recordings/", wantKey: "/oneterm/recordings/a.cast"},
+		{name: "without slash", prefix: "recordings", wantPrefix: "recordings/", wantKey: "/oneterm/recordings/a.cast"},
+		{name: "leading slash", prefix: "/recordings/", wantPrefix: "recordings/", wantKey: "/oneterm/recordings/a.cast"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths, listPrefixes []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/oneterm" {
+					listPrefixes = append(listPrefixes, r.URL.Query().Get("prefix"))
+					fmt.Fprintf(w, "<ListBucketResult><Contents><Key>%sa.cast</Key><LastModified>2024-01-01T00:00:00Z</LastModified></Contents></ListBucketResult>",
+						r.URL.Query().Get("prefix"))
+					return
+				}
+				paths = append(paths, r.Method+" "+r.URL.Path)
+				io.WriteString(w, "recording")
+			}))
+			defer srv.Close()
+
+			s := NewS3(conf.S3Config{Endpoint: srv.URL, Bucket: "oneterm", PathStyle: true, Prefix: tt.prefix})
+			path := filepath.Join(t.TempDir(), "a.cast")
+			os.WriteFile(path, []byte("recording"), 0644)
+			if err := s.Upload("a.cast", path); err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+			f, err := s.Open("a.cast")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			f.Close()
+			if err = s.Remove("a.cast"); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			want := []string{"PUT " + tt.wantKey, "GET " + tt.wantKey, "DELETE " + tt.wantKey}
+			if !slices.Equal(paths, want) {
+				t.Errorf("requests = %v, want %v", paths, want)
+			}
+
+			var names []string
+			err = s.Walk(func(name string, _ time.Time) error {
+				names = append(names, name)
+				return nil
+			})
+			if err != nil || !slices.Equal(names, []string{"a.cast"}) {
+				t.Errorf("Walk() = %v, %v, want %v", names, err, []string{"a.cast"})
+			}
+			if len(listPrefixes) != 1 || listPrefixes[0] != tt.wantPrefix {
+				t.Errorf("prefix of list = %q, want %q", listPrefixes, tt.wantPrefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	TYPE_LOCAL = "local"
+	TYPE_S3    = "s3"
+)
+
+// Storage persists finished recordings, recordings are always written to the local path first
+type Storage interface {
+	// Upload moves the local file into storage
+	Upload(name, path string) error
+	Open(name string) (io.ReadCloser, error)
+	Remove(name string) error
+	Walk(fn func(name string, modTime time.Time) error) error
+}
+
+type Hook func(name string)
+
+var (
+	S Storage
+
+	AfterArchiveHooks []Hook
+	AfterRemoveHooks  []Hook
+)
+
+func init() {
+	cfg := conf.Cfg.Storage
+	os.MkdirAll(cfg.Path, 0755)
+	switch cfg.Type {
+	case TYPE_S3:
+		S = NewS3(cfg.S3)
+	default:
+		S = NewLocal(cfg.Path)
+	}
+}
+
+// Path returns the local path of recording
+func Path(name string) string {
+	return filepath.Join(conf.Cfg.Storage.Path, name)
+}
+
+// Archive moves the finished recording from local path into storage
+func Archive(name string) (err error) {
+	if conf.Cfg.Storage.Compress {
+		if err = compressFile(Path(name)); err != nil {
+			logger.L().Warn("compress recording failed", zap.String("name", name), zap.Error(err))
+		}
+	}
+	if err = S.Upload(name, Path(name)); err != nil {
+		logger.L().Error("archive recording failed", zap.String("name", name), zap.Error(err))
+		return
+	}
+	for _, h := range AfterArchiveHooks {
+		h(name)
+	}
+	return
+}
+
+// Open prefers the local file since the recording may not be archived yet, compressed recordings are decompressed transparently
+func Open(name string) (io.ReadCloser, error) {
+	if f, err := os.Open(Path(name)); err == nil {
+		return Decompress(f)
+	}
+	r, err := S.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return Decompress(r)
+}
+
+// Expire removes recordings modified before t
+func Expire(before time.Time) (n int, err error) {
+	var names []string
+	err = S.Walk(func(name string, modTime time.Time) error {
+		if modTime.Before(before) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	for _, name := range names {
+		if e := S.Remove(name); e != nil {
+			err = errors.Join(err, e)
+			continue
+		}
+		n++
+		for _, h := range AfterRemoveHooks {
+			h(name)
+		}
+	}
+	return
+}
@@ -0,0 +1,179 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/eventbus"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	NETWORK_UDP = "udp"
+	NETWORK_TCP = "tcp"
+	NETWORK_TLS = "tls"
+
+	SEVERITY_WARNING = 4
+	SEVERITY_NOTICE  = 5
+	SEVERITY_INFO    = 6
+
+	appName     = "oneterm"
+	dialTimeout = time.Second * 5
+	// sdId is of the example enterprise number of rfc 5424, collectors take it as a private structured data
+	sdId = "oneterm@32473"
+	// timeLayout is rfc 3339 with at most 6 digits of fractions required by rfc 5424
+	timeLayout = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var (
+	severities = map[string]int{
+		eventbus.TYPE_SESSION_ADMIN_CLOSE: SEVERITY_NOTICE,
+		eventbus.TYPE_COMMAND_BLOCKED:     SEVERITY_WARNING,
+		eventbus.TYPE_CONNECT_FAILED:      SEVERITY_WARNING,
+	}
+	// params are fields of data of events put into structured data in order, others are in the message only
+	params = []string{"session_id", "uid", "user_name", "asset_info", "account_info", "client_ip", "protocol", "cmd", "rule", "closer"}
+
+	msgs        chan []byte
+	dropped     atomic.Int64
+	pid         = os.Getpid()
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+func init() {
+	cfg := conf.Cfg.Syslog
+	if cfg.Addr == "" {
+		return
+	}
+	msgs = make(chan []byte, cfg.BufferSize)
+	eventbus.Listen(func(e *eventbus.Event) {
+		if !lo.Contains(cfg.Events, e.Type) {
+			return
+		}
+		select {
+		case msgs <- Format(e, cfg.Facility):
+		default:
+			dropped.Add(1)
+		}
+	})
+}
+
+// Format renders the event as a syslog message, the data of the event is the message in json and fields of it
+// are structured data as well so that collectors could index them without parsing json
+//
+//	https://datatracker.ietf.org/doc/html/rfc5424#section-6
+func Format(e *eventbus.Event, facility int) []byte {
+	severity, ok := severities[e.Type]
+	if !ok {
+		severity = SEVERITY_INFO
+	}
+	data, _ := json.Marshal(e.Data)
+	fields := map[string]any{}
+	// numbers are kept as they are, e.g. ids are not turned into floats
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.Decode(&fields)
+	// sessions of admin close are nested
+	if s, ok := fields["session"].(map[string]any); ok {
+		fields = lo.Assign(s, fields)
+	}
+	sd := &strings.Builder{}
+	fmt.Fprintf(sd, `[%s id="%s"`, sdId, e.Id)
+	for _, k := range params {
+		if v, ok := fields[k]; ok && v != "" {
+			fmt.Fprintf(sd, ` %s="%s"`, k, escape(fmt.Sprint(v)))
+		}
+	}
+	sd.WriteByte(']')
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		facility*8+severity, e.Time.Format(timeLayout), header(e.Source), appName, pid, header(e.Type), sd, data))
+}
+
+// Run sends messages to the collector until it is stopped, it only waits if syslog is not configured
+func Run() (err error) {
+	cfg := conf.Cfg.Syslog
+	if cfg.Addr == "" {
+		<-ctx.Done()
+		return
+	}
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	send := func(msg []byte) (err error) {
+		if conn == nil {
+			if conn, err = dial(cfg); err != nil {
+				return
+			}
+		}
+		if cfg.Network != NETWORK_UDP {
+			msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+		}
+		conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+		if _, err = conn.Write(msg); err != nil {
+			conn.Close()
+			conn = nil
+		}
+		return
+	}
+
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			if n := dropped.Swap(0); n > 0 {
+				logger.L().Warn("syslog buffer is full, messages are dropped", zap.Int64("count", n))
+			}
+		case msg := <-msgs:
+			// connections closed by collectors are found by writes, the message is sent once more on a new one
+			if send(msg) != nil {
+				if err := send(msg); err != nil {
+					logger.L().Warn("send syslog failed", zap.String("addr", cfg.Addr), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+func Stop() {
+	defer cancel()
+}
+
+func dial(cfg conf.SyslogConfig) (net.Conn, error) {
+	switch cfg.Network {
+	case NETWORK_UDP, NETWORK_TCP:
+		return net.DialTimeout(cfg.Network, cfg.Addr, dialTimeout)
+	case NETWORK_TLS:
+		return tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", cfg.Addr, &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify})
+	}
+	return nil, fmt.Errorf("unsupported syslog network %s", cfg.Network)
+}
+
+// header returns s as a field of the header, which is printable ascii without spaces or - if empty
+func header(s string) string {
+	s = strings.Map(func(r rune) rune { return lo.Ternary(r > ' ' && r < 127, r, -1) }, s)
+	return lo.Ternary(s == "", "-", s)
+}
+
+// escape escapes characters which end values of structured data
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,313 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// commands and options of telnet
+//
+//	https://www.rfc-editor.org/rfc/rfc854
+const (
+	cmdSE   = 240
+	cmdSB   = 250
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255
+
+	optEcho    = 1
+	optSGA     = 3
+	optTType   = 24
+	optNAWS    = 31
+	optComPort = 44
+
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+// commands of com port control option for serial console servers
+//
+//	https://www.rfc-editor.org/rfc/rfc2217
+const (
+	comSetBaudrate = 1
+	comSetDatasize = 2
+	comSetParity   = 3
+	comSetStopsize = 4
+)
+
+var (
+	comParities  = map[string]byte{"none": 1, "odd": 2, "even": 3, "mark": 4, "space": 5}
+	comStopsizes = map[float64]byte{1: 1, 2: 2, 1.5: 3}
+)
+
+// ComPort is the line setting of the serial port, zero values are left as server's
+type ComPort struct {
+	Baudrate int
+	Datasize int
+	Parity   string
+	Stopsize float64
+}
+
+const (
+	stateData = iota
+	stateIAC
+	stateOpt
+	stateSB
+	stateSBIAC
+)
+
+var (
+	reLogin    = regexp.MustCompile(`(?i)(login|username|user name)\s*:\s*$`)
+	rePassword = regexp.MustCompile(`(?i)password\s*:\s*$`)
+	reFailed   = regexp.MustCompile(`(?i)(login incorrect|authentication failed|access denied)`)
+)
+
+// Client is a telnet client for network devices, it only accepts echo and suppress-go-ahead from server
+// and offers terminal type and window size
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mtx    sync.Mutex
+	state  int
+	cmd    byte
+	sb     []byte
+	width  int
+	height int
+	naws   bool
+	com    *ComPort
+}
+
+func Dial(addr string, timeout time.Duration, width, height int) (c *Client, err error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return
+	}
+	c = &Client{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		width:  width,
+		height: height,
+	}
+	return
+}
+
+// Read returns data with commands of telnet stripped, negotiations are answered in place
+func (c *Client) Read(p []byte) (n int, err error) {
+	for n == 0 {
+		var b byte
+		if b, err = c.reader.ReadByte(); err != nil {
+			return
+		}
+		switch c.state {
+		case stateData:
+			if b == cmdIAC {
+				c.state = stateIAC
+				continue
+			}
+			p[n] = b
+			n++
+			for n < len(p) && c.reader.Buffered() > 0 {
+				if next, _ := c.reader.Peek(1); next[0] == cmdIAC {
+					break
+				}
+				p[n], _ = c.reader.ReadByte()
+				n++
+			}
+		case stateIAC:
+			switch b {
+			case cmdIAC:
+				p[n] = b
+				n++
+				c.state = stateData
+			case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+				c.cmd, c.state = b, stateOpt
+			case cmdSB:
+				c.sb, c.state = c.sb[:0], stateSB
+			default:
+				c.state = stateData
+			}
+		case stateOpt:
+			c.state = stateData
+			if err = c.negotiate(c.cmd, b); err != nil {
+				return
+			}
+		case stateSB:
+			if b == cmdIAC {
+				c.state = stateSBIAC
+				continue
+			}
+			c.sb = append(c.sb, b)
+		case stateSBIAC:
+			if b == cmdSE {
+				c.state = stateData
+				if err = c.subnegotiate(c.sb); err != nil {
+					return
+				}
+				continue
+			}
+			c.sb, c.state = append(c.sb, b), stateSB
+		}
+	}
+	return
+}
+
+func (c *Client) negotiate(cmd, opt byte) (err error) {
+	switch cmd {
+	case cmdDO:
+		switch opt {
+		case optTType:
+			return c.command(cmdWILL, opt)
+		case optNAWS:
+			if err = c.command(cmdWILL, opt); err != nil {
+				return
+			}
+			c.naws = true
+			return c.sendWindow()
+		case optComPort:
+			// it acknowledges WILL sent by SetComPort
+			if c.com != nil {
+				return
+			}
+			return c.command(cmdWONT, opt)
+		default:
+			return c.command(cmdWONT, opt)
+		}
+	case cmdWILL:
+		if opt == optEcho || opt == optSGA {
+			return c.command(cmdDO, opt)
+		}
+		return c.command(cmdDONT, opt)
+	}
+	return
+}
+
+func (c *Client) subnegotiate(sb []byte) error {
+	if len(sb) >= 2 && sb[0] == optTType && sb[1] == ttypeSend {
+		return c.raw(append(append([]byte{cmdIAC, cmdSB, optTType, ttypeIs}, "XTERM"...), cmdIAC, cmdSE))
+	}
+	return nil
+}
+
+func (c *Client) command(cmd, opt byte) error {
+	return c.raw([]byte{cmdIAC, cmd, opt})
+}
+
+func (c *Client) raw(p []byte) (err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_, err = c.conn.Write(p)
+	return
+}
+
+func (c *Client) sendWindow() error {
+	bs := make([]byte, 4)
+	binary.BigEndian.PutUint16(bs, uint16(c.width))
+	binary.BigEndian.PutUint16(bs[2:], uint16(c.height))
+	// 255 in size must be doubled as well
+	bs = bytes.ReplaceAll(bs, []byte{cmdIAC}, []byte{cmdIAC, cmdIAC})
+	return c.raw(append(append([]byte{cmdIAC, cmdSB, optNAWS}, bs...), cmdIAC, cmdSE))
+}
+
+// Write escapes IAC and sends CR as CR NUL as the network virtual terminal requires
+func (c *Client) Write(p []byte) (n int, err error) {
+	buf := make([]byte, 0, len(p)+8)
+	for i, b := range p {
+		buf = append(buf, b)
+		switch {
+		case b == cmdIAC:
+			buf = append(buf, cmdIAC)
+		case b == '\r' && (i+1 >= len(p) || p[i+1] != '\n'):
+			buf = append(buf, 0)
+		}
+	}
+	if err = c.raw(buf); err != nil {
+		return
+	}
+	return len(p), nil
+}
+
+// SetComPort offers com port control and sets the line, replies of server are ignored
+// since console servers apply what they support
+func (c *Client) SetComPort(com *ComPort) (err error) {
+	c.com = com
+	if err = c.command(cmdWILL, optComPort); err != nil {
+		return
+	}
+	subs := make([][]byte, 0)
+	if com.Baudrate > 0 {
+		subs = append(subs, binary.BigEndian.AppendUint32([]byte{comSetBaudrate}, uint32(com.Baudrate)))
+	}
+	if com.Datasize > 0 {
+		subs = append(subs, []byte{comSetDatasize, byte(com.Datasize)})
+	}
+	if v, ok := comParities[com.Parity]; ok {
+		subs = append(subs, []byte{comSetParity, v})
+	}
+	if v, ok := comStopsizes[com.Stopsize]; ok {
+		subs = append(subs, []byte{comSetStopsize, v})
+	}
+	for _, sub := range subs {
+		sub = bytes.ReplaceAll(sub, []byte{cmdIAC}, []byte{cmdIAC, cmdIAC})
+		if err = c.raw(append(append([]byte{cmdIAC, cmdSB, optComPort}, sub...), cmdIAC, cmdSE)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WindowChange is sent only if the server asked for window size
+func (c *Client) WindowChange(width, height int) error {
+	c.width, c.height = width, height
+	if !c.naws {
+		return nil
+	}
+	return c.sendWindow()
+}
+
+// Login answers login and password prompts, output read meanwhile is returned so that it could be shown to user.
+// Devices without any prompt before timeout are regarded as not requiring login
+func (c *Client) Login(user, password string, timeout time.Duration) (out []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	all, cur := &bytes.Buffer{}, &bytes.Buffer{}
+	p := make([]byte, 1024)
+	userSent := false
+	for {
+		n, e := c.Read(p)
+		all.Write(p[:n])
+		cur.Write(p[:n])
+		if ne, ok := e.(net.Error); ok && ne.Timeout() && !userSent {
+			return all.Bytes(), nil
+		}
+		if e != nil {
+			return all.Bytes(), fmt.Errorf("telnet login failed: %w", e)
+		}
+		tail := bytes.TrimRight(cur.Bytes(), "\x00")
+		switch {
+		case reFailed.Match(tail):
+			return all.Bytes(), fmt.Errorf("telnet login failed: %s", bytes.TrimSpace(tail))
+		case !userSent && reLogin.Match(tail):
+			if _, err = c.Write([]byte(user + "\r")); err != nil {
+				return
+			}
+			userSent = true
+			cur.Reset()
+		case rePassword.Match(tail):
+			_, err = c.Write([]byte(password + "\r"))
+			return all.Bytes(), err
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,172 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// conn is a server sending chunks one per read, so that commands are split across reads as they are on networks
+type conn struct {
+	net.Conn
+	chunks [][]byte
+	out    bytes.Buffer
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	if c.chunks[0] = c.chunks[0][n:]; len(c.chunks[0]) == 0 {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func newClient(width, height int, chunks ...string) (*Client, *conn) {
+	cn := &conn{}
+	for _, s := range chunks {
+		cn.chunks = append(cn.chunks, []byte(s))
+	}
+	return &Client{conn: cn, reader: bufio.NewReader(cn), width: width, height: height}, cn
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string
+		want      string
+		wantReply string
+	}{
+		{
+			name:   "plain",
+			chunks: []string{"hello", " world"},
+			want:   "hello world",
+		},
+		{
+			name:   "doubled iac in data",
+			chunks: []string{"a\xff\xffb\xff\xff"},
+			want:   "a\xffb\xff",
+		},
+		{
+			name:   "doubled iac split across reads",
+			chunks: []string{"a\xff", "\xffb"},
+			want:   "a\xffb",
+		},
+		{
+			name:      "ttype",
+			chunks:    []string{"\xff\xfd\x18\xff\xfa\x18\x01\xff\xf0login: "},
+			want:      "login: ",
+			wantReply: "\xff\xfb\x18\xff\xfa\x18\x00XTERM\xff\xf0",
+		},
+		{
+			name:      "ttype split across reads",
+			chunks:    []string{"\xff", "\xfa", "\x18\x01", "\xff", "\xf0login: "},
+			want:      "login: ",
+			wantReply: "\xff\xfa\x18\x00XTERM\xff\xf0",
+		},
+		{
+			name:   "sub-negotiation of unknown option with doubled iac",
+			chunks: []string{"\xff\xfa\x05\xff", "\xff\x01\xff\xf0ok"},
+			want:   "ok",
+		},
+		{
+			name:      "naws",
+			chunks:    []string{"\xff\xfd\x1fok"},
+			want:      "ok",
+			wantReply: "\xff\xfb\x1f\xff\xfa\x1f\x00\x50\x00\x18\xff\xf0",
+		},
+		{
+			name:      "echo and suppress go ahead",
+			chunks:    []string{"\xff\xfb\x01\xff\xfb", "\x03ok"},
+			want:      "ok",
+			wantReply: "\xff\xfd\x01\xff\xfd\x03",
+		},
+		{
+			name:      "unknown options refused",
+			chunks:    []string{"\xff\xfd\x27\xff\xfb\x05\xff\xfd\x2cok"},
+			want:      "ok",
+			wantReply: "\xff\xfc\x27\xff\xfe\x05\xff\xfc\x2c",
+		},
+		{
+			name:   "refusals not answered",
+			chunks: []string{"\xff\xfe\x18\xff\xfc\x01ok"},
+			want:   "ok",
+		},
+		{
+			name:   "other commands skipped",
+			chunks: []string{"a\xff\xf1b"},
+			want:   "ab",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, cn := newClient(80, 24, tt.chunks...)
+			got, p := &bytes.Buffer{}, make([]byte, 4)
+			for {
+				n, err := c.Read(p)
+				got.Write(p[:n])
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+			if cn.out.String() != tt.wantReply {
+				t.Errorf("reply = %q, want %q", cn.out.String(), tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestWindowChange(t *testing.T) {
+	c, cn := newClient(80, 24)
+	if err := c.WindowChange(100, 30); err != nil || cn.out.Len() != 0 {
+		t.Errorf("WindowChange() before naws = %q, %v, want nothing sent", cn.out.String(), err)
+	}
+
+	c.naws = true
+	if err := c.WindowChange(255, 511); err != nil {
+		t.Fatalf("WindowChange() error = %v", err)
+	}
+	if want := "\xff\xfa\x1f\x00\xff\xff\x01\xff\xff\xff\xf0"; cn.out.String() != want {
+		t.Errorf("WindowChange() = %q, want %q", cn.out.String(), want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "plain", data: "ls\n", want: "ls\n"},
+		{name: "iac doubled", data: "a\xffb", want: "a\xff\xffb"},
+		{name: "cr", data: "ls\r", want: "ls\r\x00"},
+		{name: "crlf", data: "ls\r\n", want: "ls\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, cn := newClient(80, 24)
+			n, err := c.Write([]byte(tt.data))
+			if err != nil || n != len(tt.data) {
+				t.Errorf("Write() = %v, %v, want %v", n, err, len(tt.data))
+			}
+			if cn.out.String() != tt.want {
+				t.Errorf("Write() sent %q, want %q", cn.out.String(), tt.want)
+			}
+		})
+	}
+}
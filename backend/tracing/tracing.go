@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/veops/oneterm/conf"
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	// KEY of the span in contexts, it is a string so that gin contexts set by it are found as well
+	KEY = "tracing.span"
+
+	instrumentation = "github.com/veops/oneterm"
+)
+
+var (
+	ctx, cancel = context.WithCancel(context.Background())
+	provider    *sdktrace.TracerProvider
+	tracer      oteltrace.Tracer
+	propagator  = propagation.TraceContext{}
+)
+
+func init() {
+	cfg := conf.Cfg.Tracing
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	}
+	// spans still have ids and are logged without a collector, so that sessions and access logs keep trace ids
+	if cfg.Endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint), otlptracehttp.WithHeaders(cfg.Headers))
+		if err != nil {
+			logger.L().Error("create otlp exporter failed, spans are only logged", zap.Error(err))
+		} else {
+			opts = append(opts, sdktrace.WithBatcher(exporter))
+		}
+	}
+	provider = sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	tracer = provider.Tracer(instrumentation)
+}
+
+// Span is a timed phase of a trace of OpenTelemetry, so that traces of callers continue through oneterm and slow
+// connections are told by which phase takes the time. Spans are exported to the otlp collector of the config, they
+// go to logs as well and are summarized when the root ends
+type Span struct {
+	TraceId  string
+	SpanId   string
+	ParentId string
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+	span     oteltrace.Span
+	attrs    []attribute.KeyValue
+	trace    *trace
+	once     sync.Once
+}
+
+type trace struct {
+	mtx   sync.Mutex
+	spans []*Span
+}
+
+// StartRemote starts the root span of a trace continuing the W3C traceparent of the caller if it is valid
+func StartRemote(traceparent, name string) *Span {
+	parent := propagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+	s := start(parent, name, &trace{})
+	if sc := oteltrace.SpanContextFromContext(parent); sc.IsValid() {
+		s.ParentId = sc.SpanID().String()
+	}
+	return s
+}
+
+// Start starts a child of the span in ctx, the returned context carries the child. Nothing is traced if there is no
+// span in ctx, the nil span returned could be used as well
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := start(oteltrace.ContextWithSpan(context.Background(), parent.span), name, parent.trace)
+	s.ParentId = parent.SpanId
+	return context.WithValue(ctx, KEY, s), s
+}
+
+func start(parent context.Context, name string, t *trace) *Span {
+	_, span := tracer.Start(parent, name)
+	sc := span.SpanContext()
+	s := &Span{TraceId: sc.TraceID().String(), SpanId: sc.SpanID().String(), Name: name, Start: time.Now(), span: span, trace: t}
+	t.add(s)
+	return s
+}
+
+// FromContext returns the span in ctx, nil if there is none
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(KEY).(*Span)
+	return s
+}
+
+// Set adds attributes to the span, they are logged with it as well
+func (s *Span) Set(attrs ...attribute.KeyValue) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End ends the span with the error of the phase, ends after the first are ignored. The root logs the summary of
+// all spans of the trace, it is a warning if the root is slower than the threshold of the config
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.trace.mtx.Lock()
+		s.Duration, s.Err = time.Since(s.Start), err
+		s.trace.mtx.Unlock()
+		if err != nil {
+			s.span.RecordError(err)
+			s.span.SetStatus(codes.Error, err.Error())
+		}
+		s.span.End()
+
+		fields := []zap.Field{
+			zap.String("traceId", s.TraceId), zap.String("spanId", s.SpanId), zap.String("parentId", s.ParentId),
+			zap.String("span", s.Name), zap.Duration("duration", s.Duration), zap.Error(err),
+		}
+		for _, kv := range s.attrs {
+			fields = append(fields, zap.String(string(kv.Key), kv.Value.Emit()))
+		}
+		logger.L().Debug("span ended", fields...)
+		if s.trace.root() != s {
+			return
+		}
+		summary := zap.Strings("spans", s.trace.summary())
+		if slow := conf.Cfg.Tracing.Slow; slow > 0 && s.Duration >= time.Duration(slow)*time.Millisecond {
+			logger.L().Warn("slow trace", append(fields, summary)...)
+		} else {
+			logger.L().Info("trace", append(fields, summary)...)
+		}
+	})
+}
+
+// Traceparent is the W3C header of the span for calls out of it
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(oteltrace.ContextWithSpan(context.Background(), s.span), carrier)
+	return carrier.Get("traceparent")
+}
+
+func (t *trace) add(s *Span) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.spans = append(t.spans, s)
+}
+
+func (t *trace) root() *Span {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.spans[0]
+}
+
+// summary is names and durations of spans in the order they start, offsets are since the root starts
+func (t *trace) summary() []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	res := make([]string, 0, len(t.spans))
+	for _, s := range t.spans {
+		d := "running"
+		if s.Duration > 0 {
+			d = s.Duration.String()
+		}
+		res = append(res, fmt.Sprintf("%s +%s %s", s.Name, s.Start.Sub(t.spans[0].Start), d))
+	}
+	return res
+}
+
+// Run waits until it is stopped, then flushes spans not exported yet
+func Run() (err error) {
+	<-ctx.Done()
+	sctx, scancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer scancel()
+	if e := provider.Shutdown(sctx); e != nil {
+		logger.L().Warn("flush spans failed", zap.Error(e))
+	}
+	return
+}
+
+func Stop() {
+	defer cancel()
+}
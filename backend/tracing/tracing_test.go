@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestStartRemote(t *testing.T) {
+	tests := []struct {
+		name         string
+		traceparent  string
+		wantTraceId  string
+		wantParentId string
+	}{
+		{
+			name:         "continued",
+			traceparent:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantTraceId:  "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantParentId: "00f067aa0ba902b7",
+		},
+		{
+			name:        "empty",
+			traceparent: "",
+		},
+		{
+			name:        "invalid trace id of zeros",
+			traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "malformed",
+			traceparent: "00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := StartRemote(tt.traceparent, "connect")
+			defer s.End(nil)
+			if tt.wantTraceId != "" && s.TraceId != tt.wantTraceId {
+				t.Errorf("StartRemote() trace id = %v, want %v", s.TraceId, tt.wantTraceId)
+			}
+			if tt.wantTraceId == "" && (len(s.TraceId) != 32 || s.TraceId == "00000000000000000000000000000000") {
+				t.Errorf("StartRemote() trace id = %v, want a new one", s.TraceId)
+			}
+			if s.ParentId != tt.wantParentId {
+				t.Errorf("StartRemote() parent id = %v, want %v", s.ParentId, tt.wantParentId)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer func(old oteltrace.Tracer) { tracer = old }(tracer)
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(instrumentation)
+
+	if ctx, s := Start(context.Background(), "orphan"); s != nil || FromContext(ctx) != nil {
+		t.Errorf("Start() without a span = %v, want nil", s)
+	}
+
+	root := StartRemote("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "connect")
+	ctx := context.WithValue(context.Background(), KEY, root)
+	ctx, child := Start(ctx, "ssh.dial")
+	if FromContext(ctx) != child {
+		t.Errorf("FromContext() = %v, want the child", FromContext(ctx))
+	}
+	if child.TraceId != root.TraceId || child.ParentId != root.SpanId {
+		t.Errorf("Start() trace id, parent id = %v, %v, want %v, %v", child.TraceId, child.ParentId, root.TraceId, root.SpanId)
+	}
+	if want := "00-" + child.TraceId + "-" + child.SpanId + "-01"; child.Traceparent() != want {
+		t.Errorf("Traceparent() = %v, want %v", child.Traceparent(), want)
+	}
+	child.End(errors.New("refused"))
+	child.End(nil)
+	root.End(nil)
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("spans ended = %d, want 2", len(ended))
+	}
+	if got := ended[0]; got.Name() != "ssh.dial" || got.Status().Code != codes.Error || got.Parent().SpanID().String() != root.SpanId {
+		t.Errorf("child span = %v %v parent %v, want ssh.dial with an error of parent %v", got.Name(), got.Status(), got.Parent().SpanID(), root.SpanId)
+	}
+	if got := ended[1]; got.Name() != "connect" || got.Status().Code != codes.Unset || !got.Parent().IsRemote() {
+		t.Errorf("root span = %v %v, want connect of a remote parent", got.Name(), got.Status())
+	}
+}
@@ -0,0 +1,243 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+const (
+	QUOTA_USERS    = "users"
+	QUOTA_SESSIONS = "sessions"
+	QUOTA_ASSETS   = "assets"
+
+	STATE_OK       = "ok"
+	STATE_WARN     = "warn"
+	STATE_EXCEEDED = "exceeded"
+
+	MONTH_LAYOUT = "2006-01"
+
+	// warnInterval is how often reaching the warn percent of a quota is logged
+	warnInterval = time.Hour
+)
+
+var (
+	warned sync.Map
+)
+
+// Quota is the usage of a licensed limit now, limit is 0 if it is unlimited
+type Quota struct {
+	Name  string `json:"name"`
+	Used  int64  `json:"used"`
+	Limit int    `json:"limit"`
+	State string `json:"state"`
+}
+
+// LimitError is usage refused beyond a licensed limit
+type LimitError struct {
+	Quota string
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("licensed limit of %s %d is reached", e.Quota, e.Limit)
+}
+
+// Month returns the start and end of the month, e.g. 2006-01, empty is the current one
+func Month(month string) (start, end time.Time, err error) {
+	if month == "" {
+		month = time.Now().Format(MONTH_LAYOUT)
+	}
+	if start, err = time.ParseInLocation(MONTH_LAYOUT, month, time.Local); err != nil {
+		return
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Quotas returns usage of all licensed limits now, unlimited ones are there as well for metering
+func Quotas() (qs []*Quota, err error) {
+	cfg := conf.Cfg.License
+	users, err := activeUsers(time.Now())
+	if err != nil {
+		return
+	}
+	sessions, err := onlineSessions()
+	if err != nil {
+		return
+	}
+	assets, err := managedAssets()
+	if err != nil {
+		return
+	}
+	return []*Quota{
+		quota(QUOTA_USERS, int64(len(users)), cfg.MaxUsers),
+		quota(QUOTA_SESSIONS, sessions, cfg.MaxSessions),
+		quota(QUOTA_ASSETS, assets, cfg.MaxAssets),
+	}, nil
+}
+
+// CheckSession tells whether the user could open one more session, users already active this month are not counted
+// again. Failures of counting let it go, metering never blocks connecting by itself
+func CheckSession(uid int) error {
+	cfg := conf.Cfg.License
+	if cfg.MaxUsers > 0 {
+		if users, err := activeUsers(time.Now()); err != nil {
+			logger.L().Warn("count active users failed", zap.Error(err))
+		} else if !lo.Contains(users, uid) {
+			if err := check(QUOTA_USERS, int64(len(users)), cfg.MaxUsers); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.MaxSessions > 0 {
+		if n, err := onlineSessions(); err != nil {
+			logger.L().Warn("count online sessions failed", zap.Error(err))
+		} else if err := check(QUOTA_SESSIONS, n, cfg.MaxSessions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckAsset tells whether n more assets could be managed
+func CheckAsset(n int) error {
+	cfg := conf.Cfg.License
+	if cfg.MaxAssets <= 0 {
+		return nil
+	}
+	used, err := managedAssets()
+	if err != nil {
+		logger.L().Warn("count managed assets failed", zap.Error(err))
+		return nil
+	}
+	return check(QUOTA_ASSETS, used+int64(n)-1, cfg.MaxAssets)
+}
+
+// Sample records usage now into the row of the month, peaks only grow
+func Sample() {
+	now := time.Now()
+	users, err := activeUsers(now)
+	if err != nil {
+		logger.L().Warn("sample usage failed", zap.Error(err))
+		return
+	}
+	sessions, err := onlineSessions()
+	if err != nil {
+		logger.L().Warn("sample usage failed", zap.Error(err))
+		return
+	}
+	assets, err := managedAssets()
+	if err != nil {
+		logger.L().Warn("sample usage failed", zap.Error(err))
+		return
+	}
+	u := &model.Usage{
+		Month:          now.Format(MONTH_LAYOUT),
+		ActiveUsers:    int64(len(users)),
+		PeakSessions:   sessions,
+		PeakSessionsAt: &now,
+		PeakAssets:     assets,
+	}
+	// the time of the peak is set before the peak since assignments of mysql see values assigned before them
+	if err = mysql.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "month"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "active_users"}, Value: gorm.Expr("GREATEST(active_users, ?)", u.ActiveUsers)},
+			{Column: clause.Column{Name: "peak_sessions_at"}, Value: gorm.Expr("IF(? > peak_sessions, ?, peak_sessions_at)", sessions, now)},
+			{Column: clause.Column{Name: "peak_sessions"}, Value: gorm.Expr("GREATEST(peak_sessions, ?)", sessions)},
+			{Column: clause.Column{Name: "peak_assets"}, Value: gorm.Expr("GREATEST(peak_assets, ?)", assets)},
+			{Column: clause.Column{Name: "updated_at"}, Value: now},
+		},
+	}).Create(u).Error; err != nil {
+		logger.L().Warn("save usage failed", zap.String("month", u.Month), zap.Error(err))
+	}
+}
+
+// Users returns usage of users of sessions opened in the month, durations of sessions online count until now
+func Users(start, end time.Time) (res []*model.UserUsage, err error) {
+	sessions := make([]*model.Session, 0)
+	if err = mysql.AuditDB.Model(model.DefaultSession).
+		Select("uid", "user_name", "asset_id", "created_at", "closed_at").
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Find(&sessions).Error; err != nil {
+		return
+	}
+	now := time.Now()
+	byUid := map[int]*model.UserUsage{}
+	assets := map[int]map[int]bool{}
+	for _, s := range sessions {
+		u, ok := byUid[s.Uid]
+		if !ok {
+			u = &model.UserUsage{Uid: s.Uid, UserName: s.UserName}
+			byUid[s.Uid], assets[s.Uid] = u, map[int]bool{}
+		}
+		u.Sessions++
+		assets[s.Uid][s.AssetId] = true
+		closed := lo.FromPtrOr(s.ClosedAt, now)
+		u.Duration += int64(max(closed.Sub(s.CreatedAt), 0) / time.Second)
+	}
+	for uid, u := range byUid {
+		u.Assets = int64(len(assets[uid]))
+		res = append(res, u)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Uid < res[j].Uid })
+	return
+}
+
+// check refuses usage beyond the limit and warns reaching the warn percent of it, used is without the one asked for
+func check(name string, used int64, limit int) error {
+	if used >= int64(limit) {
+		logger.L().Warn("licensed limit is reached", zap.String("quota", name), zap.Int("limit", limit))
+		return &LimitError{Quota: name, Limit: limit}
+	}
+	if quota(name, used+1, limit).State != STATE_OK {
+		if last, ok := warned.Load(name); !ok || time.Since(last.(time.Time)) > warnInterval {
+			warned.Store(name, time.Now())
+			logger.L().Warn("licensed limit is nearly reached", zap.String("quota", name), zap.Int64("used", used+1), zap.Int("limit", limit))
+		}
+	}
+	return nil
+}
+
+func quota(name string, used int64, limit int) *Quota {
+	q := &Quota{Name: name, Used: used, Limit: limit, State: STATE_OK}
+	switch {
+	case limit <= 0:
+	case used > int64(limit):
+		q.State = STATE_EXCEEDED
+	case used*100 >= int64(limit)*int64(conf.Cfg.License.Warn):
+		q.State = STATE_WARN
+	}
+	return q
+}
+
+// activeUsers are uids having sessions opened in the month of t
+func activeUsers(t time.Time) (uids []int, err error) {
+	start, end, _ := Month(t.Format(MONTH_LAYOUT))
+	err = mysql.AuditDB.Model(model.DefaultSession).
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Distinct().Pluck("uid", &uids).Error
+	return
+}
+
+// onlineSessions of all instances, they share the audit db
+func onlineSessions() (n int64, err error) {
+	err = mysql.AuditDB.Model(model.DefaultSession).Where("status = ?", model.SESSIONSTATUS_ONLINE).Count(&n).Error
+	return
+}
+
+func managedAssets() (n int64, err error) {
+	err = mysql.DB.Model(model.DefaultAsset).Count(&n).Error
+	return
+}
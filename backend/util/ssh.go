@@ -1,12 +1,14 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/spf13/cast"
+	"github.com/veops/oneterm/credential"
 	mysql "github.com/veops/oneterm/db"
 	ggateway "github.com/veops/oneterm/gateway"
 	"github.com/veops/oneterm/model"
@@ -23,6 +25,13 @@ func GetAAG(assetId int, accountId int) (asset *model.Asset, account *model.Acco
 	account.Password = DecryptAES(account.Password)
 	account.Pk = DecryptAES(account.Pk)
 	account.Phrase = DecryptAES(account.Phrase)
+	if err = credential.Resolve(context.Background(), asset, account); err != nil {
+		return
+	}
+	if asset.GatewayGroupId != 0 {
+		gateway, err = PickGateway(asset)
+		return
+	}
 	if asset.GatewayId != 0 {
 		if err = mysql.DB.Model(gateway).Where("id = ?", asset.GatewayId).First(gateway).Error; err != nil {
 			return
@@ -35,6 +44,31 @@ func GetAAG(assetId int, accountId int) (asset *model.Asset, account *model.Acco
 	return
 }
 
+// PickGateway picks a gateway of the group of the asset with others of the group as its fallbacks, GatewayId of the asset is set to the picked one
+func PickGateway(asset *model.Asset) (gateway *model.Gateway, err error) {
+	group := &model.GatewayGroup{}
+	if err = mysql.DB.Model(group).Where("id = ?", asset.GatewayGroupId).First(group).Error; err != nil {
+		return
+	}
+	gateways := make([]*model.Gateway, 0)
+	if err = mysql.DB.Model(model.DefaultGateway).Where("id IN ?", []int(group.GatewayIds)).Find(&gateways).Error; err != nil {
+		return
+	}
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("gateway group %s has no gateway", group.Name)
+	}
+	for _, g := range gateways {
+		g.Password = DecryptAES(g.Password)
+		g.Pk = DecryptAES(g.Pk)
+		g.Phrase = DecryptAES(g.Phrase)
+	}
+	gateways = ggateway.GetGatewayManager().Pick(group, gateways)
+	gateway = gateways[0]
+	gateway.Fallbacks = gateways[1:]
+	asset.GatewayId = gateway.Id
+	return
+}
+
 func GetAuth(account *model.Account) (ssh.AuthMethod, error) {
 	switch account.AccountType {
 	case model.AUTHMETHOD_PASSWORD:
@@ -53,6 +87,18 @@ func GetAuth(account *model.Account) (ssh.AuthMethod, error) {
 			}
 			return ssh.PublicKeys(pk), nil
 		}
+	case model.AUTHMETHOD_SSHCERT:
+		signer, err := SignSshCert(account.Account)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.PublicKeys(signer), nil
+	case model.AUTHMETHOD_SSHKEYCERT:
+		signer, err := SshKeyCertSigner(account)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.PublicKeys(signer), nil
 	default:
 		return nil, fmt.Errorf("invalid authmethod %d", account.AccountType)
 	}
@@ -0,0 +1,239 @@
+package util
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+)
+
+// GenerateSshCa generates an ed25519 key pair for the ca, the private key is saved encrypted
+func GenerateSshCa(ca *model.SshCa) (err error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "oneterm-ca-"+ca.Name)
+	if err != nil {
+		return
+	}
+	ca.PublicKey = fmt.Sprintf("%s oneterm-ca-%s", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), ca.Name)
+	ca.Fingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+	ca.PrivateKey = EncryptAES(string(pem.EncodeToMemory(block)))
+	return
+}
+
+// SignSshCert signs a short-lived user certificate of principal for an ephemeral key by the active ca,
+// it is valid a minute earlier in case clocks of targets are behind
+func SignSshCert(principal string) (signer ssh.Signer, err error) {
+	caSigner, err := activeSshCa()
+	if err != nil {
+		return
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	keySigner, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	cert, err := signCert(caSigner, keySigner.PublicKey(), principal, now.Add(time.Minute*time.Duration(conf.Cfg.SshCa.CertTtl)))
+	if err != nil {
+		return
+	}
+	return ssh.NewCertSigner(cert, keySigner)
+}
+
+// IssueSshKeyCert signs a certificate of the account for its own key by the active ca, cert and its expiry are
+// set on the account. Secrets of the account must be decrypted
+func IssueSshKeyCert(account *model.Account) (err error) {
+	caSigner, err := activeSshCa()
+	if err != nil {
+		return
+	}
+	keySigner, err := parseAccountKey(account)
+	if err != nil {
+		return
+	}
+	cert, err := signCert(caSigner, keySigner.PublicKey(), account.Account, time.Now().AddDate(0, 0, conf.Cfg.SshCa.KeyCertTtl))
+	if err != nil {
+		return
+	}
+	expiredAt := time.Unix(int64(cert.ValidBefore), 0)
+	account.Cert, account.CertExpiredAt = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))), &expiredAt
+	return
+}
+
+// ParseSshKeyCert parses the key and certificate of the account, the certificate must be a user one of the key
+func ParseSshKeyCert(account *model.Account) (signer ssh.Signer, cert *ssh.Certificate, err error) {
+	keySigner, err := parseAccountKey(account)
+	if err != nil {
+		return
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(account.Cert))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, nil, fmt.Errorf("invalid certificate: not a user certificate")
+	}
+	if !bytes.Equal(cert.Key.Marshal(), keySigner.PublicKey().Marshal()) {
+		return nil, nil, fmt.Errorf("invalid certificate: not of the private key")
+	}
+	if signer, err = ssh.NewCertSigner(cert, keySigner); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// SshKeyCertSigner returns the signer of the account by its certificate, the certificate is re-issued and saved
+// if it is invalid or expires within a minute so that logins do not fail before the renewal runs
+func SshKeyCertSigner(account *model.Account) (signer ssh.Signer, err error) {
+	signer, cert, err := ParseSshKeyCert(account)
+	if err == nil && sshCertValid(cert, time.Now().Add(time.Minute)) {
+		return
+	}
+	if err = RenewSshKeyCert(account); err != nil {
+		return nil, fmt.Errorf("certificate of account %s is invalid and re-issuing failed: %w", account.Name, err)
+	}
+	signer, _, err = ParseSshKeyCert(account)
+	return
+}
+
+// RenewSshKeyCert re-issues the certificate of the account and saves it, secrets of the account must be decrypted
+func RenewSshKeyCert(account *model.Account) (err error) {
+	if err = IssueSshKeyCert(account); err != nil {
+		return
+	}
+	return mysql.DB.Model(account).Updates(map[string]any{"cert": account.Cert, "cert_expired_at": account.CertExpiredAt}).Error
+}
+
+// RenewSshKeyCertsDue re-issues certificates of accounts expiring within the renewal days
+func RenewSshKeyCertsDue() {
+	accounts := make([]*model.Account, 0)
+	if err := mysql.DB.Model(model.DefaultAccount).
+		Where("account_type = ? AND cred_provider = ''", model.AUTHMETHOD_SSHKEYCERT).
+		Where("cert_expired_at IS NULL OR cert_expired_at <= ?", time.Now().AddDate(0, 0, conf.Cfg.SshCa.RenewBefore)).
+		Find(&accounts).Error; err != nil {
+		logger.L().Warn("get accounts to renew certificates failed", zap.Error(err))
+		return
+	}
+	for _, account := range accounts {
+		account.Pk = DecryptAES(account.Pk)
+		account.Phrase = DecryptAES(account.Phrase)
+		if err := RenewSshKeyCert(account); err != nil {
+			logger.L().Warn("renew certificate of account failed", zap.Int("accountId", account.Id), zap.Error(err))
+			continue
+		}
+		logger.L().Info("certificate of account renewed", zap.Int("accountId", account.Id), zap.Timep("expiredAt", account.CertExpiredAt))
+	}
+}
+
+func activeSshCa() (signer ssh.Signer, err error) {
+	ca := &model.SshCa{}
+	if err = mysql.DB.Model(ca).Where("active = ?", true).Order("id DESC").First(ca).Error; err != nil {
+		return nil, fmt.Errorf("no active ssh ca: %w", err)
+	}
+	return ssh.ParsePrivateKey([]byte(DecryptAES(ca.PrivateKey)))
+}
+
+func parseAccountKey(account *model.Account) (ssh.Signer, error) {
+	if account.Phrase == "" {
+		return ssh.ParsePrivateKey([]byte(account.Pk))
+	}
+	return ssh.ParsePrivateKeyWithPassphrase([]byte(account.Pk), []byte(account.Phrase))
+}
+
+// signCert signs a user certificate of principal for the key, it is valid a minute earlier in case clocks of targets are behind
+func signCert(caSigner ssh.Signer, key ssh.PublicKey, principal string, before time.Time) (cert *ssh.Certificate, err error) {
+	now := time.Now()
+	cert = &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("oneterm-%s-%d", principal, now.Unix()),
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(before.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-X11-forwarding":   "",
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+			},
+		},
+	}
+	err = cert.SignCert(rand.Reader, caSigner)
+	return
+}
+
+// sshCertValid tells whether the certificate is valid until t
+func sshCertValid(cert *ssh.Certificate, t time.Time) bool {
+	unix := uint64(t.Unix())
+	return cert.ValidAfter <= uint64(time.Now().Unix()) && (cert.ValidBefore == ssh.CertTimeInfinity || unix < cert.ValidBefore)
+}
+
+// TrustedSshCaKeys returns public keys of all cas in the format of TrustedUserCAKeys of sshd
+func TrustedSshCaKeys() (keys string, err error) {
+	cas := make([]*model.SshCa, 0)
+	if err = mysql.DB.Model(model.DefaultSshCa).Order("id").Find(&cas).Error; err != nil {
+		return
+	}
+	for _, ca := range cas {
+		keys += ca.PublicKey + "\n"
+	}
+	return
+}
+
+// SshCaPrincipals returns principals accepted by login users of the asset,
+// they are users of accounts logging in by certificates of the cas since certificates are signed for account names
+func SshCaPrincipals(asset *model.Asset) (principals map[string][]string, err error) {
+	accounts := make([]*model.Account, 0)
+	if err = mysql.DB.Model(model.DefaultAccount).
+		Where("id IN ? AND account_type IN ?", lo.Keys(asset.Authorization), []int{model.AUTHMETHOD_SSHCERT, model.AUTHMETHOD_SSHKEYCERT}).
+		Find(&accounts).Error; err != nil {
+		return
+	}
+	principals = make(map[string][]string)
+	for _, a := range accounts {
+		principals[a.Account] = []string{a.Account}
+	}
+	return
+}
+
+// KnownHosts returns lines of known_hosts trusting the host key of the ssh server of oneterm for hosts, hosts are
+// bracketed with the port unless it is 22
+func KnownHosts(hosts []string) (lines string, err error) {
+	signer, err := ssh.ParsePrivateKey([]byte(conf.Cfg.Ssh.PrivateKey))
+	if err != nil {
+		return
+	}
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	for _, h := range hosts {
+		lines += fmt.Sprintf("%s %s\n", knownhosts.Normalize(net.JoinHostPort(h, fmt.Sprint(conf.Cfg.Ssh.Port))), key)
+	}
+	return
+}
@@ -0,0 +1,241 @@
+package warmup
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	ggateway "github.com/veops/oneterm/gateway"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+// pooled is an ssh control connection shared by sessions of the same asset and account, sessions are channels of it
+type pooled struct {
+	cli            *gossh.Client
+	tunnelId       string
+	ip             string
+	port           int
+	assetUpdated   time.Time
+	accountUpdated time.Time
+	created        time.Time
+	used           time.Time
+	refs           int
+	stale          bool
+}
+
+var (
+	pool = map[string]*pooled{}
+	mtx  sync.Mutex
+)
+
+func key(assetId, accountId int) string {
+	return fmt.Sprintf("%d-%d", assetId, accountId)
+}
+
+// fresh reports whether credentials are not changed since it was dialed and it is not too old
+func (p *pooled) fresh(asset *model.Asset, account *model.Account) bool {
+	lifetime := time.Minute * time.Duration(conf.Cfg.Warmup.Lifetime)
+	return !p.stale && p.assetUpdated.Equal(asset.UpdatedAt) && p.accountUpdated.Equal(account.UpdatedAt) &&
+		(lifetime <= 0 || time.Since(p.created) < lifetime)
+}
+
+func (p *pooled) close() {
+	p.cli.Close()
+	ggateway.GetGatewayManager().Close(p.tunnelId)
+}
+
+// Dial returns an ssh client of the target and the address it dialed, clients of warmup assets are taken from the pool,
+// release must be called when the session ends
+func Dial(sessionId string, asset *model.Asset, account *model.Account, gateway *model.Gateway) (cli *gossh.Client, ip string, port int, release func(), err error) {
+	if !asset.Warmup {
+		return DialDirect(sessionId, asset, account, gateway)
+	}
+
+	k := key(asset.Id, account.Id)
+	if p := acquire(k, asset, account); p != nil {
+		// the connection may be broken by the target or network while it is idle
+		if _, _, err = p.cli.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+			return p.cli, p.ip, p.port, func() { unref(p) }, nil
+		}
+		mtx.Lock()
+		p.stale = true
+		if pool[k] == p {
+			delete(pool, k)
+		}
+		mtx.Unlock()
+		unref(p)
+	}
+
+	p, err := put(k, asset, account, gateway)
+	if err != nil {
+		return
+	}
+	return p.cli, p.ip, p.port, func() { unref(p) }, nil
+}
+
+// DialDirect dials a client which is not pooled for sessions which could not share it with others
+func DialDirect(sessionId string, asset *model.Asset, account *model.Account, gateway *model.Gateway) (cli *gossh.Client, ip string, port int, release func(), err error) {
+	if cli, ip, port, err = dial(sessionId, asset, account, gateway); err != nil {
+		return
+	}
+	return cli, ip, port, func() { cli.Close() }, nil
+}
+
+func acquire(k string, asset *model.Asset, account *model.Account) *pooled {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	p, ok := pool[k]
+	if !ok {
+		return nil
+	}
+	if !p.fresh(asset, account) {
+		p.stale = true
+		delete(pool, k)
+		if p.refs <= 0 {
+			p.close()
+		}
+		return nil
+	}
+	p.refs++
+	p.used = time.Now()
+	return p
+}
+
+func unref(p *pooled) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	p.refs--
+	p.used = time.Now()
+	if p.stale && p.refs <= 0 {
+		p.close()
+	}
+}
+
+// put dials a new pooled client with one reference, tunnel of gateway lives as long as the client
+func put(k string, asset *model.Asset, account *model.Account, gateway *model.Gateway) (p *pooled, err error) {
+	tunnelId := fmt.Sprintf("warmup-%s-%d", k, time.Now().UnixNano())
+	cli, ip, port, err := dial(tunnelId, asset, account, gateway)
+	if err != nil {
+		ggateway.GetGatewayManager().Close(tunnelId)
+		return
+	}
+	now := time.Now()
+	p = &pooled{
+		cli:            cli,
+		tunnelId:       tunnelId,
+		ip:             ip,
+		port:           port,
+		assetUpdated:   asset.UpdatedAt,
+		accountUpdated: account.UpdatedAt,
+		created:        now,
+		used:           now,
+		refs:           1,
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if old, ok := pool[k]; ok {
+		old.stale = true
+		if old.refs <= 0 {
+			old.close()
+		}
+	}
+	pool[k] = p
+
+	return
+}
+
+func dial(tunnelId string, asset *model.Asset, account *model.Account, gateway *model.Gateway) (cli *gossh.Client, ip string, port int, err error) {
+	if ip, port, err = util.Proxy(false, tunnelId, "ssh", asset, gateway); err != nil {
+		return
+	}
+	auth, err := util.GetAuth(account)
+	if err != nil {
+		return
+	}
+	cli, err = gossh.Dial("tcp", net.JoinHostPort(ip, fmt.Sprint(port)), &gossh.ClientConfig{
+		User:            account.Account,
+		Auth:            []gossh.AuthMethod{auth},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	return
+}
+
+// Expire closes idle clients, clients in use are never closed
+func Expire() {
+	idle := time.Minute * time.Duration(conf.Cfg.Warmup.Idle)
+	lifetime := time.Minute * time.Duration(conf.Cfg.Warmup.Lifetime)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	for k, p := range pool {
+		if p.refs > 0 {
+			continue
+		}
+		if time.Since(p.used) >= idle || (lifetime > 0 && time.Since(p.created) >= lifetime) {
+			delete(pool, k)
+			p.close()
+		}
+	}
+}
+
+// Warm dials clients of warmup assets for authorized accounts in advance,
+// clients of assets not warmup any more or accounts not authorized any more are closed
+func Warm() {
+	assets := make([]*model.Asset, 0)
+	if err := mysql.DB.Model(model.DefaultAsset).Where("warmup = ?", true).Find(&assets).Error; err != nil {
+		logger.L().Warn("get warmup assets failed", zap.Error(err))
+		return
+	}
+	keys := make(map[string]bool)
+	for _, a := range assets {
+		for accountId := range a.Authorization {
+			keys[key(a.Id, accountId)] = true
+		}
+	}
+
+	mtx.Lock()
+	for k, p := range pool {
+		if keys[k] {
+			delete(keys, k)
+			continue
+		}
+		p.stale = true
+		delete(pool, k)
+		if p.refs <= 0 {
+			p.close()
+		}
+	}
+	mtx.Unlock()
+
+	for _, a := range assets {
+		for accountId := range a.Authorization {
+			k := key(a.Id, accountId)
+			if !keys[k] {
+				continue
+			}
+			asset, account, gateway, err := util.GetAAG(a.Id, accountId)
+			if err != nil || !lo.Contains([]int{model.AUTHMETHOD_PASSWORD, model.AUTHMETHOD_PUBLICKEY}, account.AccountType) {
+				continue
+			}
+			p, err := put(k, asset, account, gateway)
+			if err != nil {
+				logger.L().Debug("warmup failed", zap.String("key", k), zap.Error(err))
+				continue
+			}
+			unref(p)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package warning
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/remote"
+)
+
+const (
+	SOURCE_PULL = "pull"
+)
+
+var (
+	running atomic.Bool
+	last    time.Time
+)
+
+// Item is a warning of the asset of AssetId, or of all assets of Ip if AssetId is 0
+type Item struct {
+	ExternalId string `json:"external_id" binding:"required,max=128"`
+	AssetId    int    `json:"asset_id"`
+	Ip         string `json:"ip"`
+	Type       string `json:"type" binding:"omitempty,oneof=incident vulnerability"`
+	Severity   string `json:"severity" binding:"omitempty,oneof=low medium high critical"`
+	Title      string `json:"title"`
+	Url        string `json:"url"`
+	Resolved   bool   `json:"resolved"`
+}
+
+// Report is the body of both the webhook and pulls, open warnings of the source missing in a full report are resolved
+type Report struct {
+	Source   string  `json:"source" binding:"required,max=64"`
+	Full     bool    `json:"full"`
+	Warnings []*Item `json:"warnings" binding:"dive"`
+}
+
+// PullDue pulls warnings if the interval has passed since the last pull
+func PullDue() {
+	cfg := conf.Cfg.Warning
+	if cfg.Url == "" || cfg.Interval <= 0 || time.Since(last) < time.Minute*time.Duration(cfg.Interval) {
+		return
+	}
+	last = time.Now()
+	if _, err := Pull(); err != nil {
+		logger.L().Warn("pull asset warnings failed", zap.Error(err))
+	}
+}
+
+// Pull takes the report from the configured url as a full one, only one runs at a time
+func Pull() (n int, err error) {
+	cfg := conf.Cfg.Warning
+	if cfg.Url == "" {
+		return 0, fmt.Errorf("url of warnings is not configured")
+	}
+	if !running.CompareAndSwap(false, true) {
+		return 0, fmt.Errorf("pulling warnings is running")
+	}
+	defer running.Store(false)
+
+	r := &Report{}
+	req := remote.RC.R().SetResult(r)
+	if cfg.Token != "" {
+		req.SetAuthToken(cfg.Token)
+	}
+	resp, err := req.Get(cfg.Url)
+	if err = remote.HandleErr(err, resp, nil); err != nil {
+		return
+	}
+	r.Source = lo.Ternary(r.Source != "", r.Source, SOURCE_PULL)
+	r.Full = true
+
+	return Apply(context.Background(), r)
+}
+
+// Apply upserts open warnings and deletes resolved ones, it returns the number of open warnings saved
+func Apply(ctx context.Context, r *Report) (n int, err error) {
+	ips := lo.Uniq(lo.FilterMap(r.Warnings, func(w *Item, _ int) (string, bool) { return w.Ip, w.AssetId == 0 && w.Ip != "" }))
+	assets := make([]*model.Asset, 0)
+	if len(ips) > 0 {
+		if err = mysql.DB.WithContext(ctx).Model(model.DefaultAsset).Select("id", "ip").Where("ip IN ?", ips).Find(&assets).Error; err != nil {
+			return
+		}
+	}
+	byIp := lo.GroupBy(assets, func(a *model.Asset) string { return a.Ip })
+
+	// datetime columns may be rounded, so open ones of a full report are compared with the truncated time
+	now := time.Now()
+	open, resolved := make([]*model.AssetWarning, 0), make([]*model.AssetWarning, 0)
+	for _, w := range r.Warnings {
+		assetIds := []int{w.AssetId}
+		if w.AssetId == 0 {
+			assetIds = lo.Map(byIp[w.Ip], func(a *model.Asset, _ int) int { return a.Id })
+		}
+		for _, id := range assetIds {
+			aw := &model.AssetWarning{
+				AssetId:    id,
+				Source:     r.Source,
+				ExternalId: w.ExternalId,
+				Type:       w.Type,
+				Severity:   lo.Ternary(w.Severity != "", w.Severity, model.SEVERITY_LOW),
+				Title:      w.Title,
+				Url:        w.Url,
+				CreatedAt:  now,
+				UpdatedAt:  now,
+			}
+			if w.Resolved {
+				resolved = append(resolved, aw)
+			} else {
+				open = append(open, aw)
+			}
+		}
+	}
+
+	err = mysql.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		if len(open) > 0 {
+			if err = tx.
+				Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "asset_id"}, {Name: "source"}, {Name: "external_id"}},
+					DoUpdates: clause.AssignmentColumns([]string{"type", "severity", "title", "url", "updated_at"}),
+				}).
+				Create(&open).Error; err != nil {
+				return
+			}
+		}
+		for _, w := range resolved {
+			if err = tx.Where("asset_id = ? AND source = ? AND external_id = ?", w.AssetId, w.Source, w.ExternalId).
+				Delete(model.DefaultAssetWarning).Error; err != nil {
+				return
+			}
+		}
+		if r.Full {
+			err = tx.Where("source = ? AND updated_at < ?", r.Source, now.Truncate(time.Second)).Delete(model.DefaultAssetWarning).Error
+		}
+		return
+	})
+	n = len(open)
+
+	return
+}
+
+// Get returns open warnings of assets grouped by asset ids, the most severe ones come first
+func Get(ctx context.Context, assetIds ...int) (res map[int][]*model.AssetWarning, err error) {
+	warnings := make([]*model.AssetWarning, 0)
+	if err = mysql.DB.WithContext(ctx).Model(model.DefaultAssetWarning).
+		Where("asset_id IN ?", assetIds).
+		Order("FIELD(severity, 'critical', 'high', 'medium', 'low'), id").
+		Find(&warnings).Error; err != nil {
+		return
+	}
+	res = lo.GroupBy(warnings, func(w *model.AssetWarning) int { return w.AssetId })
+	return
+}
@@ -0,0 +1,249 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/veops/oneterm/conf"
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/eventbus"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/util"
+)
+
+const (
+	HEADER_EVENT     = "X-Oneterm-Event"
+	HEADER_DELIVERY  = "X-Oneterm-Delivery"
+	HEADER_SIGNATURE = "X-Oneterm-Signature"
+
+	// retryInterval of looking for deliveries due
+	retryInterval = time.Second * 10
+	// maxMessage is the most of response bodies kept on deliveries
+	maxMessage = 1024
+)
+
+var (
+	// Types are events webhooks could take
+	Types = []string{
+		eventbus.TYPE_SESSION_OPEN, eventbus.TYPE_SESSION_CLOSE, eventbus.TYPE_SESSION_ADMIN_CLOSE,
+		eventbus.TYPE_COMMAND_BLOCKED, eventbus.TYPE_COMMAND, eventbus.TYPE_FILE_TRANSFER, eventbus.TYPE_CANARY_FAILED,
+	}
+	// defaultTypes are taken by webhooks without events, they are of the lifecycle of sessions and violations
+	defaultTypes = Types[:4]
+
+	events      chan *eventbus.Event
+	dropped     atomic.Int64
+	sem         chan struct{}
+	cli         = &http.Client{}
+	ctx, cancel = context.WithCancel(context.Background())
+)
+
+func init() {
+	cfg := conf.Cfg.Webhook
+	events = make(chan *eventbus.Event, cfg.BufferSize)
+	sem = make(chan struct{}, max(cfg.Concurrency, 1))
+	cli.Timeout = time.Second * time.Duration(cfg.Timeout)
+	eventbus.Listen(func(e *eventbus.Event) {
+		if !lo.Contains(Types, e.Type) {
+			return
+		}
+		select {
+		case events <- e:
+		default:
+			dropped.Add(1)
+		}
+	})
+}
+
+// Validate checks the url and events of a webhook
+func Validate(w *model.Webhook) error {
+	u, err := url.Parse(w.Url)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %s", w.Url)
+	}
+	if t, ok := lo.Find(w.Events, func(t string) bool { return !lo.Contains(Types, t) }); ok {
+		return fmt.Errorf("unsupported webhook event %s", t)
+	}
+	return nil
+}
+
+// Run creates deliveries of events for enabled webhooks taking them and posts them, failed ones are retried by
+// backoff until it is stopped
+func Run() (err error) {
+	tk := time.NewTicker(retryInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			if n := dropped.Swap(0); n > 0 {
+				logger.L().Warn("webhook buffer is full, events are dropped", zap.Int64("count", n))
+			}
+			retryDue()
+		case e := <-events:
+			for _, d := range create(e) {
+				go deliver(d)
+			}
+		}
+	}
+}
+
+func Stop() {
+	defer cancel()
+}
+
+// Redeliver makes the delivery pending with all attempts again, it is posted by the next retry
+func Redeliver(id int) error {
+	db := mysql.DB.Model(model.DefaultWebhookDelivery).Where("id = ?", id).
+		Updates(map[string]any{"status": model.WEBHOOKSTATUS_PENDING, "attempts": 0, "next_at": time.Now()})
+	if db.Error == nil && db.RowsAffected == 0 {
+		return fmt.Errorf("webhook delivery %d does not exist", id)
+	}
+	return db.Error
+}
+
+// Expire deletes deliveries created before t
+func Expire(t time.Time) (n int64, err error) {
+	db := mysql.DB.Where("created_at < ?", t).Delete(model.DefaultWebhookDelivery)
+	return db.RowsAffected, db.Error
+}
+
+// create saves deliveries of the event already claimed, so that retries do not post them at the same time
+func create(e *eventbus.Event) (ds []*model.WebhookDelivery) {
+	hooks := make([]*model.Webhook, 0)
+	if err := mysql.DB.Model(model.DefaultWebhook).Where("enable = ?", true).Find(&hooks).Error; err != nil {
+		logger.L().Error("get webhooks failed", zap.Error(err))
+		return
+	}
+	hooks = lo.Filter(hooks, func(w *model.Webhook, _ int) bool {
+		return lo.Contains(lo.Ternary(len(w.Events) == 0, defaultTypes, w.Events), e.Type)
+	})
+	if len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	ds = lo.Map(hooks, func(w *model.Webhook, _ int) *model.WebhookDelivery {
+		return &model.WebhookDelivery{
+			WebhookId: w.Id,
+			EventId:   e.Id,
+			Type:      e.Type,
+			SessionId: e.SessionId,
+			Payload:   string(payload),
+			Status:    model.WEBHOOKSTATUS_PENDING,
+			NextAt:    claimUntil(),
+		}
+	})
+	if err = mysql.DB.Create(&ds).Error; err != nil {
+		logger.L().Error("create webhook deliveries failed", zap.String("type", e.Type), zap.Error(err))
+		return nil
+	}
+	return
+}
+
+// retryDue claims pending deliveries due by moving their next time, others running oneterm skip claimed ones
+func retryDue() {
+	ds := make([]*model.WebhookDelivery, 0)
+	if err := mysql.DB.Model(model.DefaultWebhookDelivery).
+		Where("status = ? AND next_at <= ?", model.WEBHOOKSTATUS_PENDING, time.Now()).
+		Order("next_at").Limit(100).Find(&ds).Error; err != nil {
+		logger.L().Error("get due webhook deliveries failed", zap.Error(err))
+		return
+	}
+	for _, d := range ds {
+		next := claimUntil()
+		db := mysql.DB.Model(d).Where("status = ? AND next_at = ?", model.WEBHOOKSTATUS_PENDING, d.NextAt).Update("next_at", next)
+		if db.Error != nil || db.RowsAffected == 0 {
+			continue
+		}
+		d.NextAt = next
+		go deliver(d)
+	}
+}
+
+// claimUntil is when a claimed delivery is retried if oneterm stops before finishing it
+func claimUntil() time.Time {
+	return time.Now().Add(cli.Timeout + retryInterval)
+}
+
+// deliver posts the delivery once, it is failed once attempts run out or the webhook is gone
+func deliver(d *model.WebhookDelivery) {
+	sem <- struct{}{}
+	defer func() { <-sem }()
+
+	cfg := conf.Cfg.Webhook
+	w := &model.Webhook{}
+	code, err := 0, error(nil)
+	if err = mysql.DB.Model(w).Where("id = ?", d.WebhookId).First(w).Error; err == nil {
+		code, err = post(w, d)
+	}
+	d.Attempts++
+	d.ResponseCode = code
+	d.Message = ""
+	switch {
+	case err == nil:
+		d.Status = model.WEBHOOKSTATUS_SUCCESS
+	case errors.Is(err, gorm.ErrRecordNotFound) || d.Attempts >= cfg.MaxAttempts:
+		d.Status, d.Message = model.WEBHOOKSTATUS_FAILED, err.Error()
+	default:
+		d.Message = err.Error()
+		d.NextAt = time.Now().Add(time.Second * time.Duration(cfg.Backoff) << (d.Attempts - 1))
+	}
+	if err := mysql.DB.Model(d).Select("status", "attempts", "response_code", "message", "next_at").Updates(d).Error; err != nil {
+		logger.L().Error("update webhook delivery failed", zap.Int("id", d.Id), zap.Error(err))
+	}
+}
+
+func post(w *model.Webhook, d *model.WebhookDelivery) (code int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Url, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "oneterm-webhook")
+	req.Header.Set(HEADER_EVENT, d.Type)
+	req.Header.Set(HEADER_DELIVERY, strconv.Itoa(d.Id))
+	if w.Secret != "" {
+		req.Header.Set(HEADER_SIGNATURE, "sha256="+Sign(util.DecryptAES(w.Secret), []byte(d.Payload)))
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	bs, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessage))
+	if code = resp.StatusCode; code < 200 || code >= 300 {
+		err = fmt.Errorf("%s: %s", resp.Status, bs)
+	}
+	return
+}
+
+// Sign is the hex of hmac sha256 of the body, receivers compare it with the signature header after sha256=
+func Sign(secret string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
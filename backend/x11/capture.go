@@ -0,0 +1,269 @@
+package x11
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	mysql "github.com/veops/oneterm/db"
+	"github.com/veops/oneterm/logger"
+	"github.com/veops/oneterm/model"
+	"github.com/veops/oneterm/storage"
+)
+
+const (
+	opGetGeometry = 14
+	opGetImage    = 73
+
+	formatZPixmap = 2
+)
+
+type visual struct {
+	red, green, blue uint32
+}
+
+// conn is a minimal x11 client on a channel to the x server of the user, only what capturing needs is parsed
+type conn struct {
+	rw      io.ReadWriter
+	order   binary.ByteOrder
+	lsb     bool
+	bpp     map[byte]int
+	pad     map[byte]int
+	visuals map[uint32]*visual
+}
+
+// Capture saves screenshots of top level windows of the target every interval until ctx is done
+func (f *Forwarder) Capture(ctx context.Context, interval time.Duration) {
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			if err := f.capture(); err != nil {
+				logger.L().Warn("capture x11 windows failed", zap.String("sessionId", f.sessionId), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (f *Forwarder) capture() (err error) {
+	ids := f.Windows()
+	if len(ids) == 0 {
+		return
+	}
+	ch, reqs, err := f.user.OpenChannel("x11", ssh.Marshal(struct {
+		Addr string
+		Port uint32
+	}{"127.0.0.1", 0}))
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	c, err := dial(ch, f.req)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		img, err := c.capture(id)
+		if err != nil {
+			// the window may be gone in the meantime
+			logger.L().Debug("capture x11 window failed", zap.String("sessionId", f.sessionId), zap.Uint32("window", id), zap.Error(err))
+			continue
+		}
+		if err = f.save(id, img); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (f *Forwarder) save(id uint32, img image.Image) (err error) {
+	now := time.Now()
+	name := fmt.Sprintf("%s-x11-%d-%d.png", f.sessionId, id, now.UnixNano())
+	buf := &bytes.Buffer{}
+	if err = png.Encode(buf, img); err != nil {
+		return
+	}
+	if err = os.WriteFile(storage.Path(name), buf.Bytes(), 0644); err != nil {
+		return
+	}
+	if err = storage.Archive(name); err != nil {
+		return
+	}
+	return mysql.AuditDB.Create(&model.X11Capture{
+		SessionId: f.sessionId,
+		Uid:       f.uid,
+		AssetId:   f.assetId,
+		WindowId:  id,
+		Width:     img.Bounds().Dx(),
+		Height:    img.Bounds().Dy(),
+		Name:      name,
+		CreatedAt: now,
+	}).Error
+}
+
+// dial sets up the connection with the cookie of the user, which the client of the user replaces with the real one
+func dial(rw io.ReadWriter, req *Request) (c *conn, err error) {
+	cookie, err := hex.DecodeString(req.AuthCookie)
+	if err != nil {
+		return
+	}
+	c = &conn{rw: rw, order: binary.LittleEndian, bpp: map[byte]int{}, pad: map[byte]int{}, visuals: map[uint32]*visual{}}
+
+	setup := make([]byte, 12+pad(len(req.AuthProtocol))+pad(len(cookie)))
+	setup[0] = 'l'
+	c.order.PutUint16(setup[2:], 11)
+	c.order.PutUint16(setup[6:], uint16(len(req.AuthProtocol)))
+	c.order.PutUint16(setup[8:], uint16(len(cookie)))
+	copy(setup[12:], req.AuthProtocol)
+	copy(setup[12+pad(len(req.AuthProtocol)):], cookie)
+	if _, err = rw.Write(setup); err != nil {
+		return
+	}
+
+	head := make([]byte, 8)
+	if _, err = io.ReadFull(rw, head); err != nil {
+		return
+	}
+	data := make([]byte, int(c.order.Uint16(head[6:]))*4)
+	if _, err = io.ReadFull(rw, data); err != nil {
+		return
+	}
+	if head[0] != 1 {
+		return nil, fmt.Errorf("x11 setup failed: %s", bytes.TrimRight(data[:min(int(head[1]), len(data))], "\x00"))
+	}
+	if len(data) < 32 {
+		return nil, fmt.Errorf("x11 setup reply too short")
+	}
+
+	vendor, screens, formats := int(c.order.Uint16(data[16:])), int(data[20]), int(data[21])
+	c.lsb = data[22] == 0
+	off := 32 + pad(vendor)
+	for i := 0; i < formats && off+8 <= len(data); i, off = i+1, off+8 {
+		c.bpp[data[off]], c.pad[data[off]] = int(data[off+1]), int(data[off+2])
+	}
+	// visuals of all depths of all screens, images tell the visual of windows
+	for i := 0; i < screens && off+40 <= len(data); i++ {
+		depths := int(data[off+39])
+		off += 40
+		for j := 0; j < depths && off+8 <= len(data); j++ {
+			n := int(c.order.Uint16(data[off+2:]))
+			off += 8
+			for k := 0; k < n && off+24 <= len(data); k, off = k+1, off+24 {
+				c.visuals[c.order.Uint32(data[off:])] = &visual{
+					red:   c.order.Uint32(data[off+8:]),
+					green: c.order.Uint32(data[off+12:]),
+					blue:  c.order.Uint32(data[off+16:]),
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// request sends a request and returns the reply with its header, events are skipped
+func (c *conn) request(op byte, data byte, body []byte) (reply []byte, err error) {
+	req := make([]byte, 4+len(body))
+	req[0], req[1] = op, data
+	c.order.PutUint16(req[2:], uint16(len(req)/4))
+	copy(req[4:], body)
+	if _, err = c.rw.Write(req); err != nil {
+		return
+	}
+
+	for {
+		reply = make([]byte, 32)
+		if _, err = io.ReadFull(c.rw, reply); err != nil {
+			return
+		}
+		switch reply[0] {
+		case 0:
+			return nil, fmt.Errorf("x11 error %d of request %d", reply[1], op)
+		case 1:
+			extra := make([]byte, int(c.order.Uint32(reply[4:]))*4)
+			if _, err = io.ReadFull(c.rw, extra); err != nil {
+				return
+			}
+			return append(reply, extra...), nil
+		}
+	}
+}
+
+func (c *conn) capture(id uint32) (img image.Image, err error) {
+	body := make([]byte, 4)
+	c.order.PutUint32(body, id)
+	geo, err := c.request(opGetGeometry, 0, body)
+	if err != nil {
+		return
+	}
+	w, h := int(c.order.Uint16(geo[16:])), int(c.order.Uint16(geo[18:]))
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("empty window")
+	}
+
+	body = make([]byte, 16)
+	c.order.PutUint32(body, id)
+	c.order.PutUint16(body[8:], uint16(w))
+	c.order.PutUint16(body[10:], uint16(h))
+	c.order.PutUint32(body[12:], 0xffffffff)
+	reply, err := c.request(opGetImage, formatZPixmap, body)
+	if err != nil {
+		return
+	}
+	return c.decode(reply[1], c.visuals[c.order.Uint32(reply[8:])], reply[32:], w, h)
+}
+
+// decode converts a z pixmap of true color visuals to rgba, pixels are whole bytes
+func (c *conn) decode(depth byte, v *visual, data []byte, w, h int) (image.Image, error) {
+	bpp, scanPad := c.bpp[depth], c.pad[depth]
+	if v == nil || bpp%8 != 0 || bpp == 0 || scanPad == 0 || v.red == 0 || v.green == 0 || v.blue == 0 {
+		return nil, fmt.Errorf("unsupported depth %d", depth)
+	}
+	stride := (w*bpp + scanPad - 1) / scanPad * scanPad / 8
+	if len(data) < stride*h {
+		return nil, fmt.Errorf("image too short")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	size := bpp / 8
+	for y := 0; y < h; y++ {
+		row := data[y*stride:]
+		for x := 0; x < w; x++ {
+			p := uint32(0)
+			for i := 0; i < size; i++ {
+				b := uint32(row[x*size+i])
+				if c.lsb {
+					p |= b << (8 * i)
+				} else {
+					p = p<<8 | b
+				}
+			}
+			img.SetRGBA(x, y, color.RGBA{channel(p, v.red), channel(p, v.green), channel(p, v.blue), 0xff})
+		}
+	}
+	return img, nil
+}
+
+// channel scales the masked bits of p to 8 bits
+func channel(p, mask uint32) uint8 {
+	n := bits.OnesCount32(mask)
+	x := uint64((p & mask) >> bits.TrailingZeros32(mask))
+	return uint8(x * 0xff / (1<<n - 1))
+}
@@ -0,0 +1,229 @@
+package x11
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/veops/oneterm/logger"
+)
+
+const (
+	opCreateWindow  = 1
+	opDestroyWindow = 4
+	opMapWindow     = 8
+	opUnmapWindow   = 10
+)
+
+// Request is the payload of x11-req
+//
+//	https://datatracker.ietf.org/doc/html/rfc4254#section-6.3.1
+type Request struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}
+
+// ParseRequest parses the payload of x11-req sent by clients of users
+func ParseRequest(payload []byte) (req *Request, err error) {
+	req = &Request{}
+	if err = ssh.Unmarshal(payload, req); err != nil {
+		return nil, err
+	}
+	return
+}
+
+type window struct {
+	top    bool
+	mapped bool
+}
+
+// Forwarder bridges x11 channels opened by the target to the client of the user, which is the x server.
+// Windows created by the target are tracked from the requests so that top level ones could be captured
+type Forwarder struct {
+	sessionId string
+	uid       int
+	assetId   int
+	req       *Request
+	user      ssh.Conn
+	mtx       sync.Mutex
+	windows   map[uint32]*window
+}
+
+func New(sessionId string, uid, assetId int, user ssh.Conn, req *Request) *Forwarder {
+	return &Forwarder{
+		sessionId: sessionId,
+		uid:       uid,
+		assetId:   assetId,
+		req:       req,
+		user:      user,
+		windows:   map[uint32]*window{},
+	}
+}
+
+// Forward serves x11 channels opened by the target and asks the target to forward for the session,
+// cli must not be shared by other sessions since channels of x11 are not bound to sessions
+func (f *Forwarder) Forward(cli *ssh.Client, sess *ssh.Session) (err error) {
+	chans := cli.HandleChannelOpen("x11")
+	if chans == nil {
+		return fmt.Errorf("x11 channels are handled already")
+	}
+	go func() {
+		for ch := range chans {
+			go f.bridge(ch)
+		}
+	}()
+
+	ok, err := sess.SendRequest("x11-req", true, ssh.Marshal(f.req))
+	if err == nil && !ok {
+		err = fmt.Errorf("x11 forwarding request denied")
+	}
+	return
+}
+
+func (f *Forwarder) bridge(nc ssh.NewChannel) {
+	// the originator is passed as it is, clients only show it
+	uc, ureqs, err := f.user.OpenChannel("x11", nc.ExtraData())
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	tc, treqs, err := nc.Accept()
+	if err != nil {
+		uc.Close()
+		return
+	}
+	go ssh.DiscardRequests(ureqs)
+	go ssh.DiscardRequests(treqs)
+	defer uc.Close()
+	defer tc.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer uc.CloseWrite()
+		io.Copy(uc, io.TeeReader(tc, &tracker{f: f}))
+	}()
+	go func() {
+		defer wg.Done()
+		defer tc.CloseWrite()
+		io.Copy(tc, uc)
+	}()
+	wg.Wait()
+}
+
+// Windows returns top level windows which are mapped at the moment
+func (f *Forwarder) Windows() (ids []uint32) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for id, w := range f.windows {
+		if w.top && w.mapped {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+func (f *Forwarder) track(op byte, wid, parent uint32) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	switch op {
+	case opCreateWindow:
+		// the root window is not created by clients, so windows with unknown parents are top level ones
+		f.windows[wid] = &window{top: f.windows[parent] == nil}
+	case opDestroyWindow:
+		delete(f.windows, wid)
+	case opMapWindow, opUnmapWindow:
+		if w := f.windows[wid]; w != nil {
+			w.mapped = op == opMapWindow
+		}
+	}
+}
+
+// tracker parses requests of a x11 connection from the target, bodies other than window ids are skipped
+type tracker struct {
+	f     *Forwarder
+	order binary.ByteOrder
+	buf   []byte
+	skip  int
+	lost  bool
+}
+
+func (t *tracker) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if t.lost {
+		return
+	}
+	t.buf = append(t.buf, p...)
+	for {
+		if t.skip > 0 {
+			k := min(t.skip, len(t.buf))
+			t.skip -= k
+			t.buf = t.buf[k:]
+			if t.skip > 0 {
+				break
+			}
+		}
+		if t.order == nil {
+			// connection setup, names and data of authorization are padded to 4 bytes
+			if len(t.buf) < 12 {
+				break
+			}
+			switch t.buf[0] {
+			case 'B':
+				t.order = binary.BigEndian
+			case 'l':
+				t.order = binary.LittleEndian
+			default:
+				logger.L().Debug("unknown x11 byte order", zap.String("sessionId", t.f.sessionId))
+				t.lost, t.buf = true, nil
+				return
+			}
+			t.skip = 12 + pad(int(t.order.Uint16(t.buf[6:]))) + pad(int(t.order.Uint16(t.buf[8:])))
+			continue
+		}
+		if len(t.buf) < 4 {
+			break
+		}
+		// length of big requests is extended to the next 4 bytes
+		size, head := int(t.order.Uint16(t.buf[2:]))*4, 4
+		if size == 0 {
+			if len(t.buf) < 8 {
+				break
+			}
+			size, head = int(t.order.Uint32(t.buf[4:]))*4, 8
+		}
+		if size < head {
+			t.lost, t.buf = true, nil
+			return
+		}
+		if len(t.buf) < min(size, head+8) {
+			break
+		}
+		switch op := t.buf[0]; op {
+		case opCreateWindow:
+			if size >= head+8 {
+				t.f.track(op, t.order.Uint32(t.buf[head:]), t.order.Uint32(t.buf[head+4:]))
+			}
+		case opDestroyWindow, opMapWindow, opUnmapWindow:
+			if size >= head+4 {
+				t.f.track(op, t.order.Uint32(t.buf[head:]), 0)
+			}
+		}
+		t.skip = size
+	}
+	if len(t.buf) == 0 {
+		t.buf = nil
+	}
+	return
+}
+
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
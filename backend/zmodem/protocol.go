@@ -0,0 +1,341 @@
+package zmodem
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/samber/lo"
+)
+
+const (
+	ZPAD   = '*'
+	ZDLE   = 0x18
+	ZBIN   = 'A'
+	ZHEX   = 'B'
+	ZBIN32 = 'C'
+
+	XON  = 0x11
+	XOFF = 0x13
+)
+
+// frame types
+const (
+	ZRQINIT = iota
+	ZRINIT
+	ZSINIT
+	ZACK
+	ZFILE
+	ZSKIP
+	ZNAK
+	ZABORT
+	ZFIN
+	ZRPOS
+	ZDATA
+	ZEOF
+	ZFERR
+	ZCRC
+	ZCHALLENGE
+	ZCOMPL
+	ZCAN
+	ZFREECNT
+	ZCOMMAND
+)
+
+// ends of data subpackets
+const (
+	// ZCRCE the frame ends and a header follows
+	ZCRCE = 'h'
+	// ZCRCG the frame goes on without acks
+	ZCRCG = 'i'
+	// ZCRCQ the frame goes on and ZACK is expected
+	ZCRCQ = 'j'
+	// ZCRCW the frame ends and ZACK is expected
+	ZCRCW = 'k'
+	ZRUB0 = 'l'
+	ZRUB1 = 'm'
+)
+
+// flags of ZRINIT in ZF0
+const (
+	CANFDX  = 0x01
+	CANOVIO = 0x02
+	CANFC32 = 0x20
+)
+
+const (
+	// maxGarbage bytes are skipped at most while waiting for a header
+	maxGarbage = 4096
+	// maxSubpacket is twice of the longest subpacket of lrzsz
+	maxSubpacket = 16384
+)
+
+var (
+	errCanceled = errors.New("canceled by the peer")
+	errGarbage  = errors.New("no header in output")
+	// abortSeq cancels the transfer of the peer, backspaces erase cans echoed by shells
+	abortSeq = []byte{ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, ZDLE, 8, 8, 8, 8, 8, 8, 8, 8, 8, 8}
+)
+
+// header is of 5 bytes, p is ZP0-ZP3 of positions which are ZF3-ZF0 of flags
+type header struct {
+	typ   byte
+	p     [4]byte
+	crc32 bool
+}
+
+func newPosHeader(typ byte, pos int64) *header {
+	h := &header{typ: typ}
+	binary.LittleEndian.PutUint32(h.p[:], uint32(pos))
+	return h
+}
+
+func (h *header) pos() int64 {
+	return int64(binary.LittleEndian.Uint32(h.p[:]))
+}
+
+func (h *header) raw() []byte {
+	return append([]byte{h.typ}, h.p[:]...)
+}
+
+// hex is how receivers send headers
+func (h *header) hex() []byte {
+	raw := h.raw()
+	raw = binary.BigEndian.AppendUint16(raw, crc16(0, raw))
+	bs := append([]byte{ZPAD, ZPAD, ZDLE, ZHEX}, hex.EncodeToString(raw)...)
+	bs = append(bs, '\r', '\n'|0x80)
+	if h.typ != ZACK && h.typ != ZFIN {
+		bs = append(bs, XON)
+	}
+	return bs
+}
+
+// bin is how senders send headers, data subpackets following it take 16 bit crcs
+func (h *header) bin() []byte {
+	raw := h.raw()
+	raw = binary.BigEndian.AppendUint16(raw, crc16(0, raw))
+	return append([]byte{ZPAD, ZDLE, ZBIN}, escape(raw)...)
+}
+
+// subpacket encodes data with its end and 16 bit crc
+func subpacket(data []byte, end byte) []byte {
+	bs := append(escape(data), ZDLE, end)
+	return append(bs, escape(binary.BigEndian.AppendUint16(nil, crc16(crc16(0, data), []byte{end})))...)
+}
+
+// escape escapes zdle, flow control and cr which could be eaten by terminals
+func escape(bs []byte) []byte {
+	res := make([]byte, 0, len(bs)+len(bs)/16)
+	for _, b := range bs {
+		switch b {
+		case ZDLE, 0x10, 0x90, XON, XON | 0x80, XOFF, XOFF | 0x80, '\r', '\r' | 0x80:
+			res = append(res, ZDLE, b^0x40)
+		default:
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// crc16 is the crc of xmodem
+func crc16(crc uint16, bs []byte) uint16 {
+	for _, b := range bs {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// reader reads frames of the peer, bytes skipped before headers are kept in garbage for the terminal
+type reader struct {
+	r       *bufio.Reader
+	garbage []byte
+}
+
+// readByte skips flow control which is always escaped in frames
+func (r *reader) readByte() (b byte, err error) {
+	for {
+		if b, err = r.r.ReadByte(); err != nil || (b&0x7f != XON && b&0x7f != XOFF) {
+			return
+		}
+	}
+}
+
+// zdlRead reads a byte of data, end is set if it is the end of a subpacket
+func (r *reader) zdlRead() (b byte, end bool, err error) {
+	if b, err = r.readByte(); err != nil || b != ZDLE {
+		return
+	}
+	for cans := 1; ; cans++ {
+		if b, err = r.readByte(); err != nil {
+			return
+		}
+		switch {
+		case b == ZDLE:
+			if cans >= 4 {
+				return 0, false, errCanceled
+			}
+			continue
+		case b >= ZCRCE && b <= ZCRCW:
+			return b, true, nil
+		case b == ZRUB0:
+			return 0x7f, false, nil
+		case b == ZRUB1:
+			return 0xff, false, nil
+		case b&0x60 == 0x40:
+			return b ^ 0x40, false, nil
+		}
+		return 0, false, fmt.Errorf("bad escape %#x", b)
+	}
+}
+
+func (r *reader) skip(bs ...byte) error {
+	r.garbage = append(r.garbage, bs...)
+	if len(r.garbage) > maxGarbage {
+		return errGarbage
+	}
+	return nil
+}
+
+// readHeader skips bytes until a header, five cans in a row cancel the transfer
+func (r *reader) readHeader() (h *header, err error) {
+	b, cans := byte(0), 0
+	for {
+		if b, err = r.readByte(); err != nil {
+			return
+		}
+		if b == ZDLE {
+			if cans++; cans >= 5 {
+				return nil, errCanceled
+			}
+		} else {
+			cans = 0
+		}
+		if b&0x7f != ZPAD {
+			if err = r.skip(b); err != nil {
+				return
+			}
+			continue
+		}
+		// bytes of a false start are output of the terminal as well
+		seen := []byte{b}
+		for b&0x7f == ZPAD {
+			if b, err = r.readByte(); err != nil {
+				return
+			}
+			seen = append(seen, b)
+		}
+		if b != ZDLE {
+			if err = r.skip(seen...); err != nil {
+				return
+			}
+			continue
+		}
+		cans = 1
+		if b, err = r.readByte(); err != nil {
+			return
+		}
+		switch b {
+		case ZBIN, ZBIN32:
+			return r.readBinHeader(b == ZBIN32)
+		case ZHEX:
+			return r.readHexHeader()
+		case ZDLE:
+			cans++
+		}
+		if err = r.skip(append(seen, b)...); err != nil {
+			return
+		}
+	}
+}
+
+func (r *reader) readBinHeader(isCrc32 bool) (h *header, err error) {
+	n := 5 + lo.Ternary(isCrc32, 4, 2)
+	bs := make([]byte, n)
+	for i := range bs {
+		end := false
+		if bs[i], end, err = r.zdlRead(); err != nil {
+			return
+		}
+		if end {
+			return nil, fmt.Errorf("bad header")
+		}
+	}
+	if isCrc32 && crc32.ChecksumIEEE(bs[:5]) != binary.LittleEndian.Uint32(bs[5:]) ||
+		!isCrc32 && crc16(0, bs[:5]) != binary.BigEndian.Uint16(bs[5:]) {
+		return nil, fmt.Errorf("bad crc of header")
+	}
+	h = &header{typ: bs[0], crc32: isCrc32}
+	copy(h.p[:], bs[1:5])
+	return
+}
+
+func (r *reader) readHexHeader() (h *header, err error) {
+	hx := make([]byte, 14)
+	for i := range hx {
+		if hx[i], err = r.readByte(); err != nil {
+			return
+		}
+	}
+	bs := make([]byte, 7)
+	if _, err = hex.Decode(bs, hx); err != nil {
+		return nil, fmt.Errorf("bad header: %w", err)
+	}
+	if crc16(0, bs[:5]) != binary.BigEndian.Uint16(bs[5:]) {
+		return nil, fmt.Errorf("bad crc of header")
+	}
+	// cr and lf end hex headers
+	for i := 0; i < 2; i++ {
+		if p, _ := r.r.Peek(1); len(p) > 0 && p[0]&0x7f != '\r' && p[0]&0x7f != '\n' {
+			break
+		}
+		r.r.ReadByte()
+	}
+	h = &header{typ: bs[0]}
+	copy(h.p[:], bs[1:5])
+	return
+}
+
+// readSubpacket reads data till the end, the crc is of the header before it
+func (r *reader) readSubpacket(isCrc32 bool) (data []byte, end byte, err error) {
+	data = make([]byte, 0, 1024)
+	for {
+		b, isEnd := byte(0), false
+		if b, isEnd, err = r.zdlRead(); err != nil {
+			return
+		}
+		if !isEnd {
+			if data = append(data, b); len(data) > maxSubpacket {
+				return nil, 0, fmt.Errorf("subpacket is too long")
+			}
+			continue
+		}
+		end = b
+		break
+	}
+
+	crc := make([]byte, lo.Ternary(isCrc32, 4, 2))
+	for i := range crc {
+		isEnd := false
+		if crc[i], isEnd, err = r.zdlRead(); err != nil {
+			return
+		}
+		if isEnd {
+			return nil, 0, fmt.Errorf("bad subpacket")
+		}
+	}
+	if isCrc32 && crc32.Update(crc32.ChecksumIEEE(data), crc32.IEEETable, []byte{end}) != binary.LittleEndian.Uint32(crc) ||
+		!isCrc32 && crc16(crc16(0, data), []byte{end}) != binary.BigEndian.Uint16(crc) {
+		return nil, 0, fmt.Errorf("bad crc of subpacket")
+	}
+	return
+}
@@ -0,0 +1,251 @@
+package zmodem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func newReader(bs []byte) *reader {
+	return &reader{r: bufio.NewReader(bytes.NewReader(bs))}
+}
+
+func TestCrc(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want uint16
+	}{
+		{name: "check of xmodem", data: "123456789", want: 0x31c3},
+		{name: "empty", data: "", want: 0},
+		{name: "ZRQINIT", data: "\x00\x00\x00\x00\x00", want: 0},
+		{name: "ZRINIT of lrzsz", data: "\x01\x00\x00\x00\x23", want: 0xbe50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := crc16(0, []byte(tt.data)); got != tt.want {
+				t.Errorf("crc16() = %#04x, want %#04x", got, tt.want)
+			}
+			if n := len(tt.data) / 2; crc16(crc16(0, []byte(tt.data[:n])), []byte(tt.data[n:])) != tt.want {
+				t.Errorf("crc16() of two parts != %#04x", tt.want)
+			}
+		})
+	}
+	// crcs of 32 bit are of the standard library, the check value pins the polynomial zmodem uses
+	if got := crc32.ChecksumIEEE([]byte("123456789")); got != 0xcbf43926 {
+		t.Errorf("crc32.ChecksumIEEE() = %#08x, want %#08x", got, 0xcbf43926)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{name: "plain", data: []byte("abc\n"), want: []byte("abc\n")},
+		{name: "zdle", data: []byte{ZDLE}, want: []byte{ZDLE, 0x58}},
+		{name: "dle", data: []byte{0x10, 0x90}, want: []byte{ZDLE, 0x50, ZDLE, 0xd0}},
+		{name: "xon and xoff", data: []byte{XON, XOFF, XON | 0x80, XOFF | 0x80}, want: []byte{ZDLE, 0x51, ZDLE, 0x53, ZDLE, 0xd1, ZDLE, 0xd3}},
+		{name: "cr", data: []byte{'\r', '\r' | 0x80}, want: []byte{ZDLE, 0x4d, ZDLE, 0xcd}},
+		{name: "rubout is not escaped", data: []byte{0x7f, 0xff}, want: []byte{0x7f, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := escape(tt.data)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("escape() = %q, want %q", got, tt.want)
+			}
+			r := newReader(got)
+			for i, want := range tt.data {
+				if b, end, err := r.zdlRead(); err != nil || end || b != want {
+					t.Errorf("zdlRead() of byte %d = %#x, %v, %v, want %#x", i, b, end, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderHex(t *testing.T) {
+	zrinit := &header{typ: ZRINIT}
+	zrinit.p[3] = CANFDX | CANOVIO | CANFC32
+	tests := []struct {
+		name string
+		h    *header
+		want string
+	}{
+		{name: "ZRQINIT of sz", h: &header{typ: ZRQINIT}, want: "**\x18B00000000000000\r\x8a\x11"},
+		{name: "ZRINIT of rz", h: zrinit, want: "**\x18B0100000023be50\r\x8a\x11"},
+		{name: "ZACK without xon", h: &header{typ: ZACK}, want: "**\x18B0300000000eed2\r\x8a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.hex(); string(got) != tt.want {
+				t.Errorf("hex() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	// positions of bytes to be escaped
+	headers := []*header{
+		{typ: ZRQINIT},
+		newPosHeader(ZRPOS, 0x0d131118),
+		newPosHeader(ZDATA, 0x90911093),
+		newPosHeader(ZEOF, 1<<31),
+		{typ: ZFILE, p: [4]byte{0, 0, 0, 0x8d}},
+	}
+	for _, h := range headers {
+		for _, enc := range []struct {
+			name string
+			bs   []byte
+		}{{"hex", h.hex()}, {"bin", h.bin()}} {
+			got, err := newReader(enc.bs).readHeader()
+			if err != nil {
+				t.Errorf("readHeader() of %s of %d error = %v", enc.name, h.typ, err)
+				continue
+			}
+			if got.typ != h.typ || got.p != h.p || got.crc32 {
+				t.Errorf("readHeader() of %s = %+v, want %+v", enc.name, got, h)
+			}
+		}
+
+		raw := h.raw()
+		bs := append([]byte{ZPAD, ZDLE, ZBIN32}, escape(binary.LittleEndian.AppendUint32(raw, crc32.ChecksumIEEE(raw)))...)
+		got, err := newReader(bs).readHeader()
+		if err != nil || got.typ != h.typ || got.p != h.p || !got.crc32 {
+			t.Errorf("readHeader() of bin32 = %+v, %v, want %+v", got, err, h)
+		}
+	}
+	if got := newPosHeader(ZRPOS, 0x0d131118).pos(); got != 0x0d131118 {
+		t.Errorf("pos() = %#x, want %#x", got, 0x0d131118)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	zrqinit := (&header{typ: ZRQINIT}).hex()
+	bad := (&header{typ: ZRPOS}).bin()
+	bad[len(bad)-1] ^= 0x01
+	tests := []struct {
+		name        string
+		data        []byte
+		wantTyp     byte
+		wantGarbage string
+		wantErr     error
+	}{
+		{name: "after output", data: append([]byte("rz\r"), zrqinit...), wantTyp: ZRQINIT, wantGarbage: "rz\r"},
+		{name: "stars in output", data: append([]byte("** build **\r\n"), zrqinit...), wantTyp: ZRQINIT, wantGarbage: "** build **\r\n"},
+		{name: "false start", data: append([]byte("**\x18x"), zrqinit...), wantTyp: ZRQINIT, wantGarbage: "**\x18x"},
+		{name: "canceled", data: []byte{ZDLE, ZDLE, ZDLE, ZDLE, ZDLE}, wantErr: errCanceled},
+		{name: "abort sequence", data: abortSeq, wantErr: errCanceled},
+		{name: "too much garbage", data: bytes.Repeat([]byte("x"), maxGarbage+1), wantErr: errGarbage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReader(tt.data)
+			h, err := r.readHeader()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("readHeader() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if h.typ != tt.wantTyp || string(r.garbage) != tt.wantGarbage {
+				t.Errorf("readHeader() = %d, garbage %q, want %d, %q", h.typ, r.garbage, tt.wantTyp, tt.wantGarbage)
+			}
+		})
+	}
+	if _, err := newReader(bad).readHeader(); err == nil {
+		t.Errorf("readHeader() of a bad crc error = nil, want an error")
+	}
+}
+
+func TestSubpacketRoundTrip(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		end  byte
+	}{
+		{name: "all bytes", data: all, end: ZCRCG},
+		{name: "empty", data: nil, end: ZCRCE},
+		{name: "zdles", data: bytes.Repeat([]byte{ZDLE}, 8), end: ZCRCW},
+		{name: "text", data: []byte("hello\r\nworld"), end: ZCRCQ},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, end, err := newReader(subpacket(tt.data, tt.end)).readSubpacket(false)
+			if err != nil || end != tt.end || !bytes.Equal(data, tt.data) {
+				t.Errorf("readSubpacket() = %d bytes, %c, %v, want %d bytes, %c", len(data), end, err, len(tt.data), tt.end)
+			}
+
+			crc := crc32.Update(crc32.ChecksumIEEE(tt.data), crc32.IEEETable, []byte{tt.end})
+			bs := append(append(escape(tt.data), ZDLE, tt.end), escape(binary.LittleEndian.AppendUint32(nil, crc))...)
+			data, end, err = newReader(bs).readSubpacket(true)
+			if err != nil || end != tt.end || !bytes.Equal(data, tt.data) {
+				t.Errorf("readSubpacket() of crc32 = %d bytes, %c, %v, want %d bytes, %c", len(data), end, err, len(tt.data), tt.end)
+			}
+
+			bad := subpacket(tt.data, tt.end)
+			bad[len(bad)-1] ^= 0x01
+			if _, _, err = newReader(bad).readSubpacket(false); err == nil {
+				t.Errorf("readSubpacket() of a bad crc error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{name: "empty", data: "", want: -1},
+		{name: "ordinary output", data: "total 8\r\ndrwxr-xr-x 2 root root 4096 .\r\n", want: -1},
+		{name: "stars", data: "***** BUILD SUCCESS *****\r\n", want: -1},
+		{name: "markdown bold", data: "**B0ld** text\r\n", want: -1},
+		{name: "colors", data: "\x1b[1;31m**\x1b[0mB00\r\n", want: -1},
+		{name: "other frame type", data: "**\x18B02", want: -1},
+		{name: "signature at the end", data: "abc**\x18B0", want: -1},
+		{name: "sz", data: "**\x18B00000000000000\r\x8a\x11", want: 0},
+		{name: "rz after its banner", data: "rz waiting to receive.**\x18B0100000023be50\r\x8a\x11", want: 22},
+		{name: "after a false start", data: "**\x18B02 **\x18B01", want: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Index([]byte(tt.data)); got != tt.want {
+				t.Errorf("Index() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{name: "sz", data: "**\x18B00000000000000\r\x8a\x11", want: true},
+		{name: "ordinary output", data: "hello\r\n", want: false},
+		{name: "not at the start", data: "rz\r**\x18B0100000023be50\r\x8a\x11", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader([]byte(tt.data)))
+			r.Peek(1)
+			if got := Detect(r); got != tt.want {
+				t.Errorf("Detect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package zmodem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// events between web terminals and zmodem transfers
+const (
+	// EVENT_DOWNLOAD sz sends a file of Name and Size
+	EVENT_DOWNLOAD = "download"
+	// EVENT_UPLOAD rz waits for a file, the client answers it with Name and Size of the file or EVENT_CANCEL
+	EVENT_UPLOAD = "upload"
+	// EVENT_DATA content of the file in base64
+	EVENT_DATA = "data"
+	// EVENT_END the file ends, the client sends it once all data is sent
+	EVENT_END = "end"
+	// EVENT_SKIP rz skips the file
+	EVENT_SKIP = "skip"
+	// EVENT_CANCEL the client cancels the transfer
+	EVENT_CANCEL = "cancel"
+	// EVENT_ERROR the transfer fails with Err
+	EVENT_ERROR = "error"
+	// EVENT_FINISH the terminal is back to the shell
+	EVENT_FINISH = "finish"
+)
+
+const (
+	// osc is a private osc code, terminals ignore it while the web client registers a handler for it
+	osc = 5380
+	// chunkSize of data subpackets sent to rz
+	chunkSize = 1024
+	// inputTimeout aborts uploads if the client sends nothing in it
+	inputTimeout = time.Minute
+)
+
+var (
+	errCanceledByUser = errors.New("canceled by the user")
+	// zsig is how hex headers of sz and rz start, the frame type after it is ZRQINIT or ZRINIT
+	zsig = []byte{ZPAD, ZPAD, ZDLE, ZHEX, '0'}
+)
+
+// Event is sent to web terminals in the private osc and the answers of them are in the same form
+type Event struct {
+	Event string `json:"event"`
+	Name  string `json:"name,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+type upload struct {
+	in   chan *Event
+	done chan struct{}
+}
+
+func (u *upload) next(ctx context.Context) (*Event, error) {
+	select {
+	case ev := <-u.in:
+		return ev, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(inputTimeout):
+		return nil, fmt.Errorf("no input from the client in %s", inputTimeout)
+	}
+}
+
+// Zmodem transfers files between web terminals and rz or sz on targets, output of the session is taken over
+// from the start sequence until the transfer finishes. Files are streamed through events and never saved
+//
+//	https://gallium.inria.fr/~doligez/zmodem/zmodem.txt
+type Zmodem struct {
+	// Out is events for the web terminal
+	Out chan []byte
+	// OnEnd is called once a file is transferred with the sha256 of its content
+	OnEnd func(upload bool, filename string, size int64, sum string)
+
+	ctx      context.Context
+	w        io.Writer
+	busy     atomic.Bool
+	canceled atomic.Bool
+	upload   atomic.Pointer[upload]
+}
+
+// New returns a zmodem writing to the stdin of the session
+func New(ctx context.Context, w io.Writer, onEnd func(upload bool, filename string, size int64, sum string)) *Zmodem {
+	return &Zmodem{
+		Out:   make(chan []byte, 16),
+		OnEnd: onEnd,
+		ctx:   ctx,
+		w:     w,
+	}
+}
+
+// Detect tells whether output is the start of zmodem, the reader is at the first ZPAD. Only buffered bytes are looked
+// into so that it never blocks
+func Detect(r *bufio.Reader) bool {
+	bs, _ := r.Peek(min(r.Buffered(), len(zsig)+1))
+	return Index(bs) == 0
+}
+
+// Index returns where the start of zmodem is in output, or -1 if it is not there
+func Index(p []byte) int {
+	for off := 0; ; {
+		i := bytes.Index(p[off:], zsig)
+		if i < 0 {
+			return -1
+		}
+		if i += off; i+len(zsig) < len(p) && (p[i+len(zsig)] == '0' || p[i+len(zsig)] == '1') {
+			return i
+		}
+		off = i + 1
+	}
+}
+
+// Busy tells whether a transfer is going on, keys of the terminal are dropped then
+func (z *Zmodem) Busy() bool {
+	return z != nil && z.busy.Load()
+}
+
+// Input takes events of the web terminal, files uploaded are taken only while rz waits for them
+func (z *Zmodem) Input(msg []byte) {
+	ev := &Event{}
+	if json.Unmarshal(msg, ev) != nil {
+		return
+	}
+	if ev.Event == EVENT_CANCEL {
+		z.canceled.Store(true)
+	}
+	u := z.upload.Load()
+	if u == nil {
+		return
+	}
+	select {
+	case u.in <- ev:
+	case <-u.done:
+	}
+}
+
+// Serve runs the transfer after Detect, rest is output skipped which is not of zmodem
+func (z *Zmodem) Serve(r *bufio.Reader) (rest []byte, err error) {
+	z.busy.Store(true)
+	z.canceled.Store(false)
+	defer z.busy.Store(false)
+
+	zr := &reader{r: r}
+	defer func() {
+		rest = zr.garbage
+		if err != nil {
+			z.write(abortSeq)
+			z.emit(&Event{Event: EVENT_ERROR, Err: err.Error()})
+		}
+		z.emit(&Event{Event: EVENT_FINISH})
+	}()
+
+	h, err := zr.readHeader()
+	if err != nil {
+		return
+	}
+	switch h.typ {
+	case ZRQINIT:
+		err = z.receive(zr)
+	case ZRINIT:
+		err = z.send(zr, h)
+	default:
+		err = fmt.Errorf("unexpected frame %d", h.typ)
+	}
+	return
+}
+
+// receive takes files from sz until it finishes
+func (z *Zmodem) receive(r *reader) (err error) {
+	zrinit := &header{typ: ZRINIT}
+	zrinit.p[3] = CANFDX | CANOVIO | CANFC32
+	if err = z.write(zrinit.hex()); err != nil {
+		return
+	}
+	for {
+		if z.canceled.Load() {
+			return errCanceledByUser
+		}
+		h, err := r.readHeader()
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case ZRQINIT:
+			err = z.write(zrinit.hex())
+		case ZSINIT:
+			if _, _, err = r.readSubpacket(h.crc32); err == nil {
+				err = z.write(newPosHeader(ZACK, 0).hex())
+			}
+		case ZFILE:
+			if err = z.receiveFile(r, h); err == nil {
+				err = z.write(zrinit.hex())
+			}
+		case ZFIN:
+			if err = z.write(newPosHeader(ZFIN, 0).hex()); err == nil {
+				r.skipOO()
+			}
+			return err
+		case ZCAN, ZABORT:
+			return errCanceled
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (z *Zmodem) receiveFile(r *reader, h *header) (err error) {
+	info, _, err := r.readSubpacket(h.crc32)
+	if err != nil {
+		return
+	}
+	name, size := parseInfo(info)
+	z.emit(&Event{Event: EVENT_DOWNLOAD, Name: name, Size: size})
+	if err = z.write(newPosHeader(ZRPOS, 0).hex()); err != nil {
+		return
+	}
+
+	sum, pos := sha256.New(), int64(0)
+	for {
+		if h, err = r.readHeader(); err != nil {
+			return
+		}
+		switch h.typ {
+		case ZDATA:
+			// data is never resent since ssh does not lose it, so positions must go on
+			if h.pos() != pos {
+				return fmt.Errorf("data at %d is not expected, %d is", h.pos(), pos)
+			}
+			if pos, err = z.receiveData(r, h, sum, pos); err != nil {
+				return
+			}
+		case ZEOF:
+			if h.pos() != pos {
+				return fmt.Errorf("file ends at %d while %d is received", h.pos(), pos)
+			}
+			z.emit(&Event{Event: EVENT_END, Name: name, Size: pos})
+			if z.OnEnd != nil {
+				z.OnEnd(false, name, pos, hex.EncodeToString(sum.Sum(nil)))
+			}
+			return
+		case ZFIN, ZCAN, ZABORT:
+			return errCanceled
+		}
+	}
+}
+
+// receiveData passes subpackets of the frame to the client till it ends
+func (z *Zmodem) receiveData(r *reader, h *header, sum hash.Hash, pos int64) (int64, error) {
+	for {
+		if z.canceled.Load() {
+			return pos, errCanceledByUser
+		}
+		data, end, err := r.readSubpacket(h.crc32)
+		if err != nil {
+			return pos, err
+		}
+		if len(data) > 0 {
+			sum.Write(data)
+			pos += int64(len(data))
+			z.emit(&Event{Event: EVENT_DATA, Data: data})
+		}
+		if end == ZCRCQ || end == ZCRCW {
+			if err = z.write(newPosHeader(ZACK, pos).hex()); err != nil {
+				return pos, err
+			}
+		}
+		if end == ZCRCE || end == ZCRCW {
+			return pos, nil
+		}
+	}
+}
+
+// send gives the file of the client to rz, windows of ZRINIT are waited for by ZCRCW
+func (z *Zmodem) send(r *reader, zrinit *header) (err error) {
+	u := &upload{in: make(chan *Event, 16), done: make(chan struct{})}
+	z.upload.Store(u)
+	defer func() {
+		z.upload.Store(nil)
+		close(u.done)
+	}()
+
+	z.emit(&Event{Event: EVENT_UPLOAD})
+	ev, err := u.next(z.ctx)
+	if err != nil {
+		return
+	}
+	if ev.Event != EVENT_UPLOAD || ev.Name == "" {
+		return errCanceledByUser
+	}
+	name := filepath.Base(ev.Name)
+	info := []byte(fmt.Sprintf("%s\x00%d %o %o 0 1 %d\x00", name, ev.Size, time.Now().Unix(), 0100644, ev.Size))
+
+	for tries := 0; ; tries++ {
+		if err = z.write(append(newPosHeader(ZFILE, 0).bin(), subpacket(info, ZCRCW)...)); err != nil {
+			return
+		}
+		h, err := r.readHeader()
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case ZRINIT:
+			if tries < 3 {
+				continue
+			}
+			return fmt.Errorf("file is not taken by rz")
+		case ZSKIP:
+			z.emit(&Event{Event: EVENT_SKIP, Name: name})
+			return z.finish(r)
+		case ZRPOS:
+			if h.pos() != 0 {
+				return fmt.Errorf("resuming is not supported")
+			}
+		default:
+			return errCanceled
+		}
+		break
+	}
+
+	window := int64(zrinit.p[0]) | int64(zrinit.p[1])<<8
+	if err = z.write(newPosHeader(ZDATA, 0).bin()); err != nil {
+		return
+	}
+	sum, pos, unacked := sha256.New(), int64(0), int64(0)
+	for {
+		if ev, err = u.next(z.ctx); err != nil {
+			return
+		}
+		switch ev.Event {
+		case EVENT_DATA:
+			for data := ev.Data; len(data) > 0; data = data[min(len(data), chunkSize):] {
+				chunk := data[:min(len(data), chunkSize)]
+				sum.Write(chunk)
+				pos, unacked = pos+int64(len(chunk)), unacked+int64(len(chunk))
+				if window == 0 || unacked < window {
+					if err = z.write(subpacket(chunk, ZCRCG)); err != nil {
+						return
+					}
+					continue
+				}
+				// the buffer of rz is full, the frame goes on once it is acked
+				if err = z.write(subpacket(chunk, ZCRCW)); err != nil {
+					return
+				}
+				h, err := r.readHeader()
+				if err != nil {
+					return err
+				}
+				if h.typ != ZACK {
+					return fmt.Errorf("unexpected frame %d while waiting for ack", h.typ)
+				}
+				unacked = 0
+				if err = z.write(newPosHeader(ZDATA, pos).bin()); err != nil {
+					return err
+				}
+			}
+		case EVENT_END:
+			if err = z.write(append(subpacket(nil, ZCRCE), newPosHeader(ZEOF, pos).bin()...)); err != nil {
+				return
+			}
+			h, err := r.readHeader()
+			if err != nil {
+				return err
+			}
+			if h.typ != ZRINIT {
+				return fmt.Errorf("unexpected frame %d after the file", h.typ)
+			}
+			z.emit(&Event{Event: EVENT_END, Name: name, Size: pos})
+			if z.OnEnd != nil {
+				z.OnEnd(true, name, pos, hex.EncodeToString(sum.Sum(nil)))
+			}
+			return z.finish(r)
+		default:
+			return errCanceledByUser
+		}
+	}
+}
+
+// finish ends the session of rz, rz exits once it gets over and out
+func (z *Zmodem) finish(r *reader) (err error) {
+	if err = z.write(newPosHeader(ZFIN, 0).hex()); err != nil {
+		return
+	}
+	h, err := r.readHeader()
+	if err != nil {
+		return
+	}
+	if h.typ != ZFIN {
+		return fmt.Errorf("unexpected frame %d while finishing", h.typ)
+	}
+	return z.write([]byte("OO"))
+}
+
+func (z *Zmodem) write(bs []byte) (err error) {
+	_, err = z.w.Write(bs)
+	return
+}
+
+func (z *Zmodem) emit(ev *Event) {
+	bs, _ := json.Marshal(ev)
+	select {
+	case z.Out <- []byte(fmt.Sprintf("\x1b]%d;%s\x07", osc, bs)):
+	case <-z.ctx.Done():
+	}
+}
+
+// skipOO drops the over and out sz sends after ZFIN
+func (r *reader) skipOO() {
+	for i := 0; i < 2; i++ {
+		if p, _ := r.r.Peek(1); len(p) == 0 || p[0] != 'O' {
+			return
+		}
+		r.r.ReadByte()
+	}
+}
+
+// parseInfo parses the name and the size of ZFILE, paths of names are dropped
+func parseInfo(info []byte) (name string, size int64) {
+	parts := bytes.SplitN(info, []byte{0}, 3)
+	name = filepath.Base(string(parts[0]))
+	if len(parts) > 1 {
+		if fields := strings.Fields(string(parts[1])); len(fields) > 0 {
+			size = cast.ToInt64(fields[0])
+		}
+	}
+	return
+}